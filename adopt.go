@@ -0,0 +1,125 @@
+package cdsexec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// adoptPollInterval is the interval at which the exit of an adopted process that is not a
+// child of the caller is polled for where it cannot be waited for otherwise.
+const adoptPollInterval = 100 * time.Millisecond
+
+// AdoptProcess returns a Commander for the running process pid, which the caller did not
+// start, such as a daemon started by systemd or by a previous generation of an agent. It
+// fails with an error matching os.ErrProcessDone if there is no such process.
+//
+// The process is already started: Start, Run, Output, CombinedOutput and the pipes fail, and
+// the Set methods have no effect. Signal sends it signals, through the pidfd holding it where
+// there is one. Wait waits for it to exit. Only the exit status of a child of the caller,
+// e.g. one reparented to it as a subreaper, is known: Wait then returns an *exec.ExitError if
+// it failed and sets ProcessState, as it does for commands. The exit status of other
+// processes cannot be read: Wait returns nil once they are gone and ProcessState stays nil.
+//
+// On Linux, the process is held through a pidfd from the time it is adopted, so that Wait
+// does not confuse it with a process reusing its PID; elsewhere, and on kernels older than
+// 5.3, the exit of processes that are not children is polled for.
+func AdoptProcess(pid int) (Commander, error) {
+	if pid <= 0 {
+		return nil, fmt.Errorf("cdsexec: adopting process %d: invalid PID", pid)
+	}
+	a := &adoptedProcess{}
+	if err := a.open(pid); err != nil {
+		return nil, fmt.Errorf("cdsexec: adopting process %d: %w", pid, err)
+	}
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		a.close()
+		return nil, fmt.Errorf("cdsexec: adopting process %d: %w", pid, err)
+	}
+	a.proc = proc
+	return a, nil
+}
+
+// adoptedProcess is the Commander of a process started by someone else.
+type adoptedProcess struct {
+	proc *os.Process
+	// pidfd holds the process on Linux, nil elsewhere or if the kernel has no pidfd.
+	pidfd *os.File
+
+	mu     sync.Mutex
+	waited bool
+	state  *os.ProcessState
+}
+
+var _ Commander = (*adoptedProcess)(nil)
+
+func (a *adoptedProcess) Start() error                       { return errAlreadyStarted }
+func (a *adoptedProcess) Run() error                         { return errAlreadyStarted }
+func (a *adoptedProcess) Output() ([]byte, error)            { return nil, errAlreadyStarted }
+func (a *adoptedProcess) CombinedOutput() ([]byte, error)    { return nil, errAlreadyStarted }
+func (a *adoptedProcess) StdinPipe() (io.WriteCloser, error) { return nil, errAlreadyStarted }
+func (a *adoptedProcess) StdoutPipe() (io.ReadCloser, error) { return nil, errAlreadyStarted }
+func (a *adoptedProcess) StderrPipe() (io.ReadCloser, error) { return nil, errAlreadyStarted }
+func (a *adoptedProcess) SetDir(string)                      {}
+func (a *adoptedProcess) SetEnv([]string)                    {}
+func (a *adoptedProcess) SetStdin(io.Reader)                 {}
+func (a *adoptedProcess) SetStdout(io.Writer)                {}
+func (a *adoptedProcess) SetStderr(io.Writer)                {}
+func (a *adoptedProcess) Process() *os.Process               { return a.proc }
+
+func (a *adoptedProcess) ProcessState() *os.ProcessState {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.state
+}
+
+// Wait waits for the process to exit, reaping it if it is a child of the caller.
+func (a *adoptedProcess) Wait() error {
+	a.mu.Lock()
+	if a.waited {
+		a.mu.Unlock()
+		return errWaitCalled
+	}
+	a.waited = true
+	a.mu.Unlock()
+	defer a.close()
+
+	state, err := a.proc.Wait()
+	if errors.Is(err, syscall.ECHILD) {
+		return a.waitExit()
+	}
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.state = state
+	a.mu.Unlock()
+	if !state.Success() {
+		return &exec.ExitError{ProcessState: state}
+	}
+	return nil
+}
+
+// pollExit waits for the process to be gone by sending it signal 0 on an interval.
+func (a *adoptedProcess) pollExit() error {
+	t := time.NewTicker(adoptPollInterval)
+	defer t.Stop()
+	for {
+		if err := a.proc.Signal(syscall.Signal(0)); errors.Is(err, os.ErrProcessDone) {
+			return nil
+		}
+		<-t.C
+	}
+}
+
+func (a *adoptedProcess) close() {
+	if a.pidfd != nil {
+		a.pidfd.Close()
+	}
+}
@@ -0,0 +1,29 @@
+package cdsexec
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// open holds the process pid through a pidfd, failing with os.ErrProcessDone if there is
+// no such process. Without pidfd support, it only checks that the process exists.
+func (a *adoptedProcess) open(pid int) error {
+	f, err := openPidfd(pid)
+	if errors.Is(err, errors.ErrUnsupported) {
+		if err := syscall.Kill(pid, 0); errors.Is(err, syscall.ESRCH) {
+			return os.ErrProcessDone
+		}
+		return nil
+	}
+	a.pidfd = f
+	return err
+}
+
+// waitExit waits for a process that is not a child of the caller to exit.
+func (a *adoptedProcess) waitExit() error {
+	if a.pidfd == nil || waitPidfd(a.pidfd) != nil {
+		return a.pollExit()
+	}
+	return nil
+}
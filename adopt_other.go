@@ -0,0 +1,27 @@
+//go:build !linux
+
+package cdsexec
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// open checks that the process pid exists, failing with os.ErrProcessDone if it does not.
+func (a *adoptedProcess) open(pid int) error {
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	defer proc.Release()
+	if err := proc.Signal(syscall.Signal(0)); errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+	return nil
+}
+
+// waitExit waits for a process that is not a child of the caller to exit.
+func (a *adoptedProcess) waitExit() error {
+	return a.pollExit()
+}
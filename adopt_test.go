@@ -0,0 +1,117 @@
+package cdsexec_test
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// orphan starts a command in the background of a shell that exits at once, so that it is
+// not a child of the test, and returns its PID.
+func orphan(t *testing.T, command string) int {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+	out, err := exec.Command("sh", "-c", command+" >/dev/null 2>&1 & echo $!").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pid
+}
+
+func TestAdoptProcess(t *testing.T) {
+	pid := orphan(t, "sleep 30")
+	cmd, err := cdsexec.AdoptProcess(pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err == nil {
+		t.Error("Expected Start to fail for an adopted process")
+	}
+	if cmd.Process().Pid != pid {
+		t.Errorf("Expected process %d, got %d", pid, cmd.Process().Pid)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		t.Fatalf("Expected Wait to block while the process runs, got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err := cmd.Process().Kill(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected Wait to succeed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Wait to return once the process is killed")
+	}
+	if cmd.ProcessState() != nil {
+		t.Errorf("Expected no state for a process that is not a child, got %v", cmd.ProcessState())
+	}
+	if err := cmd.Wait(); err == nil {
+		t.Error("Expected a second Wait to fail")
+	}
+}
+
+func TestAdoptProcessExited(t *testing.T) {
+	cmd, err := cdsexec.AdoptProcess(orphan(t, "sleep 30"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cdsexec.Signal(cmd, syscall.SIGKILL); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected Wait to succeed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Wait to return for a process that already exited")
+	}
+}
+
+func TestAdoptChildProcess(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+	child := exec.Command("sh", "-c", "sleep 0.2; exit 3")
+	if err := child.Start(); err != nil {
+		t.Fatal(err)
+	}
+	cmd, err := cdsexec.AdoptProcess(child.Process.Pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cmd.Wait()
+	if code := cdsexec.ExitCode(err); code != 3 {
+		t.Errorf("Expected exit code 3 of a child, got %d (%v)", code, err)
+	}
+	if st := cmd.ProcessState(); st == nil || st.ExitCode() != 3 {
+		t.Errorf("Expected the state of a child, got %v", st)
+	}
+	if _, err := cdsexec.AdoptProcess(child.Process.Pid); !errors.Is(err, os.ErrProcessDone) {
+		t.Errorf("Expected adopting a process that is gone to fail, got %v", err)
+	}
+}
@@ -0,0 +1,280 @@
+package auditcmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// Record is one audited execution.
+type Record struct {
+	Time     time.Time     `json:"time"`
+	Host     string        `json:"host,omitempty"`
+	UID      int           `json:"uid"`
+	Actor    string        `json:"actor,omitempty"`
+	Command  string        `json:"command"`
+	Name     string        `json:"name"`
+	Args     []string      `json:"args,omitempty"`
+	Dir      string        `json:"dir,omitempty"`
+	Call     string        `json:"call"`
+	ExitCode int           `json:"exit_code"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+	// StdoutSHA256 and StderrSHA256 are hex digests of the output, when it could be observed.
+	StdoutSHA256 string `json:"stdout_sha256,omitempty"`
+	StdoutBytes  int64  `json:"stdout_bytes"`
+	StderrSHA256 string `json:"stderr_sha256,omitempty"`
+	StderrBytes  int64  `json:"stderr_bytes"`
+	// Env and Stdin are the environment and standard input of the command, recorded with
+	// WithReplayData. StdinTruncated is set when the standard input was not recorded up to
+	// its end: it was larger than the recorded part, the command exited before reading all
+	// of it, or it was a file, which is not recorded.
+	Env            []string `json:"env,omitempty"`
+	Stdin          []byte   `json:"stdin,omitempty"`
+	StdinTruncated bool     `json:"stdin_truncated,omitempty"`
+	// Redacted is set when the Redactor masked part of the command or of the replay data.
+	Redacted bool `json:"redacted,omitempty"`
+	// Seq, Prev, KeyID and Signature chain the records signed with WithSigning: Seq numbers
+	// the record in the chain, Prev is the signature of the previous record, and Signature
+	// is the hex signature of the record by the key KeyID.
+	Seq       uint64 `json:"seq,omitempty"`
+	Prev      string `json:"prev,omitempty"`
+	KeyID     string `json:"key_id,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// ErrNotReplayable is returned by Replay for records that do not hold the command exactly.
+var ErrNotReplayable = errors.New("auditcmd: record cannot be replayed")
+
+// Replay returns the cdsexec.Record to pass to cdsexec.Reproduce to run the command of r
+// again. It fails with ErrNotReplayable if r was written without WithReplayData, or if
+// part of it was redacted or truncated, as the command would then not be the same.
+func (r Record) Replay() (cdsexec.Record, error) {
+	switch {
+	case r.Env == nil:
+		return cdsexec.Record{}, fmt.Errorf("%w: no replay data", ErrNotReplayable)
+	case r.Redacted:
+		return cdsexec.Record{}, fmt.Errorf("%w: redacted", ErrNotReplayable)
+	case r.StdinTruncated:
+		return cdsexec.Record{}, fmt.Errorf("%w: standard input truncated", ErrNotReplayable)
+	}
+	return cdsexec.Record{Name: r.Name, Args: r.Args, Dir: r.Dir, Env: r.Env, Stdin: r.Stdin}, nil
+}
+
+// Sink stores audit records.
+type Sink interface {
+	Write(Record) error
+}
+
+// SinkFunc adapts a function to a Sink.
+type SinkFunc func(Record) error
+
+// Write calls f(r).
+func (f SinkFunc) Write(r Record) error { return f(r) }
+
+// MultiSink writes every record to all sinks, returning the first error.
+func MultiSink(sinks ...Sink) Sink {
+	return SinkFunc(func(r Record) error {
+		var first error
+		for _, s := range sinks {
+			if err := s.Write(r); err != nil && first == nil {
+				first = err
+			}
+		}
+		return first
+	})
+}
+
+type actorKey struct{}
+
+// WithActor returns a context attributing the commands run with it to actor, e.g. the
+// authenticated API user.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor carried by ctx, or "".
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// Option configures the audit wrapper.
+type Option func(*config)
+
+type config struct {
+	onError  func(Record, error)
+	replay   bool
+	maxStdin int
+	// chain signs the records, continuing after resume if set.
+	chain  *chain
+	resume *Record
+}
+
+// WithErrorHandler sets a function called when a record cannot be written to the sink.
+func WithErrorHandler(fn func(Record, error)) Option {
+	return func(c *config) { c.onError = fn }
+}
+
+// WithReplayData records the environment of every command, and up to maxStdin bytes of its
+// standard input, so that the command can be run again with Record.Replay. They are
+// redacted like the command line, but secrets passed in the standard input are only masked
+// if a cdsexec.RedactPattern rule matches them: enable it where records are kept safe. A
+// standard input that is an *os.File, e.g. a terminal, is passed to the command as is and
+// not recorded, as reading it through this process would make Wait wait for its end.
+func WithReplayData(maxStdin int) Option {
+	return func(c *config) {
+		c.replay = true
+		c.maxStdin = maxStdin
+	}
+}
+
+// New returns a CommandConstructor that writes a Record to sink for every command built by
+// base once it has finished. Command lines and errors are redacted by the cdsexec.Redactor
+// found in the command's context.
+func New(base cdsexec.CommandConstructor, sink Sink, opts ...Option) cdsexec.CommandConstructor {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	write := sink.Write
+	if cfg.chain != nil {
+		if cfg.resume != nil {
+			cfg.chain.seq, cfg.chain.prev = cfg.resume.Seq, cfg.resume.Signature
+		}
+		write = func(r Record) error { return cfg.chain.sign(r, sink.Write) }
+	}
+	host, _ := os.Hostname()
+
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		stdout, stderr := newDigest(), newDigest()
+		teeOut := inv.TeeStdout(stdout)
+		teeErr := inv.TeeStderr(stderr)
+		var stdin *capture
+		if cfg.replay && inv.Spec.Stdin != nil {
+			stdin = &capture{max: cfg.maxStdin}
+			if _, ok := inv.Spec.Stdin.(*os.File); !ok {
+				inv.Spec.Stdin = &captureReader{r: inv.Spec.Stdin, c: stdin}
+			}
+		}
+
+		clock := cdsexec.ClockFromContext(inv.Ctx)
+		now := clock.Now()
+		err := next(inv)
+		res := inv.Result(err)
+
+		redactor := cdsexec.RedactorFromContext(inv.Ctx)
+		spec := redactor.Spec(inv.Spec)
+		rec := Record{
+			Time:     now,
+			Host:     host,
+			UID:      os.Getuid(),
+			Actor:    ActorFromContext(inv.Ctx),
+			Command:  spec.String(),
+			Name:     spec.Name,
+			Args:     spec.Args,
+			Dir:      spec.Dir,
+			Call:     inv.Kind.String(),
+			ExitCode: res.ExitCode,
+			Duration: clock.Now().Sub(now),
+		}
+		if err != nil {
+			rec.Error = redactor.Error(err).Error()
+		}
+		rec.Redacted = spec.Name != inv.Spec.Name || !slices.Equal(spec.Args, inv.Spec.Args)
+		if cfg.replay {
+			env := inv.Spec.Env
+			if env == nil {
+				env = os.Environ()
+			}
+			rec.Env = redactor.Env(env)
+			rec.Redacted = rec.Redacted || !slices.Equal(rec.Env, env)
+			if stdin != nil {
+				masked := redactor.Text(string(stdin.buf))
+				rec.Stdin, rec.StdinTruncated = []byte(masked), stdin.truncated || !stdin.eof
+				rec.Redacted = rec.Redacted || masked != string(stdin.buf)
+			}
+		}
+		if !teeOut && res.Stdout != nil {
+			stdout.Write(res.Stdout)
+			teeOut = true
+		}
+		if !teeErr && res.Stderr != nil {
+			stderr.Write(res.Stderr)
+			teeErr = true
+		}
+		if teeOut {
+			rec.StdoutSHA256, rec.StdoutBytes = stdout.sum()
+		}
+		if teeErr {
+			rec.StderrSHA256, rec.StderrBytes = stderr.sum()
+		}
+
+		if werr := write(rec); werr != nil && cfg.onError != nil {
+			cfg.onError(rec, werr)
+		}
+		return err
+	})
+}
+
+// capture keeps the first max bytes written to it.
+type capture struct {
+	max       int
+	buf       []byte
+	truncated bool
+	// eof is set by captureReader once the whole input was read.
+	eof bool
+}
+
+func (c *capture) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := c.max - len(c.buf); room < n {
+		c.truncated = true
+		p = p[:max(room, 0)]
+	}
+	c.buf = append(c.buf, p...)
+	return n, nil
+}
+
+// captureReader writes what is read from r to c.
+type captureReader struct {
+	r io.Reader
+	c *capture
+}
+
+func (r *captureReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.c.Write(p[:n])
+	if err == io.EOF {
+		r.c.eof = true
+	}
+	return n, err
+}
+
+// digest hashes and counts everything written to it.
+type digest struct {
+	h hash.Hash
+	n int64
+}
+
+func newDigest() *digest {
+	return &digest{h: sha256.New()}
+}
+
+func (d *digest) Write(p []byte) (int, error) {
+	d.n += int64(len(p))
+	return d.h.Write(p)
+}
+
+func (d *digest) sum() (string, int64) {
+	return hex.EncodeToString(d.h.Sum(nil)), d.n
+}
@@ -0,0 +1,186 @@
+package auditcmd_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/auditcmd"
+)
+
+func sha(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestAuditRecords(t *testing.T) {
+	var records []auditcmd.Record
+	sink := auditcmd.SinkFunc(func(r auditcmd.Record) error {
+		records = append(records, r)
+		return nil
+	})
+	r := cdsexec.NewRedactor(cdsexec.RedactFlags("--secret"))
+	ctor := cdsexec.Redact(auditcmd.New(cdsexec.CommandContext, sink), r)
+	ctx := auditcmd.WithActor(context.Background(), "alice")
+
+	if err := ctor(ctx, "sh", "-c", "printf out; printf err >&2", "--secret", "s3cr3t").Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := ctor(ctx, "sh", "-c", "printf abc; exit 3").Output(); err == nil {
+		t.Fatalf("Expected an error")
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+	first := records[0]
+	if first.Actor != "alice" || first.Call != "Run" || first.ExitCode != 0 || first.Error != "" {
+		t.Errorf("Unexpected first record %+v", first)
+	}
+	if strings.Contains(first.Command, "s3cr3t") || strings.Contains(strings.Join(first.Args, " "), "s3cr3t") {
+		t.Errorf("Expected secret to be redacted: %s", first.Command)
+	}
+	if first.StdoutSHA256 != sha("out") || first.StdoutBytes != 3 || first.StderrSHA256 != sha("err") {
+		t.Errorf("Unexpected output digests %+v", first)
+	}
+	if first.UID != os.Getuid() || first.Time.IsZero() || first.Duration <= 0 {
+		t.Errorf("Expected who/when to be recorded: %+v", first)
+	}
+
+	second := records[1]
+	if second.ExitCode != 3 || second.Error != "exit status 3" || second.StdoutSHA256 != sha("abc") {
+		t.Errorf("Unexpected second record %+v", second)
+	}
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := auditcmd.NewFileSink(path, auditcmd.FileOptions{MaxBytes: 200, MaxBackups: 2})
+	if err != nil {
+		t.Fatalf("NewFileSink: %v", err)
+	}
+	defer sink.Close()
+
+	for i := 0; i < 10; i++ {
+		if err := sink.Write(auditcmd.Record{Command: "lsblk -J", Name: "lsblk", Call: "Output"}); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+
+	for _, p := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatalf("Expected %s to exist: %v", p, err)
+		}
+		if info.Size() > 200 {
+			t.Errorf("Expected %s to be rotated at 200 bytes, got %d", p, info.Size())
+		}
+		if info.Mode().Perm() != 0o600 {
+			t.Errorf("Expected mode 0600, got %v", info.Mode().Perm())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected only 2 backups to be kept")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r auditcmd.Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil || r.Name != "lsblk" {
+			t.Errorf("Unexpected line %q: %v", scanner.Text(), err)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	var records []auditcmd.Record
+	sink := auditcmd.SinkFunc(func(r auditcmd.Record) error {
+		records = append(records, r)
+		return nil
+	})
+	r := cdsexec.NewRedactor(cdsexec.RedactFlags("--secret"))
+	ctor := cdsexec.Redact(auditcmd.New(cdsexec.CommandContext, sink, auditcmd.WithReplayData(8)), r)
+
+	cmd := ctor(context.Background(), "sh", "-c", `read v; echo "$v $POOL"; exit 2`)
+	cmd.SetEnv(cdsexec.MergeEnv(nil, "POOL=tank"))
+	cmd.SetStdin(strings.NewReader("grow\n"))
+	cmd.Run()
+	cmd = ctor(context.Background(), "sh", "-c", "cat >/dev/null", "--secret", "s3cr3t")
+	cmd.SetStdin(strings.NewReader("a long payload"))
+	cmd.Run()
+
+	rec, err := records[0].Replay()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := cdsexec.Reproduce(context.Background(), cdsexec.CommandContext, rec)
+	if res.ExitCode != 2 || string(res.Stdout) != "grow tank\n" {
+		t.Errorf("Expected the command to be reproduced, got %q, %v", res.Stdout, err)
+	}
+
+	second := records[1]
+	if !second.Redacted || !second.StdinTruncated || string(second.Stdin) != "a long p" {
+		t.Errorf("Unexpected record %+v", second)
+	}
+	if _, err := second.Replay(); !errors.Is(err, auditcmd.ErrNotReplayable) {
+		t.Errorf("Expected ErrNotReplayable, got %v", err)
+	}
+	if _, err := (auditcmd.Record{Name: "true"}).Replay(); !errors.Is(err, auditcmd.ErrNotReplayable) {
+		t.Errorf("Expected records without replay data to be refused, got %v", err)
+	}
+}
+
+func TestReplayPartialStdin(t *testing.T) {
+	var records []auditcmd.Record
+	sink := auditcmd.SinkFunc(func(r auditcmd.Record) error {
+		records = append(records, r)
+		return nil
+	})
+	ctor := auditcmd.New(cdsexec.CommandContext, sink, auditcmd.WithReplayData(4<<20))
+
+	cmd := ctor(context.Background(), "head", "-c", "1")
+	cmd.SetStdin(strings.NewReader(strings.Repeat("x", 2<<20)))
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "input")
+	if err := os.WriteFile(path, []byte("data"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cmd = ctor(context.Background(), "cat")
+	cmd.SetStdin(f)
+	out, err := cmd.Output()
+	if err != nil || string(out) != "data" {
+		t.Fatalf("Expected the file to be passed to the command, got %q, %v", out, err)
+	}
+
+	for _, rec := range records {
+		if !rec.StdinTruncated {
+			t.Errorf("Expected the standard input of %s to be flagged as truncated", rec.Command)
+		}
+		if _, err := rec.Replay(); !errors.Is(err, auditcmd.ErrNotReplayable) {
+			t.Errorf("Expected ErrNotReplayable for %s, got %v", rec.Command, err)
+		}
+	}
+	if len(records[1].Stdin) != 0 {
+		t.Errorf("Expected a file not to be recorded, got %q", records[1].Stdin)
+	}
+}
@@ -0,0 +1,247 @@
+package auditcmd
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+var (
+	// ErrBadSignature matches the errors of records whose signature does not verify.
+	ErrBadSignature = errors.New("auditcmd: invalid record signature")
+	// ErrBrokenChain matches the errors of records missing from a chain, or out of order.
+	ErrBrokenChain = errors.New("auditcmd: broken record chain")
+)
+
+// Key signs records, and verifies their signatures.
+type Key interface {
+	// ID names the key in the records it signs, so that keys can be rotated.
+	ID() string
+	Sign(payload []byte) ([]byte, error)
+	// Verify returns an error if sig is not a signature of payload.
+	Verify(payload, sig []byte) error
+}
+
+// HMACKey returns a Key signing records with HMAC-SHA256 under secret. Anyone able to verify
+// the records can also sign them: keep secret away from the host the records are written
+// on, e.g. in a TPM or a key service, or use SignerKey.
+func HMACKey(id string, secret []byte) Key {
+	return hmacKey{id: id, secret: secret}
+}
+
+type hmacKey struct {
+	id     string
+	secret []byte
+}
+
+func (k hmacKey) ID() string { return k.id }
+
+func (k hmacKey) Sign(payload []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write(payload)
+	return mac.Sum(nil), nil
+}
+
+func (k hmacKey) Verify(payload, sig []byte) error {
+	want, _ := k.Sign(payload)
+	if !hmac.Equal(sig, want) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// SignerKey returns a Key signing records with s, an Ed25519, ECDSA or RSA private key or a
+// signer backed by an HSM, and verifying them with its public key. Ed25519 signs records
+// as they are, the others their SHA-256 digest, RSA with PKCS #1 v1.5.
+func SignerKey(id string, s crypto.Signer) Key {
+	return signerKey{publicKey: publicKey{id: id, pub: s.Public()}, s: s}
+}
+
+// PublicKey returns a Key verifying the records signed by the SignerKey whose public key
+// is pub, for auditors who must not be able to sign records. Its Sign method fails.
+func PublicKey(id string, pub crypto.PublicKey) Key {
+	return publicKey{id: id, pub: pub}
+}
+
+type publicKey struct {
+	id  string
+	pub crypto.PublicKey
+}
+
+func (k publicKey) ID() string { return k.id }
+
+func (k publicKey) Sign([]byte) ([]byte, error) {
+	return nil, fmt.Errorf("auditcmd: key %s cannot sign", k.id)
+}
+
+func (k publicKey) Verify(payload, sig []byte) error {
+	digest := sha256.Sum256(payload)
+	var ok bool
+	switch pub := k.pub.(type) {
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(pub, payload, sig)
+	case *ecdsa.PublicKey:
+		ok = ecdsa.VerifyASN1(pub, digest[:], sig)
+	case *rsa.PublicKey:
+		ok = rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) == nil
+	default:
+		return fmt.Errorf("auditcmd: unsupported public key type %T", k.pub)
+	}
+	if !ok {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+type signerKey struct {
+	publicKey
+	s crypto.Signer
+}
+
+func (k signerKey) Sign(payload []byte) ([]byte, error) {
+	if _, ok := k.pub.(ed25519.PublicKey); ok {
+		return k.s.Sign(rand.Reader, payload, crypto.Hash(0))
+	}
+	digest := sha256.Sum256(payload)
+	return k.s.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// payload returns what the signature of r signs: its JSON encoding without the signature.
+func payload(r Record) ([]byte, error) {
+	r.Signature = ""
+	return json.Marshal(r)
+}
+
+// chain signs records in a sequence, each one covering the signature of the previous one.
+type chain struct {
+	key Key
+
+	mu   sync.Mutex
+	seq  uint64
+	prev string
+}
+
+// sign signs r as the next record of c, and passes it to write. The chain only moves on
+// if write succeeds, so that a record lost by the sink is not accounted for.
+func (c *chain) sign(r Record, write func(Record) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r.Seq, r.Prev, r.KeyID = c.seq+1, c.prev, c.key.ID()
+	p, err := payload(r)
+	if err != nil {
+		return err
+	}
+	sig, err := c.key.Sign(p)
+	if err != nil {
+		return fmt.Errorf("auditcmd: signing record: %w", err)
+	}
+	r.Signature = hex.EncodeToString(sig)
+	if err := write(r); err != nil {
+		return err
+	}
+	c.seq, c.prev = r.Seq, r.Signature
+	return nil
+}
+
+// WithSigning signs every record with k, in a chain: records are numbered by their Seq,
+// and each one carries the signature of the previous one in Prev, so that records that are
+// altered, removed, reordered or forged without k are detected by VerifyChain. The chain
+// starts anew with every constructor unless ResumeChain continues it.
+//
+// Records are signed and written one at a time, in the order of the chain, so that the
+// sink must not block for long.
+func WithSigning(k Key) Option {
+	return func(c *config) { c.chain = &chain{key: k} }
+}
+
+// ResumeChain continues the chain of WithSigning after last, the last record written by a
+// previous run of the program, e.g. the last one of its audit file read with ReadRecords,
+// so that the records of successive runs form a single chain.
+func ResumeChain(last Record) Option {
+	return func(c *config) { c.resume = &last }
+}
+
+// ChainError describes the first record of a chain that does not verify.
+type ChainError struct {
+	// Index is the index of the record in the records verified, and Seq its number.
+	Index int
+	Seq   uint64
+	// Err matches ErrBadSignature or ErrBrokenChain.
+	Err error
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("auditcmd: record %d (seq %d): %v", e.Index, e.Seq, e.Err)
+}
+
+func (e *ChainError) Unwrap() error { return e.Err }
+
+// VerifyChain verifies that records are a part of a chain written with WithSigning, signed
+// by one of keys and in order, and returns a *ChainError for the first one that is not.
+// The first record is trusted to be where the part starts; whether records are missing
+// at the end cannot be told from the records alone, but from their last Seq, e.g. as
+// reported elsewhere.
+func VerifyChain(records []Record, keys ...Key) error {
+	byID := make(map[string]Key, len(keys))
+	for _, k := range keys {
+		byID[k.ID()] = k
+	}
+	for i, r := range records {
+		fail := func(err error) error { return &ChainError{Index: i, Seq: r.Seq, Err: err} }
+		if r.Signature == "" {
+			return fail(fmt.Errorf("%w: record not signed", ErrBadSignature))
+		}
+		k, ok := byID[r.KeyID]
+		if !ok {
+			return fail(fmt.Errorf("%w: unknown key %q", ErrBadSignature, r.KeyID))
+		}
+		sig, err := hex.DecodeString(r.Signature)
+		if err != nil {
+			return fail(fmt.Errorf("%w: %v", ErrBadSignature, err))
+		}
+		p, err := payload(r)
+		if err != nil {
+			return fail(err)
+		}
+		if err := k.Verify(p, sig); err != nil {
+			return fail(err)
+		}
+		if i > 0 {
+			if prev := records[i-1]; r.Seq != prev.Seq+1 {
+				return fail(fmt.Errorf("%w: seq %d follows %d", ErrBrokenChain, r.Seq, prev.Seq))
+			} else if r.Prev != prev.Signature {
+				return fail(fmt.Errorf("%w: record does not follow the previous one", ErrBrokenChain))
+			}
+		}
+	}
+	return nil
+}
+
+// ReadRecords reads the records of a FileSink from r, one JSON record per line.
+func ReadRecords(r io.Reader) ([]Record, error) {
+	var records []Record
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 16<<20)
+	for n := 1; sc.Scan(); n++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return records, fmt.Errorf("auditcmd: line %d: %w", n, err)
+		}
+		records = append(records, rec)
+	}
+	return records, sc.Err()
+}
@@ -0,0 +1,119 @@
+package auditcmd_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/auditcmd"
+)
+
+// readFile returns the records of the audit file at path.
+func readFile(t *testing.T, path string) []auditcmd.Record {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := auditcmd.ReadRecords(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestSignedChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := auditcmd.NewFileSink(path, auditcmd.FileOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	key := auditcmd.HMACKey("k1", []byte("audit secret"))
+	ctor := auditcmd.New(cdsexec.CommandContext, sink, auditcmd.WithSigning(key))
+	for _, script := range []string{"exit 0", "echo data", "exit 4"} {
+		ctor(context.Background(), "sh", "-c", script).Run()
+	}
+
+	records := readFile(t, path)
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 records, got %d", len(records))
+	}
+	for i, r := range records {
+		if r.Seq != uint64(i+1) || r.KeyID != "k1" || r.Signature == "" || (i > 0) != (r.Prev != "") {
+			t.Errorf("Unexpected chain fields of record %d: %+v", i, r)
+		}
+	}
+	if err := auditcmd.VerifyChain(records, key); err != nil {
+		t.Fatalf("Expected the chain to verify, got %v", err)
+	}
+
+	// An altered record.
+	altered := append([]auditcmd.Record(nil), records...)
+	altered[2].ExitCode = 0
+	var ce *auditcmd.ChainError
+	if err := auditcmd.VerifyChain(altered, key); !errors.As(err, &ce) || ce.Index != 2 || !errors.Is(err, auditcmd.ErrBadSignature) {
+		t.Errorf("Expected the altered record to fail, got %v", err)
+	}
+	// A removed record.
+	removed := []auditcmd.Record{records[0], records[2]}
+	if err := auditcmd.VerifyChain(removed, key); !errors.As(err, &ce) || ce.Seq != 3 || !errors.Is(err, auditcmd.ErrBrokenChain) {
+		t.Errorf("Expected the removed record to break the chain, got %v", err)
+	}
+	// A record forged with another key.
+	if err := auditcmd.VerifyChain(records, auditcmd.HMACKey("k1", []byte("guess"))); !errors.Is(err, auditcmd.ErrBadSignature) {
+		t.Errorf("Expected a forged record to fail, got %v", err)
+	}
+
+	// The next run of the program continues the chain.
+	ctor = auditcmd.New(cdsexec.CommandContext, sink, auditcmd.WithSigning(key), auditcmd.ResumeChain(records[2]))
+	ctor(context.Background(), "true").Run()
+	records = readFile(t, path)
+	if err := auditcmd.VerifyChain(records, key); err != nil || len(records) != 4 || records[3].Seq != 4 {
+		t.Errorf("Expected the resumed chain to verify, got %d records, %v", len(records), err)
+	}
+}
+
+func TestSignerKeys(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		signer, verifier auditcmd.Key
+	}{
+		{auditcmd.SignerKey("ed", priv), auditcmd.PublicKey("ed", pub)},
+		{auditcmd.SignerKey("ec", ec), auditcmd.PublicKey("ec", &ec.PublicKey)},
+	} {
+		var records []auditcmd.Record
+		sink := auditcmd.SinkFunc(func(r auditcmd.Record) error {
+			records = append(records, r)
+			return nil
+		})
+		ctor := auditcmd.New(cdsexec.CommandContext, sink, auditcmd.WithSigning(tc.signer))
+		ctor(context.Background(), "true").Run()
+		ctor(context.Background(), "false").Run()
+		if err := auditcmd.VerifyChain(records, tc.verifier); err != nil {
+			t.Errorf("%s: Expected the chain to verify with the public key, got %v", tc.signer.ID(), err)
+		}
+		records[1].Args = []string{"forged"}
+		if err := auditcmd.VerifyChain(records, tc.verifier); !errors.Is(err, auditcmd.ErrBadSignature) {
+			t.Errorf("%s: Expected a forged record to fail, got %v", tc.signer.ID(), err)
+		}
+		if _, err := tc.verifier.Sign([]byte("record")); err == nil {
+			t.Errorf("%s: Expected a public key not to sign", tc.signer.ID())
+		}
+	}
+}
@@ -0,0 +1,39 @@
+//go:build !windows && !plan9
+
+package auditcmd
+
+import (
+	"encoding/json"
+	"log/syslog"
+)
+
+// SyslogSink writes records as JSON messages to syslog.
+type SyslogSink struct {
+	w *syslog.Writer
+}
+
+// NewSyslogSink connects to the local syslog daemon, logging with the given facility and tag.
+func NewSyslogSink(facility syslog.Priority, tag string) (*SyslogSink, error) {
+	w, err := syslog.New(facility|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogSink{w: w}, nil
+}
+
+// Write sends r to syslog, at warning level when the command failed.
+func (s *SyslogSink) Write(r Record) error {
+	msg, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if r.Error != "" {
+		return s.w.Warning(string(msg))
+	}
+	return s.w.Info(string(msg))
+}
+
+// Close closes the connection to syslog.
+func (s *SyslogSink) Close() error {
+	return s.w.Close()
+}
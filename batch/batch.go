@@ -0,0 +1,122 @@
+package batch
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// Step is a command of a batch with the command undoing it.
+type Step struct {
+	// Name describes the step in errors. Empty means the command line of Do.
+	Name string
+	// Do is the command of the step.
+	Do cdsexec.CommandSpec
+	// Undo compensates Do if a later step fails. Nil means the step needs no rollback.
+	Undo *cdsexec.CommandSpec
+}
+
+func (s Step) name() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return s.Do.String()
+}
+
+// Batch runs its steps one after the other, stopping at the first failure and rolling back
+// the steps completed before it.
+type Batch struct {
+	// Constructor constructs the commands. Nil means cdsexec.CommandContext.
+	Constructor cdsexec.CommandConstructor
+	Steps       []Step
+}
+
+// Add appends a step without rollback.
+func (b *Batch) Add(do cdsexec.CommandSpec) *Batch {
+	b.Steps = append(b.Steps, Step{Do: do})
+	return b
+}
+
+// AddWithUndo appends a step rolled back by undo.
+func (b *Batch) AddWithUndo(do, undo cdsexec.CommandSpec) *Batch {
+	b.Steps = append(b.Steps, Step{Do: do, Undo: &undo})
+	return b
+}
+
+// Run runs the steps in order. If a step fails, the Undo commands of the steps completed
+// before it run in reverse order, all of them even if some fail, and Run returns an
+// *Error. The rollback runs even if ctx was cancelled, with the values of ctx.
+func (b *Batch) Run(ctx context.Context) error {
+	ctor := b.Constructor
+	if ctor == nil {
+		ctor = cdsexec.CommandContext
+	}
+	for i, step := range b.Steps {
+		err := ctx.Err()
+		if err == nil {
+			err = step.Do.Command(ctx, ctor).Run()
+		}
+		if err == nil {
+			continue
+		}
+		e := &Error{Step: i, Name: step.name(), Err: err}
+		rctx := context.WithoutCancel(ctx)
+		for j := i - 1; j >= 0; j-- {
+			undo := b.Steps[j].Undo
+			if undo == nil {
+				continue
+			}
+			if err := undo.Command(rctx, ctor).Run(); err != nil {
+				e.RollbackErrs = append(e.RollbackErrs, &RollbackError{Step: j, Name: b.Steps[j].name(), Err: err})
+				continue
+			}
+			e.RolledBack++
+		}
+		return e
+	}
+	return nil
+}
+
+// Error reports the step a batch failed at and the outcome of the rollback.
+type Error struct {
+	// Step is the index of the failed step.
+	Step int
+	Name string
+	Err  error
+	// RolledBack is the number of steps successfully rolled back.
+	RolledBack int
+	// RollbackErrs holds a *RollbackError for every step whose rollback failed.
+	RollbackErrs []error
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "batch: step %d (%s): %v; rolled back %d steps", e.Step, e.Name, e.Err, e.RolledBack)
+	for _, err := range e.RollbackErrs {
+		b.WriteString("; ")
+		b.WriteString(err.Error())
+	}
+	return b.String()
+}
+
+// Unwrap returns the error of the failed step followed by the rollback errors.
+func (e *Error) Unwrap() []error {
+	return append([]error{e.Err}, e.RollbackErrs...)
+}
+
+// RollbackError reports a step whose rollback failed, leaving it in place.
+type RollbackError struct {
+	Step int
+	Name string
+	Err  error
+}
+
+func (e *RollbackError) Error() string {
+	return fmt.Sprintf("rollback of step %d (%s): %v", e.Step, e.Name, e.Err)
+}
+
+func (e *RollbackError) Unwrap() error {
+	return e.Err
+}
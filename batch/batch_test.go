@@ -0,0 +1,145 @@
+package batch_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/batch"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+// recorder is a mock constructor recording the commands and failing those in fail.
+type recorder struct {
+	mu   sync.Mutex
+	ran  []string
+	fail map[string]bool
+}
+
+func (r *recorder) ctor() cdsexec.CommandConstructor {
+	return mockcmd.MakeMockCmdWithOutput("", func(m *mockcmd.MockCmd) error {
+		line := strings.Join(append([]string{m.Name}, m.Args...), " ")
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.ran = append(r.ran, line)
+		if r.fail[line] {
+			return &cdsexec.ExitError{Code: 1}
+		}
+		return nil
+	})
+}
+
+func spec(name string, args ...string) cdsexec.CommandSpec {
+	return cdsexec.CommandSpec{Name: name, Args: args}
+}
+
+func provisioning(ctor cdsexec.CommandConstructor) *batch.Batch {
+	b := &batch.Batch{Constructor: ctor}
+	return b.AddWithUndo(spec("lvcreate", "-L1G", "vg0", "-n", "lun1"), spec("lvremove", "-f", "vg0/lun1")).
+		Add(spec("udevadm", "settle")).
+		AddWithUndo(spec("targetcli", "create", "lun1"), spec("targetcli", "delete", "lun1")).
+		AddWithUndo(spec("targetcli", "map", "lun1"), spec("targetcli", "unmap", "lun1"))
+}
+
+func TestBatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		fail       []string
+		expected   []string
+		failedStep int
+		rolledBack int
+	}{
+		{
+			name: "success",
+			expected: []string{
+				"lvcreate -L1G vg0 -n lun1", "udevadm settle", "targetcli create lun1", "targetcli map lun1",
+			},
+			failedStep: -1,
+		},
+		{
+			name: "rollback",
+			fail: []string{"targetcli map lun1"},
+			expected: []string{
+				"lvcreate -L1G vg0 -n lun1", "udevadm settle", "targetcli create lun1", "targetcli map lun1",
+				"targetcli delete lun1", "lvremove -f vg0/lun1",
+			},
+			failedStep: 3,
+			rolledBack: 2,
+		},
+		{
+			name:       "first step",
+			fail:       []string{"lvcreate -L1G vg0 -n lun1"},
+			expected:   []string{"lvcreate -L1G vg0 -n lun1"},
+			failedStep: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &recorder{fail: make(map[string]bool)}
+			for _, f := range tt.fail {
+				r.fail[f] = true
+			}
+			err := provisioning(r.ctor()).Run(context.Background())
+			if !reflect.DeepEqual(r.ran, tt.expected) {
+				t.Errorf("Expected %q, got %q", tt.expected, r.ran)
+			}
+			if tt.failedStep < 0 {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				return
+			}
+			var be *batch.Error
+			if !errors.As(err, &be) || be.Step != tt.failedStep || be.RolledBack != tt.rolledBack {
+				t.Errorf("Expected failure at step %d with %d rolled back, got %v", tt.failedStep, tt.rolledBack, err)
+			}
+			if cdsexec.ExitCode(err) != 1 {
+				t.Errorf("Expected the error of the step, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRollbackFailure(t *testing.T) {
+	r := &recorder{fail: map[string]bool{"targetcli map lun1": true, "targetcli delete lun1": true}}
+	err := provisioning(r.ctor()).Run(context.Background())
+
+	if r.ran[len(r.ran)-1] != "lvremove -f vg0/lun1" {
+		t.Errorf("Expected the rollback to continue past the failure, got %q", r.ran)
+	}
+	var re *batch.RollbackError
+	if !errors.As(err, &re) || re.Step != 2 {
+		t.Fatalf("Expected *RollbackError for step 2, got %v", err)
+	}
+	want := "batch: step 3 (targetcli map lun1): exit status 1; rolled back 1 steps; rollback of step 2 (targetcli create lun1): exit status 1"
+	if err.Error() != want {
+		t.Errorf("Expected %q, got %q", want, err.Error())
+	}
+}
+
+func TestRollbackAfterCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &recorder{}
+	ctor := r.ctor()
+	b := &batch.Batch{Constructor: func(c context.Context, name string, arg ...string) cdsexec.Commander {
+		if name == "udevadm" {
+			cancel()
+		}
+		return ctor(c, name, arg...)
+	}}
+	b.AddWithUndo(spec("lvcreate", "vg0/lun1"), spec("lvremove", "vg0/lun1")).
+		Add(spec("udevadm", "settle")).
+		Add(spec("targetcli", "create", "lun1"))
+
+	err := b.Run(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if want := []string{"lvcreate vg0/lun1", "udevadm settle", "lvremove vg0/lun1"}; !reflect.DeepEqual(r.ran, want) {
+		t.Errorf("Expected %q, got %q", want, r.ran)
+	}
+}
@@ -0,0 +1,141 @@
+package cdsexec_test
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+// layered returns the constructors benchmarked against base: base itself, one Wrap
+// layer, five Wrap layers and a Chain of five interceptors.
+func layered(base cdsexec.CommandConstructor) []struct {
+	name string
+	ctor cdsexec.CommandConstructor
+} {
+	wrap5 := base
+	for i := 0; i < 5; i++ {
+		wrap5 = cdsexec.Wrap(wrap5, passThrough)
+	}
+	return []struct {
+		name string
+		ctor cdsexec.CommandConstructor
+	}{
+		{"Base", base},
+		{"Wrap", cdsexec.Wrap(base, passThrough)},
+		{"Wrap5", wrap5},
+		{"Chain5", cdsexec.Chain(base, passThrough, passThrough, passThrough, passThrough, passThrough)},
+	}
+}
+
+// BenchmarkExec measures the cost of running a short command, dominated by spawning the
+// process, with os/exec directly and through the wrappers of the package.
+func BenchmarkExec(b *testing.B) {
+	b.Run("os/exec", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := exec.CommandContext(context.Background(), "true").Output(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	for _, l := range layered(cdsexec.CommandContext) {
+		b.Run(l.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := l.ctor(context.Background(), "true").Output(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkSpawn compares CommandContext with FastCommandContext for commands whose
+// streams are left unset, as for most short commands whose exit code is all that matters.
+func BenchmarkSpawn(b *testing.B) {
+	for _, c := range []struct {
+		name string
+		ctor cdsexec.CommandConstructor
+	}{
+		{"Default", cdsexec.CommandContext},
+		{"Fast", cdsexec.FastCommandContext},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := c.ctor(context.Background(), "true").Run(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(c.name+"Parallel", func(b *testing.B) {
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := c.ctor(context.Background(), "true").Run(); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkOutputAll compares running 100 short commands with a Group and with OutputAll.
+func BenchmarkOutputAll(b *testing.B) {
+	specs := make([]cdsexec.CommandSpec, 100)
+	for i := range specs {
+		specs[i] = cdsexec.CommandSpec{Name: "echo", Args: []string{"sda"}}
+	}
+	b.Run("Group", func(b *testing.B) {
+		b.ReportAllocs()
+		g := &cdsexec.Group{Parallelism: 8}
+		for i := 0; i < b.N; i++ {
+			if _, err := g.Run(context.Background(), specs...); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("OutputAll", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := cdsexec.OutputAll(context.Background(), nil, specs, 8); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkOverhead isolates the cost of the wrappers with a command that does not spawn
+// a process.
+func BenchmarkOverhead(b *testing.B) {
+	for _, l := range layered(mockcmd.MakeMockCmdWithOutput("ok", nil)) {
+		b.Run(l.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := l.ctor(context.Background(), "true").Output(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkOutputSize measures the allocations of collecting outputs of various sizes.
+func BenchmarkOutputSize(b *testing.B) {
+	ctor := cdsexec.Wrap(cdsexec.CommandContext, passThrough)
+	for _, size := range []string{"64", "65536", "1048576"} {
+		b.Run(size, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := ctor(context.Background(), "head", "-c", size, "/dev/zero").Output(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package breakercmd
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// ErrOpen is returned without executing a command while the breaker of its binary is open.
+var ErrOpen = errors.New("breakercmd: circuit open")
+
+// State is the state of the breaker of one binary.
+type State int
+
+const (
+	// Closed lets every command through.
+	Closed State = iota
+	// Open rejects every command with ErrOpen.
+	Open
+	// HalfOpen lets a single probe through to decide whether to close again.
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Config configures a Breaker.
+type Config struct {
+	// Threshold is the number of consecutive failures that opens the breaker. Zero means 5.
+	Threshold int
+	// Cooldown is how long the breaker stays open before a probe is let through, measured on
+	// the cdsexec.Clock of the context of the commands. Zero means 30s.
+	Cooldown time.Duration
+	// IsFailure decides whether an execution counts as a failure. Nil counts every error.
+	IsFailure func(cdsexec.Result, error) bool
+	// OnStateChange, if set, is called whenever the breaker of a binary changes state. It is
+	// called without the Breaker locked, so it may call its methods.
+	OnStateChange func(binary string, from, to State)
+}
+
+// Breaker keeps one circuit per binary.
+type Breaker struct {
+	cfg Config
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+	// changes holds the state changes to report once mu is unlocked.
+	changes []stateChange
+}
+
+type circuit struct {
+	state    State
+	failures int
+	openedAt time.Time
+	probing  bool
+	// generation counts the state changes, so that the outcome of a command allowed in an
+	// earlier state is ignored.
+	generation uint64
+}
+
+type stateChange struct {
+	binary   string
+	from, to State
+}
+
+// NewBreaker creates a Breaker from cfg.
+func NewBreaker(cfg Config) *Breaker {
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = 5
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = 30 * time.Second
+	}
+	return &Breaker{cfg: cfg, circuits: make(map[string]*circuit)}
+}
+
+// State returns the current state of the breaker of binary.
+func (b *Breaker) State(binary string) State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c, ok := b.circuits[binary]; ok {
+		return c.state
+	}
+	return Closed
+}
+
+// Wrap returns a CommandConstructor whose commands are rejected with ErrOpen while the
+// breaker of their binary is open.
+func (b *Breaker) Wrap(base cdsexec.CommandConstructor) cdsexec.CommandConstructor {
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		binary := filepath.Base(inv.Spec.Name)
+		clock := cdsexec.ClockFromContext(inv.Ctx)
+		gen, err := b.allow(binary, clock.Now())
+		if err != nil {
+			return err
+		}
+		err = next(inv)
+		failed := err != nil
+		if failed && b.cfg.IsFailure != nil {
+			failed = b.cfg.IsFailure(inv.Result(err), err)
+		}
+		b.record(binary, gen, failed, clock.Now())
+		return err
+	})
+}
+
+// New is a shorthand for NewBreaker followed by Wrap.
+func New(base cdsexec.CommandConstructor, cfg Config) cdsexec.CommandConstructor {
+	return NewBreaker(cfg).Wrap(base)
+}
+
+// allow decides whether a command of binary may execute at now, and returns the generation
+// of the circuit it executes in.
+func (b *Breaker) allow(binary string, now time.Time) (uint64, error) {
+	b.mu.Lock()
+	defer b.unlock()
+	c := b.circuitLocked(binary)
+	switch c.state {
+	case Open:
+		if now.Sub(c.openedAt) < b.cfg.Cooldown {
+			return 0, fmt.Errorf("%w for %s", ErrOpen, binary)
+		}
+		b.setStateLocked(binary, c, HalfOpen)
+		c.probing = true
+	case HalfOpen:
+		if c.probing {
+			return 0, fmt.Errorf("%w for %s: probe in progress", ErrOpen, binary)
+		}
+		c.probing = true
+	}
+	return c.generation, nil
+}
+
+// record updates the circuit of binary with the outcome of an execution allowed in
+// generation gen and ending at now.
+func (b *Breaker) record(binary string, gen uint64, failed bool, now time.Time) {
+	b.mu.Lock()
+	defer b.unlock()
+	c := b.circuitLocked(binary)
+	if gen != c.generation {
+		return
+	}
+	c.probing = false
+	if !failed {
+		c.failures = 0
+		b.setStateLocked(binary, c, Closed)
+		return
+	}
+	c.failures++
+	if c.state == HalfOpen || c.failures >= b.cfg.Threshold {
+		c.openedAt = now
+		b.setStateLocked(binary, c, Open)
+	}
+}
+
+func (b *Breaker) circuitLocked(binary string) *circuit {
+	c, ok := b.circuits[binary]
+	if !ok {
+		c = &circuit{}
+		b.circuits[binary] = c
+	}
+	return c
+}
+
+func (b *Breaker) setStateLocked(binary string, c *circuit, to State) {
+	from := c.state
+	if from == to {
+		return
+	}
+	c.state = to
+	c.generation++
+	if b.cfg.OnStateChange != nil {
+		b.changes = append(b.changes, stateChange{binary, from, to})
+	}
+}
+
+// unlock unlocks b and reports the state changes made while it was locked.
+func (b *Breaker) unlock() {
+	changes := b.changes
+	b.changes = nil
+	b.mu.Unlock()
+	for _, ch := range changes {
+		b.cfg.OnStateChange(ch.binary, ch.from, ch.to)
+	}
+}
@@ -0,0 +1,163 @@
+package breakercmd_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/breakercmd"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+func TestBreakerOpensAndRecovers(t *testing.T) {
+	healthy := false
+	calls := 0
+	base := func(ctx context.Context, name string, arg ...string) cdsexec.Commander {
+		calls++
+		if healthy {
+			return mockcmd.MakeMockCmdWithOutput("ok", nil)(ctx, name, arg...)
+		}
+		return mockcmd.MakeMockCmdWithOutputSpecificError("", context.DeadlineExceeded, nil)(ctx, name, arg...)
+	}
+	var transitions []string
+	b := breakercmd.NewBreaker(breakercmd.Config{
+		Threshold: 3,
+		Cooldown:  30 * time.Second,
+		OnStateChange: func(binary string, from, to breakercmd.State) {
+			transitions = append(transitions, binary+":"+from.String()+"->"+to.String())
+		},
+	})
+	ctor := b.Wrap(base)
+	clock := mockcmd.NewFakeClock(time.Now())
+	ctx := cdsexec.ContextWithClock(context.Background(), clock)
+
+	for i := 0; i < 3; i++ {
+		if err := ctor(ctx, "multipathd", "show", "paths").Run(); errors.Is(err, breakercmd.ErrOpen) {
+			t.Fatalf("Breaker opened too early")
+		}
+	}
+	if b.State("multipathd") != breakercmd.Open {
+		t.Fatalf("Expected breaker to be open, got %v", b.State("multipathd"))
+	}
+	if err := ctor(ctx, "multipathd").Run(); !errors.Is(err, breakercmd.ErrOpen) {
+		t.Errorf("Expected ErrOpen, got %v", err)
+	}
+	if err := ctor(ctx, "lsblk").Run(); errors.Is(err, breakercmd.ErrOpen) {
+		t.Errorf("Expected other binaries to be unaffected")
+	}
+	if calls != 4 {
+		t.Errorf("Expected 4 executions, got %d", calls)
+	}
+
+	clock.Advance(31 * time.Second)
+	if err := ctor(ctx, "multipathd").Run(); errors.Is(err, breakercmd.ErrOpen) {
+		t.Errorf("Expected a probe after the cooldown")
+	}
+	if b.State("multipathd") != breakercmd.Open {
+		t.Errorf("Expected failed probe to reopen the breaker")
+	}
+
+	healthy = true
+	clock.Advance(31 * time.Second)
+	if err := ctor(ctx, "multipathd").Run(); err != nil {
+		t.Errorf("Expected probe to succeed, got %v", err)
+	}
+	if b.State("multipathd") != breakercmd.Closed {
+		t.Errorf("Expected breaker to close after a successful probe")
+	}
+
+	want := []string{
+		"multipathd:closed->open",
+		"multipathd:open->half-open",
+		"multipathd:half-open->open",
+		"multipathd:open->half-open",
+		"multipathd:half-open->closed",
+	}
+	if len(transitions) != len(want) {
+		t.Fatalf("Expected transitions %v, got %v", want, transitions)
+	}
+	for i := range want {
+		if transitions[i] != want[i] {
+			t.Errorf("Transition %d: expected %s, got %s", i, want[i], transitions[i])
+		}
+	}
+}
+
+func TestBreakerIsFailure(t *testing.T) {
+	ctor := breakercmd.New(cdsexec.CommandContext, breakercmd.Config{
+		Threshold: 1,
+		IsFailure: func(r cdsexec.Result, err error) bool { return r.ExitCode != 1 },
+	})
+
+	for i := 0; i < 3; i++ {
+		if err := ctor(context.Background(), "sh", "-c", "exit 1").Run(); errors.Is(err, breakercmd.ErrOpen) {
+			t.Fatalf("Expected exit code 1 not to count as a failure")
+		}
+	}
+	ctor(context.Background(), "sh", "-c", "exit 2").Run()
+	if err := ctor(context.Background(), "sh").Run(); !errors.Is(err, breakercmd.ErrOpen) {
+		t.Errorf("Expected ErrOpen, got %v", err)
+	}
+}
+
+func TestBreakerStateChangeCallback(t *testing.T) {
+	var b *breakercmd.Breaker
+	var states []breakercmd.State
+	b = breakercmd.NewBreaker(breakercmd.Config{
+		Threshold: 1,
+		OnStateChange: func(binary string, from, to breakercmd.State) {
+			states = append(states, b.State(binary))
+		},
+	})
+	b.Wrap(mockcmd.MakeMockCmdWithOutputGenericError(nil))(context.Background(), "sgdisk").Run()
+	if len(states) != 1 || states[0] != breakercmd.Open {
+		t.Errorf("Expected the callback to see the breaker open, got %v", states)
+	}
+}
+
+func TestBreakerStaleOutcome(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	slow := mockcmd.MakeMockCmdWithOutput("ok", func(*mockcmd.MockCmd) error {
+		entered <- struct{}{}
+		<-release
+		return nil
+	})
+	failing := mockcmd.MakeMockCmdWithOutputGenericError(nil)
+	base := func(ctx context.Context, name string, arg ...string) cdsexec.Commander {
+		if len(arg) > 0 && arg[0] == "--slow" {
+			return slow(ctx, name, arg...)
+		}
+		return failing(ctx, name, arg...)
+	}
+	b := breakercmd.NewBreaker(breakercmd.Config{Threshold: 1, Cooldown: time.Minute})
+	ctor := b.Wrap(base)
+	clock := mockcmd.NewFakeClock(time.Now())
+	ctx := cdsexec.ContextWithClock(context.Background(), clock)
+
+	// A command allowed while the breaker is closed succeeds once it is half-open.
+	done := make(chan error)
+	go func() { done <- ctor(ctx, "iscsiadm", "--slow").Run() }()
+	<-entered
+	ctor(ctx, "iscsiadm").Run()
+	clock.Advance(2 * time.Minute)
+	probeDone := make(chan error)
+	go func() { probeDone <- ctor(ctx, "iscsiadm", "--slow").Run() }()
+	<-entered
+
+	release <- struct{}{}
+	if err := <-done; err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if b.State("iscsiadm") != breakercmd.HalfOpen {
+		t.Errorf("Expected the stale outcome to be ignored, got %v", b.State("iscsiadm"))
+	}
+	if err := ctor(ctx, "iscsiadm").Run(); !errors.Is(err, breakercmd.ErrOpen) {
+		t.Errorf("Expected ErrOpen while the probe runs, got %v", err)
+	}
+	release <- struct{}{}
+	if err := <-probeDone; err != nil || b.State("iscsiadm") != breakercmd.Closed {
+		t.Errorf("Expected the probe to close the breaker, got %v, %v", err, b.State("iscsiadm"))
+	}
+}
@@ -0,0 +1,136 @@
+package budgetcmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// ErrExhausted matches every error returned for a command refused because its budget is used up.
+var ErrExhausted = errors.New("budgetcmd: execution budget exhausted")
+
+// ExhaustedError reports the state of the exhausted budget.
+type ExhaustedError struct {
+	Limit time.Duration
+	Used  time.Duration
+	// Reserved is the budget reserved by the commands still running.
+	Reserved time.Duration
+}
+
+func (e *ExhaustedError) Error() string {
+	if e.Reserved > 0 {
+		return fmt.Sprintf("budgetcmd: execution budget of %s exhausted (%s used, %s reserved by running commands)", e.Limit, e.Used, e.Reserved)
+	}
+	return fmt.Sprintf("budgetcmd: execution budget of %s exhausted (%s used)", e.Limit, e.Used)
+}
+
+// Is reports whether target is ErrExhausted.
+func (e *ExhaustedError) Is(target error) bool {
+	return target == ErrExhausted
+}
+
+// Budget is the total wall time the commands sharing it may spend executing.
+type Budget struct {
+	limit time.Duration
+
+	mu       sync.Mutex
+	used     time.Duration
+	reserved time.Duration
+}
+
+// NewBudget returns a Budget of limit.
+func NewBudget(limit time.Duration) *Budget {
+	return &Budget{limit: limit}
+}
+
+// Limit returns the total budget.
+func (b *Budget) Limit() time.Duration {
+	return b.limit
+}
+
+// Used returns the wall time charged so far.
+func (b *Budget) Used() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// Remaining returns the wall time neither used nor reserved by running commands, which is
+// never negative.
+func (b *Budget) Remaining() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return max(b.limit-b.used-b.reserved, 0)
+}
+
+// reserve reserves what remains of the budget for a command, or returns an *ExhaustedError.
+func (b *Budget) reserve() (time.Duration, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	r := b.limit - b.used - b.reserved
+	if r <= 0 {
+		return 0, &ExhaustedError{Limit: b.limit, Used: b.used, Reserved: b.reserved}
+	}
+	b.reserved += r
+	return r, nil
+}
+
+// charge releases the reservation r of a command that ran for d.
+func (b *Budget) charge(r, d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.reserved -= r
+	b.used += d
+}
+
+type budgetKey struct{}
+
+// WithBudget returns a context carrying a new Budget of limit, shared by every command
+// executed with that context or one derived from it.
+func WithBudget(ctx context.Context, limit time.Duration) (context.Context, *Budget) {
+	b := NewBudget(limit)
+	return context.WithValue(ctx, budgetKey{}, b), b
+}
+
+// FromContext returns the Budget carried by ctx, or nil.
+func FromContext(ctx context.Context) *Budget {
+	b, _ := ctx.Value(budgetKey{}).(*Budget)
+	return b
+}
+
+// New returns a CommandConstructor that charges the wall time of every command built by
+// base, measured on the cdsexec.Clock of its context, to the Budget of that context.
+// Commands are refused with an *ExhaustedError once the budget is used up, and killed when
+// they would exceed what remains of it. A command reserves what remains of the budget while
+// it runs, so the commands sharing a Budget run one at a time: the others are refused until
+// it returns. Commands whose context carries no Budget are not limited.
+func New(base cdsexec.CommandConstructor) cdsexec.CommandConstructor {
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		b := FromContext(inv.Ctx)
+		if b == nil {
+			return next(inv)
+		}
+		remaining, err := b.reserve()
+		if err != nil {
+			return err
+		}
+
+		parent := inv.Ctx
+		ctx, cancel := cdsexec.ContextWithTimeout(parent, remaining)
+		defer cancel()
+		inv.Ctx = ctx
+		clock := cdsexec.ClockFromContext(parent)
+		start := clock.Now()
+		err = next(inv)
+		b.charge(remaining, clock.Now().Sub(start))
+		inv.Ctx = parent
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) && parent.Err() == nil {
+			return fmt.Errorf("%w: %w", &ExhaustedError{Limit: b.limit, Used: b.Used()}, err)
+		}
+		return err
+	})
+}
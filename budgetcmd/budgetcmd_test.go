@@ -0,0 +1,85 @@
+package budgetcmd_test
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/budgetcmd"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+func TestBudget(t *testing.T) {
+	clock := mockcmd.NewFakeClock(time.Unix(0, 0))
+	base := mockcmd.MakeMockCmdWithOutput("", func(*mockcmd.MockCmd) error {
+		clock.Advance(20 * time.Millisecond)
+		return nil
+	})
+	ctor := budgetcmd.New(base)
+	ctx, b := budgetcmd.WithBudget(cdsexec.ContextWithClock(context.Background(), clock), 50*time.Millisecond)
+
+	var err error
+	n := 0
+	for ; n < 10; n++ {
+		if err = ctor(ctx, "lsblk").Run(); err != nil {
+			break
+		}
+	}
+	var be *budgetcmd.ExhaustedError
+	if !errors.As(err, &be) || !errors.Is(err, budgetcmd.ErrExhausted) {
+		t.Fatalf("Expected *ExhaustedError, got %v", err)
+	}
+	if n != 3 {
+		t.Errorf("Expected 3 commands within the budget, got %d", n)
+	}
+	if be.Limit != 50*time.Millisecond || be.Used != 60*time.Millisecond || b.Remaining() != 0 {
+		t.Errorf("Unexpected budget state %v, remaining %s", be, b.Remaining())
+	}
+
+	if err := ctor(context.Background(), "lsblk").Run(); err != nil {
+		t.Errorf("Expected commands without a budget to be unaffected, got %v", err)
+	}
+}
+
+func TestBudgetKillsOverrunningCommand(t *testing.T) {
+	ctx, _ := budgetcmd.WithBudget(context.Background(), 50*time.Millisecond)
+	start := time.Now()
+	err := budgetcmd.New(cdsexec.CommandContext)(ctx, "sleep", "5").Run()
+	var ee *exec.ExitError
+	if !errors.Is(err, budgetcmd.ErrExhausted) || !errors.As(err, &ee) {
+		t.Errorf("Expected a budget error wrapping the exit error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the command to be killed, took %s", elapsed)
+	}
+}
+
+func TestBudgetReserved(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	base := mockcmd.MakeMockCmdWithOutput("", func(*mockcmd.MockCmd) error {
+		close(entered)
+		<-release
+		return nil
+	})
+	ctor := budgetcmd.New(base)
+	ctx, b := budgetcmd.WithBudget(context.Background(), time.Minute)
+
+	done := make(chan error)
+	go func() { done <- ctor(ctx, "fio").Run() }()
+	<-entered
+	err := ctor(ctx, "fio").Run()
+	var be *budgetcmd.ExhaustedError
+	if !errors.As(err, &be) || be.Reserved != time.Minute {
+		t.Errorf("Expected the running command to hold the budget, got %v", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if b.Remaining() <= 0 {
+		t.Errorf("Expected the unused reservation to be released, got %s remaining", b.Remaining())
+	}
+}
@@ -0,0 +1,146 @@
+package cachecmd
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// Config configures a Cache.
+type Config struct {
+	// TTL is how long a successful output is reused, measured on the cdsexec.Clock of the
+	// context of the command.
+	TTL time.Duration
+	// Cacheable selects the commands whose output is cached.
+	Cacheable cdsexec.Matcher
+	// InvalidateOn selects commands that clear the whole cache once they have run, e.g. writes
+	// that change what the cached commands report.
+	InvalidateOn cdsexec.Matcher
+	// OnInvalidate, if set, is called whenever the cache is cleared because of InvalidateOn.
+	OnInvalidate func(cdsexec.CommandSpec)
+}
+
+// Cache memoizes the output of successful read-only commands.
+type Cache struct {
+	cfg Config
+
+	mu      sync.Mutex
+	entries map[string]entry
+	// gen counts the invalidations, so that an output read before one is not cached.
+	gen    uint64
+	hits   int64
+	misses int64
+}
+
+type entry struct {
+	spec    cdsexec.CommandSpec
+	out     []byte
+	expires time.Time
+}
+
+// NewCache creates a Cache from cfg.
+func NewCache(cfg Config) *Cache {
+	return &Cache{cfg: cfg, entries: make(map[string]entry)}
+}
+
+// Invalidate drops every cached output.
+func (c *Cache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]entry)
+	c.gen++
+}
+
+// InvalidateMatching drops the cached outputs of the commands matched by m.
+func (c *Cache) InvalidateMatching(m cdsexec.Matcher) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, e := range c.entries {
+		if m(e.spec) {
+			delete(c.entries, key)
+		}
+	}
+	c.gen++
+}
+
+// Stats returns the number of cache hits and misses so far.
+func (c *Cache) Stats() (hits, misses int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+// Wrap returns a CommandConstructor whose cacheable Output and CombinedOutput calls are
+// answered from c while the cached output is fresh. An output is not cached if the cache
+// was invalidated while the command ran, as it may predate the change.
+func (c *Cache) Wrap(base cdsexec.CommandConstructor) cdsexec.CommandConstructor {
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		if c.cfg.InvalidateOn != nil && c.cfg.InvalidateOn(inv.Spec) {
+			defer c.invalidateAfter(inv.Spec)
+		}
+		if !c.cacheable(inv) {
+			return next(inv)
+		}
+
+		key := inv.Kind.String() + ":" + inv.Spec.Fingerprint()
+		now := cdsexec.ClockFromContext(inv.Ctx).Now()
+		c.mu.Lock()
+		if e, ok := c.entries[key]; ok && now.Before(e.expires) {
+			c.hits++
+			c.mu.Unlock()
+			inv.Output = append([]byte(nil), e.out...)
+			return nil
+		}
+		c.misses++
+		gen := c.gen
+		c.mu.Unlock()
+
+		if err := next(inv); err != nil {
+			return err
+		}
+		c.mu.Lock()
+		if c.gen != gen {
+			c.mu.Unlock()
+			return nil
+		}
+		c.pruneLocked(now)
+		c.entries[key] = entry{
+			spec:    inv.Spec.Clone(),
+			out:     append([]byte(nil), inv.Output...),
+			expires: now.Add(c.cfg.TTL),
+		}
+		c.mu.Unlock()
+		return nil
+	})
+}
+
+// New is a shorthand for NewCache followed by Wrap.
+func New(base cdsexec.CommandConstructor, cfg Config) cdsexec.CommandConstructor {
+	return NewCache(cfg).Wrap(base)
+}
+
+func (c *Cache) invalidateAfter(spec cdsexec.CommandSpec) {
+	c.Invalidate()
+	if c.cfg.OnInvalidate != nil {
+		c.cfg.OnInvalidate(spec)
+	}
+}
+
+func (c *Cache) cacheable(inv *cdsexec.Invocation) bool {
+	if inv.Kind != cdsexec.CallOutput && inv.Kind != cdsexec.CallCombinedOutput {
+		return false
+	}
+	if inv.Spec.Stdin != nil || inv.Spec.Stdout != nil || inv.Spec.Stderr != nil || !inv.Replayable() {
+		return false
+	}
+	return c.cfg.Cacheable != nil && c.cfg.Cacheable(inv.Spec)
+}
+
+func (c *Cache) pruneLocked(now time.Time) {
+	for key, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, key)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package cachecmd_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/cachecmd"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+// counting returns a mock constructor that counts executions per binary.
+func counting(counts map[string]int, err error) cdsexec.CommandConstructor {
+	return mockcmd.MakeMockCmdWithOutputSpecificError("pool: tank\nstate: ONLINE", err, func(m *mockcmd.MockCmd) error {
+		counts[m.Name]++
+		return nil
+	})
+}
+
+func TestCacheHitsAndExpiry(t *testing.T) {
+	counts := map[string]int{}
+	c := cachecmd.NewCache(cachecmd.Config{TTL: time.Minute, Cacheable: cdsexec.MatchCommand("zpool", "status")})
+	ctor := c.Wrap(counting(counts, nil))
+	clock := mockcmd.NewFakeClock(time.Unix(0, 0))
+	ctx := cdsexec.ContextWithClock(context.Background(), clock)
+
+	for i := 0; i < 3; i++ {
+		out, err := ctor(ctx, "zpool", "status").Output()
+		if err != nil || string(out) != "pool: tank\nstate: ONLINE" {
+			t.Fatalf("Unexpected result %q, %v", out, err)
+		}
+	}
+	if counts["zpool"] != 1 {
+		t.Errorf("Expected 1 execution, got %d", counts["zpool"])
+	}
+	if hits, misses := c.Stats(); hits != 2 || misses != 1 {
+		t.Errorf("Expected 2 hits and 1 miss, got %d and %d", hits, misses)
+	}
+
+	clock.Advance(time.Minute)
+	ctor(ctx, "zpool", "status").Output()
+	if counts["zpool"] != 2 {
+		t.Errorf("Expected expired entry to be refreshed, got %d executions", counts["zpool"])
+	}
+
+	ctor(context.Background(), "zpool", "list").Output()
+	ctor(context.Background(), "zpool", "list").Output()
+	if counts["zpool"] != 4 {
+		t.Errorf("Expected uncached commands to run every time, got %d executions", counts["zpool"])
+	}
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	counts := map[string]int{}
+	var invalidatedBy string
+	c := cachecmd.NewCache(cachecmd.Config{
+		TTL:          time.Hour,
+		Cacheable:    cdsexec.MatchCommand("zpool", "status"),
+		InvalidateOn: cdsexec.MatchBinary("zfs"),
+		OnInvalidate: func(s cdsexec.CommandSpec) { invalidatedBy = s.String() },
+	})
+	ctor := c.Wrap(counting(counts, nil))
+
+	ctor(context.Background(), "zpool", "status").Output()
+	ctor(context.Background(), "zfs", "create", "tank/vol").Run()
+	ctor(context.Background(), "zpool", "status").Output()
+	if counts["zpool"] != 2 {
+		t.Errorf("Expected write to invalidate the cache, got %d executions", counts["zpool"])
+	}
+	if invalidatedBy != "zfs create tank/vol" {
+		t.Errorf("Unexpected invalidation hook argument %q", invalidatedBy)
+	}
+
+	c.Invalidate()
+	ctor(context.Background(), "zpool", "status").Output()
+	if counts["zpool"] != 3 {
+		t.Errorf("Expected explicit invalidation, got %d executions", counts["zpool"])
+	}
+
+	c.InvalidateMatching(cdsexec.MatchBinary("zpool"))
+	ctor(context.Background(), "zpool", "status").Output()
+	if counts["zpool"] != 4 {
+		t.Errorf("Expected matching invalidation, got %d executions", counts["zpool"])
+	}
+}
+
+func TestCacheSkipsFailures(t *testing.T) {
+	counts := map[string]int{}
+	ctor := cachecmd.New(counting(counts, errors.New("pool is busy")), cachecmd.Config{TTL: time.Hour, Cacheable: cdsexec.MatchBinary("zpool")})
+
+	ctor(context.Background(), "zpool", "status").Output()
+	ctor(context.Background(), "zpool", "status").Output()
+	if counts["zpool"] != 2 {
+		t.Errorf("Expected failures not to be cached, got %d executions", counts["zpool"])
+	}
+}
+
+func TestCacheInvalidatedWhileRunning(t *testing.T) {
+	counts := map[string]int{}
+	started, release := make(chan struct{}), make(chan struct{})
+	base := mockcmd.MakeMockCmdWithOutput("size 10G", func(m *mockcmd.MockCmd) error {
+		counts[m.Name]++
+		if m.Name == "zpool" && counts[m.Name] == 1 {
+			close(started)
+			<-release
+		}
+		return nil
+	})
+	ctor := cachecmd.New(base, cachecmd.Config{
+		TTL:          time.Hour,
+		Cacheable:    cdsexec.MatchCommand("zpool", "list"),
+		InvalidateOn: cdsexec.MatchBinary("zfs"),
+	})
+
+	done := make(chan error)
+	go func() {
+		_, err := ctor(context.Background(), "zpool", "list").Output()
+		done <- err
+	}()
+	// The pool grows while its size is being read.
+	<-started
+	ctor(context.Background(), "zfs", "set", "volsize=20G", "tank/vol").Run()
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	ctor(context.Background(), "zpool", "list").Output()
+	if counts["zpool"] != 2 {
+		t.Errorf("Expected the output read before the write not to be cached, got %d executions", counts["zpool"])
+	}
+}
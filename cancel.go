@@ -0,0 +1,85 @@
+package cdsexec
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// DefaultCancelWaitDelay is how long a command stopped with a signal other than SIGKILL
+// gets to exit before it is killed, when no delay is given.
+const DefaultCancelWaitDelay = 10 * time.Second
+
+// CancelPolicy is how a command is stopped when its context is done.
+type CancelPolicy struct {
+	// Signal is sent to the command first. Nil sends os.Kill, as exec.CommandContext does.
+	Signal os.Signal
+	// WaitDelay is how long the command gets to exit after Signal before it is killed and
+	// its pipes are closed. Zero is DefaultCancelWaitDelay.
+	WaitDelay time.Duration
+}
+
+type cancelKey struct{}
+
+// ContextWithCancelPolicy returns a context whose commands are stopped as p describes when
+// the context is done.
+func ContextWithCancelPolicy(ctx context.Context, p CancelPolicy) context.Context {
+	return context.WithValue(ctx, cancelKey{}, p)
+}
+
+// CancelPolicyFromContext returns the CancelPolicy carried by ctx, and whether there is
+// one. Backends stopping commands themselves, such as mocks, honor it.
+func CancelPolicyFromContext(ctx context.Context) (CancelPolicy, bool) {
+	if ctx == nil {
+		return CancelPolicy{}, false
+	}
+	p, ok := ctx.Value(cancelKey{}).(CancelPolicy)
+	if ok && p.WaitDelay <= 0 {
+		p.WaitDelay = DefaultCancelWaitDelay
+	}
+	return p, ok
+}
+
+// WithCancelSignal returns a CommandConstructor whose commands built by base are sent sig
+// when their context is done, instead of being killed outright, and killed if they have not
+// exited waitDelay later, or DefaultCancelWaitDelay if waitDelay is zero. It gives commands
+// such as mdadm --grow the chance to leave things in a consistent state. The policy is
+// carried by the context the commands are built with, see CancelPolicyFromContext.
+//
+// On Windows, where signals cannot be sent to processes, the commands are killed.
+func WithCancelSignal(base CommandConstructor, sig os.Signal, waitDelay time.Duration) CommandConstructor {
+	p := CancelPolicy{Signal: sig, WaitDelay: waitDelay}
+	return Wrap(base, func(inv *Invocation, next Handler) error {
+		inv.Ctx = ContextWithCancelPolicy(inv.Ctx, p)
+		return next(inv)
+	})
+}
+
+// applyCancelPolicy makes c honor the CancelPolicy of ctx, if any.
+func (c *Cmd) applyCancelPolicy(ctx context.Context) {
+	p, ok := CancelPolicyFromContext(ctx)
+	if !ok || p.Signal == nil || p.Signal == os.Kill || runtime.GOOS == "windows" {
+		return
+	}
+	c.Cmd.Cancel = func() error { return c.signal(p.Signal) }
+	c.Cmd.WaitDelay = p.WaitDelay
+}
+
+// SignalName returns the name of sig as ExitError.Signal holds it, e.g. "TERM".
+func SignalName(sig os.Signal) string {
+	switch sig {
+	case os.Kill:
+		return "KILL"
+	case os.Interrupt:
+		return "INT"
+	case syscall.SIGTERM:
+		return "TERM"
+	case syscall.SIGHUP:
+		return "HUP"
+	case syscall.SIGQUIT:
+		return "QUIT"
+	}
+	return sig.String()
+}
@@ -0,0 +1,86 @@
+package cdsexec_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+// cancelAfterStart starts cmd, cancels its context once it has written its first line and
+// returns the result of Wait.
+func cancelAfterStart(t *testing.T, ctor cdsexec.CommandConstructor, script string) (string, error) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("signals cannot be sent on Windows")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cmd := ctor(ctx, "sh", "-c", script)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	n, _ := out.Read(buf)
+	cancel()
+	rest, _ := io.ReadAll(out)
+	return string(buf[:n]) + string(rest), cmd.Wait()
+}
+
+func TestCancelSignal(t *testing.T) {
+	ctor := cdsexec.WithCancelSignal(cdsexec.CommandContext, syscall.SIGTERM, 10*time.Second)
+	out, err := cancelAfterStart(t, ctor, `trap 'echo stopping; exit 3' TERM; echo ready; while :; do sleep 0.05; done`)
+	if cdsexec.ExitCode(err) != 3 || !errors.Is(err, cdsexec.ErrKilledByContext) {
+		t.Errorf("Expected the command to exit with 3 on SIGTERM, got %v", err)
+	}
+	if out != "ready\nstopping\n" {
+		t.Errorf("Expected the trap to run, got %q", out)
+	}
+}
+
+func TestCancelSignalWaitDelay(t *testing.T) {
+	ctor := cdsexec.WithCancelSignal(cdsexec.CommandContext, syscall.SIGTERM, 100*time.Millisecond)
+	start := time.Now()
+	_, err := cancelAfterStart(t, ctor, `trap '' TERM; echo ready; while :; do sleep 0.05; done`)
+	var ee interface{ ExitCode() int }
+	if !errors.As(err, &ee) || ee.ExitCode() != -1 || !errors.Is(err, cdsexec.ErrKilledByContext) {
+		t.Errorf("Expected the command to be killed, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("Expected the command to be killed after the wait delay, got %v", elapsed)
+	}
+}
+
+func TestCancelSignalMock(t *testing.T) {
+	var policy *cdsexec.CancelPolicy
+	base := mockcmd.MakeMockCmdWithOutput("", func(m *mockcmd.MockCmd) error {
+		policy = m.Cancel
+		return nil
+	})
+	ctor := cdsexec.WithCancelSignal(base, os.Interrupt, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := ctor(ctx, "mdadm", "--grow", "/dev/md0").Run(); err != nil {
+		t.Fatal(err)
+	}
+	if policy == nil || policy.Signal != os.Interrupt || policy.WaitDelay != cdsexec.DefaultCancelWaitDelay {
+		t.Errorf("Expected the mock to see the cancel policy, got %+v", policy)
+	}
+
+	cancel()
+	err := ctor(ctx, "mdadm", "--grow", "/dev/md0").Run()
+	var ee *cdsexec.ExitError
+	if !errors.As(err, &ee) || ee.Signal != "INT" || !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the mock to be interrupted, got %v", err)
+	}
+}
@@ -0,0 +1,225 @@
+package chrootcmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// Mounter creates and removes the bind mounts of a chroot.
+type Mounter interface {
+	// Bind bind-mounts source, recursively, onto target.
+	Bind(source, target string) error
+	// Unmount detaches the mount at target.
+	Unmount(target string) error
+}
+
+// Options configures the chroot environment.
+type Options struct {
+	// Root is the root directory of the image.
+	Root string
+	// BindMounts are host directories mounted at the same path inside Root. Nil means
+	// /dev, /proc and /sys.
+	BindMounts []string
+	// KeepResolvConf leaves the image's /etc/resolv.conf alone instead of replacing it with
+	// the host's for the duration of the command.
+	KeepResolvConf bool
+	// Mounter performs the mounts. Nil uses bind mounts of the running kernel.
+	Mounter Mounter
+}
+
+func (o Options) bindMounts() []string {
+	if o.BindMounts == nil {
+		return []string{"/dev", "/proc", "/sys"}
+	}
+	return o.BindMounts
+}
+
+// hostResolvConf is the file copied into the image.
+var hostResolvConf = "/etc/resolv.conf"
+
+// New returns a CommandConstructor that runs every command built by base inside the
+// chroot at opts.Root through chroot(8). The environment is prepared before the command
+// starts and torn down once it has finished, even if it fails; errors tearing it down are
+// joined to the error of the command. Commands running concurrently in the same root share
+// one environment, torn down after the last of them.
+//
+// The image is not trusted: the mount points and /etc of the image must not be, or lie
+// below, symbolic links, which could redirect mounts and writes onto the host. The Dir of
+// a command is a directory inside the image, entered by /bin/sh of the image.
+func New(base cdsexec.CommandConstructor, opts Options) cdsexec.CommandConstructor {
+	if opts.Mounter == nil {
+		opts.Mounter = bindMounter{}
+	}
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		if err := acquire(opts); err != nil {
+			return err
+		}
+		args := append([]string{inv.Spec.Name}, inv.Spec.Args...)
+		if inv.Spec.Dir != "" {
+			args = append([]string{"/bin/sh", "-c", `cd -- "$1" && shift && exec "$@"`, "sh", inv.Spec.Dir}, args...)
+			inv.Spec.Dir = ""
+		}
+		inv.Spec.Name, inv.Spec.Args = "chroot", append([]string{opts.Root}, args...)
+		err := next(inv)
+		return errors.Join(err, release(opts))
+	})
+}
+
+var (
+	mu    sync.Mutex
+	users = make(map[string]int)
+)
+
+// acquire prepares the environment of opts.Root unless it is already in use.
+func acquire(opts Options) error {
+	mu.Lock()
+	defer mu.Unlock()
+	root := filepath.Clean(opts.Root)
+	if users[root] > 0 {
+		users[root]++
+		return nil
+	}
+	if err := setup(opts); err != nil {
+		return err
+	}
+	users[root] = 1
+	return nil
+}
+
+// release tears the environment of opts.Root down once its last user is done.
+func release(opts Options) error {
+	mu.Lock()
+	defer mu.Unlock()
+	root := filepath.Clean(opts.Root)
+	users[root]--
+	if users[root] > 0 {
+		return nil
+	}
+	delete(users, root)
+	return teardown(opts, len(opts.bindMounts()), !opts.KeepResolvConf)
+}
+
+func setup(opts Options) error {
+	for i, dir := range opts.bindMounts() {
+		target, err := mkdirInRoot(opts.Root, dir)
+		if err != nil {
+			return errors.Join(fmt.Errorf("chrootcmd: %w", err), teardown(opts, i, false))
+		}
+		if err := opts.Mounter.Bind(dir, target); err != nil {
+			return errors.Join(fmt.Errorf("chrootcmd: mounting %s: %w", target, err), teardown(opts, i, false))
+		}
+	}
+	if !opts.KeepResolvConf {
+		if err := installResolvConf(opts.Root); err != nil {
+			return errors.Join(fmt.Errorf("chrootcmd: %w", err), teardown(opts, len(opts.bindMounts()), false))
+		}
+	}
+	return nil
+}
+
+// teardown restores resolv.conf, if it was installed, and removes the first n bind mounts,
+// in reverse order.
+func teardown(opts Options, n int, resolvConf bool) error {
+	var errs []error
+	if resolvConf {
+		if err := restoreResolvConf(opts.Root); err != nil {
+			errs = append(errs, fmt.Errorf("chrootcmd: restoring resolv.conf: %w", err))
+		}
+	}
+	mounts := opts.bindMounts()
+	for i := n - 1; i >= 0; i-- {
+		target := filepath.Join(opts.Root, mounts[i])
+		if err := opts.Mounter.Unmount(target); err != nil {
+			errs = append(errs, fmt.Errorf("chrootcmd: unmounting %s: %w", target, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// mkdirInRoot creates the directory dir of the image at root, as MkdirAll does, and returns
+// its path. It fails if dir or one of its parents is not a directory, symbolic links
+// included, so that the path cannot lead out of root.
+func mkdirInRoot(root, dir string) (string, error) {
+	path := filepath.Clean(root)
+	for _, name := range strings.Split(filepath.Clean("/"+dir), "/")[1:] {
+		if name == "" {
+			continue
+		}
+		path = filepath.Join(path, name)
+		fi, err := os.Lstat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			if err := os.Mkdir(path, 0o755); err != nil {
+				return "", err
+			}
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if !fi.IsDir() {
+			return "", fmt.Errorf("%s is not a directory", path)
+		}
+	}
+	return path, nil
+}
+
+// backupSuffix marks the image's own resolv.conf while the host's is in place.
+const backupSuffix = ".cdsexec-orig"
+
+// installResolvConf puts the host's resolv.conf in the image, keeping the image's own aside.
+// A backup left by a run that did not restore it is never replaced, so that the image's own
+// resolv.conf is not lost: the run fails until the backup is restored.
+func installResolvConf(root string) error {
+	data, err := os.ReadFile(hostResolvConf)
+	if err != nil {
+		return err
+	}
+	dir, err := mkdirInRoot(root, "etc")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, "resolv.conf")
+	if _, err := os.Lstat(path + backupSuffix); err == nil {
+		return fmt.Errorf("%s exists, left by an interrupted run: restore it as %s", path+backupSuffix, path)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	// A symbolic link is renamed as itself, never followed.
+	if _, err := os.Lstat(path); err == nil {
+		if err := os.Rename(path, path+backupSuffix); err != nil {
+			return err
+		}
+	}
+	// O_EXCL fails on a symbolic link created in the meantime rather than follow it.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return errors.Join(err, restoreResolvConf(root))
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return errors.Join(err, restoreResolvConf(root))
+	}
+	return nil
+}
+
+func restoreResolvConf(root string) error {
+	path := filepath.Join(root, "etc", "resolv.conf")
+	if _, err := os.Lstat(path + backupSuffix); err == nil {
+		return os.Rename(path+backupSuffix, path)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package chrootcmd_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec/chrootcmd"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+// fakeMounter records the mounts instead of performing them.
+type fakeMounter struct {
+	mu      sync.Mutex
+	log     []string
+	mounted map[string]bool
+	failOn  string
+	// failUnmount fails the unmounts, which still happen.
+	failUnmount bool
+}
+
+func (f *fakeMounter) Bind(source, target string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if source == f.failOn {
+		return errors.New("permission denied")
+	}
+	f.log = append(f.log, "bind "+source)
+	f.mounted[target] = true
+	return nil
+}
+
+func (f *fakeMounter) Unmount(target string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.log = append(f.log, "unmount "+filepath.Base(target))
+	delete(f.mounted, target)
+	if f.failUnmount {
+		return errors.New("device or resource busy")
+	}
+	return nil
+}
+
+func newImage(t *testing.T) string {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "etc", "resolv.conf"), []byte("image\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestChroot(t *testing.T) {
+	root := newImage(t)
+	host, err := os.ReadFile("/etc/resolv.conf")
+	if err != nil {
+		t.Skip("no host resolv.conf")
+	}
+	m := &fakeMounter{mounted: make(map[string]bool)}
+	base := mockcmd.MakeMockCmdWithOutput("", func(c *mockcmd.MockCmd) error {
+		if want := []string{root, "update-initramfs", "-u"}; c.Name != "chroot" || !reflect.DeepEqual(c.Args, want) {
+			t.Errorf("Expected chroot %v, got %s %v", want, c.Name, c.Args)
+		}
+		if len(m.mounted) != 3 {
+			t.Errorf("Expected 3 mounts during the command, got %d", len(m.mounted))
+		}
+		data, _ := os.ReadFile(filepath.Join(root, "etc", "resolv.conf"))
+		if string(data) != string(host) {
+			t.Errorf("Expected host resolv.conf in the image, got %q", data)
+		}
+		return errors.New("exit status 1")
+	})
+
+	err = chrootcmd.New(base, chrootcmd.Options{Root: root, Mounter: m})(context.Background(), "update-initramfs", "-u").Run()
+	if err == nil {
+		t.Error("Expected the command's error, got nil")
+	}
+	want := []string{"bind /dev", "bind /proc", "bind /sys", "unmount sys", "unmount proc", "unmount dev"}
+	if !reflect.DeepEqual(m.log, want) {
+		t.Errorf("Expected %v, got %v", want, m.log)
+	}
+	data, _ := os.ReadFile(filepath.Join(root, "etc", "resolv.conf"))
+	if string(data) != "image\n" {
+		t.Errorf("Expected the image's resolv.conf to be restored, got %q", data)
+	}
+	if _, err := os.Lstat(filepath.Join(root, "etc", "resolv.conf.cdsexec-orig")); err == nil {
+		t.Error("Expected no backup left behind")
+	}
+}
+
+func TestChrootSetupFailure(t *testing.T) {
+	root := newImage(t)
+	m := &fakeMounter{mounted: make(map[string]bool), failOn: "/sys"}
+	ran := false
+	base := mockcmd.MakeMockCmdWithOutput("", func(c *mockcmd.MockCmd) error {
+		ran = true
+		return nil
+	})
+
+	err := chrootcmd.New(base, chrootcmd.Options{Root: root, Mounter: m, KeepResolvConf: true})(context.Background(), "true").Run()
+	if err == nil {
+		t.Fatal("Expected a setup error, got nil")
+	}
+	if ran {
+		t.Error("Expected the command not to run")
+	}
+	if len(m.mounted) != 0 {
+		t.Errorf("Expected the partial mounts to be removed, got %v", m.mounted)
+	}
+}
+
+func TestChrootShared(t *testing.T) {
+	root := newImage(t)
+	m := &fakeMounter{mounted: make(map[string]bool)}
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	base := mockcmd.MakeMockCmdWithOutput("", func(c *mockcmd.MockCmd) error {
+		if c.Args[1] == "block" {
+			close(entered)
+			<-release
+		}
+		return nil
+	})
+	ctor := chrootcmd.New(base, chrootcmd.Options{Root: root, BindMounts: []string{"/dev"}, KeepResolvConf: true, Mounter: m})
+
+	done := make(chan error)
+	go func() { done <- ctor(context.Background(), "block").Run() }()
+	<-entered
+	if err := ctor(context.Background(), "true").Run(); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.mounted) != 1 {
+		t.Errorf("Expected the mounts to stay while another command runs, got %v", m.mounted)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"bind /dev", "unmount dev"}; !reflect.DeepEqual(m.log, want) {
+		t.Errorf("Expected %v, got %v", want, m.log)
+	}
+}
+
+func TestChrootStaleBackup(t *testing.T) {
+	root := newImage(t)
+	backup := filepath.Join(root, "etc", "resolv.conf.cdsexec-orig")
+	if err := os.WriteFile(backup, []byte("original\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m := &fakeMounter{mounted: make(map[string]bool)}
+	ran := false
+	base := mockcmd.MakeMockCmdWithOutput("", func(c *mockcmd.MockCmd) error {
+		ran = true
+		return nil
+	})
+
+	err := chrootcmd.New(base, chrootcmd.Options{Root: root, Mounter: m})(context.Background(), "true").Run()
+	if err == nil || !strings.Contains(err.Error(), "interrupted run") {
+		t.Fatalf("Expected the stale backup to be reported, got %v", err)
+	}
+	if ran {
+		t.Error("Expected the command not to run")
+	}
+	if data, _ := os.ReadFile(backup); string(data) != "original\n" {
+		t.Errorf("Expected the backup to be kept, got %q", data)
+	}
+	if len(m.mounted) != 0 {
+		t.Errorf("Expected the mounts to be removed, got %v", m.mounted)
+	}
+}
+
+func TestChrootSymlinks(t *testing.T) {
+	outside := t.TempDir()
+	for _, link := range []string{"dev", "etc"} {
+		root := t.TempDir()
+		if err := os.Symlink(outside, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+		m := &fakeMounter{mounted: make(map[string]bool)}
+		base := mockcmd.MakeMockCmdWithOutput("", func(c *mockcmd.MockCmd) error { return nil })
+		opts := chrootcmd.Options{Root: root, BindMounts: []string{"/dev/pts"}, Mounter: m}
+
+		if err := chrootcmd.New(base, opts)(context.Background(), "true").Run(); err == nil {
+			t.Errorf("Expected %s as a symbolic link to fail, got nil", link)
+		}
+		if link == "dev" && len(m.log) != 0 {
+			t.Errorf("Expected nothing mounted through %s, got %v", link, m.log)
+		}
+		if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+			t.Errorf("Expected nothing written through %s, got %v", link, entries)
+		}
+	}
+}
+
+func TestChrootDir(t *testing.T) {
+	root := newImage(t)
+	m := &fakeMounter{mounted: make(map[string]bool)}
+	base := mockcmd.MakeMockCmdWithOutput("", func(c *mockcmd.MockCmd) error {
+		want := []string{root, "/bin/sh", "-c", `cd -- "$1" && shift && exec "$@"`, "sh", "/build", "make", "-j4"}
+		if !reflect.DeepEqual(c.Args, want) {
+			t.Errorf("Expected chroot %v, got %v", want, c.Args)
+		}
+		if c.Dir != "" {
+			t.Errorf("Expected no host directory, got %q", c.Dir)
+		}
+		return nil
+	})
+	cmd := chrootcmd.New(base, chrootcmd.Options{Root: root, KeepResolvConf: true, Mounter: m})(context.Background(), "make", "-j4")
+	cmd.SetDir("/build")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChrootTeardownFailure(t *testing.T) {
+	root := newImage(t)
+	m := &fakeMounter{mounted: make(map[string]bool), failUnmount: true}
+	base := mockcmd.MakeMockCmdWithOutput("", func(c *mockcmd.MockCmd) error { return nil })
+
+	err := chrootcmd.New(base, chrootcmd.Options{Root: root, BindMounts: []string{"/proc"}, KeepResolvConf: true, Mounter: m})(context.Background(), "true").Run()
+	if err == nil || !strings.Contains(err.Error(), "unmounting") {
+		t.Errorf("Expected the unmount failure, got %v", err)
+	}
+}
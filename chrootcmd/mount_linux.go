@@ -0,0 +1,14 @@
+package chrootcmd
+
+import "syscall"
+
+// bindMounter mounts with the mount system call.
+type bindMounter struct{}
+
+func (bindMounter) Bind(source, target string) error {
+	return syscall.Mount(source, target, "", syscall.MS_BIND|syscall.MS_REC, "")
+}
+
+func (bindMounter) Unmount(target string) error {
+	return syscall.Unmount(target, syscall.MNT_DETACH)
+}
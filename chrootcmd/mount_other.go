@@ -0,0 +1,13 @@
+//go:build !linux
+
+package chrootcmd
+
+import "errors"
+
+var errUnsupported = errors.New("bind mounts are only supported on Linux")
+
+// bindMounter fails since bind mounts are Linux specific.
+type bindMounter struct{}
+
+func (bindMounter) Bind(source, target string) error { return errUnsupported }
+func (bindMounter) Unmount(target string) error      { return errUnsupported }
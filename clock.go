@@ -0,0 +1,126 @@
+package cdsexec
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time of the features that wait, such as timeouts, retries and
+// rate limits. A fake implementation lets tests of those features run instantly and
+// deterministically; see mockcmd.FakeClock.
+type Clock interface {
+	Now() time.Time
+	// NewTimer returns a Timer sending the current time on its channel after d.
+	NewTimer(d time.Duration) Timer
+	// AfterFunc returns a Timer calling f after d. Its channel is nil.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is the Clock counterpart of time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// RealClock is the Clock of the time package.
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) Timer { return realTimer{time.NewTimer(d)} }
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return realTimer{time.AfterFunc(d, f)}
+}
+
+type realTimer struct{ t *time.Timer }
+
+func (t realTimer) C() <-chan time.Time        { return t.t.C }
+func (t realTimer) Stop() bool                 { return t.t.Stop() }
+func (t realTimer) Reset(d time.Duration) bool { return t.t.Reset(d) }
+
+type clockKey struct{}
+
+// ContextWithClock returns a context whose commands measure time with c.
+func ContextWithClock(ctx context.Context, c Clock) context.Context {
+	return context.WithValue(ctx, clockKey{}, c)
+}
+
+// ClockFromContext returns the Clock carried by ctx, or RealClock.
+func ClockFromContext(ctx context.Context) Clock {
+	if ctx != nil {
+		if c, ok := ctx.Value(clockKey{}).(Clock); ok {
+			return c
+		}
+	}
+	return RealClock
+}
+
+// Sleep waits for d on the Clock of ctx, or until ctx is done and returns its error.
+func Sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := ClockFromContext(ctx).NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ContextWithTimeout is context.WithTimeout measured on the Clock of ctx: the returned
+// context is done with context.DeadlineExceeded once d has passed on that Clock.
+func ContextWithTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	clock := ClockFromContext(ctx)
+	if clock == RealClock {
+		return context.WithTimeout(ctx, d)
+	}
+	c := &clockContext{Context: ctx, deadline: clock.Now().Add(d), done: make(chan struct{})}
+	if parent, ok := ctx.Deadline(); ok && parent.Before(c.deadline) {
+		c.deadline = parent
+	}
+	stop := context.AfterFunc(ctx, func() { c.cancel(ctx.Err()) })
+	timer := clock.AfterFunc(d, func() { c.cancel(context.DeadlineExceeded) })
+	return c, func() {
+		stop()
+		timer.Stop()
+		c.cancel(context.Canceled)
+	}
+}
+
+// clockContext is a context cancelled by a timer of a Clock. It has a channel of its own,
+// so that the contexts derived from it take their error from its Err method.
+type clockContext struct {
+	context.Context
+	deadline time.Time
+	done     chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+func (c *clockContext) Deadline() (time.Time, bool) { return c.deadline, true }
+
+func (c *clockContext) Done() <-chan struct{} { return c.done }
+
+func (c *clockContext) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *clockContext) cancel(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+		close(c.done)
+	}
+}
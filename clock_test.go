@@ -0,0 +1,37 @@
+package cdsexec_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+func TestContextWithTimeoutKillsCommand(t *testing.T) {
+	clock := mockcmd.NewFakeClock(time.Now())
+	ctx := cdsexec.ContextWithClock(context.Background(), clock)
+	ctx, cancel := cdsexec.ContextWithTimeout(ctx, time.Hour)
+	defer cancel()
+
+	cmd := cdsexec.CommandContext(ctx, "sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	clock.Advance(time.Hour)
+	err := cmd.Wait()
+	if err == nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		t.Errorf("Expected the command to be killed on the deadline, got %v, %v", err, ctx.Err())
+	}
+
+	child, stop := context.WithCancel(ctx)
+	defer stop()
+	if !errors.Is(child.Err(), context.DeadlineExceeded) {
+		t.Errorf("Expected derived contexts to report context.DeadlineExceeded, got %v", child.Err())
+	}
+	if cdsexec.ClockFromContext(context.Background()) != cdsexec.RealClock {
+		t.Errorf("Expected RealClock by default")
+	}
+}
@@ -0,0 +1,93 @@
+package confine
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Cap is a Linux capability.
+type Cap uint
+
+// The Linux capabilities, from linux/capability.h.
+const (
+	CapChown Cap = iota
+	CapDACOverride
+	CapDACReadSearch
+	CapFowner
+	CapFsetid
+	CapKill
+	CapSetgid
+	CapSetuid
+	CapSetpcap
+	CapLinuxImmutable
+	CapNetBindService
+	CapNetBroadcast
+	CapNetAdmin
+	CapNetRaw
+	CapIPCLock
+	CapIPCOwner
+	CapSysModule
+	CapSysRawio
+	CapSysChroot
+	CapSysPtrace
+	CapSysPacct
+	CapSysAdmin
+	CapSysBoot
+	CapSysNice
+	CapSysResource
+	CapSysTime
+	CapSysTTYConfig
+	CapMknod
+	CapLease
+	CapAuditWrite
+	CapAuditControl
+	CapSetfcap
+	CapMACOverride
+	CapMACAdmin
+	CapSyslog
+	CapWakeAlarm
+	CapBlockSuspend
+	CapAuditRead
+	CapPerfmon
+	CapBPF
+	CapCheckpointRestore
+)
+
+var capNames = [...]string{
+	"chown", "dac_override", "dac_read_search", "fowner", "fsetid", "kill", "setgid",
+	"setuid", "setpcap", "linux_immutable", "net_bind_service", "net_broadcast", "net_admin",
+	"net_raw", "ipc_lock", "ipc_owner", "sys_module", "sys_rawio", "sys_chroot", "sys_ptrace",
+	"sys_pacct", "sys_admin", "sys_boot", "sys_nice", "sys_resource", "sys_time",
+	"sys_tty_config", "mknod", "lease", "audit_write", "audit_control", "setfcap",
+	"mac_override", "mac_admin", "syslog", "wake_alarm", "block_suspend", "audit_read",
+	"perfmon", "bpf", "checkpoint_restore",
+}
+
+// String returns the name of c as in the capabilities(7) manual, e.g. CAP_SYS_ADMIN.
+func (c Cap) String() string {
+	if int(c) < len(capNames) {
+		return "CAP_" + strings.ToUpper(capNames[c])
+	}
+	return "CAP_" + strconv.FormatUint(uint64(c), 10)
+}
+
+// Option adjusts the Policy of New.
+type Option func(*Policy)
+
+// WithAmbientCaps keeps caps in the ambient set of the command, so that the programs it
+// executes hold them too, even after switching to a user other than root. The caps must be
+// held by the current process.
+func WithAmbientCaps(caps ...Cap) Option {
+	return func(p *Policy) {
+		p.AmbientCaps = append(p.AmbientCaps, caps...)
+	}
+}
+
+// DropAllCaps drops every capability but the ambient ones from the command, including
+// from its bounding set, so that neither it nor the programs it executes can regain them,
+// even as root.
+func DropAllCaps() Option {
+	return func(p *Policy) {
+		p.DropCaps = true
+	}
+}
@@ -0,0 +1,126 @@
+package confine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	prCapbsetRead           = 23
+	prCapbsetDrop           = 24
+	prCapAmbient            = 47
+	prCapAmbientRaise       = 2
+	prCapAmbientClearAll    = 4
+	linuxCapabilityVersion3 = 0x20080522
+)
+
+type capHeader struct {
+	version uint32
+	pid     int32
+}
+
+type capData struct {
+	effective   uint32
+	permitted   uint32
+	inheritable uint32
+}
+
+// capSets are the capability sets of a thread, as 64-bit masks.
+type capSets struct {
+	effective, permitted, inheritable uint64
+}
+
+func capget() (capSets, error) {
+	hdr := capHeader{version: linuxCapabilityVersion3}
+	var data [2]capData
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPGET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return capSets{}, errno
+	}
+	return capSets{
+		effective:   uint64(data[1].effective)<<32 | uint64(data[0].effective),
+		permitted:   uint64(data[1].permitted)<<32 | uint64(data[0].permitted),
+		inheritable: uint64(data[1].inheritable)<<32 | uint64(data[0].inheritable),
+	}, nil
+}
+
+func capset(s capSets) error {
+	hdr := capHeader{version: linuxCapabilityVersion3}
+	data := [2]capData{
+		{effective: uint32(s.effective), permitted: uint32(s.permitted), inheritable: uint32(s.inheritable)},
+		{effective: uint32(s.effective >> 32), permitted: uint32(s.permitted >> 32), inheritable: uint32(s.inheritable >> 32)},
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// lastCap returns the highest capability the kernel knows.
+func lastCap() Cap {
+	b, err := os.ReadFile("/proc/sys/kernel/cap_last_cap")
+	if err == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil && n >= 0 && n < 64 {
+			return Cap(n)
+		}
+	}
+	return CapCheckpointRestore
+}
+
+// setCaps makes the current thread keep p.AmbientCaps in its ambient set, and, if
+// p.DropCaps is set, drops every other capability from all its sets, so that executing a
+// program as root does not grant them again.
+func setCaps(p Policy) error {
+	if len(p.AmbientCaps) == 0 && !p.DropCaps {
+		return nil
+	}
+	sets, err := capget()
+	if err != nil {
+		return fmt.Errorf("reading capabilities: %w", err)
+	}
+	var keep uint64
+	for _, c := range p.AmbientCaps {
+		if c >= 64 || sets.permitted&(1<<c) == 0 {
+			return fmt.Errorf("capability %v: %w", c, syscall.EPERM)
+		}
+		keep |= 1 << c
+	}
+
+	if p.DropCaps {
+		if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientClearAll, 0, 0, 0, 0); errno != 0 && errno != syscall.EINVAL {
+			return fmt.Errorf("clearing ambient capabilities: %w", errno)
+		}
+		for c := Cap(0); c <= lastCap(); c++ {
+			if keep&(1<<c) != 0 {
+				continue
+			}
+			if held, _, _ := syscall.RawSyscall6(syscall.SYS_PRCTL, prCapbsetRead, uintptr(c), 0, 0, 0, 0); held != 1 {
+				continue
+			}
+			if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prCapbsetDrop, uintptr(c), 0, 0, 0, 0); errno != 0 {
+				return fmt.Errorf("dropping %v from the bounding set: %w", c, errno)
+			}
+		}
+		sets.permitted &= keep
+		sets.effective &= keep
+		sets.inheritable &= keep
+	}
+	// Ambient capabilities must be permitted and inheritable.
+	sets.inheritable |= keep
+	if err := capset(sets); err != nil {
+		return fmt.Errorf("setting capabilities: %w", err)
+	}
+	for _, c := range p.AmbientCaps {
+		if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientRaise, uintptr(c), 0, 0, 0); errno != 0 {
+			if errno == syscall.EINVAL {
+				return fmt.Errorf("ambient capabilities: %w by the kernel", errors.ErrUnsupported)
+			}
+			return fmt.Errorf("raising ambient %v: %w", c, errno)
+		}
+	}
+	return nil
+}
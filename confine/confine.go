@@ -0,0 +1,162 @@
+package confine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// EnvVar carries the policy to the re-executed binary.
+const EnvVar = "CDSEXEC_CONFINE"
+
+// ExitSetupFailed is the exit code of commands whose policy could not be applied, e.g.
+// because the kernel does not support it. The reason is written to their standard error.
+const ExitSetupFailed = 125
+
+// ErrNotInitialized fails the commands of a program that does not call Main.
+var ErrNotInitialized = errors.New("confine: Main was not called at the start of the program")
+
+// Policy restricts what a command may do. The zero Policy restricts nothing but the
+// gaining of privileges.
+type Policy struct {
+	// ReadPaths are the files and directory trees the command may read and execute.
+	ReadPaths []string `json:",omitempty"`
+	// WritePaths are the files and directory trees the command may read, execute, write,
+	// and create or remove entries in.
+	WritePaths []string `json:",omitempty"`
+	// DenySyscalls are the names of the system calls that fail with EPERM, e.g.
+	// DefaultDenySyscalls.
+	DenySyscalls []string `json:",omitempty"`
+	// AmbientCaps are the capabilities the command keeps in its ambient set, as set by
+	// WithAmbientCaps.
+	AmbientCaps []Cap `json:",omitempty"`
+	// DropCaps drops every capability but AmbientCaps, as set by DropAllCaps.
+	DropCaps bool `json:",omitempty"`
+	// BestEffort runs the command with the restrictions the kernel supports instead of
+	// failing it when some are not.
+	BestEffort bool `json:",omitempty"`
+}
+
+// restrictsFS reports whether p limits access to the file system, which it does as soon
+// as any path is given.
+func (p Policy) restrictsFS() bool {
+	return len(p.ReadPaths) > 0 || len(p.WritePaths) > 0
+}
+
+// SystemReadPaths are the directories holding the binaries, shared libraries and
+// configuration most commands need, to be added to the ReadPaths of a Policy.
+var SystemReadPaths = []string{"/bin", "/sbin", "/usr", "/lib", "/lib32", "/lib64", "/etc"}
+
+// DefaultDenySyscalls are the system calls a command has no business making on behalf of
+// the program: loading kernel code, mounting, tracing or reading the memory of other
+// processes, entering namespaces, and changing the clock or the host name.
+var DefaultDenySyscalls = []string{
+	"acct", "add_key", "bpf", "chroot", "clock_settime", "delete_module", "finit_module",
+	"init_module", "kexec_file_load", "kexec_load", "keyctl", "mount", "open_by_handle_at",
+	"perf_event_open", "pivot_root", "process_vm_readv", "process_vm_writev", "ptrace",
+	"reboot", "request_key", "setdomainname", "sethostname", "setns", "settimeofday",
+	"swapoff", "swapon", "umount2", "unshare", "userfaultfd",
+}
+
+var initialized atomic.Bool
+
+// Main applies the policy and executes the command if the program was re-executed by the
+// commands of New, and returns otherwise. Call it at the start of main, before the program
+// does anything else:
+//
+//	func main() {
+//		confine.Main()
+//		...
+//	}
+func Main() {
+	encoded, ok := os.LookupEnv(EnvVar)
+	if !ok {
+		initialized.Store(true)
+		return
+	}
+	os.Unsetenv(EnvVar)
+	if err := run(encoded, os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "confine: %v\n", err)
+		os.Exit(ExitSetupFailed)
+	}
+}
+
+// run decodes the policy and executes args confined by it. It returns only if it fails.
+func run(encoded string, args []string) error {
+	var p Policy
+	if err := json.Unmarshal([]byte(encoded), &p); err != nil {
+		return fmt.Errorf("decoding policy: %w", err)
+	}
+	if len(args) == 0 {
+		return errors.New("no command")
+	}
+	return execConfined(p, args)
+}
+
+// PolicyOf returns the policy a command built by New runs with, given its environment, and
+// whether it is confined at all. It lets tests check the policy through the commands
+// recorded by a mock.
+func PolicyOf(env []string) (Policy, bool) {
+	encoded, ok := cdsexec.LookupEnv(env, EnvVar)
+	if !ok {
+		return Policy{}, false
+	}
+	var p Policy
+	return p, json.Unmarshal([]byte(encoded), &p) == nil
+}
+
+// New returns a CommandConstructor whose commands built by base run confined by p, as
+// adjusted by opts. Go cannot run code in the child between fork and exec, so the commands
+// run the current program again, which must call Main: it restricts itself as p describes
+// and replaces itself with the command. The process, its PID, arguments, environment and
+// streams are those of the command, and the restrictions are inherited by everything it
+// runs, for its whole life. They cannot be lifted, and setuid binaries do not gain
+// privileges.
+//
+// Access to the file system is restricted with Landlock, and system calls with a seccomp
+// filter, so confinement requires Linux; elsewhere the commands fail with
+// errors.ErrUnsupported. base must run commands on the local host. Restrictions the kernel
+// does not support fail the commands with ExitSetupFailed unless p.BestEffort is set.
+func New(base cdsexec.CommandConstructor, p Policy, opts ...Option) cdsexec.CommandConstructor {
+	for _, opt := range opts {
+		opt(&p)
+	}
+	encoded, err := json.Marshal(p)
+	if err == nil {
+		err = check(p)
+	}
+	exe, exeErr := os.Executable()
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		if err != nil {
+			return err
+		}
+		if runtime.GOOS != "linux" {
+			return fmt.Errorf("confine: %w on %s", errors.ErrUnsupported, runtime.GOOS)
+		}
+		if !initialized.Load() {
+			return ErrNotInitialized
+		}
+		if exeErr != nil {
+			return exeErr
+		}
+		name := inv.Spec.Name
+		if !strings.ContainsRune(name, '/') {
+			path, _ := cdsexec.LookupEnv(inv.Spec.Env, "PATH")
+			resolved, err := cdsexec.LookPath(name, path)
+			if err != nil {
+				return err
+			}
+			name = resolved
+		}
+		inv.Spec.Env = cdsexec.MergeEnv(inv.Spec.Env, EnvVar+"="+string(encoded))
+		inv.Spec.Args = append([]string{name}, inv.Spec.Args...)
+		inv.Spec.Name = exe
+		return next(inv)
+	})
+}
@@ -0,0 +1,50 @@
+package confine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+const prSetNoNewPrivs = 38
+
+// check reports the errors of p that every command would fail with.
+func check(p Policy) error {
+	if syscallNumbers == nil {
+		return nil
+	}
+	for _, name := range p.DenySyscalls {
+		if _, ok := syscallNumbers[name]; !ok {
+			return fmt.Errorf("confine: unknown system call %q", name)
+		}
+	}
+	return nil
+}
+
+// execConfined restricts the current thread as p describes and replaces the process with
+// args.
+func execConfined(p Policy, args []string) error {
+	// The restrictions apply to the thread applying them, which must be the one executing
+	// the command.
+	runtime.LockOSThread()
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("setting no_new_privs: %w", errno)
+	}
+	if err := setCaps(p); err != nil && !(p.BestEffort && errors.Is(err, errors.ErrUnsupported)) {
+		return err
+	}
+	if p.restrictsFS() {
+		if err := landlock(p); err != nil && !(p.BestEffort && errors.Is(err, errors.ErrUnsupported)) {
+			return err
+		}
+	}
+	if len(p.DenySyscalls) > 0 {
+		if err := seccomp(p.DenySyscalls); err != nil && !(p.BestEffort && errors.Is(err, errors.ErrUnsupported)) {
+			return err
+		}
+	}
+	err := syscall.Exec(args[0], args, os.Environ())
+	return &os.PathError{Op: "exec", Path: args[0], Err: err}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package confine
+
+import (
+	"errors"
+	"runtime"
+)
+
+func check(p Policy) error { return nil }
+
+func execConfined(p Policy, args []string) error {
+	return errors.New(errors.ErrUnsupported.Error() + " on " + runtime.GOOS)
+}
@@ -0,0 +1,153 @@
+package confine_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/confine"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+func TestMain(m *testing.M) {
+	confine.Main()
+	os.Exit(m.Run())
+}
+
+func requireLinux(t *testing.T) {
+	t.Helper()
+	if runtime.GOOS != "linux" {
+		t.Skip("confinement requires Linux")
+	}
+}
+
+// output runs the command and returns its standard output, its standard error and its
+// error, skipping the test if the kernel cannot apply the policy.
+func output(t *testing.T, cmd cdsexec.Commander) (string, string, error) {
+	t.Helper()
+	var stdout, stderr strings.Builder
+	cmd.SetStdout(&stdout)
+	cmd.SetStderr(&stderr)
+	err := cmd.Run()
+	if cdsexec.ExitCode(err) == confine.ExitSetupFailed && strings.Contains(stderr.String(), "not supported") {
+		t.Skipf("Policy not supported: %s", stderr.String())
+	}
+	return stdout.String(), stderr.String(), err
+}
+
+func TestSameProcess(t *testing.T) {
+	requireLinux(t)
+	ctor := confine.New(cdsexec.CommandContext, confine.Policy{})
+	cmd := ctor(context.Background(), "sh", "-c", `echo "$0 $1 ${CDSEXEC_CONFINE-unset}"; exit 3`, "zero", "one")
+	out, _, err := output(t, cmd)
+	if cdsexec.ExitCode(err) != 3 {
+		t.Errorf("Expected exit code 3, got %v", err)
+	}
+	if out != "zero one unset\n" {
+		t.Errorf("Expected the arguments of the command, got %q", out)
+	}
+}
+
+func TestDenySyscalls(t *testing.T) {
+	requireLinux(t)
+	if _, err := exec.LookPath("chroot"); err != nil {
+		t.Skip("chroot not installed")
+	}
+	ctor := confine.New(cdsexec.CommandContext, confine.Policy{DenySyscalls: confine.DefaultDenySyscalls})
+	_, stderr, err := output(t, ctor(context.Background(), "chroot", "/", "true"))
+	if err == nil || !strings.Contains(stderr, "Operation not permitted") {
+		t.Errorf("Expected chroot to be denied, got %v: %s", err, stderr)
+	}
+	if _, _, err := output(t, ctor(context.Background(), "sh", "-c", "cat /dev/null")); err != nil {
+		t.Errorf("Expected other system calls to be allowed, got %v", err)
+	}
+}
+
+func TestPaths(t *testing.T) {
+	requireLinux(t)
+	dir, other := t.TempDir(), t.TempDir()
+	ctor := confine.New(cdsexec.CommandContext, confine.Policy{
+		ReadPaths:  confine.SystemReadPaths,
+		WritePaths: []string{dir},
+	})
+	script := `echo ok > "$1/allowed" && cat /etc/hostname >/dev/null; echo no > "$2/denied"`
+	_, stderr, err := output(t, ctor(context.Background(), "sh", "-c", script, "sh", dir, other))
+	if err == nil || !strings.Contains(stderr, "Permission denied") {
+		t.Errorf("Expected the write outside WritePaths to be denied, got %v: %s", err, stderr)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "allowed")); err != nil {
+		t.Errorf("Expected the write to WritePaths to succeed, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(other, "denied")); err == nil {
+		t.Errorf("Expected no file outside WritePaths")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	requireLinux(t)
+	ctor := confine.New(cdsexec.CommandContext, confine.Policy{})
+	if err := ctor(context.Background(), "cdsexec-missing").Run(); !errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("Expected exec.ErrNotFound, got %v", err)
+	}
+	ctor = confine.New(cdsexec.CommandContext, confine.Policy{DenySyscalls: []string{"frobnicate"}})
+	if err := ctor(context.Background(), "true").Run(); err == nil || !strings.Contains(err.Error(), "frobnicate") {
+		t.Errorf("Expected unknown system calls to be rejected, got %v", err)
+	}
+}
+
+// capStatus returns the capability lines of the status of a command built by ctor.
+func capStatus(t *testing.T, ctor cdsexec.CommandConstructor) string {
+	t.Helper()
+	out, stderr, err := output(t, ctor(context.Background(), "grep", "^Cap", "/proc/self/status"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v: %s", err, stderr)
+	}
+	return out
+}
+
+func TestCaps(t *testing.T) {
+	requireLinux(t)
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+	status := capStatus(t, confine.New(cdsexec.CommandContext, confine.Policy{}, confine.DropAllCaps()))
+	for _, set := range []string{"CapInh", "CapPrm", "CapEff", "CapBnd", "CapAmb"} {
+		if !strings.Contains(status, set+":\t0000000000000000") {
+			t.Errorf("Expected no %s, got\n%s", set, status)
+		}
+	}
+
+	ctor := confine.New(cdsexec.CommandContext, confine.Policy{},
+		confine.DropAllCaps(), confine.WithAmbientCaps(confine.CapNetBindService))
+	status = capStatus(t, ctor)
+	for _, set := range []string{"CapPrm", "CapEff", "CapBnd", "CapAmb"} {
+		if !strings.Contains(status, set+":\t0000000000000400") {
+			t.Errorf("Expected only CAP_NET_BIND_SERVICE in %s, got\n%s", set, status)
+		}
+	}
+}
+
+func TestPolicyOf(t *testing.T) {
+	requireLinux(t)
+	var policy confine.Policy
+	base := mockcmd.MakeMockCmdWithOutput("", func(m *mockcmd.MockCmd) error {
+		policy, _ = confine.PolicyOf(m.Env)
+		return nil
+	})
+	ctor := confine.New(base, confine.Policy{}, confine.DropAllCaps(), confine.WithAmbientCaps(confine.CapSysAdmin))
+	if err := ctor(context.Background(), "true").Run(); err != nil {
+		t.Fatal(err)
+	}
+	if !policy.DropCaps || len(policy.AmbientCaps) != 1 || policy.AmbientCaps[0] != confine.CapSysAdmin {
+		t.Errorf("Expected the mock to see the capabilities, got %+v", policy)
+	}
+	if s := confine.CapSysAdmin.String(); s != "CAP_SYS_ADMIN" {
+		t.Errorf("Expected CAP_SYS_ADMIN, got %s", s)
+	}
+}
@@ -0,0 +1,123 @@
+package confine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// The Landlock system calls have the same numbers on every architecture.
+const (
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+
+	landlockCreateRulesetVersion = 1
+	landlockRulePathBeneath      = 1
+
+	// oPath is O_PATH, which the syscall package lacks, on the architectures Go supports.
+	oPath = 0x200000
+)
+
+// Access rights to the file system, from linux/landlock.h.
+const (
+	accessExecute = 1 << iota
+	accessWriteFile
+	accessReadFile
+	accessReadDir
+	accessRemoveDir
+	accessRemoveFile
+	accessMakeChar
+	accessMakeDir
+	accessMakeReg
+	accessMakeSock
+	accessMakeFifo
+	accessMakeBlock
+	accessMakeSym
+	accessRefer    // ABI 2
+	accessTruncate // ABI 3
+
+	accessRead = accessExecute | accessReadFile | accessReadDir
+	accessFile = accessExecute | accessWriteFile | accessReadFile | accessTruncate
+)
+
+type rulesetAttr struct {
+	handledAccessFS uint64
+}
+
+// pathBeneathAttr is the packed struct landlock_path_beneath_attr, of which the kernel
+// reads the first 12 bytes.
+type pathBeneathAttr struct {
+	allowedAccess uint64
+	parentFD      int32
+}
+
+// landlock restricts the access of the current thread to the file system to the paths of
+// p. Paths that do not exist are ignored.
+func landlock(p Policy) error {
+	abi, _, errno := syscall.RawSyscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVersion)
+	if errno != 0 {
+		if errno == syscall.ENOSYS || errno == syscall.EOPNOTSUPP {
+			return fmt.Errorf("landlock: %w by the kernel", errors.ErrUnsupported)
+		}
+		return fmt.Errorf("landlock: %w", errno)
+	}
+	// Rights of later ABIs are only handled, and so denied, when the kernel knows them.
+	handled := uint64(accessMakeSym<<1 - 1)
+	if abi >= 2 {
+		handled |= accessRefer
+	}
+	if abi >= 3 {
+		handled |= accessTruncate
+	}
+	attr := rulesetAttr{handledAccessFS: handled}
+	fd, _, errno := syscall.RawSyscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("landlock: creating ruleset: %w", errno)
+	}
+	defer syscall.Close(int(fd))
+
+	for _, rule := range []struct {
+		paths  []string
+		access uint64
+	}{
+		{p.ReadPaths, accessRead},
+		{p.WritePaths, handled},
+	} {
+		for _, path := range rule.paths {
+			if err := addPathRule(int(fd), path, rule.access&handled); err != nil {
+				return err
+			}
+		}
+	}
+	if _, _, errno := syscall.RawSyscall(sysLandlockRestrictSelf, fd, 0, 0); errno != 0 {
+		return fmt.Errorf("landlock: restricting: %w", errno)
+	}
+	return nil
+}
+
+func addPathRule(ruleset int, path string, access uint64) error {
+	fd, err := syscall.Open(path, oPath|syscall.O_CLOEXEC, 0)
+	if err == syscall.ENOENT {
+		return nil
+	}
+	if err != nil {
+		return &os.PathError{Op: "landlock", Path: path, Err: err}
+	}
+	defer syscall.Close(fd)
+	var st syscall.Stat_t
+	if err := syscall.Fstat(fd, &st); err != nil {
+		return &os.PathError{Op: "landlock", Path: path, Err: err}
+	}
+	if st.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		// Rights on directory entries only apply to directories.
+		access &= accessFile
+	}
+	attr := pathBeneathAttr{allowedAccess: access, parentFD: int32(fd)}
+	if _, _, errno := syscall.RawSyscall6(sysLandlockAddRule, uintptr(ruleset), landlockRulePathBeneath, uintptr(unsafe.Pointer(&attr)), 0, 0, 0); errno != 0 {
+		return &os.PathError{Op: "landlock", Path: path, Err: errno}
+	}
+	return nil
+}
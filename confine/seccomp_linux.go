@@ -0,0 +1,68 @@
+package confine
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	prSetSeccomp      = 22
+	seccompModeFilter = 2
+
+	seccompRetAllow = 0x7fff0000
+	seccompRetErrno = 0x00050000
+
+	// Offsets of the fields of struct seccomp_data.
+	seccompDataNr   = 0
+	seccompDataArch = 4
+)
+
+// seccomp installs a filter failing the system calls named names with EPERM, and every
+// system call made with the conventions of another architecture than that of the program,
+// which would otherwise have other numbers.
+func seccomp(names []string) error {
+	if syscallNumbers == nil {
+		return fmt.Errorf("seccomp: %w on this architecture", errors.ErrUnsupported)
+	}
+	if len(names) >= 255 {
+		return errors.New("seccomp: too many system calls")
+	}
+	deny := seccompRetErrno | uint32(syscall.EPERM)
+	var prog []syscall.SockFilter
+	stmt := func(code uint16, k uint32) {
+		prog = append(prog, syscall.SockFilter{Code: code, K: k})
+	}
+	// jumpToDeny jumps to the last instruction of the program if the comparison holds,
+	// skipping the skip instructions in between.
+	jumpToDeny := func(code uint16, k uint32, skip int) {
+		prog = append(prog, syscall.SockFilter{Code: code, Jt: uint8(skip), K: k})
+	}
+
+	stmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, seccompDataArch)
+	prog = append(prog, syscall.SockFilter{Code: syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K, Jt: 1, K: auditArch})
+	stmt(syscall.BPF_RET|syscall.BPF_K, deny)
+	stmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, seccompDataNr)
+	if x32SyscallBit != 0 {
+		jumpToDeny(syscall.BPF_JMP|syscall.BPF_JGE|syscall.BPF_K, x32SyscallBit, len(names)+1)
+	}
+	for i, name := range names {
+		nr, ok := syscallNumbers[name]
+		if !ok {
+			return fmt.Errorf("seccomp: unknown system call %q", name)
+		}
+		jumpToDeny(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, nr, len(names)-i)
+	}
+	stmt(syscall.BPF_RET|syscall.BPF_K, seccompRetAllow)
+	stmt(syscall.BPF_RET|syscall.BPF_K, deny)
+
+	fprog := syscall.SockFprog{Len: uint16(len(prog)), Filter: &prog[0]}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetSeccomp, seccompModeFilter, uintptr(unsafe.Pointer(&fprog))); errno != 0 {
+		if errno == syscall.EINVAL {
+			return fmt.Errorf("seccomp: %w by the kernel", errors.ErrUnsupported)
+		}
+		return fmt.Errorf("seccomp: %w", errno)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build linux && !amd64 && !arm64
+
+package confine
+
+const (
+	auditArch     = 0
+	x32SyscallBit = 0
+)
+
+// syscallNumbers is nil where system calls cannot be filtered yet.
+var syscallNumbers map[string]uint32
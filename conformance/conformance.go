@@ -0,0 +1,305 @@
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// Options configures RunWith.
+type Options struct {
+	// Shell is the POSIX shell the commands of the suite are scripts for, run as
+	// Shell -c script. Empty means sh.
+	Shell string
+	// Skip names the subtests to skip, for backends that do not support a feature, e.g.
+	// "Dir" for one that cannot change directory.
+	Skip []string
+	// Timeout bounds how long a cancelled command may take to return. Zero means 10s.
+	Timeout time.Duration
+}
+
+// Run is RunWith with the zero Options.
+func Run(t *testing.T, ctor cdsexec.CommandConstructor) {
+	RunWith(t, ctor, Options{})
+}
+
+// RunWith runs a subtest for every part of the contract of Commander against the
+// commands built by ctor, so that every backend behaves like os/exec: what Run, Output
+// and CombinedOutput return, the lifecycle of pipes, Start and Wait, the errors carrying
+// exit codes and standard error, and the effect of context cancellation.
+func RunWith(t *testing.T, ctor cdsexec.CommandConstructor, opts Options) {
+	if opts.Shell == "" {
+		opts.Shell = "sh"
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	s := &suite{ctor: ctor, opts: opts}
+	tests := []struct {
+		name string
+		fn   func(t *testing.T)
+	}{
+		{"Run", s.testRun},
+		{"Output", s.testOutput},
+		{"OutputStderr", s.testOutputStderr},
+		{"OutputStdoutSet", s.testOutputStdoutSet},
+		{"CombinedOutput", s.testCombinedOutput},
+		{"Stdin", s.testStdin},
+		{"StdinPipe", s.testStdinPipe},
+		{"StdoutPipe", s.testStdoutPipe},
+		{"StderrPipe", s.testStderrPipe},
+		{"Dir", s.testDir},
+		{"Env", s.testEnv},
+		{"StartWait", s.testStartWait},
+		{"CancelBeforeStart", s.testCancelBeforeStart},
+		{"Cancel", s.testCancel},
+		{"Deadline", s.testDeadline},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, skip := range opts.Skip {
+				if skip == tt.name {
+					t.Skip("conformance: skipped by Options.Skip")
+				}
+			}
+			tt.fn(t)
+		})
+	}
+}
+
+type suite struct {
+	ctor cdsexec.CommandConstructor
+	opts Options
+}
+
+func (s *suite) script(ctx context.Context, script string) cdsexec.Commander {
+	return s.ctor(ctx, s.opts.Shell, "-c", script)
+}
+
+func (s *suite) testRun(t *testing.T) {
+	if err := s.script(context.Background(), "exit 0").Run(); err != nil {
+		t.Errorf("Expected success, got %v", err)
+	}
+	err := s.script(context.Background(), "exit 3").Run()
+	if code := cdsexec.ExitCode(err); code != 3 {
+		t.Errorf("Expected an error with exit code 3, got %v (exit code %d)", err, code)
+	}
+}
+
+func (s *suite) testOutput(t *testing.T) {
+	out, err := s.script(context.Background(), "printf out; printf err >&2").Output()
+	if err != nil || string(out) != "out" {
+		t.Errorf("Expected only stdout, got %q, %v", out, err)
+	}
+}
+
+func (s *suite) testOutputStderr(t *testing.T) {
+	out, err := s.script(context.Background(), "printf partial; printf failure >&2; exit 2").Output()
+	if string(out) != "partial" {
+		t.Errorf("Expected the stdout written before failing, got %q", out)
+	}
+	if code := cdsexec.ExitCode(err); code != 2 {
+		t.Fatalf("Expected an error with exit code 2, got %v (exit code %d)", err, code)
+	}
+	if got := stderrOf(err); got != "failure" {
+		t.Errorf("Expected the error to carry stderr %q, got %q", "failure", got)
+	}
+}
+
+// stderrOf returns the standard error carried by an *exec.ExitError or *cdsexec.ExitError.
+func stderrOf(err error) string {
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		return string(ee.Stderr)
+	}
+	var xe *cdsexec.ExitError
+	if errors.As(err, &xe) {
+		return string(xe.Stderr)
+	}
+	return ""
+}
+
+func (s *suite) testOutputStdoutSet(t *testing.T) {
+	cmd := s.script(context.Background(), "printf out")
+	cmd.SetStdout(io.Discard)
+	if _, err := cmd.Output(); err == nil {
+		t.Errorf("Expected Output to fail when stdout is set")
+	}
+	cmd = s.script(context.Background(), "printf out")
+	cmd.SetStdout(io.Discard)
+	if _, err := cmd.CombinedOutput(); err == nil {
+		t.Errorf("Expected CombinedOutput to fail when stdout is set")
+	}
+}
+
+func (s *suite) testCombinedOutput(t *testing.T) {
+	out, err := s.script(context.Background(), "printf a; printf b >&2; printf c").CombinedOutput()
+	if err != nil || string(out) != "abc" {
+		t.Errorf("Expected interleaved stdout and stderr, got %q, %v", out, err)
+	}
+}
+
+func (s *suite) testStdin(t *testing.T) {
+	cmd := s.script(context.Background(), "cat")
+	cmd.SetStdin(strings.NewReader("input\n"))
+	out, err := cmd.Output()
+	if err != nil || string(out) != "input\n" {
+		t.Errorf("Expected stdin echoed, got %q, %v", out, err)
+	}
+}
+
+func (s *suite) testStdinPipe(t *testing.T) {
+	cmd := s.script(context.Background(), "cat")
+	var out bytes.Buffer
+	cmd.SetStdout(&out)
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	io.WriteString(w, "piped\n")
+	if err := w.Close(); err != nil {
+		t.Errorf("Unexpected error closing stdin: %v", err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if out.String() != "piped\n" {
+		t.Errorf("Expected %q, got %q", "piped\n", out.String())
+	}
+}
+
+func (s *suite) testStdoutPipe(t *testing.T) {
+	cmd := s.script(context.Background(), "printf piped")
+	r, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := cmd.StdoutPipe(); err == nil {
+		t.Errorf("Expected a second StdoutPipe to fail")
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := cmd.StderrPipe(); err == nil {
+		t.Errorf("Expected StderrPipe after Start to fail")
+	}
+	out, err := io.ReadAll(r)
+	if err != nil || string(out) != "piped" {
+		t.Errorf("Expected %q, got %q, %v", "piped", out, err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func (s *suite) testStderrPipe(t *testing.T) {
+	cmd := s.script(context.Background(), "printf piped >&2; exit 4")
+	r, err := cmd.StderrPipe()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil || string(out) != "piped" {
+		t.Errorf("Expected %q, got %q, %v", "piped", out, err)
+	}
+	if code := cdsexec.ExitCode(cmd.Wait()); code != 4 {
+		t.Errorf("Expected exit code 4, got %d", code)
+	}
+}
+
+func (s *suite) testDir(t *testing.T) {
+	cmd := s.script(context.Background(), "pwd")
+	cmd.SetDir("/")
+	out, err := cmd.Output()
+	if err != nil || string(out) != "/\n" {
+		t.Errorf("Expected the command to run in /, got %q, %v", out, err)
+	}
+}
+
+func (s *suite) testEnv(t *testing.T) {
+	cmd := s.script(context.Background(), `printf '%s|%s' "$CONFORMANCE" "${HOME-unset}"`)
+	cmd.SetEnv([]string{"CONFORMANCE=yes"})
+	out, err := cmd.Output()
+	if err != nil || string(out) != "yes|unset" {
+		t.Errorf("Expected exactly the environment set, got %q, %v", out, err)
+	}
+}
+
+func (s *suite) testStartWait(t *testing.T) {
+	cmd := s.script(context.Background(), "exit 5")
+	if err := cmd.Wait(); err == nil {
+		t.Errorf("Expected Wait before Start to fail")
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := cmd.Start(); err == nil {
+		t.Errorf("Expected a second Start to fail")
+	}
+	err := cmd.Wait()
+	if code := cdsexec.ExitCode(err); code != 5 {
+		t.Errorf("Expected exit code 5, got %v (exit code %d)", err, code)
+	}
+	if err := cmd.Wait(); err == nil {
+		t.Errorf("Expected a second Wait to fail")
+	}
+	if st := cmd.ProcessState(); st != nil && st.ExitCode() != 5 {
+		t.Errorf("Expected the process state to report exit code 5, got %d", st.ExitCode())
+	}
+	if _, err := cmd.Output(); err == nil {
+		t.Errorf("Expected Output after Start to fail")
+	}
+}
+
+func (s *suite) testCancelBeforeStart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.script(ctx, "exit 0").Run(); err == nil {
+		t.Errorf("Expected a command with a cancelled context to fail")
+	}
+}
+
+func (s *suite) testCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cmd := s.script(ctx, "exec sleep 60")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	cancel()
+	s.expectFailure(t, cmd.Wait)
+}
+
+func (s *suite) testDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	s.expectFailure(t, s.script(ctx, "exec sleep 60").Run)
+}
+
+// expectFailure expects wait to fail within the timeout of the suite.
+func (s *suite) expectFailure(t *testing.T, wait func() error) {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- wait() }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("Expected the command to fail")
+		}
+	case <-time.After(s.opts.Timeout):
+		t.Errorf("Expected the command to return within %s", s.opts.Timeout)
+	}
+}
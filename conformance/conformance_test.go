@@ -0,0 +1,19 @@
+package conformance_test
+
+import (
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/conformance"
+)
+
+func TestRun(t *testing.T) {
+	t.Run("Real", func(t *testing.T) {
+		conformance.Run(t, cdsexec.CommandContext)
+	})
+	t.Run("Wrapped", func(t *testing.T) {
+		conformance.Run(t, cdsexec.Wrap(cdsexec.CommandContext, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+			return next(inv)
+		}))
+	})
+}
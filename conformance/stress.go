@@ -0,0 +1,203 @@
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// StressOptions configures Stress.
+type StressOptions struct {
+	// Echo is a command writing EchoOutput to its standard output and exiting 0. Zero
+	// means echo cdsexec-conformance.
+	Echo       cdsexec.CommandSpec
+	EchoOutput string
+	// Sleep is a command running until it is killed. Zero means sleep 60.
+	Sleep cdsexec.CommandSpec
+	// Goroutines is the number of concurrent workers. Zero means 8.
+	Goroutines int
+	// Iterations is the number of sequences every worker runs. Zero means 20.
+	Iterations int
+	// Timeout bounds how long a killed or cancelled command may take to return. Zero
+	// means 10s.
+	Timeout time.Duration
+	// Seed seeds the choice of sequences. Zero means 1.
+	Seed int64
+}
+
+func (o *StressOptions) defaults() {
+	if o.Echo.Name == "" {
+		o.Echo = cdsexec.CommandSpec{Name: "echo", Args: []string{"cdsexec-conformance"}}
+		o.EchoOutput = "cdsexec-conformance\n"
+	}
+	if o.Sleep.Name == "" {
+		o.Sleep = cdsexec.CommandSpec{Name: "sleep", Args: []string{"60"}}
+	}
+	if o.Goroutines <= 0 {
+		o.Goroutines = 8
+	}
+	if o.Iterations <= 0 {
+		o.Iterations = 20
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 10 * time.Second
+	}
+	if o.Seed == 0 {
+		o.Seed = 1
+	}
+}
+
+// sequence exercises one command and returns an error when it breaks the contract of
+// Commander.
+type sequence struct {
+	name string
+	run  func(ctor cdsexec.CommandConstructor, o *StressOptions) error
+}
+
+var sequences = []sequence{
+	{"Output", stressOutput},
+	{"CombinedOutput", stressCombinedOutput},
+	{"StdoutPipe", stressStdoutPipe},
+	{"Kill", stressKill},
+	{"Cancel", stressCancel},
+	{"Reuse", stressReuse},
+}
+
+// Stress runs random sequences of construct, start, kill, cancel and output calls from
+// many goroutines at once against commands built by ctor, reporting the contract
+// violations it observes on t. Run it under the race detector, which catches the data
+// races the sequences provoke:
+//
+//	go test -race -run TestStress ./...
+func Stress(t testing.TB, ctor cdsexec.CommandConstructor, opts StressOptions) {
+	t.Helper()
+	opts.defaults()
+	var wg sync.WaitGroup
+	for g := 0; g < opts.Goroutines; g++ {
+		rnd := rand.New(rand.NewSource(opts.Seed + int64(g)))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < opts.Iterations; i++ {
+				seq := sequences[rnd.Intn(len(sequences))]
+				if err := seq.run(ctor, &opts); err != nil {
+					t.Errorf("conformance: %s: %v", seq.name, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func stressOutput(ctor cdsexec.CommandConstructor, o *StressOptions) error {
+	out, err := o.Echo.Command(context.Background(), ctor).Output()
+	if err != nil {
+		return err
+	}
+	if string(out) != o.EchoOutput {
+		return fmt.Errorf("expected output %q, got %q", o.EchoOutput, out)
+	}
+	return nil
+}
+
+func stressCombinedOutput(ctor cdsexec.CommandConstructor, o *StressOptions) error {
+	out, err := o.Echo.Command(context.Background(), ctor).CombinedOutput()
+	if err != nil {
+		return err
+	}
+	if string(out) != o.EchoOutput {
+		return fmt.Errorf("expected combined output %q, got %q", o.EchoOutput, out)
+	}
+	return nil
+}
+
+func stressStdoutPipe(ctor cdsexec.CommandConstructor, o *StressOptions) error {
+	cmd := o.Echo.Command(context.Background(), ctor)
+	r, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	_, copyErr := io.Copy(&out, r)
+	if err := cmd.Wait(); err != nil {
+		return err
+	}
+	if copyErr != nil {
+		return fmt.Errorf("reading stdout: %w", copyErr)
+	}
+	if out.String() != o.EchoOutput {
+		return fmt.Errorf("expected piped output %q, got %q", o.EchoOutput, out.String())
+	}
+	if cmd.ProcessState() != nil && !cmd.ProcessState().Success() {
+		return fmt.Errorf("expected a successful process state, got %v", cmd.ProcessState())
+	}
+	return nil
+}
+
+// stressKill kills a started command from another goroutine while Wait runs, as a
+// watchdog would. Commands without a local process are cancelled instead.
+func stressKill(ctor cdsexec.CommandConstructor, o *StressOptions) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cmd := o.Sleep.Command(ctx, ctor)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go func() {
+		if p := cmd.Process(); p != nil {
+			p.Kill()
+		} else {
+			cancel()
+		}
+	}()
+	return waitFailed(cmd.Wait, o.Timeout, "killed")
+}
+
+// stressCancel cancels the context of a running command.
+func stressCancel(ctor cdsexec.CommandConstructor, o *StressOptions) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := o.Sleep.Command(ctx, ctor)
+	time.AfterFunc(time.Millisecond, cancel)
+	return waitFailed(cmd.Run, o.Timeout, "cancelled")
+}
+
+// stressReuse checks that a command only executes once.
+func stressReuse(ctor cdsexec.CommandConstructor, o *StressOptions) error {
+	cmd := o.Echo.Command(context.Background(), ctor)
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+	if err := cmd.Start(); err == nil {
+		cmd.Wait()
+		return fmt.Errorf("expected Start after Run to fail")
+	}
+	if _, err := cmd.Output(); err == nil {
+		return fmt.Errorf("expected Output after Run to fail")
+	}
+	return nil
+}
+
+// waitFailed calls wait and expects it to fail within timeout.
+func waitFailed(wait func() error, timeout time.Duration, what string) error {
+	done := make(chan error, 1)
+	go func() { done <- wait() }()
+	select {
+	case err := <-done:
+		if err == nil {
+			return fmt.Errorf("expected a %s command to fail", what)
+		}
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("a %s command did not return within %s", what, timeout)
+	}
+}
@@ -0,0 +1,25 @@
+package conformance_test
+
+import (
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/conformance"
+)
+
+func TestStress(t *testing.T) {
+	tests := []struct {
+		name string
+		ctor cdsexec.CommandConstructor
+	}{
+		{"Real", cdsexec.CommandContext},
+		{"Wrapped", cdsexec.Wrap(cdsexec.CommandContext, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+			return next(inv)
+		})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conformance.Stress(t, tt.ctor, conformance.StressOptions{Iterations: 10})
+		})
+	}
+}
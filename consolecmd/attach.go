@@ -0,0 +1,44 @@
+package consolecmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// Attach starts a local console client such as
+// "ipmitool -I lanplus -H bmc -U admin -E sol activate" with ctor and returns its session:
+// reads come from its output and writes go to its input. Closing the session closes the
+// input of the client and waits for it to exit.
+func Attach(ctx context.Context, ctor cdsexec.CommandConstructor, name string, arg ...string) (io.ReadWriteCloser, error) {
+	cmd := ctor(ctx, name, arg...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("consolecmd: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("consolecmd: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("consolecmd: starting %s: %w", name, err)
+	}
+	return &session{cmd: cmd, stdin: stdin, stdout: stdout}, nil
+}
+
+// session is the console of a local client process.
+type session struct {
+	cmd    cdsexec.Commander
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+}
+
+func (s *session) Read(p []byte) (int, error)  { return s.stdout.Read(p) }
+func (s *session) Write(p []byte) (int, error) { return s.stdin.Write(p) }
+
+func (s *session) Close() error {
+	s.stdin.Close()
+	return s.cmd.Wait()
+}
@@ -0,0 +1,251 @@
+package consolecmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// Options configures how commands are driven over the console.
+type Options struct {
+	// Prompt matches the shell prompt at the end of the console output. Nil matches a
+	// line ending in "#", "$" or ">" followed by an optional space.
+	Prompt *regexp.Regexp
+	// NoEcho is set when the console does not echo the command line back.
+	NoEcho bool
+	// NoExitStatus is set when the console is not a POSIX shell, e.g. a boot loader or BMC
+	// prompt. Exit codes are then not collected and every command that completes succeeds.
+	NoExitStatus bool
+	// Interrupt is sent to the console when a command is cancelled. Nil sends Ctrl-C.
+	Interrupt []byte
+}
+
+var defaultPrompt = regexp.MustCompile(`[#$>] ?$`)
+
+// exitMarker precedes the exit status printed after every command. The echoed command line
+// contains it followed by "$?", which exitPattern does not match.
+const exitMarker = "__cdsexec_status="
+
+var exitPattern = regexp.MustCompile(`(?m)^` + exitMarker + `(\d+)\n?`)
+
+// syncMarker precedes the number printed to find the end of the output of a cancelled
+// command.
+const syncMarker = "__cdsexec_sync="
+
+// New returns a CommandConstructor running commands on the shell behind a serial console
+// or an IPMI Serial-over-LAN session, e.g. an opened /dev/ttyS0 or the session returned by
+// Attach. Each command line is typed at the prompt and its output captured until the next
+// prompt, one command at a time.
+//
+// Consoles merge standard output and error, so all output is written to Stdout, and have
+// no end of input, so standard input is not supported. Unless opts.NoExitStatus is set, the
+// exit status is collected by echoing $? after the command, and non-zero exits are
+// reported as *cdsexec.ExitError. Cancelling the context sends opts.Interrupt and waits
+// for the prompt no longer. The next command then first waits for the output of the
+// cancelled one to end: on a shell by echoing a marker, otherwise until the next prompt.
+func New(rw io.ReadWriter, opts Options) cdsexec.CommandConstructor {
+	if opts.Prompt == nil {
+		opts.Prompt = defaultPrompt
+	}
+	if opts.Interrupt == nil {
+		opts.Interrupt = []byte{0x03}
+	}
+	c := &console{rw: rw, opts: opts}
+	return cdsexec.StreamConstructor(c.run)
+}
+
+// console serializes the commands sent over one console.
+type console struct {
+	rw   io.ReadWriter
+	opts Options
+
+	mu      sync.Mutex
+	once    sync.Once
+	chunks  chan []byte
+	readErr error
+	// cancelled is set while the output of a cancelled command may still arrive.
+	cancelled bool
+	syncs     int
+}
+
+// startReader starts reading the console in the background, so that reads can be
+// abandoned when a command is cancelled.
+func (c *console) startReader() {
+	c.once.Do(func() {
+		c.chunks = make(chan []byte, 64)
+		go func() {
+			for {
+				buf := make([]byte, 4096)
+				n, err := c.rw.Read(buf)
+				if n > 0 {
+					c.chunks <- buf[:n]
+				}
+				if err != nil {
+					c.readErr = err
+					close(c.chunks)
+					return
+				}
+			}
+		}()
+	})
+}
+
+// resync discards the output of the command cancelled last, up to the prompt following
+// it. On a shell, a numbered marker is echoed first, so that a prompt printed by the
+// cancelled command is not mistaken for the end of its output.
+func (c *console) resync(ctx context.Context) error {
+	var end *regexp.Regexp
+	if !c.opts.NoExitStatus {
+		c.syncs++
+		n := strconv.Itoa(c.syncs)
+		if _, err := io.WriteString(c.rw, "echo "+syncMarker+n+"\r"); err != nil {
+			return err
+		}
+		end = regexp.MustCompile(`(?m)^` + syncMarker + n + `\r?\n`)
+	}
+	var buf []byte
+	for {
+		chunk, err := c.next(ctx)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, chunk...)
+		out := buf
+		if end != nil {
+			loc := end.FindIndex(out)
+			if loc == nil {
+				continue
+			}
+			out = out[loc[1]:]
+		}
+		if promptAtEnd(c.opts.Prompt, out) >= 0 {
+			c.cancelled = false
+			return nil
+		}
+	}
+}
+
+// next returns the next chunk of output read from the console.
+func (c *console) next(ctx context.Context) ([]byte, error) {
+	select {
+	case chunk, ok := <-c.chunks:
+		if !ok {
+			if c.readErr == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, c.readErr
+		}
+		return chunk, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *console) run(ctx context.Context, spec cdsexec.CommandSpec) error {
+	if spec.Stdin != nil {
+		return errors.New("consolecmd: standard input is not supported")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.startReader()
+	if c.cancelled {
+		if err := c.resync(ctx); err != nil {
+			return fmt.Errorf("consolecmd: waiting for a cancelled command: %w", err)
+		}
+	}
+
+	line := commandLine(spec)
+	if !c.opts.NoExitStatus {
+		line += `; echo "` + exitMarker + `$?"`
+	}
+	if _, err := io.WriteString(c.rw, line+"\r"); err != nil {
+		return fmt.Errorf("consolecmd: %w", err)
+	}
+	out, err := c.readUntilPrompt(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			c.rw.Write(c.opts.Interrupt)
+			c.cancelled = true
+			return fmt.Errorf("consolecmd: %w", ctx.Err())
+		}
+		return fmt.Errorf("consolecmd: %w", err)
+	}
+
+	code := 0
+	if !c.opts.NoExitStatus {
+		all := exitPattern.FindAllSubmatchIndex(out, -1)
+		m := all[len(all)-1]
+		code, _ = strconv.Atoi(string(out[m[2]:m[3]]))
+		out = append(out[:m[0]:m[0]], out[m[1]:]...)
+	}
+	if spec.Stdout != nil && len(out) > 0 {
+		if _, err := spec.Stdout.Write(out); err != nil {
+			return fmt.Errorf("consolecmd: %w", err)
+		}
+	}
+	if code != 0 {
+		return &cdsexec.ExitError{Code: code}
+	}
+	return nil
+}
+
+// readUntilPrompt returns the output of the command, without the echoed command line and
+// the prompt, with line endings normalized to "\n". Unless opts.NoExitStatus is set, the
+// output ends only with a prompt following the exit status, so that the command may print
+// text looking like a prompt.
+func (c *console) readUntilPrompt(ctx context.Context) ([]byte, error) {
+	var buf []byte
+	for {
+		chunk, err := c.next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, chunk...)
+
+		out := buf
+		if !c.opts.NoEcho {
+			i := bytes.IndexByte(out, '\n')
+			if i < 0 {
+				continue
+			}
+			out = out[i+1:]
+		}
+		loc := promptAtEnd(c.opts.Prompt, out)
+		if loc < 0 {
+			continue
+		}
+		out = bytes.ReplaceAll(out[:loc], []byte("\r\n"), []byte("\n"))
+		if c.opts.NoExitStatus || exitPattern.Match(out) {
+			return out, nil
+		}
+	}
+}
+
+// promptAtEnd returns the offset of the prompt ending the last line of out, or -1.
+func promptAtEnd(prompt *regexp.Regexp, out []byte) int {
+	start := bytes.LastIndexByte(out, '\n') + 1
+	loc := prompt.FindIndex(out[start:])
+	if loc == nil || start+loc[1] != len(out) {
+		return -1
+	}
+	return start
+}
+
+// commandLine renders spec as a shell command line.
+func commandLine(spec cdsexec.CommandSpec) string {
+	line := cdsexec.CommandSpec{Name: spec.Name, Args: spec.Args}.String()
+	if len(spec.Env) > 0 {
+		line = cdsexec.CommandSpec{Name: "env", Args: spec.Env}.String() + " " + line
+	}
+	if spec.Dir != "" {
+		line = cdsexec.CommandSpec{Name: "cd", Args: []string{spec.Dir}}.String() + " && " + line
+	}
+	return line
+}
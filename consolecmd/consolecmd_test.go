@@ -0,0 +1,142 @@
+package consolecmd_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/consolecmd"
+)
+
+// fakeTerminal echoes the lines typed at its prompt and runs them with sh, writing CRLF
+// line endings like a serial console.
+type fakeTerminal struct {
+	io.Reader
+	io.Writer
+}
+
+func newFakeTerminal(t *testing.T) *fakeTerminal {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	t.Cleanup(func() { inW.Close(); outR.Close() })
+	// Typing does not block while a command runs, like on a terminal.
+	lines := make(chan string, 16)
+	go func() {
+		defer close(lines)
+		r := bufio.NewReader(inR)
+		for {
+			line, err := r.ReadString('\r')
+			if err != nil {
+				outW.CloseWithError(err)
+				return
+			}
+			lines <- line
+		}
+	}()
+	go func() {
+		for line := range lines {
+			// Like a terminal line discipline, Ctrl-C discards what was typed before it.
+			line = strings.TrimSuffix(line[strings.LastIndexByte(line, 0x03)+1:], "\r")
+			out, _ := exec.Command("sh", "-c", line).CombinedOutput()
+			io.WriteString(outW, line+"\r\n")
+			outW.Write(bytes.ReplaceAll(out, []byte("\n"), []byte("\r\n")))
+			io.WriteString(outW, "root@rescue:~# ")
+		}
+	}()
+	return &fakeTerminal{Reader: outR, Writer: inW}
+}
+
+func TestConsole(t *testing.T) {
+	ctor := consolecmd.New(newFakeTerminal(t), consolecmd.Options{})
+
+	tests := []struct {
+		name     string
+		cmd      []string
+		dir      string
+		env      []string
+		expected string
+		code     int
+	}{
+		{"output", []string{"echo", "hello world"}, "", nil, "hello world\n", 0},
+		{"exit code", []string{"sh", "-c", "echo failing >&2; exit 3"}, "", nil, "failing\n", 3},
+		{"dir", []string{"pwd"}, "/", nil, "/\n", 0},
+		{"env", []string{"sh", "-c", "echo $DEVICE"}, "", []string{"DEVICE=/dev/sda"}, "/dev/sda\n", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := ctor(context.Background(), tt.cmd[0], tt.cmd[1:]...)
+			cmd.SetDir(tt.dir)
+			cmd.SetEnv(tt.env)
+			out, err := cmd.Output()
+			if string(out) != tt.expected {
+				t.Errorf("Expected output %q, got %q", tt.expected, out)
+			}
+			if cdsexec.ExitCode(err) != tt.code {
+				t.Errorf("Expected exit code %d, got %v", tt.code, err)
+			}
+		})
+	}
+}
+
+func TestConsoleCancel(t *testing.T) {
+	for _, noExitStatus := range []bool{false, true} {
+		ctor := consolecmd.New(newFakeTerminal(t), consolecmd.Options{NoExitStatus: noExitStatus})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		err := ctor(ctx, "sh", "-c", "sleep 0.3; echo late").Run()
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+		}
+
+		// The output of the cancelled command arrives while the next command waits.
+		out, err := ctor(context.Background(), "echo", "next").Output()
+		if err != nil || string(out) != "next\n" {
+			t.Errorf("Expected %q with NoExitStatus %v, got %q, %v", "next\n", noExitStatus, out, err)
+		}
+	}
+}
+
+// slowTerminal answers every line typed with chunks written one at a time.
+type slowTerminal struct {
+	io.Reader
+	chunks []string
+	w      *io.PipeWriter
+}
+
+func (s *slowTerminal) Write(p []byte) (int, error) {
+	go func() {
+		for _, chunk := range s.chunks {
+			time.Sleep(20 * time.Millisecond)
+			io.WriteString(s.w, chunk)
+		}
+	}()
+	return len(p), nil
+}
+
+func TestConsolePromptInOutput(t *testing.T) {
+	r, w := io.Pipe()
+	t.Cleanup(func() { r.Close() })
+	term := &slowTerminal{Reader: r, w: w, chunks: []string{
+		"show config; echo \"__cdsexec_status=$?\"\r\n", "cfg# ", "done\r\n__cdsexec_status=0\r\n", "# ",
+	}}
+	out, err := consolecmd.New(term, consolecmd.Options{})(context.Background(), "show", "config").Output()
+	if err != nil || string(out) != "cfg# done\n" {
+		t.Errorf("Expected %q, got %q, %v", "cfg# done\n", out, err)
+	}
+}
+
+func TestConsoleStdin(t *testing.T) {
+	cmd := consolecmd.New(newFakeTerminal(t), consolecmd.Options{})(context.Background(), "cat")
+	cmd.SetStdin(strings.NewReader("data"))
+	if err := cmd.Run(); err == nil {
+		t.Error("Expected an error for standard input, got nil")
+	}
+}
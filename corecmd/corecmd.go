@@ -0,0 +1,221 @@
+package corecmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// Options configures the detection of crashed commands.
+type Options struct {
+	// Dir is where cores are looked for when the core pattern of the system does not tell
+	// where a core went, e.g. the directory a handler of piped cores writes to.
+	Dir string
+}
+
+// CrashError is returned for a command killed by a signal that dumps core, such as SIGSEGV
+// or SIGABRT. It unwraps to the error of the command.
+type CrashError struct {
+	// Name is the name of the command.
+	Name string
+	// PID is the process ID of the command, 0 if unknown.
+	PID int
+	// Signal is the name of the signal, e.g. "SIGSEGV".
+	Signal string
+	// CoreDumped reports whether the kernel dumped a core.
+	CoreDumped bool
+	// Core tells where the core is: its path or, for cores piped to a handler such as
+	// systemd-coredump, how to retrieve it. It is empty if it could not be determined.
+	Core string
+	// Err is the error of the command.
+	Err error
+}
+
+func (e *CrashError) Error() string {
+	msg := fmt.Sprintf("corecmd: %s", e.Name)
+	if e.PID != 0 {
+		msg += fmt.Sprintf(" (pid %d)", e.PID)
+	}
+	msg += " crashed with " + e.Signal
+	switch {
+	case e.CoreDumped && e.Core != "":
+		msg += ", core dumped: " + e.Core
+	case e.CoreDumped:
+		msg += ", core dumped"
+	default:
+		msg += ", no core dumped"
+	}
+	return msg
+}
+
+func (e *CrashError) Unwrap() error { return e.Err }
+
+// coreSignals are the signals whose default action dumps core.
+var coreSignals = map[string]bool{
+	"SIGQUIT": true, "SIGILL": true, "SIGTRAP": true, "SIGABRT": true, "SIGBUS": true,
+	"SIGFPE": true, "SIGSEGV": true, "SIGSYS": true, "SIGXCPU": true, "SIGXFSZ": true,
+}
+
+// New returns a CommandConstructor whose commands built by base return a *CrashError when
+// they are killed by a signal that dumps core, pointing at the core when one was dumped.
+// Whether the kernel dumps cores depends on RLIMIT_CORE, which preexec.WithCoreDumps sets,
+// and where it writes them on its core pattern.
+func New(base cdsexec.CommandConstructor, opts Options) cdsexec.CommandConstructor {
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		err := next(inv)
+		if err == nil {
+			return nil
+		}
+		if ce := crash(inv, err, opts); ce != nil {
+			return ce
+		}
+		return err
+	})
+}
+
+// waitStatus is implemented by the syscall.WaitStatus of every platform.
+type waitStatus interface {
+	Signaled() bool
+	Signal() syscall.Signal
+	CoreDump() bool
+}
+
+func crash(inv *cdsexec.Invocation, err error, opts Options) *CrashError {
+	ce := &CrashError{Name: inv.Spec.Name, Err: err}
+	var xe *cdsexec.ExitError
+	if cmd := inv.Commander(); cmd != nil && cmd.ProcessState() != nil {
+		ws, ok := cmd.ProcessState().Sys().(waitStatus)
+		if !ok || !ws.Signaled() {
+			return nil
+		}
+		ce.PID = cmd.ProcessState().Pid()
+		ce.Signal = signalName(ws.Signal())
+		ce.CoreDumped = ws.CoreDump()
+	} else if errors.As(err, &xe) && xe.Signal != "" {
+		// Remote backends name the signal without its prefix.
+		ce.Signal = "SIG" + strings.TrimPrefix(xe.Signal, "SIG")
+	} else {
+		return nil
+	}
+	if !coreSignals[ce.Signal] {
+		return nil
+	}
+	if ce.CoreDumped {
+		dir := inv.Spec.Dir
+		if dir == "" {
+			dir, _ = os.Getwd()
+		}
+		ce.Core = locateCore(inv.Spec.Name, ce.PID, dir, opts.Dir)
+	}
+	return ce
+}
+
+// signalNames maps the core-dumping signals to their names, which the String method of
+// syscall.Signal does not give.
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGQUIT: "SIGQUIT", syscall.SIGILL: "SIGILL", syscall.SIGTRAP: "SIGTRAP",
+	syscall.SIGABRT: "SIGABRT", syscall.SIGBUS: "SIGBUS", syscall.SIGFPE: "SIGFPE",
+	syscall.SIGSEGV: "SIGSEGV",
+}
+
+func signalName(sig syscall.Signal) string {
+	if name, ok := signalNames[sig]; ok {
+		return name
+	}
+	return sig.String()
+}
+
+// corePatternFile holds the pattern the Linux kernel names cores after.
+var corePatternFile = "/proc/sys/kernel/core_pattern"
+
+// locateCore returns where the core of the process pid, running name in dir, went, or
+// the most recent file of hintDir mentioning pid.
+func locateCore(name string, pid int, dir, hintDir string) string {
+	if data, err := os.ReadFile(corePatternFile); err == nil {
+		pattern := strings.TrimSpace(string(data))
+		if handler, ok := strings.CutPrefix(pattern, "|"); ok {
+			if strings.Contains(handler, "systemd-coredump") {
+				return fmt.Sprintf("coredumpctl info %d", pid)
+			}
+		} else if path := expandCorePattern(pattern, name, pid); path != "" {
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dir, path)
+			}
+			if newest := newestMatch(path); newest != "" {
+				return newest
+			}
+		}
+	}
+	if hintDir != "" && pid != 0 {
+		return newestMatch(filepath.Join(hintDir, "*"+strconv.Itoa(pid)+"*"))
+	}
+	return ""
+}
+
+// expandCorePattern expands the specifiers of a core pattern known from the process into
+// a glob, matching the others with a wildcard.
+func expandCorePattern(pattern, name string, pid int) string {
+	comm := filepath.Base(name)
+	if len(comm) > 15 {
+		comm = comm[:15]
+	}
+	var b strings.Builder
+	hasPID := false
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		if c != '%' || i+1 == len(pattern) {
+			b.WriteString(globEscape(string(c)))
+			continue
+		}
+		i++
+		switch pattern[i] {
+		case '%':
+			b.WriteByte('%')
+		case 'p', 'P', 'i', 'I':
+			b.WriteString(strconv.Itoa(pid))
+			hasPID = true
+		case 'e':
+			b.WriteString(globEscape(comm))
+		default:
+			b.WriteByte('*')
+		}
+	}
+	if !hasPID && pid != 0 {
+		if data, err := os.ReadFile("/proc/sys/kernel/core_uses_pid"); err == nil && strings.TrimSpace(string(data)) == "1" {
+			b.WriteString("." + strconv.Itoa(pid))
+		}
+	}
+	return b.String()
+}
+
+func globEscape(s string) string {
+	r := strings.NewReplacer(`*`, `\*`, `?`, `\?`, `[`, `\[`, `\`, `\\`)
+	return r.Replace(s)
+}
+
+// newestMatch returns the most recently modified file matching glob.
+func newestMatch(glob string) string {
+	matches, _ := filepath.Glob(glob)
+	type file struct {
+		path string
+		mod  int64
+	}
+	var files []file
+	for _, m := range matches {
+		if fi, err := os.Stat(m); err == nil && fi.Mode().IsRegular() {
+			files = append(files, file{m, fi.ModTime().UnixNano()})
+		}
+	}
+	if len(files) == 0 {
+		return ""
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].mod > files[j].mod })
+	return files[0].path
+}
@@ -0,0 +1,75 @@
+package corecmd_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/corecmd"
+	"github.com/cirrusdata/cdsexec/preexec"
+)
+
+func TestCrash(t *testing.T) {
+	dir := t.TempDir()
+	ctor := corecmd.New(preexec.New(cdsexec.CommandContext, preexec.WithCoreDumps(true)), corecmd.Options{Dir: dir})
+	cmd := ctor(context.Background(), "sh", "-c", "kill -SEGV $$")
+	cmd.SetDir(dir)
+	err := cmd.Run()
+
+	var ce *corecmd.CrashError
+	if !errors.As(err, &ce) {
+		t.Fatalf("Expected *CrashError, got %v", err)
+	}
+	var ee *exec.ExitError
+	if !errors.As(err, &ee) || ce.Signal != "SIGSEGV" || ce.PID == 0 {
+		t.Errorf("Expected SIGSEGV of the process, got %+v", ce)
+	}
+	if !ce.CoreDumped {
+		t.Skipf("No core dumped in this environment: %v", err)
+	}
+	if ce.Core == "" || !strings.HasPrefix(ce.Core, dir) && !strings.HasPrefix(ce.Core, "coredumpctl") {
+		t.Errorf("Expected the core to be located, got %q", ce.Core)
+	}
+	if _, err := os.Stat(ce.Core); err != nil && !strings.HasPrefix(ce.Core, "coredumpctl") {
+		t.Errorf("Expected the core to exist: %v", err)
+	}
+	if !strings.Contains(err.Error(), "crashed with SIGSEGV, core dumped") {
+		t.Errorf("Unexpected message %q", err.Error())
+	}
+}
+
+func TestCrashDisabled(t *testing.T) {
+	dir := t.TempDir()
+	ctor := corecmd.New(preexec.New(cdsexec.CommandContext, preexec.WithCoreDumps(false)), corecmd.Options{})
+	cmd := ctor(context.Background(), "sh", "-c", "kill -ABRT $$")
+	cmd.SetDir(dir)
+	var ce *corecmd.CrashError
+	if err := cmd.Run(); !errors.As(err, &ce) || ce.CoreDumped || ce.Signal != "SIGABRT" {
+		t.Fatalf("Expected a crash without core, got %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Expected no core in %s, got %v", dir, entries)
+	}
+}
+
+func TestNotACrash(t *testing.T) {
+	ctor := corecmd.New(cdsexec.CommandContext, corecmd.Options{})
+	for _, script := range []string{"exit 3", "kill -TERM $$"} {
+		var ce *corecmd.CrashError
+		if err := ctor(context.Background(), "sh", "-c", script).Run(); err == nil || errors.As(err, &ce) {
+			t.Errorf("Expected %q to fail without *CrashError, got %v", script, err)
+		}
+	}
+
+	remote := cdsexec.StreamConstructor(func(ctx context.Context, spec cdsexec.CommandSpec) error {
+		return &cdsexec.ExitError{Code: -1, Signal: "SEGV"}
+	})
+	var ce *corecmd.CrashError
+	if err := corecmd.New(remote, corecmd.Options{})(context.Background(), "vendor-cli").Run(); !errors.As(err, &ce) || ce.Signal != "SIGSEGV" {
+		t.Errorf("Expected a remote crash to be detected, got %v", err)
+	}
+}
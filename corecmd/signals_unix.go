@@ -0,0 +1,11 @@
+//go:build unix
+
+package corecmd
+
+import "syscall"
+
+func init() {
+	signalNames[syscall.SIGSYS] = "SIGSYS"
+	signalNames[syscall.SIGXCPU] = "SIGXCPU"
+	signalNames[syscall.SIGXFSZ] = "SIGXFSZ"
+}
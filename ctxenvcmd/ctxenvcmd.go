@@ -0,0 +1,56 @@
+package ctxenvcmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// Var maps a value carried by the context to an environment variable.
+type Var struct {
+	// Name is the environment variable to set.
+	Name string
+	// Value extracts the value from the context. The variable is left alone when ok is false.
+	Value func(ctx context.Context) (value string, ok bool)
+}
+
+// FromValue returns a Var that sets name to ctx.Value(key), formatted with fmt.Sprint.
+// Nil and empty values are skipped.
+func FromValue(name string, key any) Var {
+	return Var{Name: name, Value: func(ctx context.Context) (string, bool) {
+		v := ctx.Value(key)
+		if v == nil {
+			return "", false
+		}
+		s := fmt.Sprint(v)
+		return s, s != ""
+	}}
+}
+
+type disabledKey struct{}
+
+// Disable returns a context under which commands are spawned without the injected variables.
+func Disable(ctx context.Context) context.Context {
+	return context.WithValue(ctx, disabledKey{}, true)
+}
+
+// New returns a CommandConstructor that sets vars in the environment of every command built
+// by base, replacing variables of the same name set by the caller.
+func New(base cdsexec.CommandConstructor, vars ...Var) cdsexec.CommandConstructor {
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		if disabled, _ := inv.Ctx.Value(disabledKey{}).(bool); disabled {
+			return next(inv)
+		}
+		var kv []string
+		for _, v := range vars {
+			if value, ok := v.Value(inv.Ctx); ok {
+				kv = append(kv, v.Name+"="+value)
+			}
+		}
+		if len(kv) > 0 {
+			inv.Spec.Env = cdsexec.MergeEnv(inv.Spec.Env, kv...)
+		}
+		return next(inv)
+	})
+}
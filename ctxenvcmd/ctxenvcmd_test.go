@@ -0,0 +1,55 @@
+package ctxenvcmd_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/ctxenvcmd"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+type ctxKey string
+
+func TestPropagation(t *testing.T) {
+	var got []string
+	base := mockcmd.MakeMockCmdWithOutput("", func(m *mockcmd.MockCmd) error {
+		got = m.Env
+		return nil
+	})
+	ctor := ctxenvcmd.New(base,
+		ctxenvcmd.FromValue("CDS_REQUEST_ID", ctxKey("request")),
+		ctxenvcmd.FromValue("CDS_TENANT", ctxKey("tenant")),
+	)
+	ctx := context.WithValue(context.Background(), ctxKey("request"), "req-42")
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		request string
+		tenant  string
+	}{
+		{"present values are injected", ctx, "req-42", ""},
+		{"nested values", context.WithValue(ctx, ctxKey("tenant"), 7), "req-42", "7"},
+		{"disabled", ctxenvcmd.Disable(ctx), "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = nil
+			cmd := ctor(tt.ctx, "helper.sh")
+			cmd.SetEnv([]string{"HOME=/root"})
+			if err := cmd.Run(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if v, _ := cdsexec.LookupEnv(got, "CDS_REQUEST_ID"); v != tt.request {
+				t.Errorf("Expected CDS_REQUEST_ID=%q, got %q", tt.request, v)
+			}
+			if v, _ := cdsexec.LookupEnv(got, "CDS_TENANT"); v != tt.tenant {
+				t.Errorf("Expected CDS_TENANT=%q, got %q", tt.tenant, v)
+			}
+			if v, _ := cdsexec.LookupEnv(got, "HOME"); v != "/root" {
+				t.Errorf("Expected caller env to be kept, got %v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,305 @@
+package dag
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// ErrInvalid matches the errors of graphs with duplicate nodes, unknown dependencies or
+// cycles.
+var ErrInvalid = errors.New("dag: invalid graph")
+
+// Status is the outcome of a node.
+type Status int
+
+const (
+	// Pending nodes have not completed yet; no node of a finished Run is pending.
+	Pending Status = iota
+	// Succeeded nodes ran successfully.
+	Succeeded
+	// Failed nodes ran and failed.
+	Failed
+	// Skipped nodes did not run because a node they depend on did not succeed.
+	Skipped
+	// Canceled nodes did not run because the context was done.
+	Canceled
+)
+
+func (s Status) String() string {
+	switch s {
+	case Succeeded:
+		return "succeeded"
+	case Failed:
+		return "failed"
+	case Skipped:
+		return "skipped"
+	case Canceled:
+		return "canceled"
+	}
+	return "pending"
+}
+
+type node struct {
+	name  string
+	spec  cdsexec.CommandSpec
+	after []string
+}
+
+// Graph is a set of commands with dependencies between them.
+type Graph struct {
+	// Constructor constructs the commands. Nil means cdsexec.CommandContext.
+	Constructor cdsexec.CommandConstructor
+	// Parallelism bounds the number of commands running at once. Zero means no bound.
+	Parallelism int
+
+	nodes []node
+}
+
+// Add adds the node name running spec once all nodes in after have succeeded. Nodes may be
+// added in any order; the graph is validated by Run.
+func (g *Graph) Add(name string, spec cdsexec.CommandSpec, after ...string) *Graph {
+	g.nodes = append(g.nodes, node{name: name, spec: spec, after: after})
+	return g
+}
+
+// NodeReport is the outcome of a node.
+type NodeReport struct {
+	Name   string
+	Status Status
+	// Result is set for the nodes that ran. The output of commands whose spec does not set
+	// Stdout or Stderr is collected in it.
+	Result cdsexec.Result
+	// Err is the error of failed and canceled nodes.
+	Err error
+}
+
+// Report holds the outcome of every node, in topological order.
+type Report []NodeReport
+
+// Node returns the report of the node name.
+func (r Report) Node(name string) (NodeReport, bool) {
+	for _, nr := range r {
+		if nr.Name == name {
+			return nr, true
+		}
+	}
+	return NodeReport{}, false
+}
+
+// order returns the indexes of the nodes in topological order, keeping the order they
+// were added in among independent nodes.
+func (g *Graph) order() ([]int, error) {
+	index := make(map[string]int, len(g.nodes))
+	for i, n := range g.nodes {
+		if _, ok := index[n.name]; ok {
+			return nil, fmt.Errorf("%w: duplicate node %q", ErrInvalid, n.name)
+		}
+		index[n.name] = i
+	}
+	indegree := make([]int, len(g.nodes))
+	for i, n := range g.nodes {
+		for _, dep := range n.after {
+			if _, ok := index[dep]; !ok {
+				return nil, fmt.Errorf("%w: node %q depends on unknown node %q", ErrInvalid, n.name, dep)
+			}
+			indegree[i]++
+		}
+	}
+	var order []int
+	done := make([]bool, len(g.nodes))
+	for len(order) < len(g.nodes) {
+		progress := false
+		for i, n := range g.nodes {
+			if done[i] || indegree[i] > 0 {
+				continue
+			}
+			done[i] = true
+			progress = true
+			order = append(order, i)
+			for j, m := range g.nodes {
+				for _, dep := range m.after {
+					if dep == n.name {
+						indegree[j]--
+					}
+				}
+			}
+		}
+		if !progress {
+			var cycle []string
+			for i, n := range g.nodes {
+				if !done[i] {
+					cycle = append(cycle, n.name)
+				}
+			}
+			return nil, fmt.Errorf("%w: cycle between %s", ErrInvalid, strings.Join(cycle, ", "))
+		}
+	}
+	return order, nil
+}
+
+// Run runs every node once all nodes it depends on have succeeded, running independent
+// nodes concurrently. A failed node does not stop the nodes that do not depend on it; its
+// dependents are skipped. Once ctx is done no further node is started.
+//
+// Run returns the report of every node and nil if all succeeded, an *Error if some did
+// not, or an error matching ErrInvalid, without running anything, if the graph is invalid.
+func (g *Graph) Run(ctx context.Context) (Report, error) {
+	order, err := g.order()
+	if err != nil {
+		return nil, err
+	}
+	ctor := g.Constructor
+	if ctor == nil {
+		ctor = cdsexec.CommandContext
+	}
+	pos := make(map[string]int, len(order))
+	report := make(Report, len(order))
+	for p, i := range order {
+		pos[g.nodes[i].name] = p
+		report[p] = NodeReport{Name: g.nodes[i].name}
+	}
+	// waiting counts the dependencies of each node that have not succeeded yet.
+	waiting := make([]int, len(order))
+	dependents := make([][]int, len(order))
+	for p, i := range order {
+		for _, dep := range g.nodes[i].after {
+			waiting[p]++
+			dependents[pos[dep]] = append(dependents[pos[dep]], p)
+		}
+	}
+
+	type completion struct {
+		p      int
+		result cdsexec.Result
+		err    error
+	}
+	done := make(chan completion)
+	var ready []int
+	for p := range order {
+		if waiting[p] == 0 {
+			ready = append(ready, p)
+		}
+	}
+	running, finished := 0, 0
+	for finished < len(order) {
+		for len(ready) > 0 && (g.Parallelism <= 0 || running < g.Parallelism) {
+			p := ready[0]
+			ready = ready[1:]
+			if err := ctx.Err(); err != nil {
+				report[p].Status, report[p].Err = Canceled, err
+				finished += 1 + skip(report, dependents, p)
+				continue
+			}
+			running++
+			go func(p int, spec cdsexec.CommandSpec) {
+				var r cdsexec.Result
+				err := run(ctx, ctor, spec, &r)
+				done <- completion{p: p, result: r, err: err}
+			}(p, g.nodes[order[p]].spec)
+		}
+		if running == 0 {
+			break
+		}
+		c := <-done
+		running--
+		finished++
+		report[c.p].Result, report[c.p].Err = c.result, c.err
+		if c.err != nil {
+			report[c.p].Status = Failed
+			finished += skip(report, dependents, c.p)
+			continue
+		}
+		report[c.p].Status = Succeeded
+		for _, d := range dependents[c.p] {
+			if waiting[d]--; waiting[d] == 0 && report[d].Status == Pending {
+				ready = append(ready, d)
+			}
+		}
+	}
+
+	var failed []NodeReport
+	for _, nr := range report {
+		if nr.Status != Succeeded {
+			failed = append(failed, nr)
+		}
+	}
+	if len(failed) > 0 {
+		return report, &Error{Nodes: failed}
+	}
+	return report, nil
+}
+
+// skip marks the pending transitive dependents of p as skipped and returns their number.
+func skip(report Report, dependents [][]int, p int) int {
+	n := 0
+	for _, d := range dependents[p] {
+		if report[d].Status != Pending {
+			continue
+		}
+		report[d].Status = Skipped
+		n += 1 + skip(report, dependents, d)
+	}
+	return n
+}
+
+func run(ctx context.Context, ctor cdsexec.CommandConstructor, spec cdsexec.CommandSpec, r *cdsexec.Result) error {
+	var stdout, stderr *bytes.Buffer
+	if spec.Stdout == nil {
+		stdout = new(bytes.Buffer)
+		spec.Stdout = stdout
+	}
+	if spec.Stderr == nil {
+		stderr = new(bytes.Buffer)
+		spec.Stderr = stderr
+	}
+	clock := cdsexec.ClockFromContext(ctx)
+	*r = cdsexec.Result{Name: spec.Name, Args: spec.Args, Dir: spec.Dir, Env: spec.Env, StartTime: clock.Now()}
+	cmd := spec.Command(ctx, ctor)
+	err := cmd.Run()
+	r.Duration = clock.Now().Sub(r.StartTime)
+	r.ExitCode = cdsexec.ExitCode(err)
+	if state := cmd.ProcessState(); state != nil {
+		r.ExitCode = state.ExitCode()
+	}
+	if stdout != nil {
+		r.Stdout = stdout.Bytes()
+	}
+	if stderr != nil {
+		r.Stderr = stderr.Bytes()
+	}
+	return err
+}
+
+// Error reports the nodes of a graph that did not succeed.
+type Error struct {
+	// Nodes are the reports of the failed, skipped and canceled nodes, in topological order.
+	Nodes []NodeReport
+}
+
+func (e *Error) Error() string {
+	parts := make([]string, len(e.Nodes))
+	for i, nr := range e.Nodes {
+		if nr.Err != nil {
+			parts[i] = fmt.Sprintf("%s %s: %v", nr.Name, nr.Status, nr.Err)
+		} else {
+			parts[i] = nr.Name + " " + nr.Status.String()
+		}
+	}
+	return "dag: " + strings.Join(parts, "; ")
+}
+
+// Unwrap returns the errors of the failed and canceled nodes.
+func (e *Error) Unwrap() []error {
+	var errs []error
+	for _, nr := range e.Nodes {
+		if nr.Err != nil {
+			errs = append(errs, nr.Err)
+		}
+	}
+	return errs
+}
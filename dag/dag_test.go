@@ -0,0 +1,197 @@
+package dag_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/dag"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+func spec(name string, args ...string) cdsexec.CommandSpec {
+	return cdsexec.CommandSpec{Name: name, Args: args}
+}
+
+// volumeStack is two encrypted loop devices assembled into a mirror and mounted.
+func volumeStack(ctor cdsexec.CommandConstructor) *dag.Graph {
+	g := &dag.Graph{Constructor: ctor}
+	return g.Add("mount", spec("mount", "/dev/md0", "/srv"), "mdadm").
+		Add("loop0", spec("losetup", "/dev/loop0", "/img/a")).
+		Add("loop1", spec("losetup", "/dev/loop1", "/img/b")).
+		Add("crypt0", spec("cryptsetup", "open", "/dev/loop0", "c0"), "loop0").
+		Add("crypt1", spec("cryptsetup", "open", "/dev/loop1", "c1"), "loop1").
+		Add("mdadm", spec("mdadm", "--assemble", "/dev/md0"), "crypt0", "crypt1")
+}
+
+// timeline is a mock constructor recording when each command starts and ends.
+type timeline struct {
+	mu     sync.Mutex
+	events []string
+	fail   string
+}
+
+func (tl *timeline) ctor() cdsexec.CommandConstructor {
+	return mockcmd.MakeMockCmdWithOutput("", func(m *mockcmd.MockCmd) error {
+		id := m.Name + " " + m.Args[0]
+		tl.record("start " + id)
+		time.Sleep(5 * time.Millisecond)
+		tl.record("end " + id)
+		if id == tl.fail {
+			return &cdsexec.ExitError{Code: 1}
+		}
+		return nil
+	})
+}
+
+func (tl *timeline) record(e string) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	tl.events = append(tl.events, e)
+}
+
+func (tl *timeline) index(e string) int {
+	for i, x := range tl.events {
+		if x == e {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestRun(t *testing.T) {
+	tl := &timeline{}
+	report, err := volumeStack(tl.ctor()).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(report))
+	for i, nr := range report {
+		names[i] = nr.Name
+		if nr.Status != dag.Succeeded {
+			t.Errorf("Expected %s to succeed, got %s", nr.Name, nr.Status)
+		}
+	}
+	if got := strings.Join(names, " "); got != "loop0 loop1 crypt0 crypt1 mdadm mount" {
+		t.Errorf("Expected the report in topological order, got %s", got)
+	}
+	deps := [][2]string{
+		{"end losetup /dev/loop0", "start cryptsetup open"},
+		{"end mdadm --assemble", "start mount /dev/md0"},
+	}
+	for _, d := range deps {
+		if tl.index(d[0]) > tl.index(d[1]) {
+			t.Errorf("Expected %q before %q, got %q", d[0], d[1], tl.events)
+		}
+	}
+	if tl.index("start losetup /dev/loop1") > tl.index("end losetup /dev/loop0") {
+		t.Errorf("Expected independent nodes to run concurrently, got %q", tl.events)
+	}
+}
+
+func TestFailure(t *testing.T) {
+	tl := &timeline{fail: "cryptsetup open"}
+	g := &dag.Graph{Constructor: tl.ctor()}
+	g.Add("loop0", spec("losetup", "/dev/loop0", "/img/a")).
+		Add("crypt0", spec("cryptsetup", "open", "/dev/loop0", "c0"), "loop0").
+		Add("mount", spec("mount", "/dev/mapper/c0", "/srv"), "crypt0").
+		Add("sysctl", spec("sysctl", "-w", "vm.dirty_ratio=10"))
+	report, err := g.Run(context.Background())
+
+	want := map[string]dag.Status{"loop0": dag.Succeeded, "crypt0": dag.Failed, "mount": dag.Skipped, "sysctl": dag.Succeeded}
+	for name, status := range want {
+		if nr, _ := report.Node(name); nr.Status != status {
+			t.Errorf("Expected %s to be %s, got %s", name, status, nr.Status)
+		}
+	}
+	var de *dag.Error
+	if !errors.As(err, &de) || len(de.Nodes) != 2 || cdsexec.ExitCode(err) != 1 {
+		t.Fatalf("Expected *dag.Error for crypt0 and mount, got %v", err)
+	}
+	if err.Error() != "dag: crypt0 failed: exit status 1; mount skipped" {
+		t.Errorf("Unexpected message %q", err.Error())
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		graph *dag.Graph
+		msg   string
+	}{
+		{"duplicate", new(dag.Graph).Add("a", spec("true")).Add("a", spec("true")), `duplicate node "a"`},
+		{"unknown", new(dag.Graph).Add("a", spec("true"), "b"), `node "a" depends on unknown node "b"`},
+		{"cycle", new(dag.Graph).Add("a", spec("true"), "c").Add("b", spec("true"), "a").Add("c", spec("true"), "b").Add("d", spec("true")), "cycle between a, b, c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.graph.Run(context.Background())
+			if !errors.Is(err, dag.ErrInvalid) || !strings.Contains(err.Error(), tt.msg) {
+				t.Errorf("Expected ErrInvalid with %q, got %v", tt.msg, err)
+			}
+		})
+	}
+}
+
+func TestCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ctor := mockcmd.MakeMockCmdWithOutput("", func(*mockcmd.MockCmd) error {
+		cancel()
+		return nil
+	})
+	g := &dag.Graph{Constructor: ctor}
+	report, err := g.Add("a", spec("true")).Add("b", spec("true"), "a").Add("c", spec("true"), "b").Run(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	statuses := []dag.Status{dag.Succeeded, dag.Canceled, dag.Skipped}
+	for i, nr := range report {
+		if nr.Status != statuses[i] {
+			t.Errorf("Expected %s to be %s, got %s", nr.Name, statuses[i], nr.Status)
+		}
+	}
+}
+
+func TestParallelism(t *testing.T) {
+	var mu sync.Mutex
+	running, peak := 0, 0
+	ctor := mockcmd.MakeMockCmdWithOutput("", func(*mockcmd.MockCmd) error {
+		mu.Lock()
+		running++
+		peak = max(peak, running)
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	})
+	g := &dag.Graph{Constructor: ctor, Parallelism: 2}
+	for _, n := range []string{"a", "b", "c", "d", "e"} {
+		g.Add(n, spec("true"))
+	}
+	if _, err := g.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if peak > 2 {
+		t.Errorf("Expected at most 2 commands at once, got %d", peak)
+	}
+}
+
+func TestResultClock(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	clock := mockcmd.NewFakeClock(start)
+	base := mockcmd.MakeMockCmdWithOutput("", func(*mockcmd.MockCmd) error {
+		clock.Advance(time.Second)
+		return nil
+	})
+	g := (&dag.Graph{Constructor: base}).Add("scan", spec("iscsiadm", "-m", "discovery"))
+	report, err := g.Run(cdsexec.ContextWithClock(context.Background(), clock))
+	if err != nil || !report[0].Result.StartTime.Equal(start) || report[0].Result.Duration != time.Second {
+		t.Errorf("Expected the result to be timed on the clock of the context, got %+v, %v", report, err)
+	}
+}
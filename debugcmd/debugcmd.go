@@ -0,0 +1,54 @@
+package debugcmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// EnvVar enables the debug output of New when set to a true value, e.g. CDSEXEC_DEBUG=1.
+const EnvVar = "CDSEXEC_DEBUG"
+
+// Options configures the debug output.
+type Options struct {
+	// Enabled enables the debug output regardless of EnvVar, e.g. from a command-line flag.
+	Enabled bool
+	// Writer receives the command lines, os.Stderr if nil.
+	Writer io.Writer
+	// Prefix starts every line, "+ " if empty, as with set -x.
+	Prefix string
+}
+
+// Enabled reports whether EnvVar enables the debug output.
+func Enabled() bool {
+	on, _ := strconv.ParseBool(os.Getenv(EnvVar))
+	return on
+}
+
+// New returns a CommandConstructor printing every command built by base, before it runs,
+// as a line that reproduces it when pasted into a shell (see cdsexec.CommandSpec.ShellLine),
+// redacted by the Redactor of its context. Unless opts.Enabled is set or EnvVar enables it,
+// New returns base unchanged.
+func New(base cdsexec.CommandConstructor, opts Options) cdsexec.CommandConstructor {
+	if !opts.Enabled && !Enabled() {
+		return base
+	}
+	if opts.Writer == nil {
+		opts.Writer = os.Stderr
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = "+ "
+	}
+	var mu sync.Mutex
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		line := cdsexec.RedactorFromContext(inv.Ctx).Spec(inv.Spec).ShellLine()
+		mu.Lock()
+		fmt.Fprintf(opts.Writer, "%s%s\n", opts.Prefix, line)
+		mu.Unlock()
+		return next(inv)
+	})
+}
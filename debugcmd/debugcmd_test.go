@@ -0,0 +1,43 @@
+package debugcmd_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/debugcmd"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+func TestDebug(t *testing.T) {
+	var out bytes.Buffer
+	ctor := debugcmd.New(mockcmd.MakeMockCmdWithOutput("", nil), debugcmd.Options{Enabled: true, Writer: &out})
+	ctx := cdsexec.ContextWithRedactor(context.Background(), cdsexec.NewRedactor(cdsexec.RedactFlags("--password")))
+	cmd := ctor(ctx, "iscsiadm", "-m", "node", "--password", "s3cr3t", "-T", "iqn.2001-05.com.example:vol 1")
+	cmd.SetDir("/tmp")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	want := "+ iscsiadm -m node --password '***' -T 'iqn.2001-05.com.example:vol 1' # cwd: /tmp\n"
+	if out.String() != want {
+		t.Errorf("Expected %q, got %q", want, out.String())
+	}
+}
+
+func TestDebugGate(t *testing.T) {
+	var out bytes.Buffer
+	base := mockcmd.MakeMockCmdWithOutput("", nil)
+
+	t.Setenv(debugcmd.EnvVar, "")
+	debugcmd.New(base, debugcmd.Options{Writer: &out})(context.Background(), "true").Run()
+	if out.Len() != 0 {
+		t.Errorf("Expected no output when disabled, got %q", out.String())
+	}
+
+	t.Setenv(debugcmd.EnvVar, "1")
+	debugcmd.New(base, debugcmd.Options{Writer: &out, Prefix: "debug: "})(context.Background(), "true").Run()
+	if out.String() != "debug: true\n" {
+		t.Errorf("Expected output enabled by %s, got %q", debugcmd.EnvVar, out.String())
+	}
+}
@@ -0,0 +1,102 @@
+package dedupcmd
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// Group collapses concurrent identical read-only commands into a single execution.
+type Group struct {
+	readOnly cdsexec.Matcher
+
+	mu     sync.Mutex
+	calls  map[string]*call
+	shared int64
+}
+
+// call is an execution in flight whose outcome is shared with every waiter.
+type call struct {
+	done chan struct{}
+	out  []byte
+	err  error
+}
+
+// NewGroup creates a Group deduplicating the commands matched by readOnly.
+func NewGroup(readOnly cdsexec.Matcher) *Group {
+	return &Group{readOnly: readOnly, calls: make(map[string]*call)}
+}
+
+// Shared returns how many executions were avoided by sharing another one's output.
+func (g *Group) Shared() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.shared
+}
+
+// Wrap returns a CommandConstructor whose Output and CombinedOutput calls join an identical
+// execution already in flight instead of starting a new one. Only read-only commands without
+// stdin, pipes or caller-provided writers are deduplicated. Waiters whose context is done stop
+// waiting; the shared execution itself runs with the context of the first caller.
+func (g *Group) Wrap(base cdsexec.CommandConstructor) cdsexec.CommandConstructor {
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		if !g.eligible(inv) {
+			return next(inv)
+		}
+		key := callKey(inv)
+
+		g.mu.Lock()
+		if c, ok := g.calls[key]; ok {
+			g.shared++
+			g.mu.Unlock()
+			select {
+			case <-c.done:
+				inv.Output = append([]byte(nil), c.out...)
+				return c.err
+			case <-inv.Ctx.Done():
+				return inv.Ctx.Err()
+			}
+		}
+		c := &call{done: make(chan struct{})}
+		g.calls[key] = c
+		g.mu.Unlock()
+
+		// The call is released even if next panics, so that its waiters and the next callers
+		// are not blocked forever.
+		finished := false
+		defer func() {
+			if !finished {
+				c.err = fmt.Errorf("dedupcmd: shared execution of %s panicked", inv.Spec.Name)
+			}
+			g.mu.Lock()
+			delete(g.calls, key)
+			g.mu.Unlock()
+			close(c.done)
+		}()
+		c.err = next(inv)
+		c.out = inv.Output
+		finished = true
+		return c.err
+	})
+}
+
+// New is a shorthand for NewGroup followed by Wrap.
+func New(base cdsexec.CommandConstructor, readOnly cdsexec.Matcher) cdsexec.CommandConstructor {
+	return NewGroup(readOnly).Wrap(base)
+}
+
+func (g *Group) eligible(inv *cdsexec.Invocation) bool {
+	if inv.Kind != cdsexec.CallOutput && inv.Kind != cdsexec.CallCombinedOutput {
+		return false
+	}
+	if !inv.Replayable() || inv.Spec.Stdin != nil || inv.Spec.Stdout != nil || inv.Spec.Stderr != nil {
+		return false
+	}
+	return g.readOnly(inv.Spec)
+}
+
+// callKey identifies identical invocations.
+func callKey(inv *cdsexec.Invocation) string {
+	return inv.Kind.String() + ":" + inv.Spec.Fingerprint()
+}
@@ -0,0 +1,126 @@
+package dedupcmd_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/dedupcmd"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+func TestDeduplicatesConcurrentCalls(t *testing.T) {
+	var executions int32
+	release := make(chan struct{})
+	base := mockcmd.MakeMockCmdWithOutput(`{"blockdevices":[]}`, func(*mockcmd.MockCmd) error {
+		atomic.AddInt32(&executions, 1)
+		<-release
+		return nil
+	})
+	g := dedupcmd.NewGroup(cdsexec.MatchCommand("lsblk", "-J"))
+	ctor := g.Wrap(base)
+
+	const callers = 10
+	outputs := make([]string, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			out, err := ctor(context.Background(), "lsblk", "-J").Output()
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			outputs[i] = string(out)
+		}(i)
+	}
+	for g.Shared() < callers-1 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if executions != 1 {
+		t.Errorf("Expected 1 execution, got %d", executions)
+	}
+	for i, out := range outputs {
+		if out != `{"blockdevices":[]}` {
+			t.Errorf("Caller %d got %q", i, out)
+		}
+	}
+}
+
+func TestSkipsIneligibleCommands(t *testing.T) {
+	var executions int32
+	base := mockcmd.MakeMockCmdWithOutput("", func(*mockcmd.MockCmd) error {
+		atomic.AddInt32(&executions, 1)
+		return nil
+	})
+	ctor := dedupcmd.New(base, cdsexec.MatchBinary("lsblk"))
+
+	ctor(context.Background(), "lsblk").Output()
+	ctor(context.Background(), "lsblk").Run()
+	ctor(context.Background(), "wipefs", "-a", "/dev/sdb").Output()
+	if executions != 3 {
+		t.Errorf("Expected 3 executions, got %d", executions)
+	}
+}
+
+func TestWaiterHonorsContext(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	ctor := dedupcmd.New(mockcmd.MakeMockCmdWithOutput("", func(*mockcmd.MockCmd) error {
+		close(entered)
+		<-release
+		return nil
+	}), cdsexec.MatchBinary("lsblk"))
+
+	go ctor(context.Background(), "lsblk").Output()
+	<-entered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := ctor(ctx, "lsblk").Output(); err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestReleasesPanickingCall(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	var executions int32
+	base := mockcmd.MakeMockCmdWithOutput("", func(*mockcmd.MockCmd) error {
+		if atomic.AddInt32(&executions, 1) == 1 {
+			close(entered)
+			<-release
+			panic("lsblk parser bug")
+		}
+		return nil
+	})
+	g := dedupcmd.NewGroup(cdsexec.MatchCommand("lsblk"))
+	ctor := g.Wrap(base)
+
+	go func() {
+		defer func() { recover() }()
+		ctor(context.Background(), "lsblk").Output()
+	}()
+	<-entered
+	waiter := make(chan error)
+	go func() {
+		_, err := ctor(context.Background(), "lsblk").Output()
+		waiter <- err
+	}()
+	for g.Shared() < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	if err := <-waiter; err == nil {
+		t.Errorf("Expected the waiter to fail with the panicking call")
+	}
+	if _, err := ctor(context.Background(), "lsblk").Output(); err != nil || atomic.LoadInt32(&executions) != 2 {
+		t.Errorf("Expected the next call to run again, got %v after %d executions", err, atomic.LoadInt32(&executions))
+	}
+}
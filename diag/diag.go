@@ -0,0 +1,210 @@
+package diag
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/history"
+)
+
+// DefaultTailSize is the amount of standard output and standard error kept by default.
+const DefaultTailSize = 64 << 10
+
+// Options configures the bundles.
+type Options struct {
+	// Dir receives one JSON file per failure.
+	Dir string
+	// Writer receives every bundle as a line of JSON.
+	Writer io.Writer
+	// Func receives every bundle.
+	Func func(*Bundle)
+	// OnError is called when a bundle could not be written.
+	OnError func(error)
+
+	// TailSize bounds the output kept from each stream, DefaultTailSize if 0.
+	TailSize int
+	// History, if set, provides the recent executions of the same binary. They include the
+	// failed execution if the Recorder wraps the constructor passed to New.
+	History *history.Recorder
+	// HistoryWindow bounds how far back the history goes, 10 minutes if 0.
+	HistoryWindow time.Duration
+	// HistoryLimit bounds the number of history entries, 20 if 0.
+	HistoryLimit int
+}
+
+// EnvDiff is the difference between the environment of a command and that of the current
+// process, with secret values redacted.
+type EnvDiff struct {
+	Added   []string `json:"added,omitempty"`
+	Changed []string `json:"changed,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// Bundle describes a failed command.
+type Bundle struct {
+	Time      time.Time       `json:"time"`
+	Name      string          `json:"name"`
+	Args      []string        `json:"args"`
+	Dir       string          `json:"dir,omitempty"`
+	Env       EnvDiff         `json:"env"`
+	StartTime time.Time       `json:"start_time"`
+	Duration  time.Duration   `json:"duration"`
+	ExitCode  int             `json:"exit_code"`
+	Error     string          `json:"error"`
+	Stdout    string          `json:"stdout,omitempty"`
+	Stderr    string          `json:"stderr,omitempty"`
+	History   []history.Entry `json:"history,omitempty"`
+}
+
+// WriteTo writes the bundle as indented JSON.
+func (b *Bundle) WriteTo(w io.Writer) (int64, error) {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(append(data, '\n'))
+	return int64(n), err
+}
+
+// New returns a CommandConstructor assembling a Bundle whenever a command built by base
+// fails, and delivering it as configured by opts.
+func New(base cdsexec.CommandConstructor, opts Options) cdsexec.CommandConstructor {
+	if opts.TailSize <= 0 {
+		opts.TailSize = DefaultTailSize
+	}
+	if opts.HistoryWindow <= 0 {
+		opts.HistoryWindow = 10 * time.Minute
+	}
+	if opts.HistoryLimit <= 0 {
+		opts.HistoryLimit = 20
+	}
+	var mu sync.Mutex
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		stdout := cdsexec.NewRingBuffer(opts.TailSize)
+		stderr := cdsexec.NewRingBuffer(opts.TailSize)
+		inv.TeeStdout(stdout)
+		inv.TeeStderr(stderr)
+		err := next(inv)
+		if err == nil {
+			return nil
+		}
+
+		res := inv.Result(err)
+		if stdout.Written() == 0 {
+			stdout.Write(res.Stdout)
+		}
+		if stderr.Written() == 0 {
+			stderr.Write(res.Stderr)
+		}
+		b := newBundle(inv, res, err, stdout.Bytes(), stderr.Bytes())
+		if opts.History != nil {
+			b.History = opts.History.Query(history.Query{
+				Name:  inv.Spec.Name,
+				Since: b.Time.Add(-opts.HistoryWindow),
+				Limit: opts.HistoryLimit,
+			})
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if werr := opts.deliver(b); werr != nil && opts.OnError != nil {
+			opts.OnError(werr)
+		}
+		return err
+	})
+}
+
+func newBundle(inv *cdsexec.Invocation, res cdsexec.Result, err error, stdout, stderr []byte) *Bundle {
+	redactor := cdsexec.RedactorFromContext(inv.Ctx)
+	spec := redactor.Spec(inv.Spec)
+	b := &Bundle{
+		Time:      cdsexec.ClockFromContext(inv.Ctx).Now(),
+		Name:      spec.Name,
+		Args:      spec.Args,
+		Dir:       spec.Dir,
+		StartTime: res.StartTime,
+		Duration:  res.Duration,
+		ExitCode:  res.ExitCode,
+		Error:     redactor.Error(err).Error(),
+		Stdout:    redactor.Text(string(stdout)),
+		Stderr:    redactor.Text(string(stderr)),
+	}
+	if spec.Env != nil {
+		b.Env = diffEnv(redactor.Env(os.Environ()), spec.Env)
+	}
+	return b
+}
+
+// diffEnv compares env to the environment base.
+func diffEnv(base, env []string) EnvDiff {
+	baseVars := make(map[string]string, len(base))
+	for _, kv := range base {
+		k, v, _ := strings.Cut(kv, "=")
+		baseVars[k] = v
+	}
+	var d EnvDiff
+	seen := make(map[string]bool, len(env))
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		seen[k] = true
+		old, ok := baseVars[k]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, kv)
+		case old != v:
+			d.Changed = append(d.Changed, kv)
+		}
+	}
+	for k := range baseVars {
+		if !seen[k] {
+			d.Removed = append(d.Removed, k)
+		}
+	}
+	sort.Strings(d.Removed)
+	return d
+}
+
+func (o *Options) deliver(b *Bundle) error {
+	if o.Func != nil {
+		o.Func(b)
+	}
+	var errs []error
+	if o.Writer != nil {
+		data, err := json.Marshal(b)
+		if err == nil {
+			_, err = o.Writer.Write(append(data, '\n'))
+		}
+		errs = append(errs, err)
+	}
+	if o.Dir != "" {
+		errs = append(errs, writeFile(o.Dir, b))
+	}
+	return errors.Join(errs...)
+}
+
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// writeFile writes b to a new file of dir named after the time and the binary.
+func writeFile(dir string, b *Bundle) error {
+	name := fmt.Sprintf("cdsexec-diag-%s-%s-*.json",
+		b.Time.UTC().Format("20060102T150405"), unsafeChars.ReplaceAllString(filepath.Base(b.Name), "_"))
+	f, err := os.CreateTemp(dir, name)
+	if err != nil {
+		return err
+	}
+	if _, err := b.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
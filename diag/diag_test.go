@@ -0,0 +1,96 @@
+package diag_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/diag"
+	"github.com/cirrusdata/cdsexec/history"
+)
+
+func TestBundle(t *testing.T) {
+	t.Setenv("CDSEXEC_DIAG_KEEP", "1")
+	t.Setenv("CDSEXEC_DIAG_CHANGE", "old")
+	t.Setenv("CDSEXEC_DIAG_DROP", "1")
+
+	rec := history.NewRecorder(10)
+	dir := t.TempDir()
+	var lines bytes.Buffer
+	var bundles []*diag.Bundle
+	ctor := diag.New(rec.Wrap(cdsexec.CommandContext), diag.Options{
+		Dir:          dir,
+		Writer:       &lines,
+		Func:         func(b *diag.Bundle) { bundles = append(bundles, b) },
+		TailSize:     8,
+		History:      rec,
+		HistoryLimit: 5,
+	})
+	ctx := cdsexec.ContextWithRedactor(context.Background(), cdsexec.NewRedactor(
+		cdsexec.RedactFlags("--password"), cdsexec.RedactEnv("*_SECRET")))
+
+	if err := ctor(ctx, "sh", "-c", "true").Run(); err != nil {
+		t.Fatal(err)
+	}
+	cmd := ctor(ctx, "sh", "-c", "echo 0123456789; echo failed >&2; exit 3", "--password", "s3cr3t")
+	env := []string{"CDSEXEC_DIAG_KEEP=1", "CDSEXEC_DIAG_CHANGE=new", "API_SECRET=hunter2"}
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "CDSEXEC_DIAG_") {
+			env = append(env, kv)
+		}
+	}
+	cmd.SetEnv(env)
+	if err := cmd.Run(); cdsexec.ExitCode(err) != 3 {
+		t.Fatalf("Expected the error of the command, got %v", err)
+	}
+
+	if len(bundles) != 1 {
+		t.Fatalf("Expected one bundle, got %d", len(bundles))
+	}
+	b := bundles[0]
+	if b.ExitCode != 3 || b.Error != "exit status 3" || b.Stdout != "3456789\n" || b.Stderr != "failed\n" {
+		t.Errorf("Unexpected bundle %+v", b)
+	}
+	if b.Args[3] != cdsexec.DefaultMask {
+		t.Errorf("Expected redacted arguments, got %q", b.Args)
+	}
+	expected := diag.EnvDiff{
+		Added:   []string{"API_SECRET=***"},
+		Changed: []string{"CDSEXEC_DIAG_CHANGE=new"},
+		Removed: []string{"CDSEXEC_DIAG_DROP"},
+	}
+	if !reflect.DeepEqual(b.Env, expected) {
+		t.Errorf("Expected env diff %+v, got %+v", expected, b.Env)
+	}
+	if len(b.History) != 2 || b.History[0].ExitCode != 0 || b.History[1].ExitCode != 3 {
+		t.Errorf("Expected the earlier and the failed executions in the history, got %+v", b.History)
+	}
+
+	var fromWriter diag.Bundle
+	if err := json.Unmarshal(lines.Bytes(), &fromWriter); err != nil || fromWriter.Stderr != "failed\n" {
+		t.Errorf("Expected the bundle as JSON, got %s, %v", lines.Bytes(), err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "cdsexec-diag-*-sh-*.json"))
+	if len(files) != 1 {
+		t.Fatalf("Expected one bundle file, got %v", files)
+	}
+	data, _ := os.ReadFile(files[0])
+	if !strings.Contains(string(data), `"exit_code": 3`) {
+		t.Errorf("Unexpected bundle file %s", data)
+	}
+}
+
+func TestBundleOutput(t *testing.T) {
+	var got *diag.Bundle
+	ctor := diag.New(cdsexec.CommandContext, diag.Options{Func: func(b *diag.Bundle) { got = b }})
+	ctor(context.Background(), "sh", "-c", "echo partial; echo boom >&2; exit 1").Output()
+	if got == nil || got.Stdout != "partial\n" || got.Stderr != "boom\n" {
+		t.Errorf("Expected the output returned by Output, got %+v", got)
+	}
+}
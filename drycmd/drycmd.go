@@ -0,0 +1,215 @@
+package drycmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// Record describes a command that would have been executed.
+type Record struct {
+	Spec cdsexec.CommandSpec
+	// Stdin holds the data the command would have read from a stdin set with SetStdin.
+	Stdin []byte
+	Call  cdsexec.CallKind
+	Time  time.Time
+}
+
+// Sink receives the records of would-be executions.
+type Sink interface {
+	Record(Record)
+}
+
+// SinkFunc adapts a function to a Sink.
+type SinkFunc func(Record)
+
+// Record calls f(r).
+func (f SinkFunc) Record(r Record) { f(r) }
+
+// Recorder is a Sink keeping every record in memory.
+type Recorder struct {
+	mu      sync.Mutex
+	records []Record
+}
+
+// Record appends r.
+func (r *Recorder) Record(rec Record) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = append(r.records, rec)
+}
+
+// Records returns the records received so far.
+func (r *Recorder) Records() []Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Record(nil), r.records...)
+}
+
+// WriterSink prints every would-be command line to w.
+func WriterSink(w io.Writer) Sink {
+	var mu sync.Mutex
+	return SinkFunc(func(r Record) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(w, "dry-run: %s\n", r.Spec)
+	})
+}
+
+// Option configures the dry-run constructor.
+type Option func(*config)
+
+type config struct {
+	outputs []cannedOutput
+}
+
+type cannedOutput struct {
+	match  cdsexec.Matcher
+	stdout []byte
+}
+
+// WithOutput makes commands matched by m report stdout as their output. The first matching
+// option wins; other commands produce no output.
+func WithOutput(m cdsexec.Matcher, stdout string) Option {
+	return func(c *config) { c.outputs = append(c.outputs, cannedOutput{match: m, stdout: []byte(stdout)}) }
+}
+
+// New returns a CommandConstructor whose commands record themselves to sink instead of
+// executing, and always succeed. Recorded specs are redacted by the cdsexec.Redactor of the
+// command's context.
+func New(sink Sink, opts ...Option) cdsexec.CommandConstructor {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return func(ctx context.Context, name string, arg ...string) cdsexec.Commander {
+		return &Cmd{
+			ctx:  ctx,
+			spec: cdsexec.CommandSpec{Name: name, Args: arg},
+			sink: sink,
+			cfg:  &cfg,
+		}
+	}
+}
+
+var _ cdsexec.Commander = (*Cmd)(nil)
+
+// Cmd is a Commander that records itself instead of executing.
+type Cmd struct {
+	ctx  context.Context
+	spec cdsexec.CommandSpec
+	sink Sink
+	cfg  *config
+}
+
+func (c *Cmd) stdout() []byte {
+	for _, o := range c.cfg.outputs {
+		if o.match(c.spec) {
+			return o.stdout
+		}
+	}
+	return nil
+}
+
+// record reports the would-be execution and writes the canned output to the configured stdout.
+func (c *Cmd) record(kind cdsexec.CallKind) error {
+	rec := Record{Spec: cdsexec.RedactorFromContext(c.ctx).Spec(c.spec.Clone()), Call: kind, Time: cdsexec.ClockFromContext(c.ctx).Now()}
+	if c.spec.Stdin != nil {
+		data, err := io.ReadAll(c.spec.Stdin)
+		if err != nil {
+			return err
+		}
+		rec.Stdin = data
+	}
+	c.sink.Record(rec)
+	if c.spec.Stdout != nil && (kind == cdsexec.CallRun || kind == cdsexec.CallStart) {
+		if _, err := c.spec.Stdout.Write(c.stdout()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run records the command.
+func (c *Cmd) Run() error {
+	return c.record(cdsexec.CallRun)
+}
+
+// Output records the command and returns its canned output.
+func (c *Cmd) Output() ([]byte, error) {
+	if err := c.record(cdsexec.CallOutput); err != nil {
+		return nil, err
+	}
+	return c.stdout(), nil
+}
+
+// CombinedOutput records the command and returns its canned output.
+func (c *Cmd) CombinedOutput() ([]byte, error) {
+	if err := c.record(cdsexec.CallCombinedOutput); err != nil {
+		return nil, err
+	}
+	return c.stdout(), nil
+}
+
+// Start records the command.
+func (c *Cmd) Start() error {
+	return c.record(cdsexec.CallStart)
+}
+
+// Wait returns immediately.
+func (c *Cmd) Wait() error {
+	return nil
+}
+
+// StdinPipe returns a pipe discarding everything written to it.
+func (c *Cmd) StdinPipe() (io.WriteCloser, error) {
+	return nopWriteCloser{io.Discard}, nil
+}
+
+// StdoutPipe returns a reader with the canned output.
+func (c *Cmd) StdoutPipe() (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(c.stdout())), nil
+}
+
+// StderrPipe returns an empty reader.
+func (c *Cmd) StderrPipe() (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(nil)), nil
+}
+
+// SetDir sets the working directory recorded for the command.
+func (c *Cmd) SetDir(dir string) { c.spec.Dir = dir }
+
+// SetEnv sets the environment recorded for the command.
+func (c *Cmd) SetEnv(env []string) { c.spec.Env = env }
+
+// SetStdin sets the reader whose content is recorded as the command's stdin.
+func (c *Cmd) SetStdin(in io.Reader) { c.spec.Stdin = in }
+
+// SetStdout sets the writer receiving the canned output on Run and Start.
+func (c *Cmd) SetStdout(out io.Writer) { c.spec.Stdout = out }
+
+// SetStderr sets the standard error for the command; nothing is written to it.
+func (c *Cmd) SetStderr(out io.Writer) { c.spec.Stderr = out }
+
+// Process returns nil since no process is started.
+func (c *Cmd) Process() *os.Process { return nil }
+
+// ProcessState returns nil since no process is started.
+func (c *Cmd) ProcessState() *os.ProcessState { return nil }
+
+// String returns the command line, redacted by the cdsexec.Redactor of the command's context.
+func (c *Cmd) String() string {
+	return cdsexec.RedactorFromContext(c.ctx).Spec(c.spec).String()
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
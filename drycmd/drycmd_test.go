@@ -0,0 +1,79 @@
+package drycmd_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/drycmd"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+func TestDryRunRecords(t *testing.T) {
+	rec := &drycmd.Recorder{}
+	ctor := drycmd.New(rec, drycmd.WithOutput(cdsexec.MatchCommand("lsblk", "-J"), `{"blockdevices":[]}`))
+
+	out, err := ctor(context.Background(), "lsblk", "-J").Output()
+	if err != nil || string(out) != `{"blockdevices":[]}` {
+		t.Errorf("Unexpected output %q, %v", out, err)
+	}
+
+	cmd := ctor(context.Background(), "sfdisk", "/dev/sdb")
+	cmd.SetDir("/tmp")
+	cmd.SetStdin(strings.NewReader("label: gpt\n"))
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	cmd = ctor(context.Background(), "dd", "if=/dev/zero")
+	stdin, _ := cmd.StdinPipe()
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	io.WriteString(stdin, "ignored")
+	stdin.Close()
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	records := rec.Records()
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 records, got %d", len(records))
+	}
+	if records[0].Call != cdsexec.CallOutput || records[0].Spec.String() != "lsblk -J" {
+		t.Errorf("Unexpected first record %+v", records[0])
+	}
+	if records[1].Spec.Dir != "/tmp" || string(records[1].Stdin) != "label: gpt\n" {
+		t.Errorf("Unexpected second record %+v", records[1])
+	}
+	if records[2].Call != cdsexec.CallStart {
+		t.Errorf("Unexpected third record %+v", records[2])
+	}
+}
+
+func TestWriterSinkRedacts(t *testing.T) {
+	var buf bytes.Buffer
+	r := cdsexec.NewRedactor(cdsexec.RedactFlags("--password"))
+	ctor := cdsexec.Redact(drycmd.New(drycmd.WriterSink(&buf)), r)
+
+	if err := ctor(context.Background(), "iscsiadm", "--password", "hunter2").Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := buf.String(); got != "dry-run: iscsiadm --password '***'\n" {
+		t.Errorf("Unexpected output %q", got)
+	}
+}
+
+func TestRecordTimeOnClock(t *testing.T) {
+	clock := mockcmd.NewFakeClock(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	ctx := cdsexec.ContextWithClock(context.Background(), clock)
+	rec := &drycmd.Recorder{}
+	drycmd.New(rec)(ctx, "wipefs", "-a", "/dev/sdb").Run()
+	if recs := rec.Records(); len(recs) != 1 || !recs[0].Time.Equal(clock.Now()) {
+		t.Errorf("Expected the record to be timed on the clock of the context, got %+v", recs)
+	}
+}
@@ -0,0 +1,113 @@
+package cdsexec
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// MergeEnv returns a copy of env with the given KEY=VALUE pairs set, replacing existing
+// values of the same keys. A nil env stands for the environment of the current process,
+// which is what a command inherits when no environment is set.
+func MergeEnv(env []string, kv ...string) []string {
+	if env == nil {
+		env = os.Environ()
+	}
+	out := make([]string, 0, len(env)+len(kv))
+	set := make(map[string]bool, len(kv))
+	for _, pair := range kv {
+		key, _, _ := strings.Cut(pair, "=")
+		set[key] = true
+	}
+	for _, pair := range env {
+		key, _, _ := strings.Cut(pair, "=")
+		if !set[key] {
+			out = append(out, pair)
+		}
+	}
+	return append(out, kv...)
+}
+
+// LookupEnv returns the value of key in env, where a nil env stands for the environment of
+// the current process.
+func LookupEnv(env []string, key string) (string, bool) {
+	if env == nil {
+		return os.LookupEnv(key)
+	}
+	for i := len(env) - 1; i >= 0; i-- {
+		if k, v, ok := strings.Cut(env[i], "="); ok && k == key {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// LookPath is exec.LookPath searching the directories of path, e.g. the PATH of the
+// environment of a command, instead of those of the PATH of the current process. Like
+// exec.LookPath, it returns the path found with an error matching exec.ErrDot if the path
+// is relative, as found through an empty, "." or relative entry of path, and an
+// *exec.Error matching exec.ErrNotFound if name is not found.
+//
+// The search of Windows, with its executable extensions and current directory, is left to
+// exec.LookPath: on Windows, name is resolved by exec.LookPath if path is the PATH of the
+// current process, and returned as is otherwise.
+func LookPath(name, path string) (string, error) {
+	if runtime.GOOS == "windows" {
+		if path != os.Getenv("PATH") {
+			return name, nil
+		}
+		return exec.LookPath(name)
+	}
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			// Unix shell semantics: an empty entry means the current directory.
+			dir = "."
+		}
+		p := filepath.Join(dir, name)
+		if !isExecutable(p) {
+			continue
+		}
+		if !filepath.IsAbs(p) {
+			return p, &exec.Error{Name: name, Err: exec.ErrDot}
+		}
+		return p, nil
+	}
+	return "", &exec.Error{Name: name, Err: exec.ErrNotFound}
+}
+
+func isExecutable(path string) bool {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	m := fi.Mode()
+	return !m.IsDir() && m&0o111 != 0
+}
+
+// StableLocaleEnv holds the environment variables WithStableLocale sets: the C locale for
+// messages, number and date formats and sort order, and UTC for times.
+var StableLocaleEnv = []string{"LC_ALL=C", "LANG=C", "TZ=UTC"}
+
+// WithStableLocale returns a CommandConstructor whose commands built by base run with
+// StableLocaleEnv, so that their output is the same whatever the locale and time zone of
+// the host, as parsers expect. The variables override those set by outer layers or the
+// caller, and LANGUAGE, which GNU programs prefer over LC_ALL for messages, is removed.
+func WithStableLocale(base CommandConstructor) CommandConstructor {
+	return Wrap(base, func(inv *Invocation, next Handler) error {
+		inv.Spec.Env = stableLocaleEnv(inv.Spec.Env)
+		return next(inv)
+	})
+}
+
+func stableLocaleEnv(env []string) []string {
+	env = MergeEnv(env, StableLocaleEnv...)
+	out := env[:0]
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, "LANGUAGE=") {
+			out = append(out, kv)
+		}
+	}
+	return out
+}
@@ -0,0 +1,69 @@
+package cdsexec_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+func TestWithStableLocale(t *testing.T) {
+	t.Setenv("LANGUAGE", "de_DE:de")
+	t.Setenv("LC_ALL", "de_DE.UTF-8")
+	t.Setenv("TZ", "Europe/Berlin")
+	ctor := cdsexec.WithStableLocale(cdsexec.CommandContext)
+
+	cmd := ctor(context.Background(), "sh", "-c", `echo "$LC_ALL $LANG $TZ ${LANGUAGE-unset}"; date -d @0 +%H 2>/dev/null || date -r 0 +%H`)
+	cmd.SetEnv(cdsexec.MergeEnv(nil, "LANG=fr_FR.UTF-8", "ZPOOL_VDEV_NAME_PATH=1"))
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "C C UTC unset\n00\n"; string(out) != want {
+		t.Errorf("Expected %q, got %q", want, out)
+	}
+
+	cmd = ctor(context.Background(), "sh", "-c", "echo $ZPOOL_VDEV_NAME_PATH")
+	cmd.SetEnv([]string{"ZPOOL_VDEV_NAME_PATH=1"})
+	if out, err := cmd.Output(); err != nil || strings.TrimSpace(string(out)) != "1" {
+		t.Errorf("Expected the other variables to be kept, got %q, %v", out, err)
+	}
+}
+
+func TestLookPath(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("scripts are not executable on windows")
+	}
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "zpool")
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\nexit 0\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := cdsexec.LookPath("zpool", t.TempDir()+string(filepath.ListSeparator)+dir); err != nil || got != bin {
+		t.Errorf("Expected %s, got %q, %v", bin, got, err)
+	}
+	if _, err := cdsexec.LookPath("zfs", dir); !errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	// Empty and "." entries are the current directory, which is not searched silently.
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	for _, path := range []string{".", string(filepath.ListSeparator) + "/nonexistent"} {
+		if _, err := cdsexec.LookPath("zpool", path); !errors.Is(err, exec.ErrDot) {
+			t.Errorf("Expected ErrDot for PATH %q, got %v", path, err)
+		}
+	}
+}
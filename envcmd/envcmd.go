@@ -0,0 +1,96 @@
+package envcmd
+
+import (
+	"strings"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// Option configures the environment of the commands.
+type Option func(*config)
+
+type config struct {
+	set      []string
+	defaults []string
+	unset    []string
+	path     string
+}
+
+// Set sets the given KEY=VALUE pairs, overriding values set by the caller.
+func Set(kv ...string) Option {
+	return func(c *config) { c.set = append(c.set, kv...) }
+}
+
+// Default sets the given KEY=VALUE pairs unless the command's environment already has the key.
+func Default(kv ...string) Option {
+	return func(c *config) { c.defaults = append(c.defaults, kv...) }
+}
+
+// Unset removes the given variables.
+func Unset(keys ...string) Option {
+	return func(c *config) { c.unset = append(c.unset, keys...) }
+}
+
+// PinPath sets PATH to path and resolves commands given without a slash against it with
+// cdsexec.LookPath, so the binary that runs does not depend on the PATH of the current
+// process. Commands whose binary is not found in path fail without reaching base.
+func PinPath(path string) Option {
+	return func(c *config) {
+		c.path = path
+		c.set = append(c.set, "PATH="+path)
+	}
+}
+
+// New returns a CommandConstructor that applies opts to the environment of every command
+// built by base, starting from the environment set by the caller or, if none, the environment
+// of the current process.
+func New(base cdsexec.CommandConstructor, opts ...Option) cdsexec.CommandConstructor {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		inv.Spec.Env = cfg.apply(inv.Spec.Env)
+		if cfg.path != "" && !strings.ContainsRune(inv.Spec.Name, '/') {
+			p, err := cdsexec.LookPath(inv.Spec.Name, cfg.path)
+			if err != nil {
+				return err
+			}
+			inv.Spec.Name = p
+		}
+		return next(inv)
+	})
+}
+
+func (c *config) apply(env []string) []string {
+	env = cdsexec.MergeEnv(env)
+	var defaults []string
+	for _, kv := range c.defaults {
+		key, _, _ := strings.Cut(kv, "=")
+		if _, ok := cdsexec.LookupEnv(env, key); !ok {
+			defaults = append(defaults, kv)
+		}
+	}
+	env = cdsexec.MergeEnv(env, append(defaults, c.set...)...)
+	if len(c.unset) == 0 {
+		return env
+	}
+	out := env[:0]
+	for _, kv := range env {
+		key, _, _ := strings.Cut(kv, "=")
+		if !contains(c.unset, key) {
+			out = append(out, kv)
+		}
+	}
+	return out
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package envcmd_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/envcmd"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+func TestEnvInjection(t *testing.T) {
+	var got *mockcmd.MockCmd
+	base := mockcmd.MakeMockCmdWithOutput("", func(m *mockcmd.MockCmd) error {
+		got = m
+		return nil
+	})
+	ctor := envcmd.New(base,
+		envcmd.Set("LC_ALL=C", "LANG=C"),
+		envcmd.Default("https_proxy=http://proxy:3128"),
+		envcmd.Unset("LANGUAGE"),
+	)
+
+	cmd := ctor(context.Background(), "parted", "-s", "/dev/sdb", "print")
+	cmd.SetEnv([]string{"LC_ALL=de_DE.UTF-8", "LANGUAGE=de", "https_proxy=http://other:8080", "HOME=/root"})
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	want := "https_proxy=http://other:8080 HOME=/root LC_ALL=C LANG=C"
+	if env := strings.Join(got.Env, " "); env != want {
+		t.Errorf("Expected env %q, got %q", want, env)
+	}
+}
+
+func TestEnvInheritsProcessEnvironment(t *testing.T) {
+	t.Setenv("CDSEXEC_TEST_VAR", "inherited")
+	out, err := envcmd.New(cdsexec.CommandContext, envcmd.Set("LC_ALL=C"))(context.Background(),
+		"sh", "-c", "echo $CDSEXEC_TEST_VAR $LC_ALL").Output()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(out) != "inherited C\n" {
+		t.Errorf("Unexpected output %q", out)
+	}
+}
+
+func TestPinPath(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "pinned-tool")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho pinned\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	var got *mockcmd.MockCmd
+	base := mockcmd.MakeMockCmdWithOutput("", func(m *mockcmd.MockCmd) error {
+		got = m
+		return nil
+	})
+	envcmd.New(base, envcmd.PinPath(dir))(context.Background(), "pinned-tool").Run()
+	if got.Name != script {
+		t.Errorf("Expected binary to resolve to %s, got %s", script, got.Name)
+	}
+	if v, _ := cdsexec.LookupEnv(got.Env, "PATH"); v != dir {
+		t.Errorf("Expected PATH=%s, got %s", dir, v)
+	}
+
+	envcmd.New(base, envcmd.PinPath(dir))(context.Background(), "/bin/echo").Run()
+	if got.Name != "/bin/echo" {
+		t.Errorf("Expected absolute names to be kept, got %s", got.Name)
+	}
+
+	// A binary missing from the pinned PATH is not looked for in that of the process.
+	got = nil
+	err := envcmd.New(base, envcmd.PinPath(dir))(context.Background(), "sh").Run()
+	if !errors.Is(err, exec.ErrNotFound) || got != nil {
+		t.Errorf("Expected ErrNotFound without running the command, got %v", err)
+	}
+}
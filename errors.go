@@ -0,0 +1,193 @@
+package cdsexec
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os/exec"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	// ErrNotFound matches errors for commands whose executable does not exist.
+	ErrNotFound = errors.New("cdsexec: executable not found")
+	// ErrPermission matches errors for commands that could not be executed for lack of permission.
+	ErrPermission = errors.New("cdsexec: permission denied")
+)
+
+// ExitCommandNotFound is the exit code POSIX shells exit with when they cannot find the
+// command to run. Remote backends running commands through a shell report a missing
+// executable that way, so an *ExitError with this code matches ErrNotFound.
+const ExitCommandNotFound = 127
+
+// ExitError is returned by backends that do not run a local process, such as remote
+// execution backends, when a command exits unsuccessfully. Local commands return an
+// *exec.ExitError instead; ExitCode understands both.
+type ExitError struct {
+	// Code is the exit code, or -1 if the command was terminated by a signal.
+	Code int
+	// Signal is the name of the signal that terminated the command, e.g. "KILL".
+	Signal string
+	// Stderr holds standard error collected by Output when it was not otherwise redirected.
+	Stderr []byte
+}
+
+func (e *ExitError) Error() string {
+	if e.Signal != "" {
+		return "signal: " + e.Signal
+	}
+	return "exit status " + strconv.Itoa(e.Code)
+}
+
+// ExitCode returns the exit code, or -1 if the command was terminated by a signal.
+func (e *ExitError) ExitCode() int {
+	return e.Code
+}
+
+var (
+	// ErrTimedOut matches errors for commands that were killed or not run because the
+	// deadline of their context passed.
+	ErrTimedOut = errors.New("cdsexec: command timed out")
+	// ErrKilledByContext matches errors for commands that were killed or not run because
+	// their context was cancelled or its deadline passed.
+	ErrKilledByContext = errors.New("cdsexec: command killed by context")
+	// ErrPolicyDenied matches errors for commands rejected by a policy before they ran, such
+	// as those of policycmd.
+	ErrPolicyDenied = errors.New("cdsexec: command denied by policy")
+	// ErrQuotaExceeded matches errors for commands rejected because a quota was exhausted,
+	// such as those of quotacmd.
+	ErrQuotaExceeded = errors.New("cdsexec: quota exceeded")
+)
+
+// Classify makes err match the error values of this package that describe it, whatever
+// backend produced it: ErrNotFound for a missing executable, including an *ExitError with
+// code ExitCommandNotFound, ErrPermission for a permission failure, and, when ctx is done,
+// ErrKilledByContext and the error of ctx, plus ErrTimedOut if its deadline passed. The
+// message is kept and err remains reachable with errors.Is and errors.As, so exit codes are
+// still read with ExitCode. A nil ctx only classifies err itself.
+//
+// Commands built by Wrap, CommandContext, StreamConstructor and mockcmd return classified
+// errors; Classify is for errors of other backends.
+func Classify(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	var ce *classifiedError
+	if errors.As(err, &ce) {
+		return err
+	}
+	var kinds []error
+	var xe *ExitError
+	add := func(kind error) {
+		if !errors.Is(err, kind) {
+			kinds = append(kinds, kind)
+		}
+	}
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrPermission):
+	case errors.Is(err, exec.ErrNotFound), isExecError(err, fs.ErrNotExist):
+		add(ErrNotFound)
+	case errors.As(err, &xe) && xe.Code == ExitCommandNotFound:
+		add(ErrNotFound)
+	case errors.Is(err, fs.ErrPermission):
+		add(ErrPermission)
+	}
+	if ctx != nil {
+		if cerr := ctx.Err(); cerr != nil {
+			add(ErrKilledByContext)
+			add(cerr)
+			if errors.Is(cerr, context.DeadlineExceeded) {
+				add(ErrTimedOut)
+			}
+		}
+	}
+	if len(kinds) == 0 {
+		return err
+	}
+	return &classifiedError{err: err, kinds: kinds}
+}
+
+// isExecError reports whether err is a failure of the exec system call matching target,
+// as opposed to e.g. a missing working directory.
+func isExecError(err, target error) bool {
+	var pe *fs.PathError
+	return errors.As(err, &pe) && pe.Op == "fork/exec" && errors.Is(pe.Err, target)
+}
+
+// classifiedError is an error made to match the error values describing it by Classify.
+type classifiedError struct {
+	err   error
+	kinds []error
+}
+
+func (e *classifiedError) Error() string { return e.err.Error() }
+
+func (e *classifiedError) Unwrap() []error { return append([]error{e.err}, e.kinds...) }
+
+// maxStderrExcerpt bounds the standard error quoted by the message of a CommandError.
+const maxStderrExcerpt = 512
+
+// CommandError is returned by the helpers collecting the output of commands, such as
+// Group, OutputAll and RunScript, when a command exits unsuccessfully. Its message names the
+// command and quotes the end of its standard error, e.g.
+//
+//	zpool: exit status 1: cannot open 'tank': no such pool
+//
+// It unwraps to the error of the command, so errors.As still finds the *exec.ExitError or
+// *ExitError and ExitCode reads its exit code.
+type CommandError struct {
+	// Name is the name of the command.
+	Name string
+	// Stderr is the standard error collected from the command.
+	Stderr []byte
+	// Err is the error of the command.
+	Err error
+}
+
+func (e *CommandError) Error() string {
+	msg := e.Name + ": " + e.Err.Error()
+	if excerpt := stderrExcerpt(e.Stderr); excerpt != "" {
+		msg += ": " + excerpt
+	}
+	return msg
+}
+
+func (e *CommandError) Unwrap() error { return e.Err }
+
+// commandError wraps err in a *CommandError if it reports an unsuccessful exit, and returns
+// it unchanged otherwise.
+func commandError(name string, err error, stderr []byte) error {
+	var ee *exec.ExitError
+	var xe *ExitError
+	if err == nil || (!errors.As(err, &ee) && !errors.As(err, &xe)) {
+		return err
+	}
+	return &CommandError{Name: name, Stderr: stderr, Err: err}
+}
+
+// stderrExcerpt returns the end of stderr, at most maxStderrExcerpt bytes, on a single line.
+func stderrExcerpt(stderr []byte) string {
+	s := strings.TrimSpace(string(stderr))
+	truncated := false
+	if len(s) > maxStderrExcerpt {
+		s = s[len(s)-maxStderrExcerpt:]
+		// Do not start in the middle of a UTF-8 sequence.
+		for len(s) > 0 && !utf8.RuneStart(s[0]) {
+			s = s[1:]
+		}
+		truncated = true
+	}
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	s = strings.Join(lines, "; ")
+	if truncated {
+		s = "..." + s
+	}
+	return s
+}
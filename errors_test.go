@@ -0,0 +1,135 @@
+package cdsexec_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+func TestExitCode(t *testing.T) {
+	localErr := exec.Command("sh", "-c", "exit 4").Run()
+
+	tests := []struct {
+		name    string
+		err     error
+		want    int
+		wantMsg string
+	}{
+		{"nil", nil, 0, ""},
+		{"local exit", localErr, 4, "exit status 4"},
+		{"remote exit", &cdsexec.ExitError{Code: 2}, 2, "exit status 2"},
+		{"remote signal", &cdsexec.ExitError{Code: -1, Signal: "KILL"}, -1, "signal: KILL"},
+		{"wrapped", fmt.Errorf("sshcmd: %w", &cdsexec.ExitError{Code: 5}), 5, "sshcmd: exit status 5"},
+		{"other error", errors.New("connection reset"), -1, "connection reset"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cdsexec.ExitCode(tt.err); got != tt.want {
+				t.Errorf("Expected exit code %d, got %d", tt.want, got)
+			}
+			if tt.err != nil && tt.err.Error() != tt.wantMsg {
+				t.Errorf("Expected message %q, got %q", tt.wantMsg, tt.err.Error())
+			}
+		})
+	}
+}
+
+func TestClassify(t *testing.T) {
+	expired, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	remote := cdsexec.StreamConstructor(func(ctx context.Context, spec cdsexec.CommandSpec) error {
+		<-ctx.Done()
+		return &cdsexec.ExitError{Code: -1, Signal: "TERM"}
+	})
+
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		ctor  cdsexec.CommandConstructor
+		cmd   []string
+		kinds []error
+		code  int
+	}{
+		{"local not found", context.Background(), cdsexec.CommandContext, []string{"cdsexec-missing"}, []error{cdsexec.ErrNotFound, exec.ErrNotFound}, -1},
+		{"wrapped not found", context.Background(), cdsexec.Wrap(cdsexec.CommandContext, passThrough), []string{"cdsexec-missing"}, []error{cdsexec.ErrNotFound}, -1},
+		{"mock not found", context.Background(), mockcmd.MakeMockCmdWithOutputSpecificError("", exec.ErrNotFound, nil), []string{"zpool"}, []error{cdsexec.ErrNotFound}, -1},
+		{"local timeout", expired, cdsexec.CommandContext, []string{"sleep", "5"}, []error{cdsexec.ErrTimedOut, cdsexec.ErrKilledByContext, context.DeadlineExceeded}, -1},
+		{"remote timeout", expired, remote, []string{"sleep", "5"}, []error{cdsexec.ErrTimedOut, cdsexec.ErrKilledByContext}, -1},
+		{"mock cancelled", cancelled, mockcmd.MakeMockCmdWithOutputSpecificError("", &cdsexec.ExitError{Code: -1, Signal: "KILL"}, nil), []string{"zpool"}, []error{cdsexec.ErrKilledByContext, context.Canceled}, -1},
+		{"remote not found", context.Background(), mockcmd.MakeMockCmdWithOutputSpecificError("", &cdsexec.ExitError{Code: cdsexec.ExitCommandNotFound}, nil), []string{"zpool"}, []error{cdsexec.ErrNotFound}, 127},
+		{"local exit", context.Background(), cdsexec.CommandContext, []string{"sh", "-c", "exit 3"}, nil, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.ctor(tt.ctx, tt.cmd[0], tt.cmd[1:]...).Run()
+			if err == nil {
+				t.Fatal("Expected an error")
+			}
+			for _, kind := range tt.kinds {
+				if !errors.Is(err, kind) {
+					t.Errorf("Expected %v to match %v", err, kind)
+				}
+			}
+			if tt.kinds == nil && (errors.Is(err, cdsexec.ErrKilledByContext) || errors.Is(err, cdsexec.ErrNotFound)) {
+				t.Errorf("Expected %v to be left unclassified", err)
+			}
+			if got := cdsexec.ExitCode(err); got != tt.code {
+				t.Errorf("Expected exit code %d, got %d", tt.code, got)
+			}
+		})
+	}
+
+	if err := mockcmd.MakeMockCmdWithOutputGenericError(nil)(context.Background(), "zpool").Run(); err != exec.ErrNotFound {
+		t.Errorf("Expected the generic mock error unchanged, got %v", err)
+	}
+	if err := cdsexec.Classify(nil, errors.New("connection reset")); err.Error() != "connection reset" || errors.Is(err, cdsexec.ErrNotFound) {
+		t.Errorf("Expected an unrelated error unchanged, got %v", err)
+	}
+}
+
+func TestCommandError(t *testing.T) {
+	long := strings.Repeat("x", 1000)
+	tests := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{"stderr", "echo 'cannot open tank' >&2; exit 1", "sh: exit status 1: cannot open tank"},
+		{"lines", "printf 'first\\n\\n  second  \\n' >&2; exit 2", "sh: exit status 2: first; second"},
+		{"no stderr", "exit 3", "sh: exit status 3"},
+		{"long", "echo head >&2; echo " + long + " >&2; exit 4", "sh: exit status 4: ..." + long[:512]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := cdsexec.OutputAll(context.Background(), nil, []cdsexec.CommandSpec{{Name: "sh", Args: []string{"-c", tt.script}}}, 1)
+			var ge *cdsexec.GroupError
+			if !errors.As(err, &ge) {
+				t.Fatalf("Expected *GroupError, got %v", err)
+			}
+			var ce *cdsexec.CommandError
+			var ee *exec.ExitError
+			if !errors.As(ge.Errs[0], &ce) || !errors.As(ge.Errs[0], &ee) {
+				t.Fatalf("Expected *CommandError wrapping *exec.ExitError, got %v", ge.Errs[0])
+			}
+			if ce.Error() != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, ce.Error())
+			}
+		})
+	}
+
+	// Errors other than exits are returned unchanged.
+	r, err := cdsexec.RunScript(context.Background(), mockcmd.MakeMockCmdWithOutputGenericError(nil), cdsexec.ScriptOptions{Content: "true"})
+	var ce *cdsexec.CommandError
+	if errors.As(err, &ce) || !errors.Is(err, exec.ErrNotFound) || r.ExitCode != -1 {
+		t.Errorf("Expected exec.ErrNotFound, got %v", err)
+	}
+}
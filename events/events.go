@@ -0,0 +1,178 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// Kind identifies a lifecycle event.
+type Kind int
+
+const (
+	// Constructed is published when a command is built by the constructor.
+	Constructed Kind = iota
+	// Started is published when the command is about to start.
+	Started
+	// FirstOutput is published on the first write of the command to its standard output
+	// or standard error. It is only observed for Run and Start calls whose streams are
+	// not pipes.
+	FirstOutput
+	// Exited is published when the command has finished on its own, or failed to start.
+	Exited
+	// Killed is published instead of Exited when the command was terminated by a signal
+	// or by the cancellation of its context.
+	Killed
+	// TimedOut is published instead of Exited when the deadline of the context of the
+	// command expired.
+	TimedOut
+)
+
+// String returns the name of the kind.
+func (k Kind) String() string {
+	switch k {
+	case Constructed:
+		return "Constructed"
+	case Started:
+		return "Started"
+	case FirstOutput:
+		return "FirstOutput"
+	case Exited:
+		return "Exited"
+	case Killed:
+		return "Killed"
+	case TimedOut:
+		return "TimedOut"
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Event describes a step in the lifecycle of a command.
+type Event struct {
+	Kind Kind
+	Time time.Time
+	// ID identifies the command across its events. IDs are unique within a Bus.
+	ID  uint64
+	Ctx context.Context
+	// Spec is the command, redacted by the Redactor of its context.
+	Spec cdsexec.CommandSpec
+	// Stream is the stream written first, for FirstOutput events.
+	Stream cdsexec.Stream
+	// Result and Err are the outcome of the command, for Exited, Killed and TimedOut events.
+	Result cdsexec.Result
+	Err    error
+}
+
+// Bus delivers events to its subscribers. Its zero value is ready to use.
+type Bus struct {
+	mu     sync.RWMutex
+	subs   map[uint64]func(Event)
+	nextID uint64
+	cmdID  atomic.Uint64
+}
+
+// Subscribe registers fn to receive every event published and returns a function
+// unregistering it. Subscribers are called synchronously, in the goroutine running the
+// command, and must not block.
+func (b *Bus) Subscribe(fn func(Event)) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs == nil {
+		b.subs = make(map[uint64]func(Event))
+	}
+	id := b.nextID
+	b.nextID++
+	b.subs[id] = fn
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs, id)
+	}
+}
+
+// Publish delivers ev to every subscriber registered when it is called. Subscribers may
+// subscribe and unsubscribe while they are called, which affects the next events only.
+func (b *Bus) Publish(ev Event) {
+	b.mu.RLock()
+	subs := make([]func(Event), 0, len(b.subs))
+	for _, fn := range b.subs {
+		subs = append(subs, fn)
+	}
+	b.mu.RUnlock()
+	for _, fn := range subs {
+		fn(ev)
+	}
+}
+
+// New returns a CommandConstructor publishing the lifecycle events of every command built
+// by base on bus.
+func New(base cdsexec.CommandConstructor, bus *Bus) cdsexec.CommandConstructor {
+	return func(ctx context.Context, name string, arg ...string) cdsexec.Commander {
+		id := bus.cmdID.Add(1)
+		publish := func(inv *cdsexec.Invocation, ev Event) {
+			ev.Time, ev.ID, ev.Ctx = cdsexec.ClockFromContext(inv.Ctx).Now(), id, inv.Ctx
+			ev.Spec = cdsexec.RedactorFromContext(inv.Ctx).Spec(inv.Spec)
+			bus.Publish(ev)
+		}
+		cmd := cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+			var once sync.Once
+			first := func(s cdsexec.Stream) {
+				once.Do(func() { publish(inv, Event{Kind: FirstOutput, Stream: s}) })
+			}
+			inv.TeeStdout(firstWriter(func() { first(cdsexec.Stdout) }))
+			inv.TeeStderr(firstWriter(func() { first(cdsexec.Stderr) }))
+
+			publish(inv, Event{Kind: Started})
+			err := next(inv)
+			res := inv.Result(err)
+			publish(inv, Event{Kind: exitKind(inv, err), Result: res, Err: err})
+			return err
+		})(ctx, name, arg...)
+		bus.Publish(Event{
+			Kind: Constructed,
+			Time: cdsexec.ClockFromContext(ctx).Now(),
+			ID:   id,
+			Ctx:  ctx,
+			Spec: cdsexec.RedactorFromContext(ctx).Spec(cdsexec.CommandSpec{Name: name, Args: arg}),
+		})
+		return cmd
+	}
+}
+
+// exitKind tells how the command finished.
+func exitKind(inv *cdsexec.Invocation, err error) Kind {
+	if err == nil {
+		return Exited
+	}
+	switch inv.Ctx.Err() {
+	case context.DeadlineExceeded:
+		return TimedOut
+	case context.Canceled:
+		return Killed
+	}
+	if cmd := inv.Commander(); cmd != nil {
+		if state := cmd.ProcessState(); state != nil && !state.Exited() {
+			return Killed
+		}
+	}
+	var xe *cdsexec.ExitError
+	if errors.As(err, &xe) && xe.Signal != "" {
+		return Killed
+	}
+	return Exited
+}
+
+// firstWriter calls fn on every non-empty write; fn deduplicates.
+type firstWriter func()
+
+func (f firstWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		f()
+	}
+	return len(p), nil
+}
@@ -0,0 +1,138 @@
+package events_test
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/events"
+)
+
+type recorder struct {
+	mu     sync.Mutex
+	events []events.Event
+}
+
+func (r *recorder) record(ev events.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, ev)
+}
+
+func (r *recorder) kinds() []events.Kind {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var kinds []events.Kind
+	for _, ev := range r.events {
+		kinds = append(kinds, ev.Kind)
+	}
+	return kinds
+}
+
+func TestLifecycle(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeout  time.Duration
+		script   string
+		output   bool
+		expected []events.Kind
+	}{
+		{
+			name:     "exited",
+			script:   "echo progress >&2; exit 3",
+			expected: []events.Kind{events.Constructed, events.Started, events.FirstOutput, events.Exited},
+		},
+		{
+			name:     "output",
+			script:   "echo data",
+			output:   true,
+			expected: []events.Kind{events.Constructed, events.Started, events.Exited},
+		},
+		{
+			name:     "killed",
+			script:   "kill -9 $$",
+			expected: []events.Kind{events.Constructed, events.Started, events.Killed},
+		},
+		{
+			name:     "timed out",
+			timeout:  50 * time.Millisecond,
+			script:   "exec sleep 5",
+			expected: []events.Kind{events.Constructed, events.Started, events.TimedOut},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bus events.Bus
+			var rec recorder
+			unsubscribe := bus.Subscribe(rec.record)
+			ctor := events.New(cdsexec.CommandContext, &bus)
+
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			cmd := ctor(ctx, "sh", "-c", tt.script)
+			if tt.output {
+				cmd.Output()
+			} else {
+				cmd.Run()
+			}
+			unsubscribe()
+			ctor(ctx, "true").Run()
+
+			if kinds := rec.kinds(); !reflect.DeepEqual(kinds, tt.expected) {
+				t.Errorf("Expected events %v, got %v", tt.expected, kinds)
+			}
+			for _, ev := range rec.events {
+				if ev.ID != rec.events[0].ID || ev.Spec.Name != "sh" {
+					t.Errorf("Expected every event to be about the same command, got %+v", ev)
+				}
+			}
+		})
+	}
+}
+
+func TestEventDetails(t *testing.T) {
+	var bus events.Bus
+	var rec recorder
+	bus.Subscribe(rec.record)
+	ctx := cdsexec.ContextWithRedactor(context.Background(), cdsexec.NewRedactor(cdsexec.RedactFlags("--password")))
+	ctor := events.New(cdsexec.CommandContext, &bus)
+	ctor(ctx, "sh", "-c", "echo out; exit 2", "--password", "s3cr3t").Run()
+	ctor(ctx, "true").Run()
+
+	first, last := rec.events[2], rec.events[3]
+	if first.Kind != events.FirstOutput || first.Stream != cdsexec.Stdout {
+		t.Errorf("Expected FirstOutput on stdout, got %v on %v", first.Kind, first.Stream)
+	}
+	if last.Result.ExitCode != 2 || cdsexec.ExitCode(last.Err) != 2 {
+		t.Errorf("Expected exit code 2, got %+v", last.Result)
+	}
+	if last.Spec.Args[3] != cdsexec.DefaultMask {
+		t.Errorf("Expected a redacted spec, got %v", last.Spec.Args)
+	}
+	if rec.events[4].ID == last.ID {
+		t.Error("Expected distinct IDs for distinct commands")
+	}
+}
+
+func TestUnsubscribeInCallback(t *testing.T) {
+	var bus events.Bus
+	var kinds []events.Kind
+	var unsubscribe func()
+	unsubscribe = bus.Subscribe(func(ev events.Event) {
+		kinds = append(kinds, ev.Kind)
+		if ev.Kind == events.Started {
+			unsubscribe()
+		}
+	})
+	events.New(cdsexec.CommandContext, &bus)(context.Background(), "true").Run()
+	if !reflect.DeepEqual(kinds, []events.Kind{events.Constructed, events.Started}) {
+		t.Errorf("Expected the events up to the unsubscription, got %v", kinds)
+	}
+}
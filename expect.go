@@ -0,0 +1,285 @@
+package cdsexec
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxExpectTail is the amount of output seen an *ExpectError keeps.
+const maxExpectTail = 4096
+
+// ExpectError is returned when the line expected from a stream did not show up.
+type ExpectError struct {
+	// Pattern is the pattern or string expected.
+	Pattern string
+	// Output is the end of the output read while waiting, up to 4 KiB.
+	Output []byte
+	// Err is context.DeadlineExceeded if the timeout elapsed, the error of the context if
+	// it was done first, io.EOF if the stream ended, or the error reading it.
+	Err error
+}
+
+func (e *ExpectError) Error() string {
+	reason := e.Err.Error()
+	switch e.Err {
+	case context.DeadlineExceeded:
+		reason = "timed out"
+	case io.EOF:
+		reason = "output ended"
+	}
+	if len(e.Output) == 0 {
+		return fmt.Sprintf("cdsexec: expecting %q: %s", e.Pattern, reason)
+	}
+	return fmt.Sprintf("cdsexec: expecting %q: %s, last output: %q", e.Pattern, reason, tailLines(e.Output, 3))
+}
+
+func (e *ExpectError) Unwrap() error { return e.Err }
+
+// tailLines returns the last n lines of b.
+func tailLines(b []byte, n int) string {
+	s := strings.TrimRight(string(b), "\n")
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] == '\n' {
+			n--
+			if n == 0 {
+				return s[i+1:]
+			}
+		}
+	}
+	return s
+}
+
+// keepTail appends line to tail, keeping its last maxExpectTail bytes.
+func keepTail(tail, line []byte) []byte {
+	tail = append(tail, line...)
+	if len(tail) > maxExpectTail {
+		tail = append(tail[:0], tail[len(tail)-maxExpectTail:]...)
+	}
+	return tail
+}
+
+// ExpectLine reads lines from r, such as the StdoutPipe of a daemon being started, until
+// one matches re, and returns the submatches of that line, without its line ending. It
+// waits at most timeout, measured on the Clock of ctx, where zero means until ctx is done.
+// If no line matches, it returns an *ExpectError.
+//
+// r is read one byte at a time, so that after a match the rest of the output is still in r,
+// for the caller to read or discard; a command whose output pipe is no longer read blocks
+// once the pipe is full. If ExpectLine fails, a read of r may still be in progress: r should
+// not be read further, but closed, e.g. by stopping the command. Use an Expecter to wait for
+// several lines in turn.
+func ExpectLine(ctx context.Context, r io.Reader, re *regexp.Regexp, timeout time.Duration) ([]string, error) {
+	var m []string
+	err := expectLine(ctx, r, re.String(), timeout, func(line string) bool {
+		m = re.FindStringSubmatch(line)
+		return m != nil
+	})
+	return m, err
+}
+
+// ExpectString is ExpectLine waiting for a line containing substr, which it returns.
+func ExpectString(ctx context.Context, r io.Reader, substr string, timeout time.Duration) (string, error) {
+	var found string
+	err := expectLine(ctx, r, substr, timeout, func(line string) bool {
+		found = line
+		return strings.Contains(line, substr)
+	})
+	return found, err
+}
+
+type lineRead struct {
+	line []byte
+	err  error
+}
+
+func expectLine(ctx context.Context, r io.Reader, pattern string, timeout time.Duration, match func(string) bool) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = ContextWithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	lines := make(chan lineRead)
+	more := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			line, err := readLine(r)
+			select {
+			case lines <- lineRead{line, err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+			select {
+			case <-more:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var tail []byte
+	for {
+		select {
+		case lr := <-lines:
+			if len(lr.line) > 0 {
+				if match(string(trimEOL(lr.line))) {
+					return nil
+				}
+				tail = keepTail(tail, lr.line)
+			}
+			if lr.err != nil {
+				return &ExpectError{Pattern: pattern, Output: tail, Err: lr.err}
+			}
+			more <- struct{}{}
+		case <-ctx.Done():
+			return &ExpectError{Pattern: pattern, Output: tail, Err: ctx.Err()}
+		}
+	}
+}
+
+// readLine reads r one byte at a time up to and including the next newline.
+func readLine(r io.Reader) ([]byte, error) {
+	var line []byte
+	var b [1]byte
+	for {
+		n, err := r.Read(b[:])
+		if n > 0 {
+			line = append(line, b[0])
+			if b[0] == '\n' {
+				return line, nil
+			}
+		}
+		if err != nil {
+			return line, err
+		}
+	}
+}
+
+func trimEOL(line []byte) []byte {
+	return bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+}
+
+// Expecter reads the lines of a stream in the background, for a sequence of
+// expectations, such as the banner of a daemon followed by the line telling it is ready.
+// The stream is always read, so that a command writing to it never blocks.
+type Expecter struct {
+	mu      sync.Mutex
+	pending [][]byte
+	err     error
+	fwd     io.Writer
+	notify  chan struct{}
+}
+
+// NewExpecter starts reading the lines of r. r is read until it ends, so it must be a
+// stream that ends, such as the StdoutPipe of a command. Lines are kept until an
+// expectation consumes them or Forward hands them over.
+func NewExpecter(r io.Reader) *Expecter {
+	e := &Expecter{notify: make(chan struct{})}
+	go e.read(r)
+	return e
+}
+
+func (e *Expecter) read(r io.Reader) {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		e.mu.Lock()
+		if len(line) > 0 {
+			if e.fwd != nil {
+				e.fwd.Write(line)
+			} else {
+				e.pending = append(e.pending, line)
+			}
+		}
+		if err != nil {
+			e.err = err
+		}
+		close(e.notify)
+		e.notify = make(chan struct{})
+		e.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// ExpectLine waits for a line matching re among the lines not consumed by a previous
+// expectation, as the function ExpectLine does. A match consumes the lines up to and
+// including the matching one.
+func (e *Expecter) ExpectLine(ctx context.Context, re *regexp.Regexp, timeout time.Duration) ([]string, error) {
+	var m []string
+	err := e.expect(ctx, re.String(), timeout, func(line string) bool {
+		m = re.FindStringSubmatch(line)
+		return m != nil
+	})
+	return m, err
+}
+
+// ExpectString waits for a line containing substr, as ExpectLine does, and returns it.
+func (e *Expecter) ExpectString(ctx context.Context, substr string, timeout time.Duration) (string, error) {
+	var found string
+	err := e.expect(ctx, substr, timeout, func(line string) bool {
+		found = line
+		return strings.Contains(line, substr)
+	})
+	return found, err
+}
+
+func (e *Expecter) expect(ctx context.Context, pattern string, timeout time.Duration, match func(string) bool) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = ContextWithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	// Lines are only consumed by a match, so that a failed expectation leaves them to the
+	// next one or to Forward.
+	var tail []byte
+	seen := 0
+	for {
+		e.mu.Lock()
+		for ; seen < len(e.pending); seen++ {
+			line := e.pending[seen]
+			if match(string(trimEOL(line))) {
+				e.pending = e.pending[seen+1:]
+				e.mu.Unlock()
+				return nil
+			}
+			tail = keepTail(tail, line)
+		}
+		readErr := e.err
+		notify := e.notify
+		e.mu.Unlock()
+		if readErr != nil {
+			return &ExpectError{Pattern: pattern, Output: tail, Err: readErr}
+		}
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return &ExpectError{Pattern: pattern, Output: tail, Err: ctx.Err()}
+		}
+	}
+}
+
+// Forward writes the lines not consumed yet, and all the lines read from then on, to w,
+// e.g. a log, once the expectations are over. Forward(io.Discard) drops them. w is
+// written to from the goroutine reading the stream.
+func (e *Expecter) Forward(w io.Writer) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, line := range e.pending {
+		w.Write(line)
+	}
+	e.pending = nil
+	e.fwd = w
+}
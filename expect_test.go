@@ -0,0 +1,80 @@
+package cdsexec_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+func TestExpectLine(t *testing.T) {
+	cmd := cdsexec.CommandContext(context.Background(), "sh", "-c", "echo 'Starting tgtd'; sleep 0.1; echo 'Ready to accept connections on port 3260'; echo 'serving'")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	m, err := cdsexec.ExpectLine(context.Background(), out, regexp.MustCompile(`Ready to accept connections on port (\d+)`), 5*time.Second)
+	if err != nil || len(m) != 2 || m[1] != "3260" {
+		t.Fatalf("Expected the port, got %q, %v", m, err)
+	}
+	rest, _ := io.ReadAll(out)
+	if string(rest) != "serving\n" {
+		t.Errorf("Expected the rest of the output to be left in the pipe, got %q", rest)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExpectLineErrors(t *testing.T) {
+	_, err := cdsexec.ExpectString(context.Background(), strings.NewReader("Starting tgtd\nbind: address in use\n"), "Ready", time.Second)
+	var ee *cdsexec.ExpectError
+	if !errors.As(err, &ee) || !errors.Is(err, io.EOF) || !strings.Contains(err.Error(), "bind: address in use") {
+		t.Errorf("Expected an *ExpectError at the end of the output, got %v", err)
+	}
+
+	r, w := io.Pipe()
+	defer w.Close()
+	go io.WriteString(w, "Starting tgtd\n")
+	_, err = cdsexec.ExpectLine(context.Background(), r, regexp.MustCompile(`Ready`), 200*time.Millisecond)
+	if !errors.As(err, &ee) || !errors.Is(err, context.DeadlineExceeded) || string(ee.Output) != "Starting tgtd\n" {
+		t.Errorf("Expected a timeout with the output seen, got %v", err)
+	}
+}
+
+func TestExpecter(t *testing.T) {
+	r, w := io.Pipe()
+	e := cdsexec.NewExpecter(r)
+	go io.WriteString(w, "iscsid 2.1.8\nlogging in to iqn.2024-01.com.example:lun1\nlogin successful\nsession 1 up\nsession 2 up\n")
+
+	ctx := context.Background()
+	m, err := e.ExpectLine(ctx, regexp.MustCompile(`^iscsid (\S+)`), time.Second)
+	if err != nil || m[1] != "2.1.8" {
+		t.Fatalf("Expected the version, got %q, %v", m, err)
+	}
+	if line, err := e.ExpectString(ctx, "successful", time.Second); err != nil || line != "login successful" {
+		t.Fatalf("Expected the login line, got %q, %v", line, err)
+	}
+	_, err = e.ExpectString(ctx, "successful", 50*time.Millisecond)
+	var ee *cdsexec.ExpectError
+	if !errors.As(err, &ee) || !errors.Is(err, context.DeadlineExceeded) || string(ee.Output) != "session 1 up\nsession 2 up\n" {
+		t.Errorf("Expected consumed lines not to match again, got %v", err)
+	}
+	var rest strings.Builder
+	e.Forward(&rest)
+	w.Close()
+	if _, err := e.ExpectString(ctx, "never", time.Second); !errors.Is(err, io.EOF) {
+		t.Errorf("Expected the end of the stream, got %v", err)
+	}
+	if rest.String() != "session 1 up\nsession 2 up\n" {
+		t.Errorf("Expected the remaining lines to be forwarded, got %q", rest.String())
+	}
+}
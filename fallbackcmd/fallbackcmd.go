@@ -0,0 +1,53 @@
+package fallbackcmd
+
+import (
+	"errors"
+	"path/filepath"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// Alternative is a binary that can stand in for another one.
+type Alternative struct {
+	// Name is the binary to run instead.
+	Name string
+	// MapArgs translates the arguments of the original command. Nil keeps them as they are.
+	MapArgs func(args []string) []string
+}
+
+// Table maps the base name of a binary to the alternatives tried, in order, when it is not found.
+type Table map[string][]Alternative
+
+// New returns a CommandConstructor that runs the next alternative from t whenever the
+// binary of a command built by base is not found, i.e. fails with an error matching
+// cdsexec.ErrNotFound, such as a missing absolute path or a command that a remote backend
+// reports with exit status 127. Commands started with
+// Start or with attached pipes are executed only once. If no alternative is found either,
+// the error of the original binary is returned.
+func New(base cdsexec.CommandConstructor, t Table) cdsexec.CommandConstructor {
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		alts := t[filepath.Base(inv.Spec.Name)]
+		if len(alts) == 0 || !inv.Replayable() {
+			return next(inv)
+		}
+
+		orig := inv.Spec
+		err := next(inv)
+		if !errors.Is(err, cdsexec.ErrNotFound) {
+			return err
+		}
+		for _, alt := range alts {
+			inv.Spec.Name = alt.Name
+			inv.Spec.Args = orig.Args
+			if alt.MapArgs != nil {
+				inv.Spec.Args = alt.MapArgs(append([]string(nil), orig.Args...))
+			}
+			inv.Output = nil
+			if aerr := next(inv); !errors.Is(aerr, cdsexec.ErrNotFound) {
+				return aerr
+			}
+		}
+		inv.Spec = orig
+		return err
+	})
+}
@@ -0,0 +1,104 @@
+package fallbackcmd_test
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/fallbackcmd"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+func TestFallback(t *testing.T) {
+	table := fallbackcmd.Table{
+		"fuser": {
+			{Name: "lsof", MapArgs: func(args []string) []string { return append([]string{"-t"}, args...) }},
+		},
+		"nvme": {
+			{Name: "nvme-cli"},
+			{Name: "nvme2"},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		installed []string
+		cmd       []string
+		want      string
+		wantErr   bool
+	}{
+		{"first binary found", []string{"nvme", "nvme-cli"}, []string{"nvme", "list"}, "nvme list", false},
+		{"second binary", []string{"nvme-cli"}, []string{"nvme", "list"}, "nvme-cli list", false},
+		{"last binary", []string{"nvme2"}, []string{"/usr/sbin/nvme", "list"}, "nvme2 list", false},
+		{"mapped args", []string{"lsof"}, []string{"fuser", "/mnt/data"}, "lsof -t /mnt/data", false},
+		{"none found", nil, []string{"nvme", "list"}, "", true},
+		{"no alternatives", nil, []string{"blkid"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ran string
+			base := mockcmd.MakeMockCmdWithOutput("", func(m *mockcmd.MockCmd) error {
+				for _, b := range tt.installed {
+					if m.Name == b {
+						ran = strings.Join(append([]string{m.Name}, m.Args...), " ")
+						return nil
+					}
+				}
+				return &exec.Error{Name: m.Name, Err: exec.ErrNotFound}
+			})
+			err := fallbackcmd.New(base, table)(context.Background(), tt.cmd[0], tt.cmd[1:]...).Run()
+			if tt.wantErr {
+				var ee *exec.Error
+				if !errors.As(err, &ee) || ee.Name != tt.cmd[0] {
+					t.Errorf("Expected not found error for %s, got %v", tt.cmd[0], err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if ran != tt.want {
+				t.Errorf("Expected %q to run, got %q", tt.want, ran)
+			}
+		})
+	}
+}
+
+func TestFallbackReal(t *testing.T) {
+	ctor := fallbackcmd.New(cdsexec.CommandContext, fallbackcmd.Table{
+		"cdsexec-missing-tool": {{Name: "echo"}},
+	})
+	for _, name := range []string{"cdsexec-missing-tool", "/nonexistent/cdsexec-missing-tool"} {
+		out, err := ctor(context.Background(), name, "hello").Output()
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %v", name, err)
+		}
+		if string(out) != "hello\n" {
+			t.Errorf("Expected output from the fallback binary for %s, got %q", name, out)
+		}
+	}
+}
+
+func TestFallbackRemoteNotFound(t *testing.T) {
+	// Remote backends report a missing binary with the exit status of the shell.
+	base := mockcmd.MakeMockCmdWithOutput("", func(m *mockcmd.MockCmd) error {
+		if m.Name == "nvme" {
+			return &cdsexec.ExitError{Code: cdsexec.ExitCommandNotFound, Stderr: []byte("sh: nvme: not found\n")}
+		}
+		return nil
+	})
+	var ran string
+	ctor := fallbackcmd.New(cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		ran = inv.Spec.Name
+		return next(inv)
+	}), fallbackcmd.Table{"nvme": {{Name: "nvme-cli"}}})
+	if err := ctor(context.Background(), "nvme", "list").Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ran != "nvme-cli" {
+		t.Errorf("Expected nvme-cli to run, got %s", ran)
+	}
+}
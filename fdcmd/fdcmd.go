@@ -0,0 +1,145 @@
+package fdcmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// EnvVar enables the audit of New when set to a true value, e.g. CDSEXEC_FD_AUDIT=1.
+const EnvVar = "CDSEXEC_FD_AUDIT"
+
+// ErrLeak matches the errors of commands refused because they would inherit file
+// descriptors.
+var ErrLeak = errors.New("fdcmd: file descriptors would leak into the command")
+
+// Leak is a file descriptor of the current process that children inherit.
+type Leak struct {
+	FD int
+	// Path is what the descriptor refers to, e.g. a file name or socket:[1234], where the
+	// platform tells.
+	Path string
+}
+
+func (l Leak) String() string {
+	if l.Path == "" {
+		return "fd " + strconv.Itoa(l.FD)
+	}
+	return "fd " + strconv.Itoa(l.FD) + " (" + l.Path + ")"
+}
+
+// LeakError is returned for commands refused because they would inherit file descriptors.
+type LeakError struct {
+	// Spec is the refused command, redacted by the cdsexec.Redactor of its context.
+	Spec  cdsexec.CommandSpec
+	Leaks []Leak
+}
+
+func (e *LeakError) Error() string {
+	return fmt.Sprintf("fdcmd: %s would inherit %s", e.Spec.Name, joinLeaks(e.Leaks))
+}
+
+// Is reports whether target is ErrLeak.
+func (e *LeakError) Is(target error) bool {
+	return target == ErrLeak
+}
+
+func joinLeaks(leaks []Leak) string {
+	s := make([]string, len(leaks))
+	for i, l := range leaks {
+		s[i] = l.String()
+	}
+	return strings.Join(s, ", ")
+}
+
+// Options configures the audit.
+type Options struct {
+	// Enabled enables the audit regardless of EnvVar, e.g. from a command-line flag.
+	Enabled bool
+	// Declared are the files deliberately passed to children, e.g. through ExtraFiles or
+	// inherited from systemd socket activation, which are not reported.
+	Declared []*os.File
+	// Fail refuses to run the commands that would inherit file descriptors, with a
+	// *LeakError, instead of reporting them and running them.
+	Fail bool
+	// Report receives the leaks of each command. Nil writes a line per command to Writer.
+	Report func(spec cdsexec.CommandSpec, leaks []Leak)
+	// Writer receives the reports when Report is nil, os.Stderr if nil.
+	Writer io.Writer
+}
+
+// Enabled reports whether EnvVar enables the audit.
+func Enabled() bool {
+	on, _ := strconv.ParseBool(os.Getenv(EnvVar))
+	return on
+}
+
+// Leaks returns the file descriptors of the current process, other than standard input,
+// output and error and the declared files, that are not closed on exec and so are
+// inherited by every child, whatever its ExtraFiles. Files opened by Go are closed on
+// exec; leaks come from descriptors inherited by the process, or created by C libraries or
+// raw system calls. On platforms without a way to list them, Leaks returns nil.
+func Leaks(declared ...*os.File) ([]Leak, error) {
+	leaks, err := inherited()
+	if err != nil {
+		return nil, err
+	}
+	out := leaks[:0]
+	for _, l := range leaks {
+		if !isDeclared(l.FD, declared) {
+			out = append(out, l)
+		}
+	}
+	return out, nil
+}
+
+func isDeclared(fd int, declared []*os.File) bool {
+	for _, f := range declared {
+		if f != nil && int(f.Fd()) == fd {
+			return true
+		}
+	}
+	return false
+}
+
+// New returns a CommandConstructor auditing, before every command built by base starts,
+// the file descriptors it would inherit beyond its standard streams and ExtraFiles, as
+// Leaks does. Unless opts.Enabled is set or EnvVar enables it, New returns base
+// unchanged: listing the descriptors costs a few system calls per descriptor. Leaks can
+// be fixed at the source by opening files with O_CLOEXEC, or worked around with
+// preexec.WithCloseOnExec.
+func New(base cdsexec.CommandConstructor, opts Options) cdsexec.CommandConstructor {
+	if !opts.Enabled && !Enabled() {
+		return base
+	}
+	if opts.Writer == nil {
+		opts.Writer = os.Stderr
+	}
+	var mu sync.Mutex
+	report := opts.Report
+	if report == nil {
+		report = func(spec cdsexec.CommandSpec, leaks []Leak) {
+			mu.Lock()
+			defer mu.Unlock()
+			fmt.Fprintf(opts.Writer, "fdcmd: %s would inherit %s\n", spec, joinLeaks(leaks))
+		}
+	}
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		leaks, err := Leaks(opts.Declared...)
+		if err != nil || len(leaks) == 0 {
+			return next(inv)
+		}
+		spec := cdsexec.RedactorFromContext(inv.Ctx).Spec(inv.Spec)
+		if opts.Fail {
+			return &LeakError{Spec: spec, Leaks: leaks}
+		}
+		report(spec, leaks)
+		return next(inv)
+	})
+}
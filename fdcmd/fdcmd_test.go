@@ -0,0 +1,86 @@
+//go:build unix
+
+package fdcmd_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/fdcmd"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+// leakyPipe returns a pipe whose read end is inherited by children, as descriptors
+// created by C libraries often are.
+func leakyPipe(t *testing.T) *os.File {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close(); w.Close() })
+	if _, _, errno := syscall.Syscall(syscall.SYS_FCNTL, r.Fd(), syscall.F_SETFD, 0); errno != 0 {
+		t.Fatal(errno)
+	}
+	return r
+}
+
+func TestReport(t *testing.T) {
+	r := leakyPipe(t)
+	fd := int(r.Fd())
+	var out bytes.Buffer
+	ctor := fdcmd.New(cdsexec.CommandContext, fdcmd.Options{Enabled: true, Writer: &out})
+	got, err := ctor(context.Background(), "sh", "-c", "ls /dev/fd/").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "fd " + strconv.Itoa(fd)
+	if !strings.Contains(out.String(), "fdcmd: sh -c 'ls /dev/fd/' would inherit "+want) {
+		t.Errorf("Expected %s to be reported, got %q", want, out.String())
+	}
+	if runtime.GOOS == "linux" && !strings.Contains(out.String(), "pipe:[") {
+		t.Errorf("Expected the path of the descriptor, got %q", out.String())
+	}
+	if !strings.Contains(" "+strings.Join(strings.Fields(string(got)), " ")+" ", " "+strconv.Itoa(fd)+" ") {
+		t.Errorf("Expected the child to have inherited fd %d, got %q", fd, got)
+	}
+}
+
+func TestFail(t *testing.T) {
+	r := leakyPipe(t)
+	ctor := fdcmd.New(mockcmd.MakeMockCmdWithOutput("", nil), fdcmd.Options{Enabled: true, Fail: true})
+	err := ctor(context.Background(), "zpool", "list").Run()
+	var le *fdcmd.LeakError
+	if !errors.As(err, &le) || !errors.Is(err, fdcmd.ErrLeak) {
+		t.Fatalf("Expected *LeakError, got %v", err)
+	}
+	if le.Spec.Name != "zpool" || len(le.Leaks) != 1 || le.Leaks[0].FD != int(r.Fd()) {
+		t.Errorf("Unexpected error details %+v", le)
+	}
+
+	ctor = fdcmd.New(mockcmd.MakeMockCmdWithOutput("", nil), fdcmd.Options{Enabled: true, Fail: true, Declared: []*os.File{r}})
+	if err := ctor(context.Background(), "zpool", "list").Run(); err != nil {
+		t.Errorf("Expected declared files to be allowed, got %v", err)
+	}
+}
+
+func TestGate(t *testing.T) {
+	leakyPipe(t)
+	base := mockcmd.MakeMockCmdWithOutput("", nil)
+	t.Setenv(fdcmd.EnvVar, "")
+	if err := fdcmd.New(base, fdcmd.Options{Fail: true})(context.Background(), "true").Run(); err != nil {
+		t.Errorf("Expected no audit when disabled, got %v", err)
+	}
+	t.Setenv(fdcmd.EnvVar, "1")
+	if err := fdcmd.New(base, fdcmd.Options{Fail: true})(context.Background(), "true").Run(); !errors.Is(err, fdcmd.ErrLeak) {
+		t.Errorf("Expected EnvVar to enable the audit, got %v", err)
+	}
+}
@@ -0,0 +1,6 @@
+//go:build !unix
+
+package fdcmd
+
+// inherited returns nothing: handles are not inherited unless asked for on Windows.
+func inherited() ([]Leak, error) { return nil, nil }
@@ -0,0 +1,44 @@
+//go:build unix
+
+package fdcmd
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+)
+
+// fdDir lists the open file descriptors of the current process.
+func fdDir() string {
+	if runtime.GOOS == "linux" {
+		return "/proc/self/fd"
+	}
+	return "/dev/fd"
+}
+
+// inherited returns the descriptors above standard error without FD_CLOEXEC.
+func inherited() ([]Leak, error) {
+	dir := fdDir()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var leaks []Leak
+	for _, e := range entries {
+		fd, err := strconv.Atoi(e.Name())
+		if err != nil || fd <= 2 {
+			continue
+		}
+		flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_GETFD, 0)
+		if errno != 0 || flags&syscall.FD_CLOEXEC != 0 {
+			// Closed since it was listed, like the descriptor of the listing, or not
+			// inherited.
+			continue
+		}
+		path, _ := os.Readlink(filepath.Join(dir, e.Name()))
+		leaks = append(leaks, Leak{FD: fd, Path: path})
+	}
+	return leaks, nil
+}
@@ -0,0 +1,155 @@
+package fleet
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// ErrSkipped is the error of the hosts a command was not run on because too many other
+// hosts had failed.
+var ErrSkipped = errors.New("fleet: skipped after too many failures")
+
+// Fleet maps host names to the CommandConstructor running commands on each host.
+type Fleet map[string]cdsexec.CommandConstructor
+
+// Options configures a fan-out.
+type Options struct {
+	// Parallelism bounds the number of hosts running the command at once. Zero runs all
+	// hosts at once.
+	Parallelism int
+	// MaxFailures stops starting the command on further hosts once that many hosts failed,
+	// as a circuit breaker for rollouts. Zero never stops.
+	MaxFailures int
+}
+
+// HostResult is the outcome of the command on one host.
+type HostResult struct {
+	Host string
+	cdsexec.Result
+	// Err is the error of the command, ErrSkipped or the context error for hosts the
+	// command was not run on.
+	Err error
+}
+
+// Results are the outcomes of a fan-out, sorted by host name.
+type Results []HostResult
+
+// Failed returns the results of the hosts the command failed or was not run on.
+func (r Results) Failed() Results {
+	var failed Results
+	for _, hr := range r {
+		if hr.Err != nil {
+			failed = append(failed, hr)
+		}
+	}
+	return failed
+}
+
+// Err returns nil if the command succeeded on every host and an *Error otherwise.
+func (r Results) Err() error {
+	failed := r.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+	return &Error{Failed: failed, Total: len(r)}
+}
+
+// Error reports the hosts a fan-out failed on.
+type Error struct {
+	Failed Results
+	Total  int
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "fleet: %d of %d hosts failed", len(e.Failed), e.Total)
+	for i, hr := range e.Failed {
+		if i == 3 {
+			fmt.Fprintf(&b, "; and %d more", len(e.Failed)-i)
+			break
+		}
+		fmt.Fprintf(&b, "; %s: %v", hr.Host, hr.Err)
+	}
+	return b.String()
+}
+
+// Unwrap returns the errors of the failed hosts.
+func (e *Error) Unwrap() []error {
+	errs := make([]error, len(e.Failed))
+	for i, hr := range e.Failed {
+		errs[i] = hr.Err
+	}
+	return errs
+}
+
+// Run runs spec on every host of f with bounded parallelism and returns the result of each
+// host, collecting the output of each in its Result. The standard streams of spec are not
+// used. The command is not started on further hosts once ctx is done.
+func (f Fleet) Run(ctx context.Context, spec cdsexec.CommandSpec, opts Options) Results {
+	hosts := make([]string, 0, len(f))
+	for h := range f {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+
+	parallelism := opts.Parallelism
+	if parallelism <= 0 || parallelism > len(hosts) {
+		parallelism = len(hosts)
+	}
+	results := make(Results, len(hosts))
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failures := 0
+	for i, host := range hosts {
+		results[i] = HostResult{Host: host, Result: cdsexec.Result{Name: spec.Name, Args: spec.Args, Dir: spec.Dir, Env: spec.Env}}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			results[i].Err = ctx.Err()
+			continue
+		}
+		mu.Lock()
+		tripped := opts.MaxFailures > 0 && failures >= opts.MaxFailures
+		mu.Unlock()
+		if tripped {
+			<-sem
+			results[i].Err = ErrSkipped
+			continue
+		}
+		wg.Add(1)
+		go func(hr *HostResult, ctor cdsexec.CommandConstructor) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			runHost(ctx, hr, ctor, spec)
+			if hr.Err != nil {
+				mu.Lock()
+				failures++
+				mu.Unlock()
+			}
+		}(&results[i], f[host])
+	}
+	wg.Wait()
+	return results
+}
+
+func runHost(ctx context.Context, hr *HostResult, ctor cdsexec.CommandConstructor, spec cdsexec.CommandSpec) {
+	var stdout, stderr bytes.Buffer
+	spec = spec.Clone()
+	spec.Stdin, spec.Stdout, spec.Stderr = nil, &stdout, &stderr
+	clock := cdsexec.ClockFromContext(ctx)
+	hr.StartTime = clock.Now()
+	hr.Err = spec.Command(ctx, ctor).Run()
+	hr.Duration = clock.Now().Sub(hr.StartTime)
+	hr.ExitCode = cdsexec.ExitCode(hr.Err)
+	hr.Stdout, hr.Stderr = stdout.Bytes(), stderr.Bytes()
+}
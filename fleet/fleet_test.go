@@ -0,0 +1,103 @@
+package fleet_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/fleet"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+func TestRun(t *testing.T) {
+	f := fleet.Fleet{
+		"node1": cdsexec.CommandContext,
+		"node2": mockcmd.MakeMockCmdWithOutputSpecificError("", &cdsexec.ExitError{Code: 1}, nil),
+		"node3": mockcmd.MakeMockCmdWithOutput("", nil),
+	}
+	results := f.Run(context.Background(), cdsexec.CommandSpec{Name: "sh", Args: []string{"-c", "echo reloaded; echo warn >&2"}}, fleet.Options{})
+
+	if len(results) != 3 || results[0].Host != "node1" || results[2].Host != "node3" {
+		t.Fatalf("Expected results sorted by host, got %+v", results)
+	}
+	if string(results[0].Stdout) != "reloaded\n" || string(results[0].Stderr) != "warn\n" {
+		t.Errorf("Expected the output of node1, got %q, %q", results[0].Stdout, results[0].Stderr)
+	}
+	if results[1].ExitCode != 1 || results[1].Err == nil {
+		t.Errorf("Expected node2 to fail with exit code 1, got %d, %v", results[1].ExitCode, results[1].Err)
+	}
+
+	err := results.Err()
+	var fe *fleet.Error
+	if !errors.As(err, &fe) || len(fe.Failed) != 1 || fe.Failed[0].Host != "node2" || fe.Total != 3 {
+		t.Fatalf("Expected *fleet.Error for node2, got %v", err)
+	}
+	if cdsexec.ExitCode(err) != 1 {
+		t.Errorf("Expected the host errors to be unwrapped, got %v", err)
+	}
+	if err.Error() != "fleet: 1 of 3 hosts failed; node2: exit status 1" {
+		t.Errorf("Unexpected message %q", err.Error())
+	}
+}
+
+func TestParallelism(t *testing.T) {
+	var running, peak atomic.Int32
+	ctor := mockcmd.MakeMockCmdWithOutput("", func(*mockcmd.MockCmd) error {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		running.Add(-1)
+		return nil
+	})
+	f := fleet.Fleet{}
+	for _, h := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		f[h] = ctor
+	}
+	results := f.Run(context.Background(), cdsexec.CommandSpec{Name: "multipath", Args: []string{"-r"}}, fleet.Options{Parallelism: 3})
+	if err := results.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if p := peak.Load(); p > 3 {
+		t.Errorf("Expected at most 3 hosts at once, got %d", p)
+	}
+}
+
+func TestMaxFailures(t *testing.T) {
+	failing := mockcmd.MakeMockCmdWithOutputSpecificError("", &cdsexec.ExitError{Code: 1}, nil)
+	f := fleet.Fleet{"a": failing, "b": failing, "c": failing, "d": failing}
+	results := f.Run(context.Background(), cdsexec.CommandSpec{Name: "multipath", Args: []string{"-r"}}, fleet.Options{Parallelism: 1, MaxFailures: 2})
+
+	skipped := 0
+	for _, hr := range results {
+		if errors.Is(hr.Err, fleet.ErrSkipped) {
+			skipped++
+		}
+	}
+	if skipped != 2 {
+		t.Errorf("Expected 2 skipped hosts, got %d: %+v", skipped, results)
+	}
+	if len(results.Failed()) != 4 {
+		t.Errorf("Expected all hosts to be reported as failed, got %d", len(results.Failed()))
+	}
+}
+
+func TestCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ctor := mockcmd.MakeMockCmdWithOutput("", func(*mockcmd.MockCmd) error {
+		cancel()
+		return nil
+	})
+	f := fleet.Fleet{"a": ctor, "b": ctor, "c": ctor}
+	results := f.Run(ctx, cdsexec.CommandSpec{Name: "true"}, fleet.Options{Parallelism: 1})
+	if results[0].Err != nil || !errors.Is(results[2].Err, context.Canceled) {
+		t.Errorf("Expected the hosts after the cancellation not to run, got %+v", results)
+	}
+}
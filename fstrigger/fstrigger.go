@@ -0,0 +1,272 @@
+package fstrigger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/fsnotify/fsnotify"
+)
+
+// EnvPaths is the environment variable giving a command the paths whose changes triggered
+// it, separated by os.PathListSeparator.
+const EnvPaths = "CDSEXEC_TRIGGER_PATHS"
+
+// Concurrency tells what becomes of the changes made while the command of a rule runs.
+type Concurrency int
+
+const (
+	// Coalesce runs the command once more after the running one, for all the changes made
+	// while it ran.
+	Coalesce Concurrency = iota
+	// Skip ignores the changes made while the command runs.
+	Skip
+	// Parallel runs the command for those changes while the previous one still runs.
+	Parallel
+)
+
+// Rule runs a command when watched paths change.
+type Rule struct {
+	// Name identifies the rule in runs. Empty means the command line of Spec.
+	Name string
+	// Paths are the files and directories watched. The changes of the entries of a
+	// directory are reported, but not those of its subdirectories.
+	Paths []string
+	// Pattern, if set, restricts the rule to the changed paths whose base name it matches,
+	// as with filepath.Match, e.g. "sd*".
+	Pattern string
+	// Ops are the kinds of changes the rule reacts to. Zero means all.
+	Ops fsnotify.Op
+	// Spec is the command run. It is given the changed paths in EnvPaths.
+	Spec cdsexec.CommandSpec
+	// Debounce waits for the paths to be quiet for that long before running the command,
+	// so that a burst of changes runs it once. Zero runs it at the first change.
+	Debounce time.Duration
+	// PerPath handles each changed path on its own: changes are debounced, and the command
+	// is run and kept from overlapping, for each path separately.
+	PerPath bool
+	// Concurrency applies to the runs of the rule, or of each path with PerPath.
+	Concurrency Concurrency
+}
+
+func (r Rule) name() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return r.Spec.String()
+}
+
+// Run is the outcome of a command run by a rule.
+type Run struct {
+	Rule string
+	// Paths are the changed paths that triggered the run, sorted.
+	Paths []string
+	cdsexec.Result
+	Err error
+}
+
+// Trigger runs the commands of its rules as the paths they watch change.
+type Trigger struct {
+	// Constructor constructs the commands. Nil means cdsexec.CommandContext.
+	Constructor cdsexec.CommandConstructor
+	Rules       []Rule
+	// OnRun, if set, is called after each command has run.
+	OnRun func(Run)
+
+	mu     sync.Mutex
+	states map[key]*state
+	wg     sync.WaitGroup
+}
+
+type key struct {
+	rule int
+	path string
+}
+
+// state is the state of a rule, or of a path of a rule with PerPath.
+type state struct {
+	pending map[string]bool
+	timer   cdsexec.Timer
+	running bool
+	again   bool
+}
+
+// Run watches the paths of the rules and runs their commands as the paths change, until
+// ctx is done, measuring debounce delays on the Clock of ctx. It then waits for the
+// commands running, which are cancelled with ctx, and returns the error of ctx, or the
+// error of the watcher if it fails. If the kernel drops events because too many happened
+// at once, every rule runs its command for all of its paths.
+func (t *Trigger) Run(ctx context.Context) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("fstrigger: %w", err)
+	}
+	defer w.Close()
+	watched := make(map[string]bool)
+	for _, r := range t.Rules {
+		if r.Pattern != "" {
+			if _, err := filepath.Match(r.Pattern, ""); err != nil {
+				return fmt.Errorf("fstrigger: rule %s: %w", r.name(), err)
+			}
+		}
+		for _, p := range r.Paths {
+			p = filepath.Clean(p)
+			if watched[p] {
+				continue
+			}
+			if err := w.Add(p); err != nil {
+				return fmt.Errorf("fstrigger: watching %s: %w", p, err)
+			}
+			watched[p] = true
+		}
+	}
+	t.mu.Lock()
+	t.states = make(map[key]*state)
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		for _, st := range t.states {
+			if st.timer != nil {
+				st.timer.Stop()
+			}
+		}
+		t.mu.Unlock()
+		t.wg.Wait()
+	}()
+
+	for {
+		select {
+		case ev, ok := <-w.Events:
+			if !ok {
+				return ctx.Err()
+			}
+			t.event(ctx, ev)
+		case err, ok := <-w.Errors:
+			if !ok {
+				return ctx.Err()
+			}
+			if !errors.Is(err, fsnotify.ErrEventOverflow) {
+				return fmt.Errorf("fstrigger: %w", err)
+			}
+			for i, r := range t.Rules {
+				for _, p := range r.Paths {
+					t.change(ctx, i, filepath.Clean(p))
+				}
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// event hands ev to the rules it concerns.
+func (t *Trigger) event(ctx context.Context, ev fsnotify.Event) {
+	name := filepath.Clean(ev.Name)
+	for i, r := range t.Rules {
+		if r.Ops != 0 && ev.Op&r.Ops == 0 {
+			continue
+		}
+		if r.Pattern != "" {
+			if ok, _ := filepath.Match(r.Pattern, filepath.Base(name)); !ok {
+				continue
+			}
+		}
+		for _, p := range r.Paths {
+			p = filepath.Clean(p)
+			if name == p || filepath.Dir(name) == p {
+				t.change(ctx, i, name)
+				break
+			}
+		}
+	}
+}
+
+// change records that path changed for the rule i and schedules its command.
+func (t *Trigger) change(ctx context.Context, i int, path string) {
+	r := t.Rules[i]
+	k := key{rule: i}
+	if r.PerPath {
+		k.path = path
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st := t.states[k]
+	if st == nil {
+		st = &state{pending: make(map[string]bool)}
+		t.states[k] = st
+	}
+	st.pending[path] = true
+	if r.Debounce <= 0 {
+		t.fireLocked(ctx, k, st)
+		return
+	}
+	if st.timer != nil {
+		st.timer.Reset(r.Debounce)
+		return
+	}
+	st.timer = cdsexec.ClockFromContext(ctx).AfterFunc(r.Debounce, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.fireLocked(ctx, k, st)
+	})
+}
+
+// fireLocked runs the command of k for its pending paths, as its Concurrency allows.
+func (t *Trigger) fireLocked(ctx context.Context, k key, st *state) {
+	r := t.Rules[k.rule]
+	if ctx.Err() != nil || len(st.pending) == 0 {
+		return
+	}
+	if st.running && r.Concurrency != Parallel {
+		if r.Concurrency == Skip {
+			clear(st.pending)
+		} else {
+			st.again = true
+		}
+		return
+	}
+	paths := make([]string, 0, len(st.pending))
+	for p := range st.pending {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	clear(st.pending)
+	st.running = true
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		t.run(ctx, r, paths)
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		st.running = false
+		if st.again {
+			st.again = false
+			t.fireLocked(ctx, k, st)
+		}
+	}()
+}
+
+// run runs the command of r for paths.
+func (t *Trigger) run(ctx context.Context, r Rule, paths []string) {
+	ctor := t.Constructor
+	if ctor == nil {
+		ctor = cdsexec.CommandContext
+	}
+	spec := r.Spec
+	spec.Env = cdsexec.MergeEnv(spec.Env, EnvPaths+"="+strings.Join(paths, string(os.PathListSeparator)))
+	results, err := cdsexec.OutputAll(ctx, ctor, []cdsexec.CommandSpec{spec}, 1)
+	var ge *cdsexec.GroupError
+	if errors.As(err, &ge) {
+		err = ge.Errs[0]
+	}
+	if t.OnRun != nil {
+		t.OnRun(Run{Rule: r.name(), Paths: paths, Result: results[0], Err: err})
+	}
+}
@@ -0,0 +1,185 @@
+package fstrigger_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/fstrigger"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+	"github.com/fsnotify/fsnotify"
+)
+
+// start runs tr until the test ends and returns the channel its runs are sent on.
+func start(t *testing.T, tr *fstrigger.Trigger) <-chan fstrigger.Run {
+	t.Helper()
+	runs := make(chan fstrigger.Run, 16)
+	tr.OnRun = func(r fstrigger.Run) { runs <- r }
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- tr.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+	})
+	// Let the watcher start.
+	time.Sleep(50 * time.Millisecond)
+	return runs
+}
+
+func touch(t *testing.T, path string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte("1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func next(t *testing.T, runs <-chan fstrigger.Run) fstrigger.Run {
+	t.Helper()
+	select {
+	case r := <-runs:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a run")
+		return fstrigger.Run{}
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	dev := t.TempDir()
+	var env []string
+	base := mockcmd.MakeMockCmdWithOutput("", func(m *mockcmd.MockCmd) error {
+		env = m.Env
+		return nil
+	})
+	runs := start(t, &fstrigger.Trigger{
+		Constructor: base,
+		Rules: []fstrigger.Rule{{
+			Name:     "rescan",
+			Paths:    []string{dev},
+			Pattern:  "sd*",
+			Ops:      fsnotify.Create,
+			Spec:     cdsexec.CommandSpec{Name: "multipath", Args: []string{"-r"}},
+			Debounce: 200 * time.Millisecond,
+		}},
+	})
+
+	for _, name := range []string{"sda", "sdb", "loop0", "sdc"} {
+		touch(t, filepath.Join(dev, name))
+	}
+	r := next(t, runs)
+	want := []string{filepath.Join(dev, "sda"), filepath.Join(dev, "sdb"), filepath.Join(dev, "sdc")}
+	if r.Rule != "rescan" || r.Err != nil || strings.Join(r.Paths, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected one run for %v, got %+v", want, r)
+	}
+	if v, _ := cdsexec.LookupEnv(env, fstrigger.EnvPaths); v != strings.Join(want, string(os.PathListSeparator)) {
+		t.Errorf("Expected the paths in %s, got %q", fstrigger.EnvPaths, v)
+	}
+	select {
+	case r := <-runs:
+		t.Errorf("Unexpected run %+v", r)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestPerPathCoalesce(t *testing.T) {
+	dev := t.TempDir()
+	sda, sdb := filepath.Join(dev, "sda"), filepath.Join(dev, "sdb")
+	entered := make(chan string, 4)
+	release := make(chan struct{})
+	base := mockcmd.MakeMockCmdWithOutput("", func(m *mockcmd.MockCmd) error {
+		path, _ := cdsexec.LookupEnv(m.Env, fstrigger.EnvPaths)
+		entered <- path
+		if path == sda {
+			select {
+			case <-release:
+			case <-m.Ctx.Done():
+			}
+		}
+		return nil
+	})
+	touch(t, sda)
+	touch(t, sdb)
+	runs := start(t, &fstrigger.Trigger{
+		Constructor: base,
+		Rules: []fstrigger.Rule{{
+			Paths:    []string{dev},
+			Ops:      fsnotify.Write,
+			Spec:     cdsexec.CommandSpec{Name: "sg_inq"},
+			Debounce: 50 * time.Millisecond,
+			PerPath:  true,
+		}},
+	})
+
+	touch(t, sda)
+	if p := <-entered; p != sda {
+		t.Fatalf("Expected a run for %s, got %s", sda, p)
+	}
+	// sdb runs while sda does; the changes of sda wait for its run to finish.
+	touch(t, sdb)
+	if p := <-entered; p != sdb {
+		t.Fatalf("Expected a run for %s, got %s", sdb, p)
+	}
+	touch(t, sda)
+	touch(t, sda)
+	time.Sleep(200 * time.Millisecond)
+	select {
+	case p := <-entered:
+		t.Fatalf("Unexpected overlapping run for %s", p)
+	default:
+	}
+	close(release)
+	if p := <-entered; p != sda {
+		t.Fatalf("Expected %s to run again, got %s", sda, p)
+	}
+	for i := 0; i < 3; i++ {
+		next(t, runs)
+	}
+	select {
+	case p := <-entered:
+		t.Errorf("Expected the changes to be coalesced into one run, got another for %s", p)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSkip(t *testing.T) {
+	dev := t.TempDir()
+	entered := make(chan struct{}, 4)
+	release := make(chan struct{})
+	base := mockcmd.MakeMockCmdWithOutput("", func(m *mockcmd.MockCmd) error {
+		entered <- struct{}{}
+		select {
+		case <-release:
+		case <-m.Ctx.Done():
+		}
+		return nil
+	})
+	runs := start(t, &fstrigger.Trigger{
+		Constructor: base,
+		Rules: []fstrigger.Rule{{
+			Paths:       []string{dev},
+			Spec:        cdsexec.CommandSpec{Name: "partprobe"},
+			Concurrency: fstrigger.Skip,
+		}},
+	})
+	touch(t, filepath.Join(dev, "sda"))
+	<-entered
+	touch(t, filepath.Join(dev, "sdb"))
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	if r := next(t, runs); len(r.Paths) != 1 || r.Rule != "partprobe" {
+		t.Errorf("Unexpected run %+v", r)
+	}
+	select {
+	case <-entered:
+		t.Errorf("Expected the changes made during the run to be skipped")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
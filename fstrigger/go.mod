@@ -0,0 +1,12 @@
+module github.com/cirrusdata/cdsexec/fstrigger
+
+go 1.22.0
+
+require (
+	github.com/cirrusdata/cdsexec v0.0.0
+	github.com/fsnotify/fsnotify v1.7.0
+)
+
+require golang.org/x/sys v0.4.0 // indirect
+
+replace github.com/cirrusdata/cdsexec => ../
@@ -0,0 +1,136 @@
+package cdsexec_test
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// FuzzQuoting checks that no argument escapes its argv slot when a spec is rendered as a
+// command line, with or without an environment and directory.
+func FuzzQuoting(f *testing.F) {
+	for _, seed := range []string{"", "plain", "a b", "it's", `"$(reboot)"`, "`id`", "a;b|c&&d", "#x", "~root", "*", "\\", "\n", "\x00\xff", "--opt=1"} {
+		f.Add(seed, seed, "CDSEXEC_FUZZ")
+	}
+	f.Add("v", "d", "A;reboot")
+	f.Fuzz(func(t *testing.T, a, b, key string) {
+		spec := cdsexec.CommandSpec{Name: "cmd", Args: []string{a, b}}
+		words, err := cdsexec.SplitShellLine(spec.String())
+		if err != nil {
+			t.Fatalf("Unexpected error splitting %q: %v", spec.String(), err)
+		}
+		if want := []string{"cmd", a, b}; !reflect.DeepEqual(words, want) {
+			t.Fatalf("Expected %q, got %q from %q", want, words, spec.String())
+		}
+
+		if key == "" || strings.ContainsAny(key, "=\x00") || strings.HasPrefix(key, "-") {
+			return
+		}
+		if _, ok := os.LookupEnv(key); ok {
+			return
+		}
+		spec.Env = append(os.Environ(), key+"="+a)
+		spec.Dir = b
+		words, err = cdsexec.SplitShellLine(spec.ShellLine())
+		if err != nil {
+			t.Fatalf("Unexpected error splitting %q: %v", spec.ShellLine(), err)
+		}
+		if words[0] == "env" {
+			words = words[1:]
+		}
+		if want := []string{key + "=" + a, "cmd", a, b}; !reflect.DeepEqual(words, want) {
+			t.Fatalf("Expected %q, got %q from %q", want, words, spec.ShellLine())
+		}
+	})
+}
+
+// FuzzCommandTemplate checks that parameters never change the number of arguments, and
+// that only plain tokens are accepted.
+func FuzzCommandTemplate(f *testing.F) {
+	for _, seed := range []string{"tank", "-rf", "a b", "x;reboot", "{pool}", "}}", "", "\x00"} {
+		f.Add(seed)
+	}
+	tmpl := cdsexec.CommandTemplate{Name: "zfs", Args: []string{"destroy", "{pool}", "{pool}/{pool}@snap"}}
+	f.Fuzz(func(t *testing.T, pool string) {
+		spec, err := tmpl.Spec(map[string]string{"pool": pool})
+		if err != nil {
+			if !errors.Is(err, cdsexec.ErrInvalidTemplate) {
+				t.Fatalf("Expected ErrInvalidTemplate, got %v", err)
+			}
+			return
+		}
+		if len(spec.Args) != len(tmpl.Args) || spec.Args[1] != pool {
+			t.Fatalf("Expected %d arguments with %q, got %q", len(tmpl.Args), pool, spec.Args)
+		}
+		if strings.HasPrefix(pool, "-") || strings.ContainsAny(pool, " \t\n;&|$`'\"\\<>(){}*?~#") {
+			t.Fatalf("Expected %q to be rejected", pool)
+		}
+		if words, err := cdsexec.SplitShellLine(spec.String()); err != nil || len(words) != 1+len(spec.Args) {
+			t.Fatalf("Expected %d words, got %q, %v", 1+len(spec.Args), words, err)
+		}
+	})
+}
+
+// FuzzRedactor checks that secrets passed to redacted flags and variables never appear in
+// the rendered command line, and that redaction keeps the arguments in place.
+func FuzzRedactor(f *testing.F) {
+	for _, seed := range []string{"hunter2", "", "***", "--password", "a b", "=", "\xff"} {
+		f.Add(seed, "plain")
+	}
+	r := cdsexec.NewRedactor(cdsexec.RedactFlags("--password"), cdsexec.RedactEnv("*_TOKEN"))
+	f.Fuzz(func(t *testing.T, secret, other string) {
+		spec := cdsexec.CommandSpec{
+			Name: "login",
+			Args: []string{other, "--password", secret, "--password=" + secret},
+			Env:  []string{"API_TOKEN=" + secret},
+		}
+		red := r.Spec(spec)
+		if len(red.Args) != len(spec.Args) || red.Args[0] != other && other != "--password" {
+			t.Fatalf("Expected the arguments kept in place, got %q", red.Args)
+		}
+		words, err := cdsexec.SplitShellLine(red.String())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if words[3] != cdsexec.DefaultMask && other != "--password" || words[4] != "--password="+cdsexec.DefaultMask {
+			t.Fatalf("Expected the secret masked, got %q", words)
+		}
+		if red.Env[0] != "API_TOKEN="+cdsexec.DefaultMask {
+			t.Fatalf("Expected the variable masked, got %q", red.Env[0])
+		}
+	})
+}
+
+func TestSplitShellLine(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected []string
+		err      bool
+	}{
+		{line: `zfs list -H`, expected: []string{"zfs", "list", "-H"}},
+		{line: `echo 'a b' "c \"d\" \$e" f\ g`, expected: []string{"echo", "a b", `c "d" $e`, "f g"}},
+		{line: `env -u HOME LANG=C ls '' # cwd: /tmp`, expected: []string{"env", "-u", "HOME", "LANG=C", "ls", ""}},
+		{line: `a#b`, expected: []string{"a#b"}},
+		{line: `echo $HOME`, err: true},
+		{line: `echo "$(id)"`, err: true},
+		{line: `ls; reboot`, err: true},
+		{line: `ls *.img`, err: true},
+		{line: `echo 'unterminated`, err: true},
+	}
+	for _, tt := range tests {
+		words, err := cdsexec.SplitShellLine(tt.line)
+		if tt.err {
+			if !errors.Is(err, cdsexec.ErrShellSyntax) {
+				t.Errorf("%s: expected ErrShellSyntax, got %q, %v", tt.line, words, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(words, tt.expected) {
+			t.Errorf("%s: expected %q, got %q, %v", tt.line, tt.expected, words, err)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package cdsexec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// GroupMode selects how a Group reacts to a failed command.
+type GroupMode int
+
+const (
+	// CollectAll runs every command regardless of failures.
+	CollectAll GroupMode = iota
+	// FailFast cancels the running commands and skips the remaining ones once a command
+	// fails.
+	FailFast
+)
+
+// Group runs commands concurrently and collects their results.
+type Group struct {
+	// Constructor constructs the commands. Nil means CommandContext.
+	Constructor CommandConstructor
+	// Parallelism bounds the number of commands running at once. Zero runs all at once.
+	Parallelism int
+	// Mode selects what happens when a command fails.
+	Mode GroupMode
+}
+
+// Run runs specs and returns the result of each, in the order of specs. The standard
+// output and error of specs that do not set them are collected in their Result. It returns
+// nil if every command succeeded and a *GroupError otherwise.
+func (g *Group) Run(ctx context.Context, specs ...CommandSpec) ([]Result, error) {
+	ctor := g.Constructor
+	if ctor == nil {
+		ctor = CommandContext
+	}
+	parallelism := g.Parallelism
+	if parallelism <= 0 || parallelism > len(specs) {
+		parallelism = len(specs)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]Result, len(specs))
+	errs := make([]error, len(specs))
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	first := -1
+	for i, spec := range specs {
+		results[i] = Result{Name: spec.Name, Args: spec.Args, Dir: spec.Dir, Env: spec.Env}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if err := ctx.Err(); err != nil {
+			errs[i] = err
+			continue
+		}
+		wg.Add(1)
+		go func(i int, spec CommandSpec) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			err := runSpec(ctx, ctor, spec, &results[i])
+			if err == nil {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			errs[i] = err
+			if first < 0 {
+				first = i
+				if g.Mode == FailFast {
+					cancel()
+				}
+			}
+		}(i, spec)
+	}
+	wg.Wait()
+
+	if first < 0 {
+		// Commands may still have been skipped because ctx was cancelled.
+		first = firstError(errs)
+	}
+	if first < 0 {
+		return results, nil
+	}
+	return results, &GroupError{Names: specNames(specs), Errs: errs, First: first}
+}
+
+// OutputAll runs specs with ctor, nil meaning CommandContext, at most parallelism at once,
+// and returns the result of each, in the order of specs. It is Group.Run with CollectAll,
+// tuned for many small commands: parallelism workers run the commands one after the other,
+// each collecting output into buffers of its own reused from one command to the next, so
+// that the cost of a command is little more than spawning it. Zero parallelism means
+// GOMAXPROCS.
+//
+// The standard output and error of specs that do not set them are collected in their
+// Result. It returns nil if every command succeeded and a *GroupError otherwise; commands
+// not started because ctx is done fail with its error.
+func OutputAll(ctx context.Context, ctor CommandConstructor, specs []CommandSpec, parallelism int) ([]Result, error) {
+	if ctor == nil {
+		ctor = CommandContext
+	}
+	if parallelism <= 0 {
+		parallelism = runtime.GOMAXPROCS(0)
+	}
+	if parallelism > len(specs) {
+		parallelism = len(specs)
+	}
+	results := make([]Result, len(specs))
+	errs := make([]error, len(specs))
+	var next atomic.Int64
+	work := func() {
+		var stdout, stderr bytes.Buffer
+		for {
+			i := int(next.Add(1)) - 1
+			if i >= len(specs) {
+				return
+			}
+			spec := specs[i]
+			results[i] = Result{Name: spec.Name, Args: spec.Args, Dir: spec.Dir, Env: spec.Env}
+			if err := ctx.Err(); err != nil {
+				errs[i] = err
+				continue
+			}
+			var out, errOut *bytes.Buffer
+			if spec.Stdout == nil {
+				stdout.Reset()
+				out = &stdout
+			}
+			if spec.Stderr == nil {
+				stderr.Reset()
+				errOut = &stderr
+			}
+			err := runSpecInto(ctx, ctor, spec, &results[i], out, errOut)
+			if out != nil && out.Len() > 0 {
+				results[i].Stdout = bytes.Clone(out.Bytes())
+			}
+			if errOut != nil && errOut.Len() > 0 {
+				results[i].Stderr = bytes.Clone(errOut.Bytes())
+			}
+			errs[i] = commandError(spec.Name, err, results[i].Stderr)
+		}
+	}
+	var wg sync.WaitGroup
+	for w := 1; w < parallelism; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			work()
+		}()
+	}
+	// The calling goroutine is one of the workers.
+	work()
+	wg.Wait()
+
+	if first := firstError(errs); first >= 0 {
+		return results, &GroupError{Names: specNames(specs), Errs: errs, First: first}
+	}
+	return results, nil
+}
+
+// runSpec runs spec with ctor, filling r.
+func runSpec(ctx context.Context, ctor CommandConstructor, spec CommandSpec, r *Result) error {
+	var stdout, stderr *bytes.Buffer
+	if spec.Stdout == nil {
+		stdout = getBuffer(spec.Name)
+	}
+	if spec.Stderr == nil {
+		stderr = getBuffer("")
+	}
+	err := runSpecInto(ctx, ctor, spec, r, stdout, stderr)
+	if stdout != nil {
+		r.Stdout = detachBuffer(spec.Name, stdout)
+	}
+	if stderr != nil {
+		r.Stderr = detachBuffer("", stderr)
+	}
+	return commandError(spec.Name, err, r.Stderr)
+}
+
+// runSpecInto runs spec with ctor, filling r except for its output, which is collected into
+// stdout and stderr when they are not nil.
+func runSpecInto(ctx context.Context, ctor CommandConstructor, spec CommandSpec, r *Result, stdout, stderr *bytes.Buffer) error {
+	if stdout != nil {
+		spec.Stdout = stdout
+	}
+	if stderr != nil {
+		spec.Stderr = stderr
+	}
+	cmd := spec.Command(ctx, ctor)
+	clock := ClockFromContext(ctx)
+	r.StartTime = clock.Now()
+	err := cmd.Run()
+	r.Duration = clock.Now().Sub(r.StartTime)
+	r.ExitCode = exitCodeOf(cmd.ProcessState(), err)
+	return err
+}
+
+func specNames(specs []CommandSpec) []string {
+	names := make([]string, len(specs))
+	for i, s := range specs {
+		names[i] = s.Name
+	}
+	return names
+}
+
+func firstError(errs []error) int {
+	for i, err := range errs {
+		if err != nil {
+			return i
+		}
+	}
+	return -1
+}
+
+// GroupError reports the commands of a Group that failed or were not run.
+type GroupError struct {
+	// Names are the names of the commands of the group.
+	Names []string
+	// Errs holds the error of every command, nil for those that succeeded, and a
+	// *CommandError for those that exited unsuccessfully. With FailFast,
+	// the commands cancelled after the first failure have the error they were cancelled with.
+	Errs []error
+	// First is the index of the first command that failed.
+	First int
+}
+
+func (e *GroupError) Error() string {
+	failed := 0
+	for _, err := range e.Errs {
+		if err != nil {
+			failed++
+		}
+	}
+	err := e.Errs[e.First]
+	var ce *CommandError
+	if errors.As(err, &ce) && ce.Name == e.Names[e.First] {
+		// The error names the command already.
+		return fmt.Sprintf("cdsexec: %d of %d commands failed, first %v", failed, len(e.Errs), err)
+	}
+	return fmt.Sprintf("cdsexec: %d of %d commands failed, first %s: %v", failed, len(e.Errs), e.Names[e.First], err)
+}
+
+// Unwrap returns the errors of the failed commands, starting with the first failure.
+func (e *GroupError) Unwrap() []error {
+	errs := []error{e.Errs[e.First]}
+	for i, err := range e.Errs {
+		if err != nil && i != e.First {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,131 @@
+package cdsexec_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+func TestGroup(t *testing.T) {
+	g := &cdsexec.Group{Parallelism: 2}
+	results, err := g.Run(context.Background(),
+		cdsexec.CommandSpec{Name: "sh", Args: []string{"-c", "echo sda"}},
+		cdsexec.CommandSpec{Name: "sh", Args: []string{"-c", "echo 'read failure' >&2; exit 4"}},
+		cdsexec.CommandSpec{Name: "sh", Args: []string{"-c", "echo sdc"}},
+	)
+	if len(results) != 3 || string(results[0].Stdout) != "sda\n" || string(results[2].Stdout) != "sdc\n" {
+		t.Errorf("Expected the output of every command, got %+v", results)
+	}
+	if results[1].ExitCode != 4 || string(results[1].Stderr) != "read failure\n" {
+		t.Errorf("Expected the failure of the second command, got %+v", results[1])
+	}
+	var ge *cdsexec.GroupError
+	if !errors.As(err, &ge) || ge.First != 1 || cdsexec.ExitCode(err) != 4 {
+		t.Fatalf("Expected *GroupError for the second command, got %v", err)
+	}
+	if err.Error() != "cdsexec: 1 of 3 commands failed, first sh: exit status 4: read failure" {
+		t.Errorf("Unexpected message %q", err.Error())
+	}
+}
+
+func TestGroupParallelism(t *testing.T) {
+	var running, peak atomic.Int32
+	ctor := mockcmd.MakeMockCmdWithOutput("", func(*mockcmd.MockCmd) error {
+		n := running.Add(1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		time.Sleep(10 * time.Millisecond)
+		running.Add(-1)
+		return nil
+	})
+	specs := make([]cdsexec.CommandSpec, 10)
+	for i := range specs {
+		specs[i] = cdsexec.CommandSpec{Name: "smartctl", Args: []string{"-a"}}
+	}
+	g := &cdsexec.Group{Constructor: ctor, Parallelism: 3}
+	if _, err := g.Run(context.Background(), specs...); err != nil {
+		t.Fatal(err)
+	}
+	if p := peak.Load(); p > 3 {
+		t.Errorf("Expected at most 3 commands at once, got %d", p)
+	}
+}
+
+func TestGroupFailFast(t *testing.T) {
+	g := &cdsexec.Group{Parallelism: 2, Mode: cdsexec.FailFast}
+	start := time.Now()
+	results, err := g.Run(context.Background(),
+		cdsexec.CommandSpec{Name: "sleep", Args: []string{"5"}},
+		cdsexec.CommandSpec{Name: "false"},
+		cdsexec.CommandSpec{Name: "echo", Args: []string{"never"}},
+	)
+	if time.Since(start) > 2*time.Second {
+		t.Error("Expected the running commands to be cancelled")
+	}
+	var ge *cdsexec.GroupError
+	if !errors.As(err, &ge) || ge.First != 1 {
+		t.Fatalf("Expected the second command to fail first, got %v", err)
+	}
+	if !errors.Is(ge.Errs[2], context.Canceled) || results[2].Stdout != nil {
+		t.Errorf("Expected the third command to be skipped, got %v", ge.Errs[2])
+	}
+}
+
+func TestOutputAll(t *testing.T) {
+	specs := make([]cdsexec.CommandSpec, 50)
+	for i := range specs {
+		specs[i] = cdsexec.CommandSpec{Name: "sh", Args: []string{"-c", fmt.Sprintf("echo sd%d", i)}}
+	}
+	specs[7] = cdsexec.CommandSpec{Name: "sh", Args: []string{"-c", "echo 'read failure' >&2; exit 4"}}
+	var own strings.Builder
+	specs[9].Stdout = &own
+
+	results, err := cdsexec.OutputAll(context.Background(), nil, specs, 4)
+	for i, r := range results {
+		want := fmt.Sprintf("sd%d\n", i)
+		if i == 7 || i == 9 {
+			want = ""
+		}
+		if string(r.Stdout) != want {
+			t.Errorf("Expected %q from command %d, got %q", want, i, r.Stdout)
+		}
+	}
+	if own.String() != "sd9\n" {
+		t.Errorf("Expected the output of command 9 in its own writer, got %q", own.String())
+	}
+	if results[7].ExitCode != 4 || string(results[7].Stderr) != "read failure\n" || results[8].Stderr != nil {
+		t.Errorf("Expected the stderr of the failed command only, got %+v", results[7])
+	}
+	var ge *cdsexec.GroupError
+	if !errors.As(err, &ge) || ge.First != 7 || cdsexec.ExitCode(err) != 4 {
+		t.Fatalf("Expected *GroupError for command 7, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results, err = cdsexec.OutputAll(ctx, mockcmd.MakeMockCmdWithOutput("ok", nil), specs[:3], 0)
+	if !errors.As(err, &ge) || len(results) != 3 || !errors.Is(ge.Errs[2], context.Canceled) {
+		t.Errorf("Expected every command to be skipped, got %v", err)
+	}
+}
+
+func TestOutputAllClock(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	clock := mockcmd.NewFakeClock(start)
+	ctx := cdsexec.ContextWithClock(context.Background(), clock)
+	base := mockcmd.MakeMockCmdWithOutput("", func(*mockcmd.MockCmd) error {
+		clock.Advance(time.Second)
+		return nil
+	})
+	results, err := cdsexec.OutputAll(ctx, base, []cdsexec.CommandSpec{{Name: "smartctl"}}, 1)
+	if err != nil || !results[0].StartTime.Equal(start) || results[0].Duration != time.Second {
+		t.Errorf("Expected the result to be timed on the clock of the context, got %+v, %v", results, err)
+	}
+}
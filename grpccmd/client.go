@@ -0,0 +1,137 @@
+package grpccmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/grpccmd/agentpb"
+	"google.golang.org/grpc"
+)
+
+// stdinChunkSize is the size of the stdin chunks sent to the agent.
+const stdinChunkSize = 32 << 10
+
+// New returns a CommandConstructor executing commands on the agent conn is connected to.
+// Non-zero exits are reported as *cdsexec.ExitError and missing executables match
+// cdsexec.ErrNotFound. Cancelling the context kills the remote command.
+func New(conn grpc.ClientConnInterface) cdsexec.CommandConstructor {
+	client := agentpb.NewAgentClient(conn)
+	return cdsexec.StreamConstructor(func(ctx context.Context, spec cdsexec.CommandSpec) error {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		stream, err := client.Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("grpccmd: %w", err)
+		}
+		err = stream.Send(&agentpb.ExecRequest{Payload: &agentpb.ExecRequest_Command{Command: &agentpb.Command{
+			Name:   spec.Name,
+			Args:   spec.Args,
+			Dir:    spec.Dir,
+			Env:    spec.Env,
+			SetEnv: spec.Env != nil,
+			Stdin:  spec.Stdin != nil,
+		}}})
+		if err != nil {
+			return fmt.Errorf("grpccmd: %w", err)
+		}
+		// stdinErr is written before the agent sees the end of stdin, so it is known by the
+		// time a command that read all its stdin exits.
+		stdinErr := make(chan error, 1)
+		if spec.Stdin != nil {
+			go func() {
+				stdinErr <- sendStdin(stream, spec.Stdin)
+				stream.CloseSend()
+			}()
+		} else {
+			stream.CloseSend()
+		}
+
+		for {
+			resp, err := stream.Recv()
+			if err != nil {
+				if ctx.Err() != nil {
+					return fmt.Errorf("grpccmd: %w", ctx.Err())
+				}
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return fmt.Errorf("grpccmd: %w", err)
+			}
+			switch p := resp.Payload.(type) {
+			case *agentpb.ExecResponse_Stdout:
+				if err := write(spec.Stdout, p.Stdout); err != nil {
+					return fmt.Errorf("grpccmd: writing stdout: %w", err)
+				}
+			case *agentpb.ExecResponse_Stderr:
+				if err := write(spec.Stderr, p.Stderr); err != nil {
+					return fmt.Errorf("grpccmd: writing stderr: %w", err)
+				}
+			case *agentpb.ExecResponse_Exit:
+				if err := exitError(p.Exit); err != nil {
+					return err
+				}
+				select {
+				case err := <-stdinErr:
+					if err != nil {
+						return fmt.Errorf("grpccmd: sending stdin: %w", err)
+					}
+				default:
+				}
+				return nil
+			}
+		}
+	})
+}
+
+// write writes p to w, if w is not nil.
+func write(w io.Writer, p []byte) error {
+	if w == nil {
+		return nil
+	}
+	_, err := w.Write(p)
+	return err
+}
+
+// sendStdin streams r to the agent and ends the command's stdin at EOF. It returns the error
+// of reading r or of sending it, except io.EOF from Send: that means the agent has ended
+// the call, and its status is reported by Recv.
+func sendStdin(stream agentpb.Agent_ExecClient, r io.Reader) error {
+	buf := make([]byte, stdinChunkSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			chunk := append([]byte(nil), buf[:n]...)
+			if err := stream.Send(&agentpb.ExecRequest{Payload: &agentpb.ExecRequest_Stdin{Stdin: chunk}}); err != nil {
+				return ignoreEOF(err)
+			}
+		}
+		if err == io.EOF {
+			return ignoreEOF(stream.Send(&agentpb.ExecRequest{Payload: &agentpb.ExecRequest_StdinEof{StdinEof: true}}))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func ignoreEOF(err error) error {
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+func exitError(exit *agentpb.Exit) error {
+	switch {
+	case exit.NotFound:
+		return fmt.Errorf("grpccmd: %s: %w", exit.Error, cdsexec.ErrNotFound)
+	case exit.Error != "":
+		return errors.New("grpccmd: " + exit.Error)
+	case exit.Code != 0 || exit.Signal != "":
+		return &cdsexec.ExitError{Code: int(exit.Code), Signal: exit.Signal}
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package grpccmd_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/grpccmd"
+	"github.com/cirrusdata/cdsexec/grpccmd/agentpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+func newAgent(t *testing.T) cdsexec.CommandConstructor {
+	t.Helper()
+	ln := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	agentpb.RegisterAgentServer(srv, grpccmd.NewServer(nil))
+	go srv.Serve(ln)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///agent",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return grpccmd.New(conn)
+}
+
+func TestExec(t *testing.T) {
+	ctor := newAgent(t)
+
+	tests := []struct {
+		name       string
+		cmd        []string
+		setup      func(cdsexec.Commander)
+		want       string
+		wantCode   int
+		wantStderr string
+	}{
+		{"args", []string{"printf", "%s|", "a b", "c"}, nil, "a b|c|", 0, ""},
+		{"stdin", []string{"tr", "a-z", "A-Z"}, func(c cdsexec.Commander) { c.SetStdin(strings.NewReader("hello")) }, "HELLO", 0, ""},
+		{"env and dir", []string{"sh", "-c", "echo $NODE; pwd"}, func(c cdsexec.Commander) {
+			c.SetEnv([]string{"NODE=n1"})
+			c.SetDir("/tmp")
+		}, "n1\n/tmp\n", 0, ""},
+		{"exit code", []string{"sh", "-c", "echo partial; echo 'no such pool' >&2; exit 3"}, nil, "partial\n", 3, "no such pool\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := ctor(context.Background(), tt.cmd[0], tt.cmd[1:]...)
+			if tt.setup != nil {
+				tt.setup(cmd)
+			}
+			out, err := cmd.Output()
+			if string(out) != tt.want {
+				t.Errorf("Expected output %q, got %q", tt.want, out)
+			}
+			if code := cdsexec.ExitCode(err); code != tt.wantCode {
+				t.Errorf("Expected exit code %d, got %d (%v)", tt.wantCode, code, err)
+			}
+			var xe *cdsexec.ExitError
+			if errors.As(err, &xe) && string(xe.Stderr) != tt.wantStderr {
+				t.Errorf("Expected stderr %q, got %q", tt.wantStderr, xe.Stderr)
+			}
+		})
+	}
+}
+
+func TestExecFailures(t *testing.T) {
+	ctor := newAgent(t)
+
+	if err := ctor(context.Background(), "cdsexec-missing-tool").Run(); !errors.Is(err, cdsexec.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	err := ctor(context.Background(), "sh", "-c", "kill -TERM $$").Run()
+	var xe *cdsexec.ExitError
+	if !errors.As(err, &xe) || xe.Signal != "TERM" || xe.Code != -1 {
+		t.Errorf("Expected termination by TERM, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := ctor(ctx, "sleep", "5").Run(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the remote command to be killed, took %s", elapsed)
+	}
+}
+
+type failingWriter struct{ err error }
+
+func (w failingWriter) Write([]byte) (int, error) { return 0, w.err }
+
+func TestClientErrors(t *testing.T) {
+	ctor := newAgent(t)
+	errFull := errors.New("no space left on device")
+
+	cmd := ctor(context.Background(), "echo", "report")
+	cmd.SetStdout(failingWriter{errFull})
+	if err := cmd.Run(); !errors.Is(err, errFull) {
+		t.Errorf("Expected the stdout write error, got %v", err)
+	}
+
+	cmd = ctor(context.Background(), "cat")
+	cmd.SetStdin(iotest.ErrReader(errFull))
+	if err := cmd.Run(); !errors.Is(err, errFull) {
+		t.Errorf("Expected the stdin read error, got %v", err)
+	}
+}
+
+// execStream is an agentpb.Agent_ExecServer whose client never closes stdin, and cancels
+// the call once it receives the exit.
+type execStream struct {
+	agentpb.Agent_ExecServer
+	ctx      context.Context
+	cancel   context.CancelFunc
+	received bool
+	// receiving counts the calls to Recv in progress.
+	receiving atomic.Int32
+}
+
+func (s *execStream) Context() context.Context { return s.ctx }
+
+func (s *execStream) Recv() (*agentpb.ExecRequest, error) {
+	if !s.received {
+		s.received = true
+		return &agentpb.ExecRequest{Payload: &agentpb.ExecRequest_Command{Command: &agentpb.Command{Name: "true", Stdin: true}}}, nil
+	}
+	s.receiving.Add(1)
+	defer s.receiving.Add(-1)
+	<-s.ctx.Done()
+	time.Sleep(20 * time.Millisecond)
+	return nil, s.ctx.Err()
+}
+
+func (s *execStream) Send(resp *agentpb.ExecResponse) error {
+	if resp.GetExit() != nil {
+		s.cancel()
+	}
+	return nil
+}
+
+func TestServerStdinEndsWithCall(t *testing.T) {
+	stream := &execStream{}
+	stream.ctx, stream.cancel = context.WithCancel(context.Background())
+	if err := grpccmd.NewServer(nil).Exec(stream); err != nil {
+		t.Fatal(err)
+	}
+	if n := stream.receiving.Load(); n != 0 {
+		t.Errorf("Expected no Recv in progress once Exec returns, got %d", n)
+	}
+}
+
+func TestSpecAndResultProto(t *testing.T) {
+	spec := cdsexec.CommandSpec{Name: "sfdisk", Args: []string{"/dev/sdb"}, Env: []string{}, Stdin: strings.NewReader("label: gpt\n")}
+	p, err := grpccmd.SpecToProto(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := proto.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded agentpb.Spec
+	if err := proto.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	got := grpccmd.SpecFromProto(&decoded)
+	in, _ := io.ReadAll(got.Stdin)
+	if got.Name != "sfdisk" || got.Args[0] != "/dev/sdb" || got.Env == nil || string(in) != "label: gpt\n" {
+		t.Errorf("Unexpected spec %+v", got)
+	}
+	if _, err := grpccmd.SpecToProto(cdsexec.CommandSpec{Name: "cat", Stdin: os.Stdin}); !errors.Is(err, cdsexec.ErrNotSerializable) {
+		t.Errorf("Expected ErrNotSerializable, got %v", err)
+	}
+
+	r := cdsexec.Result{Name: "zpool", Args: []string{"list"}, ExitCode: 1, Stdout: []byte("out"),
+		StartTime: time.Unix(1714564800, 5), Duration: time.Second, Phases: cdsexec.Phases{Spawn: time.Millisecond}}
+	if back := grpccmd.ResultFromProto(grpccmd.ResultToProto(r)); !reflect.DeepEqual(back, r) {
+		t.Errorf("Expected %+v, got %+v", r, back)
+	}
+}
@@ -0,0 +1,142 @@
+package grpccmd
+
+import (
+	"errors"
+	"io"
+	"os/exec"
+	"sync"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/grpccmd/agentpb"
+)
+
+// Server is an agentpb.AgentServer executing commands with a CommandConstructor.
+type Server struct {
+	agentpb.UnimplementedAgentServer
+
+	ctor cdsexec.CommandConstructor
+}
+
+// NewServer returns a Server executing commands built by ctor, or by cdsexec.CommandContext
+// if ctor is nil. Commands are bound to the context of their call.
+func NewServer(ctor cdsexec.CommandConstructor) *Server {
+	if ctor == nil {
+		ctor = cdsexec.CommandContext
+	}
+	return &Server{ctor: ctor}
+}
+
+// Exec implements agentpb.AgentServer.
+func (s *Server) Exec(stream agentpb.Agent_ExecServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	c := req.GetCommand()
+	if c == nil {
+		return errors.New("grpccmd: first request must carry the command")
+	}
+
+	cmd := s.ctor(stream.Context(), c.Name, c.Args...)
+	cmd.SetDir(c.Dir)
+	if c.SetEnv {
+		cmd.SetEnv(append([]string{}, c.Env...))
+	}
+	out := &streamWriter{stream: stream}
+	cmd.SetStdout(out.writer(func(p []byte) *agentpb.ExecResponse {
+		return &agentpb.ExecResponse{Payload: &agentpb.ExecResponse_Stdout{Stdout: p}}
+	}))
+	cmd.SetStderr(out.writer(func(p []byte) *agentpb.ExecResponse {
+		return &agentpb.ExecResponse{Payload: &agentpb.ExecResponse_Stderr{Stderr: p}}
+	}))
+	var stdin io.WriteCloser
+	if c.Stdin {
+		if stdin, err = cmd.StdinPipe(); err != nil {
+			return out.exit(startFailure(err))
+		}
+	}
+
+	if err := cmd.Start(); err != nil {
+		return out.exit(startFailure(err))
+	}
+	// The stream must not be received from once Exec returns, so Exec waits for forwardStdin.
+	// The client ends the call once it receives the exit, which ends forwardStdin if the
+	// command exited before reading all its stdin.
+	forwarded := make(chan struct{})
+	if stdin != nil {
+		go func() {
+			defer close(forwarded)
+			forwardStdin(stream, stdin)
+		}()
+	} else {
+		close(forwarded)
+	}
+	err = out.exit(exitOf(cmd, cmd.Wait()))
+	<-forwarded
+	return err
+}
+
+// forwardStdin copies the stdin chunks of the call to w until the client closes it or the
+// call ends.
+func forwardStdin(stream agentpb.Agent_ExecServer, w io.WriteCloser) {
+	defer w.Close()
+	for {
+		req, err := stream.Recv()
+		if err != nil || req.GetStdinEof() {
+			return
+		}
+		if _, err := w.Write(req.GetStdin()); err != nil {
+			return
+		}
+	}
+}
+
+func startFailure(err error) *agentpb.Exit {
+	return &agentpb.Exit{
+		Code:     -1,
+		Error:    err.Error(),
+		NotFound: errors.Is(err, exec.ErrNotFound) || errors.Is(err, cdsexec.ErrNotFound),
+	}
+}
+
+func exitOf(cmd cdsexec.Commander, err error) *agentpb.Exit {
+	exit := &agentpb.Exit{Code: int32(cdsexec.ExitCode(err))}
+	if state := cmd.ProcessState(); state != nil {
+		exit.Code = int32(state.ExitCode())
+		exit.Signal = signalOf(state)
+	}
+	var ee *exec.ExitError
+	if err != nil && !errors.As(err, &ee) {
+		exit.Error = err.Error()
+	}
+	return exit
+}
+
+// streamWriter serializes the responses sent by the output writers of one command.
+type streamWriter struct {
+	mu     sync.Mutex
+	stream agentpb.Agent_ExecServer
+}
+
+func (s *streamWriter) send(resp *agentpb.ExecResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stream.Send(resp)
+}
+
+func (s *streamWriter) writer(wrap func([]byte) *agentpb.ExecResponse) io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		if err := s.send(wrap(p)); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	})
+}
+
+func (s *streamWriter) exit(exit *agentpb.Exit) error {
+	return s.send(&agentpb.ExecResponse{Payload: &agentpb.ExecResponse_Exit{Exit: exit}})
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package grpccmd
+
+import "os"
+
+// signalOf returns "" since signals are not reported on this platform.
+func signalOf(*os.ProcessState) string {
+	return ""
+}
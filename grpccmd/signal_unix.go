@@ -0,0 +1,20 @@
+//go:build unix
+
+package grpccmd
+
+import (
+	"os"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// signalOf returns the name of the signal that terminated the process, e.g. "KILL".
+func signalOf(state *os.ProcessState) string {
+	ws, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !ws.Signaled() {
+		return ""
+	}
+	return strings.TrimPrefix(unix.SignalName(ws.Signal()), "SIG")
+}
@@ -0,0 +1,103 @@
+package grpccmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/grpccmd/agentpb"
+)
+
+// SpecToProto converts spec to its protobuf form, to be queued or stored, with the same
+// rules as CommandSpec.MarshalJSON: standard input is only encoded from a *bytes.Reader or
+// a *strings.Reader, any other reader fails with cdsexec.ErrNotSerializable, and standard
+// output and error are not encoded.
+func SpecToProto(spec cdsexec.CommandSpec) (*agentpb.Spec, error) {
+	p := &agentpb.Spec{
+		Name:   spec.Name,
+		Args:   spec.Args,
+		Dir:    spec.Dir,
+		Env:    spec.Env,
+		SetEnv: spec.Env != nil,
+	}
+	if spec.Stdin != nil {
+		r, ok := spec.Stdin.(interface {
+			io.ReaderAt
+			Size() int64
+			Len() int
+		})
+		if !ok {
+			return nil, fmt.Errorf("%w: %T", cdsexec.ErrNotSerializable, spec.Stdin)
+		}
+		p.Stdin = make([]byte, r.Len())
+		if _, err := r.ReadAt(p.Stdin, r.Size()-int64(r.Len())); err != nil && err != io.EOF {
+			return nil, err
+		}
+		p.SetStdin = true
+	}
+	return p, nil
+}
+
+// SpecFromProto converts p back to a CommandSpec. Standard input is restored as a
+// *bytes.Reader.
+func SpecFromProto(p *agentpb.Spec) cdsexec.CommandSpec {
+	spec := cdsexec.CommandSpec{Name: p.GetName(), Args: p.GetArgs(), Dir: p.GetDir()}
+	if p.GetSetEnv() {
+		spec.Env = append([]string{}, p.GetEnv()...)
+	}
+	if p.GetSetStdin() {
+		spec.Stdin = bytes.NewReader(p.GetStdin())
+	}
+	return spec
+}
+
+// ResultToProto converts r to its protobuf form.
+func ResultToProto(r cdsexec.Result) *agentpb.Result {
+	p := &agentpb.Result{
+		Name:          r.Name,
+		Args:          r.Args,
+		Dir:           r.Dir,
+		Env:           r.Env,
+		SetEnv:        r.Env != nil,
+		ExitCode:      int32(r.ExitCode),
+		Stdout:        r.Stdout,
+		Stderr:        r.Stderr,
+		DurationNanos: int64(r.Duration),
+		Phases: &agentpb.Phases{
+			ConstructNanos:   int64(r.Phases.Construct),
+			SpawnNanos:       int64(r.Phases.Spawn),
+			FirstOutputNanos: int64(r.Phases.FirstOutput),
+		},
+	}
+	if !r.StartTime.IsZero() {
+		p.StartTimeUnixNano = r.StartTime.UnixNano()
+	}
+	return p
+}
+
+// ResultFromProto converts p back to a Result.
+func ResultFromProto(p *agentpb.Result) cdsexec.Result {
+	r := cdsexec.Result{
+		Name:     p.GetName(),
+		Args:     p.GetArgs(),
+		Dir:      p.GetDir(),
+		ExitCode: int(p.GetExitCode()),
+		Stdout:   p.GetStdout(),
+		Stderr:   p.GetStderr(),
+		Duration: time.Duration(p.GetDurationNanos()),
+		Phases: cdsexec.Phases{
+			Construct:   time.Duration(p.GetPhases().GetConstructNanos()),
+			Spawn:       time.Duration(p.GetPhases().GetSpawnNanos()),
+			FirstOutput: time.Duration(p.GetPhases().GetFirstOutputNanos()),
+		},
+	}
+	if p.GetSetEnv() {
+		r.Env = append([]string{}, p.GetEnv()...)
+	}
+	if p.GetStartTimeUnixNano() != 0 {
+		r.StartTime = time.Unix(0, p.GetStartTimeUnixNano())
+	}
+	return r
+}
@@ -0,0 +1,264 @@
+package healthcheck
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// State is the health of a check, or of all the checks of a Runner.
+type State int
+
+const (
+	// Unknown is the state of checks that have not run yet.
+	Unknown State = iota
+	Healthy
+	// Degraded is the aggregate state when only non-critical checks are unhealthy.
+	Degraded
+	Unhealthy
+)
+
+func (s State) String() string {
+	switch s {
+	case Healthy:
+		return "healthy"
+	case Degraded:
+		return "degraded"
+	case Unhealthy:
+		return "unhealthy"
+	}
+	return "unknown"
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s State) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
+
+// Check is a command whose outcome tells whether a component is healthy, as an exec probe
+// of Kubernetes does.
+type Check struct {
+	Name string
+	Spec cdsexec.CommandSpec
+	// Interval is the time between the end of a run and the start of the next. Zero means
+	// DefaultInterval.
+	Interval time.Duration
+	// Timeout bounds each run, measured on the Clock of the context of the Runner. Zero
+	// means no bound.
+	Timeout time.Duration
+	// ExitCodes are the exit codes of a successful run. Nil means 0.
+	ExitCodes []int
+	// Match, if set, must match the standard output of a successful run, and NotMatch
+	// must not.
+	Match, NotMatch *regexp.Regexp
+	// JSON are assertions on the standard output of a successful run, decoded as JSON.
+	JSON []Assertion
+	// FailureThreshold is the number of consecutive failed runs making a check unhealthy,
+	// and SuccessThreshold the number of consecutive successful runs making an unhealthy
+	// check healthy again; the first successful run makes a check that has not been
+	// unhealthy yet healthy. Zero means 1.
+	FailureThreshold, SuccessThreshold int
+	// Critical makes the aggregate state Unhealthy when the check is; the failure of other
+	// checks only makes it Degraded.
+	Critical bool
+}
+
+// DefaultInterval is the interval of checks that do not set one.
+const DefaultInterval = 30 * time.Second
+
+// Status is the state of a check.
+type Status struct {
+	Name  string `json:"name"`
+	State State  `json:"state"`
+	// Error tells why the last run failed, empty if it succeeded.
+	Error    string        `json:"error,omitempty"`
+	ExitCode int           `json:"exit_code"`
+	LastRun  time.Time     `json:"last_run"`
+	Duration time.Duration `json:"duration"`
+	// Failures and Successes are the numbers of consecutive failed and successful runs.
+	Failures  int  `json:"failures"`
+	Successes int  `json:"successes"`
+	Critical  bool `json:"critical"`
+}
+
+// Report is the aggregate state of the checks of a Runner.
+type Report struct {
+	State  State    `json:"state"`
+	Checks []Status `json:"checks"`
+}
+
+// Runner runs checks on their intervals and keeps their state.
+type Runner struct {
+	// Constructor constructs the commands. Nil means cdsexec.CommandContext.
+	Constructor cdsexec.CommandConstructor
+	Checks      []Check
+
+	mu       sync.Mutex
+	statuses map[string]*Status
+}
+
+// Run runs every check once at once and then on its interval until ctx is done, and
+// returns the error of ctx. Intervals are measured on the Clock of ctx.
+func (r *Runner) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for i := range r.Checks {
+		c := r.Checks[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			interval := c.Interval
+			if interval <= 0 {
+				interval = DefaultInterval
+			}
+			for {
+				r.runCheck(ctx, c)
+				if cdsexec.Sleep(ctx, interval) != nil {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// RunOnce runs every check once, concurrently, and returns the resulting report.
+func (r *Runner) RunOnce(ctx context.Context) Report {
+	var wg sync.WaitGroup
+	for _, c := range r.Checks {
+		wg.Add(1)
+		go func(c Check) {
+			defer wg.Done()
+			r.runCheck(ctx, c)
+		}(c)
+	}
+	wg.Wait()
+	return r.Report()
+}
+
+// Report returns the state of every check, in the order of Checks, and their aggregate
+// state: Unhealthy if a critical check is unhealthy, Degraded if another check is, Unknown
+// if a check has not run yet, and Healthy otherwise.
+func (r *Runner) Report() Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rep := Report{State: Healthy, Checks: make([]Status, 0, len(r.Checks))}
+	for _, c := range r.Checks {
+		st := Status{Name: c.Name, Critical: c.Critical}
+		if p := r.statuses[c.Name]; p != nil {
+			st = *p
+		}
+		rep.Checks = append(rep.Checks, st)
+		switch {
+		case st.State == Unhealthy && c.Critical:
+			rep.State = Unhealthy
+		case st.State == Unhealthy && rep.State != Unhealthy:
+			rep.State = Degraded
+		case st.State == Unknown && rep.State == Healthy:
+			rep.State = Unknown
+		}
+	}
+	return rep
+}
+
+// ServeHTTP serves the report as JSON, with status 200 if the aggregate state is Healthy
+// or Degraded and 503 otherwise, for liveness and readiness endpoints.
+func (r *Runner) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	rep := r.Report()
+	w.Header().Set("Content-Type", "application/json")
+	if rep.State != Healthy && rep.State != Degraded {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(rep)
+}
+
+// runCheck runs c once and updates its status.
+func (r *Runner) runCheck(ctx context.Context, c Check) {
+	ctor := r.Constructor
+	if ctor == nil {
+		ctor = cdsexec.CommandContext
+	}
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = cdsexec.ContextWithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	spec := c.Spec
+	spec.Stdout = nil
+	results, err := cdsexec.OutputAll(ctx, ctor, []cdsexec.CommandSpec{spec}, 1)
+	res := results[0]
+	err = evaluate(c, res, err)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.statuses == nil {
+		r.statuses = make(map[string]*Status)
+	}
+	st := r.statuses[c.Name]
+	if st == nil {
+		st = &Status{Name: c.Name, Critical: c.Critical}
+		r.statuses[c.Name] = st
+	}
+	st.ExitCode, st.LastRun, st.Duration, st.Error = res.ExitCode, res.StartTime, res.Duration, ""
+	if err != nil {
+		st.Error = err.Error()
+		st.Failures++
+		st.Successes = 0
+		if st.State != Unhealthy && st.Failures >= max(c.FailureThreshold, 1) {
+			st.State = Unhealthy
+		}
+		return
+	}
+	st.Successes++
+	st.Failures = 0
+	if st.State == Unknown || st.Successes >= max(c.SuccessThreshold, 1) {
+		st.State = Healthy
+	}
+}
+
+// evaluate returns why the run of c with result res and error err failed, or nil if it
+// succeeded.
+func evaluate(c Check, res cdsexec.Result, err error) error {
+	var ge *cdsexec.GroupError
+	if errors.As(err, &ge) {
+		err = ge.Errs[0]
+	}
+	codes := c.ExitCodes
+	if codes == nil {
+		codes = []int{0}
+	}
+	if err != nil && res.ExitCode <= 0 {
+		// The command did not run to completion: not found, timed out or killed.
+		return err
+	}
+	if !slices.Contains(codes, res.ExitCode) {
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("exit code %d", res.ExitCode)
+	}
+	if c.Match != nil && !c.Match.Match(res.Stdout) {
+		return fmt.Errorf("output does not match %q", c.Match)
+	}
+	if c.NotMatch != nil && c.NotMatch.Match(res.Stdout) {
+		return fmt.Errorf("output matches %q", c.NotMatch)
+	}
+	if len(c.JSON) > 0 {
+		var v any
+		if err := json.Unmarshal(res.Stdout, &v); err != nil {
+			return fmt.Errorf("decoding output: %w", err)
+		}
+		for _, a := range c.JSON {
+			if err := a(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package healthcheck_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/healthcheck"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+// outputs returns a constructor writing the output of each binary and exiting with its code.
+func outputs(out map[string]string, codes map[string]int) cdsexec.CommandConstructor {
+	return cdsexec.StreamConstructor(func(ctx context.Context, spec cdsexec.CommandSpec) error {
+		io.WriteString(spec.Stdout, out[spec.Name])
+		if code := codes[spec.Name]; code != 0 {
+			return &cdsexec.ExitError{Code: code}
+		}
+		return nil
+	})
+}
+
+func TestCriteria(t *testing.T) {
+	out := map[string]string{
+		"multipathd": "mpatha (3600a0) dm-0\n",
+		"zpool":      `{"pools": [{"name": "tank", "health": "ONLINE", "vdevs": 2}, {"name": "scratch", "health": "DEGRADED", "vdevs": 1}]}`,
+		"iscsiadm":   "iscsiadm: No active sessions.\n",
+		"smartctl":   "SMART overall-health self-assessment test result: PASSED\n",
+	}
+	codes := map[string]int{"iscsiadm": 21, "smartctl": 4}
+	r := &healthcheck.Runner{
+		Constructor: outputs(out, codes),
+		Checks: []healthcheck.Check{
+			{Name: "multipath", Spec: cdsexec.CommandSpec{Name: "multipathd"}, Match: regexp.MustCompile(`dm-\d+`), Critical: true},
+			{Name: "tank", Spec: cdsexec.CommandSpec{Name: "zpool"}, JSON: []healthcheck.Assertion{
+				healthcheck.JSONEquals("pools.0.health", "ONLINE"),
+				healthcheck.JSONEquals("pools.0.vdevs", 2),
+			}},
+			{Name: "pools", Spec: cdsexec.CommandSpec{Name: "zpool"}, JSON: []healthcheck.Assertion{
+				healthcheck.JSONEach("pools", healthcheck.JSONEquals("health", "ONLINE")),
+			}},
+			{Name: "iscsi", Spec: cdsexec.CommandSpec{Name: "iscsiadm"}, ExitCodes: []int{0, 21}, NotMatch: regexp.MustCompile(`No active`)},
+			// smartctl sets bit 2 of its exit code for some SMART errors it survives.
+			{Name: "smart", Spec: cdsexec.CommandSpec{Name: "smartctl"}, ExitCodes: []int{0, 4}, Match: regexp.MustCompile(`PASSED`)},
+		},
+	}
+	rep := r.RunOnce(context.Background())
+	want := map[string]string{
+		"multipath": "",
+		"tank":      "",
+		"pools":     `pools.1: health is "DEGRADED", want "ONLINE"`,
+		"iscsi":     `output matches "No active"`,
+		"smart":     "",
+	}
+	for _, st := range rep.Checks {
+		if st.Error != want[st.Name] {
+			t.Errorf("Expected %s to fail with %q, got %q", st.Name, want[st.Name], st.Error)
+		}
+		if healthy := want[st.Name] == ""; healthy != (st.State == healthcheck.Healthy) {
+			t.Errorf("Unexpected state %s of %s", st.State, st.Name)
+		}
+	}
+	if rep.State != healthcheck.Degraded {
+		t.Errorf("Expected non-critical failures to degrade, got %s", rep.State)
+	}
+}
+
+func TestThresholds(t *testing.T) {
+	fail := true
+	base := mockcmd.MakeMockCmdWithOutput("", func(m *mockcmd.MockCmd) error {
+		if fail {
+			return &cdsexec.ExitError{Code: 1}
+		}
+		return nil
+	})
+	clock := mockcmd.NewFakeClock(time.Unix(0, 0))
+	ctx, cancel := context.WithCancel(cdsexec.ContextWithClock(context.Background(), clock))
+	r := &healthcheck.Runner{
+		Constructor: base,
+		Checks: []healthcheck.Check{{
+			Name:             "target",
+			Spec:             cdsexec.CommandSpec{Name: "targetcli"},
+			Interval:         10 * time.Second,
+			FailureThreshold: 2,
+			SuccessThreshold: 2,
+			Critical:         true,
+		}},
+	}
+	done := make(chan error)
+	go func() { done <- r.Run(ctx) }()
+	state := func() healthcheck.State {
+		clock.BlockUntil(1)
+		return r.Report().Checks[0].State
+	}
+	advance := func() {
+		clock.BlockUntil(1)
+		clock.Advance(10 * time.Second)
+	}
+
+	// A first failure is not enough to make the check unhealthy, nor known.
+	if s := state(); s != healthcheck.Unknown {
+		t.Errorf("Expected unknown after one failure, got %s", s)
+	}
+	advance()
+	if s := state(); s != healthcheck.Unhealthy {
+		t.Errorf("Expected unhealthy after two failures, got %s", s)
+	}
+	if rep := r.Report(); rep.State != healthcheck.Unhealthy || rep.Checks[0].Failures != 2 {
+		t.Errorf("Unexpected report %+v", rep)
+	}
+	fail = false
+	advance()
+	if s := state(); s != healthcheck.Unhealthy {
+		t.Errorf("Expected unhealthy after one success, got %s", s)
+	}
+	advance()
+	if s := state(); s != healthcheck.Healthy {
+		t.Errorf("Expected healthy after two successes, got %s", s)
+	}
+	cancel()
+	<-done
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := &healthcheck.Runner{
+		Constructor: mockcmd.MakeMockCmdWithOutputSpecificError("", &cdsexec.ExitError{Code: 3}, nil),
+		Checks:      []healthcheck.Check{{Name: "lvm", Spec: cdsexec.CommandSpec{Name: "vgs"}, Critical: true}},
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), `"state":"unknown"`) {
+		t.Errorf("Expected 503 before the checks ran, got %d %s", rec.Code, rec.Body)
+	}
+
+	r.RunOnce(context.Background())
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	var rep struct {
+		State  string
+		Checks []struct{ Name, State, Error string }
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &rep); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusServiceUnavailable || rep.State != "unhealthy" || rep.Checks[0].Error == "" {
+		t.Errorf("Unexpected response %d %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,99 @@
+package healthcheck
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Assertion checks the output of a check decoded as JSON, with objects as map[string]any,
+// arrays as []any and numbers as float64.
+type Assertion func(v any) error
+
+// JSONEquals asserts that the value at path equals want, compared as JSON: want is
+// encoded and decoded again, so that e.g. the int 3 equals the number 3. A path is a list
+// of object keys and array indices separated by dots, such as "pools.0.health".
+func JSONEquals(path string, want any) Assertion {
+	return func(v any) error {
+		got, err := lookup(v, path)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(want)
+		if err != nil {
+			return err
+		}
+		var w any
+		json.Unmarshal(data, &w)
+		if !reflect.DeepEqual(got, w) {
+			return fmt.Errorf("%s is %s, want %s", path, compact(got), data)
+		}
+		return nil
+	}
+}
+
+// JSONExists asserts that there is a value at path, as described by JSONEquals.
+func JSONExists(path string) Assertion {
+	return func(v any) error {
+		_, err := lookup(v, path)
+		return err
+	}
+}
+
+// JSONEach applies a to every element of the array at path, as described by JSONEquals,
+// with paths relative to the element, e.g. JSONEach("pools", JSONEquals("health", "ONLINE")).
+func JSONEach(path string, a Assertion) Assertion {
+	return func(v any) error {
+		got, err := lookup(v, path)
+		if err != nil {
+			return err
+		}
+		elems, ok := got.([]any)
+		if !ok {
+			return fmt.Errorf("%s is %s, not an array", path, compact(got))
+		}
+		for i, e := range elems {
+			if err := a(e); err != nil {
+				return fmt.Errorf("%s.%d: %w", path, i, err)
+			}
+		}
+		return nil
+	}
+}
+
+func lookup(v any, path string) (any, error) {
+	if path == "" {
+		return v, nil
+	}
+	for i, key := range strings.Split(path, ".") {
+		switch x := v.(type) {
+		case map[string]any:
+			e, ok := x[key]
+			if !ok {
+				return nil, fmt.Errorf("%s not found", prefix(path, i))
+			}
+			v = e
+		case []any:
+			n, err := strconv.Atoi(key)
+			if err != nil || n < 0 || n >= len(x) {
+				return nil, fmt.Errorf("%s not found", prefix(path, i))
+			}
+			v = x[n]
+		default:
+			return nil, fmt.Errorf("%s not found", prefix(path, i))
+		}
+	}
+	return v, nil
+}
+
+// prefix returns the first n+1 elements of path.
+func prefix(path string, n int) string {
+	return strings.Join(strings.Split(path, ".")[:n+1], ".")
+}
+
+func compact(v any) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
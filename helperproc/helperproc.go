@@ -0,0 +1,88 @@
+package helperproc
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// EnvVar tells a re-executed test binary which behavior to run.
+const EnvVar = "CDSEXEC_HELPER_PROCESS"
+
+// Behavior is the body of a fake process. It runs in a re-executed test binary, with the
+// arguments of the command and real standard streams, and returns the exit code.
+type Behavior func(args []string) int
+
+var (
+	mu        sync.RWMutex
+	behaviors = map[string]Behavior{}
+)
+
+// Register registers fn as the behavior of the commands named name, typically from the
+// TestMain of a package, before calling Main.
+func Register(name string, fn Behavior) {
+	mu.Lock()
+	defer mu.Unlock()
+	behaviors[name] = fn
+}
+
+func lookup(name string) (Behavior, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	fn, ok := behaviors[name]
+	if !ok {
+		fn, ok = behaviors[filepath.Base(name)]
+	}
+	return fn, ok
+}
+
+// Main runs the behavior selected by EnvVar and exits if the test binary was re-executed
+// as a helper process, and returns otherwise. Call it from TestMain after registering
+// the behaviors:
+//
+//	func TestMain(m *testing.M) {
+//		helperproc.Register("zpool", fakeZpool)
+//		helperproc.Main()
+//		os.Exit(m.Run())
+//	}
+func Main() {
+	name, ok := os.LookupEnv(EnvVar)
+	if !ok {
+		return
+	}
+	fn, ok := lookup(name)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "helperproc: no behavior registered for %q\n", name)
+		os.Exit(127)
+	}
+	os.Exit(fn(os.Args[1:]))
+}
+
+// Constructor returns a CommandConstructor running the behavior registered for the name,
+// or base name, of each command in a new process re-executing the test binary. The
+// processes have real pipes, PIDs and signals. Commands without behavior fail to start
+// with an *exec.Error wrapping exec.ErrNotFound.
+func Constructor() cdsexec.CommandConstructor {
+	exe, exeErr := os.Executable()
+	return cdsexec.Wrap(cdsexec.CommandContext, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		if _, ok := lookup(inv.Spec.Name); !ok {
+			return &exec.Error{Name: inv.Spec.Name, Err: exec.ErrNotFound}
+		}
+		if exeErr != nil {
+			return exeErr
+		}
+		inv.Spec.Env = cdsexec.MergeEnv(inv.Spec.Env, EnvVar+"="+inv.Spec.Name)
+		inv.Spec.Name = exe
+		return next(inv)
+	})
+}
+
+// Command returns a command running the behavior registered for name with ctx.
+func Command(ctx context.Context, name string, arg ...string) cdsexec.Commander {
+	return Constructor()(ctx, name, arg...)
+}
@@ -0,0 +1,99 @@
+package helperproc_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/helperproc"
+)
+
+func TestMain(m *testing.M) {
+	helperproc.Register("zpool", func(args []string) int {
+		if len(args) > 0 && args[0] == "list" {
+			fmt.Println("tank\tONLINE")
+			return 0
+		}
+		fmt.Fprintln(os.Stderr, "unrecognized command", strings.Join(args, " "))
+		return 2
+	})
+	helperproc.Register("upper", func(args []string) int {
+		sc := bufio.NewScanner(os.Stdin)
+		for sc.Scan() {
+			fmt.Println(strings.ToUpper(sc.Text()))
+		}
+		return 0
+	})
+	helperproc.Register("hang", func(args []string) int {
+		fmt.Println("ready")
+		fmt.Println(os.Getenv("POOL"))
+		time.Sleep(time.Hour)
+		return 0
+	})
+	helperproc.Main()
+	os.Exit(m.Run())
+}
+
+func TestHelperProcess(t *testing.T) {
+	ctor := helperproc.Constructor()
+	out, err := ctor(context.Background(), "/sbin/zpool", "list").Output()
+	if err != nil || string(out) != "tank\tONLINE\n" {
+		t.Errorf("Expected the fake zpool output, got %q, %v", out, err)
+	}
+
+	_, err = ctor(context.Background(), "zpool", "destroy").Output()
+	var ee *exec.ExitError
+	if !errors.As(err, &ee) || ee.ExitCode() != 2 || !strings.Contains(string(ee.Stderr), "unrecognized command destroy") {
+		t.Errorf("Expected exit code 2 with stderr, got %v", err)
+	}
+
+	err = ctor(context.Background(), "zfs", "list").Run()
+	if !errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("Expected exec.ErrNotFound, got %v", err)
+	}
+}
+
+func TestHelperProcessPipes(t *testing.T) {
+	cmd := helperproc.Command(context.Background(), "upper")
+	cmd.SetStdin(strings.NewReader("a\nb\n"))
+	out, err := cmd.Output()
+	if err != nil || string(out) != "A\nB\n" {
+		t.Errorf("Expected the input upper-cased, got %q, %v", out, err)
+	}
+}
+
+func TestHelperProcessSignal(t *testing.T) {
+	cmd := helperproc.Command(context.Background(), "hang")
+	cmd.SetEnv(append(os.Environ(), "POOL=tank"))
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(stdout)
+	for _, want := range []string{"ready\n", "tank\n"} {
+		if line, _ := r.ReadString('\n'); line != want {
+			t.Fatalf("Expected %q, got %q", want, line)
+		}
+	}
+	if cmd.Process() == nil || cmd.Process().Pid == os.Getpid() {
+		t.Fatal("Expected a separate process")
+	}
+	cmd.Process().Signal(syscall.SIGTERM)
+	io.Copy(io.Discard, r)
+	err = cmd.Wait()
+	if cdsexec.ExitCode(err) != -1 || !strings.Contains(err.Error(), "terminated") {
+		t.Errorf("Expected the process to be terminated by SIGTERM, got %v", err)
+	}
+}
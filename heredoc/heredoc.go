@@ -0,0 +1,117 @@
+package heredoc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// ErrInvalid matches the errors of payloads that cannot be written safely, such as values
+// containing line breaks.
+var ErrInvalid = errors.New("heredoc: invalid payload")
+
+// Marshaler is implemented by the payloads of this package.
+type Marshaler interface {
+	MarshalStdin() ([]byte, error)
+}
+
+// Reader returns a reader of the payload of m. The payload is rendered completely first, so
+// an invalid payload is reported before any command reads part of it.
+func Reader(m Marshaler) (io.Reader, error) {
+	b, err := m.MarshalStdin()
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(b), nil
+}
+
+// SetStdin sets the payload of m as the standard input of cmd.
+func SetStdin(cmd cdsexec.Commander, m Marshaler) error {
+	r, err := Reader(m)
+	if err != nil {
+		return err
+	}
+	cmd.SetStdin(r)
+	return nil
+}
+
+// Doc is a line-oriented payload: plain lines such as the keystrokes answering fdisk,
+// key=value lines and [section] headers. Every value is checked for line breaks, which
+// would silently add lines to the payload; the first error is kept and reported by
+// MarshalStdin.
+type Doc struct {
+	// Sep separates keys and values in KV lines. Empty means "=".
+	Sep string
+
+	b   bytes.Buffer
+	err error
+}
+
+// Line appends fields joined by spaces as one line.
+func (d *Doc) Line(fields ...string) *Doc {
+	for _, f := range fields {
+		d.check("line", f)
+	}
+	return d.write(strings.Join(fields, " "))
+}
+
+// KV appends a key-value line. Keys must not contain the separator.
+func (d *Doc) KV(key, value string) *Doc {
+	sep := d.Sep
+	if sep == "" {
+		sep = "="
+	}
+	d.check("key", key)
+	d.check("value", value)
+	if key == "" || strings.Contains(key, strings.TrimSpace(sep)) {
+		d.fail(fmt.Errorf("%w: invalid key %q", ErrInvalid, key))
+	}
+	return d.write(key + sep + value)
+}
+
+// Section appends a [name] header, preceded by an empty line unless it starts the payload.
+func (d *Doc) Section(name string) *Doc {
+	d.check("section", name)
+	if strings.ContainsAny(name, "[]") {
+		d.fail(fmt.Errorf("%w: invalid section %q", ErrInvalid, name))
+	}
+	if d.b.Len() > 0 {
+		d.write("")
+	}
+	return d.write("[" + name + "]")
+}
+
+// Blank appends an empty line.
+func (d *Doc) Blank() *Doc {
+	return d.write("")
+}
+
+// MarshalStdin returns the payload, or the first error made while building it.
+func (d *Doc) MarshalStdin() ([]byte, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	return bytes.Clone(d.b.Bytes()), nil
+}
+
+func (d *Doc) write(line string) *Doc {
+	d.b.WriteString(line)
+	d.b.WriteByte('\n')
+	return d
+}
+
+func (d *Doc) check(what, s string) {
+	if strings.ContainsAny(s, "\r\n") {
+		d.fail(fmt.Errorf("%w: %s %q contains a line break", ErrInvalid, what, s))
+	}
+}
+
+func (d *Doc) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+}
@@ -0,0 +1,107 @@
+package heredoc_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/heredoc"
+)
+
+func TestDoc(t *testing.T) {
+	d := (&heredoc.Doc{Sep: " = "}).
+		Section("cluster").
+		KV("recovery lock", "/clusterfs/.reclock").
+		Section("logging").
+		KV("log level", "NOTICE")
+	b, err := d.MarshalStdin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "[cluster]\nrecovery lock = /clusterfs/.reclock\n\n[logging]\nlog level = NOTICE\n"
+	if string(b) != expected {
+		t.Errorf("Expected %q, got %q", expected, b)
+	}
+
+	keys := new(heredoc.Doc).Line("n").Line("p").Line("1").Blank().Line("+1G").Line("w")
+	b, _ = keys.MarshalStdin()
+	if string(b) != "n\np\n1\n\n+1G\nw\n" {
+		t.Errorf("Unexpected keystrokes %q", b)
+	}
+}
+
+func TestDocInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  *heredoc.Doc
+	}{
+		{"line break in value", new(heredoc.Doc).KV("size", "1G\nw")},
+		{"line break in line", new(heredoc.Doc).Line("n", "p\n")},
+		{"separator in key", new(heredoc.Doc).KV("a=b", "c")},
+		{"empty key", new(heredoc.Doc).KV("", "c")},
+		{"bracket in section", new(heredoc.Doc).Section("a]b")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.doc.Line("w").MarshalStdin(); !errors.Is(err, heredoc.ErrInvalid) {
+				t.Errorf("Expected ErrInvalid, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSfdisk(t *testing.T) {
+	table := heredoc.Sfdisk{
+		Label: "gpt",
+		Partitions: []heredoc.Partition{
+			{Size: "512MiB", Type: "U", Name: "EFI System"},
+			{Start: "1050624", Size: "+", Type: "L", Bootable: true},
+			{},
+		},
+	}
+	b, err := table.MarshalStdin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "label: gpt\n\nsize=512MiB, type=U, name=\"EFI System\"\nstart=1050624, size=+, type=L, bootable\n,\n"
+	if string(b) != expected {
+		t.Errorf("Expected %q, got %q", expected, b)
+	}
+
+	invalid := []heredoc.Sfdisk{
+		{Label: "gpt\nwrite"},
+		{Partitions: []heredoc.Partition{{Size: "10G, type=EF"}}},
+		{Partitions: []heredoc.Partition{{Name: `a" bootable`}}},
+		{Partitions: []heredoc.Partition{{Type: "L\nsize=1"}}},
+	}
+	for _, s := range invalid {
+		if _, err := s.MarshalStdin(); !errors.Is(err, heredoc.ErrInvalid) {
+			t.Errorf("Expected ErrInvalid for %+v, got %v", s, err)
+		}
+	}
+}
+
+func TestSetStdin(t *testing.T) {
+	cmd := cdsexec.CommandContext(context.Background(), "cat")
+	if err := heredoc.SetStdin(cmd, new(heredoc.Doc).KV("a", "1")); err != nil {
+		t.Fatal(err)
+	}
+	out, err := cmd.Output()
+	if err != nil || string(out) != "a=1\n" {
+		t.Errorf("Expected %q, got %q, %v", "a=1\n", out, err)
+	}
+
+	cmd = cdsexec.CommandContext(context.Background(), "cat")
+	if err := heredoc.SetStdin(cmd, new(heredoc.Doc).KV("a", "1\n2")); !errors.Is(err, heredoc.ErrInvalid) {
+		t.Errorf("Expected ErrInvalid, got %v", err)
+	}
+
+	r, _ := heredoc.Reader(new(heredoc.Doc).Line("p"))
+	b, _ := io.ReadAll(r)
+	if !strings.HasSuffix(string(b), "\n") {
+		t.Errorf("Expected a trailing newline, got %q", b)
+	}
+}
@@ -0,0 +1,105 @@
+package heredoc
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Sfdisk is a partition table in the script format read by sfdisk(8).
+type Sfdisk struct {
+	// Label is the type of the partition table, "gpt" or "dos".
+	Label   string
+	LabelID string
+	// Unit is the unit of Start and Size without suffix; sfdisk only supports "sectors".
+	Unit       string
+	Partitions []Partition
+}
+
+// Partition is a partition of an Sfdisk table. Empty fields take the defaults of sfdisk.
+type Partition struct {
+	// Start and Size are a number of sectors or a size with a K, M, G, T or P suffix such
+	// as "10GiB". Size may also be "+" to use all the remaining space.
+	Start string
+	Size  string
+	// Type is the partition type, a GPT type GUID or alias such as "L" or an MBR type.
+	Type     string
+	Name     string
+	UUID     string
+	Attrs    string
+	Bootable bool
+}
+
+var (
+	sizePattern  = regexp.MustCompile(`^(\+|[0-9]+([KMGTP](iB)?)?)$`)
+	tokenPattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]+$`)
+)
+
+// MarshalStdin returns the sfdisk script of the table.
+func (s Sfdisk) MarshalStdin() ([]byte, error) {
+	d := &Doc{Sep: ": "}
+	for _, h := range [][2]string{{"label", s.Label}, {"label-id", s.LabelID}, {"unit", s.Unit}} {
+		if h[1] == "" {
+			continue
+		}
+		if !tokenPattern.MatchString(h[1]) {
+			return nil, fmt.Errorf("%w: invalid %s %q", ErrInvalid, h[0], h[1])
+		}
+		d.KV(h[0], h[1])
+	}
+	d.Blank()
+	for i, p := range s.Partitions {
+		fields, err := p.fields()
+		if err != nil {
+			return nil, fmt.Errorf("%w: partition %d: %v", ErrInvalid, i+1, err)
+		}
+		d.Line(strings.Join(fields, ", "))
+	}
+	return d.MarshalStdin()
+}
+
+func (p Partition) fields() ([]string, error) {
+	var fields []string
+	add := func(key, value string, valid *regexp.Regexp) error {
+		if value == "" {
+			return nil
+		}
+		if !valid.MatchString(value) {
+			return fmt.Errorf("invalid %s %q", key, value)
+		}
+		fields = append(fields, key+"="+value)
+		return nil
+	}
+	if err := add("start", p.Start, sizePattern); err != nil {
+		return nil, err
+	}
+	if err := add("size", p.Size, sizePattern); err != nil {
+		return nil, err
+	}
+	if err := add("type", p.Type, tokenPattern); err != nil {
+		return nil, err
+	}
+	if err := add("uuid", p.UUID, tokenPattern); err != nil {
+		return nil, err
+	}
+	if p.Name != "" {
+		if strings.ContainsAny(p.Name, "\"\\\r\n") {
+			return nil, fmt.Errorf("invalid name %q", p.Name)
+		}
+		fields = append(fields, `name="`+p.Name+`"`)
+	}
+	if p.Attrs != "" {
+		if strings.ContainsAny(p.Attrs, "\"\\\r\n") {
+			return nil, fmt.Errorf("invalid attrs %q", p.Attrs)
+		}
+		fields = append(fields, `attrs="`+p.Attrs+`"`)
+	}
+	if p.Bootable {
+		fields = append(fields, "bootable")
+	}
+	if len(fields) == 0 {
+		// An empty line would end the script; a lone comma takes every default.
+		fields = append(fields, ",")
+	}
+	return fields, nil
+}
@@ -0,0 +1,205 @@
+package history
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// Entry records one execution.
+type Entry struct {
+	// Name and Args are the command, redacted by the Redactor of its context.
+	Name      string        `json:"name"`
+	Args      []string      `json:"args"`
+	Dir       string        `json:"dir,omitempty"`
+	StartTime time.Time     `json:"start_time"`
+	Duration  time.Duration `json:"duration"`
+	ExitCode  int           `json:"exit_code"`
+	// Error is the redacted message of Err.
+	Error string `json:"error,omitempty"`
+	Err   error  `json:"-"`
+}
+
+// Recorder keeps the last executions of the commands it wraps.
+type Recorder struct {
+	mu      sync.Mutex
+	entries []Entry
+	next    int
+	full    bool
+}
+
+// NewRecorder returns a Recorder keeping the last size executions.
+func NewRecorder(size int) *Recorder {
+	if size <= 0 {
+		panic("history: non-positive Recorder size")
+	}
+	return &Recorder{entries: make([]Entry, size)}
+}
+
+// Wrap returns a CommandConstructor recording every execution of the commands built by base.
+func (r *Recorder) Wrap(base cdsexec.CommandConstructor) cdsexec.CommandConstructor {
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		err := next(inv)
+		res := inv.Result(err)
+		redactor := cdsexec.RedactorFromContext(inv.Ctx)
+		e := Entry{
+			Name:      inv.Spec.Name,
+			Args:      redactor.Args(inv.Spec.Args),
+			Dir:       inv.Spec.Dir,
+			StartTime: res.StartTime,
+			Duration:  res.Duration,
+			ExitCode:  res.ExitCode,
+			Err:       err,
+		}
+		if e.StartTime.IsZero() {
+			e.StartTime = cdsexec.ClockFromContext(inv.Ctx).Now()
+		}
+		if err != nil {
+			e.Error = redactor.Error(err).Error()
+		}
+		r.add(e)
+		return err
+	})
+}
+
+func (r *Recorder) add(e Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[r.next] = e
+	r.next++
+	if r.next == len(r.entries) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// Query selects entries. Zero fields do not filter.
+type Query struct {
+	// Name matches the command name or its base name, e.g. "zpool" for "/sbin/zpool".
+	Name string
+	// Since and Until bound the start time of the executions.
+	Since, Until time.Time
+	// Failed selects failed executions only.
+	Failed bool
+	// ExitCode selects executions with that exit code.
+	ExitCode *int
+	// Limit keeps the most recent Limit entries.
+	Limit int
+}
+
+func (q *Query) match(e *Entry) bool {
+	switch {
+	case q.Name != "" && q.Name != e.Name && q.Name != path.Base(e.Name):
+		return false
+	case !q.Since.IsZero() && e.StartTime.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && e.StartTime.After(q.Until):
+		return false
+	case q.Failed && e.Err == nil && e.Error == "":
+		return false
+	case q.ExitCode != nil && *q.ExitCode != e.ExitCode:
+		return false
+	}
+	return true
+}
+
+// Query returns the entries selected by q, oldest first.
+func (r *Recorder) Query(q Query) []Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []Entry
+	for _, e := range r.all() {
+		if q.match(&e) {
+			out = append(out, e)
+		}
+	}
+	if q.Limit > 0 && len(out) > q.Limit {
+		out = out[len(out)-q.Limit:]
+	}
+	return out
+}
+
+// Entries returns every entry kept, oldest first.
+func (r *Recorder) Entries() []Entry {
+	return r.Query(Query{})
+}
+
+// all returns the entries in order. r.mu is held.
+func (r *Recorder) all() []Entry {
+	if !r.full {
+		return append([]Entry(nil), r.entries[:r.next]...)
+	}
+	out := make([]Entry, 0, len(r.entries))
+	out = append(out, r.entries[r.next:]...)
+	return append(out, r.entries[:r.next]...)
+}
+
+// MarshalJSON encodes every entry kept, oldest first.
+func (r *Recorder) MarshalJSON() ([]byte, error) {
+	entries := r.Entries()
+	if entries == nil {
+		entries = []Entry{}
+	}
+	return json.Marshal(entries)
+}
+
+// ServeHTTP serves the entries as JSON, filtered by the query parameters name, since (a
+// duration back from now such as "10m", or an RFC 3339 time), until (an RFC 3339 time),
+// failed (a boolean), exit_code and limit.
+func (r *Recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	q, err := ParseQuery(req.URL.Query(), cdsexec.ClockFromContext(req.Context()).Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entries := r.Query(q)
+	if entries == nil {
+		entries = []Entry{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// ParseQuery parses the query parameters described by ServeHTTP. Relative times are
+// relative to now.
+func ParseQuery(v url.Values, now time.Time) (Query, error) {
+	get := v.Get
+	q := Query{Name: get("name")}
+	var err error
+	if s := get("since"); s != "" {
+		if d, derr := time.ParseDuration(s); derr == nil {
+			q.Since = now.Add(-d)
+		} else if q.Since, err = time.Parse(time.RFC3339, s); err != nil {
+			return Query{}, err
+		}
+	}
+	if s := get("until"); s != "" {
+		if q.Until, err = time.Parse(time.RFC3339, s); err != nil {
+			return Query{}, err
+		}
+	}
+	if s := get("failed"); s != "" {
+		if q.Failed, err = strconv.ParseBool(s); err != nil {
+			return Query{}, err
+		}
+	}
+	if s := get("exit_code"); s != "" {
+		code, err := strconv.Atoi(s)
+		if err != nil {
+			return Query{}, err
+		}
+		q.ExitCode = &code
+	}
+	if s := get("limit"); s != "" {
+		if q.Limit, err = strconv.Atoi(s); err != nil {
+			return Query{}, err
+		}
+	}
+	return q, nil
+}
@@ -0,0 +1,97 @@
+package history_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/history"
+)
+
+func names(entries []history.Entry) []string {
+	var out []string
+	for _, e := range entries {
+		out = append(out, e.Name+" "+e.Args[0])
+	}
+	return out
+}
+
+func TestRecorder(t *testing.T) {
+	r := history.NewRecorder(3)
+	ctx := cdsexec.ContextWithRedactor(context.Background(), cdsexec.NewRedactor(cdsexec.RedactFlags("--key")))
+	ctor := r.Wrap(cdsexec.CommandContext)
+	ctor(ctx, "echo", "1").Run()
+	ctor(ctx, "/bin/sh", "-c", "exit 2").Run()
+	start := time.Now()
+	ctor(ctx, "echo", "3").Output()
+	ctor(ctx, "echo", "4", "--key", "s3cr3t").Run()
+
+	all := r.Entries()
+	if got := names(all); len(got) != 3 || got[0] != "/bin/sh -c" || got[2] != "echo 4" {
+		t.Fatalf("Expected the last 3 executions oldest first, got %q", got)
+	}
+	if all[2].Args[2] != cdsexec.DefaultMask {
+		t.Errorf("Expected redacted arguments, got %q", all[2].Args)
+	}
+	if all[0].ExitCode != 2 || all[0].Error != "exit status 2" || all[0].Duration <= 0 {
+		t.Errorf("Unexpected failed entry %+v", all[0])
+	}
+
+	code := 2
+	tests := []struct {
+		name     string
+		query    history.Query
+		expected int
+	}{
+		{name: "base name", query: history.Query{Name: "sh"}, expected: 1},
+		{name: "full name", query: history.Query{Name: "/bin/sh"}, expected: 1},
+		{name: "since", query: history.Query{Since: start}, expected: 2},
+		{name: "until", query: history.Query{Until: start}, expected: 1},
+		{name: "failed", query: history.Query{Failed: true}, expected: 1},
+		{name: "exit code", query: history.Query{ExitCode: &code}, expected: 1},
+		{name: "limit", query: history.Query{Name: "echo", Limit: 1}, expected: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.Query(tt.query); len(got) != tt.expected {
+				t.Errorf("Expected %d entries, got %q", tt.expected, names(got))
+			}
+		})
+	}
+	if got := r.Query(history.Query{Name: "echo", Limit: 1}); got[0].Args[0] != "4" {
+		t.Errorf("Expected the most recent entry, got %q", names(got))
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := history.NewRecorder(10)
+	ctor := r.Wrap(cdsexec.CommandContext)
+	ctor(context.Background(), "true").Run()
+	ctor(context.Background(), "false").Run()
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/history?since=10m&failed=true", nil))
+	var entries []map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0]["name"] != "false" || entries[0]["exit_code"] != 1.0 {
+		t.Errorf("Unexpected response %s", rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/history?limit=x", nil))
+	if rec.Code != 400 {
+		t.Errorf("Expected status 400, got %d", rec.Code)
+	}
+
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	q, err := history.ParseQuery(url.Values{"since": {"2026-10-17T11:00:00Z"}, "exit_code": {"0"}}, now)
+	if err != nil || !q.Since.Equal(now.Add(-time.Hour)) || q.ExitCode == nil || *q.ExitCode != 0 {
+		t.Errorf("Unexpected query %+v, %v", q, err)
+	}
+}
@@ -0,0 +1,64 @@
+package hooks
+
+import (
+	"context"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// BeforeFunc runs before a command executes. It may change spec; returning an error vetoes
+// the execution and the error is returned to the caller unchanged.
+type BeforeFunc func(ctx context.Context, spec *cdsexec.CommandSpec) error
+
+// AfterFunc runs once a command has finished, or was vetoed, with its outcome.
+type AfterFunc func(ctx context.Context, spec cdsexec.CommandSpec, res cdsexec.Result, err error)
+
+// Option registers a hook.
+type Option func(*config)
+
+type config struct {
+	before []BeforeFunc
+	after  []AfterFunc
+}
+
+// Before registers fn to run before every command. Before hooks run in registration order
+// and the first error stops the chain.
+func Before(fn BeforeFunc) Option {
+	return func(c *config) { c.before = append(c.before, fn) }
+}
+
+// After registers fn to run after every command. After hooks run in registration order.
+func After(fn AfterFunc) Option {
+	return func(c *config) { c.after = append(c.after, fn) }
+}
+
+// New returns a CommandConstructor running the registered hooks around every command built by base.
+func New(base cdsexec.CommandConstructor, opts ...Option) cdsexec.CommandConstructor {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		err := cfg.runBefore(inv)
+		if err == nil {
+			err = next(inv)
+		}
+		if len(cfg.after) > 0 {
+			res := inv.Result(err)
+			for _, fn := range cfg.after {
+				fn(inv.Ctx, inv.Spec, res, err)
+			}
+		}
+		return err
+	})
+}
+
+func (c *config) runBefore(inv *cdsexec.Invocation) error {
+	for _, fn := range c.before {
+		if err := fn(inv.Ctx, &inv.Spec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package hooks_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/hooks"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+func TestHooks(t *testing.T) {
+	errMaintenance := errors.New("maintenance window closed")
+	var ran []string
+	base := mockcmd.MakeMockCmdWithOutput("ok", func(m *mockcmd.MockCmd) error {
+		ran = append(ran, m.Name+" "+strings.Join(m.Args, " "))
+		return nil
+	})
+
+	var observed []string
+	ctor := hooks.New(base,
+		hooks.Before(func(ctx context.Context, spec *cdsexec.CommandSpec) error {
+			if spec.Name == "mdadm" {
+				return errMaintenance
+			}
+			return nil
+		}),
+		hooks.Before(func(ctx context.Context, spec *cdsexec.CommandSpec) error {
+			spec.Args = append([]string{"--quiet"}, spec.Args...)
+			return nil
+		}),
+		hooks.After(func(ctx context.Context, spec cdsexec.CommandSpec, res cdsexec.Result, err error) {
+			observed = append(observed, spec.String()+" exit="+map[bool]string{true: "ok", false: "err"}[err == nil]+" out="+string(res.Stdout))
+		}),
+	)
+
+	out, err := ctor(context.Background(), "lvs", "vg0").Output()
+	if err != nil || string(out) != "ok" {
+		t.Fatalf("Unexpected result %q, %v", out, err)
+	}
+	if err := ctor(context.Background(), "mdadm", "--grow", "/dev/md0").Run(); !errors.Is(err, errMaintenance) {
+		t.Errorf("Expected veto error, got %v", err)
+	}
+
+	if len(ran) != 1 || ran[0] != "lvs --quiet vg0" {
+		t.Errorf("Expected only the mutated lvs command to run, got %v", ran)
+	}
+	want := []string{"lvs --quiet vg0 exit=ok out=ok", "mdadm --grow /dev/md0 exit=err out="}
+	if strings.Join(observed, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected after hooks %v, got %v", want, observed)
+	}
+}
@@ -0,0 +1,274 @@
+package integritycmd
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// ErrIntegrity matches every error returned for a binary that failed verification.
+var ErrIntegrity = errors.New("integritycmd: binary failed integrity verification")
+
+// IntegrityError describes a binary that failed verification.
+type IntegrityError struct {
+	// Name is the name of the command, and Path the binary it resolved to.
+	Name, Path string
+	// Digest is the hex-encoded SHA-256 digest of the binary.
+	Digest string
+	// Want are the digests allowed for the binary, empty if it is not listed.
+	Want []string
+	// Err is the error of the Verifier that rejected the binary, if any.
+	Err error
+}
+
+func (e *IntegrityError) Error() string {
+	switch {
+	case e.Err != nil:
+		return fmt.Sprintf("integritycmd: %s: %v", e.Path, e.Err)
+	case len(e.Want) == 0:
+		return fmt.Sprintf("integritycmd: %s is not in the allowlist", e.Path)
+	}
+	return fmt.Sprintf("integritycmd: %s has digest %s, want %s", e.Path, e.Digest, strings.Join(e.Want, " or "))
+}
+
+func (e *IntegrityError) Unwrap() error { return e.Err }
+
+// Is reports whether target is ErrIntegrity or cdsexec.ErrPolicyDenied.
+func (e *IntegrityError) Is(target error) bool {
+	return target == ErrIntegrity || target == cdsexec.ErrPolicyDenied
+}
+
+// Verifier verifies a binary given its path and its SHA-256 digest, e.g. by checking a
+// detached signature of the digest, and returns why the binary must not run, or nil.
+type Verifier func(path string, digest []byte) error
+
+// Option configures the verification of the binaries.
+type Option func(*config)
+
+type config struct {
+	// digests are the hex-encoded digests allowed for each binary path.
+	digests  map[string][]string
+	verifier Verifier
+	unlisted bool
+	err      error
+}
+
+func (c *config) fail(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+func (c *config) add(path, digest string) error {
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("integritycmd: binary path %q is not absolute", path)
+	}
+	digest = strings.ToLower(digest)
+	if b, err := hex.DecodeString(digest); err != nil || len(b) != sha256.Size {
+		return fmt.Errorf("integritycmd: invalid SHA-256 digest %q for %s", digest, path)
+	}
+	if c.digests == nil {
+		c.digests = make(map[string][]string)
+	}
+	path = filepath.Clean(path)
+	c.digests[path] = append(c.digests[path], digest)
+	return nil
+}
+
+// WithDigest allows the binary at the absolute path to run if its SHA-256 digest, given in
+// hex, is one of digests; several digests let a binary be upgraded while commands run.
+func WithDigest(path string, digests ...string) Option {
+	return func(c *config) {
+		for _, d := range digests {
+			if err := c.add(path, d); err != nil {
+				c.fail(err)
+				return
+			}
+		}
+	}
+}
+
+// WithSumsFile loads the digests of binaries from a file in the format of sha256sum(1),
+// a hex digest and an absolute path on each line, such as a manifest shipped with the
+// appliance. The file is read once, by WithSumsFile.
+func WithSumsFile(name string) Option {
+	f, err := os.Open(name)
+	if err != nil {
+		return func(c *config) { c.fail(fmt.Errorf("integritycmd: %w", err)) }
+	}
+	defer f.Close()
+	var lines [][2]string
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		digest, path, ok := strings.Cut(line, " ")
+		if !ok {
+			err = fmt.Errorf("integritycmd: %s:%d: malformed line", name, n)
+			break
+		}
+		// sha256sum marks binary mode with an asterisk before the path.
+		lines = append(lines, [2]string{digest, strings.TrimPrefix(strings.TrimSpace(path), "*")})
+	}
+	if err == nil {
+		err = sc.Err()
+	}
+	return func(c *config) {
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		for _, l := range lines {
+			if err := c.add(l[1], l[0]); err != nil {
+				c.fail(fmt.Errorf("%w in %s", err, name))
+				return
+			}
+		}
+	}
+}
+
+// WithVerifier verifies the binaries with v, after their digest is checked against the
+// allowlist if they are listed in it. Binaries that are not listed run if v accepts them.
+func WithVerifier(v Verifier) Option {
+	return func(c *config) { c.verifier = v }
+}
+
+// AllowUnlisted lets the binaries that are not in the allowlist run unverified, so that
+// only the listed tools are pinned. By default, they fail.
+func AllowUnlisted() Option {
+	return func(c *config) { c.unlisted = true }
+}
+
+// New returns a CommandConstructor that verifies the binary of each command built by base
+// before it runs, and fails the command with an *IntegrityError if the binary is not
+// allowed. The name of a command is resolved in the PATH of its environment, and the
+// command runs the binary at the path verified. Binaries are listed by the path they are
+// found at or, if it is a symbolic link, the path it resolves to.
+//
+// On Linux, digests are cached for the life of the constructor, keyed by the inode, size
+// and change time of each file, so that a binary is read again only if it changed;
+// elsewhere binaries are read by every command. A binary is verified before it is
+// executed, not as it is: a concurrent writer of its directory can still replace it in
+// between, and the interpreters of scripts are not verified. base must run commands on the
+// local host.
+func New(base cdsexec.CommandConstructor, opts ...Option) cdsexec.CommandConstructor {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	var cache sync.Map // path -> cached
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		if cfg.err != nil {
+			return cfg.err
+		}
+		path, err := resolve(inv.Spec)
+		if err != nil {
+			return err
+		}
+		if err := cfg.verify(&cache, inv.Spec.Name, path); err != nil {
+			return err
+		}
+		inv.Spec.Name = path
+		return next(inv)
+	})
+}
+
+// resolve returns the absolute path of the binary of spec, found in the PATH of its
+// environment as cdsexec.LookPath does.
+func resolve(spec cdsexec.CommandSpec) (string, error) {
+	name := spec.Name
+	if !strings.ContainsRune(name, '/') {
+		path, _ := cdsexec.LookupEnv(spec.Env, "PATH")
+		resolved, err := cdsexec.LookPath(name, path)
+		if err != nil {
+			return "", err
+		}
+		name = resolved
+	} else if !filepath.IsAbs(name) && spec.Dir != "" {
+		name = filepath.Join(spec.Dir, name)
+	}
+	return filepath.Abs(name)
+}
+
+// verify returns an *IntegrityError if the binary at path, run as name, must not run.
+func (c *config) verify(cache *sync.Map, name, path string) error {
+	want, listed := c.digests[path]
+	if !listed {
+		if real, err := filepath.EvalSymlinks(path); err == nil {
+			want, listed = c.digests[real]
+		}
+	}
+	if !listed && c.verifier == nil {
+		if c.unlisted {
+			return nil
+		}
+		return &IntegrityError{Name: name, Path: path}
+	}
+	sum, err := digest(cache, path)
+	if err != nil {
+		return fmt.Errorf("integritycmd: %w", err)
+	}
+	got := hex.EncodeToString(sum)
+	if listed && !slices.Contains(want, got) {
+		return &IntegrityError{Name: name, Path: path, Digest: got, Want: want}
+	}
+	if c.verifier != nil {
+		if err := c.verifier(path, sum); err != nil {
+			return &IntegrityError{Name: name, Path: path, Digest: got, Want: want, Err: err}
+		}
+	}
+	return nil
+}
+
+// cached is the digest of a file as it was when it was read.
+type cached struct {
+	fi    os.FileInfo
+	ctime time.Time
+	sum   []byte
+}
+
+// digest returns the SHA-256 digest of the file at path, read again only if it changed
+// since it was cached.
+func digest(cache *sync.Map, path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	// The change time cannot be set back, unlike the modification time, so that a binary
+	// rewritten in place is always read again.
+	ctime, ok := changeTime(fi)
+	if ok {
+		if v, hit := cache.Load(path); hit {
+			if c := v.(cached); os.SameFile(c.fi, fi) && c.fi.Size() == fi.Size() && c.ctime.Equal(ctime) {
+				return c.sum, nil
+			}
+		}
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	sum := h.Sum(nil)
+	if ok {
+		cache.Store(path, cached{fi: fi, ctime: ctime, sum: sum})
+	}
+	return sum, nil
+}
@@ -0,0 +1,165 @@
+package integritycmd_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/integritycmd"
+)
+
+// tool writes an executable script named name into dir and returns its path and digest.
+func tool(t *testing.T, dir, name, script string) (string, string) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(script))
+	return path, hex.EncodeToString(sum[:])
+}
+
+func TestVerify(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("scripts are not executable on windows")
+	}
+	dir := t.TempDir()
+	path, digest := tool(t, dir, "zpool", "#!/bin/sh\necho ONLINE\n")
+	ctor := integritycmd.New(cdsexec.CommandContext, integritycmd.WithDigest(path, digest))
+	env := []string{"PATH=" + dir}
+
+	cmd := ctor(context.Background(), "zpool")
+	cmd.SetEnv(env)
+	out, err := cmd.Output()
+	if err != nil || string(out) != "ONLINE\n" {
+		t.Fatalf("Expected the verified binary to run, got %q, %v", out, err)
+	}
+
+	// The binary is tampered with on disk.
+	tool(t, dir, "zpool", "#!/bin/sh\necho pwned\n")
+	cmd = ctor(context.Background(), "zpool")
+	cmd.SetEnv(env)
+	out, err = cmd.Output()
+	var ie *integritycmd.IntegrityError
+	if !errors.As(err, &ie) || ie.Path != path || len(ie.Want) != 1 || ie.Want[0] != digest || ie.Digest == digest {
+		t.Fatalf("Expected an integrity error, got %v", err)
+	}
+	if len(out) != 0 {
+		t.Errorf("Expected the tampered binary not to run, got %q", out)
+	}
+	if !errors.Is(err, integritycmd.ErrIntegrity) || !errors.Is(err, cdsexec.ErrPolicyDenied) {
+		t.Errorf("Expected the error to match ErrIntegrity and ErrPolicyDenied, got %v", err)
+	}
+
+	// Binaries that are not listed fail unless allowed.
+	other, _ := tool(t, dir, "zfs", "#!/bin/sh\nexit 0\n")
+	if err := ctor(context.Background(), other).Run(); !errors.As(err, &ie) || !strings.Contains(err.Error(), "not in the allowlist") {
+		t.Errorf("Expected an unlisted binary to fail, got %v", err)
+	}
+	ctor = integritycmd.New(cdsexec.CommandContext, integritycmd.WithDigest(path, digest), integritycmd.AllowUnlisted())
+	if err := ctor(context.Background(), other).Run(); err != nil {
+		t.Errorf("Expected an unlisted binary to run, got %v", err)
+	}
+}
+
+func TestCurrentDirectory(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("scripts are not executable on windows")
+	}
+	dir := t.TempDir()
+	path, digest := tool(t, dir, "zpool", "#!/bin/sh\necho ONLINE\n")
+	ctor := integritycmd.New(cdsexec.CommandContext, integritycmd.WithDigest(path, digest))
+
+	// An empty entry of PATH is the directory of the current process, not that of the
+	// command: the binary found there must not be verified and run.
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	cmd := ctor(context.Background(), "zpool")
+	cmd.SetEnv([]string{"PATH=:/nonexistent"})
+	cmd.SetDir(t.TempDir())
+	if out, err := cmd.Output(); !errors.Is(err, exec.ErrDot) || len(out) != 0 {
+		t.Errorf("Expected ErrDot, got %q, %v", out, err)
+	}
+}
+
+func TestSymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("scripts are not executable on windows")
+	}
+	dir := t.TempDir()
+	path, digest := tool(t, dir, "python3.11", "#!/bin/sh\nexit 0\n")
+	link := filepath.Join(dir, "python3")
+	if err := os.Symlink(path, link); err != nil {
+		t.Fatal(err)
+	}
+	ctor := integritycmd.New(cdsexec.CommandContext, integritycmd.WithDigest(path, digest))
+	if err := ctor(context.Background(), link).Run(); err != nil {
+		t.Errorf("Expected a link to a listed binary to run, got %v", err)
+	}
+}
+
+func TestSumsFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("scripts are not executable on windows")
+	}
+	dir := t.TempDir()
+	lvs, lvsDigest := tool(t, dir, "lvs", "#!/bin/sh\nexit 0\n")
+	vgs, _ := tool(t, dir, "vgs", "#!/bin/sh\nexit 0\n")
+	sums := filepath.Join(dir, "SHA256SUMS")
+	manifest := "# tools\n" + lvsDigest + "  " + lvs + "\n" + strings.Repeat("0", 64) + " *" + vgs + "\n"
+	if err := os.WriteFile(sums, []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctor := integritycmd.New(cdsexec.CommandContext, integritycmd.WithSumsFile(sums))
+	if err := ctor(context.Background(), lvs).Run(); err != nil {
+		t.Errorf("Expected lvs to run, got %v", err)
+	}
+	if err := ctor(context.Background(), vgs).Run(); !errors.Is(err, integritycmd.ErrIntegrity) {
+		t.Errorf("Expected vgs to fail verification, got %v", err)
+	}
+
+	if err := os.WriteFile(sums, []byte("deadbeef "+lvs+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctor = integritycmd.New(cdsexec.CommandContext, integritycmd.WithSumsFile(sums))
+	if err := ctor(context.Background(), lvs).Run(); err == nil || !strings.Contains(err.Error(), "invalid SHA-256 digest") {
+		t.Errorf("Expected an invalid digest to fail, got %v", err)
+	}
+}
+
+func TestVerifier(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("scripts are not executable on windows")
+	}
+	dir := t.TempDir()
+	signed, signedDigest := tool(t, dir, "multipath", "#!/bin/sh\nexit 0\n")
+	unsigned, _ := tool(t, dir, "kpartx", "#!/bin/sh\nexit 1\n")
+	errUnsigned := errors.New("no valid signature")
+	ctor := integritycmd.New(cdsexec.CommandContext, integritycmd.WithVerifier(func(path string, digest []byte) error {
+		if hex.EncodeToString(digest) != signedDigest {
+			return errUnsigned
+		}
+		return nil
+	}))
+	if err := ctor(context.Background(), signed).Run(); err != nil {
+		t.Errorf("Expected the signed binary to run, got %v", err)
+	}
+	err := ctor(context.Background(), unsigned).Run()
+	if !errors.Is(err, errUnsigned) || !errors.Is(err, integritycmd.ErrIntegrity) {
+		t.Errorf("Expected the verifier to reject the binary, got %v", err)
+	}
+}
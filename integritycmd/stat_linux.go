@@ -0,0 +1,16 @@
+package integritycmd
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// changeTime returns the change time of the file fi describes.
+func changeTime(fi os.FileInfo) (time.Time, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(st.Ctim.Unix()), true
+}
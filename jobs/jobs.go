@@ -0,0 +1,359 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+var (
+	// ErrNotFound is returned for the IDs of jobs the queue does not know, or no longer
+	// retains.
+	ErrNotFound = errors.New("jobs: no such job")
+	// ErrClosed is returned by Enqueue once the queue is closed.
+	ErrClosed = errors.New("jobs: queue closed")
+	// ErrFinished is returned by Cancel for jobs that have finished already.
+	ErrFinished = errors.New("jobs: job finished")
+	// ErrInterrupted is the error of the jobs that were running when the program stopped,
+	// as found in the Store by New. They are not run again, as they may have had part of
+	// their effect.
+	ErrInterrupted = errors.New("jobs: interrupted")
+)
+
+// State is the state of a job.
+type State string
+
+// The states of a job, in the order it goes through them.
+const (
+	Queued    State = "queued"
+	Running   State = "running"
+	Succeeded State = "succeeded"
+	Failed    State = "failed"
+	Cancelled State = "cancelled"
+)
+
+// Done reports whether s is a final state.
+func (s State) Done() bool {
+	return s == Succeeded || s == Failed || s == Cancelled
+}
+
+// Job is a command spec accepted by a Queue and its progress.
+type Job struct {
+	ID    string              `json:"id"`
+	Spec  cdsexec.CommandSpec `json:"spec"`
+	State State               `json:"state"`
+	// Result is the outcome of the command, once it has run.
+	Result *cdsexec.Result `json:"result,omitempty"`
+	// Error is the error the job failed or was cancelled with.
+	Error    string    `json:"error,omitempty"`
+	Enqueued time.Time `json:"enqueued"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+}
+
+// Config configures a Queue.
+type Config struct {
+	// Constructor constructs the commands of the jobs. Nil means cdsexec.CommandContext.
+	Constructor cdsexec.CommandConstructor
+	// Workers is the number of jobs run at once. Zero means 1.
+	Workers int
+	// Store persists the jobs, so that those still queued when the program stops are run
+	// once it starts again. Nil keeps them in memory only.
+	Store Store
+	// Retain is how long finished jobs are kept after they finish. Zero keeps them until
+	// they are removed.
+	Retain time.Duration
+	// Clock timestamps the jobs and is set on the context of their commands. Nil means
+	// cdsexec.RealClock.
+	Clock cdsexec.Clock
+}
+
+// Queue runs the command specs enqueued into it, in order, with a pool of workers.
+type Queue struct {
+	cfg    Config
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	jobs    map[string]*job
+	pending []*job
+	closed  bool
+}
+
+type job struct {
+	Job
+	cancel    context.CancelFunc
+	cancelled bool
+	done      chan struct{}
+}
+
+// New creates a Queue from cfg, loads the jobs of cfg.Store and starts the workers. Jobs
+// found queued are run again; jobs found running fail with ErrInterrupted.
+func New(cfg Config) (*Queue, error) {
+	if cfg.Constructor == nil {
+		cfg.Constructor = cdsexec.CommandContext
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = cdsexec.RealClock
+	}
+	q := &Queue{cfg: cfg, jobs: make(map[string]*job)}
+	q.cond = sync.NewCond(&q.mu)
+	if cfg.Store != nil {
+		loaded, err := cfg.Store.Load()
+		if err != nil {
+			return nil, err
+		}
+		sort.SliceStable(loaded, func(i, k int) bool { return loaded[i].Enqueued.Before(loaded[k].Enqueued) })
+		now := cfg.Clock.Now()
+		for _, lj := range loaded {
+			j := &job{Job: lj, done: make(chan struct{})}
+			switch {
+			case j.State == Queued:
+				q.pending = append(q.pending, j)
+			case j.State == Running:
+				j.State, j.Error, j.Finished = Failed, ErrInterrupted.Error(), now
+				if err := cfg.Store.Save(j.Job); err != nil {
+					return nil, err
+				}
+				fallthrough
+			default:
+				close(j.done)
+			}
+			q.jobs[j.ID] = j
+		}
+	}
+	q.ctx, q.cancel = context.WithCancel(cdsexec.ContextWithClock(context.Background(), cfg.Clock))
+	for i := 0; i < cfg.Workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	return q, nil
+}
+
+// Enqueue adds a job running spec to the queue and returns its ID. The job is saved to
+// the Store before Enqueue returns, so a spec that cannot be saved, e.g. because its
+// Stdin is not serializable, is refused.
+func (q *Queue) Enqueue(spec cdsexec.CommandSpec) (string, error) {
+	j := &job{Job: Job{ID: newID(), Spec: spec, State: Queued, Enqueued: q.cfg.Clock.Now()}, done: make(chan struct{})}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return "", ErrClosed
+	}
+	if err := q.saveLocked(j); err != nil {
+		return "", err
+	}
+	q.jobs[j.ID] = j
+	q.pending = append(q.pending, j)
+	q.pruneLocked(j.Enqueued)
+	q.cond.Signal()
+	return j.ID, nil
+}
+
+// Get returns the job id.
+func (q *Queue) Get(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return j.Job, nil
+}
+
+// List returns the jobs of the queue, in the order they were enqueued.
+func (q *Queue) List() []Job {
+	q.mu.Lock()
+	list := make([]Job, 0, len(q.jobs))
+	for _, j := range q.jobs {
+		list = append(list, j.Job)
+	}
+	q.mu.Unlock()
+	sort.Slice(list, func(i, k int) bool {
+		if !list[i].Enqueued.Equal(list[k].Enqueued) {
+			return list[i].Enqueued.Before(list[k].Enqueued)
+		}
+		return list[i].ID < list[k].ID
+	})
+	return list
+}
+
+// Cancel cancels the job id: a queued job will not run, and the command of a running job
+// is cancelled through its context. It returns ErrFinished if the job has finished.
+func (q *Queue) Cancel(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return ErrNotFound
+	}
+	switch j.State {
+	case Queued:
+		for i, p := range q.pending {
+			if p == j {
+				q.pending = append(q.pending[:i], q.pending[i+1:]...)
+				break
+			}
+		}
+		j.State, j.Error, j.Finished = Cancelled, context.Canceled.Error(), q.cfg.Clock.Now()
+		close(j.done)
+		return q.saveLocked(j)
+	case Running:
+		j.cancelled = true
+		j.cancel()
+		return nil
+	}
+	return ErrFinished
+}
+
+// Remove forgets the finished job id, dropping it from the Store.
+func (q *Queue) Remove(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if !j.State.Done() {
+		return fmt.Errorf("jobs: job %s is %s", id, j.State)
+	}
+	return q.deleteLocked(j)
+}
+
+// Wait waits for the job id to finish and returns it, or the error of ctx if it is done
+// first.
+func (q *Queue) Wait(ctx context.Context, id string) (Job, error) {
+	q.mu.Lock()
+	j, ok := q.jobs[id]
+	q.mu.Unlock()
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	select {
+	case <-j.done:
+	case <-ctx.Done():
+		return Job{}, ctx.Err()
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return j.Job, nil
+}
+
+// Close stops the queue from accepting and starting jobs, and waits for the running jobs to
+// finish. If ctx is done first, their commands are cancelled and Close returns the error of
+// ctx once they have returned. Queued jobs stay in the Store, to run when the program
+// starts again.
+func (q *Queue) Close(ctx context.Context) error {
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		q.cancel()
+		return nil
+	case <-ctx.Done():
+		q.cancel()
+		<-stopped
+		return ctx.Err()
+	}
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+	for {
+		q.mu.Lock()
+		for len(q.pending) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if q.closed {
+			q.mu.Unlock()
+			return
+		}
+		j := q.pending[0]
+		q.pending = q.pending[1:]
+		ctx, cancel := context.WithCancel(q.ctx)
+		j.cancel = cancel
+		j.State, j.Started = Running, q.cfg.Clock.Now()
+		q.saveLocked(j)
+		q.mu.Unlock()
+
+		results, err := cdsexec.OutputAll(ctx, q.cfg.Constructor, []cdsexec.CommandSpec{j.Spec}, 1)
+		cancel()
+		var ge *cdsexec.GroupError
+		if errors.As(err, &ge) {
+			err = ge.Errs[0]
+		}
+
+		q.mu.Lock()
+		j.Result, j.Finished = &results[0], q.cfg.Clock.Now()
+		switch {
+		case j.cancelled:
+			j.State = Cancelled
+		case err != nil:
+			j.State = Failed
+		default:
+			j.State = Succeeded
+		}
+		if err != nil {
+			j.Error = err.Error()
+		}
+		close(j.done)
+		q.saveLocked(j)
+		q.pruneLocked(j.Finished)
+		q.mu.Unlock()
+	}
+}
+
+// saveLocked saves j to the Store. The state changes of running jobs are saved on a best
+// effort basis: a failure leaves the previous state in the Store.
+func (q *Queue) saveLocked(j *job) error {
+	if q.cfg.Store == nil {
+		return nil
+	}
+	return q.cfg.Store.Save(j.Job)
+}
+
+func (q *Queue) deleteLocked(j *job) error {
+	delete(q.jobs, j.ID)
+	if q.cfg.Store == nil {
+		return nil
+	}
+	return q.cfg.Store.Delete(j.ID)
+}
+
+// pruneLocked forgets the jobs that finished more than Retain before now.
+func (q *Queue) pruneLocked(now time.Time) {
+	if q.cfg.Retain <= 0 {
+		return
+	}
+	for _, j := range q.jobs {
+		if j.State.Done() && !now.Before(j.Finished.Add(q.cfg.Retain)) {
+			q.deleteLocked(j)
+		}
+	}
+}
+
+func newID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
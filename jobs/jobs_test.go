@@ -0,0 +1,166 @@
+package jobs_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/jobs"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+func waitFor(t *testing.T, q *jobs.Queue, id string) jobs.Job {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	j, err := q.Wait(ctx, id)
+	if err != nil {
+		t.Fatalf("Unexpected error waiting for %s: %v", id, err)
+	}
+	return j
+}
+
+func TestQueue(t *testing.T) {
+	q, err := jobs.New(jobs.Config{Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close(context.Background())
+
+	ok, _ := q.Enqueue(cdsexec.CommandSpec{Name: "sh", Args: []string{"-c", "echo pool0"}})
+	failed, _ := q.Enqueue(cdsexec.CommandSpec{Name: "sh", Args: []string{"-c", "echo 'no pools' >&2; exit 1"}})
+
+	j := waitFor(t, q, ok)
+	if j.State != jobs.Succeeded || j.Result == nil || string(j.Result.Stdout) != "pool0\n" {
+		t.Errorf("Expected a succeeded job with its output, got %+v", j)
+	}
+	if j.Started.IsZero() || j.Finished.Before(j.Started) {
+		t.Errorf("Unexpected times %v and %v", j.Started, j.Finished)
+	}
+	j = waitFor(t, q, failed)
+	if j.State != jobs.Failed || j.Result.ExitCode != 1 || !strings.Contains(j.Error, "no pools") {
+		t.Errorf("Expected a failed job with exit code 1, got %+v", j)
+	}
+
+	list := q.List()
+	if len(list) != 2 || list[0].ID != ok || list[1].ID != failed {
+		t.Errorf("Expected the jobs in order, got %+v", list)
+	}
+	if err := q.Cancel(ok); !errors.Is(err, jobs.ErrFinished) {
+		t.Errorf("Expected ErrFinished, got %v", err)
+	}
+	if err := q.Remove(ok); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Get(ok); !errors.Is(err, jobs.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after Remove, got %v", err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	entered := make(chan struct{})
+	base := mockcmd.MakeMockCmdWithOutput("", func(m *mockcmd.MockCmd) error {
+		close(entered)
+		<-m.Ctx.Done()
+		return m.Ctx.Err()
+	})
+	q, err := jobs.New(jobs.Config{Constructor: base})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close(context.Background())
+
+	running, _ := q.Enqueue(cdsexec.CommandSpec{Name: "resilver"})
+	queued, _ := q.Enqueue(cdsexec.CommandSpec{Name: "scrub"})
+	<-entered
+	if j, _ := q.Get(running); j.State != jobs.Running {
+		t.Errorf("Expected the first job to run, got %s", j.State)
+	}
+	if j, _ := q.Get(queued); j.State != jobs.Queued {
+		t.Errorf("Expected the second job to wait, got %s", j.State)
+	}
+
+	if err := q.Cancel(queued); err != nil {
+		t.Fatal(err)
+	}
+	if j := waitFor(t, q, queued); j.State != jobs.Cancelled || j.Result != nil {
+		t.Errorf("Expected the queued job to be cancelled without running, got %+v", j)
+	}
+	if err := q.Cancel(running); err != nil {
+		t.Fatal(err)
+	}
+	if j := waitFor(t, q, running); j.State != jobs.Cancelled || j.Result == nil {
+		t.Errorf("Expected the running job to be cancelled, got %+v", j)
+	}
+}
+
+func TestClock(t *testing.T) {
+	start := time.Date(2024, 3, 1, 2, 0, 0, 0, time.UTC)
+	clock := mockcmd.NewFakeClock(start)
+	base := mockcmd.MakeMockCmdWithOutput("", func(m *mockcmd.MockCmd) error {
+		cdsexec.ClockFromContext(m.Ctx).(*mockcmd.FakeClock).Advance(time.Minute)
+		return nil
+	})
+	q, err := jobs.New(jobs.Config{Constructor: base, Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close(context.Background())
+
+	id, _ := q.Enqueue(cdsexec.CommandSpec{Name: "trim"})
+	j := waitFor(t, q, id)
+	if !j.Enqueued.Equal(start) || !j.Started.Equal(start) || !j.Finished.Equal(start.Add(time.Minute)) {
+		t.Errorf("Expected the times on the clock of the queue, got %v, %v and %v", j.Enqueued, j.Started, j.Finished)
+	}
+}
+
+func TestDirStore(t *testing.T) {
+	dir := jobs.DirStore(t.TempDir())
+	block := make(chan struct{})
+	base := mockcmd.MakeMockCmdWithOutput("", func(m *mockcmd.MockCmd) error {
+		<-block
+		return nil
+	})
+	q, err := jobs.New(jobs.Config{Constructor: base, Store: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = q.Enqueue(cdsexec.CommandSpec{Name: "cat", Stdin: &bytes.Buffer{}})
+	if !errors.Is(err, cdsexec.ErrNotSerializable) {
+		t.Errorf("Expected ErrNotSerializable, got %v", err)
+	}
+	first, _ := q.Enqueue(cdsexec.CommandSpec{Name: "zfs", Args: []string{"snapshot", "tank@a"}})
+	second, _ := q.Enqueue(cdsexec.CommandSpec{Name: "zfs", Args: []string{"send", "tank@a"}, Stdin: strings.NewReader("x")})
+	for {
+		if j, _ := q.Get(first); j.State == jobs.Running {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Another queue loading the store stands for the program started again after a crash.
+	q2, err := jobs.New(jobs.Config{Constructor: mockcmd.MakeMockCmdWithOutput("", nil), Store: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q2.Close(context.Background())
+	if j := waitFor(t, q2, first); j.State != jobs.Failed || j.Error != jobs.ErrInterrupted.Error() {
+		t.Errorf("Expected the running job to be interrupted, got %+v", j)
+	}
+	j := waitFor(t, q2, second)
+	if j.State != jobs.Succeeded || strings.Join(j.Spec.Args, " ") != "send tank@a" {
+		t.Errorf("Expected the queued job to run, got %+v", j)
+	}
+
+	close(block)
+	if err := q.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Enqueue(cdsexec.CommandSpec{Name: "zfs"}); !errors.Is(err, jobs.ErrClosed) {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Store persists the jobs of a Queue. The Queue calls it with its lock held, one call at a
+// time.
+type Store interface {
+	// Load returns the jobs saved so far.
+	Load() ([]Job, error)
+	// Save saves j, replacing the previous state of the job.
+	Save(j Job) error
+	// Delete drops the job id.
+	Delete(id string) error
+}
+
+// DirStore is a Store keeping each job as a JSON file named after its ID in a directory.
+// Files are replaced atomically, so a crash leaves the previous state of a job.
+type DirStore string
+
+// Load implements Store. A missing directory holds no jobs.
+func (d DirStore) Load() ([]Job, error) {
+	entries, err := os.ReadDir(string(d))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var jobs []Job
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		p := filepath.Join(string(d), e.Name())
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		var j Job
+		if err := json.Unmarshal(data, &j); err != nil {
+			return nil, fmt.Errorf("jobs: reading %s: %w", p, err)
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, nil
+}
+
+// Save implements Store, creating the directory if needed.
+func (d DirStore) Save(j Job) error {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(string(d), 0o700); err != nil {
+		return fmt.Errorf("jobs: saving job %s: %w", j.ID, err)
+	}
+	f, err := os.CreateTemp(string(d), j.ID+".tmp*")
+	if err != nil {
+		return fmt.Errorf("jobs: saving job %s: %w", j.ID, err)
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), d.path(j.ID))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("jobs: saving job %s: %w", j.ID, err)
+	}
+	return nil
+}
+
+// Delete implements Store.
+func (d DirStore) Delete(id string) error {
+	if err := os.Remove(d.path(id)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (d DirStore) path(id string) string {
+	return filepath.Join(string(d), id+".json")
+}
@@ -0,0 +1,140 @@
+package cdsexec
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultMaxJSONSize is the largest output OutputJSON decodes by default.
+const DefaultMaxJSONSize = 16 << 20
+
+// ErrOutputTooLarge matches errors for output exceeding the size allowed by the caller.
+var ErrOutputTooLarge = errors.New("cdsexec: output too large")
+
+// JSONOptions configures OutputJSONWith.
+type JSONOptions struct {
+	// MaxSize is the largest output decoded, DefaultMaxJSONSize if 0. The command is killed
+	// as soon as its output exceeds it, if it runs as a local process.
+	MaxSize int64
+	// DisallowUnknownFields fails decoding on object keys that do not match a field of the
+	// target, to detect output from incompatible versions of a tool.
+	DisallowUnknownFields bool
+}
+
+// OutputJSON runs cmd and decodes its standard output, which must hold exactly one JSON
+// value, into v. It is OutputJSONWith with the default options.
+func OutputJSON(ctx context.Context, cmd Commander, v any) error {
+	return OutputJSONWith(ctx, cmd, v, JSONOptions{})
+}
+
+// OutputJSONWith runs cmd and decodes its standard output, which must hold exactly one
+// JSON value, into v. If cmd fails its error is returned, and if the output is too large
+// the error matches ErrOutputTooLarge. If decoding fails, the *JSONError returned holds the
+// output around the offending offset, redacted by the Redactor of ctx.
+func OutputJSONWith(ctx context.Context, cmd Commander, v any, opts JSONOptions) error {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = DefaultMaxJSONSize
+	}
+	out := &limitedBuffer{limit: opts.MaxSize, cmd: cmd, buf: getBuffer("")}
+	defer putBuffer(out.buf)
+	cmd.SetStdout(out)
+	if err := cmd.Run(); err != nil {
+		if out.exceeded {
+			return fmt.Errorf("%w: more than %d bytes", ErrOutputTooLarge, opts.MaxSize)
+		}
+		return err
+	}
+	if out.exceeded {
+		return fmt.Errorf("%w: more than %d bytes", ErrOutputTooLarge, opts.MaxSize)
+	}
+
+	data := out.buf.Bytes()
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if opts.DisallowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	err := dec.Decode(v)
+	if err == nil && dec.More() {
+		err = errors.New("invalid character after top-level value")
+	}
+	if err == nil {
+		return nil
+	}
+	offset := dec.InputOffset()
+	var se *json.SyntaxError
+	var te *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &se):
+		offset = se.Offset
+	case errors.As(err, &te):
+		offset = te.Offset
+	case errors.Is(err, io.EOF):
+		err = io.ErrUnexpectedEOF
+	}
+	return &JSONError{
+		Err:     err,
+		Offset:  offset,
+		Snippet: RedactorFromContext(ctx).Text(snippet(data, offset)),
+	}
+}
+
+// JSONError reports output that could not be decoded by OutputJSON.
+type JSONError struct {
+	Err error
+	// Offset is the offset in the output where decoding failed.
+	Offset int64
+	// Snippet is the output around Offset.
+	Snippet string
+}
+
+func (e *JSONError) Error() string {
+	return fmt.Sprintf("cdsexec: decoding JSON output: %v, near offset %d: %q", e.Err, e.Offset, e.Snippet)
+}
+
+func (e *JSONError) Unwrap() error {
+	return e.Err
+}
+
+// snippetRadius is the number of bytes of output kept on each side of a decoding error.
+const snippetRadius = 48
+
+func snippet(data []byte, offset int64) string {
+	start, end := offset-snippetRadius, offset+snippetRadius
+	if start < 0 {
+		start = 0
+	}
+	if end > int64(len(data)) {
+		end = int64(len(data))
+	}
+	if start > end {
+		start = end
+	}
+	return string(data[start:end])
+}
+
+// limitedBuffer collects output up to limit bytes. Past it, the rest of the output is
+// discarded so the command does not block writing, and the command is killed.
+type limitedBuffer struct {
+	limit    int64
+	cmd      Commander
+	buf      *bytes.Buffer
+	exceeded bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.exceeded {
+		return len(p), nil
+	}
+	if int64(b.buf.Len()+len(p)) > b.limit {
+		b.exceeded = true
+		if proc := b.cmd.Process(); proc != nil {
+			proc.Kill()
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
@@ -0,0 +1,102 @@
+package cdsexec_test
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+type blockDevices struct {
+	Blockdevices []struct {
+		Name string `json:"name"`
+		Size int64  `json:"size"`
+	} `json:"blockdevices"`
+}
+
+func TestOutputJSON(t *testing.T) {
+	ctx := context.Background()
+	var devs blockDevices
+	cmd := cdsexec.CommandContext(ctx, "echo", `{"blockdevices": [{"name": "sda", "size": 512}]}`)
+	if err := cdsexec.OutputJSON(ctx, cmd, &devs); err != nil {
+		t.Fatal(err)
+	}
+	if len(devs.Blockdevices) != 1 || devs.Blockdevices[0].Name != "sda" || devs.Blockdevices[0].Size != 512 {
+		t.Errorf("Unexpected result %+v", devs)
+	}
+}
+
+func TestOutputJSONErrors(t *testing.T) {
+	ctx := cdsexec.ContextWithRedactor(context.Background(),
+		cdsexec.NewRedactor(cdsexec.RedactPattern(regexp.MustCompile(`"key": "([^"]*)"`))))
+	tests := []struct {
+		name    string
+		script  string
+		opts    cdsexec.JSONOptions
+		is      error
+		snippet string
+	}{
+		{
+			name:    "syntax",
+			script:  `echo '{"blockdevices": [oops]}'`,
+			snippet: `{"blockdevices": [o`,
+		},
+		{
+			name:    "type",
+			script:  `echo '{"blockdevices": [{"name": "sda", "size": "1G"}], "key": "s3cr3t"}'`,
+			snippet: `"size": "1G"}], "key": "***"}`,
+		},
+		{
+			name:    "truncated",
+			script:  `printf '{"blockdevices": ['`,
+			snippet: `{"blockdevices": [`,
+		},
+		{
+			name:    "trailing",
+			script:  `echo '{} {}'`,
+			snippet: `{} {}` + "\n",
+		},
+		{
+			name:   "unknown field",
+			script: `echo '{"devices": []}'`,
+			opts:   cdsexec.JSONOptions{DisallowUnknownFields: true},
+		},
+		{
+			name:   "too large",
+			script: `exec yes '{}'`,
+			opts:   cdsexec.JSONOptions{MaxSize: 1 << 10},
+			is:     cdsexec.ErrOutputTooLarge,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var devs blockDevices
+			err := cdsexec.OutputJSONWith(ctx, cdsexec.CommandContext(ctx, "sh", "-c", tt.script), &devs, tt.opts)
+			if tt.is != nil {
+				if !errors.Is(err, tt.is) {
+					t.Errorf("Expected %v, got %v", tt.is, err)
+				}
+				return
+			}
+			var je *cdsexec.JSONError
+			if !errors.As(err, &je) {
+				t.Fatalf("Expected *JSONError, got %v", err)
+			}
+			if !strings.Contains(je.Snippet, tt.snippet) {
+				t.Errorf("Expected snippet %q, got %q", tt.snippet, je.Snippet)
+			}
+		})
+	}
+}
+
+func TestOutputJSONExitError(t *testing.T) {
+	ctx := context.Background()
+	var v any
+	err := cdsexec.OutputJSON(ctx, cdsexec.CommandContext(ctx, "sh", "-c", "echo '{}'; exit 2"), &v)
+	if cdsexec.ExitCode(err) != 2 {
+		t.Errorf("Expected exit code 2, got %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package kubecmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/cirrusdata/cdsexec"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+// Target identifies the container commands are executed in.
+type Target struct {
+	Namespace string
+	Pod       string
+	// Container may be empty for pods with a single container.
+	Container string
+}
+
+// ExecutorFunc creates the executor streaming one command described by opts.
+type ExecutorFunc func(opts *corev1.PodExecOptions) (remotecommand.Executor, error)
+
+// New returns a CommandConstructor executing commands in the target container through the
+// exec subresource of the pod, over WebSocket with a fallback to SPDY for older API servers.
+func New(config *rest.Config, t Target) (cdsexec.CommandConstructor, error) {
+	cs, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("kubecmd: %w", err)
+	}
+	return NewWithExecutor(t, func(opts *corev1.PodExecOptions) (remotecommand.Executor, error) {
+		u := cs.CoreV1().RESTClient().Post().
+			Resource("pods").
+			Namespace(t.Namespace).
+			Name(t.Pod).
+			SubResource("exec").
+			VersionedParams(opts, scheme.ParameterCodec).
+			URL()
+		ws, err := remotecommand.NewWebSocketExecutor(config, "GET", u.String())
+		if err != nil {
+			return nil, err
+		}
+		spdy, err := remotecommand.NewSPDYExecutor(config, "POST", u)
+		if err != nil {
+			return nil, err
+		}
+		return remotecommand.NewFallbackExecutor(ws, spdy, httpstream.IsUpgradeFailure)
+	}), nil
+}
+
+// NewWithExecutor returns a CommandConstructor executing commands in the target container
+// through the executors created by fn.
+//
+// The exec subresource has no notion of working directory or environment, so commands
+// with either set are run through sh and env in the container. Cancelling the context
+// tears the streams down. Non-zero exits are reported as *cdsexec.ExitError, and errors
+// are classified by cdsexec.Classify: a command missing from the container, which the
+// runtime reports with exit code 127, matches cdsexec.ErrNotFound.
+func NewWithExecutor(t Target, fn ExecutorFunc) cdsexec.CommandConstructor {
+	return cdsexec.StreamConstructor(func(ctx context.Context, spec cdsexec.CommandSpec) error {
+		opts := &corev1.PodExecOptions{
+			Container: t.Container,
+			Command:   argv(spec),
+			Stdin:     spec.Stdin != nil,
+			Stdout:    spec.Stdout != nil,
+			Stderr:    spec.Stderr != nil,
+		}
+		exec, err := fn(opts)
+		if err != nil {
+			return fmt.Errorf("kubecmd: %w", err)
+		}
+		err = exec.StreamWithContext(ctx, remotecommand.StreamOptions{
+			Stdin:  spec.Stdin,
+			Stdout: spec.Stdout,
+			Stderr: spec.Stderr,
+		})
+		return mapError(ctx, err)
+	})
+}
+
+// argv returns the command line to execute, applying the directory and environment of spec.
+func argv(spec cdsexec.CommandSpec) []string {
+	cmd := append([]string{spec.Name}, spec.Args...)
+	if len(spec.Env) > 0 {
+		cmd = append(append([]string{"env"}, spec.Env...), cmd...)
+	}
+	if spec.Dir != "" {
+		cmd = append([]string{"sh", "-c", `cd "$1" && shift && exec "$@"`, "sh", spec.Dir}, cmd...)
+	}
+	return cmd
+}
+
+func mapError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("kubecmd: %w", ctx.Err())
+	}
+	var ee utilexec.ExitError
+	if errors.As(err, &ee) && ee.Exited() {
+		return &cdsexec.ExitError{Code: ee.ExitStatus()}
+	}
+	return fmt.Errorf("kubecmd: %w", err)
+}
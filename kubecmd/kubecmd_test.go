@@ -0,0 +1,118 @@
+package kubecmd_test
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/kubecmd"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+// localExecutor runs the requested command locally, the way the kubelet would in the
+// container, where the runtime reports a missing command with exit code 127.
+type localExecutor struct {
+	opts *corev1.PodExecOptions
+}
+
+func (e *localExecutor) Stream(o remotecommand.StreamOptions) error {
+	return e.StreamWithContext(context.Background(), o)
+}
+
+func (e *localExecutor) StreamWithContext(ctx context.Context, o remotecommand.StreamOptions) error {
+	cmd := exec.CommandContext(ctx, e.opts.Command[0], e.opts.Command[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = o.Stdin, o.Stdout, o.Stderr
+	err := cmd.Run()
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		return utilexec.CodeExitError{Err: err, Code: ee.ExitCode()}
+	}
+	if errors.Is(err, exec.ErrNotFound) {
+		return utilexec.CodeExitError{Err: err, Code: 127}
+	}
+	return err
+}
+
+func newCtor(seen *[]*corev1.PodExecOptions) cdsexec.CommandConstructor {
+	return kubecmd.NewWithExecutor(kubecmd.Target{Namespace: "kube-system", Pod: "csi-node-x", Container: "driver"},
+		func(opts *corev1.PodExecOptions) (remotecommand.Executor, error) {
+			*seen = append(*seen, opts)
+			return &localExecutor{opts: opts}, nil
+		})
+}
+
+func TestExec(t *testing.T) {
+	var seen []*corev1.PodExecOptions
+	ctor := newCtor(&seen)
+
+	tests := []struct {
+		name     string
+		cmd      []string
+		setup    func(cdsexec.Commander)
+		want     string
+		wantCode int
+		wantArgv string
+	}{
+		{"plain", []string{"echo", "a b"}, nil, "a b\n", 0, "echo|a b"},
+		{"stdin", []string{"cat"}, func(c cdsexec.Commander) { c.SetStdin(strings.NewReader("in")) }, "in", 0, "cat"},
+		{"env", []string{"sh", "-c", "echo $NODE"}, func(c cdsexec.Commander) { c.SetEnv([]string{"NODE=n1"}) }, "n1\n", 0, "env|NODE=n1|sh|-c|echo $NODE"},
+		{"dir", []string{"pwd"}, func(c cdsexec.Commander) { c.SetDir("/tmp") }, "/tmp\n", 0, `sh|-c|cd "$1" && shift && exec "$@"|sh|/tmp|pwd`},
+		{"exit code", []string{"sh", "-c", "exit 3"}, nil, "", 3, "sh|-c|exit 3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = nil
+			cmd := ctor(context.Background(), tt.cmd[0], tt.cmd[1:]...)
+			if tt.setup != nil {
+				tt.setup(cmd)
+			}
+			out, err := cmd.Output()
+			if string(out) != tt.want {
+				t.Errorf("Expected output %q, got %q", tt.want, out)
+			}
+			if code := cdsexec.ExitCode(err); code != tt.wantCode {
+				t.Errorf("Expected exit code %d, got %d (%v)", tt.wantCode, code, err)
+			}
+			if len(seen) != 1 {
+				t.Fatalf("Expected one exec request, got %d", len(seen))
+			}
+			if got := strings.Join(seen[0].Command, "|"); got != tt.wantArgv {
+				t.Errorf("Expected command %q, got %q", tt.wantArgv, got)
+			}
+			if seen[0].Container != "driver" || !seen[0].Stdout || !seen[0].Stderr || seen[0].Stdin != (tt.name == "stdin") {
+				t.Errorf("Unexpected exec options %+v", seen[0])
+			}
+		})
+	}
+}
+
+func TestExecContextCancel(t *testing.T) {
+	var seen []*corev1.PodExecOptions
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := newCtor(&seen)(ctx, "sleep", "5").Run()
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, cdsexec.ErrTimedOut) || !errors.Is(err, cdsexec.ErrKilledByContext) {
+		t.Errorf("Expected deadline error, got %v", err)
+	}
+}
+
+func TestExecNotFound(t *testing.T) {
+	var seen []*corev1.PodExecOptions
+	err := newCtor(&seen)(context.Background(), "cdsexec-missing-tool").Run()
+	if !errors.Is(err, cdsexec.ErrNotFound) || cdsexec.ExitCode(err) != cdsexec.ExitCommandNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := kubecmd.New(&rest.Config{Host: "https://127.0.0.1:6443"}, kubecmd.Target{Namespace: "default", Pod: "p"}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package limitcmd
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// Limits bounds how many commands run at the same time.
+type Limits struct {
+	// Global bounds all commands together. Zero means unlimited.
+	Global int
+	// PerBinary bounds commands by the base name of their binary, e.g. {"multipath": 1}.
+	PerBinary map[string]int
+}
+
+// semaphore is a counting semaphore that can be acquired with a context.
+type semaphore chan struct{}
+
+func (s semaphore) acquire(ctx context.Context) error {
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s semaphore) release() {
+	<-s
+}
+
+// New returns a CommandConstructor that bounds how many commands built by base execute
+// concurrently. Commands block until a slot is free or their context is done. A command
+// started with Start holds its slot until Wait returns.
+func New(base cdsexec.CommandConstructor, l Limits) cdsexec.CommandConstructor {
+	var global semaphore
+	if l.Global > 0 {
+		global = make(semaphore, l.Global)
+	}
+	perBinary := make(map[string]semaphore, len(l.PerBinary))
+	for name, n := range l.PerBinary {
+		if n > 0 {
+			perBinary[name] = make(semaphore, n)
+		}
+	}
+
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		binary := filepath.Base(inv.Spec.Name)
+		if sem, ok := perBinary[binary]; ok {
+			if err := sem.acquire(inv.Ctx); err != nil {
+				return fmt.Errorf("limitcmd: waiting for a %s slot: %w", binary, err)
+			}
+			defer sem.release()
+		}
+		if global != nil {
+			if err := global.acquire(inv.Ctx); err != nil {
+				return fmt.Errorf("limitcmd: waiting for a slot: %w", err)
+			}
+			defer global.release()
+		}
+		return next(inv)
+	})
+}
@@ -0,0 +1,84 @@
+package limitcmd_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/limitcmd"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+// tracking returns a constructor recording the maximum number of concurrent executions.
+func tracking(running, peak *int32) cdsexec.CommandConstructor {
+	return mockcmd.MakeMockCmdWithOutput("", func(*mockcmd.MockCmd) error {
+		n := atomic.AddInt32(running, 1)
+		for {
+			p := atomic.LoadInt32(peak)
+			if n <= p || atomic.CompareAndSwapInt32(peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(running, -1)
+		return nil
+	})
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		limits   limitcmd.Limits
+		binary   string
+		wantPeak int32
+	}{
+		{"Global", limitcmd.Limits{Global: 2}, "qemu-img", 2},
+		{"Per Binary", limitcmd.Limits{Global: 4, PerBinary: map[string]int{"multipath": 1}}, "/sbin/multipath", 1},
+		{"Other Binary Uses Global", limitcmd.Limits{Global: 3, PerBinary: map[string]int{"multipath": 1}}, "lsblk", 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var running, peak int32
+			ctor := limitcmd.New(tracking(&running, &peak), tt.limits)
+
+			var wg sync.WaitGroup
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if err := ctor(context.Background(), tt.binary).Run(); err != nil {
+						t.Errorf("Unexpected error: %v", err)
+					}
+				}()
+			}
+			wg.Wait()
+			if peak != tt.wantPeak {
+				t.Errorf("Expected peak concurrency %d, got %d", tt.wantPeak, peak)
+			}
+		})
+	}
+}
+
+func TestLimitHonorsContext(t *testing.T) {
+	ctor := limitcmd.New(cdsexec.CommandContext, limitcmd.Limits{PerBinary: map[string]int{"sleep": 1}})
+
+	holder := ctor(context.Background(), "sleep", "1")
+	if err := holder.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer func() {
+		holder.Process().Kill()
+		holder.Wait()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := ctor(ctx, "sleep", "0").Run(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded while queued, got %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package logcmd
+
+import (
+	"log/slog"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// Option configures the logging wrapper.
+type Option func(*config)
+
+type config struct {
+	level       slog.Level
+	errorLevel  slog.Level
+	startLevel  *slog.Level
+	stderrLimit int
+	message     string
+}
+
+// WithLevel sets the level used for commands that succeed. The default is slog.LevelInfo.
+func WithLevel(level slog.Level) Option {
+	return func(c *config) { c.level = level }
+}
+
+// WithErrorLevel sets the level used for commands that fail. The default is slog.LevelError.
+func WithErrorLevel(level slog.Level) Option {
+	return func(c *config) { c.errorLevel = level }
+}
+
+// WithStartLevel additionally logs every command when it starts, at the given level.
+func WithStartLevel(level slog.Level) Option {
+	return func(c *config) { c.startLevel = &level }
+}
+
+// WithStderrLimit sets how many trailing bytes of stderr are logged. Zero disables stderr logging.
+// The default is 1024.
+func WithStderrLimit(n int) Option {
+	return func(c *config) { c.stderrLimit = n }
+}
+
+// WithMessage sets the log message for finished commands. The default is "command finished".
+func WithMessage(msg string) Option {
+	return func(c *config) { c.message = msg }
+}
+
+// New returns a CommandConstructor that logs every command built by base to logger,
+// including its command line, duration, exit code and the tail of its stderr.
+// Everything logged is redacted by the cdsexec.Redactor found in the command's context.
+func New(base cdsexec.CommandConstructor, logger *slog.Logger, opts ...Option) cdsexec.CommandConstructor {
+	cfg := config{
+		level:       slog.LevelInfo,
+		errorLevel:  slog.LevelError,
+		stderrLimit: 1024,
+		message:     "command finished",
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		var tail *cdsexec.RingBuffer
+		if cfg.stderrLimit > 0 {
+			tail = cdsexec.NewRingBuffer(cfg.stderrLimit)
+			if !inv.TeeStderr(tail) {
+				tail = nil
+			}
+		}
+		redactor := cdsexec.RedactorFromContext(inv.Ctx)
+		if cfg.startLevel != nil {
+			logger.LogAttrs(inv.Ctx, *cfg.startLevel, "command started",
+				slog.String("cmd", redactor.Spec(inv.Spec).String()),
+				slog.String("call", inv.Kind.String()),
+			)
+		}
+
+		clock := cdsexec.ClockFromContext(inv.Ctx)
+		begin := clock.Now()
+		err := next(inv)
+		res := inv.Result(err)
+
+		attrs := []slog.Attr{
+			slog.String("cmd", redactor.Spec(inv.Spec).String()),
+			slog.String("call", inv.Kind.String()),
+			slog.Duration("duration", clock.Now().Sub(begin)),
+			slog.Int("exit_code", res.ExitCode),
+		}
+		stderr := res.Stderr
+		if tail != nil {
+			stderr = tail.Bytes()
+		}
+		if cfg.stderrLimit > 0 && len(stderr) > 0 {
+			if len(stderr) > cfg.stderrLimit {
+				stderr = stderr[len(stderr)-cfg.stderrLimit:]
+			}
+			attrs = append(attrs, slog.String("stderr", redactor.Text(string(stderr))))
+		}
+		level := cfg.level
+		if err != nil {
+			level = cfg.errorLevel
+			attrs = append(attrs, slog.String("error", redactor.Error(err).Error()))
+		}
+		logger.LogAttrs(inv.Ctx, level, cfg.message, attrs...)
+		return err
+	})
+}
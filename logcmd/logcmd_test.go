@@ -0,0 +1,48 @@
+package logcmd_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/logcmd"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+func newLogger(buf *bytes.Buffer) *slog.Logger {
+	return slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+func TestLogSuccess(t *testing.T) {
+	var buf bytes.Buffer
+	ctor := logcmd.New(mockcmd.MakeMockCmdWithOutput("ok", nil), newLogger(&buf), logcmd.WithLevel(slog.LevelDebug))
+
+	if _, err := ctor(context.Background(), "ls", "-l", "my dir").Output(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	line := buf.String()
+	for _, want := range []string{"level=DEBUG", `msg="command finished"`, `cmd="ls -l 'my dir'"`, "exit_code=0", "duration="} {
+		if !strings.Contains(line, want) {
+			t.Errorf("Expected log to contain %s, got %s", want, line)
+		}
+	}
+}
+
+func TestLogFailureWithStderr(t *testing.T) {
+	var buf bytes.Buffer
+	ctor := logcmd.New(cdsexec.CommandContext, newLogger(&buf), logcmd.WithStderrLimit(5), logcmd.WithStartLevel(slog.LevelDebug))
+
+	err := ctor(context.Background(), "sh", "-c", "echo 0123456789 >&2; exit 2").Run()
+	if cdsexec.ExitCode(err) != 2 {
+		t.Fatalf("Expected exit code 2, got %v", err)
+	}
+	logs := buf.String()
+	for _, want := range []string{`msg="command started"`, "level=ERROR", "exit_code=2", `stderr="6789\n"`, `error="exit status 2"`} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("Expected log to contain %s, got %s", want, logs)
+		}
+	}
+}
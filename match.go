@@ -0,0 +1,57 @@
+package cdsexec
+
+import (
+	"path/filepath"
+	"regexp"
+)
+
+// Matcher reports whether a command matches some criteria.
+type Matcher func(CommandSpec) bool
+
+// MatchBinary matches commands whose binary has one of the given base names.
+func MatchBinary(names ...string) Matcher {
+	return func(s CommandSpec) bool {
+		base := filepath.Base(s.Name)
+		for _, n := range names {
+			if base == n {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// MatchCommand matches commands whose binary has the given base name and whose arguments
+// start with args.
+func MatchCommand(name string, args ...string) Matcher {
+	return func(s CommandSpec) bool {
+		if filepath.Base(s.Name) != name || len(s.Args) < len(args) {
+			return false
+		}
+		for i, a := range args {
+			if s.Args[i] != a {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// MatchPattern matches commands whose command line, as rendered by CommandSpec.String, matches re.
+func MatchPattern(re *regexp.Regexp) Matcher {
+	return func(s CommandSpec) bool {
+		return re.MatchString(s.String())
+	}
+}
+
+// MatchAny matches commands matched by at least one of ms.
+func MatchAny(ms ...Matcher) Matcher {
+	return func(s CommandSpec) bool {
+		for _, m := range ms {
+			if m(s) {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,33 @@
+package cdsexec_test
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+func TestMatchers(t *testing.T) {
+	spec := cdsexec.CommandSpec{Name: "/usr/sbin/multipath", Args: []string{"-r", "-v2"}}
+	tests := []struct {
+		name    string
+		matcher cdsexec.Matcher
+		want    bool
+	}{
+		{"Binary", cdsexec.MatchBinary("lsblk", "multipath"), true},
+		{"Other Binary", cdsexec.MatchBinary("lsblk"), false},
+		{"Command Prefix", cdsexec.MatchCommand("multipath", "-r"), true},
+		{"Command Mismatch", cdsexec.MatchCommand("multipath", "-ll"), false},
+		{"Too Many Args", cdsexec.MatchCommand("multipath", "-r", "-v2", "-d"), false},
+		{"Pattern", cdsexec.MatchPattern(regexp.MustCompile(`multipath -r\b`)), true},
+		{"Any", cdsexec.MatchAny(cdsexec.MatchBinary("lsblk"), cdsexec.MatchCommand("multipath")), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.matcher(spec); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
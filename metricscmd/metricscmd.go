@@ -0,0 +1,185 @@
+package metricscmd
+
+import (
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Exit classes used as the "exit_class" label.
+const (
+	ExitSuccess = "success"
+	ExitNonZero = "nonzero"
+	ExitSignal  = "signal"
+	ExitError   = "error"
+)
+
+// Option configures the metrics wrapper.
+type Option func(*config)
+
+type config struct {
+	namespace   string
+	buckets     []float64
+	constLabels prometheus.Labels
+}
+
+// WithNamespace sets the metric namespace. The default is "cdsexec".
+func WithNamespace(ns string) Option {
+	return func(c *config) { c.namespace = ns }
+}
+
+// WithBuckets sets the buckets of the duration histogram. The default is prometheus.DefBuckets.
+func WithBuckets(buckets []float64) Option {
+	return func(c *config) { c.buckets = buckets }
+}
+
+// WithConstLabels adds constant labels to every metric.
+func WithConstLabels(labels prometheus.Labels) Option {
+	return func(c *config) { c.constLabels = labels }
+}
+
+// Metrics holds the collectors shared by every constructor it wraps.
+type Metrics struct {
+	executions  *prometheus.CounterVec
+	duration    *prometheus.HistogramVec
+	phases      *prometheus.HistogramVec
+	outputBytes *prometheus.CounterVec
+	inFlight    *prometheus.GaugeVec
+}
+
+// NewMetrics creates the collectors and registers them on reg.
+func NewMetrics(reg prometheus.Registerer, opts ...Option) (*Metrics, error) {
+	cfg := config{namespace: "cdsexec", buckets: prometheus.DefBuckets}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	m := &Metrics{
+		executions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   cfg.namespace,
+			Name:        "commands_total",
+			Help:        "Number of executed commands by binary and exit class.",
+			ConstLabels: cfg.constLabels,
+		}, []string{"binary", "exit_class"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   cfg.namespace,
+			Name:        "command_duration_seconds",
+			Help:        "Wall-clock duration of executed commands.",
+			Buckets:     cfg.buckets,
+			ConstLabels: cfg.constLabels,
+		}, []string{"binary"}),
+		phases: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   cfg.namespace,
+			Name:        "command_phase_seconds",
+			Help:        "Duration of the phases of executed commands: construct (including PATH lookup), spawn and first_output.",
+			Buckets:     cfg.buckets,
+			ConstLabels: cfg.constLabels,
+		}, []string{"binary", "phase"}),
+		outputBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   cfg.namespace,
+			Name:        "command_output_bytes_total",
+			Help:        "Bytes written by executed commands by stream.",
+			ConstLabels: cfg.constLabels,
+		}, []string{"binary", "stream"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   cfg.namespace,
+			Name:        "commands_in_flight",
+			Help:        "Number of commands currently executing.",
+			ConstLabels: cfg.constLabels,
+		}, []string{"binary"}),
+	}
+	for _, c := range []prometheus.Collector{m.executions, m.duration, m.phases, m.outputBytes, m.inFlight} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Wrap returns a CommandConstructor that records metrics for every command built by base.
+func (m *Metrics) Wrap(base cdsexec.CommandConstructor) cdsexec.CommandConstructor {
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		binary := filepath.Base(inv.Spec.Name)
+		stdout, stderr := &countingWriter{}, &countingWriter{}
+		teeOut := inv.TeeStdout(stdout)
+		teeErr := inv.TeeStderr(stderr)
+
+		inFlight := m.inFlight.WithLabelValues(binary)
+		inFlight.Inc()
+		err := next(inv)
+		inFlight.Dec()
+		res := inv.Result(err)
+
+		m.executions.WithLabelValues(binary, exitClass(err)).Inc()
+		if !res.StartTime.IsZero() {
+			m.duration.WithLabelValues(binary).Observe(res.Duration.Seconds())
+		}
+		m.observePhases(binary, res.Phases)
+		outBytes, errBytes := float64(len(res.Stdout)), float64(len(res.Stderr))
+		if teeOut {
+			outBytes = float64(stdout.n)
+		}
+		if teeErr {
+			errBytes = float64(stderr.n)
+		}
+		m.outputBytes.WithLabelValues(binary, "stdout").Add(outBytes)
+		m.outputBytes.WithLabelValues(binary, "stderr").Add(errBytes)
+		return err
+	})
+}
+
+// observePhases records the phases that were measured.
+func (m *Metrics) observePhases(binary string, p cdsexec.Phases) {
+	for _, phase := range []struct {
+		name string
+		d    time.Duration
+	}{{"construct", p.Construct}, {"spawn", p.Spawn}, {"first_output", p.FirstOutput}} {
+		if phase.d > 0 {
+			m.phases.WithLabelValues(binary, phase.name).Observe(phase.d.Seconds())
+		}
+	}
+}
+
+// New is a shorthand for NewMetrics followed by Wrap.
+func New(base cdsexec.CommandConstructor, reg prometheus.Registerer, opts ...Option) (cdsexec.CommandConstructor, error) {
+	m, err := NewMetrics(reg, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return m.Wrap(base), nil
+}
+
+// exitClass classifies the outcome of a command for the "exit_class" label.
+func exitClass(err error) string {
+	if err == nil {
+		return ExitSuccess
+	}
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		if ee.ExitCode() == -1 {
+			return ExitSignal
+		}
+		return ExitNonZero
+	}
+	var xe *cdsexec.ExitError
+	if errors.As(err, &xe) {
+		if xe.Signal != "" {
+			return ExitSignal
+		}
+		return ExitNonZero
+	}
+	return ExitError
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
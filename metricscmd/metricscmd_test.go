@@ -0,0 +1,79 @@
+package metricscmd_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/metricscmd"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := metricscmd.NewMetrics(reg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	ctor := m.Wrap(cdsexec.CommandContext)
+	if err := ctor(context.Background(), "/bin/sh", "-c", "printf abc; printf de >&2").Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := ctor(context.Background(), "sh", "-c", "exit 1").Run(); err == nil {
+		t.Fatalf("Expected an error")
+	}
+	mock := m.Wrap(mockcmd.MakeMockCmdWithOutputSpecificError("", errors.New("boom"), nil))
+	mock(context.Background(), "sh").Run()
+
+	expected := `
+# HELP cdsexec_command_output_bytes_total Bytes written by executed commands by stream.
+# TYPE cdsexec_command_output_bytes_total counter
+cdsexec_command_output_bytes_total{binary="sh",stream="stderr"} 2
+cdsexec_command_output_bytes_total{binary="sh",stream="stdout"} 3
+# HELP cdsexec_commands_total Number of executed commands by binary and exit class.
+# TYPE cdsexec_commands_total counter
+cdsexec_commands_total{binary="sh",exit_class="error"} 1
+cdsexec_commands_total{binary="sh",exit_class="nonzero"} 1
+cdsexec_commands_total{binary="sh",exit_class="success"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"cdsexec_commands_total", "cdsexec_command_output_bytes_total"); err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(reg, "cdsexec_command_duration_seconds"); n != 1 {
+		t.Errorf("Expected one duration series, got %d", n)
+	}
+	if n := testutil.CollectAndCount(reg, "cdsexec_command_phase_seconds"); n != 3 {
+		t.Errorf("Expected construct, spawn and first_output series, got %d", n)
+	}
+
+	if _, err := metricscmd.NewMetrics(reg); err == nil {
+		t.Errorf("Expected duplicate registration to fail")
+	}
+}
+
+func TestOutputBytesFromOutput(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	ctor, err := metricscmd.New(cdsexec.CommandContext, reg, metricscmd.WithNamespace("agent"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := ctor(context.Background(), "sh", "-c", "printf abcd").Output(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := `
+# HELP agent_command_output_bytes_total Bytes written by executed commands by stream.
+# TYPE agent_command_output_bytes_total counter
+agent_command_output_bytes_total{binary="sh",stream="stderr"} 0
+agent_command_output_bytes_total{binary="sh",stream="stdout"} 4
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "agent_command_output_bytes_total"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,615 @@
+package cdsexec
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// ErrNotReplayable is returned when an interceptor tries to execute a command a second time
+// although its pipes are already attached or it was started with Start.
+var ErrNotReplayable = errors.New("cdsexec: command cannot be executed more than once")
+
+var (
+	errAlreadyStarted = errors.New("exec: already started")
+	errNotStarted     = errors.New("exec: not started")
+	errWaitCalled     = errors.New("exec: Wait was already called")
+)
+
+// CallKind identifies the Commander method that triggered an execution.
+type CallKind int
+
+const (
+	CallRun CallKind = iota
+	CallOutput
+	CallCombinedOutput
+	// CallStart covers a Start call together with the matching Wait.
+	CallStart
+)
+
+// String returns the name of the Commander method.
+func (k CallKind) String() string {
+	switch k {
+	case CallRun:
+		return "Run"
+	case CallOutput:
+		return "Output"
+	case CallCombinedOutput:
+		return "CombinedOutput"
+	case CallStart:
+		return "Start"
+	}
+	return "Unknown"
+}
+
+// Handler executes the command described by an Invocation.
+type Handler func(inv *Invocation) error
+
+// Interceptor wraps the execution of a single command. It may inspect or modify the
+// invocation, call next zero or more times, and inspect or replace the outcome.
+// For CallStart invocations next must be called at most once.
+type Interceptor func(inv *Invocation, next Handler) error
+
+// Invocation carries one execution through an Interceptor.
+type Invocation struct {
+	// Ctx is the context used to construct the underlying command.
+	Ctx context.Context
+	// Spec is the command that will be constructed. Interceptors may change it before calling next.
+	Spec CommandSpec
+	// Kind is the Commander method that triggered the execution.
+	Kind CallKind
+	// Output holds what Output or CombinedOutput returns to the caller.
+	Output []byte
+	// StartTime and Duration are set by the innermost handler around the actual execution.
+	StartTime time.Time
+	Duration  time.Duration
+	// Phases is set by the innermost handler as far as it can measure the execution.
+	Phases Phases
+
+	w *wrappedCmd
+}
+
+// Commander returns the underlying command once it has been constructed, or nil.
+func (inv *Invocation) Commander() Commander {
+	inv.w.mu.Lock()
+	defer inv.w.mu.Unlock()
+	return inv.w.cmd
+}
+
+// Replayable reports whether next may be called again after it has already run.
+func (inv *Invocation) Replayable() bool {
+	return inv.Kind != CallStart && !inv.w.hasPipes()
+}
+
+// TeeStdout arranges for the command's stdout to also be written to wr. It reports false
+// when stdout cannot be observed this way, e.g. because the caller requested StdoutPipe or
+// the output is returned by Output or CombinedOutput.
+func (inv *Invocation) TeeStdout(wr io.Writer) bool {
+	if (inv.Kind != CallRun && inv.Kind != CallStart) || inv.w.stdout != nil {
+		return false
+	}
+	inv.shareOutput()
+	inv.Spec.Stdout = teeWriter(inv.Spec.Stdout, wr)
+	return true
+}
+
+// TeeStderr arranges for the command's stderr to also be written to wr. It reports false
+// when stderr cannot be observed this way; Result still recovers stderr from an
+// *exec.ExitError or *ExitError returned by Output.
+func (inv *Invocation) TeeStderr(wr io.Writer) bool {
+	if (inv.Kind != CallRun && inv.Kind != CallStart) || inv.w.stderr != nil {
+		return false
+	}
+	inv.shareOutput()
+	inv.Spec.Stderr = teeWriter(inv.Spec.Stderr, wr)
+	return true
+}
+
+// shareOutput serializes the writes to the writer shared by stdout and stderr, if any.
+// exec.Cmd copies a shared writer from a single pipe, but once a tee makes the streams
+// differ, it copies each of them from its own goroutine.
+func (inv *Invocation) shareOutput() {
+	if inv.Spec.Stdout == nil || !sameWriter(inv.Spec.Stdout, inv.Spec.Stderr) {
+		return
+	}
+	if _, ok := inv.Spec.Stdout.(*lockedWriter); ok {
+		return
+	}
+	shared := &lockedWriter{w: inv.Spec.Stdout}
+	inv.Spec.Stdout, inv.Spec.Stderr = shared, shared
+}
+
+// Result summarises the invocation after next has returned err.
+func (inv *Invocation) Result(err error) Result {
+	var state *os.ProcessState
+	if cmd := inv.Commander(); cmd != nil {
+		state = cmd.ProcessState()
+	}
+	r := Result{
+		Name:      inv.Spec.Name,
+		Args:      inv.Spec.Args,
+		Dir:       inv.Spec.Dir,
+		Env:       inv.Spec.Env,
+		ExitCode:  exitCodeOf(state, err),
+		StartTime: inv.StartTime,
+		Duration:  inv.Duration,
+		Phases:    inv.Phases,
+	}
+	if inv.Kind == CallOutput || inv.Kind == CallCombinedOutput {
+		r.Stdout = inv.Output
+	}
+	var ee *exec.ExitError
+	var xe *ExitError
+	if errors.As(err, &ee) {
+		r.Stderr = ee.Stderr
+	} else if errors.As(err, &xe) {
+		r.Stderr = xe.Stderr
+	}
+	return r
+}
+
+// lockedWriter serializes the writes of the standard output and error of a command.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+func teeWriter(dst, wr io.Writer) io.Writer {
+	if dst == nil {
+		return wr
+	}
+	return io.MultiWriter(dst, wr)
+}
+
+// Wrap returns a CommandConstructor whose commands route every execution through fn.
+// The command built by base is only constructed when it is executed, so fn sees and may
+// change the final spec.
+func Wrap(base CommandConstructor, fn Interceptor) CommandConstructor {
+	return func(ctx context.Context, name string, arg ...string) Commander {
+		w := &wrappedCmd{next: base, fn: fn}
+		w.inv = Invocation{
+			Ctx:  ctx,
+			Spec: CommandSpec{Name: name, Args: arg},
+			w:    w,
+		}
+		return w
+	}
+}
+
+// Chain is like nesting Wrap calls with fns[0] outermost, i.e.
+// Wrap(Wrap(base, fns[1]), fns[0]) for two interceptors, for the cost of a single layer:
+// the interceptors share one Invocation and the changes an interceptor makes to its Spec
+// and Ctx are undone when its next returns, as they would be with separate layers.
+func Chain(base CommandConstructor, fns ...Interceptor) CommandConstructor {
+	if len(fns) == 0 {
+		return base
+	}
+	return Wrap(base, func(inv *Invocation, next Handler) error {
+		return chainFrom(fns, inv, next)
+	})
+}
+
+// chainFrom calls the first of fns with a next calling the rest of them, and eventually
+// the next of the chain.
+func chainFrom(fns []Interceptor, inv *Invocation, next Handler) error {
+	if len(fns) == 0 {
+		return next(inv)
+	}
+	return fns[0](inv, func(inv *Invocation) error {
+		spec, ctx := inv.Spec, inv.Ctx
+		err := chainFrom(fns[1:], inv, next)
+		inv.Spec, inv.Ctx = spec, ctx
+		return err
+	})
+}
+
+var _ Commander = (*wrappedCmd)(nil)
+
+// wrappedCmd is the Commander returned by Wrap.
+type wrappedCmd struct {
+	next CommandConstructor
+	fn   Interceptor
+	inv  Invocation
+
+	mu    sync.Mutex
+	cmd   Commander
+	execs int
+
+	stdin, stdout, stderr *lazyPipe
+
+	executed    bool
+	startFailed bool
+	waitCalled  bool
+	finished    bool
+	finishErr   error
+	started     chan error
+	waitReq     chan struct{}
+	done        chan error
+}
+
+func (w *wrappedCmd) hasPipes() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.stdin != nil || w.stdout != nil || w.stderr != nil
+}
+
+// materialize constructs the underlying command for the current spec and connects the pipes.
+func (w *wrappedCmd) materialize(inv *Invocation) (Commander, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	pipes := w.stdin != nil || w.stdout != nil || w.stderr != nil
+	if w.execs > 0 {
+		if inv.Kind == CallStart || pipes {
+			return nil, ErrNotReplayable
+		}
+		if s, ok := inv.Spec.Stdin.(io.Seeker); ok {
+			if _, err := s.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+		}
+	}
+	w.execs++
+
+	clock := ClockFromContext(inv.Ctx)
+	start := clock.Now()
+	cmd := w.next(inv.Ctx, inv.Spec.Name, inv.Spec.Args...)
+	inv.Phases = Phases{}
+	if _, nested := cmd.(*wrappedCmd); !nested {
+		inv.Phases.Construct = clock.Now().Sub(start)
+	}
+	inv.Spec.applyTo(cmd)
+	if w.stdin != nil {
+		p, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		w.stdin.connect(p)
+	}
+	if w.stdout != nil {
+		p, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		w.stdout.connect(p)
+	}
+	if w.stderr != nil {
+		p, err := cmd.StderrPipe()
+		if err != nil {
+			return nil, err
+		}
+		w.stderr.connect(p)
+	}
+	w.cmd = cmd
+	return cmd, nil
+}
+
+// terminal is the innermost Handler, executing the underlying command. Its errors are
+// classified, so that interceptors match them with the error values of the package whatever
+// the backend.
+func (w *wrappedCmd) terminal(inv *Invocation) error {
+	return Classify(inv.Ctx, w.call(inv))
+}
+
+// call executes the underlying command for terminal.
+func (w *wrappedCmd) call(inv *Invocation) error {
+	cmd, err := w.materialize(inv)
+	if err != nil {
+		if inv.Kind == CallStart {
+			w.started <- err
+		}
+		return err
+	}
+	if inner, ok := cmd.(*wrappedCmd); ok {
+		// The inner layer measures the execution more closely than this one could.
+		defer func() {
+			inv.StartTime, inv.Duration, inv.Phases = inner.inv.StartTime, inner.inv.Duration, inner.inv.Phases
+		}()
+	} else {
+		clock := ClockFromContext(inv.Ctx)
+		inv.StartTime = clock.Now()
+		defer func() { inv.Duration = clock.Now().Sub(inv.StartTime) }()
+	}
+
+	if c, ok := cmd.(*Cmd); ok {
+		return w.runLocal(inv, c)
+	}
+	switch inv.Kind {
+	case CallOutput:
+		inv.Output, err = cmd.Output()
+		return err
+	case CallCombinedOutput:
+		inv.Output, err = cmd.CombinedOutput()
+		return err
+	case CallStart:
+		clock := ClockFromContext(inv.Ctx)
+		start := clock.Now()
+		err = cmd.Start()
+		inv.Phases.Spawn = clock.Now().Sub(start)
+		w.started <- err
+		if err != nil {
+			return err
+		}
+		<-w.waitReq
+		return cmd.Wait()
+	default:
+		return cmd.Run()
+	}
+}
+
+func (w *wrappedCmd) execute(kind CallKind) error {
+	if w.executed {
+		return errAlreadyStarted
+	}
+	w.executed = true
+	w.inv.Kind = kind
+	err := w.fn(&w.inv, w.terminal)
+	w.closePipes(err)
+	return err
+}
+
+// closePipes fails any pipe that was never connected to an underlying command.
+func (w *wrappedCmd) closePipes(err error) {
+	if err == nil {
+		err = io.EOF
+	}
+	for _, p := range []*lazyPipe{w.stdin, w.stdout, w.stderr} {
+		if p != nil {
+			p.fail(err)
+		}
+	}
+}
+
+// Run executes the command and waits for it to complete.
+func (w *wrappedCmd) Run() error {
+	return w.execute(CallRun)
+}
+
+// Output executes the command and returns its standard output.
+func (w *wrappedCmd) Output() ([]byte, error) {
+	err := w.execute(CallOutput)
+	return w.inv.Output, err
+}
+
+// CombinedOutput executes the command and returns its combined standard output and error.
+func (w *wrappedCmd) CombinedOutput() ([]byte, error) {
+	err := w.execute(CallCombinedOutput)
+	return w.inv.Output, err
+}
+
+// Start starts the command. If the interceptor completes without starting a process,
+// Start returns its result and Wait returns immediately.
+func (w *wrappedCmd) Start() error {
+	if w.executed {
+		return errAlreadyStarted
+	}
+	w.executed = true
+	w.inv.Kind = CallStart
+	w.started = make(chan error, 1)
+	w.waitReq = make(chan struct{})
+	w.done = make(chan error, 1)
+	go func() {
+		err := w.fn(&w.inv, w.terminal)
+		w.closePipes(err)
+		w.done <- err
+	}()
+
+	select {
+	case err := <-w.started:
+		if err != nil {
+			w.startFailed = true
+			return <-w.done
+		}
+		return nil
+	case err := <-w.done:
+		w.finished = true
+		w.finishErr = err
+		if err != nil {
+			w.startFailed = true
+		}
+		return err
+	}
+}
+
+// Wait waits for a command started with Start to complete.
+func (w *wrappedCmd) Wait() error {
+	if w.done == nil || w.startFailed {
+		return errNotStarted
+	}
+	if w.waitCalled {
+		return errWaitCalled
+	}
+	w.waitCalled = true
+	if w.finished {
+		return w.finishErr
+	}
+	close(w.waitReq)
+	return <-w.done
+}
+
+// StdinPipe returns a pipe connected to the command's stdin once it is constructed.
+func (w *wrappedCmd) StdinPipe() (io.WriteCloser, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.executed {
+		return nil, errors.New("exec: StdinPipe after process started")
+	}
+	if w.stdin != nil || w.inv.Spec.Stdin != nil {
+		return nil, errors.New("exec: Stdin already set")
+	}
+	w.stdin = newLazyPipe()
+	return w.stdin, nil
+}
+
+// StdoutPipe returns a pipe connected to the command's stdout once it is constructed.
+func (w *wrappedCmd) StdoutPipe() (io.ReadCloser, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.executed {
+		return nil, errors.New("exec: StdoutPipe after process started")
+	}
+	if w.stdout != nil || w.inv.Spec.Stdout != nil {
+		return nil, errors.New("exec: Stdout already set")
+	}
+	w.stdout = newLazyPipe()
+	return w.stdout, nil
+}
+
+// StderrPipe returns a pipe connected to the command's stderr once it is constructed.
+func (w *wrappedCmd) StderrPipe() (io.ReadCloser, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.executed {
+		return nil, errors.New("exec: StderrPipe after process started")
+	}
+	if w.stderr != nil || w.inv.Spec.Stderr != nil {
+		return nil, errors.New("exec: Stderr already set")
+	}
+	w.stderr = newLazyPipe()
+	return w.stderr, nil
+}
+
+// SetDir sets the working directory of the command.
+func (w *wrappedCmd) SetDir(dir string) {
+	w.inv.Spec.Dir = dir
+}
+
+// SetEnv sets the environment variables for the command.
+func (w *wrappedCmd) SetEnv(env []string) {
+	w.inv.Spec.Env = env
+}
+
+// SetStdin sets the standard input for the command.
+func (w *wrappedCmd) SetStdin(in io.Reader) {
+	w.inv.Spec.Stdin = in
+}
+
+// SetStdout sets the standard output for the command.
+func (w *wrappedCmd) SetStdout(out io.Writer) {
+	w.inv.Spec.Stdout = out
+}
+
+// SetStderr sets the standard error for the command.
+func (w *wrappedCmd) SetStderr(out io.Writer) {
+	w.inv.Spec.Stderr = out
+}
+
+// Process returns the process of the underlying command, or nil before it is constructed.
+func (w *wrappedCmd) Process() *os.Process {
+	if cmd := w.inv.Commander(); cmd != nil {
+		return cmd.Process()
+	}
+	return nil
+}
+
+// ProcessState returns the process state of the underlying command, or nil before it exits.
+func (w *wrappedCmd) ProcessState() *os.ProcessState {
+	if cmd := w.inv.Commander(); cmd != nil {
+		return cmd.ProcessState()
+	}
+	return nil
+}
+
+// String returns the command line of the command, redacted by the Redactor in its context.
+func (w *wrappedCmd) String() string {
+	return RedactorFromContext(w.inv.Ctx).Spec(w.inv.Spec).String()
+}
+
+// lazyPipe is a pipe end handed out before the underlying command exists. Reads, writes
+// and closes block until it is connected to the real pipe or failed.
+type lazyPipe struct {
+	mu     sync.Mutex
+	ready  chan struct{}
+	done   bool
+	closed bool
+	err    error
+	rw     io.Closer
+}
+
+func newLazyPipe() *lazyPipe {
+	return &lazyPipe{ready: make(chan struct{})}
+}
+
+func (p *lazyPipe) connect(rw io.Closer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.done {
+		return
+	}
+	p.done = true
+	p.rw = rw
+	if p.closed {
+		rw.Close()
+	}
+	close(p.ready)
+}
+
+func (p *lazyPipe) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.done {
+		return
+	}
+	p.done = true
+	p.err = err
+	close(p.ready)
+}
+
+func (p *lazyPipe) Read(b []byte) (int, error) {
+	<-p.ready
+	if p.err != nil {
+		return 0, p.err
+	}
+	return p.rw.(io.Reader).Read(b)
+}
+
+func (p *lazyPipe) Write(b []byte) (int, error) {
+	<-p.ready
+	if p.err != nil {
+		return 0, p.err
+	}
+	return p.rw.(io.Writer).Write(b)
+}
+
+// WriteTo copies the output of the command to w with Copy, so that a pipe of a local
+// process is spliced into a file or socket instead of being read through a buffer.
+func (p *lazyPipe) WriteTo(w io.Writer) (int64, error) {
+	<-p.ready
+	if p.err != nil {
+		return 0, p.err
+	}
+	return Copy(w, p.rw.(io.Reader))
+}
+
+// ReadFrom copies r to the input of the command with Copy.
+func (p *lazyPipe) ReadFrom(r io.Reader) (int64, error) {
+	<-p.ready
+	if p.err != nil {
+		return 0, p.err
+	}
+	return Copy(p.rw.(io.Writer), r)
+}
+
+// Close closes the pipe, or marks it to be closed as soon as it is connected.
+func (p *lazyPipe) Close() error {
+	p.mu.Lock()
+	if !p.done {
+		p.closed = true
+		p.mu.Unlock()
+		return nil
+	}
+	p.mu.Unlock()
+	if p.err != nil {
+		return nil
+	}
+	return p.rw.Close()
+}
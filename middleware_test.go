@@ -0,0 +1,304 @@
+package cdsexec_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+func passThrough(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+	return next(inv)
+}
+
+func TestWrapRewritesSpec(t *testing.T) {
+	var seen *mockcmd.MockCmd
+	base := mockcmd.MakeMockCmdWithOutput("ok", func(m *mockcmd.MockCmd) error {
+		seen = m
+		return nil
+	})
+	ctor := cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		inv.Spec.Name = "sudo"
+		inv.Spec.Args = append([]string{"-n", "ls"}, inv.Spec.Args...)
+		inv.Spec.Dir = "/tmp"
+		return next(inv)
+	})
+
+	cmd := ctor(context.Background(), "ls", "-l")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(out) != "ok" {
+		t.Errorf("Expected output %q, got %q", "ok", out)
+	}
+	if seen.Name != "sudo" || strings.Join(seen.Args, " ") != "-n ls -l" || seen.Dir != "/tmp" {
+		t.Errorf("Unexpected command %s %v in %q", seen.Name, seen.Args, seen.Dir)
+	}
+}
+
+func TestWrapVeto(t *testing.T) {
+	errDenied := errors.New("denied")
+	ctor := cdsexec.Wrap(cdsexec.CommandContext, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		return errDenied
+	})
+
+	cmd := ctor(context.Background(), "true")
+	if err := cmd.Run(); !errors.Is(err, errDenied) {
+		t.Fatalf("Expected errDenied, got %v", err)
+	}
+	if err := cmd.Run(); err == nil {
+		t.Errorf("Expected error when running twice")
+	}
+
+	cmd = ctor(context.Background(), "true")
+	if err := cmd.Start(); !errors.Is(err, errDenied) {
+		t.Fatalf("Expected errDenied from Start, got %v", err)
+	}
+	if err := cmd.Wait(); err == nil {
+		t.Errorf("Expected error from Wait after failed Start")
+	}
+}
+
+func TestWrapStartWaitWithPipes(t *testing.T) {
+	var kinds []cdsexec.CallKind
+	ctor := cdsexec.Wrap(cdsexec.CommandContext, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		kinds = append(kinds, inv.Kind)
+		return next(inv)
+	})
+
+	cmd := ctor(context.Background(), "cat")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatalf("StdinPipe: %v", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("StdoutPipe: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if cmd.Process() == nil {
+		t.Errorf("Expected a process after Start")
+	}
+	io.WriteString(stdin, "hello")
+	stdin.Close()
+	out, err := io.ReadAll(stdout)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if string(out) != "hello" {
+		t.Errorf("Expected %q, got %q", "hello", out)
+	}
+	if cmd.ProcessState() == nil || !cmd.ProcessState().Success() {
+		t.Errorf("Expected a successful process state")
+	}
+	if len(kinds) != 1 || kinds[0] != cdsexec.CallStart {
+		t.Errorf("Expected a single Start invocation, got %v", kinds)
+	}
+}
+
+func TestWrapResult(t *testing.T) {
+	var res cdsexec.Result
+	ctor := cdsexec.Wrap(cdsexec.CommandContext, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		err := next(inv)
+		res = inv.Result(err)
+		return err
+	})
+
+	_, err := ctor(context.Background(), "sh", "-c", "echo out; echo oops >&2; exit 3").Output()
+	if cdsexec.ExitCode(err) != 3 {
+		t.Fatalf("Expected exit code 3, got %v", err)
+	}
+	if res.ExitCode != 3 {
+		t.Errorf("Expected result exit code 3, got %d", res.ExitCode)
+	}
+	if string(res.Stdout) != "out\n" || string(res.Stderr) != "oops\n" {
+		t.Errorf("Unexpected result output %q / %q", res.Stdout, res.Stderr)
+	}
+	if res.Duration <= 0 || res.StartTime.IsZero() {
+		t.Errorf("Expected timing to be recorded")
+	}
+}
+
+func TestWrapResultClock(t *testing.T) {
+	clock := mockcmd.NewFakeClock(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	ctx := cdsexec.ContextWithClock(context.Background(), clock)
+	var res cdsexec.Result
+	base := mockcmd.MakeMockCmdWithOutput("", func(*mockcmd.MockCmd) error {
+		clock.Advance(time.Second)
+		return nil
+	})
+	ctor := cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		err := next(inv)
+		res = inv.Result(err)
+		return err
+	})
+	if err := ctor(ctx, "zpool", "scrub", "tank").Run(); err != nil {
+		t.Fatal(err)
+	}
+	if !res.StartTime.Equal(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)) || res.Duration != time.Second {
+		t.Errorf("Expected the result to be timed on the clock of the context, got %v for %s", res.StartTime, res.Duration)
+	}
+}
+
+// overlapWriter records whether two writes to it ever overlapped.
+type overlapWriter struct {
+	writing, overlapped atomic.Bool
+	n                   atomic.Int64
+}
+
+func (w *overlapWriter) Write(p []byte) (int, error) {
+	if w.writing.Swap(true) {
+		w.overlapped.Store(true)
+	}
+	time.Sleep(100 * time.Microsecond)
+	w.n.Add(int64(len(p)))
+	w.writing.Store(false)
+	return len(p), nil
+}
+
+func TestTeeSharedWriter(t *testing.T) {
+	ctor := cdsexec.Wrap(cdsexec.CommandContext, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		inv.TeeStdout(io.Discard)
+		inv.TeeStderr(io.Discard)
+		return next(inv)
+	})
+	var w overlapWriter
+	cmd := ctor(context.Background(), "sh", "-c", "for i in $(seq 200); do echo out; echo err >&2; done")
+	cmd.SetStdout(&w)
+	cmd.SetStderr(&w)
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if w.overlapped.Load() {
+		t.Errorf("Expected the writes to the shared writer to be serialized")
+	}
+	if got := w.n.Load(); got != 200*8 {
+		t.Errorf("Expected %d bytes, got %d", 200*8, got)
+	}
+}
+
+func TestWrapRetry(t *testing.T) {
+	attempts := 0
+	base := func(ctx context.Context, name string, arg ...string) cdsexec.Commander {
+		attempts++
+		if attempts < 3 {
+			return mockcmd.MakeMockCmdWithOutputGenericError(nil)(ctx, name, arg...)
+		}
+		return mockcmd.MakeMockCmdWithOutput("done", nil)(ctx, name, arg...)
+	}
+	ctor := cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		var err error
+		for i := 0; i < 3 && inv.Replayable(); i++ {
+			if err = next(inv); err == nil {
+				break
+			}
+		}
+		return err
+	})
+
+	out, err := ctor(context.Background(), "flaky").Output()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(out) != "done" || attempts != 3 {
+		t.Errorf("Expected %q after 3 attempts, got %q after %d", "done", out, attempts)
+	}
+}
+
+func TestCommandSpecString(t *testing.T) {
+	spec := cdsexec.CommandSpec{Name: "sh", Args: []string{"-c", "echo 'hi'", "", "a=b"}}
+	want := `sh -c 'echo '\''hi'\''' '' a=b`
+	if got := spec.String(); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if got := (cdsexec.CommandSpec{Name: "A=b", Args: []string{"c=d"}}).String(); got != "'A=b' c=d" {
+		t.Errorf("Expected a name holding '=' to be quoted, got %s", got)
+	}
+	if got := cdsexec.Wrap(cdsexec.CommandContext, passThrough)(context.Background(), "ls", "a b").(interface{ String() string }).String(); got != "ls 'a b'" {
+		t.Errorf("Unexpected String(): %s", got)
+	}
+}
+
+func TestCommandSpecShellLine(t *testing.T) {
+	t.Setenv("CDSEXEC_KEEP", "1")
+	t.Setenv("CDSEXEC_CHANGE", "old")
+	t.Setenv("CDSEXEC_DROP", "1")
+	var env []string
+	for _, kv := range os.Environ() {
+		if kv != "CDSEXEC_DROP=1" && kv != "CDSEXEC_CHANGE=old" {
+			env = append(env, kv)
+		}
+	}
+	spec := cdsexec.CommandSpec{
+		Name: "zpool",
+		Args: []string{"import", "my pool"},
+		Dir:  "/var/lib/cds",
+		Env:  append(env, "CDSEXEC_CHANGE=new value"),
+	}
+	want := `env -u CDSEXEC_DROP CDSEXEC_CHANGE='new value' zpool import 'my pool' # cwd: /var/lib/cds`
+	if got := spec.ShellLine(); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if got := (cdsexec.CommandSpec{Name: "ls"}).ShellLine(); got != "ls" {
+		t.Errorf("Expected the inherited environment to be omitted, got %s", got)
+	}
+	spec = cdsexec.CommandSpec{Name: "ls", Dir: "/tmp/x\nreboot", Env: append(env, "CDSEXEC_CHANGE=old", "CDSEXEC_DROP=1", "A;B=1")}
+	want = `env 'A;B=1' ls # cwd: '/tmp/x\nreboot'`
+	if got := spec.ShellLine(); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestChain(t *testing.T) {
+	var trace []string
+	sudo := func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		inv.Spec.Args = append([]string{"-n", inv.Spec.Name}, inv.Spec.Args...)
+		inv.Spec.Name = "sudo"
+		return next(inv)
+	}
+	retry := func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		err := next(inv)
+		trace = append(trace, "after "+inv.Spec.String())
+		if err != nil && inv.Replayable() {
+			err = next(inv)
+		}
+		return err
+	}
+	attempts := 0
+	base := func(ctx context.Context, name string, arg ...string) cdsexec.Commander {
+		attempts++
+		trace = append(trace, cdsexec.CommandSpec{Name: name, Args: arg}.String())
+		if attempts == 1 {
+			return mockcmd.MakeMockCmdWithOutputGenericError(nil)(ctx, name, arg...)
+		}
+		return mockcmd.MakeMockCmdWithOutput("ok", nil)(ctx, name, arg...)
+	}
+
+	for _, ctor := range []cdsexec.CommandConstructor{
+		cdsexec.Wrap(cdsexec.Wrap(base, sudo), retry),
+		cdsexec.Chain(base, retry, sudo),
+	} {
+		trace, attempts = nil, 0
+		out, err := ctor(context.Background(), "zpool", "list").Output()
+		if err != nil || string(out) != "ok" {
+			t.Errorf("Expected ok, got %q, %v", out, err)
+		}
+		want := "sudo -n zpool list|after zpool list|sudo -n zpool list"
+		if got := strings.Join(trace, "|"); got != want {
+			t.Errorf("Expected %s, got %s", want, got)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package mockcmd_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := mockcmd.NewFakeClock(start)
+
+	var fired []string
+	clock.AfterFunc(2*time.Second, func() { fired = append(fired, "2s@"+clock.Now().Sub(start).String()) })
+	clock.AfterFunc(time.Second, func() { fired = append(fired, "1s@"+clock.Now().Sub(start).String()) })
+	stopped := clock.AfterFunc(time.Second, func() { fired = append(fired, "stopped") })
+	timer := clock.NewTimer(3 * time.Second)
+	if !stopped.Stop() {
+		t.Errorf("Expected Stop to report a pending timer")
+	}
+	if n := clock.Pending(); n != 3 {
+		t.Errorf("Expected 3 pending timers, got %d", n)
+	}
+
+	clock.Advance(2500 * time.Millisecond)
+	if len(fired) != 2 || fired[0] != "1s@1s" || fired[1] != "2s@2s" {
+		t.Errorf("Expected timers fired in order at their deadlines, got %v", fired)
+	}
+	if got := clock.Now().Sub(start); got != 2500*time.Millisecond {
+		t.Errorf("Expected the clock to advance by 2.5s, got %v", got)
+	}
+	select {
+	case <-timer.C():
+		t.Fatalf("Timer fired early")
+	default:
+	}
+	clock.Advance(time.Second)
+	select {
+	case now := <-timer.C():
+		if now.Sub(start) != 3*time.Second {
+			t.Errorf("Expected the timer to fire at 3s, got %v", now.Sub(start))
+		}
+	default:
+		t.Errorf("Expected the timer to fire")
+	}
+}
+
+func TestFakeClockSleep(t *testing.T) {
+	clock := mockcmd.NewFakeClock(time.Now())
+	ctx := cdsexec.ContextWithClock(context.Background(), clock)
+	done := make(chan error)
+	go func() { done <- cdsexec.Sleep(ctx, time.Hour) }()
+
+	clock.BlockUntil(1)
+	clock.Advance(time.Hour)
+	if err := <-done; err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	tctx, cancel := cdsexec.ContextWithTimeout(ctx, time.Minute)
+	defer cancel()
+	clock.Advance(59 * time.Second)
+	if tctx.Err() != nil {
+		t.Fatalf("Context done early: %v", tctx.Err())
+	}
+	clock.Advance(time.Second)
+	<-tctx.Done()
+	if !errors.Is(tctx.Err(), context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", tctx.Err())
+	}
+}
@@ -23,6 +23,9 @@ type MockCmd struct {
 	Args []string
 	Dir  string
 	Env  []string
+	// Cancel is the cdsexec.CancelPolicy of Ctx, if any. When Ctx is done by the time the
+	// command runs, the command reports being terminated by Cancel.Signal instead of Err.
+	Cancel *cdsexec.CancelPolicy
 
 	// Function to check if the command was constructed correctly
 	CheckFunc func(*MockCmd) error
@@ -30,17 +33,43 @@ type MockCmd struct {
 	// Flags to track method calls
 	startCalled bool
 	waitCalled  bool
+	// unclassified is set when Err is returned as is, for callers comparing it with ==.
+	unclassified bool
 }
 
 // mockCommandContext creates a new MockCmd with the given context, name, and arguments.
 func mockCommandContext(ctx context.Context, name string, arg ...string) *MockCmd {
 	return &MockCmd{
-		Ctx:  ctx,
-		Name: name,
-		Args: arg,
+		Ctx:    ctx,
+		Name:   name,
+		Args:   arg,
+		Cancel: cancelPolicy(ctx),
 	}
 }
 
+func cancelPolicy(ctx context.Context) *cdsexec.CancelPolicy {
+	if p, ok := cdsexec.CancelPolicyFromContext(ctx); ok {
+		return &p
+	}
+	return nil
+}
+
+// result returns Err classified with cdsexec.Classify, as the errors of real commands are,
+// unless it is the error of MakeMockCmdWithOutputGenericError.
+func (m *MockCmd) result() error {
+	if m.Cancel != nil && m.Ctx != nil && m.Ctx.Err() != nil {
+		sig := m.Cancel.Signal
+		if sig == nil {
+			sig = os.Kill
+		}
+		return cdsexec.Classify(m.Ctx, &cdsexec.ExitError{Code: -1, Signal: cdsexec.SignalName(sig)})
+	}
+	if m.unclassified {
+		return m.Err
+	}
+	return cdsexec.Classify(m.Ctx, m.Err)
+}
+
 // Run simulates running the command and returns any predefined error.
 // It also executes the CheckFunc if defined.
 func (m *MockCmd) Run() error {
@@ -49,7 +78,7 @@ func (m *MockCmd) Run() error {
 			return err
 		}
 	}
-	return m.Err
+	return m.result()
 }
 
 // Output returns the predefined stdout and any error.
@@ -61,7 +90,7 @@ func (m *MockCmd) Output() ([]byte, error) {
 		}
 	}
 
-	return m.Stdout, m.Err
+	return m.Stdout, m.result()
 }
 
 // CombinedOutput returns the combined predefined stdout and stderr, and any error.
@@ -72,7 +101,7 @@ func (m *MockCmd) CombinedOutput() ([]byte, error) {
 			return nil, err
 		}
 	}
-	return append(m.Stdout, m.Stderr...), m.Err
+	return append(m.Stdout, m.Stderr...), m.result()
 }
 
 // Start simulates starting the command and marks it as started.
@@ -82,13 +111,13 @@ func (m *MockCmd) Start() error {
 	if m.CheckFunc != nil {
 		return m.CheckFunc(m)
 	}
-	return m.Err
+	return m.result()
 }
 
 // Wait simulates waiting for the command to complete and marks it as waited.
 func (m *MockCmd) Wait() error {
 	m.waitCalled = true
-	return m.Err
+	return m.result()
 }
 
 // StdinPipe returns a mock WriteCloser for stdin.
@@ -150,6 +179,7 @@ func MakeMockCmdWithOutputGenericError(checkFunc func(*MockCmd) error) cdsexec.C
 		c := mockCommandContext(ctx, name, arg...)
 		c.CheckFunc = checkFunc
 		c.Err = exec.ErrNotFound
+		c.unclassified = true
 		return c
 	}
 }
@@ -50,7 +50,7 @@ func (m *MultiCmdMockCmd) Run() error {
 	if err := m.matchCommand(); err != nil {
 		return err
 	}
-	return m.Err
+	return m.result()
 }
 
 // Output implements the Commander interface
@@ -58,7 +58,7 @@ func (m *MultiCmdMockCmd) Output() ([]byte, error) {
 	if err := m.matchCommand(); err != nil {
 		return nil, err
 	}
-	return m.Stdout, m.Err
+	return m.Stdout, m.result()
 }
 
 // CombinedOutput implements the Commander interface
@@ -66,7 +66,7 @@ func (m *MultiCmdMockCmd) CombinedOutput() ([]byte, error) {
 	if err := m.matchCommand(); err != nil {
 		return nil, err
 	}
-	return append(m.Stdout, m.Stderr...), m.Err
+	return append(m.Stdout, m.Stderr...), m.result()
 }
 
 // String returns a string representation of the last matched command
@@ -84,6 +84,7 @@ func MultiCmdMock(configs ...CommandConfig) cdsexec.CommandConstructor {
 			configs: configs,
 		}
 		cmd.Ctx = ctx
+		cmd.Cancel = cancelPolicy(ctx)
 		cmd.Name = name
 		cmd.Args = arg
 		return cmd
@@ -0,0 +1,176 @@
+package nscmd
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// Propagation is the propagation type given to the mounts of a private mount namespace,
+// which decides whether mount events reach it from the namespace it was copied from.
+// Mount events never propagate from it back to the host.
+type Propagation int
+
+const (
+	// Private mounts receive no mount events from the host.
+	Private Propagation = iota
+	// Slave mounts receive the mount events of the host, e.g. a device mounted while the
+	// command runs.
+	Slave
+)
+
+func (p Propagation) String() string {
+	switch p {
+	case Private:
+		return "private"
+	case Slave:
+		return "slave"
+	}
+	return fmt.Sprintf("Propagation(%d)", int(p))
+}
+
+// Option configures the namespaces of the commands.
+type Option func(*config)
+
+type config struct {
+	// mountNS requests a private mount namespace with the propagation mountProp.
+	mountNS   bool
+	mountProp Propagation
+	// netNS is the file of the network namespace to enter, if any.
+	netNS string
+	// overlay, if set, is the overlay root to run the commands in.
+	overlay *Overlay
+	err     error
+}
+
+func (c *config) fail(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+// empty reports whether c requests no namespace.
+func (c *config) empty() bool {
+	return !c.mountNS && c.netNS == ""
+}
+
+// WithPrivateMounts runs the commands in a private mount namespace, a copy of the mounts of
+// the process whose mounts and unmounts never propagate to the host, and which is torn down
+// with the mounts left in it once the command and the processes it started are gone. It
+// keeps the temporary mounts of helpers inspecting images from leaking and holding devices
+// busy. Creating a mount namespace requires CAP_SYS_ADMIN.
+func WithPrivateMounts(p Propagation) Option {
+	return func(c *config) {
+		if p != Private && p != Slave {
+			c.fail(fmt.Errorf("nscmd: invalid propagation %v", p))
+			return
+		}
+		c.mountNS = true
+		c.mountProp = p
+	}
+}
+
+// NetNSDir is the directory ip-netns(8) keeps the files of named network namespaces in.
+const NetNSDir = "/var/run/netns"
+
+// WithNetNS runs the commands in the network namespace ns: the name of a namespace created
+// by ip-netns(8), whose file is in NetNSDir, or the path of a namespace file, such as
+// /proc/<pid>/ns/net. Unlike ip netns exec, it does not bind-mount the files of
+// /etc/netns/<name> over those of /etc, nor mount a /sys showing the devices of the namespace.
+// Entering a network namespace requires CAP_SYS_ADMIN.
+func WithNetNS(ns string) Option {
+	return func(c *config) {
+		if ns == "" {
+			c.fail(errors.New("nscmd: empty network namespace"))
+			return
+		}
+		if !strings.ContainsRune(ns, '/') {
+			ns = filepath.Join(NetNSDir, ns)
+		}
+		c.netNS = ns
+	}
+}
+
+// WithNetNSOf runs the commands in the network namespace of the process pid, as WithNetNS
+// does.
+func WithNetNSOf(pid int) Option {
+	return func(c *config) {
+		if pid <= 0 {
+			c.fail(fmt.Errorf("nscmd: invalid PID %d", pid))
+			return
+		}
+		c.netNS = "/proc/" + strconv.Itoa(pid) + "/ns/net"
+	}
+}
+
+// Overlay describes the overlay root of WithOverlayRoot.
+type Overlay struct {
+	// Size bounds the amount of data the commands can write, as the size option of a tmpfs,
+	// such as "64m" or "10%". Empty means the default of tmpfs, half of the memory.
+	Size string
+	// Mounts are the mount points of other file systems to show read-only in the sandbox,
+	// such as "/boot", with the file systems mounted under them. The root file system is
+	// the only one the overlay shows: the directories other file systems are mounted on
+	// are shown as they are on it, usually empty.
+	Mounts []string
+}
+
+// WithOverlayRoot runs the commands in a sandbox whose root is an overlay of the root file
+// system of the host: the commands see the files of the host and can write to them, but
+// their writes go to a tmpfs discarded with the mount namespace. /dev, /proc, /sys and the
+// mount points of o.Mounts are shown read-only.
+//
+// The sandbox is a private mount namespace, with the propagation given by
+// WithPrivateMounts, Private by default, whose root is replaced by the overlay with
+// pivot_root(2). The commands start in the directory of the process, which must be shown by
+// the sandbox. They run without capabilities, even as root, and cannot gain any by
+// executing setuid binaries, so that they cannot mount or remount file systems, nor leave
+// the sandbox by chroot(2). They keep the permissions of their user otherwise: device
+// nodes they can open can still be written to, and /proc shows the processes of the host,
+// whose files are reachable under /proc/<pid>/root as ptrace access allows, so the
+// commands should not be given access to them otherwise, e.g. through confinement.
+func WithOverlayRoot(o Overlay) Option {
+	return func(c *config) {
+		for _, m := range o.Mounts {
+			if !filepath.IsAbs(m) {
+				c.fail(fmt.Errorf("nscmd: overlay mount point %q is not absolute", m))
+				return
+			}
+		}
+		if strings.ContainsAny(o.Size, ",") {
+			c.fail(fmt.Errorf("nscmd: invalid overlay size %q", o.Size))
+			return
+		}
+		c.mountNS = true
+		c.overlay = &o
+	}
+}
+
+// New returns a CommandConstructor whose commands built by base run in the namespaces opts
+// describe. The namespaces are entered by a thread dedicated to each command, which starts
+// it and is discarded once it has been waited for. base must start commands from the
+// goroutine running its handler, as cdsexec.CommandContext does, so New should wrap it
+// directly; other middleware goes around the constructor returned.
+//
+// Namespaces are specific to Linux; elsewhere, the commands fail with
+// errors.ErrUnsupported. A namespace that cannot be entered fails the command before it
+// starts.
+func New(base cdsexec.CommandConstructor, opts ...Option) cdsexec.CommandConstructor {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		if cfg.err != nil {
+			return cfg.err
+		}
+		if cfg.empty() {
+			return next(inv)
+		}
+		return cfg.run(func() error { return next(inv) })
+	})
+}
@@ -0,0 +1,73 @@
+package nscmd
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// run calls fn from a thread dedicated to it that entered the namespaces of c.
+func (c *config) run(fn func() error) error {
+	errc := make(chan error, 1)
+	go func() {
+		// The thread is never unlocked: it leaves the namespaces of the process, so the
+		// runtime terminates it when the goroutine exits instead of reusing it.
+		runtime.LockOSThread()
+		if syscall.Gettid() == os.Getpid() {
+			// The main thread cannot be terminated, and the namespaces it is in are those
+			// /proc/self shows: keep it busy, so that another thread is used.
+			errc <- c.run(fn)
+			runtime.UnlockOSThread()
+			return
+		}
+		if err := c.enter(); err != nil {
+			errc <- err
+			return
+		}
+		errc <- fn()
+	}()
+	return <-errc
+}
+
+// enter makes the current thread enter the namespaces of c.
+func (c *config) enter() error {
+	// The network namespace is entered first, so that it is found in the mount namespace
+	// of the process.
+	if c.netNS != "" {
+		if err := setns(c.netNS, syscall.CLONE_NEWNET); err != nil {
+			return fmt.Errorf("nscmd: entering network namespace %s: %w", c.netNS, err)
+		}
+	}
+	if c.mountNS {
+		if err := syscall.Unshare(syscall.CLONE_NEWNS); err != nil {
+			return fmt.Errorf("nscmd: creating a mount namespace: %w", err)
+		}
+		flag := uintptr(syscall.MS_PRIVATE)
+		if c.mountProp == Slave {
+			flag = syscall.MS_SLAVE
+		}
+		if err := syscall.Mount("none", "/", "", syscall.MS_REC|flag, ""); err != nil {
+			return fmt.Errorf("nscmd: making mounts %v: %w", c.mountProp, err)
+		}
+	}
+	if c.overlay != nil {
+		if err := c.overlay.enter(); err != nil {
+			return fmt.Errorf("nscmd: entering overlay root: %w", err)
+		}
+	}
+	return nil
+}
+
+// setns makes the current thread enter the namespace of type nstype whose file is path.
+func setns(path string, nstype uintptr) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, _, errno := syscall.RawSyscall(sysSetns, f.Fd(), nstype, 0); errno != 0 {
+		return os.NewSyscallError("setns", errno)
+	}
+	return nil
+}
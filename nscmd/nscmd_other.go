@@ -0,0 +1,13 @@
+//go:build !linux
+
+package nscmd
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+func (c *config) run(fn func() error) error {
+	return fmt.Errorf("nscmd: %w on %s", errors.ErrUnsupported, runtime.GOOS)
+}
@@ -0,0 +1,199 @@
+package nscmd_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/nscmd"
+)
+
+// requireNamespaces skips the test unless namespaces can be created.
+func requireNamespaces(t *testing.T, err error) {
+	t.Helper()
+	if runtime.GOOS != "linux" {
+		if !errors.Is(err, errors.ErrUnsupported) {
+			t.Errorf("Expected namespaces to be unsupported on %s, got %v", runtime.GOOS, err)
+		}
+		t.Skip("namespaces are specific to Linux")
+	}
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip("creating namespaces requires CAP_SYS_ADMIN")
+	}
+}
+
+func TestPrivateMounts(t *testing.T) {
+	dir := t.TempDir()
+	ctor := nscmd.New(cdsexec.CommandContext, nscmd.WithPrivateMounts(nscmd.Private))
+	out, err := ctor(context.Background(), "sh", "-c", `mount -t tmpfs cdsexec-test "$1" && readlink /proc/self/ns/mnt && grep -c cdsexec-test /proc/self/mountinfo`, "sh", dir).Output()
+	requireNamespaces(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Fields(string(out))
+	host, _ := os.Readlink("/proc/self/ns/mnt")
+	if len(lines) != 2 || lines[0] == host || lines[1] != "1" {
+		t.Errorf("Expected the mount in a namespace other than %s, got %q", host, out)
+	}
+	mountinfo, err := os.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(mountinfo), "cdsexec-test") {
+		t.Error("Expected the mount not to leak into the host namespace")
+	}
+
+	// Started commands run in a namespace too.
+	cmd := ctor(context.Background(), "readlink", "/proc/self/ns/mnt")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 64)
+	n, _ := stdout.Read(b)
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if ns := strings.TrimSpace(string(b[:n])); ns == host {
+		t.Errorf("Expected a new namespace, got %s", ns)
+	}
+}
+
+// rootMount returns the line of the root mount in mountinfo.
+func rootMount(mountinfo string) string {
+	for _, line := range strings.Split(mountinfo, "\n") {
+		if f := strings.Fields(line); len(f) > 4 && f[4] == "/" {
+			return line
+		}
+	}
+	return ""
+}
+
+func TestSlaveMounts(t *testing.T) {
+	ctor := nscmd.New(cdsexec.CommandContext, nscmd.WithPrivateMounts(nscmd.Slave))
+	out, err := ctor(context.Background(), "cat", "/proc/self/mountinfo").Output()
+	requireNamespaces(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := rootMount(string(out))
+	if strings.Contains(root, "shared:") {
+		t.Errorf("Expected the root mount not to be shared, got %q", root)
+	}
+	mountinfo, err := os.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A root mount shared with the host becomes a slave of its peer group.
+	host := rootMount(string(mountinfo))
+	if i := strings.Index(host, "shared:"); i >= 0 {
+		if peer := strings.Fields(host[i+len("shared:"):])[0]; !strings.Contains(root, "master:"+peer) {
+			t.Errorf("Expected the root mount to be a slave of %s, got %q", peer, root)
+		}
+	}
+}
+
+func TestInvalidPropagation(t *testing.T) {
+	ctor := nscmd.New(cdsexec.CommandContext, nscmd.WithPrivateMounts(nscmd.Propagation(7)))
+	if err := ctor(context.Background(), "true").Run(); err == nil || !strings.Contains(err.Error(), "invalid propagation") {
+		t.Errorf("Expected an invalid propagation to fail, got %v", err)
+	}
+}
+
+func TestNetNS(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("namespaces are specific to Linux")
+	}
+	// A process holding a network namespace of its own.
+	holder := exec.Command("unshare", "--net", "sleep", "30")
+	if err := holder.Start(); err != nil {
+		t.Skipf("unshare not available: %v", err)
+	}
+	defer holder.Wait()
+	defer holder.Process.Kill()
+	nsFile := "/proc/" + strconv.Itoa(holder.Process.Pid) + "/ns/net"
+	var want string
+	for i := 0; i < 100; i++ {
+		// unshare creates the namespace before executing sleep.
+		if comm, _ := os.ReadFile("/proc/" + strconv.Itoa(holder.Process.Pid) + "/comm"); string(comm) == "sleep\n" {
+			want, _ = os.Readlink(nsFile)
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	host, _ := os.Readlink("/proc/self/ns/net")
+	if want == "" || want == host {
+		t.Skip("unshare could not create a network namespace")
+	}
+
+	for name, opt := range map[string]nscmd.Option{"path": nscmd.WithNetNS(nsFile), "pid": nscmd.WithNetNSOf(holder.Process.Pid)} {
+		ctor := nscmd.New(cdsexec.CommandContext, opt, nscmd.WithPrivateMounts(nscmd.Private))
+		out, err := ctor(context.Background(), "readlink", "/proc/self/ns/net").Output()
+		requireNamespaces(t, err)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimSpace(string(out)); got != want {
+			t.Errorf("%s: Expected the command in %s, got %s", name, want, got)
+		}
+	}
+	if got, _ := os.Readlink("/proc/self/ns/net"); got != host {
+		t.Errorf("Expected the process to stay in %s, got %s", host, got)
+	}
+
+	err := nscmd.New(cdsexec.CommandContext, nscmd.WithNetNS("cdsexec-missing"))(context.Background(), "true").Run()
+	if !errors.Is(err, fs.ErrNotExist) || !strings.Contains(err.Error(), nscmd.NetNSDir+"/cdsexec-missing") {
+		t.Errorf("Expected a missing namespace to fail, got %v", err)
+	}
+}
+
+func TestOverlayRoot(t *testing.T) {
+	ctor := nscmd.New(cdsexec.CommandContext, nscmd.WithOverlayRoot(nscmd.Overlay{Size: "16m"}))
+	script := `echo written > /etc/cdsexec-overlay && cat /etc/cdsexec-overlay && pwd &&
+		rm -f "$1" && ! echo 1 2>/dev/null > /proc/sys/kernel/cdsexec &&
+		! touch /sys/cdsexec 2>/dev/null && ! touch /dev/shm/cdsexec-overlay 2>/dev/null && echo read-only &&
+		! mount -o remount,rw / 2>/dev/null && ! mount -o remount,rw /sys 2>/dev/null &&
+		! mount -t tmpfs none /mnt 2>/dev/null && grep -q "^CapEff:[[:space:]]*0*$" /proc/self/status && echo confined`
+	file, err := os.CreateTemp(".", "cdsexec-overlay-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+	out, err := ctor(context.Background(), "sh", "-c", script, "sh", file.Name()).CombinedOutput()
+	requireNamespaces(t, err)
+	if err != nil && strings.Contains(err.Error(), "mounting overlay") {
+		t.Skipf("overlayfs not available: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	cwd, _ := os.Getwd()
+	if want := "written\n" + cwd + "\nread-only\nconfined\n"; string(out) != want {
+		t.Errorf("Expected %q, got %q", want, out)
+	}
+	for _, name := range []string{"/etc/cdsexec-overlay", "/dev/shm/cdsexec-overlay", file.Name()} {
+		if _, err := os.Stat(name); (name == file.Name()) != (err == nil) {
+			t.Errorf("Expected the host files to be left alone, got %v for %s", err, name)
+		}
+	}
+}
+
+func TestInvalidOverlay(t *testing.T) {
+	ctor := nscmd.New(cdsexec.CommandContext, nscmd.WithOverlayRoot(nscmd.Overlay{Mounts: []string{"boot"}}))
+	if err := ctor(context.Background(), "true").Run(); err == nil || !strings.Contains(err.Error(), "not absolute") {
+		t.Errorf("Expected a relative mount point to fail, got %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+package nscmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// overlayMounts are the mount points always shown read-only in an overlay root: the file
+// systems of the kernel, which the commands cannot do without.
+var overlayMounts = []string{"/dev", "/proc", "/sys"}
+
+// O_PATH, and the prctl options and capability version dropCaps uses, which package
+// syscall does not define.
+const (
+	oPath                   = 0x200000
+	prCapbsetDrop           = 24
+	prSetNoNewPrivs         = 38
+	prCapAmbient            = 47
+	prCapAmbientClearAll    = 4
+	linuxCapabilityVersion3 = 0x20080522
+)
+
+// enter builds the overlay root o in the mount namespace of the current thread, which must
+// be private to it, makes the thread enter it and drops the capabilities of the thread, so
+// that the commands it starts cannot undo the sandbox.
+func (o *Overlay) enter() error {
+	cwd, err := syscall.Getwd()
+	if err != nil {
+		return err
+	}
+	// The file systems to show are held before the staging tmpfs is mounted, which may
+	// hide one of them.
+	mounts := append(overlayMounts[:len(overlayMounts):len(overlayMounts)], o.Mounts...)
+	fds := make([]int, len(mounts))
+	for i, m := range mounts {
+		fd, err := syscall.Open(m, oPath|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			for _, fd := range fds[:i] {
+				syscall.Close(fd)
+			}
+			return &os.PathError{Op: "open", Path: m, Err: err}
+		}
+		fds[i] = fd
+	}
+	defer func() {
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+	}()
+
+	// The layers are staged on a tmpfs mounted over the temporary directory, in the
+	// namespace only.
+	stage := os.TempDir()
+	if strings.ContainsAny(stage, `,:\`) {
+		return fmt.Errorf("temporary directory %q cannot hold overlay layers", stage)
+	}
+	data := "mode=0700"
+	if o.Size != "" {
+		data += ",size=" + o.Size
+	}
+	if err := syscall.Mount("cdsexec-overlay", stage, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, data); err != nil {
+		return fmt.Errorf("mounting tmpfs on %s: %w", stage, err)
+	}
+	upper, work, root := filepath.Join(stage, "upper"), filepath.Join(stage, "work"), filepath.Join(stage, "root")
+	for _, dir := range []string{upper, work, root} {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	if err := syscall.Mount("overlay", root, "overlay", 0, "lowerdir=/,upperdir="+upper+",workdir="+work); err != nil {
+		return fmt.Errorf("mounting overlay: %w", err)
+	}
+	for i, m := range mounts {
+		src := "/proc/self/fd/" + strconv.Itoa(fds[i])
+		if err := syscall.Mount(src, filepath.Join(root, m), "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("binding %s: %w", m, err)
+		}
+	}
+	if err := remountReadOnly(root); err != nil {
+		return err
+	}
+	// The overlay replaces the root of the namespace, unlike a chroot which the commands
+	// could walk out of: the root of the host is stacked under it by pivot_root, and
+	// detached.
+	if err := syscall.Chdir(root); err != nil {
+		return err
+	}
+	if err := syscall.PivotRoot(".", "."); err != nil {
+		return os.NewSyscallError("pivot_root", err)
+	}
+	if err := syscall.Unmount(".", syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("detaching the host root: %w", err)
+	}
+	if err := syscall.Chdir(cwd); err != nil {
+		return fmt.Errorf("working directory %s is not in the overlay: %w", cwd, err)
+	}
+	return dropCaps()
+}
+
+// dropCaps drops every capability of the current thread, from all its sets, so that the
+// programs it starts hold none even as root, and keeps them from gaining privileges by
+// executing setuid binaries.
+func dropCaps() error {
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("setting no_new_privs: %w", errno)
+	}
+	// Kernels without ambient capabilities have none to clear.
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientClearAll, 0, 0, 0, 0); errno != 0 && errno != syscall.EINVAL {
+		return fmt.Errorf("clearing ambient capabilities: %w", errno)
+	}
+	// The bounding set is emptied up to the first capability the kernel does not know.
+	for c := uintptr(0); c < 64; c++ {
+		if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prCapbsetDrop, c, 0, 0, 0, 0); errno == syscall.EINVAL {
+			break
+		} else if errno != 0 {
+			return fmt.Errorf("dropping capability %d from the bounding set: %w", c, errno)
+		}
+	}
+	hdr := struct {
+		version uint32
+		pid     int32
+	}{version: linuxCapabilityVersion3}
+	var data [2]struct{ effective, permitted, inheritable uint32 }
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("dropping capabilities: %w", errno)
+	}
+	return nil
+}
+
+// remountReadOnly makes the mounts under root, but not root itself, read-only. Recursive
+// binds are remounted one mount at a time, as a read-only remount is not recursive.
+func remountReadOnly(root string) error {
+	f, err := os.Open("/proc/thread-self/mountinfo")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var targets []string
+	var flags []uintptr
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// The fifth field is the mount point, the sixth the options of the mount.
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 6 {
+			continue
+		}
+		if target := unescapeMountinfo(fields[4]); strings.HasPrefix(target, root+"/") {
+			targets = append(targets, target)
+			flags = append(flags, mountFlags(fields[5]))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	for i, target := range targets {
+		// The flags of the mount are kept, as the kernel refuses to clear those that are
+		// locked, e.g. in a container.
+		if err := syscall.Mount("", target, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY|flags[i], ""); err != nil {
+			return fmt.Errorf("remounting %s read-only: %w", strings.TrimPrefix(target, root), err)
+		}
+	}
+	return nil
+}
+
+// mountFlags returns the flags set by the mount options opts of a mountinfo line.
+func mountFlags(opts string) uintptr {
+	var flags uintptr
+	for _, opt := range strings.Split(opts, ",") {
+		switch opt {
+		case "nosuid":
+			flags |= syscall.MS_NOSUID
+		case "nodev":
+			flags |= syscall.MS_NODEV
+		case "noexec":
+			flags |= syscall.MS_NOEXEC
+		case "noatime":
+			flags |= syscall.MS_NOATIME
+		case "nodiratime":
+			flags |= syscall.MS_NODIRATIME
+		case "relatime":
+			flags |= syscall.MS_RELATIME
+		}
+	}
+	return flags
+}
+
+// unescapeMountinfo decodes the octal escapes of the spaces, tabs, newlines and
+// backslashes of a path of mountinfo.
+func unescapeMountinfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,4 @@
+package nscmd
+
+// sysSetns is the number of setns, which the syscall package lacks on 386.
+const sysSetns = 346
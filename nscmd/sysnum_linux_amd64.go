@@ -0,0 +1,4 @@
+package nscmd
+
+// sysSetns is the number of setns, which the syscall package lacks on amd64.
+const sysSetns = 308
@@ -0,0 +1,241 @@
+package oncecmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// ErrDuplicate matches the errors of one-shot commands refused because they already ran.
+var ErrDuplicate = errors.New("oncecmd: command already ran")
+
+// Config configures a Guard.
+type Config struct {
+	// OneShot selects the commands that must not run twice within Window, e.g. vgextend.
+	OneShot cdsexec.Matcher
+	// Window is how long after it started a command cannot run again. Zero means until it is
+	// forgotten.
+	Window time.Duration
+	// Skip answers the repeated commands with the result of the first run, including its
+	// redacted output, instead of refusing them with a *DuplicateError. Commands repeated while the
+	// first run has not finished are refused either way.
+	Skip bool
+	// Path is the file the ledger is kept in, so that it survives restarts. Empty keeps it
+	// in memory only.
+	Path string
+	// Key identifies the commands that are the same. Nil uses CommandSpec.Fingerprint, for
+	// which a difference of argument, directory or environment makes another command.
+	Key func(cdsexec.CommandSpec) string
+}
+
+// Entry records a run of a one-shot command in the ledger.
+type Entry struct {
+	// Command is the command line, redacted by the cdsexec.Redactor of its context.
+	Command string    `json:"command"`
+	Time    time.Time `json:"time"`
+	// Running is set until the command has finished. An entry still running when the
+	// ledger is loaded is a run interrupted by a restart, whose outcome is unknown.
+	Running  bool `json:"running,omitempty"`
+	ExitCode int  `json:"exit_code"`
+	// Output is the output collected from the command, redacted like Command.
+	Output []byte `json:"output,omitempty"`
+}
+
+// DuplicateError is returned for one-shot commands refused because they already ran.
+type DuplicateError struct {
+	// Spec is the refused command, redacted by the cdsexec.Redactor of its context.
+	Spec     cdsexec.CommandSpec
+	Previous Entry
+}
+
+func (e *DuplicateError) Error() string {
+	if e.Previous.Running {
+		return fmt.Sprintf("oncecmd: %s is already running since %s", e.Spec, e.Previous.Time.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("oncecmd: %s already ran at %s with exit code %d", e.Spec, e.Previous.Time.Format(time.RFC3339), e.Previous.ExitCode)
+}
+
+// Is reports whether target is ErrDuplicate.
+func (e *DuplicateError) Is(target error) bool {
+	return target == ErrDuplicate
+}
+
+// Guard keeps one-shot commands from running twice, guarding against double submissions.
+type Guard struct {
+	cfg Config
+
+	mu      sync.Mutex
+	entries map[string]Entry
+}
+
+// NewGuard creates a Guard from cfg, loading the ledger from cfg.Path if it exists.
+func NewGuard(cfg Config) (*Guard, error) {
+	if cfg.Key == nil {
+		cfg.Key = cdsexec.CommandSpec.Fingerprint
+	}
+	g := &Guard{cfg: cfg, entries: make(map[string]Entry)}
+	if cfg.Path == "" {
+		return g, nil
+	}
+	data, err := os.ReadFile(cfg.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return g, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &g.entries); err != nil {
+		return nil, fmt.Errorf("oncecmd: reading %s: %w", cfg.Path, err)
+	}
+	return g, nil
+}
+
+// Forget drops the ledger entry of spec, letting it run again, e.g. once an operator has
+// checked that a repeated command is intended.
+func (g *Guard) Forget(spec cdsexec.CommandSpec) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.entries, g.cfg.Key(spec))
+	// Forget has no context to read the time from, so expired entries are dropped later.
+	return g.saveLocked(time.Time{})
+}
+
+// Entries returns the entries of the ledger, keyed by the keys of their commands.
+func (g *Guard) Entries() map[string]Entry {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	out := make(map[string]Entry, len(g.entries))
+	for k, e := range g.entries {
+		out[k] = e
+	}
+	return out
+}
+
+// Wrap returns a CommandConstructor whose one-shot commands built by base run at most once
+// within the window of g. A command is recorded in the ledger when it starts, so that a
+// concurrent double submission is refused too, and its exit code and output once it has
+// finished. A command that fails to start is forgotten, as it had no effect.
+//
+// The ledger is saved to disk before a one-shot command starts and after it finishes; a
+// failure to save it fails the command before it starts.
+func (g *Guard) Wrap(base cdsexec.CommandConstructor) cdsexec.CommandConstructor {
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		if g.cfg.OneShot == nil || !g.cfg.OneShot(inv.Spec) {
+			return next(inv)
+		}
+		key := g.cfg.Key(inv.Spec)
+		now := cdsexec.ClockFromContext(inv.Ctx).Now()
+		redactor := cdsexec.RedactorFromContext(inv.Ctx)
+		redacted := redactor.Spec(inv.Spec)
+
+		g.mu.Lock()
+		if prev, ok := g.entries[key]; ok && (g.cfg.Window <= 0 || now.Before(prev.Time.Add(g.cfg.Window))) {
+			g.mu.Unlock()
+			if !g.cfg.Skip || prev.Running {
+				return &DuplicateError{Spec: redacted, Previous: prev}
+			}
+			return replay(inv, prev)
+		}
+		g.entries[key] = Entry{Command: redacted.String(), Time: now, Running: true}
+		err := g.saveLocked(now)
+		if err != nil {
+			delete(g.entries, key)
+		}
+		g.mu.Unlock()
+		if err != nil {
+			return err
+		}
+
+		err = next(inv)
+		res := inv.Result(err)
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if ran(inv, err) {
+			entry := Entry{Command: redacted.String(), Time: now, ExitCode: res.ExitCode}
+			if inv.Output != nil {
+				entry.Output = []byte(redactor.Text(string(inv.Output)))
+			}
+			g.entries[key] = entry
+		} else {
+			delete(g.entries, key)
+		}
+		g.saveLocked(now)
+		return err
+	})
+}
+
+// New is a shorthand for NewGuard followed by Wrap.
+func New(base cdsexec.CommandConstructor, cfg Config) (cdsexec.CommandConstructor, error) {
+	g, err := NewGuard(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return g.Wrap(base), nil
+}
+
+// replay answers inv with the result of its previous run.
+func replay(inv *cdsexec.Invocation, prev Entry) error {
+	inv.Output = append([]byte(nil), prev.Output...)
+	if prev.ExitCode != 0 {
+		return &cdsexec.ExitError{Code: prev.ExitCode}
+	}
+	return nil
+}
+
+// ran reports whether the command of inv ran, as opposed to failing to start.
+func ran(inv *cdsexec.Invocation, err error) bool {
+	if err == nil {
+		return true
+	}
+	if cmd := inv.Commander(); cmd != nil && cmd.ProcessState() != nil {
+		return true
+	}
+	var ee interface{ ExitCode() int }
+	return errors.As(err, &ee)
+}
+
+// saveLocked writes the ledger to the path of g, dropping the entries expired at now unless
+// it is zero, through a temporary file renamed over it so that a crash cannot leave it
+// half-written.
+func (g *Guard) saveLocked(now time.Time) error {
+	if g.cfg.Path == "" {
+		return nil
+	}
+	if g.cfg.Window > 0 && !now.IsZero() {
+		for k, e := range g.entries {
+			if !e.Running && !now.Before(e.Time.Add(g.cfg.Window)) {
+				delete(g.entries, k)
+			}
+		}
+	}
+	data, err := json.Marshal(g.entries)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(g.cfg.Path), filepath.Base(g.cfg.Path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("oncecmd: saving ledger: %w", err)
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), g.cfg.Path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("oncecmd: saving ledger: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package oncecmd_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+	"github.com/cirrusdata/cdsexec/oncecmd"
+)
+
+func TestRefuseDuplicates(t *testing.T) {
+	runs := 0
+	base := mockcmd.MakeMockCmdWithOutput("extended", func(m *mockcmd.MockCmd) error {
+		runs++
+		return nil
+	})
+	clock := mockcmd.NewFakeClock(time.Unix(0, 0))
+	ctx := cdsexec.ContextWithClock(context.Background(), clock)
+	path := filepath.Join(t.TempDir(), "ledger.json")
+	cfg := oncecmd.Config{OneShot: cdsexec.MatchBinary("vgextend"), Window: time.Hour, Path: path}
+	ctor, err := oncecmd.New(base, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ctor(ctx, "vgextend", "vg0", "/dev/sdb").Run(); err != nil {
+		t.Fatal(err)
+	}
+	err = ctor(ctx, "vgextend", "vg0", "/dev/sdb").Run()
+	var de *oncecmd.DuplicateError
+	if !errors.As(err, &de) || !errors.Is(err, oncecmd.ErrDuplicate) || de.Previous.Running {
+		t.Fatalf("Expected *DuplicateError, got %v", err)
+	}
+	if err := ctor(ctx, "vgextend", "vg0", "/dev/sdc").Run(); err != nil {
+		t.Errorf("Expected other arguments to run, got %v", err)
+	}
+	if err := ctor(ctx, "vgs").Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctor(ctx, "vgs").Run(); err != nil {
+		t.Errorf("Expected commands that are not one-shot to run again, got %v", err)
+	}
+	if runs != 4 {
+		t.Errorf("Expected 4 runs, got %d", runs)
+	}
+
+	// The ledger survives a restart.
+	ctor, err = oncecmd.New(base, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctor(ctx, "vgextend", "vg0", "/dev/sdb").Run(); !errors.Is(err, oncecmd.ErrDuplicate) {
+		t.Errorf("Expected the ledger to be loaded, got %v", err)
+	}
+	clock.Advance(time.Hour)
+	if err := ctor(ctx, "vgextend", "vg0", "/dev/sdb").Run(); err != nil {
+		t.Errorf("Expected the command to run after the window, got %v", err)
+	}
+}
+
+func TestSkip(t *testing.T) {
+	base := mockcmd.MakeMockCmdWithOutputSpecificError("partial", &cdsexec.ExitError{Code: 5}, nil)
+	g, err := oncecmd.NewGuard(oncecmd.Config{OneShot: cdsexec.MatchBinary("lvcreate"), Skip: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctor := g.Wrap(base)
+	for i := 0; i < 2; i++ {
+		out, err := ctor(context.Background(), "lvcreate", "-L", "1G", "vg0").Output()
+		if cdsexec.ExitCode(err) != 5 || string(out) != "partial" {
+			t.Errorf("Expected the result of the first run, got %q, %v", out, err)
+		}
+	}
+	if len(g.Entries()) != 1 {
+		t.Errorf("Expected 1 entry, got %v", g.Entries())
+	}
+	g.Forget(cdsexec.CommandSpec{Name: "lvcreate", Args: []string{"-L", "1G", "vg0"}})
+	if len(g.Entries()) != 0 {
+		t.Errorf("Expected Forget to drop the entry, got %v", g.Entries())
+	}
+}
+
+func TestRedactedOutput(t *testing.T) {
+	base := mockcmd.MakeMockCmdWithOutput("created, key=s3cr3t\n", nil)
+	path := filepath.Join(t.TempDir(), "ledger.json")
+	g, err := oncecmd.NewGuard(oncecmd.Config{OneShot: cdsexec.MatchBinary("cryptsetup"), Skip: true, Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := cdsexec.NewRedactor(cdsexec.RedactPattern(regexp.MustCompile(`key=(\S+)`)))
+	ctx := cdsexec.ContextWithRedactor(context.Background(), r)
+	ctor := g.Wrap(base)
+	if _, err := ctor(ctx, "cryptsetup", "luksFormat", "/dev/sdb").Output(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ledger map[string]oncecmd.Entry
+	if err := json.Unmarshal(data, &ledger); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range ledger {
+		if strings.Contains(string(e.Output), "s3cr3t") {
+			t.Errorf("Expected the output to be redacted in the ledger, got %q", e.Output)
+		}
+	}
+	out, err := ctor(ctx, "cryptsetup", "luksFormat", "/dev/sdb").Output()
+	if err != nil || strings.Contains(string(out), "s3cr3t") || !strings.HasPrefix(string(out), "created") {
+		t.Errorf("Expected the redacted output to be replayed, got %q, %v", out, err)
+	}
+}
+
+func TestConcurrentAndFailedStart(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	base := mockcmd.MakeMockCmdWithOutput("", func(m *mockcmd.MockCmd) error {
+		close(entered)
+		<-release
+		return nil
+	})
+	ctor, _ := oncecmd.New(base, oncecmd.Config{OneShot: cdsexec.MatchBinary("vgextend"), Skip: true})
+	done := make(chan error)
+	go func() { done <- ctor(context.Background(), "vgextend", "vg0", "/dev/sdb").Run() }()
+	<-entered
+	var de *oncecmd.DuplicateError
+	if err := ctor(context.Background(), "vgextend", "vg0", "/dev/sdb").Run(); !errors.As(err, &de) || !de.Previous.Running {
+		t.Errorf("Expected the concurrent submission to be refused, got %v", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	ctor, _ = oncecmd.New(cdsexec.CommandContext, oncecmd.Config{OneShot: cdsexec.MatchBinary("cdsexec-missing")})
+	for i := 0; i < 2; i++ {
+		if err := ctor(context.Background(), "cdsexec-missing").Run(); !errors.Is(err, exec.ErrNotFound) {
+			t.Errorf("Expected commands that did not start to be forgotten, got %v", err)
+		}
+	}
+}
+
+func TestForgetKeepsEntriesOnClock(t *testing.T) {
+	clock := mockcmd.NewFakeClock(time.Unix(0, 0))
+	ctx := cdsexec.ContextWithClock(context.Background(), clock)
+	path := filepath.Join(t.TempDir(), "ledger.json")
+	g, err := oncecmd.NewGuard(oncecmd.Config{OneShot: cdsexec.MatchBinary("vgextend"), Window: time.Hour, Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctor := g.Wrap(mockcmd.MakeMockCmdWithOutput("", nil))
+	ctor(ctx, "vgextend", "vg0", "/dev/sdb").Run()
+	ctor(ctx, "vgextend", "vg0", "/dev/sdc").Run()
+	if err := g.Forget(cdsexec.CommandSpec{Name: "vgextend", Args: []string{"vg0", "/dev/sdb"}}); err != nil {
+		t.Fatal(err)
+	}
+	g, err = oncecmd.NewGuard(oncecmd.Config{OneShot: cdsexec.MatchBinary("vgextend"), Window: time.Hour, Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Entries()) != 1 {
+		t.Errorf("Expected the entry within the window of the clock to be kept, got %v", g.Entries())
+	}
+}
@@ -0,0 +1,135 @@
+package otelcmd
+
+import (
+	"io"
+
+	"github.com/cirrusdata/cdsexec"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/cirrusdata/cdsexec/otelcmd"
+
+// Attribute keys recorded on command spans.
+const (
+	ExecutableNameKey = attribute.Key("process.executable.name")
+	CommandArgsKey    = attribute.Key("process.command_args")
+	ExitCodeKey       = attribute.Key("process.exit.code")
+	CallKey           = attribute.Key("cdsexec.call")
+	StdinBytesKey     = attribute.Key("cdsexec.stdin.bytes")
+	StdoutBytesKey    = attribute.Key("cdsexec.stdout.bytes")
+	StderrBytesKey    = attribute.Key("cdsexec.stderr.bytes")
+)
+
+// Option configures the tracing wrapper.
+type Option func(*config)
+
+type config struct {
+	provider trace.TracerProvider
+	spanName func(cdsexec.CommandSpec) string
+	attrs    []attribute.KeyValue
+}
+
+// WithTracerProvider sets the provider used to create spans. The default is the global provider.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *config) { c.provider = tp }
+}
+
+// WithSpanName sets how span names are derived from the command. The default is "exec <name>".
+func WithSpanName(fn func(cdsexec.CommandSpec) string) Option {
+	return func(c *config) { c.spanName = fn }
+}
+
+// WithAttributes adds fixed attributes to every span.
+func WithAttributes(attrs ...attribute.KeyValue) Option {
+	return func(c *config) { c.attrs = append(c.attrs, attrs...) }
+}
+
+// New returns a CommandConstructor that records a span for every command built by base.
+// The span is a child of the span in the command's context, and that context is passed on to
+// base. Arguments are redacted by the cdsexec.Redactor found in the context.
+func New(base cdsexec.CommandConstructor, opts ...Option) cdsexec.CommandConstructor {
+	cfg := config{
+		spanName: func(s cdsexec.CommandSpec) string { return "exec " + s.Name },
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.provider == nil {
+		cfg.provider = otel.GetTracerProvider()
+	}
+	tracer := cfg.provider.Tracer(instrumentationName)
+
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		redactor := cdsexec.RedactorFromContext(inv.Ctx)
+		spec := redactor.Spec(inv.Spec)
+		ctx, span := tracer.Start(inv.Ctx, cfg.spanName(spec),
+			trace.WithSpanKind(trace.SpanKindInternal),
+			trace.WithAttributes(cfg.attrs...),
+			trace.WithAttributes(
+				ExecutableNameKey.String(spec.Name),
+				CommandArgsKey.StringSlice(append([]string{spec.Name}, spec.Args...)),
+				CallKey.String(inv.Kind.String()),
+			),
+		)
+		defer span.End()
+		inv.Ctx = ctx
+
+		var stdin *countingReader
+		if inv.Spec.Stdin != nil {
+			stdin = &countingReader{r: inv.Spec.Stdin}
+			inv.Spec.Stdin = stdin
+		}
+		stdout, stderr := &countingWriter{}, &countingWriter{}
+		teeOut := inv.TeeStdout(stdout)
+		teeErr := inv.TeeStderr(stderr)
+
+		err := next(inv)
+		res := inv.Result(err)
+
+		span.SetAttributes(ExitCodeKey.Int(res.ExitCode))
+		if stdin != nil {
+			span.SetAttributes(StdinBytesKey.Int64(stdin.n))
+		}
+		switch {
+		case teeOut:
+			span.SetAttributes(StdoutBytesKey.Int64(stdout.n))
+		case res.Stdout != nil:
+			span.SetAttributes(StdoutBytesKey.Int(len(res.Stdout)))
+		}
+		switch {
+		case teeErr:
+			span.SetAttributes(StderrBytesKey.Int64(stderr.n))
+		case res.Stderr != nil:
+			span.SetAttributes(StderrBytesKey.Int(len(res.Stderr)))
+		}
+		if err != nil {
+			rerr := redactor.Error(err)
+			span.RecordError(rerr)
+			span.SetStatus(codes.Error, rerr.Error())
+		}
+		return err
+	})
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
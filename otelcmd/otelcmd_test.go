@@ -0,0 +1,86 @@
+package otelcmd_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/otelcmd"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func attrMap(attrs []attribute.KeyValue) map[attribute.Key]attribute.Value {
+	m := make(map[attribute.Key]attribute.Value, len(attrs))
+	for _, kv := range attrs {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
+
+func TestSpanPerCommand(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	ctor := otelcmd.New(cdsexec.CommandContext, otelcmd.WithTracerProvider(tp))
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	cmd := ctor(ctx, "sh", "-c", "cat; echo err >&2")
+	cmd.SetStdin(strings.NewReader("hello"))
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	parent.End()
+
+	spans := rec.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	span := spans[0]
+	if span.Name() != "exec sh" {
+		t.Errorf("Unexpected span name %q", span.Name())
+	}
+	if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("Expected command span to be a child of the parent span")
+	}
+	attrs := attrMap(span.Attributes())
+	if got := attrs[otelcmd.ExitCodeKey].AsInt64(); got != 0 {
+		t.Errorf("Expected exit code 0, got %d", got)
+	}
+	if got := attrs[otelcmd.StdinBytesKey].AsInt64(); got != 5 {
+		t.Errorf("Expected 5 stdin bytes, got %d", got)
+	}
+	if got := attrs[otelcmd.StdoutBytesKey].AsInt64(); got != 5 {
+		t.Errorf("Expected 5 stdout bytes, got %d", got)
+	}
+	if got := attrs[otelcmd.StderrBytesKey].AsInt64(); got != 4 {
+		t.Errorf("Expected 4 stderr bytes, got %d", got)
+	}
+}
+
+func TestSpanErrorAndRedaction(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	r := cdsexec.NewRedactor(cdsexec.RedactFlags("--password"))
+	ctor := cdsexec.Redact(otelcmd.New(cdsexec.CommandContext, otelcmd.WithTracerProvider(tp)), r)
+
+	_, err := ctor(context.Background(), "sh", "-c", "exit 3", "--password", "hunter2").Output()
+	if cdsexec.ExitCode(err) != 3 {
+		t.Fatalf("Expected exit code 3, got %v", err)
+	}
+
+	span := rec.Ended()[0]
+	if span.Status().Code != codes.Error {
+		t.Errorf("Expected error status, got %v", span.Status())
+	}
+	attrs := attrMap(span.Attributes())
+	if got := attrs[otelcmd.ExitCodeKey].AsInt64(); got != 3 {
+		t.Errorf("Expected exit code 3, got %d", got)
+	}
+	args := strings.Join(attrs[otelcmd.CommandArgsKey].AsStringSlice(), " ")
+	if strings.Contains(args, "hunter2") {
+		t.Errorf("Expected password to be redacted, got %s", args)
+	}
+}
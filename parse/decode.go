@@ -0,0 +1,92 @@
+package parse
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// structFields maps the lowercased names of the exported fields of typ, taken from the
+// given tag or else the field name, to their indexes.
+func structFields(typ reflect.Type, tag string) map[string][]int {
+	fields := make(map[string][]int)
+	for _, f := range reflect.VisibleFields(typ) {
+		if !f.IsExported() || f.Anonymous {
+			continue
+		}
+		name := f.Name
+		if t, ok := f.Tag.Lookup(tag); ok {
+			if t == "-" {
+				continue
+			}
+			name = t
+		}
+		fields[strings.ToLower(name)] = f.Index
+	}
+	return fields
+}
+
+// setValue parses s into v according to its kind. An empty s leaves v unchanged.
+func setValue(v reflect.Value, s string) error {
+	if s == "" {
+		return nil
+	}
+	if v.Kind() == reflect.Pointer {
+		p := reflect.New(v.Type().Elem())
+		if err := setValue(p.Elem(), s); err != nil {
+			return err
+		}
+		v.Set(p)
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := parseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return &unsupportedError{v.Type()}
+	}
+	return nil
+}
+
+// parseBool also accepts the yes/no and on/off spellings of command-line tools.
+func parseBool(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "yes", "y", "on", "enabled":
+		return true, nil
+	case "no", "n", "off", "disabled":
+		return false, nil
+	}
+	return strconv.ParseBool(s)
+}
+
+type unsupportedError struct {
+	typ reflect.Type
+}
+
+func (e *unsupportedError) Error() string {
+	return "unsupported field type " + e.typ.String()
+}
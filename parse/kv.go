@@ -0,0 +1,217 @@
+package parse
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// KVOptions configures ParseKV.
+type KVOptions struct {
+	// Separators separate keys from values; the one occurring first in a line is used. The
+	// default is ":" and "=".
+	Separators []string
+	// Section matches the lines starting a section. Its first group, or the whole match
+	// without group, names the section. A pair on that line, as in "Target: iqn...", is the
+	// first of the section. The default matches INI headers such as "[main]".
+	Section *regexp.Regexp
+	// Comments are the prefixes of lines to skip. The default is "#" and ";".
+	Comments []string
+}
+
+var iniSection = regexp.MustCompile(`^\s*\[([^\]]+)\]\s*$`)
+
+// Pair is a key and its value.
+type Pair struct {
+	Key, Value string
+}
+
+// Section is a named group of pairs. Pairs appearing before any section header belong to
+// a section with an empty name.
+type Section struct {
+	Name  string
+	Pairs []Pair
+}
+
+// Get returns the value of the first pair whose key equals key, compared
+// case-insensitively.
+func (s *Section) Get(key string) (string, bool) {
+	for _, p := range s.Pairs {
+		if strings.EqualFold(p.Key, key) {
+			return p.Value, true
+		}
+	}
+	return "", false
+}
+
+// Map returns the pairs of the section as a map. For repeated keys the last value wins.
+func (s *Section) Map() map[string]string {
+	m := make(map[string]string, len(s.Pairs))
+	for _, p := range s.Pairs {
+		m[p.Key] = p.Value
+	}
+	return m
+}
+
+// KV is key-value output split into sections.
+type KV struct {
+	Sections []Section
+}
+
+// ParseKV splits "key: value" or "key=value" output, such as that of smartctl -i or
+// iscsiadm -m session -P 3, into sections of pairs. Keys and values are trimmed, and lines
+// without separator are skipped.
+func ParseKV(data []byte, opts KVOptions) *KV {
+	seps := opts.Separators
+	if seps == nil {
+		seps = []string{":", "="}
+	}
+	section := opts.Section
+	if section == nil {
+		section = iniSection
+	}
+	comments := opts.Comments
+	if comments == nil {
+		comments = []string{"#", ";"}
+	}
+
+	kv := &KV{Sections: []Section{{}}}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, len(data)+1)
+	for sc.Scan() {
+		line := sc.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || hasAnyPrefix(trimmed, comments) {
+			continue
+		}
+		if m := section.FindStringSubmatch(line); m != nil {
+			name := m[0]
+			if len(m) > 1 {
+				name = m[1]
+			}
+			kv.Sections = append(kv.Sections, Section{Name: strings.TrimSpace(name)})
+		}
+		if p, ok := splitPair(trimmed, seps); ok {
+			cur := &kv.Sections[len(kv.Sections)-1]
+			cur.Pairs = append(cur.Pairs, p)
+		}
+	}
+	if len(kv.Sections[0].Pairs) == 0 && len(kv.Sections) > 1 {
+		kv.Sections = kv.Sections[1:]
+	}
+	return kv
+}
+
+// splitPair splits line at the separator occurring first.
+func splitPair(line string, seps []string) (Pair, bool) {
+	i, sep := -1, ""
+	for _, s := range seps {
+		if j := strings.Index(line, s); j > 0 && (i < 0 || j < i) {
+			i, sep = j, s
+		}
+	}
+	if i < 0 {
+		return Pair{}, false
+	}
+	return Pair{Key: strings.TrimSpace(line[:i]), Value: strings.TrimSpace(line[i+len(sep):])}, true
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Section returns the first section named name, compared case-insensitively, or nil.
+func (kv *KV) Section(name string) *Section {
+	for i := range kv.Sections {
+		if strings.EqualFold(kv.Sections[i].Name, name) {
+			return &kv.Sections[i]
+		}
+	}
+	return nil
+}
+
+// Get returns the value of the first pair whose key equals key in any section.
+func (kv *KV) Get(key string) (string, bool) {
+	for i := range kv.Sections {
+		if v, ok := kv.Sections[i].Get(key); ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// Decode stores the pairs in v, a pointer to a struct. A field receives the value of the
+// key named by its "kv" tag, or else by its name, compared case-insensitively; a tag of
+// "-" skips the field. Fields of the top-level struct take the first pair with their key in
+// any section. A field of struct type, or slice of structs, is instead decoded from the
+// first section, or from every section, with its name. Values are converted as Table's
+// Decode does.
+func (kv *KV) Decode(v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("parse: Decode needs a pointer to a struct, got %T", v)
+	}
+	return kv.decode(rv.Elem(), kv.Sections)
+}
+
+// decode fills the struct v from the pairs of sections, and its struct fields from the
+// sections of kv.
+func (kv *KV) decode(v reflect.Value, sections []Section) error {
+	for name, index := range structFields(v.Type(), "kv") {
+		f := v.FieldByIndex(index)
+		switch {
+		case f.Kind() == reflect.Struct:
+			if s := kv.Section(name); s != nil {
+				if err := kv.decodeSection(f, s); err != nil {
+					return err
+				}
+			}
+		case f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.Struct:
+			for i := range kv.Sections {
+				if !strings.EqualFold(kv.Sections[i].Name, name) {
+					continue
+				}
+				elem := reflect.New(f.Type().Elem()).Elem()
+				if err := kv.decodeSection(elem, &kv.Sections[i]); err != nil {
+					return err
+				}
+				f.Set(reflect.Append(f, elem))
+			}
+		default:
+			for i := range sections {
+				if value, ok := sections[i].Get(name); ok {
+					if err := setValue(f, value); err != nil {
+						return fmt.Errorf("parse: key %q: %w", name, err)
+					}
+					break
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// decodeSection fills the scalar fields of v from the pairs of s.
+func (kv *KV) decodeSection(v reflect.Value, s *Section) error {
+	for name, index := range structFields(v.Type(), "kv") {
+		if value, ok := s.Get(name); ok {
+			if err := setValue(v.FieldByIndex(index), value); err != nil {
+				return fmt.Errorf("parse: section %q, key %q: %w", s.Name, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// DecodeKV parses key-value output and stores its pairs in v, as Decode does.
+func DecodeKV(data []byte, opts KVOptions, v any) error {
+	return ParseKV(data, opts).Decode(v)
+}
@@ -0,0 +1,117 @@
+package parse_test
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec/parse"
+)
+
+const smartctl = `smartctl 7.3 2022-02-28 r5338 [x86_64-linux-6.1.0] (local build)
+
+=== START OF INFORMATION SECTION ===
+Model Family:     Samsung based SSDs
+Device Model:     Samsung SSD 860 EVO 500GB
+User Capacity:    500107862016
+Rotation Rate:    Solid State Device
+SMART support is: Enabled
+Local Time is:    Mon Oct 17 10:00:00 2026 UTC
+`
+
+func TestParseKV(t *testing.T) {
+	kv := parse.ParseKV([]byte(smartctl), parse.KVOptions{Section: regexp.MustCompile(`^=== (.+) ===$`)})
+	if len(kv.Sections) != 1 || kv.Sections[0].Name != "START OF INFORMATION SECTION" {
+		t.Fatalf("Unexpected sections %+v", kv.Sections)
+	}
+	if v, _ := kv.Get("device model"); v != "Samsung SSD 860 EVO 500GB" {
+		t.Errorf("Expected the device model, got %q", v)
+	}
+	if v, _ := kv.Get("Local Time is"); v != "Mon Oct 17 10:00:00 2026 UTC" {
+		t.Errorf("Expected the value to keep its colons, got %q", v)
+	}
+
+	ini := parse.ParseKV([]byte("top = 1\n# comment\n[global]\nworkgroup = WG\nurl=http://host:80/x\n[share]\npath: /srv\n"), parse.KVOptions{})
+	expected := []parse.Section{
+		{Pairs: []parse.Pair{{"top", "1"}}},
+		{Name: "global", Pairs: []parse.Pair{{"workgroup", "WG"}, {"url", "http://host:80/x"}}},
+		{Name: "share", Pairs: []parse.Pair{{"path", "/srv"}}},
+	}
+	if !reflect.DeepEqual(ini.Sections, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, ini.Sections)
+	}
+	if m := ini.Section("Global").Map(); m["workgroup"] != "WG" {
+		t.Errorf("Unexpected section map %v", m)
+	}
+}
+
+const iscsiadm = `iSCSI Transport Class version 2.0-870
+version 2.1.8
+Target: iqn.2001-05.com.example:vol1 (non-flash)
+	Current Portal: 10.0.0.1:3260,1
+	Iface Name: default
+	iSCSI Connection State: LOGGED IN
+	Attached scsi disk sdb		State: running
+Target: iqn.2001-05.com.example:vol2 (non-flash)
+	Current Portal: 10.0.0.2:3260,1
+	Iface Name: default
+	iSCSI Connection State: LOGGED IN
+`
+
+type session struct {
+	Target string  `kv:"Target"`
+	Portal string  `kv:"Current Portal"`
+	State  string  `kv:"iSCSI Connection State"`
+	Iface  *string `kv:"Iface Name"`
+	Skip   string  `kv:"-"`
+}
+
+func TestDecodeKV(t *testing.T) {
+	var out struct {
+		Version  string
+		Sessions []session `kv:"Target"`
+	}
+	opts := parse.KVOptions{Section: regexp.MustCompile(`^(Target): `)}
+	if err := parse.DecodeKV([]byte(iscsiadm), opts, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Version != "" {
+		t.Errorf("Expected no version without separator, got %q", out.Version)
+	}
+	if len(out.Sessions) != 2 {
+		t.Fatalf("Expected 2 sessions, got %+v", out.Sessions)
+	}
+	if s := out.Sessions[1]; s.Target != "iqn.2001-05.com.example:vol2 (non-flash)" || s.Portal != "10.0.0.2:3260,1" || s.State != "LOGGED IN" || s.Iface == nil || *s.Iface != "default" {
+		t.Errorf("Unexpected session %+v", s)
+	}
+
+	var info struct {
+		Model    string `kv:"Device Model"`
+		Capacity uint64 `kv:"User Capacity"`
+		SMART    bool   `kv:"SMART support is"`
+	}
+	if err := parse.DecodeKV([]byte(smartctl), parse.KVOptions{}, &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Model != "Samsung SSD 860 EVO 500GB" || info.Capacity != 500107862016 || !info.SMART {
+		t.Errorf("Unexpected info %+v", info)
+	}
+
+	var bad struct {
+		Capacity int8 `kv:"User Capacity"`
+	}
+	if err := parse.DecodeKV([]byte(smartctl), parse.KVOptions{}, &bad); err == nil {
+		t.Error("Expected an error for an overflowing value")
+	}
+
+	var lun struct {
+		LUN   int  `kv:"lun"`
+		Month uint `kv:"month"`
+	}
+	if err := parse.DecodeKV([]byte("lun: 010\nmonth: 08\n"), parse.KVOptions{}, &lun); err != nil {
+		t.Fatal(err)
+	}
+	if lun.LUN != 10 || lun.Month != 8 {
+		t.Errorf("Expected decimal values 10 and 8, got %+v", lun)
+	}
+}
@@ -0,0 +1,200 @@
+package parse
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// ErrNoHeader is returned by ParseTable when no header line was found.
+var ErrNoHeader = errors.New("parse: table header not found")
+
+// TableOptions configures ParseTable.
+type TableOptions struct {
+	// Header matches the header line; the lines before it are skipped. If nil, the header
+	// is the first non-blank line.
+	Header *regexp.Regexp
+	// NoHeader says that the output has no header line, in which case Columns must be set.
+	NoHeader bool
+	// Columns names the columns in order. If set, the names must appear in the header line,
+	// which allows names containing spaces such as "Mounted on". If not, every
+	// whitespace-separated word of the header line names a column.
+	Columns []string
+	// FixedWidth splits rows at the offsets of the column names in the header line instead
+	// of at whitespace, for output with blank or multi-word values. Values of right-aligned
+	// columns may start before their name.
+	FixedWidth bool
+}
+
+// Table is tabular output split into columns.
+type Table struct {
+	Columns []string
+	Rows    [][]string
+}
+
+// ParseTable splits tabular output into rows of columns. Blank lines are skipped. Without
+// FixedWidth, the words of a row beyond the last column are part of the last column and
+// missing words are empty.
+func ParseTable(data []byte, opts TableOptions) (*Table, error) {
+	if opts.NoHeader && (len(opts.Columns) == 0 || opts.FixedWidth) {
+		return nil, errors.New("parse: a table without header needs Columns and cannot be fixed-width")
+	}
+	t := &Table{Columns: opts.Columns}
+	var offsets []int
+	found := opts.NoHeader
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, len(data)+1)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), " \t\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if !found {
+			if opts.Header != nil && !opts.Header.MatchString(line) {
+				continue
+			}
+			found = true
+			cols, offs, err := headerColumns(line, opts.Columns)
+			if err != nil {
+				return nil, err
+			}
+			t.Columns, offsets = cols, offs
+			continue
+		}
+		if opts.FixedWidth {
+			t.Rows = append(t.Rows, splitFixed(line, offsets))
+		} else {
+			t.Rows = append(t.Rows, splitFields(line, len(t.Columns)))
+		}
+	}
+	if !found {
+		return nil, ErrNoHeader
+	}
+	return t, nil
+}
+
+// headerColumns returns the names of the columns of a header line and their offsets.
+func headerColumns(line string, names []string) ([]string, []int, error) {
+	var offsets []int
+	if names == nil {
+		for i := 0; i < len(line); {
+			for i < len(line) && isBlank(line[i]) {
+				i++
+			}
+			start := i
+			for i < len(line) && !isBlank(line[i]) {
+				i++
+			}
+			names = append(names, line[start:i])
+			offsets = append(offsets, start)
+		}
+		return names, offsets, nil
+	}
+	pos := 0
+	for _, name := range names {
+		i := strings.Index(line[pos:], name)
+		if i < 0 {
+			return nil, nil, fmt.Errorf("parse: column %q not found in header %q", name, line)
+		}
+		offsets = append(offsets, pos+i)
+		pos += i + len(name)
+	}
+	return names, offsets, nil
+}
+
+func splitFields(line string, n int) []string {
+	row := make([]string, n)
+	rest := strings.TrimLeft(line, " \t")
+	for i := 0; i < n && rest != ""; i++ {
+		if i == n-1 {
+			row[i] = rest
+			break
+		}
+		end := strings.IndexAny(rest, " \t")
+		if end < 0 {
+			end = len(rest)
+		}
+		row[i] = rest[:end]
+		rest = strings.TrimLeft(rest[end:], " \t")
+	}
+	return row
+}
+
+func splitFixed(line string, offsets []int) []string {
+	row := make([]string, len(offsets))
+	bounds := make([]int, len(offsets)+1)
+	for i, off := range offsets {
+		b := off
+		// A value running into the column from the left belongs to it: move the boundary to
+		// the blank before the value.
+		for i > 0 && b > offsets[i-1]+1 && b < len(line) && !isBlank(line[b-1]) && !isBlank(line[b]) {
+			b--
+		}
+		bounds[i] = b
+	}
+	bounds[len(offsets)] = len(line)
+	for i := range offsets {
+		start, end := min(bounds[i], len(line)), min(bounds[i+1], len(line))
+		if start < end {
+			row[i] = strings.TrimSpace(line[start:end])
+		}
+	}
+	return row
+}
+
+func isBlank(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
+// Maps returns every row as a map from column names to values.
+func (t *Table) Maps() []map[string]string {
+	maps := make([]map[string]string, len(t.Rows))
+	for i, row := range t.Rows {
+		m := make(map[string]string, len(t.Columns))
+		for j, name := range t.Columns {
+			m[name] = row[j]
+		}
+		maps[i] = m
+	}
+	return maps
+}
+
+// Decode stores the rows in v, a pointer to a slice of structs. A struct field receives
+// the column named by its "table" tag, or else by its name, compared case-insensitively;
+// a tag of "-" skips the field. Fields may be strings, booleans, integers, floats or
+// pointers to them; empty values leave them unchanged and floats may end with "%".
+func (t *Table) Decode(v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Slice || rv.Elem().Type().Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("parse: Decode needs a pointer to a slice of structs, got %T", v)
+	}
+	slice := rv.Elem()
+	fields := structFields(slice.Type().Elem(), "table")
+	rows := reflect.MakeSlice(slice.Type(), len(t.Rows), len(t.Rows))
+	for i, row := range t.Rows {
+		for j, name := range t.Columns {
+			f, ok := fields[strings.ToLower(name)]
+			if !ok {
+				continue
+			}
+			if err := setValue(rows.Index(i).FieldByIndex(f), row[j]); err != nil {
+				return fmt.Errorf("parse: row %d, column %q: %w", i, name, err)
+			}
+		}
+	}
+	slice.Set(rows)
+	return nil
+}
+
+// DecodeTable parses tabular output and stores its rows in v, as Decode does.
+func DecodeTable(data []byte, opts TableOptions, v any) error {
+	t, err := ParseTable(data, opts)
+	if err != nil {
+		return err
+	}
+	return t.Decode(v)
+}
@@ -0,0 +1,159 @@
+package parse_test
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec/parse"
+)
+
+const df = `Filesystem     1K-blocks     Used Available Use% Mounted on
+/dev/sda1       41152736 12345678  26694212  32% /
+tmpfs            4017236        0   4017236   0% /dev/shm
+/dev/sdb1      960303520   123456 911335064   1% /srv/my data
+`
+
+func TestParseTable(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		opts     parse.TableOptions
+		expected *parse.Table
+	}{
+		{
+			name: "whitespace",
+			data: df,
+			opts: parse.TableOptions{Columns: []string{"Filesystem", "1K-blocks", "Used", "Available", "Use%", "Mounted on"}},
+			expected: &parse.Table{
+				Columns: []string{"Filesystem", "1K-blocks", "Used", "Available", "Use%", "Mounted on"},
+				Rows: [][]string{
+					{"/dev/sda1", "41152736", "12345678", "26694212", "32%", "/"},
+					{"tmpfs", "4017236", "0", "4017236", "0%", "/dev/shm"},
+					{"/dev/sdb1", "960303520", "123456", "911335064", "1%", "/srv/my data"},
+				},
+			},
+		},
+		{
+			name: "fixed width",
+			data: "NAME   FSTYPE LABEL   MOUNTPOINT\nsda\nsda1   ext4   my root /\nsda2   swap           [SWAP]\n",
+			opts: parse.TableOptions{FixedWidth: true},
+			expected: &parse.Table{
+				Columns: []string{"NAME", "FSTYPE", "LABEL", "MOUNTPOINT"},
+				Rows: [][]string{
+					{"sda", "", "", ""},
+					{"sda1", "ext4", "my root", "/"},
+					{"sda2", "swap", "", "[SWAP]"},
+				},
+			},
+		},
+		{
+			name: "fixed width right-aligned",
+			data: "Filesystem     1K-blocks Mounted on\n/dev/sda1      123456789 /\ntmpfs                  0 /run\n",
+			opts: parse.TableOptions{FixedWidth: true, Columns: []string{"Filesystem", "1K-blocks", "Mounted on"}},
+			expected: &parse.Table{
+				Columns: []string{"Filesystem", "1K-blocks", "Mounted on"},
+				Rows: [][]string{
+					{"/dev/sda1", "123456789", "/"},
+					{"tmpfs", "0", "/run"},
+				},
+			},
+		},
+		{
+			name: "header pattern",
+			data: "Warning: stale cache\n\n  Pool  Size Used\n  tank  10G  1G\n",
+			opts: parse.TableOptions{Header: regexp.MustCompile(`^\s*Pool\b`)},
+			expected: &parse.Table{
+				Columns: []string{"Pool", "Size", "Used"},
+				Rows:    [][]string{{"tank", "10G", "1G"}},
+			},
+		},
+		{
+			name: "no header",
+			data: "[0:0:0:0]    disk    ATA      Samsung SSD 860  RVT0  /dev/sda\n[1:0:0:0]    cd/dvd\n",
+			opts: parse.TableOptions{NoHeader: true, Columns: []string{"hctl", "type", "vendor", "rest"}},
+			expected: &parse.Table{
+				Columns: []string{"hctl", "type", "vendor", "rest"},
+				Rows: [][]string{
+					{"[0:0:0:0]", "disk", "ATA", "Samsung SSD 860  RVT0  /dev/sda"},
+					{"[1:0:0:0]", "cd/dvd", "", ""},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table, err := parse.ParseTable([]byte(tt.data), tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(table, tt.expected) {
+				t.Errorf("Expected %q, got %q", tt.expected, table)
+			}
+		})
+	}
+}
+
+func TestParseTableErrors(t *testing.T) {
+	if _, err := parse.ParseTable([]byte("\n\n"), parse.TableOptions{}); !errors.Is(err, parse.ErrNoHeader) {
+		t.Errorf("Expected ErrNoHeader, got %v", err)
+	}
+	if _, err := parse.ParseTable([]byte(df), parse.TableOptions{Columns: []string{"Filesystem", "Size"}}); err == nil {
+		t.Error("Expected an error for a missing column")
+	}
+	if _, err := parse.ParseTable([]byte(df), parse.TableOptions{NoHeader: true}); err == nil {
+		t.Error("Expected an error for a table without header nor columns")
+	}
+}
+
+type mount struct {
+	Filesystem string
+	Blocks     uint64   `table:"1K-blocks"`
+	Use        *float64 `table:"Use%"`
+	MountedOn  string   `table:"Mounted on"`
+	Ignored    string   `table:"-"`
+}
+
+func TestDecodeTable(t *testing.T) {
+	var mounts []mount
+	opts := parse.TableOptions{Columns: []string{"Filesystem", "1K-blocks", "Used", "Available", "Use%", "Mounted on"}}
+	if err := parse.DecodeTable([]byte(df), opts, &mounts); err != nil {
+		t.Fatal(err)
+	}
+	if len(mounts) != 3 {
+		t.Fatalf("Expected 3 mounts, got %d", len(mounts))
+	}
+	m := mounts[0]
+	if m.Filesystem != "/dev/sda1" || m.Blocks != 41152736 || m.Use == nil || *m.Use != 32 || m.MountedOn != "/" {
+		t.Errorf("Unexpected mount %+v", m)
+	}
+
+	err := parse.DecodeTable([]byte("Filesystem 1K-blocks\ntmpfs lots\n"), parse.TableOptions{}, &mounts)
+	if err == nil || err.Error() != `parse: row 0, column "1K-blocks": strconv.ParseUint: parsing "lots": invalid syntax` {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if err := parse.DecodeTable([]byte(df), opts, mounts); err == nil {
+		t.Error("Expected an error for a non-pointer target")
+	}
+
+	// Leading zeros, e.g. of dates and device numbers, are not octal.
+	var ids []struct {
+		Major int
+		Minor uint
+	}
+	if err := parse.DecodeTable([]byte("Major Minor\n010 08\n"), parse.TableOptions{}, &ids); err != nil {
+		t.Fatal(err)
+	}
+	if ids[0].Major != 10 || ids[0].Minor != 8 {
+		t.Errorf("Expected 10 and 8, got %+v", ids[0])
+	}
+}
+
+func TestTableMaps(t *testing.T) {
+	table, _ := parse.ParseTable([]byte("A B\n1 2\n"), parse.TableOptions{})
+	expected := []map[string]string{{"A": "1", "B": "2"}}
+	if !reflect.DeepEqual(table.Maps(), expected) {
+		t.Errorf("Expected %v, got %v", expected, table.Maps())
+	}
+}
@@ -0,0 +1,149 @@
+package pathcmd
+
+import (
+	"errors"
+	"io/fs"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// Config configures a Cache.
+type Config struct {
+	// TTL is how long a resolved path, or the failure to find a binary, is reused. Zero keeps
+	// entries until they are invalidated.
+	TTL time.Duration
+}
+
+// Cache remembers where binaries were found in PATH, so that running the same tools over
+// and over does not search every directory of PATH each time. Entries are keyed by the
+// binary name and the PATH of the command, so that commands with a different PATH resolve
+// on their own.
+type Cache struct {
+	cfg Config
+
+	mu      sync.Mutex
+	entries map[key]entry
+	hits    int64
+	misses  int64
+}
+
+type key struct {
+	name, path string
+}
+
+type entry struct {
+	resolved string
+	err      error
+	expires  time.Time
+}
+
+// maxEntries bounds the number of entries kept before expired ones are dropped.
+const maxEntries = 1024
+
+// NewCache creates a Cache from cfg.
+func NewCache(cfg Config) *Cache {
+	return &Cache{cfg: cfg, entries: make(map[key]entry)}
+}
+
+// Invalidate drops the entries of the binary name, for every PATH, e.g. after a package
+// providing it was installed or removed.
+func (c *Cache) Invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k := range c.entries {
+		if k.name == name {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// InvalidateAll drops every entry.
+func (c *Cache) InvalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[key]entry)
+}
+
+// Stats returns the number of cache hits and misses so far.
+func (c *Cache) Stats() (hits, misses int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+// LookPath is cdsexec.LookPath answered from c while the entry is fresh. now is the time
+// the freshness of entries is measured against.
+func (c *Cache) LookPath(name, path string, now time.Time) (string, error) {
+	k := key{name: name, path: path}
+	c.mu.Lock()
+	if e, ok := c.entries[k]; ok && (e.expires.IsZero() || now.Before(e.expires)) {
+		c.hits++
+		c.mu.Unlock()
+		return e.resolved, e.err
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	resolved, err := cdsexec.LookPath(name, path)
+	e := entry{resolved: resolved, err: err}
+	if c.cfg.TTL > 0 {
+		e.expires = now.Add(c.cfg.TTL)
+	}
+	c.mu.Lock()
+	if len(c.entries) >= maxEntries {
+		c.pruneLocked(now)
+	}
+	c.entries[k] = e
+	c.mu.Unlock()
+	return resolved, err
+}
+
+func (c *Cache) pruneLocked(now time.Time) {
+	for k, e := range c.entries {
+		if !e.expires.IsZero() && !now.Before(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	if len(c.entries) >= maxEntries {
+		c.entries = make(map[key]entry)
+	}
+}
+
+// Wrap returns a CommandConstructor running the binaries of commands built by base from
+// the path resolved through c. Names containing a path separator are left alone. A binary
+// that cannot be found fails the command with the *exec.Error exec.LookPath returns, without
+// reaching base. The entry of a binary that has disappeared since it was resolved is
+// invalidated, so that the next command searches PATH again.
+//
+// The commands see the resolved path as their argv[0], as if they had been run by it.
+func (c *Cache) Wrap(base cdsexec.CommandConstructor) cdsexec.CommandConstructor {
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		name := inv.Spec.Name
+		if name == "" || strings.ContainsRune(name, '/') || strings.ContainsRune(name, filepath.Separator) {
+			return next(inv)
+		}
+		path, _ := cdsexec.LookupEnv(inv.Spec.Env, "PATH")
+		resolved, err := c.LookPath(name, path, cdsexec.ClockFromContext(inv.Ctx).Now())
+		if err != nil {
+			return err
+		}
+		inv.Spec.Name = resolved
+		err = next(inv)
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, exec.ErrNotFound) {
+			c.mu.Lock()
+			delete(c.entries, key{name: name, path: path})
+			c.mu.Unlock()
+		}
+		return err
+	})
+}
+
+// New is a shorthand for NewCache followed by Wrap.
+func New(base cdsexec.CommandConstructor, cfg Config) cdsexec.CommandConstructor {
+	return NewCache(cfg).Wrap(base)
+}
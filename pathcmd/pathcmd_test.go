@@ -0,0 +1,127 @@
+package pathcmd_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+	"github.com/cirrusdata/cdsexec/pathcmd"
+)
+
+// installTool writes an executable script named name into dir.
+func installTool(t *testing.T, dir, name string) string {
+	t.Helper()
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, []byte("#!/bin/sh\necho "+name+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestCacheResolvesOnce(t *testing.T) {
+	bin := t.TempDir()
+	zpool := installTool(t, bin, "zpool")
+	env := []string{"PATH=" + t.TempDir() + string(filepath.ListSeparator) + bin}
+
+	var names []string
+	base := mockcmd.MakeMockCmdWithOutput("ok", func(m *mockcmd.MockCmd) error {
+		names = append(names, m.Name)
+		return nil
+	})
+	clock := mockcmd.NewFakeClock(time.Unix(0, 0))
+	ctx := cdsexec.ContextWithClock(context.Background(), clock)
+	c := pathcmd.NewCache(pathcmd.Config{TTL: time.Minute})
+	ctor := c.Wrap(base)
+
+	for i := 0; i < 3; i++ {
+		cmd := ctor(ctx, "zpool", "list")
+		cmd.SetEnv(env)
+		if _, err := cmd.Output(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	for _, name := range names {
+		if name != zpool {
+			t.Errorf("Expected %s to run, got %s", zpool, name)
+		}
+	}
+	if hits, misses := c.Stats(); hits != 2 || misses != 1 {
+		t.Errorf("Expected 2 hits and 1 miss, got %d and %d", hits, misses)
+	}
+
+	clock.Advance(time.Minute)
+	if _, err := c.LookPath("zpool", env[0][len("PATH="):], clock.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, misses := c.Stats(); misses != 2 {
+		t.Errorf("Expected the expired entry to be resolved again, got %d misses", misses)
+	}
+
+	// Another PATH is another entry.
+	c.LookPath("zpool", bin, clock.Now())
+	if _, misses := c.Stats(); misses != 3 {
+		t.Errorf("Expected a miss for another PATH, got %d misses", misses)
+	}
+	c.Invalidate("zpool")
+	c.LookPath("zpool", bin, clock.Now())
+	if _, misses := c.Stats(); misses != 4 {
+		t.Errorf("Expected a miss after Invalidate, got %d misses", misses)
+	}
+}
+
+func TestCacheNotFound(t *testing.T) {
+	bin := t.TempDir()
+	env := []string{"PATH=" + bin}
+	c := pathcmd.NewCache(pathcmd.Config{})
+	ctor := c.Wrap(cdsexec.CommandContext)
+
+	for i := 0; i < 2; i++ {
+		cmd := ctor(context.Background(), "sg_inq")
+		cmd.SetEnv(env)
+		if err := cmd.Run(); !errors.Is(err, exec.ErrNotFound) {
+			t.Fatalf("Expected exec.ErrNotFound, got %v", err)
+		}
+	}
+	if hits, misses := c.Stats(); hits != 1 || misses != 1 {
+		t.Errorf("Expected the failure to be cached, got %d hits and %d misses", hits, misses)
+	}
+
+	installTool(t, bin, "sg_inq")
+	c.InvalidateAll()
+	cmd := ctor(context.Background(), "sg_inq")
+	cmd.SetEnv(env)
+	if out, err := cmd.Output(); err != nil || string(out) != "sg_inq\n" {
+		t.Errorf("Expected the installed binary to run, got %q, %v", out, err)
+	}
+}
+
+func TestCacheRemovedBinary(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	installTool(t, first, "lsscsi")
+	moved := installTool(t, second, "lsscsi")
+	env := []string{"PATH=" + first + string(filepath.ListSeparator) + second}
+	c := pathcmd.NewCache(pathcmd.Config{})
+	ctor := c.Wrap(cdsexec.CommandContext)
+
+	run := func() error {
+		cmd := ctor(context.Background(), "lsscsi")
+		cmd.SetEnv(env)
+		return cmd.Run()
+	}
+	if err := run(); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(first, "lsscsi"))
+	if err := run(); err == nil {
+		t.Fatalf("Expected the removed binary to fail")
+	}
+	if err := run(); err != nil {
+		t.Errorf("Expected %s to be found after the failure, got %v", moved, err)
+	}
+}
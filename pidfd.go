@@ -0,0 +1,72 @@
+package cdsexec
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// Pidfd returns a pidfd referring to the process of cmd, which becomes readable when the
+// process exits, for callers waiting for it along with other file descriptors, e.g. with
+// epoll. The caller owns the file and must close it. Pidfd fails with os.ErrProcessDone if
+// the process has exited, and with errors.ErrUnsupported on systems other than Linux and
+// on kernels older than 5.3.
+//
+// Commands of CommandContext started with Start, through Wrap or not, and processes of
+// AdoptProcess hold a pidfd from the time they start or are adopted, which Pidfd
+// duplicates. For the processes of other commands, a pidfd is opened from the PID, which
+// only refers to the right process if the command is not waited for concurrently.
+func Pidfd(cmd Commander) (*os.File, error) {
+	var f *os.File
+	var err error
+	if held := heldPidfd(cmd); held != nil {
+		f, err = dupPidfd(held)
+	} else if p := cmd.Process(); p == nil {
+		err = errNotStarted
+	} else if cmd.ProcessState() != nil {
+		err = os.ErrProcessDone
+	} else {
+		f, err = openPidfd(p.Pid)
+	}
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil, fmt.Errorf("cdsexec: pidfd: %w on %s", err, runtime.GOOS)
+	}
+	return f, err
+}
+
+// Signal sends sig to the process of cmd. For the commands and processes holding a pidfd,
+// as described by Pidfd, the signal is sent through the pidfd, so that it never reaches
+// another process reusing the PID of one that has exited and been waited for. Otherwise, it
+// is sent with the Signal method of the process. Signal fails with os.ErrProcessDone if the
+// process has exited.
+func Signal(cmd Commander, sig os.Signal) error {
+	if held := heldPidfd(cmd); held != nil {
+		if err := pidfdSignal(held, sig); !errors.Is(err, errors.ErrUnsupported) {
+			return err
+		}
+	}
+	p := cmd.Process()
+	if p == nil {
+		return errNotStarted
+	}
+	return p.Signal(sig)
+}
+
+// heldPidfd returns the pidfd held by cmd, or by the command it wraps, if any.
+func heldPidfd(cmd Commander) *os.File {
+	for {
+		switch c := cmd.(type) {
+		case *Cmd:
+			return c.pidfd.Load()
+		case *adoptedProcess:
+			return c.pidfd
+		case *wrappedCmd:
+			if cmd = c.inv.Commander(); cmd == nil {
+				return nil
+			}
+		default:
+			return nil
+		}
+	}
+}
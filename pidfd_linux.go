@@ -0,0 +1,117 @@
+package cdsexec
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// The pidfd system calls have the same numbers on every architecture.
+const (
+	sysPidfdSendSignal = 424
+	sysPidfdOpen       = 434
+)
+
+// openPidfd returns a non-blocking pidfd referring to the process pid, registered with the
+// runtime poller, which tells when it is readable, i.e. when the process has exited. It
+// fails with os.ErrProcessDone if there is no such process, and with errors.ErrUnsupported
+// if the kernel has no pidfd, as before Linux 5.3, or the call is filtered, as by some
+// container runtimes. The pidfd is closed on exec.
+func openPidfd(pid int) (*os.File, error) {
+	fd, _, errno := syscall.Syscall(sysPidfdOpen, uintptr(pid), 0, 0)
+	switch errno {
+	case 0:
+	case syscall.ESRCH:
+		return nil, os.ErrProcessDone
+	case syscall.ENOSYS, syscall.EPERM:
+		return nil, errors.ErrUnsupported
+	default:
+		return nil, os.NewSyscallError("pidfd_open", errno)
+	}
+	if err := syscall.SetNonblock(int(fd), true); err != nil {
+		syscall.Close(int(fd))
+		return nil, os.NewSyscallError("fcntl", err)
+	}
+	return os.NewFile(fd, "pidfd:"+strconv.Itoa(pid)), nil
+}
+
+// pidfdSignal sends sig to the process pidfd refers to. It fails with os.ErrProcessDone
+// if the process has exited or pidfd is closed, and with errors.ErrUnsupported if sig is
+// not a syscall.Signal.
+func pidfdSignal(pidfd *os.File, sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	var errno syscall.Errno
+	if err := control(pidfd, func(fd uintptr) {
+		_, _, errno = syscall.Syscall6(sysPidfdSendSignal, fd, uintptr(s), 0, 0, 0, 0)
+	}); err != nil {
+		return err
+	}
+	switch errno {
+	case 0:
+		return nil
+	case syscall.ESRCH:
+		return os.ErrProcessDone
+	}
+	return os.NewSyscallError("pidfd_send_signal", errno)
+}
+
+// dupPidfd returns a duplicate of pidfd, closed on exec, for the caller to own.
+func dupPidfd(pidfd *os.File) (*os.File, error) {
+	var nfd uintptr
+	var errno syscall.Errno
+	if err := control(pidfd, func(fd uintptr) {
+		nfd, _, errno = syscall.Syscall(syscall.SYS_FCNTL, fd, syscall.F_DUPFD_CLOEXEC, 0)
+	}); err != nil {
+		return nil, err
+	}
+	if errno != 0 {
+		return nil, os.NewSyscallError("fcntl", errno)
+	}
+	return os.NewFile(nfd, pidfd.Name()), nil
+}
+
+// control calls f with the descriptor of pidfd, failing with os.ErrProcessDone if pidfd is
+// closed, which it only is once the process has been waited for.
+func control(pidfd *os.File, f func(fd uintptr)) error {
+	rc, err := pidfd.SyscallConn()
+	if err == nil {
+		err = rc.Control(f)
+	}
+	if err != nil {
+		return os.ErrProcessDone
+	}
+	return nil
+}
+
+// pollFd is struct pollfd.
+type pollFd struct {
+	fd      int32
+	events  int16
+	revents int16
+}
+
+const pollIn = 0x1
+
+// readable reports whether fd is readable, without blocking.
+func readable(fd uintptr) bool {
+	pfd := pollFd{fd: int32(fd), events: pollIn}
+	var ts syscall.Timespec
+	n, _, errno := syscall.Syscall6(syscall.SYS_PPOLL, uintptr(unsafe.Pointer(&pfd)), 1, uintptr(unsafe.Pointer(&ts)), 0, 0, 0)
+	return errno == 0 && n == 1 && pfd.revents&pollIn != 0
+}
+
+// waitPidfd waits for the process pidfd refers to to exit.
+func waitPidfd(pidfd *os.File) error {
+	rc, err := pidfd.SyscallConn()
+	if err != nil {
+		return err
+	}
+	// The poller only reports the pidfd becoming readable after the first call, so the
+	// first call checks whether the process has already exited.
+	return rc.Read(readable)
+}
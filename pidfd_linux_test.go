@@ -0,0 +1,52 @@
+package cdsexec_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// pollReadable waits up to 5 seconds for f to be readable.
+func pollReadable(t *testing.T, f *os.File) bool {
+	t.Helper()
+	pfd := struct {
+		fd              int32
+		events, revents int16
+	}{fd: int32(f.Fd()), events: 1}
+	ts := syscall.Timespec{Sec: 5}
+	n, _, errno := syscall.Syscall6(syscall.SYS_PPOLL, uintptr(unsafe.Pointer(&pfd)), 1, uintptr(unsafe.Pointer(&ts)), 0, 0, 0)
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	return n == 1
+}
+
+func TestPidfd(t *testing.T) {
+	cmd := cdsexec.CommandContext(context.Background(), "sleep", "30")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := cdsexec.Pidfd(cmd)
+	if errors.Is(err, errors.ErrUnsupported) {
+		cmd.Process().Kill()
+		cmd.Wait()
+		t.Skip("pidfd not supported by the kernel")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cmd.Process().Kill()
+	if !pollReadable(t, f) {
+		t.Error("Expected the pidfd to be readable once the process exited")
+	}
+	cmd.Wait()
+	if _, err := cdsexec.Pidfd(cmd); !errors.Is(err, os.ErrProcessDone) {
+		t.Errorf("Expected no pidfd once the command is waited for, got %v", err)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package cdsexec
+
+import (
+	"errors"
+	"os"
+)
+
+func openPidfd(int) (*os.File, error) { return nil, errors.ErrUnsupported }
+
+func pidfdSignal(*os.File, os.Signal) error { return errors.ErrUnsupported }
+
+func dupPidfd(*os.File) (*os.File, error) { return nil, errors.ErrUnsupported }
@@ -0,0 +1,42 @@
+package cdsexec_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+func TestSignal(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals cannot be sent on Windows")
+	}
+	passthrough := cdsexec.Wrap(cdsexec.CommandContext, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		return next(inv)
+	})
+	for name, ctor := range map[string]cdsexec.CommandConstructor{"direct": cdsexec.CommandContext, "wrapped": passthrough} {
+		t.Run(name, func(t *testing.T) {
+			cmd := ctor(context.Background(), "sleep", "30")
+			if err := cdsexec.Signal(cmd, syscall.SIGTERM); err == nil {
+				t.Error("Expected signaling a command not started to fail")
+			}
+			if err := cmd.Start(); err != nil {
+				t.Fatal(err)
+			}
+			if err := cdsexec.Signal(cmd, syscall.SIGTERM); err != nil {
+				t.Fatal(err)
+			}
+			var ee interface{ ExitCode() int }
+			if err := cmd.Wait(); !errors.As(err, &ee) || ee.ExitCode() != -1 {
+				t.Errorf("Expected the command to be terminated by the signal, got %v", err)
+			}
+			if err := cdsexec.Signal(cmd, syscall.SIGTERM); !errors.Is(err, os.ErrProcessDone) {
+				t.Errorf("Expected signaling a command waited for to fail, got %v", err)
+			}
+		})
+	}
+}
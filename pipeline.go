@@ -0,0 +1,319 @@
+package cdsexec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// CmdPipeline connects the standard output of each of its commands to the standard input
+// of the next, like a shell pipeline. Build it with Pipeline.
+type CmdPipeline struct {
+	ctx    context.Context
+	ctor   CommandConstructor
+	stages []CommandSpec
+	opts   []*StageOptions
+
+	stdin   io.Reader
+	stdout  io.Writer
+	stderr  io.Writer
+	ran     bool
+	results []Result
+}
+
+// Pipeline returns an empty pipeline whose commands are constructed by ctor with ctx.
+func Pipeline(ctx context.Context, ctor CommandConstructor) *CmdPipeline {
+	return &CmdPipeline{ctx: ctx, ctor: ctor}
+}
+
+// Cmd appends a command to the pipeline.
+func (p *CmdPipeline) Cmd(name string, arg ...string) *CmdPipeline {
+	return p.Spec(CommandSpec{Name: name, Args: arg})
+}
+
+// Spec appends a command with its own directory and environment to the pipeline. The
+// standard streams of spec are ignored.
+func (p *CmdPipeline) Spec(spec CommandSpec) *CmdPipeline {
+	p.stages = append(p.stages, spec)
+	p.opts = append(p.opts, nil)
+	return p
+}
+
+// StderrMode says what a pipeline does with the standard error of one of its commands.
+type StderrMode int
+
+const (
+	// StderrCapture collects the standard error in the Result of the command.
+	StderrCapture StderrMode = iota
+	// StderrTee collects the standard error and also copies it to the writer set with
+	// SetStderr.
+	StderrTee
+	// StderrDiscard drops the standard error.
+	StderrDiscard
+)
+
+// StageOptions configures how a pipeline handles one of its commands.
+type StageOptions struct {
+	Stderr StderrMode
+	// MaxStderr bounds the standard error collected to its last MaxStderr bytes, where
+	// failures are usually reported. 0 means unbounded.
+	MaxStderr int
+}
+
+// With sets the options of the command appended last. Commands without options have
+// their standard error collected, or written to the writer set with SetStderr if any.
+func (p *CmdPipeline) With(opts StageOptions) *CmdPipeline {
+	if len(p.stages) > 0 {
+		p.opts[len(p.stages)-1] = &opts
+	}
+	return p
+}
+
+// SetStdin sets the standard input of the first command.
+func (p *CmdPipeline) SetStdin(in io.Reader) *CmdPipeline {
+	p.stdin = in
+	return p
+}
+
+// SetStdout sets the standard output of the last command.
+func (p *CmdPipeline) SetStdout(out io.Writer) *CmdPipeline {
+	p.stdout = out
+	return p
+}
+
+// SetStderr sets the standard error of every command without options and of those set
+// to StderrTee, which must then be safe for concurrent writes. By default the standard
+// error of each command is collected in its Result.
+func (p *CmdPipeline) SetStderr(out io.Writer) *CmdPipeline {
+	p.stderr = out
+	return p
+}
+
+// Output runs the pipeline and returns the standard output of the last command.
+func (p *CmdPipeline) Output() ([]byte, error) {
+	if p.stdout != nil {
+		return nil, errors.New("exec: Stdout already set")
+	}
+	var name string
+	if len(p.stages) > 0 {
+		name = p.stages[len(p.stages)-1].Name
+	}
+	b := getBuffer(name)
+	p.stdout = b
+	err := p.Run()
+	return detachBuffer(name, b), err
+}
+
+// Run starts every command of the pipeline and waits for all of them to exit. It returns
+// nil if all succeeded and a *PipelineError otherwise. If a command cannot be started, the
+// commands already started are cancelled through their context.
+//
+// Commands are connected by OS pipes, so that local processes exchange data directly
+// through the kernel, without being copied by this process. A command exiting early closes
+// its input, so that the commands writing to it fail instead of blocking, as with SIGPIPE
+// in a shell.
+func (p *CmdPipeline) Run() error {
+	if p.ran {
+		return errors.New("cdsexec: pipeline already ran")
+	}
+	p.ran = true
+	if len(p.stages) == 0 {
+		return errors.New("cdsexec: empty pipeline")
+	}
+	ctx, cancel := context.WithCancel(p.ctx)
+	defer cancel()
+	clock := ClockFromContext(p.ctx)
+
+	n := len(p.stages)
+	cmds := make([]Commander, n)
+	// inputs[i] and outputs[i] are the ends of the pipes of the command i held by this
+	// process, closed once the command has exited.
+	inputs := make([]*os.File, n)
+	outputs := make([]*os.File, n)
+	stderrs := make([]stderrBuffer, n)
+	p.results = make([]Result, n)
+	errs := make([]error, n)
+
+	started := 0
+	var startErr error
+	for i, spec := range p.stages {
+		spec.Stdin, spec.Stdout = nil, nil
+		stderrs[i], spec.Stderr = p.stderrOf(i)
+		if i == 0 {
+			spec.Stdin = p.stdin
+		} else {
+			spec.Stdin = inputs[i]
+		}
+		if i == n-1 {
+			spec.Stdout = p.stdout
+		} else {
+			r, w, err := os.Pipe()
+			if err != nil {
+				startErr = fmt.Errorf("cdsexec: pipeline stage %d (%s): %w", i, spec.Name, err)
+				break
+			}
+			inputs[i+1], outputs[i] = r, w
+			spec.Stdout = w
+		}
+		cmd := spec.Command(ctx, p.ctor)
+		cmds[i] = cmd
+		p.results[i] = Result{Name: spec.Name, Args: spec.Args, Dir: spec.Dir, Env: spec.Env, StartTime: clock.Now()}
+		if err := cmd.Start(); err != nil {
+			startErr = fmt.Errorf("cdsexec: pipeline stage %d (%s): %w", i, spec.Name, err)
+			break
+		}
+		started++
+	}
+	if startErr != nil {
+		cancel()
+		for i := started; i < n; i++ {
+			closeFile(inputs[i])
+			closeFile(outputs[i])
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < started; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = cmds[i].Wait()
+			p.results[i].Duration = clock.Now().Sub(p.results[i].StartTime)
+			closeFile(inputs[i])
+			closeFile(outputs[i])
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < started; i++ {
+		p.results[i].ExitCode = exitCodeOf(cmds[i].ProcessState(), errs[i])
+		if stderrs[i] != nil {
+			p.results[i].Stderr = stderrs[i].Bytes()
+		}
+	}
+	if startErr != nil {
+		return startErr
+	}
+	for _, err := range errs {
+		if err != nil {
+			pe := &PipelineError{Names: p.names(), Errs: errs, Stderr: make([][]byte, n)}
+			for i, r := range p.results {
+				pe.Stderr[i] = r.Stderr
+			}
+			return pe
+		}
+	}
+	return nil
+}
+
+func closeFile(f *os.File) {
+	if f != nil {
+		f.Close()
+	}
+}
+
+// Results returns the result of every command once the pipeline has run, timed on the Clock
+// of the context of the pipeline. The exit codes of commands that were not started are 0.
+func (p *CmdPipeline) Results() []Result {
+	return p.results
+}
+
+// ExitCodes returns the exit code of every command once the pipeline has run.
+func (p *CmdPipeline) ExitCodes() []int {
+	codes := make([]int, len(p.results))
+	for i, r := range p.results {
+		codes[i] = r.ExitCode
+	}
+	return codes
+}
+
+// stderrBuffer collects the standard error of a command.
+type stderrBuffer interface {
+	io.Writer
+	Bytes() []byte
+}
+
+// stderrOf returns the buffer collecting the standard error of the command i, if any, and
+// the writer to set as its standard error.
+func (p *CmdPipeline) stderrOf(i int) (stderrBuffer, io.Writer) {
+	opts := p.opts[i]
+	if opts == nil {
+		if p.stderr != nil {
+			return nil, p.stderr
+		}
+		opts = &StageOptions{}
+	}
+	if opts.Stderr == StderrDiscard {
+		return nil, io.Discard
+	}
+	var buf stderrBuffer = new(bytes.Buffer)
+	if opts.MaxStderr > 0 {
+		buf = NewRingBuffer(opts.MaxStderr)
+	}
+	if opts.Stderr == StderrTee && p.stderr != nil {
+		return buf, io.MultiWriter(buf, p.stderr)
+	}
+	return buf, buf
+}
+
+func (p *CmdPipeline) names() []string {
+	names := make([]string, len(p.stages))
+	for i, s := range p.stages {
+		names[i] = s.Name
+	}
+	return names
+}
+
+// PipelineError reports the commands of a pipeline that failed.
+type PipelineError struct {
+	// Names are the names of the commands of the pipeline.
+	Names []string
+	// Errs holds the error of every command, nil for those that succeeded.
+	Errs []error
+	// Stderr holds the standard error collected from every command, nil for those whose
+	// standard error was written elsewhere or discarded.
+	Stderr [][]byte
+}
+
+// Failed returns the indexes of the commands that failed.
+func (e *PipelineError) Failed() []int {
+	var failed []int
+	for i, err := range e.Errs {
+		if err != nil {
+			failed = append(failed, i)
+		}
+	}
+	return failed
+}
+
+func (e *PipelineError) Error() string {
+	var parts []string
+	for i, err := range e.Errs {
+		if err != nil {
+			part := fmt.Sprintf("stage %d (%s): %v", i, e.Names[i], err)
+			if i < len(e.Stderr) {
+				if excerpt := stderrExcerpt(e.Stderr[i]); excerpt != "" {
+					part += ": " + excerpt
+				}
+			}
+			parts = append(parts, part)
+		}
+	}
+	return "cdsexec: pipeline failed: " + strings.Join(parts, "; ")
+}
+
+// Unwrap returns the errors of the failed commands from the last to the first, so that
+// ExitCode reports the exit code of the last failed command, like pipefail in a shell.
+func (e *PipelineError) Unwrap() []error {
+	var errs []error
+	for i := len(e.Errs) - 1; i >= 0; i-- {
+		if e.Errs[i] != nil {
+			errs = append(errs, e.Errs[i])
+		}
+	}
+	return errs
+}
@@ -0,0 +1,178 @@
+package cdsexec_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+func TestPipeline(t *testing.T) {
+	tests := []struct {
+		name     string
+		build    func(p *cdsexec.CmdPipeline) *cdsexec.CmdPipeline
+		expected string
+		codes    []int
+	}{
+		{
+			name: "three stages",
+			build: func(p *cdsexec.CmdPipeline) *cdsexec.CmdPipeline {
+				return p.Cmd("printf", "b\\na\\nb\\n").Cmd("sort").Cmd("uniq", "-c")
+			},
+			expected: "      1 a\n      2 b\n",
+			codes:    []int{0, 0, 0},
+		},
+		{
+			name: "stdin",
+			build: func(p *cdsexec.CmdPipeline) *cdsexec.CmdPipeline {
+				return p.SetStdin(strings.NewReader("tank/vol1\n")).Cmd("cat").Cmd("tr", "a-z", "A-Z")
+			},
+			expected: "TANK/VOL1\n",
+			codes:    []int{0, 0},
+		},
+		{
+			name: "early exit of the reader",
+			build: func(p *cdsexec.CmdPipeline) *cdsexec.CmdPipeline {
+				return p.Cmd("yes").Cmd("head", "-n", "1")
+			},
+			expected: "y\n",
+			codes:    []int{-1, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.build(cdsexec.Pipeline(context.Background(), cdsexec.CommandContext))
+			out, _ := p.Output()
+			if string(out) != tt.expected {
+				t.Errorf("Expected output %q, got %q", tt.expected, out)
+			}
+			if codes := p.ExitCodes(); !reflect.DeepEqual(codes, tt.codes) {
+				t.Errorf("Expected exit codes %v, got %v", tt.codes, codes)
+			}
+		})
+	}
+}
+
+func TestPipelineError(t *testing.T) {
+	p := cdsexec.Pipeline(context.Background(), cdsexec.CommandContext).
+		Cmd("sh", "-c", "echo 'cannot open pool' >&2; exit 2").
+		Cmd("sh", "-c", "cat; exit 3").
+		Cmd("cat")
+	err := p.Run()
+
+	var pe *cdsexec.PipelineError
+	if !errors.As(err, &pe) {
+		t.Fatalf("Expected *PipelineError, got %v", err)
+	}
+	if cdsexec.ExitCode(err) != 3 {
+		t.Errorf("Expected the exit code of the last failed stage, got %d", cdsexec.ExitCode(err))
+	}
+	if want := []int{2, 3, 0}; !reflect.DeepEqual(p.ExitCodes(), want) {
+		t.Errorf("Expected exit codes %v, got %v", want, p.ExitCodes())
+	}
+	if stderr := string(p.Results()[0].Stderr); stderr != "cannot open pool\n" {
+		t.Errorf("Expected the stderr of the first stage, got %q", stderr)
+	}
+	if err.Error() != "cdsexec: pipeline failed: stage 0 (sh): exit status 2: cannot open pool; stage 1 (sh): exit status 3" {
+		t.Errorf("Unexpected message %q", err.Error())
+	}
+}
+
+func TestPipelineStartFailure(t *testing.T) {
+	start := time.Now()
+	err := cdsexec.Pipeline(context.Background(), cdsexec.CommandContext).
+		Cmd("sleep", "5").
+		Cmd("cdsexec-no-such-binary").
+		Run()
+	if err == nil || !strings.Contains(err.Error(), "stage 1 (cdsexec-no-such-binary)") {
+		t.Errorf("Expected a start error for stage 1, got %v", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Error("Expected the started stages to be cancelled")
+	}
+}
+
+func TestPipelineStream(t *testing.T) {
+	ctor := cdsexec.StreamConstructor(upper)
+	out, err := cdsexec.Pipeline(context.Background(), func(ctx context.Context, name string, arg ...string) cdsexec.Commander {
+		if name == "upper" {
+			return ctor(ctx, name, arg...)
+		}
+		return cdsexec.CommandContext(ctx, name, arg...)
+	}).Cmd("echo", "zfs recv").Cmd("upper").Cmd("cat").Output()
+	if err != nil || string(out) != "ZFS RECV\n" {
+		t.Errorf("Expected %q, got %q, %v", "ZFS RECV\n", out, err)
+	}
+}
+
+func TestPipelineStderrPolicy(t *testing.T) {
+	var tee bytes.Buffer
+	p := cdsexec.Pipeline(context.Background(), cdsexec.CommandContext).
+		Cmd("sh", "-c", "echo noise >&2; echo data").With(cdsexec.StageOptions{Stderr: cdsexec.StderrDiscard}).
+		Cmd("sh", "-c", "cat >/dev/null; echo progress >&2").With(cdsexec.StageOptions{Stderr: cdsexec.StderrTee}).
+		Cmd("sh", "-c", "echo 0123456789 >&2; exit 4").With(cdsexec.StageOptions{MaxStderr: 4}).
+		SetStderr(&tee)
+	err := p.Run()
+
+	var pe *cdsexec.PipelineError
+	if !errors.As(err, &pe) {
+		t.Fatalf("Expected *PipelineError, got %v", err)
+	}
+	if want := []int{2}; !reflect.DeepEqual(pe.Failed(), want) {
+		t.Errorf("Expected failed stages %v, got %v", want, pe.Failed())
+	}
+	if want := [][]byte{nil, []byte("progress\n"), []byte("789\n")}; !reflect.DeepEqual(pe.Stderr, want) {
+		t.Errorf("Expected stderr %q, got %q", want, pe.Stderr)
+	}
+	if tee.String() != "progress\n" {
+		t.Errorf("Expected only the teed stage on stderr, got %q", tee.String())
+	}
+}
+
+func TestPipelineMixedBackends(t *testing.T) {
+	// A stage of a non-local backend copies between the pipes of its neighbours itself.
+	remote := cdsexec.StreamConstructor(func(ctx context.Context, spec cdsexec.CommandSpec) error {
+		_, err := cdsexec.Copy(spec.Stdout, spec.Stdin)
+		return err
+	})
+	ctor := func(ctx context.Context, name string, arg ...string) cdsexec.Commander {
+		if name == "remote-cat" {
+			return remote(ctx, name, arg...)
+		}
+		return cdsexec.Wrap(cdsexec.CommandContext, passThrough)(ctx, name, arg...)
+	}
+	var out bytes.Buffer
+	err := cdsexec.Pipeline(context.Background(), ctor).
+		Cmd("head", "-c", "10000000", "/dev/zero").
+		Cmd("remote-cat").
+		Cmd("wc", "-c").
+		SetStdout(&out).
+		Run()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := strings.TrimSpace(out.String()); got != "10000000" {
+		t.Errorf("Expected 10000000 bytes, got %s", got)
+	}
+}
+
+func TestPipelineClock(t *testing.T) {
+	clock := mockcmd.NewFakeClock(time.Unix(1700000000, 0))
+	slow := cdsexec.StreamConstructor(func(ctx context.Context, spec cdsexec.CommandSpec) error {
+		clock.Advance(time.Second)
+		return nil
+	})
+	p := cdsexec.Pipeline(cdsexec.ContextWithClock(context.Background(), clock), slow).Cmd("zpool", "sync")
+	if err := p.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if r := p.Results()[0]; !r.StartTime.Equal(time.Unix(1700000000, 0)) || r.Duration != time.Second {
+		t.Errorf("Expected the command timed on the clock of the context, got %s for %s", r.StartTime, r.Duration)
+	}
+}
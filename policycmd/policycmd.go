@@ -0,0 +1,144 @@
+package policycmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// ErrDenied matches every error returned for a command rejected by a Policy.
+var ErrDenied = errors.New("policycmd: command denied by policy")
+
+// Rules reported by DeniedError.
+const (
+	RuleRelativePath  = "absolute-path"
+	RuleNotAllowed    = "allowed-binaries"
+	RuleDenied        = "denied-binaries"
+	RuleForbiddenArgs = "forbidden-args"
+)
+
+// Policy restricts which commands may run.
+type Policy struct {
+	// AllowedBinaries lists the binaries that may run. Entries and command names without a
+	// slash are resolved with cdsexec.LookPath against the PATH of the current process, as
+	// exec.Command resolves them, and a command is allowed if it resolves to the file an
+	// entry resolves to, symbolic links followed. Empty allows every binary.
+	AllowedBinaries []string
+	// DeniedBinaries lists binaries that may never run. Entries with a slash are matched
+	// like AllowedBinaries; entries without one also deny every binary of that base name,
+	// wherever it lives.
+	DeniedBinaries []string
+	// ForbiddenArgs rejects commands with any argument matching one of the patterns.
+	ForbiddenArgs []*regexp.Regexp
+	// RequireAbsolutePath rejects commands whose name is not an absolute path, so they cannot
+	// be redirected through PATH.
+	RequireAbsolutePath bool
+}
+
+// DeniedError describes why a command was rejected.
+type DeniedError struct {
+	// Spec is the rejected command, redacted by the cdsexec.Redactor of its context.
+	Spec cdsexec.CommandSpec
+	// Rule names the violated rule.
+	Rule string
+	// Reason is a human-readable explanation.
+	Reason string
+}
+
+func (e *DeniedError) Error() string {
+	return fmt.Sprintf("policycmd: %s denied by rule %s: %s", e.Spec, e.Rule, e.Reason)
+}
+
+// Is reports whether target is ErrDenied or cdsexec.ErrPolicyDenied.
+func (e *DeniedError) Is(target error) bool {
+	return target == ErrDenied || target == cdsexec.ErrPolicyDenied
+}
+
+// Check returns a *DeniedError if spec violates p.
+func (p Policy) Check(spec cdsexec.CommandSpec) error {
+	deny := func(rule, format string, args ...any) error {
+		return &DeniedError{Spec: spec, Rule: rule, Reason: fmt.Sprintf(format, args...)}
+	}
+	if p.RequireAbsolutePath && !filepath.IsAbs(spec.Name) {
+		return deny(RuleRelativePath, "binary %q is not an absolute path", spec.Name)
+	}
+	binary := resolve(spec.Name)
+	if isDenied(p.DeniedBinaries, spec.Name, binary) {
+		return deny(RuleDenied, "binary %q is denied", spec.Name)
+	}
+	if len(p.AllowedBinaries) > 0 && !isAllowed(p.AllowedBinaries, binary) {
+		return deny(RuleNotAllowed, "binary %q is not allowed", spec.Name)
+	}
+	for i, a := range spec.Args {
+		for _, re := range p.ForbiddenArgs {
+			if re.MatchString(a) {
+				return deny(RuleForbiddenArgs, "argument %d matches forbidden pattern %q", i+1, re)
+			}
+		}
+	}
+	return nil
+}
+
+// resolve returns the absolute path of the file binary name runs, symbolic links
+// followed, or "" if name is not found.
+func resolve(name string) string {
+	path := name
+	if !strings.ContainsRune(name, '/') {
+		var err error
+		if path, err = cdsexec.LookPath(name, os.Getenv("PATH")); err != nil {
+			return ""
+		}
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return ""
+	}
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		return real
+	}
+	return path
+}
+
+func isAllowed(list []string, binary string) bool {
+	if binary == "" {
+		return false
+	}
+	for _, entry := range list {
+		if resolve(entry) == binary {
+			return true
+		}
+	}
+	return false
+}
+
+func isDenied(list []string, name, binary string) bool {
+	base := filepath.Base(name)
+	for _, entry := range list {
+		if !strings.ContainsRune(entry, '/') && entry == base {
+			return true
+		}
+		if binary != "" && resolve(entry) == binary {
+			return true
+		}
+	}
+	return false
+}
+
+// New returns a CommandConstructor that rejects commands violating p before they execute.
+func New(base cdsexec.CommandConstructor, p Policy) cdsexec.CommandConstructor {
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		if err := p.Check(inv.Spec); err != nil {
+			var de *DeniedError
+			if errors.As(err, &de) {
+				de.Spec = cdsexec.RedactorFromContext(inv.Ctx).Spec(de.Spec.Clone())
+			}
+			return err
+		}
+		return next(inv)
+	})
+}
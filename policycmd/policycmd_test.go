@@ -0,0 +1,122 @@
+package policycmd_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+	"github.com/cirrusdata/cdsexec/policycmd"
+)
+
+// binaries creates executable files named after names in a new directory.
+func binaries(t *testing.T, names ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestPolicy(t *testing.T) {
+	bin := binaries(t, "iscsiadm", "lsblk", "curl")
+	evil := binaries(t, "lsblk", "curl")
+	t.Setenv("PATH", bin)
+	p := policycmd.Policy{
+		AllowedBinaries:     []string{filepath.Join(bin, "iscsiadm"), "lsblk", "curl"},
+		DeniedBinaries:      []string{"curl"},
+		ForbiddenArgs:       []*regexp.Regexp{regexp.MustCompile(`^--exec`), regexp.MustCompile(`[;&|]`)},
+		RequireAbsolutePath: true,
+	}
+
+	tests := []struct {
+		name     string
+		cmd      string
+		args     []string
+		wantRule string
+	}{
+		{"Allowed Exact Path", filepath.Join(bin, "iscsiadm"), []string{"-m", "session"}, ""},
+		{"Allowed Resolved Name", filepath.Join(bin, "lsblk"), []string{"-J"}, ""},
+		{"Base Name Elsewhere", filepath.Join(evil, "lsblk"), nil, policycmd.RuleNotAllowed},
+		{"Relative Path", "lsblk", nil, policycmd.RuleRelativePath},
+		{"Exact Path Mismatch", "/tmp/iscsiadm", nil, policycmd.RuleNotAllowed},
+		{"Not Allowed", "/bin/sh", []string{"-c", "id"}, policycmd.RuleNotAllowed},
+		{"Denied Wins", filepath.Join(bin, "curl"), nil, policycmd.RuleDenied},
+		{"Denied Anywhere", filepath.Join(evil, "curl"), nil, policycmd.RuleDenied},
+		{"Forbidden Arg", filepath.Join(bin, "lsblk"), []string{"--exec=/bin/sh"}, policycmd.RuleForbiddenArgs},
+		{"Metacharacter", filepath.Join(bin, "lsblk"), []string{"sda; reboot"}, policycmd.RuleForbiddenArgs},
+	}
+
+	executed := 0
+	ctor := policycmd.New(mockcmd.MakeMockCmdWithOutput("", func(*mockcmd.MockCmd) error {
+		executed++
+		return nil
+	}), p)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := executed
+			err := ctor(context.Background(), tt.cmd, tt.args...).Run()
+			if tt.wantRule == "" {
+				if err != nil || executed != before+1 {
+					t.Errorf("Expected command to run, got %v", err)
+				}
+				return
+			}
+			var de *policycmd.DeniedError
+			if !errors.As(err, &de) || !errors.Is(err, policycmd.ErrDenied) || !errors.Is(err, cdsexec.ErrPolicyDenied) {
+				t.Fatalf("Expected *DeniedError, got %v", err)
+			}
+			if de.Rule != tt.wantRule {
+				t.Errorf("Expected rule %s, got %s", tt.wantRule, de.Rule)
+			}
+			if executed != before {
+				t.Errorf("Expected denied command not to run")
+			}
+		})
+	}
+}
+
+func TestPolicyResolvesNames(t *testing.T) {
+	bin := binaries(t, "mount")
+	evil := binaries(t, "mount")
+	t.Setenv("PATH", bin)
+	link := filepath.Join(t.TempDir(), "mount")
+	if err := os.Symlink(filepath.Join(bin, "mount"), link); err != nil {
+		t.Fatal(err)
+	}
+	p := policycmd.Policy{AllowedBinaries: []string{"mount"}}
+
+	for _, name := range []string{"mount", filepath.Join(bin, "mount"), link} {
+		if err := p.Check(cdsexec.CommandSpec{Name: name}); err != nil {
+			t.Errorf("Expected %s to be allowed, got %v", name, err)
+		}
+	}
+	if err := p.Check(cdsexec.CommandSpec{Name: filepath.Join(evil, "mount")}); !errors.Is(err, policycmd.ErrDenied) {
+		t.Errorf("Expected a mount outside PATH to be denied, got %v", err)
+	}
+	t.Setenv("PATH", evil)
+	if err := p.Check(cdsexec.CommandSpec{Name: filepath.Join(bin, "mount")}); !errors.Is(err, policycmd.ErrDenied) {
+		t.Errorf("Expected entries to resolve against the current PATH, got %v", err)
+	}
+}
+
+func TestDeniedErrorIsRedacted(t *testing.T) {
+	r := cdsexec.NewRedactor(cdsexec.RedactFlags("--password"))
+	ctor := cdsexec.Redact(policycmd.New(mockcmd.MakeMockCmdWithOutput("", nil), policycmd.Policy{
+		AllowedBinaries: []string{"lsblk"},
+	}), r)
+
+	err := ctor(context.Background(), "iscsiadm", "--password", "hunter2").Run()
+	if !errors.Is(err, policycmd.ErrDenied) || strings.Contains(err.Error(), "hunter2") {
+		t.Errorf("Expected redacted denial, got %v", err)
+	}
+}
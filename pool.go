@@ -0,0 +1,110 @@
+package cdsexec
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// maxPooledBuffer bounds the capacity of the buffers kept for reuse, so that one large
+// output does not keep its memory alive.
+const maxPooledBuffer = 4 << 20
+
+// maxSizeHints bounds the number of binaries whose output sizes are remembered.
+const maxSizeHints = 1024
+
+var bufferPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// outputHints remembers the size of the last output of every binary, to size the buffer
+// collecting the next one.
+var outputHints sizeHints
+
+type sizeHints struct {
+	mu sync.Mutex
+	m  map[string]int
+}
+
+func (h *sizeHints) get(name string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.m[filepath.Base(name)]
+}
+
+func (h *sizeHints) set(name string, n int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.m == nil || len(h.m) >= maxSizeHints {
+		h.m = make(map[string]int)
+	}
+	h.m[filepath.Base(name)] = n
+}
+
+// getBuffer returns an empty buffer from the pool with room for the output of name, as
+// large as its last one. An empty name gives no hint.
+func getBuffer(name string) *bytes.Buffer {
+	b := bufferPool.Get().(*bytes.Buffer)
+	if name == "" {
+		return b
+	}
+	if hint := outputHints.get(name); hint > 0 {
+		// bytes.Buffer.ReadFrom, which collects the output, wants MinRead bytes of room to
+		// read the end of the output.
+		b.Grow(min(hint+bytes.MinRead, maxPooledBuffer))
+	}
+	return b
+}
+
+// putBuffer returns b to the pool.
+func putBuffer(b *bytes.Buffer) {
+	if b.Cap() > maxPooledBuffer {
+		return
+	}
+	b.Reset()
+	bufferPool.Put(b)
+}
+
+// detachBuffer returns a copy of the content of b, the output of name if not empty, and
+// returns b to the pool. Like a bytes.Buffer nothing was written to, it returns nil for no
+// output.
+func detachBuffer(name string, b *bytes.Buffer) []byte {
+	if name != "" {
+		outputHints.set(name, b.Len())
+	}
+	var out []byte
+	if b.Len() > 0 {
+		out = bytes.Clone(b.Bytes())
+	}
+	putBuffer(b)
+	return out
+}
+
+// copyBufferSize is the size of the buffers Copy moves data through.
+const copyBufferSize = 64 << 10
+
+var copyBuffers = sync.Pool{New: func() any {
+	b := make([]byte, copyBufferSize)
+	return &b
+}}
+
+// Copy copies from src to dst until EOF or an error, like io.Copy. When dst is an
+// io.ReaderFrom, such as an *os.File or a network connection, it is handed src, which lets
+// the kernel move data between pipes, files and sockets without copying it through this
+// process (splice and sendfile on Linux). Otherwise data goes through a pooled 64 KiB
+// buffer instead of one allocated for every copy.
+func Copy(dst io.Writer, src io.Reader) (int64, error) {
+	// An *os.File falls back to io.Copy, and its own buffer, when it cannot write to dst
+	// directly, so it is only handed dst through dst's io.ReaderFrom.
+	if wt, ok := src.(io.WriterTo); ok {
+		if _, isFile := src.(*os.File); !isFile {
+			return wt.WriteTo(dst)
+		}
+	}
+	if rf, ok := dst.(io.ReaderFrom); ok {
+		return rf.ReadFrom(src)
+	}
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, *buf)
+}
@@ -0,0 +1,74 @@
+package cdsexec_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+func TestOutputBuffersNotShared(t *testing.T) {
+	ctor := cdsexec.Wrap(cdsexec.CommandContext, passThrough)
+	first, err := ctor(context.Background(), "printf", "first").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			want := fmt.Sprintf("output %d", i)
+			out, err := ctor(context.Background(), "printf", want).Output()
+			if err != nil || string(out) != want {
+				t.Errorf("Expected %q, got %q, %v", want, out, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if string(first) != "first" {
+		t.Errorf("Expected earlier output to be left alone, got %q", first)
+	}
+
+	out, err := ctor(context.Background(), "true").Output()
+	if err != nil || out != nil {
+		t.Errorf("Expected nil for no output, got %q, %v", out, err)
+	}
+	res, err := cdsexec.RunScript(context.Background(), cdsexec.CommandContext, cdsexec.ScriptOptions{Content: "echo out; echo err >&2"})
+	if err != nil || string(res.Stdout) != "out\n" || string(res.Stderr) != "err\n" {
+		t.Errorf("Expected script output, got %q / %q, %v", res.Stdout, res.Stderr, err)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	data := strings.Repeat("0123456789", 20000)
+	var out strings.Builder
+	// strings.Builder is neither an io.ReaderFrom nor is the source an io.WriterTo.
+	n, err := cdsexec.Copy(&out, io.LimitReader(strings.NewReader(data), int64(len(data))))
+	if err != nil || n != int64(len(data)) || out.String() != data {
+		t.Errorf("Expected %d bytes copied, got %d, %v", len(data), n, err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		io.WriteString(w, data)
+		w.Close()
+	}()
+	f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if n, err := cdsexec.Copy(f, r); err != nil || n != int64(len(data)) {
+		t.Errorf("Expected %d bytes spliced, got %d, %v", len(data), n, err)
+	}
+}
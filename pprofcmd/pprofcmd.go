@@ -0,0 +1,77 @@
+package pprofcmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime/pprof"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// BinaryLabel is the label holding the base name of the binary of a command.
+const BinaryLabel = "cdsexec.binary"
+
+// Option configures the labels.
+type Option func(*config)
+
+type config struct {
+	labels []string
+	fn     func(spec cdsexec.CommandSpec) []string
+	err    error
+}
+
+func (c *config) fail(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+// Labels adds the given key-value pairs to the labels of every command.
+func Labels(kv ...string) Option {
+	return func(c *config) {
+		if len(kv)%2 != 0 {
+			c.fail(errors.New("pprofcmd: odd number of label arguments"))
+			return
+		}
+		c.labels = append(c.labels, kv...)
+	}
+}
+
+// LabelFunc adds the key-value pairs returned by fn for each command to its labels. A
+// command for which fn returns an odd number of strings fails without reaching base.
+func LabelFunc(fn func(spec cdsexec.CommandSpec) []string) Option {
+	return func(c *config) { c.fn = fn }
+}
+
+// New returns a CommandConstructor running every command built by base under pprof labels:
+// BinaryLabel, the labels set by opts and those already carried by the context of the
+// command, e.g. through pprof.WithLabels. The goroutines started to copy output or to wait
+// for a command started with Start carry the labels too.
+func New(base cdsexec.CommandConstructor, opts ...Option) cdsexec.CommandConstructor {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		if cfg.err != nil {
+			return cfg.err
+		}
+		labels := append([]string{BinaryLabel, filepath.Base(inv.Spec.Name)}, cfg.labels...)
+		if cfg.fn != nil {
+			kv := cfg.fn(inv.Spec)
+			if len(kv)%2 != 0 {
+				return fmt.Errorf("pprofcmd: odd number of labels %q for %s", kv, inv.Spec.Name)
+			}
+			labels = append(labels, kv...)
+		}
+		var err error
+		pprof.Do(inv.Ctx, pprof.Labels(labels...), func(ctx context.Context) {
+			inv.Ctx = ctx
+			err = next(inv)
+		})
+		return err
+	})
+}
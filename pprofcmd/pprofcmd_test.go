@@ -0,0 +1,76 @@
+package pprofcmd_test
+
+import (
+	"context"
+	"runtime/pprof"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+	"github.com/cirrusdata/cdsexec/pprofcmd"
+)
+
+func TestLabels(t *testing.T) {
+	got := map[string]string{}
+	base := func(ctx context.Context, name string, arg ...string) cdsexec.Commander {
+		pprof.ForLabels(ctx, func(k, v string) bool {
+			got[k] = v
+			return true
+		})
+		return mockcmd.MakeMockCmdWithOutput("", nil)(ctx, name, arg...)
+	}
+	ctor := pprofcmd.New(base,
+		pprofcmd.Labels("component", "agent"),
+		pprofcmd.LabelFunc(func(spec cdsexec.CommandSpec) []string { return []string{"pool", spec.Args[len(spec.Args)-1]} }),
+	)
+	ctx := pprof.WithLabels(context.Background(), pprof.Labels("request", "r-42"))
+	if err := ctor(ctx, "/usr/sbin/zpool", "status", "tank").Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{pprofcmd.BinaryLabel: "zpool", "component": "agent", "pool": "tank", "request": "r-42"}
+	for k, v := range expected {
+		if got[k] != v {
+			t.Errorf("Expected label %s=%s, got %q", k, v, got[k])
+		}
+	}
+}
+
+func TestStartLabels(t *testing.T) {
+	var label string
+	base := func(ctx context.Context, name string, arg ...string) cdsexec.Commander {
+		label, _ = pprof.Label(ctx, pprofcmd.BinaryLabel)
+		return cdsexec.CommandContext(ctx, name, arg...)
+	}
+	cmd := pprofcmd.New(base)(context.Background(), "true")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if label != "true" {
+		t.Errorf("Expected the binary label, got %q", label)
+	}
+}
+
+func TestOddLabels(t *testing.T) {
+	ran := false
+	base := mockcmd.MakeMockCmdWithOutput("", func(*mockcmd.MockCmd) error {
+		ran = true
+		return nil
+	})
+	ctor := pprofcmd.New(base, pprofcmd.LabelFunc(func(spec cdsexec.CommandSpec) []string { return spec.Args }))
+	if err := ctor(context.Background(), "zpool", "status").Run(); err == nil || ran {
+		t.Errorf("Expected an odd number of labels to fail the command, got %v", err)
+	}
+	if err := ctor(context.Background(), "zpool", "status", "tank").Run(); err != nil || !ran {
+		t.Errorf("Expected an even number of labels to run the command, got %v", err)
+	}
+
+	ran = false
+	ctor = pprofcmd.New(base, pprofcmd.Labels("component"))
+	if err := ctor(context.Background(), "zpool").Run(); err == nil || ran {
+		t.Errorf("Expected an odd number of label arguments to fail the command, got %v", err)
+	}
+}
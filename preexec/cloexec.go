@@ -0,0 +1,9 @@
+package preexec
+
+// WithCloseOnExec marks every file descriptor of the current process above standard error
+// close-on-exec before each command, so that descriptors opened without that flag, e.g. by
+// C libraries or inherited from the parent of the process, do not leak into the children.
+// Files passed to a child on purpose are unaffected: os/exec duplicates them into the child.
+func WithCloseOnExec() Option {
+	return func(c *config) { c.before = append(c.before, closeOnExec) }
+}
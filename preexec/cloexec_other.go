@@ -0,0 +1,6 @@
+//go:build !unix
+
+package preexec
+
+// closeOnExec does nothing: handles are not inherited unless asked for on Windows.
+func closeOnExec() error { return nil }
@@ -0,0 +1,36 @@
+//go:build unix
+
+package preexec
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"syscall"
+)
+
+// fdDir lists the open file descriptors of the current process.
+func fdDir() string {
+	if runtime.GOOS == "linux" {
+		return "/proc/self/fd"
+	}
+	return "/dev/fd"
+}
+
+func closeOnExec() error {
+	entries, err := os.ReadDir(fdDir())
+	if err != nil {
+		return fmt.Errorf("preexec: listing file descriptors: %w", err)
+	}
+	for _, e := range entries {
+		fd, err := strconv.Atoi(e.Name())
+		if err != nil || fd <= 2 {
+			continue
+		}
+		// The descriptor that listed the directory is among them; it is closed by now, or
+		// reused by another file, for which the flag is harmless.
+		syscall.CloseOnExec(fd)
+	}
+	return nil
+}
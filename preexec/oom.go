@@ -0,0 +1,19 @@
+package preexec
+
+import "fmt"
+
+// WithOOMScoreAdj sets the oom_score_adj of the child on Linux, from -1000 to 1000: a
+// positive value makes the kernel kill it before other processes when memory runs out, so
+// that a heavyweight helper such as mkfs goes before the agent, and a negative value
+// protects it. Lowering the value below that of the current process requires
+// CAP_SYS_RESOURCE; without it, or on other systems, the command exits with
+// ExitSetupFailed.
+func WithOOMScoreAdj(n int) Option {
+	return func(c *config) {
+		if n < -1000 || n > 1000 {
+			c.fail(fmt.Errorf("preexec: oom_score_adj %d out of range", n))
+			return
+		}
+		c.steps = append(c.steps, fmt.Sprintf("echo %d 2>/dev/null >/proc/self/oom_score_adj || fail 'setting oom_score_adj failed'", n))
+	}
+}
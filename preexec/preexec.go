@@ -0,0 +1,91 @@
+package preexec
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// Shell is the shell that sets up the child before executing the command.
+const Shell = "/bin/sh"
+
+// ExitSetupFailed is the exit code of commands whose child could not be set up, e.g.
+// because raising a limit was not permitted. The reason is written to their standard error.
+const ExitSetupFailed = 125
+
+// Option configures the child process of the commands before it executes them.
+type Option func(*config)
+
+type config struct {
+	// steps are the shell commands run, in order, before exec.
+	steps []string
+	// before are run by the current process before every command.
+	before []func() error
+	err    error
+}
+
+func (c *config) fail(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+// WithUmask sets the file mode creation mask of the child, so that the files it creates
+// have predictable permissions whatever the umask of the current process.
+func WithUmask(mask os.FileMode) Option {
+	return func(c *config) {
+		if mask&^os.ModePerm != 0 {
+			c.fail(fmt.Errorf("preexec: invalid umask %#o", uint32(mask)))
+			return
+		}
+		c.steps = append(c.steps, fmt.Sprintf("umask %04o", uint32(mask)))
+	}
+}
+
+// New returns a CommandConstructor whose commands built by base are set up as opts
+// describe before they run. Go cannot run code in the child between fork and exec, so the
+// command is run through a Shell that applies opts and then replaces itself with the
+// command: the process, its PID, arguments, environment and streams are those of the
+// command. The shell runs on the host of base, which must provide a POSIX Shell; on
+// Windows the commands fail with errors.ErrUnsupported.
+func New(base cdsexec.CommandConstructor, opts ...Option) cdsexec.CommandConstructor {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	script := strings.Join(append(append([]string{`fail() { echo "preexec: $1" >&2; exit ` + strconv.Itoa(ExitSetupFailed) + `; }`}, cfg.steps...), `exec "$0" "$@"`), "\n")
+
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		if cfg.err != nil {
+			return cfg.err
+		}
+		for _, fn := range cfg.before {
+			if err := fn(); err != nil {
+				return err
+			}
+		}
+		if len(cfg.steps) == 0 {
+			return next(inv)
+		}
+		if runtime.GOOS == "windows" {
+			return fmt.Errorf("preexec: %w on %s", errors.ErrUnsupported, runtime.GOOS)
+		}
+		name := inv.Spec.Name
+		if !strings.ContainsRune(name, '/') {
+			path, _ := cdsexec.LookupEnv(inv.Spec.Env, "PATH")
+			resolved, err := cdsexec.LookPath(name, path)
+			if err != nil {
+				return err
+			}
+			name = resolved
+		}
+		inv.Spec.Args = append([]string{"-c", script, name}, inv.Spec.Args...)
+		inv.Spec.Name = Shell
+		return next(inv)
+	})
+}
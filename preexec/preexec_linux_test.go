@@ -0,0 +1,31 @@
+package preexec_test
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/preexec"
+)
+
+func TestCloseOnExec(t *testing.T) {
+	fd, err := syscall.Open(os.DevNull, syscall.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fd)
+	leaked := "/proc/self/fd/" + strconv.Itoa(fd)
+	script := "test -e " + leaked + " && echo leaked || echo closed"
+
+	out, err := cdsexec.CommandContext(context.Background(), "sh", "-c", script).Output()
+	if err != nil || string(out) != "leaked\n" {
+		t.Fatalf("Expected the descriptor to leak without the option, got %q, %v", out, err)
+	}
+	out, err = preexec.New(cdsexec.CommandContext, preexec.WithCloseOnExec())(context.Background(), "sh", "-c", script).Output()
+	if err != nil || string(out) != "closed\n" {
+		t.Errorf("Expected the descriptor to be closed, got %q, %v", out, err)
+	}
+}
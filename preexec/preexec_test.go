@@ -0,0 +1,108 @@
+package preexec_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/preexec"
+)
+
+func TestUmask(t *testing.T) {
+	dir := t.TempDir()
+	ctor := preexec.New(cdsexec.CommandContext, preexec.WithUmask(0o027))
+	cmd := ctor(context.Background(), "sh", "-c", "umask; touch backup.tar")
+	cmd.SetDir(dir)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(out)) != "0027" {
+		t.Errorf("Expected umask 0027, got %q", out)
+	}
+	fi, err := os.Stat(filepath.Join(dir, "backup.tar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o640 {
+		t.Errorf("Expected mode 0640, got %v", fi.Mode().Perm())
+	}
+}
+
+func TestSameProcess(t *testing.T) {
+	ctor := preexec.New(cdsexec.CommandContext, preexec.WithUmask(0o022))
+	cmd := ctor(context.Background(), "sh", "-c", `echo "$$ $0 $1"`, "zero", "one")
+	var out strings.Builder
+	cmd.SetStdout(&out)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	pid := cmd.Process().Pid
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if want := strconv.Itoa(pid) + " zero one\n"; out.String() != want {
+		t.Errorf("Expected %q, got %q", want, out.String())
+	}
+}
+
+func TestErrors(t *testing.T) {
+	ctor := preexec.New(cdsexec.CommandContext, preexec.WithUmask(0o022))
+	if err := ctor(context.Background(), "cdsexec-missing").Run(); !errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("Expected exec.ErrNotFound, got %v", err)
+	}
+	ctor = preexec.New(cdsexec.CommandContext, preexec.WithUmask(0o1022))
+	if err := ctor(context.Background(), "true").Run(); err == nil || !strings.Contains(err.Error(), "invalid umask") {
+		t.Errorf("Expected an invalid umask error, got %v", err)
+	}
+}
+
+func TestRlimit(t *testing.T) {
+	ctor := preexec.New(cdsexec.CommandContext,
+		preexec.WithRlimit(preexec.RlimitNoFile, 256, 512),
+		preexec.WithRlimit(preexec.RlimitCore, 1<<20, preexec.Unlimited),
+	)
+	out, err := ctor(context.Background(), "sh", "-c", "ulimit -S -n; ulimit -H -n; ulimit -S -c; ulimit -H -c").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "256\n512\n2048\nunlimited\n"; string(out) != want {
+		t.Errorf("Expected %q, got %q", want, out)
+	}
+
+	ctor = preexec.New(cdsexec.CommandContext, preexec.WithRlimit(preexec.RlimitNoFile, 64, 32))
+	if err := ctor(context.Background(), "true").Run(); err == nil || !strings.Contains(err.Error(), "above its hard limit") {
+		t.Errorf("Expected a soft limit above the hard limit to be rejected, got %v", err)
+	}
+
+	if os.Geteuid() != 0 {
+		ctor = preexec.New(cdsexec.CommandContext, preexec.WithRlimit(preexec.RlimitNoFile, preexec.Unlimited, preexec.Unlimited))
+		_, err := ctor(context.Background(), "true").Output()
+		var ee *exec.ExitError
+		if !errors.As(err, &ee) || ee.ExitCode() != preexec.ExitSetupFailed || !strings.Contains(string(ee.Stderr), "setting RLIMIT_NOFILE failed") {
+			t.Errorf("Expected exit code %d, got %v", preexec.ExitSetupFailed, err)
+		}
+	}
+}
+
+func TestOOMScoreAdj(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("oom_score_adj is specific to Linux")
+	}
+	ctor := preexec.New(cdsexec.CommandContext, preexec.WithOOMScoreAdj(500))
+	out, err := ctor(context.Background(), "cat", "/proc/self/oom_score_adj").Output()
+	if err != nil || string(out) != "500\n" {
+		t.Errorf("Expected oom_score_adj 500, got %q, %v", out, err)
+	}
+	ctor = preexec.New(cdsexec.CommandContext, preexec.WithOOMScoreAdj(2000))
+	if err := ctor(context.Background(), "true").Run(); err == nil {
+		t.Errorf("Expected an out of range value to be rejected")
+	}
+}
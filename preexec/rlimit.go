@@ -0,0 +1,98 @@
+package preexec
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Resource is a resource whose use by the child is limited with WithRlimit.
+type Resource int
+
+const (
+	// RlimitCPU is the CPU time, in seconds.
+	RlimitCPU Resource = iota
+	// RlimitFileSize is the size of the files the child may write, in bytes.
+	RlimitFileSize
+	// RlimitCore is the size of the core dump of the child, in bytes.
+	RlimitCore
+	// RlimitStack is the size of the stack, in bytes.
+	RlimitStack
+	// RlimitNoFile is the number of file descriptors the child may open.
+	RlimitNoFile
+	// RlimitAS is the size of the address space, in bytes.
+	RlimitAS
+)
+
+// Unlimited is the value of a limit that does not limit anything.
+const Unlimited = math.MaxUint64
+
+// resources maps every Resource to its name, its option of the ulimit builtin and the unit
+// of the values of that option when the shell runs as sh.
+var resources = [...]struct {
+	name string
+	flag string
+	unit uint64
+}{
+	RlimitCPU:      {"RLIMIT_CPU", "-t", 1},
+	RlimitFileSize: {"RLIMIT_FSIZE", "-f", 512},
+	RlimitCore:     {"RLIMIT_CORE", "-c", 512},
+	RlimitStack:    {"RLIMIT_STACK", "-s", 1024},
+	RlimitNoFile:   {"RLIMIT_NOFILE", "-n", 1},
+	RlimitAS:       {"RLIMIT_AS", "-v", 1024},
+}
+
+func (r Resource) String() string {
+	if r < 0 || int(r) >= len(resources) {
+		return "Resource(" + strconv.Itoa(int(r)) + ")"
+	}
+	return resources[r].name
+}
+
+// WithRlimit sets the soft and hard limits of r for the child, e.g. to let qemu-img open
+// more files than the current process may. Values in bytes are rounded up to the unit of
+// the shell. Raising a hard limit requires privileges; without them the command exits
+// with ExitSetupFailed.
+func WithRlimit(r Resource, soft, hard uint64) Option {
+	return func(c *config) {
+		if r < 0 || int(r) >= len(resources) {
+			c.fail(fmt.Errorf("preexec: unknown resource %v", r))
+			return
+		}
+		if soft > hard {
+			c.fail(fmt.Errorf("preexec: soft limit of %v above its hard limit", r))
+			return
+		}
+		res := resources[r]
+		s, h := ulimitValue(soft, res.unit), ulimitValue(hard, res.unit)
+		// The soft limit is lowered first, so that a lower hard limit can be set, and set
+		// again once the hard limit has been raised.
+		c.steps = append(c.steps, fmt.Sprintf(
+			"{ ulimit -S %[1]s %[2]s 2>/dev/null; ulimit -H %[1]s %[3]s && ulimit -S %[1]s %[2]s; } || fail 'setting %[4]s failed'",
+			res.flag, s, h, res.name))
+	}
+}
+
+func ulimitValue(v, unit uint64) string {
+	if v == Unlimited {
+		return "unlimited"
+	}
+	n := v / unit
+	if v%unit != 0 {
+		n++
+	}
+	return strconv.FormatUint(n, 10)
+}
+
+// WithCoreDumps enables or disables core dumps of the child. Enabling raises the soft
+// RLIMIT_CORE to the hard one, which needs no privileges; see corecmd to find the cores of
+// crashed commands.
+func WithCoreDumps(enabled bool) Option {
+	return func(c *config) {
+		if enabled {
+			c.steps = append(c.steps, `ulimit -S -c "$(ulimit -H -c)" || fail 'enabling core dumps failed'`)
+		} else {
+			c.steps = append(c.steps, `ulimit -c 0 || fail 'disabling core dumps failed'`)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package progress
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Regexp returns an Extractor matching re against lines. The groups of re named "percent",
+// "bytes", "total", "rate" and "eta" fill the corresponding fields of the event; sizes and
+// rates may have units such as "kB" (1000) or "KiB" (1024) and thousands separators, and
+// ETAs may be [h:]mm:ss, Go durations or seconds.
+func Regexp(re *regexp.Regexp) Extractor {
+	return ExtractorFunc(func(line string) (Event, bool) {
+		m := re.FindStringSubmatch(line)
+		if m == nil {
+			return Event{}, false
+		}
+		var ev Event
+		for i, name := range re.SubexpNames() {
+			if m[i] == "" {
+				continue
+			}
+			var err error
+			switch name {
+			case "percent":
+				ev.Percent, err = strconv.ParseFloat(strings.TrimSuffix(m[i], "%"), 64)
+			case "bytes":
+				ev.Bytes, err = parseSize(m[i])
+			case "total":
+				ev.Total, err = parseSize(m[i])
+			case "rate":
+				var r int64
+				r, err = parseSize(strings.TrimSuffix(m[i], "/s"))
+				ev.Rate = float64(r)
+			case "eta":
+				ev.ETA, err = parseETA(m[i])
+			}
+			if err != nil {
+				return Event{}, false
+			}
+		}
+		return ev, true
+	})
+}
+
+var (
+	// DD extracts the progress of dd status=progress, e.g.
+	// "1073741824 bytes (1.1 GB, 1.0 GiB) copied, 8.5 s, 126 MB/s".
+	DD = Regexp(regexp.MustCompile(`^(?P<bytes>\d+) bytes .* copied, [\d.]+ s, (?P<rate>[\d.]+ ?[kMGTP]?i?B/s)`))
+	// QemuImg extracts the progress of qemu-img with -p, e.g. "    (45.23/100%)".
+	QemuImg = Regexp(regexp.MustCompile(`^\s*\((?P<percent>[\d.]+)/100%\)`))
+	// Rsync extracts the progress of rsync --info=progress2 or --progress, e.g.
+	// "  1,234,567,890  45%   12.34MB/s    0:01:23 (xfr#1, to-chk=0/1)".
+	Rsync = Regexp(regexp.MustCompile(`^\s*(?P<bytes>[\d,.]+[kMGTP]?)\s+(?P<percent>\d+)%\s+(?P<rate>[\d.]+[kMGTP]?B/s)\s+(?P<eta>\d+:\d\d:\d\d)`))
+)
+
+var sizeRE = regexp.MustCompile(`^([\d.]+)\s*(?:([kKMGTP])(i?)B?|B)?$`)
+
+// parseSize parses a size with an optional unit. Units are powers of 1000, or of 1024 with
+// an "i" as in "MiB".
+func parseSize(s string) (int64, error) {
+	m := sizeRE.FindStringSubmatch(strings.ReplaceAll(strings.TrimSpace(s), ",", ""))
+	if m == nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, err
+	}
+	base := 1000.0
+	if m[3] != "" {
+		base = 1024
+	}
+	for _, u := range "kMGTP" {
+		if m[2] == "" {
+			break
+		}
+		n *= base
+		if strings.EqualFold(m[2], string(u)) {
+			break
+		}
+	}
+	return int64(n), nil
+}
+
+// parseETA parses [h:]mm:ss, a Go duration or a number of seconds.
+func parseETA(s string) (time.Duration, error) {
+	if strings.Contains(s, ":") {
+		var d time.Duration
+		for _, part := range strings.Split(s, ":") {
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return 0, err
+			}
+			d = d*60 + time.Duration(n)
+		}
+		return d * time.Second, nil
+	}
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(secs * float64(time.Second)), nil
+	}
+	return time.ParseDuration(s)
+}
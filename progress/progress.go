@@ -0,0 +1,153 @@
+package progress
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// Event is a progress report. Zero fields are unknown.
+type Event struct {
+	// Percent is the completion from 0 to 100. Extractors that find Bytes and Total but no
+	// percentage have it computed.
+	Percent float64
+	// Bytes is the amount of data processed so far.
+	Bytes int64
+	// Total is the amount of data to process.
+	Total int64
+	// Rate is the throughput in bytes per second.
+	Rate float64
+	// ETA is the estimated time remaining.
+	ETA time.Duration
+	// Line is the line of output the event was extracted from.
+	Line string
+}
+
+// Extractor finds progress in a line of output.
+type Extractor interface {
+	Extract(line string) (Event, bool)
+}
+
+// ExtractorFunc adapts a function to an Extractor.
+type ExtractorFunc func(line string) (Event, bool)
+
+// Extract calls f(line).
+func (f ExtractorFunc) Extract(line string) (Event, bool) {
+	return f(line)
+}
+
+// Monitor runs the lines written to its writers through extractors and delivers the events
+// found on a channel. A consumer that falls behind only receives the latest event, so a
+// slow consumer never blocks the command.
+type Monitor struct {
+	extractors []Extractor
+	events     chan Event
+
+	mu      sync.Mutex
+	writers []*lineWriter
+	closed  bool
+}
+
+// New returns a Monitor trying extractors in order on every line; the first event found
+// for a line is delivered.
+func New(extractors ...Extractor) *Monitor {
+	return &Monitor{extractors: extractors, events: make(chan Event, 1)}
+}
+
+// Attach returns a Monitor reading the standard output and standard error of cmd. Close
+// it once cmd has exited.
+func Attach(cmd cdsexec.Commander, extractors ...Extractor) *Monitor {
+	m := New(extractors...)
+	cmd.SetStdout(m.Writer())
+	cmd.SetStderr(m.Writer())
+	return m
+}
+
+// Events returns the channel delivering events. It is closed by Close.
+func (m *Monitor) Events() <-chan Event {
+	return m.events
+}
+
+// Writer returns a new writer feeding the Monitor. Use one per output stream. Lines end at
+// a newline or at a carriage return, with which most tools redraw their progress.
+func (m *Monitor) Writer() io.Writer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w := &lineWriter{m: m}
+	m.writers = append(m.writers, w)
+	return w
+}
+
+// Close processes the unterminated last line of every writer and closes the channel.
+func (m *Monitor) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil
+	}
+	for _, w := range m.writers {
+		if w.buf.Len() > 0 {
+			m.line(w.buf.String())
+			w.buf.Reset()
+		}
+	}
+	m.closed = true
+	close(m.events)
+	return nil
+}
+
+// line extracts progress from line and delivers it. m.mu is held.
+func (m *Monitor) line(line string) {
+	if m.closed {
+		return
+	}
+	for _, x := range m.extractors {
+		ev, ok := x.Extract(line)
+		if !ok {
+			continue
+		}
+		if ev.Percent == 0 && ev.Total > 0 {
+			ev.Percent = 100 * float64(ev.Bytes) / float64(ev.Total)
+		}
+		ev.Line = line
+		// Replace an event not received yet with this newer one.
+		select {
+		case m.events <- ev:
+		default:
+			select {
+			case <-m.events:
+			default:
+			}
+			m.events <- ev
+		}
+		return
+	}
+}
+
+type lineWriter struct {
+	m   *Monitor
+	buf bytes.Buffer
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.m.mu.Lock()
+	defer w.m.mu.Unlock()
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexAny(p, "\r\n")
+		if i < 0 {
+			w.buf.Write(p)
+			break
+		}
+		w.buf.Write(p[:i])
+		if w.buf.Len() > 0 {
+			w.m.line(w.buf.String())
+			w.buf.Reset()
+		}
+		p = p[i+1:]
+	}
+	return n, nil
+}
@@ -0,0 +1,101 @@
+package progress_test
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/progress"
+)
+
+func TestExtractors(t *testing.T) {
+	tests := []struct {
+		name      string
+		extractor progress.Extractor
+		line      string
+		expected  progress.Event
+	}{
+		{
+			name:      "dd",
+			extractor: progress.DD,
+			line:      "1073741824 bytes (1.1 GB, 1.0 GiB) copied, 8.5 s, 126 MB/s",
+			expected:  progress.Event{Bytes: 1073741824, Rate: 126e6},
+		},
+		{
+			name:      "qemu-img",
+			extractor: progress.QemuImg,
+			line:      "    (45.23/100%)",
+			expected:  progress.Event{Percent: 45.23},
+		},
+		{
+			name:      "rsync",
+			extractor: progress.Rsync,
+			line:      "  1,234,567,890  45%   12.50MB/s    0:01:23 (xfr#1, to-chk=0/1)",
+			expected:  progress.Event{Percent: 45, Bytes: 1234567890, Rate: 12.5e6, ETA: 83 * time.Second},
+		},
+		{
+			name:      "custom",
+			extractor: progress.Regexp(regexp.MustCompile(`(?P<bytes>\S+) of (?P<total>\S+), (?P<eta>\S+) left`)),
+			line:      "512MiB of 1GiB, 2m30s left",
+			expected:  progress.Event{Bytes: 512 << 20, Total: 1 << 30, ETA: 150 * time.Second},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev, ok := tt.extractor.Extract(tt.line)
+			if !ok {
+				t.Fatalf("Expected progress in %q", tt.line)
+			}
+			if ev != tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, ev)
+			}
+			if _, ok := tt.extractor.Extract("rsync: connection unexpectedly closed"); ok {
+				t.Error("Expected no progress in an error message")
+			}
+		})
+	}
+}
+
+func TestAttach(t *testing.T) {
+	ctx := context.Background()
+	cmd := cdsexec.CommandContext(ctx, "sh", "-c", `printf '    (10.00/100%%)\r    (55.50/100%%)\r' >&2; echo done; printf '    (100.00/100%%)\r' >&2`)
+	m := progress.Attach(cmd, progress.QemuImg)
+
+	var events []progress.Event
+	done := make(chan struct{})
+	go func() {
+		for ev := range m.Events() {
+			events = append(events, ev)
+		}
+		close(done)
+	}()
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	m.Close()
+	<-done
+	if len(events) == 0 || events[len(events)-1].Percent != 100 {
+		t.Errorf("Expected the last event to report 100%%, got %+v", events)
+	}
+	if events[len(events)-1].Line != "    (100.00/100%)" {
+		t.Errorf("Unexpected line %q", events[len(events)-1].Line)
+	}
+}
+
+func TestMonitorCoalesces(t *testing.T) {
+	m := progress.New(progress.Regexp(regexp.MustCompile(`(?P<bytes>\d+)/(?P<total>\d+)`)))
+	w := m.Writer()
+	for _, line := range []string{"1/4\n", "noise\n", "2/4\n", "3/4"} {
+		w.Write([]byte(line))
+	}
+	m.Close()
+	var events []progress.Event
+	for ev := range m.Events() {
+		events = append(events, ev)
+	}
+	if len(events) != 1 || events[0].Percent != 75 {
+		t.Errorf("Expected only the latest event at 75%%, got %+v", events)
+	}
+}
@@ -0,0 +1,170 @@
+package quotacmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// ErrExceeded matches every error returned for a command rejected by a Quota.
+var ErrExceeded = errors.New("quotacmd: quota exceeded")
+
+// Limits reported by ExceededError.
+const (
+	LimitConcurrent = "concurrent"
+	LimitPerMinute  = "per-minute"
+)
+
+// ExceededError describes which quota rejected a command.
+type ExceededError struct {
+	// Key is the quota key of the command, e.g. a tenant ID.
+	Key string
+	// Limit is LimitConcurrent or LimitPerMinute.
+	Limit string
+	// Max is the configured value of the limit.
+	Max int
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("quotacmd: %s quota of %d exceeded for %q", e.Limit, e.Max, e.Key)
+}
+
+// Is reports whether target is ErrExceeded or cdsexec.ErrQuotaExceeded.
+func (e *ExceededError) Is(target error) bool {
+	return target == ErrExceeded || target == cdsexec.ErrQuotaExceeded
+}
+
+// Config configures a Quota.
+type Config struct {
+	// Key extracts the quota key from the context of a command. Commands for which ok is
+	// false are not limited.
+	Key func(ctx context.Context) (key string, ok bool)
+	// MaxConcurrent is the number of commands a key may have running at once. Zero means no limit.
+	MaxConcurrent int
+	// MaxPerMinute is the number of commands a key may start within any minute, measured on
+	// the cdsexec.Clock of the context of the commands. Zero means no limit.
+	MaxPerMinute int
+}
+
+// KeyFromContext returns a Key function reading a string stored in the context under key.
+func KeyFromContext(key any) func(context.Context) (string, bool) {
+	return func(ctx context.Context) (string, bool) {
+		s, ok := ctx.Value(key).(string)
+		return s, ok && s != ""
+	}
+}
+
+// Quota tracks the commands of every key.
+type Quota struct {
+	cfg Config
+
+	mu   sync.Mutex
+	keys map[string]*usage
+}
+
+type usage struct {
+	running int
+	starts  []time.Time
+}
+
+// maxIdleKeys bounds how many keys are kept before idle ones are dropped.
+const maxIdleKeys = 1024
+
+// NewQuota creates a Quota from cfg.
+func NewQuota(cfg Config) *Quota {
+	return &Quota{cfg: cfg, keys: make(map[string]*usage)}
+}
+
+// Usage returns how many commands key has running and how many it started within the last
+// minute, measured on the cdsexec.Clock of ctx.
+func (q *Quota) Usage(ctx context.Context, key string) (running, lastMinute int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	u, ok := q.keys[key]
+	if !ok {
+		return 0, 0
+	}
+	u.prune(cdsexec.ClockFromContext(ctx).Now())
+	return u.running, len(u.starts)
+}
+
+// acquire counts a command for key, or returns an *ExceededError if that would exceed a limit.
+func (q *Quota) acquire(key string, now time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	u, ok := q.keys[key]
+	if !ok {
+		if len(q.keys) >= maxIdleKeys {
+			q.pruneLocked(now)
+		}
+		u = &usage{}
+		q.keys[key] = u
+	}
+	u.prune(now)
+	if q.cfg.MaxConcurrent > 0 && u.running >= q.cfg.MaxConcurrent {
+		return &ExceededError{Key: key, Limit: LimitConcurrent, Max: q.cfg.MaxConcurrent}
+	}
+	if q.cfg.MaxPerMinute > 0 && len(u.starts) >= q.cfg.MaxPerMinute {
+		return &ExceededError{Key: key, Limit: LimitPerMinute, Max: q.cfg.MaxPerMinute}
+	}
+	u.running++
+	if q.cfg.MaxPerMinute > 0 {
+		u.starts = append(u.starts, now)
+	}
+	return nil
+}
+
+func (q *Quota) release(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if u, ok := q.keys[key]; ok {
+		u.running--
+	}
+}
+
+func (q *Quota) pruneLocked(now time.Time) {
+	for key, u := range q.keys {
+		u.prune(now)
+		if u.running == 0 && len(u.starts) == 0 {
+			delete(q.keys, key)
+		}
+	}
+}
+
+// prune drops the starts older than a minute.
+func (u *usage) prune(now time.Time) {
+	cutoff := now.Add(-time.Minute)
+	i := 0
+	for i < len(u.starts) && !u.starts[i].After(cutoff) {
+		i++
+	}
+	u.starts = u.starts[i:]
+}
+
+// Wrap returns a CommandConstructor whose commands are rejected with an *ExceededError
+// when their key is over quota.
+func (q *Quota) Wrap(base cdsexec.CommandConstructor) cdsexec.CommandConstructor {
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		if q.cfg.Key == nil {
+			return next(inv)
+		}
+		key, ok := q.cfg.Key(inv.Ctx)
+		if !ok {
+			return next(inv)
+		}
+		if err := q.acquire(key, cdsexec.ClockFromContext(inv.Ctx).Now()); err != nil {
+			return err
+		}
+		defer q.release(key)
+		return next(inv)
+	})
+}
+
+// New is a shorthand for NewQuota followed by Wrap.
+func New(base cdsexec.CommandConstructor, cfg Config) cdsexec.CommandConstructor {
+	return NewQuota(cfg).Wrap(base)
+}
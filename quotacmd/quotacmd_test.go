@@ -0,0 +1,91 @@
+package quotacmd_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+	"github.com/cirrusdata/cdsexec/quotacmd"
+)
+
+type tenantKey struct{}
+
+func withTenant(id string) context.Context {
+	return context.WithValue(context.Background(), tenantKey{}, id)
+}
+
+func TestConcurrentQuota(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	base := mockcmd.MakeMockCmdWithOutput("", func(m *mockcmd.MockCmd) error {
+		if m.Name == "block" {
+			close(entered)
+			<-release
+		}
+		return nil
+	})
+	q := quotacmd.NewQuota(quotacmd.Config{Key: quotacmd.KeyFromContext(tenantKey{}), MaxConcurrent: 1})
+	ctor := q.Wrap(base)
+
+	done := make(chan error)
+	go func() { done <- ctor(withTenant("acme"), "block").Run() }()
+	<-entered
+
+	err := ctor(withTenant("acme"), "lsblk").Run()
+	var qe *quotacmd.ExceededError
+	if !errors.As(err, &qe) || !errors.Is(err, quotacmd.ErrExceeded) || !errors.Is(err, cdsexec.ErrQuotaExceeded) {
+		t.Fatalf("Expected *ExceededError, got %v", err)
+	}
+	if qe.Key != "acme" || qe.Limit != quotacmd.LimitConcurrent || qe.Max != 1 {
+		t.Errorf("Unexpected error details %+v", qe)
+	}
+	if err := ctor(withTenant("globex"), "lsblk").Run(); err != nil {
+		t.Errorf("Expected other tenants to be unaffected, got %v", err)
+	}
+	if err := ctor(context.Background(), "lsblk").Run(); err != nil {
+		t.Errorf("Expected commands without tenant to be unaffected, got %v", err)
+	}
+	if running, _ := q.Usage(context.Background(), "acme"); running != 1 {
+		t.Errorf("Expected 1 running command, got %d", running)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := ctor(withTenant("acme"), "lsblk").Run(); err != nil {
+		t.Errorf("Expected the slot to be released, got %v", err)
+	}
+}
+
+func TestPerMinuteQuota(t *testing.T) {
+	base := mockcmd.MakeMockCmdWithOutput("", nil)
+	q := quotacmd.NewQuota(quotacmd.Config{Key: quotacmd.KeyFromContext(tenantKey{}), MaxPerMinute: 2})
+	ctor := q.Wrap(base)
+	clock := mockcmd.NewFakeClock(time.Unix(0, 0))
+	ctx := cdsexec.ContextWithClock(withTenant("acme"), clock)
+
+	for i := 0; i < 2; i++ {
+		if err := ctor(ctx, "lsblk").Run(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	var qe *quotacmd.ExceededError
+	if err := ctor(ctx, "lsblk").Run(); !errors.As(err, &qe) || qe.Limit != quotacmd.LimitPerMinute {
+		t.Errorf("Expected per-minute quota error, got %v", err)
+	}
+	if _, n := q.Usage(ctx, "acme"); n != 2 {
+		t.Errorf("Expected 2 starts in the last minute, got %d", n)
+	}
+
+	clock.Advance(time.Minute)
+	if _, n := q.Usage(ctx, "acme"); n != 0 {
+		t.Errorf("Expected no starts in the last minute, got %d", n)
+	}
+	if err := ctor(ctx, "lsblk").Run(); err != nil {
+		t.Errorf("Expected the quota to be replenished after a minute, got %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package ratecmd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// Config configures a token-bucket Limiter.
+type Config struct {
+	// Rate is the number of commands allowed per second, which must be positive.
+	Rate float64
+	// Burst is the number of commands that may start at once. Zero means 1.
+	Burst int
+	// Key groups commands into separate buckets. Nil uses a single bucket for all commands.
+	Key func(cdsexec.CommandSpec) string
+	// OnWait, if set, is called with the time every command spent queued.
+	OnWait func(spec cdsexec.CommandSpec, waited time.Duration)
+}
+
+// KeyByCommandLine puts every distinct command line in its own bucket.
+func KeyByCommandLine(s cdsexec.CommandSpec) string {
+	return s.String()
+}
+
+// Stats summarises the time commands spent queued.
+type Stats struct {
+	Commands  int64
+	Delayed   int64
+	TotalWait time.Duration
+	MaxWait   time.Duration
+}
+
+// Limiter delays commands so they start at no more than the configured rate.
+type Limiter struct {
+	cfg Config
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	stats   Stats
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// maxIdleBuckets bounds how many buckets are kept before full ones are dropped.
+const maxIdleBuckets = 1024
+
+// NewLimiter creates a Limiter from cfg.
+func NewLimiter(cfg Config) (*Limiter, error) {
+	if !(cfg.Rate > 0) {
+		return nil, fmt.Errorf("ratecmd: invalid rate %v", cfg.Rate)
+	}
+	if cfg.Burst <= 0 {
+		cfg.Burst = 1
+	}
+	return &Limiter{cfg: cfg, buckets: make(map[string]*bucket)}, nil
+}
+
+// Wait blocks until a command described by spec may start or ctx is done. Time is measured
+// on the cdsexec.Clock of ctx.
+func (l *Limiter) Wait(ctx context.Context, spec cdsexec.CommandSpec) error {
+	key := ""
+	if l.cfg.Key != nil {
+		key = l.cfg.Key(spec)
+	}
+	clock := cdsexec.ClockFromContext(ctx)
+	now := clock.Now()
+	delay := l.reserve(key, now)
+
+	if delay > 0 {
+		if deadline, ok := ctx.Deadline(); ok && now.Add(delay).After(deadline) {
+			l.cancel(key)
+			return fmt.Errorf("ratecmd: waiting %s would exceed the context deadline: %w", delay, context.DeadlineExceeded)
+		}
+		t := clock.NewTimer(delay)
+		defer t.Stop()
+		select {
+		case <-t.C():
+		case <-ctx.Done():
+			l.cancel(key)
+			return fmt.Errorf("ratecmd: waiting for a token: %w", ctx.Err())
+		}
+	}
+
+	l.record(delay)
+	if l.cfg.OnWait != nil {
+		l.cfg.OnWait(spec, delay)
+	}
+	return nil
+}
+
+// reserve takes a token from the bucket for key and returns how long to wait until it is valid.
+func (l *Limiter) reserve(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxIdleBuckets {
+			l.pruneLocked(now)
+		}
+		b = &bucket{tokens: float64(l.cfg.Burst), last: now}
+		l.buckets[key] = b
+	}
+	l.refillLocked(b, now)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / l.cfg.Rate * float64(time.Second))
+}
+
+// cancel returns a reserved token that was not used.
+func (l *Limiter) cancel(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if b, ok := l.buckets[key]; ok {
+		b.tokens++
+	}
+}
+
+func (l *Limiter) refillLocked(b *bucket, now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * l.cfg.Rate
+		b.last = now
+	}
+	if b.tokens > float64(l.cfg.Burst) {
+		b.tokens = float64(l.cfg.Burst)
+	}
+}
+
+func (l *Limiter) pruneLocked(now time.Time) {
+	for key, b := range l.buckets {
+		l.refillLocked(b, now)
+		if b.tokens >= float64(l.cfg.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func (l *Limiter) record(delay time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stats.Commands++
+	if delay > 0 {
+		l.stats.Delayed++
+		l.stats.TotalWait += delay
+		if delay > l.stats.MaxWait {
+			l.stats.MaxWait = delay
+		}
+	}
+}
+
+// Stats returns the wait-time statistics collected so far.
+func (l *Limiter) Stats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stats
+}
+
+// Wrap returns a CommandConstructor whose commands wait for l before they execute.
+func (l *Limiter) Wrap(base cdsexec.CommandConstructor) cdsexec.CommandConstructor {
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		if err := l.Wait(inv.Ctx, inv.Spec); err != nil {
+			return err
+		}
+		return next(inv)
+	})
+}
+
+// New is a shorthand for NewLimiter followed by Wrap.
+func New(base cdsexec.CommandConstructor, cfg Config) (cdsexec.CommandConstructor, error) {
+	l, err := NewLimiter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return l.Wrap(base), nil
+}
@@ -0,0 +1,100 @@
+package ratecmd_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+	"github.com/cirrusdata/cdsexec/ratecmd"
+)
+
+func TestRateLimit(t *testing.T) {
+	l, err := ratecmd.NewLimiter(ratecmd.Config{Rate: 100, Burst: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctor := l.Wrap(mockcmd.MakeMockCmdWithOutput("", nil))
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := ctor(context.Background(), "zpool", "status").Run(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Errorf("Expected the last two commands to be delayed, took %v", elapsed)
+	}
+
+	stats := l.Stats()
+	if stats.Commands != 4 || stats.Delayed != 2 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	if stats.TotalWait <= 0 || stats.MaxWait <= 0 {
+		t.Errorf("Expected wait times to be recorded: %+v", stats)
+	}
+}
+
+func TestRateLimitPerKey(t *testing.T) {
+	var waits []time.Duration
+	ctor, err := ratecmd.New(mockcmd.MakeMockCmdWithOutput("", nil), ratecmd.Config{
+		Rate:   0.001,
+		Key:    ratecmd.KeyByCommandLine,
+		OnWait: func(_ cdsexec.CommandSpec, d time.Duration) { waits = append(waits, d) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{{"status"}, {"list"}, {"iostat"}} {
+		if err := ctor(context.Background(), "zpool", args...).Run(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if len(waits) != 3 {
+		t.Fatalf("Expected 3 waits, got %d", len(waits))
+	}
+	for _, w := range waits {
+		if w != 0 {
+			t.Errorf("Expected distinct commands not to wait, got %v", w)
+		}
+	}
+}
+
+func TestRateLimitHonorsContext(t *testing.T) {
+	l, err := ratecmd.NewLimiter(ratecmd.Config{Rate: 0.001})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctor := l.Wrap(mockcmd.MakeMockCmdWithOutput("", nil))
+	if err := ctor(context.Background(), "zpool").Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := ctor(ctx, "zpool").Run(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+	if time.Since(start) > 100*time.Millisecond {
+		t.Errorf("Expected to fail fast when the wait exceeds the deadline")
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := ctor(ctx, "zpool").Run(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected canceled, got %v", err)
+	}
+}
+
+func TestInvalidRate(t *testing.T) {
+	for _, rate := range []float64{0, -1, math.NaN()} {
+		if _, err := ratecmd.NewLimiter(ratecmd.Config{Rate: rate}); err == nil {
+			t.Errorf("Expected rate %v to be rejected", rate)
+		}
+	}
+}
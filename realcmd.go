@@ -2,22 +2,90 @@ package cdsexec
 
 import (
 	"context"
+	"errors"
 	"io"
 	"os"
 	"os/exec"
+	"sync/atomic"
 )
 
 var _ Commander = (*Cmd)(nil)
 
 func CommandContext(ctx context.Context, name string, arg ...string) Commander {
-	return &Cmd{
+	if fastSpawn {
+		return FastCommandContext(ctx, name, arg...)
+	}
+	c := &Cmd{
 		Cmd: exec.CommandContext(ctx, name, arg...),
+		ctx: ctx,
 	}
+	c.applyCancelPolicy(ctx)
+	return c
 }
 
 // Cmd is a wrapper around exec.Cmd.
 type Cmd struct {
 	*exec.Cmd
+	// ctx is the context the command was built with, classifying its errors.
+	ctx  context.Context
+	fast bool
+	// pidfd refers to the process on Linux from Start to the end of Wait, see Pidfd.
+	pidfd atomic.Pointer[os.File]
+}
+
+// Start starts the command, as exec.Cmd.Start. On Linux, it opens a pidfd referring to the
+// process, which the process cannot be confused with another one through.
+func (c *Cmd) Start() error {
+	c.nullStreams(true, true, true)
+	if err := c.Cmd.Start(); err != nil {
+		return Classify(c.ctx, err)
+	}
+	// The process cannot have been waited for yet, so its PID is still its own.
+	if f, err := openPidfd(c.Cmd.Process.Pid); err == nil {
+		c.pidfd.Store(f)
+	}
+	return nil
+}
+
+// Wait waits for the command to exit, as exec.Cmd.Wait.
+func (c *Cmd) Wait() error {
+	err := c.Cmd.Wait()
+	if f := c.pidfd.Swap(nil); f != nil {
+		f.Close()
+	}
+	return Classify(c.ctx, err)
+}
+
+// signal sends sig to the process, through its pidfd if it has one.
+func (c *Cmd) signal(sig os.Signal) error {
+	if f := c.pidfd.Load(); f != nil {
+		if err := pidfdSignal(f, sig); !errors.Is(err, errors.ErrUnsupported) {
+			return err
+		}
+	}
+	return c.Cmd.Process.Signal(sig)
+}
+
+// Run starts the command and waits for it to exit, as exec.Cmd.Run.
+func (c *Cmd) Run() error {
+	c.nullStreams(true, true, true)
+	return Classify(c.ctx, c.Cmd.Run())
+}
+
+// Output runs the command and returns its standard output, as exec.Cmd.Output. Standard
+// error is left unset on fast commands too, so that it is collected into the *exec.ExitError.
+func (c *Cmd) Output() ([]byte, error) {
+	c.nullStreams(true, false, false)
+	out, err := c.Cmd.Output()
+	return out, Classify(c.ctx, err)
+}
+
+// CombinedOutput runs the command and returns its standard output and standard error
+// combined, as exec.Cmd.CombinedOutput.
+func (c *Cmd) CombinedOutput() ([]byte, error) {
+	c.nullStreams(true, false, false)
+	out, err := c.Cmd.CombinedOutput()
+	return out, Classify(c.ctx, err)
 }
 
 // SetDir sets the working directory of the command.
@@ -0,0 +1,5 @@
+//go:build !realtest
+
+package realtest
+
+const realMode = false
@@ -0,0 +1,175 @@
+package realtest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+// Real reports whether the tests run against real commands, i.e. were built with the
+// realtest tag:
+//
+//	go test -tags realtest ./...
+func Real() bool {
+	return realMode
+}
+
+// Requirement checks that the environment can run some real commands. It returns nil when
+// it can, and an error explaining what is missing otherwise.
+type Requirement func() error
+
+// Binaries requires the given commands to be found in PATH.
+func Binaries(names ...string) Requirement {
+	return func() error {
+		var missing []string
+		for _, name := range names {
+			if _, err := exec.LookPath(name); err != nil {
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("missing binaries: %s", strings.Join(missing, ", "))
+		}
+		return nil
+	}
+}
+
+// Root requires the tests to run as root.
+func Root() Requirement {
+	return func() error {
+		if os.Geteuid() != 0 {
+			return errors.New("not running as root")
+		}
+		return nil
+	}
+}
+
+// LoopDevices requires loop devices to be available for attaching images.
+func LoopDevices() Requirement {
+	return func() error {
+		f, err := os.OpenFile("/dev/loop-control", os.O_RDWR, 0)
+		if err != nil {
+			return fmt.Errorf("loop devices unavailable: %w", err)
+		}
+		f.Close()
+		return nil
+	}
+}
+
+// Require skips t in real mode unless every requirement is met. Mock mode has no
+// requirements.
+func Require(t testing.TB, reqs ...Requirement) {
+	t.Helper()
+	if !Real() {
+		return
+	}
+	var reasons []string
+	for _, req := range reqs {
+		if err := req(); err != nil {
+			reasons = append(reasons, err.Error())
+		}
+	}
+	if len(reasons) > 0 {
+		t.Skipf("realtest: %s", strings.Join(reasons, "; "))
+	}
+}
+
+// Constructor returns the CommandConstructor a test runs its commands with, so that one
+// suite covers both modes. In mock mode it returns mockcmd.MultiCmdMock(configs...).
+// In real mode it skips t unless the binaries of configs are found, runs commands for
+// real, and reports an error on t when a command matching a config does not produce the
+// result it describes:
+//   - Stdout, if not nil, must equal the standard output, or the combined output for
+//     CombinedOutput.
+//   - Stderr, if not nil, must equal the standard error where it can be observed: always
+//     with Run and Start, on failure with Output.
+//   - A nil Err requires the command to succeed and a non-nil one to fail, with the same
+//     exit code if Err carries one, e.g. &cdsexec.ExitError{Code: 2}.
+//
+// Commands matching no config run unchecked in real mode.
+func Constructor(t testing.TB, configs ...mockcmd.CommandConfig) cdsexec.CommandConstructor {
+	t.Helper()
+	if !Real() {
+		return mockcmd.MultiCmdMock(configs...)
+	}
+	var names []string
+	for _, c := range configs {
+		names = append(names, c.Name)
+	}
+	Require(t, Binaries(names...))
+	return cdsexec.Wrap(cdsexec.CommandContext, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		config, ok := match(configs, inv.Spec)
+		if !ok {
+			return next(inv)
+		}
+		var stdout, stderr bytes.Buffer
+		teedStdout, teedStderr := inv.TeeStdout(&stdout), inv.TeeStderr(&stderr)
+		err := next(inv)
+		check(t, inv, config, err, checked{stdout.Bytes(), teedStdout, stderr.Bytes(), teedStderr})
+		return err
+	})
+}
+
+func match(configs []mockcmd.CommandConfig, spec cdsexec.CommandSpec) (mockcmd.CommandConfig, bool) {
+	for _, c := range configs {
+		if c.Name == spec.Name && reflect.DeepEqual(c.Args, spec.Args) {
+			return c, true
+		}
+	}
+	return mockcmd.CommandConfig{}, false
+}
+
+// checked holds the streams an Interceptor observed through TeeStdout and TeeStderr.
+type checked struct {
+	stdout     []byte
+	teedStdout bool
+	stderr     []byte
+	teedStderr bool
+}
+
+func check(t testing.TB, inv *cdsexec.Invocation, c mockcmd.CommandConfig, err error, obs checked) {
+	name := inv.Spec.String()
+	res := inv.Result(err)
+	if c.Stdout != nil {
+		got, ok := obs.stdout, obs.teedStdout
+		switch inv.Kind {
+		case cdsexec.CallOutput:
+			got, ok = inv.Output, true
+		case cdsexec.CallCombinedOutput:
+			got, ok = inv.Output, true
+			if c.Stderr != nil {
+				c.Stdout = append(append([]byte(nil), c.Stdout...), c.Stderr...)
+			}
+		}
+		if ok && !bytes.Equal(got, c.Stdout) {
+			t.Errorf("realtest: %s: expected stdout %q, got %q", name, c.Stdout, got)
+		}
+	}
+	if c.Stderr != nil && inv.Kind != cdsexec.CallCombinedOutput {
+		got, ok := obs.stderr, obs.teedStderr
+		if inv.Kind == cdsexec.CallOutput {
+			got, ok = res.Stderr, err != nil
+		}
+		if ok && !bytes.Equal(got, c.Stderr) {
+			t.Errorf("realtest: %s: expected stderr %q, got %q", name, c.Stderr, got)
+		}
+	}
+	switch {
+	case c.Err == nil && err != nil:
+		t.Errorf("realtest: %s: expected success, got %v", name, err)
+	case c.Err != nil && err == nil:
+		t.Errorf("realtest: %s: expected failure like %v, got success", name, c.Err)
+	case c.Err != nil:
+		if want := cdsexec.ExitCode(c.Err); want > 0 && res.ExitCode != want {
+			t.Errorf("realtest: %s: expected exit code %d, got %d", name, want, res.ExitCode)
+		}
+	}
+}
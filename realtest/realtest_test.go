@@ -0,0 +1,43 @@
+package realtest_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+	"github.com/cirrusdata/cdsexec/realtest"
+)
+
+// The suite below passes both with mocks and with -tags realtest.
+func TestSuite(t *testing.T) {
+	ctor := realtest.Constructor(t,
+		mockcmd.CommandConfig{Name: "printf", Args: []string{"%s\n", "tank"}, Stdout: []byte("tank\n")},
+		mockcmd.CommandConfig{Name: "sh", Args: []string{"-c", "echo busy >&2; exit 3"}, Stderr: []byte("busy\n"), Err: &cdsexec.ExitError{Code: 3}},
+	)
+
+	out, err := ctor(context.Background(), "printf", "%s\n", "tank").Output()
+	if err != nil || string(out) != "tank\n" {
+		t.Errorf("Expected tank, got %q, %v", out, err)
+	}
+	if err := ctor(context.Background(), "sh", "-c", "echo busy >&2; exit 3").Run(); err == nil {
+		t.Errorf("Expected an error")
+	}
+	if _, err := ctor(context.Background(), "sh", "-c", "echo busy >&2; exit 3").Output(); err == nil {
+		t.Errorf("Expected an error")
+	}
+}
+
+func TestRequire(t *testing.T) {
+	ran := false
+	t.Run("missing", func(t *testing.T) {
+		realtest.Require(t, realtest.Binaries("sh", "cdsexec-no-such-binary"))
+		ran = true
+	})
+	if ran == realtest.Real() {
+		t.Errorf("Expected missing requirements to skip only real tests")
+	}
+	if err := realtest.Binaries("sh", "cdsexec-no-such-binary")(); err == nil || err.Error() != "missing binaries: cdsexec-no-such-binary" {
+		t.Errorf("Expected the missing binary to be reported, got %v", err)
+	}
+}
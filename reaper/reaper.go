@@ -0,0 +1,203 @@
+package reaper
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// ErrRunning is returned by Start when a Reaper is already running in the process.
+var ErrRunning = errors.New("reaper: already running")
+
+// maxStderr bounds the standard error Output collects for the error of a failed command,
+// as os/exec does.
+const maxStderr = 32 << 10
+
+// Reaped describes an orphan that was reaped.
+type Reaped struct {
+	PID int
+	// Name is the name of its executable, as the kernel reports it, truncated to 15 bytes.
+	Name string
+	// ExitCode is its exit code, or -1 if it was terminated by a signal.
+	ExitCode int
+	// Signal is the name of the signal that terminated it, e.g. "KILL".
+	Signal string
+}
+
+// Options configures a Reaper.
+type Options struct {
+	// OnReap, if set, is called with every orphan reaped, from the goroutine reaping them.
+	OnReap func(Reaped)
+}
+
+// Reaper reaps the orphans reparented to the process. Its children that are not orphans
+// are the processes of the commands built by its Constructor: it leaves them to be waited
+// for by their commands, while it reaps any other child, whose Wait then fails. Once a Reaper
+// runs, every command of the process must therefore be built by its Constructor.
+type Reaper struct {
+	opts Options
+
+	mu sync.Mutex
+	// starting counts the commands being started, whose PIDs are not known yet: no child is
+	// reaped until they are.
+	starting int
+	deferred bool
+	// own counts the commands started and not waited for yet, by PID.
+	own map[int]int
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+var running atomic.Bool
+
+// Constructor returns a CommandConstructor whose commands, built by base, are not reaped
+// by r. base must run commands as local processes, and should be cdsexec.CommandContext
+// itself, with other middleware wrapped around the constructor returned: Run, Output and
+// CombinedOutput are run through Start and Wait, which middleware such as retries cannot
+// replay.
+func (r *Reaper) Constructor(base cdsexec.CommandConstructor) cdsexec.CommandConstructor {
+	return func(ctx context.Context, name string, arg ...string) cdsexec.Commander {
+		return &guardedCmd{Commander: base(ctx, name, arg...), r: r}
+	}
+}
+
+// guardedCmd is a command whose process r does not reap.
+type guardedCmd struct {
+	cdsexec.Commander
+	r                    *Reaper
+	stdoutSet, stderrSet bool
+	pid                  int
+}
+
+func (c *guardedCmd) SetStdout(w io.Writer) {
+	c.stdoutSet = w != nil
+	c.Commander.SetStdout(w)
+}
+
+func (c *guardedCmd) SetStderr(w io.Writer) {
+	c.stderrSet = w != nil
+	c.Commander.SetStderr(w)
+}
+
+func (c *guardedCmd) StdoutPipe() (io.ReadCloser, error) {
+	c.stdoutSet = true
+	return c.Commander.StdoutPipe()
+}
+
+func (c *guardedCmd) StderrPipe() (io.ReadCloser, error) {
+	c.stderrSet = true
+	return c.Commander.StderrPipe()
+}
+
+// Start starts the command, keeping r from reaping its process until Wait has.
+func (c *guardedCmd) Start() error {
+	r := c.r
+	r.mu.Lock()
+	r.starting++
+	r.mu.Unlock()
+	err := c.Commander.Start()
+	r.mu.Lock()
+	r.starting--
+	if p := c.Commander.Process(); err == nil && p != nil {
+		c.pid = p.Pid
+		r.own[c.pid]++
+	}
+	wake := r.starting == 0 && r.deferred
+	r.mu.Unlock()
+	if wake {
+		r.kick()
+	}
+	return err
+}
+
+func (c *guardedCmd) Wait() error {
+	err := c.Commander.Wait()
+	if c.pid != 0 {
+		r := c.r
+		r.mu.Lock()
+		if r.own[c.pid]--; r.own[c.pid] <= 0 {
+			delete(r.own, c.pid)
+		}
+		r.mu.Unlock()
+		c.pid = 0
+	}
+	return err
+}
+
+func (c *guardedCmd) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+// Output runs the command and returns its standard output, as exec.Cmd.Output does.
+func (c *guardedCmd) Output() ([]byte, error) {
+	if c.stdoutSet {
+		return nil, errors.New("exec: Stdout already set")
+	}
+	var stdout bytes.Buffer
+	c.SetStdout(&stdout)
+	var stderr *cdsexec.RingBuffer
+	if !c.stderrSet {
+		stderr = cdsexec.NewRingBuffer(maxStderr)
+		c.SetStderr(stderr)
+	}
+	err := c.Run()
+	if stderr != nil {
+		var ee *exec.ExitError
+		var xe *cdsexec.ExitError
+		switch {
+		case errors.As(err, &ee):
+			ee.Stderr = stderr.Bytes()
+		case errors.As(err, &xe):
+			xe.Stderr = stderr.Bytes()
+		}
+	}
+	return stdout.Bytes(), err
+}
+
+// CombinedOutput runs the command and returns its standard output and standard error
+// combined, as exec.Cmd.CombinedOutput does.
+func (c *guardedCmd) CombinedOutput() ([]byte, error) {
+	if c.stdoutSet {
+		return nil, errors.New("exec: Stdout already set")
+	}
+	if c.stderrSet {
+		return nil, errors.New("exec: Stderr already set")
+	}
+	var out bytes.Buffer
+	w := &lockedWriter{w: &out}
+	c.SetStdout(w)
+	c.SetStderr(w)
+	err := c.Run()
+	return out.Bytes(), err
+}
+
+// lockedWriter serializes the writes of the standard output and error of a command.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// kick makes the reaping goroutine run a pass.
+func (r *Reaper) kick() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
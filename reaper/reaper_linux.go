@@ -0,0 +1,200 @@
+package reaper
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+const prSetChildSubreaper = 36
+
+// Start makes the process a child subreaper and starts reaping the orphans reparented to
+// it as they exit, until Close. There can only be one Reaper at a time in a process.
+func Start(opts Options) (*Reaper, error) {
+	if !running.CompareAndSwap(false, true) {
+		return nil, ErrRunning
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, 1, 0); errno != 0 {
+		running.Store(false)
+		return nil, os.NewSyscallError("prctl", errno)
+	}
+	r := &Reaper{
+		opts: opts,
+		own:  make(map[int]int),
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	sigchld := make(chan os.Signal, 1)
+	signal.Notify(sigchld, syscall.SIGCHLD)
+	go func() {
+		defer close(r.done)
+		defer signal.Stop(sigchld)
+		// Orphans may have exited before the first SIGCHLD.
+		r.reap()
+		for {
+			select {
+			case <-sigchld:
+			case <-r.wake:
+			case <-r.stop:
+				return
+			}
+			r.reap()
+		}
+	}()
+	return r, nil
+}
+
+// Orphans returns the PIDs of the orphans reparented to the process that are still
+// running, in increasing order.
+func (r *Reaper) Orphans() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.orphans()
+}
+
+func (r *Reaper) orphans() []int {
+	var pids []int
+	for _, pid := range children() {
+		if r.own[pid] == 0 {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// Close stops reaping once the orphans still running are gone: it sends them SIGTERM and,
+// if they have not all exited when ctx is done, SIGKILL, and returns the error of ctx in
+// that case. Orphans cannot be confused with other processes reusing their PIDs, which
+// they keep until they are reaped. The process then stops being a subreaper.
+func (r *Reaper) Close(ctx context.Context) error {
+	close(r.stop)
+	<-r.done
+	defer running.Store(false)
+	defer syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, 0, 0)
+
+	sig := syscall.SIGTERM
+	sent := make(map[int]syscall.Signal)
+	var err error
+	for {
+		r.reap()
+		r.mu.Lock()
+		orphans := r.orphans()
+		r.mu.Unlock()
+		if len(orphans) == 0 {
+			return err
+		}
+		// Orphans reparented since the last round, e.g. the children of those that exited,
+		// are sent the signal too.
+		for _, pid := range orphans {
+			if sent[pid] != sig {
+				syscall.Kill(pid, sig)
+				sent[pid] = sig
+			}
+		}
+		done := ctx.Done()
+		if err != nil {
+			done = nil
+		}
+		select {
+		case <-done:
+			err = ctx.Err()
+			sig = syscall.SIGKILL
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// reap reaps the children that exited, except the processes of commands, unless a command
+// is being started.
+func (r *Reaper) reap() {
+	var reaped []Reaped
+	r.mu.Lock()
+	if r.starting > 0 {
+		r.deferred = true
+		r.mu.Unlock()
+		return
+	}
+	r.deferred = false
+	for _, pid := range r.orphans() {
+		name := comm(pid)
+		var ws syscall.WaitStatus
+		if wpid, err := syscall.Wait4(pid, &ws, syscall.WNOHANG, nil); err != nil || wpid != pid {
+			continue
+		}
+		rp := Reaped{PID: pid, Name: name, ExitCode: ws.ExitStatus()}
+		if ws.Signaled() {
+			rp.ExitCode = -1
+			rp.Signal = cdsexec.SignalName(ws.Signal())
+		}
+		reaped = append(reaped, rp)
+	}
+	r.mu.Unlock()
+	if r.opts.OnReap != nil {
+		for _, rp := range reaped {
+			r.opts.OnReap(rp)
+		}
+	}
+}
+
+// children returns the PIDs of the children of the process, in increasing order.
+func children() []int {
+	var pids []int
+	files, _ := filepath.Glob("/proc/self/task/*/children")
+	if len(files) > 0 {
+		for _, file := range files {
+			data, _ := os.ReadFile(file)
+			for _, f := range strings.Fields(string(data)) {
+				if pid, err := strconv.Atoi(f); err == nil {
+					pids = append(pids, pid)
+				}
+			}
+		}
+	} else {
+		// Kernels built without CONFIG_PROC_CHILDREN: look for the processes whose parent
+		// is the process.
+		self := os.Getpid()
+		entries, _ := os.ReadDir("/proc")
+		for _, e := range entries {
+			pid, err := strconv.Atoi(e.Name())
+			if err != nil {
+				continue
+			}
+			if ppid, ok := parent(pid); ok && ppid == self {
+				pids = append(pids, pid)
+			}
+		}
+	}
+	sort.Ints(pids)
+	return pids
+}
+
+// parent returns the PID of the parent of pid, from /proc/<pid>/stat.
+func parent(pid int) (int, bool) {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return 0, false
+	}
+	// The name in parentheses may contain spaces and parentheses itself.
+	s := string(data)
+	fields := strings.Fields(s[strings.LastIndexByte(s, ')')+1:])
+	if len(fields) < 2 {
+		return 0, false
+	}
+	ppid, err := strconv.Atoi(fields[1])
+	return ppid, err == nil
+}
+
+// comm returns the name of the executable of pid.
+func comm(pid int) string {
+	data, _ := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/comm")
+	return strings.TrimSpace(string(data))
+}
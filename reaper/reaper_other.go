@@ -0,0 +1,21 @@
+//go:build !linux
+
+package reaper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+// Start fails with errors.ErrUnsupported, since child subreapers are specific to Linux.
+func Start(opts Options) (*Reaper, error) {
+	return nil, fmt.Errorf("reaper: %w on %s", errors.ErrUnsupported, runtime.GOOS)
+}
+
+// Orphans returns nil.
+func (r *Reaper) Orphans() []int { return nil }
+
+// Close does nothing.
+func (r *Reaper) Close(ctx context.Context) error { return nil }
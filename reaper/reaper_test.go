@@ -0,0 +1,133 @@
+package reaper_test
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/reaper"
+)
+
+// start starts a Reaper sending what it reaps to the returned channel.
+func start(t *testing.T) (*reaper.Reaper, chan reaper.Reaped) {
+	t.Helper()
+	if runtime.GOOS != "linux" {
+		t.Skip("child subreapers are specific to Linux")
+	}
+	reaped := make(chan reaper.Reaped, 1000)
+	r, err := reaper.Start(reaper.Options{OnReap: func(rp reaper.Reaped) { reaped <- rp }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, reaped
+}
+
+// orphan runs command in the background of a shell that exits at once and returns its PID.
+func orphan(t *testing.T, ctor cdsexec.CommandConstructor, command string) int {
+	t.Helper()
+	out, err := ctor(context.Background(), "sh", "-c", command+" >/dev/null 2>&1 & echo $!").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pid
+}
+
+func awaitReaped(t *testing.T, reaped chan reaper.Reaped, pid int) reaper.Reaped {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case rp := <-reaped:
+			if rp.PID == pid {
+				return rp
+			}
+		case <-timeout:
+			t.Fatalf("Expected orphan %d to be reaped", pid)
+		}
+	}
+}
+
+func TestReaper(t *testing.T) {
+	r, reaped := start(t)
+	if _, err := reaper.Start(reaper.Options{}); !errors.Is(err, reaper.ErrRunning) {
+		t.Errorf("Expected a second Reaper to fail, got %v", err)
+	}
+	ctor := r.Constructor(cdsexec.CommandContext)
+
+	pid := orphan(t, ctor, "sleep 0.2")
+	if rp := awaitReaped(t, reaped, pid); rp.Name != "sleep" || rp.ExitCode != 0 || rp.Signal != "" {
+		t.Errorf("Unexpected reaped orphan %+v", rp)
+	}
+
+	err := ctor(context.Background(), "sh", "-c", "echo failed >&2; exit 3").Run()
+	if code := cdsexec.ExitCode(err); code != 3 {
+		t.Errorf("Expected the exit code of a command to be kept, got %d (%v)", code, err)
+	}
+	_, err = ctor(context.Background(), "sh", "-c", "echo failed >&2; exit 4").Output()
+	var ee *exec.ExitError
+	if !errors.As(err, &ee) || ee.ExitCode() != 4 || string(ee.Stderr) != "failed\n" {
+		t.Errorf("Expected Output to collect standard error, got %v", err)
+	}
+
+	// Commands exiting while orphans are reaped keep their exit status.
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				out, err := ctor(context.Background(), "sh", "-c", "true & echo ok").CombinedOutput()
+				if err != nil || string(out) != "ok\n" {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("Unexpected failure of a command: %v", err)
+	}
+
+	pid = orphan(t, ctor, "sleep 30")
+	if orphans := r.Orphans(); !slices.Contains(orphans, pid) {
+		t.Errorf("Expected %d among the orphans, got %v", pid, orphans)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := r.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if rp := awaitReaped(t, reaped, pid); rp.ExitCode != -1 || rp.Signal != "TERM" {
+		t.Errorf("Expected the orphan to be terminated, got %+v", rp)
+	}
+}
+
+func TestCloseKill(t *testing.T) {
+	r, reaped := start(t)
+	pid := orphan(t, r.Constructor(cdsexec.CommandContext), `trap "" TERM; sleep 30`)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := r.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected Close to give up waiting, got %v", err)
+	}
+	if rp := awaitReaped(t, reaped, pid); rp.Signal != "KILL" {
+		t.Errorf("Expected the orphan to be killed, got %+v", rp)
+	}
+	if orphans := r.Orphans(); len(orphans) != 0 {
+		t.Errorf("Expected no orphans left, got %v", orphans)
+	}
+}
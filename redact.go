@@ -0,0 +1,259 @@
+package cdsexec
+
+import (
+	"context"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// DefaultMask replaces redacted values.
+const DefaultMask = "***"
+
+// RedactionRule configures a Redactor.
+type RedactionRule func(*Redactor)
+
+// RedactFlags masks the value following any of the given flags, both as "--flag value" and
+// "--flag=value".
+func RedactFlags(flags ...string) RedactionRule {
+	return func(r *Redactor) { r.flags = append(r.flags, flags...) }
+}
+
+// RedactEnv masks the value of environment variables whose name matches any of the given
+// path.Match patterns, e.g. "*_TOKEN".
+func RedactEnv(patterns ...string) RedactionRule {
+	return func(r *Redactor) { r.env = append(r.env, patterns...) }
+}
+
+// RedactPattern masks text matching re. If re has capture groups only the groups are masked,
+// otherwise the whole match is.
+func RedactPattern(re *regexp.Regexp) RedactionRule {
+	return func(r *Redactor) { r.patterns = append(r.patterns, re) }
+}
+
+// WithMask sets the replacement for redacted values. The default is DefaultMask.
+func WithMask(mask string) RedactionRule {
+	return func(r *Redactor) { r.mask = mask }
+}
+
+// Redactor masks secrets in command lines, environments and messages. A nil *Redactor
+// leaves everything unchanged.
+type Redactor struct {
+	flags    []string
+	env      []string
+	patterns []*regexp.Regexp
+	mask     string
+}
+
+// NewRedactor creates a Redactor from the given rules.
+func NewRedactor(rules ...RedactionRule) *Redactor {
+	r := &Redactor{mask: DefaultMask}
+	for _, rule := range rules {
+		rule(r)
+	}
+	return r
+}
+
+// Args returns a copy of args with secret values masked.
+func (r *Redactor) Args(args []string) []string {
+	if r == nil || args == nil {
+		return args
+	}
+	out := make([]string, len(args))
+	maskNext := false
+	for i, a := range args {
+		switch {
+		case maskNext:
+			out[i] = r.mask
+			maskNext = false
+			continue
+		case r.isFlag(a):
+			maskNext = true
+			out[i] = a
+			continue
+		}
+		if name, _, ok := strings.Cut(a, "="); ok && r.isFlag(name) {
+			out[i] = name + "=" + r.mask
+			continue
+		}
+		out[i] = r.Text(a)
+	}
+	return out
+}
+
+// Env returns a copy of env with the values of secret variables masked.
+func (r *Redactor) Env(env []string) []string {
+	if r == nil || env == nil {
+		return env
+	}
+	out := make([]string, len(env))
+	for i, kv := range env {
+		name, _, ok := strings.Cut(kv, "=")
+		if ok && r.isSecretEnv(name) {
+			out[i] = name + "=" + r.mask
+			continue
+		}
+		out[i] = r.Text(kv)
+	}
+	return out
+}
+
+// Text masks every match of the pattern rules in s.
+func (r *Redactor) Text(s string) string {
+	if r == nil {
+		return s
+	}
+	for _, re := range r.patterns {
+		if re.NumSubexp() == 0 {
+			s = re.ReplaceAllLiteralString(s, r.mask)
+			continue
+		}
+		s = r.maskGroups(re, s)
+	}
+	return s
+}
+
+// Spec returns a copy of s with its arguments and environment redacted.
+func (r *Redactor) Spec(s CommandSpec) CommandSpec {
+	if r == nil {
+		return s
+	}
+	s.Name = r.Text(s.Name)
+	s.Args = r.Args(s.Args)
+	s.Env = r.Env(s.Env)
+	return s
+}
+
+// Error returns err with its message redacted. The returned error unwraps to err.
+func (r *Redactor) Error(err error) error {
+	if r == nil || err == nil {
+		return err
+	}
+	msg := r.Text(err.Error())
+	if msg == err.Error() {
+		return err
+	}
+	return &redactedError{msg: msg, err: err}
+}
+
+func (r *Redactor) isFlag(a string) bool {
+	for _, f := range r.flags {
+		if a == f {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Redactor) isSecretEnv(name string) bool {
+	for _, p := range r.env {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Redactor) maskGroups(re *regexp.Regexp, s string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range re.FindAllStringSubmatchIndex(s, -1) {
+		for g := 2; g < len(m); g += 2 {
+			if m[g] < 0 || m[g] < last {
+				continue
+			}
+			b.WriteString(s[last:m[g]])
+			b.WriteString(r.mask)
+			last = m[g+1]
+		}
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string { return e.msg }
+func (e *redactedError) Unwrap() error { return e.err }
+
+type redactorKey struct{}
+
+// ContextWithRedactor returns a context carrying r.
+func ContextWithRedactor(ctx context.Context, r *Redactor) context.Context {
+	return context.WithValue(ctx, redactorKey{}, r)
+}
+
+// RedactorFromContext returns the Redactor carried by ctx, or nil.
+func RedactorFromContext(ctx context.Context) *Redactor {
+	if ctx == nil {
+		return nil
+	}
+	r, _ := ctx.Value(redactorKey{}).(*Redactor)
+	return r
+}
+
+// Redact returns a CommandConstructor that installs r for every layer built by base, so
+// wrappers such as logcmd redact what they record, and that redacts the errors and String()
+// of the commands it returns.
+func Redact(base CommandConstructor, r *Redactor) CommandConstructor {
+	return func(ctx context.Context, name string, arg ...string) Commander {
+		return &redactedCmd{
+			Commander: base(ContextWithRedactor(ctx, r), name, arg...),
+			spec:      CommandSpec{Name: name, Args: arg},
+			r:         r,
+		}
+	}
+}
+
+// redactedCmd redacts the errors and command line of the Commander it embeds.
+type redactedCmd struct {
+	Commander
+	spec CommandSpec
+	r    *Redactor
+}
+
+func (c *redactedCmd) Run() error {
+	return c.r.Error(c.Commander.Run())
+}
+
+func (c *redactedCmd) Output() ([]byte, error) {
+	out, err := c.Commander.Output()
+	return out, c.r.Error(err)
+}
+
+func (c *redactedCmd) CombinedOutput() ([]byte, error) {
+	out, err := c.Commander.CombinedOutput()
+	return out, c.r.Error(err)
+}
+
+func (c *redactedCmd) Start() error {
+	return c.r.Error(c.Commander.Start())
+}
+
+func (c *redactedCmd) Wait() error {
+	return c.r.Error(c.Commander.Wait())
+}
+
+func (c *redactedCmd) StdinPipe() (io.WriteCloser, error) {
+	p, err := c.Commander.StdinPipe()
+	return p, c.r.Error(err)
+}
+
+func (c *redactedCmd) StdoutPipe() (io.ReadCloser, error) {
+	p, err := c.Commander.StdoutPipe()
+	return p, c.r.Error(err)
+}
+
+func (c *redactedCmd) StderrPipe() (io.ReadCloser, error) {
+	p, err := c.Commander.StderrPipe()
+	return p, c.r.Error(err)
+}
+
+// String returns the redacted command line.
+func (c *redactedCmd) String() string {
+	return c.r.Spec(c.spec).String()
+}
@@ -0,0 +1,88 @@
+package cdsexec_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"os/exec"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/logcmd"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+func newTestRedactor() *cdsexec.Redactor {
+	return cdsexec.NewRedactor(
+		cdsexec.RedactFlags("--password", "-p"),
+		cdsexec.RedactEnv("*_TOKEN", "CHAP_*"),
+		cdsexec.RedactPattern(regexp.MustCompile(`secret=(\S+)`)),
+	)
+}
+
+func TestRedactorArgs(t *testing.T) {
+	r := newTestRedactor()
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"Separate Value", []string{"login", "--password", "hunter2", "-v"}, []string{"login", "--password", "***", "-v"}},
+		{"Joined Value", []string{"--password=hunter2"}, []string{"--password=***"}},
+		{"Short Flag", []string{"-p", "hunter2"}, []string{"-p", "***"}},
+		{"Pattern Group", []string{"node.secret=abc", "other"}, []string{"node.secret=***", "other"}},
+		{"Nothing Secret", []string{"-l", "/dev"}, []string{"-l", "/dev"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := r.Args(tt.args)
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRedactorEnvAndNil(t *testing.T) {
+	r := newTestRedactor()
+	got := r.Env([]string{"API_TOKEN=abc", "CHAP_SECRET=def", "PATH=/bin"})
+	want := []string{"API_TOKEN=***", "CHAP_SECRET=***", "PATH=/bin"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	var none *cdsexec.Redactor
+	if got := none.Text("secret=abc"); got != "secret=abc" {
+		t.Errorf("Expected nil redactor to leave text unchanged, got %q", got)
+	}
+}
+
+func TestRedactCommander(t *testing.T) {
+	r := newTestRedactor()
+	base := mockcmd.MakeMockCmdWithOutputSpecificError("", errors.New("login failed with secret=abc"), nil)
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	ctor := cdsexec.Redact(logcmd.New(base, logger), r)
+
+	cmd := ctor(context.Background(), "iscsiadm", "--password", "hunter2")
+	err := cmd.Run()
+	if err == nil || strings.Contains(err.Error(), "abc") {
+		t.Fatalf("Expected redacted error, got %v", err)
+	}
+	if got := cmd.(interface{ String() string }).String(); got != "iscsiadm --password '***'" {
+		t.Errorf("Unexpected String(): %s", got)
+	}
+	if logs := buf.String(); strings.Contains(logs, "hunter2") || strings.Contains(logs, "abc") {
+		t.Errorf("Expected secrets to be redacted from logs, got %s", logs)
+	}
+
+	err = cdsexec.Redact(cdsexec.CommandContext, r)(context.Background(), "sh", "-c", "exit 4").Run()
+	var ee *exec.ExitError
+	if !errors.As(err, &ee) || ee.ExitCode() != 4 {
+		t.Errorf("Expected redacted errors to unwrap to *exec.ExitError, got %v", err)
+	}
+}
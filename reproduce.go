@@ -0,0 +1,41 @@
+package cdsexec
+
+import (
+	"bytes"
+	"context"
+	"slices"
+)
+
+// Record holds what is needed to run a command again exactly as it ran: its arguments,
+// working directory, environment and standard input. It is the input of Reproduce, built
+// from a Result with Result.Record or from the records of auditcmd.
+type Record struct {
+	Name string   `json:"name"`
+	Args []string `json:"args,omitempty"`
+	Dir  string   `json:"dir,omitempty"`
+	// Env is the environment of the command; nil stands for the environment of the
+	// process reproducing it.
+	Env []string `json:"env,omitempty"`
+	// Stdin is the standard input of the command, if it had one and it was recorded.
+	Stdin []byte `json:"stdin,omitempty"`
+}
+
+// Record returns the Record of the command of r. Results do not hold standard input.
+func (r Result) Record() Record {
+	return Record{Name: r.Name, Args: slices.Clone(r.Args), Dir: r.Dir, Env: slices.Clone(r.Env)}
+}
+
+// Reproduce runs the command of rec again with ctor and returns its result, with its
+// output collected as RunScript does. ctor decides where the command runs: a support
+// engineer replaying a failed operation passes the constructor of a lab host, or a drycmd
+// Recorder to see what would run first. A command exiting unsuccessfully returns a
+// *CommandError quoting its standard error.
+func Reproduce(ctx context.Context, ctor CommandConstructor, rec Record) (Result, error) {
+	spec := CommandSpec{Name: rec.Name, Args: slices.Clone(rec.Args), Dir: rec.Dir, Env: slices.Clone(rec.Env)}
+	if rec.Stdin != nil {
+		spec.Stdin = bytes.NewReader(rec.Stdin)
+	}
+	r := Result{Name: spec.Name, Args: spec.Args, Dir: spec.Dir, Env: spec.Env}
+	err := runSpec(ctx, ctor, spec, &r)
+	return r, err
+}
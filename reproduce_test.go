@@ -0,0 +1,48 @@
+package cdsexec_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+func TestReproduce(t *testing.T) {
+	dir := t.TempDir()
+	spec := cdsexec.CommandSpec{
+		Name:  "sh",
+		Args:  []string{"-c", `read line; echo "$line $POOL $PWD"; echo failed >&2; exit 4`},
+		Dir:   dir,
+		Env:   cdsexec.MergeEnv(nil, "POOL=tank"),
+		Stdin: strings.NewReader("resize\n"),
+	}
+	var failed cdsexec.Result
+	cmd := spec.Command(context.Background(), cdsexec.Wrap(cdsexec.CommandContext, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		err := next(inv)
+		failed = inv.Result(err)
+		return err
+	}))
+	if err := cmd.Run(); cdsexec.ExitCode(err) != 4 {
+		t.Fatalf("Expected exit code 4, got %v", err)
+	}
+
+	rec := failed.Record()
+	if rec.Dir != dir || !slices.Equal(rec.Env, spec.Env) || !slices.Equal(rec.Args, spec.Args) {
+		t.Fatalf("Unexpected record %+v", rec)
+	}
+	rec.Stdin = []byte("resize\n")
+	r, err := cdsexec.Reproduce(context.Background(), cdsexec.CommandContext, rec)
+	var ce *cdsexec.CommandError
+	if !errors.As(err, &ce) || r.ExitCode != 4 {
+		t.Fatalf("Expected the failure to be reproduced, got %v", err)
+	}
+	if want := "resize tank " + dir + "\n"; string(r.Stdout) != want {
+		t.Errorf("Expected %q, got %q", want, r.Stdout)
+	}
+	if string(r.Stderr) != "failed\n" || r.Dir != dir {
+		t.Errorf("Unexpected result %+v", r)
+	}
+}
@@ -0,0 +1,51 @@
+package cdsexec
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Result describes a finished command execution. It is encoded to JSON with the output as
+// base64 and durations in nanoseconds.
+type Result struct {
+	Name string   `json:"name"`
+	Args []string `json:"args,omitempty"`
+	// Dir and Env are the working directory and environment of the command, where nil
+	// Env stands for the environment of the current process. Env is left out of the JSON
+	// form of a Result, as it may hold secrets.
+	Dir       string        `json:"dir,omitempty"`
+	Env       []string      `json:"-"`
+	ExitCode  int           `json:"exit_code"`
+	Stdout    []byte        `json:"stdout,omitempty"`
+	Stderr    []byte        `json:"stderr,omitempty"`
+	StartTime time.Time     `json:"start_time"`
+	Duration  time.Duration `json:"duration"`
+	Phases    Phases        `json:"phases"`
+}
+
+// ExitCode returns the exit code carried by err: 0 for nil, the process exit code for
+// an *exec.ExitError or *ExitError, and -1 for any other error.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		return ee.ExitCode()
+	}
+	var xe *ExitError
+	if errors.As(err, &xe) {
+		return xe.Code
+	}
+	return -1
+}
+
+// exitCodeOf prefers the process state over the error when one is available.
+func exitCodeOf(state *os.ProcessState, err error) int {
+	if state != nil {
+		return state.ExitCode()
+	}
+	return ExitCode(err)
+}
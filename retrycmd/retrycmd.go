@@ -0,0 +1,149 @@
+package retrycmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// Backoff returns how long to wait before the given retry; attempt starts at 1 for the first retry.
+type Backoff func(attempt int) time.Duration
+
+// ConstantBackoff waits d before every retry.
+func ConstantBackoff(d time.Duration) Backoff {
+	return func(int) time.Duration { return d }
+}
+
+// ExponentialBackoff doubles the wait before every retry, starting at initial and capped at max.
+func ExponentialBackoff(initial, max time.Duration) Backoff {
+	return func(attempt int) time.Duration {
+		d := initial
+		for i := 1; i < attempt && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			d = max
+		}
+		return d
+	}
+}
+
+// Policy controls how failed commands are retried.
+type Policy struct {
+	// MaxAttempts is the total number of attempts. Zero means 3.
+	MaxAttempts int
+	// Backoff computes the wait between attempts, measured on the cdsexec.Clock of the
+	// context of the command. Nil means no wait.
+	Backoff Backoff
+	// RetryIf decides whether a failed attempt is retried. Nil retries every error.
+	RetryIf func(cdsexec.Result, error) bool
+}
+
+// Attempt records the outcome of one execution.
+type Attempt struct {
+	Result cdsexec.Result
+	Err    error
+}
+
+// Error is returned when a command still fails after it has been retried, or when its
+// context is done while waiting to retry it. It unwraps to the error of the last attempt
+// and to Cancel.
+type Error struct {
+	Attempts []Attempt
+	// Cancel is the error of the context if it was done while waiting to retry, nil
+	// otherwise.
+	Cancel error
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "command failed after %d attempts", len(e.Attempts))
+	for i, a := range e.Attempts {
+		fmt.Fprintf(&b, "; attempt %d: %v", i+1, a.Err)
+	}
+	if e.Cancel != nil {
+		fmt.Fprintf(&b, "; retry cancelled: %v", e.Cancel)
+	}
+	return b.String()
+}
+
+func (e *Error) Unwrap() []error {
+	errs := []error{e.Attempts[len(e.Attempts)-1].Err}
+	if e.Cancel != nil {
+		errs = append(errs, e.Cancel)
+	}
+	return errs
+}
+
+// New returns a CommandConstructor that re-executes failed commands built by base according
+// to p. Commands that could not run again as they first did are executed only once: those
+// started with Start or with attached pipes, those whose standard input cannot be rewound,
+// and those writing their output to writers of the caller, which would get the output of
+// every attempt. Only the error of a command that was retried, or whose retry was
+// cancelled, is wrapped in an *Error.
+func New(base cdsexec.CommandConstructor, p Policy) cdsexec.CommandConstructor {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 3
+	}
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		if !replayable(inv) {
+			return next(inv)
+		}
+
+		var attempts []Attempt
+		for {
+			inv.Output = nil
+			if s, ok := inv.Spec.Stdin.(io.Seeker); ok {
+				if _, err := s.Seek(0, io.SeekStart); err != nil {
+					return err
+				}
+			}
+			err := next(inv)
+			if err == nil {
+				return nil
+			}
+			res := inv.Result(err)
+			attempts = append(attempts, Attempt{Result: res, Err: err})
+			if len(attempts) >= p.MaxAttempts || inv.Ctx.Err() != nil || (p.RetryIf != nil && !p.RetryIf(res, err)) {
+				break
+			}
+			if p.Backoff != nil {
+				if werr := cdsexec.Sleep(inv.Ctx, p.Backoff(len(attempts))); werr != nil {
+					return &Error{Attempts: attempts, Cancel: werr}
+				}
+			}
+		}
+		if len(attempts) == 1 {
+			return attempts[0].Err
+		}
+		return &Error{Attempts: attempts}
+	})
+}
+
+// replayable reports whether the command of inv can run again as it first did: its
+// standard input is nil or can be rewound, and no writer of the caller gets its output.
+func replayable(inv *cdsexec.Invocation) bool {
+	if !inv.Replayable() || inv.Spec.Stdout != nil || inv.Spec.Stderr != nil {
+		return false
+	}
+	if inv.Spec.Stdin == nil {
+		return true
+	}
+	_, ok := inv.Spec.Stdin.(io.Seeker)
+	return ok
+}
+
+// RetryOnExitCodes returns a RetryIf function retrying only the given exit codes.
+func RetryOnExitCodes(codes ...int) func(cdsexec.Result, error) bool {
+	return func(r cdsexec.Result, _ error) bool {
+		for _, c := range codes {
+			if r.ExitCode == c {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,171 @@
+package retrycmd_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+	"github.com/cirrusdata/cdsexec/retrycmd"
+)
+
+// flaky returns a constructor whose commands fail until the given attempt.
+func flaky(succeedAt int, calls *int) cdsexec.CommandConstructor {
+	return func(ctx context.Context, name string, arg ...string) cdsexec.Commander {
+		*calls++
+		if *calls < succeedAt {
+			return mockcmd.MakeMockCmdWithOutputSpecificError("", errors.New("iscsiadm: session busy"), nil)(ctx, name, arg...)
+		}
+		return mockcmd.MakeMockCmdWithOutput("ok", nil)(ctx, name, arg...)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name      string
+		succeedAt int
+		policy    retrycmd.Policy
+		wantCalls int
+		wantErr   bool
+	}{
+		{"First Attempt", 1, retrycmd.Policy{}, 1, false},
+		{"Third Attempt", 3, retrycmd.Policy{MaxAttempts: 3}, 3, false},
+		{"Exhausted", 5, retrycmd.Policy{MaxAttempts: 2, Backoff: retrycmd.ConstantBackoff(time.Millisecond)}, 2, true},
+		{"Not Retryable", 5, retrycmd.Policy{RetryIf: func(cdsexec.Result, error) bool { return false }}, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			ctor := retrycmd.New(flaky(tt.succeedAt, &calls), tt.policy)
+			out, err := ctor(context.Background(), "iscsiadm", "-m", "node", "--login").Output()
+			if calls != tt.wantCalls {
+				t.Errorf("Expected %d calls, got %d", tt.wantCalls, calls)
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Expected an error")
+				}
+				return
+			}
+			if err != nil || string(out) != "ok" {
+				t.Errorf("Expected %q, got %q, %v", "ok", out, err)
+			}
+		})
+	}
+}
+
+func TestRetryErrorHistory(t *testing.T) {
+	ctor := retrycmd.New(cdsexec.CommandContext, retrycmd.Policy{
+		MaxAttempts: 3,
+		RetryIf:     retrycmd.RetryOnExitCodes(7),
+	})
+
+	err := ctor(context.Background(), "sh", "-c", "exit 7").Run()
+	var re *retrycmd.Error
+	if !errors.As(err, &re) {
+		t.Fatalf("Expected *retrycmd.Error, got %v", err)
+	}
+	if len(re.Attempts) != 3 || re.Attempts[0].Result.ExitCode != 7 {
+		t.Errorf("Unexpected attempts: %+v", re.Attempts)
+	}
+	var ee *exec.ExitError
+	if !errors.As(err, &ee) || ee.ExitCode() != 7 {
+		t.Errorf("Expected error to unwrap to the last *exec.ExitError")
+	}
+	if !strings.Contains(err.Error(), "after 3 attempts") {
+		t.Errorf("Unexpected message: %v", err)
+	}
+
+	if err := ctor(context.Background(), "sh", "-c", "exit 8").Run(); cdsexec.ExitCode(err) != 8 || errors.As(err, &re) {
+		t.Errorf("Expected a plain exit error for a non-retryable exit code, got %v", err)
+	}
+}
+
+func TestRetryHonorsContext(t *testing.T) {
+	calls := 0
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	ctor := retrycmd.New(flaky(100, &calls), retrycmd.Policy{MaxAttempts: 10, Backoff: retrycmd.ConstantBackoff(time.Hour)})
+
+	err := ctor(ctx, "iscsiadm").Run()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected 1 call, got %d", calls)
+	}
+	var re *retrycmd.Error
+	if !errors.As(err, &re) || len(re.Attempts) != 1 || re.Cancel != context.DeadlineExceeded || !strings.Contains(err.Error(), "session busy") {
+		t.Errorf("Expected the attempt and the cancellation apart, got %v", err)
+	}
+}
+
+func TestRetryInput(t *testing.T) {
+	policy := retrycmd.Policy{MaxAttempts: 3, RetryIf: retrycmd.RetryOnExitCodes(7)}
+	ctor := retrycmd.New(cdsexec.CommandContext, policy)
+	script := `cat >> "$0"; exit 7`
+
+	// Each attempt reads the whole of a standard input that can be rewound.
+	log := filepath.Join(t.TempDir(), "stdin")
+	cmd := ctor(context.Background(), "sh", "-c", script, log)
+	cmd.SetStdin(strings.NewReader("label: gpt\n"))
+	var re *retrycmd.Error
+	if err := cmd.Run(); !errors.As(err, &re) || len(re.Attempts) != 3 {
+		t.Fatalf("Expected 3 attempts, got %v", err)
+	}
+	if got, _ := os.ReadFile(log); string(got) != strings.Repeat("label: gpt\n", 3) {
+		t.Errorf("Expected the input of every attempt, got %q", got)
+	}
+
+	// A standard input read once, or writers of the caller, run the command once.
+	log = filepath.Join(t.TempDir(), "stdin")
+	cmd = ctor(context.Background(), "sh", "-c", script, log)
+	cmd.SetStdin(bytes.NewBufferString("label: gpt\n"))
+	if err := cmd.Run(); cdsexec.ExitCode(err) != 7 || errors.As(err, &re) {
+		t.Errorf("Expected a single attempt, got %v", err)
+	}
+	var out strings.Builder
+	cmd = ctor(context.Background(), "sh", "-c", "echo attempt; exit 7")
+	cmd.SetStdout(&out)
+	if err := cmd.Run(); cdsexec.ExitCode(err) != 7 || out.String() != "attempt\n" {
+		t.Errorf("Expected a single attempt, got %q, %v", out.String(), err)
+	}
+}
+
+func TestRetryBackoffOnClock(t *testing.T) {
+	calls := 0
+	clock := mockcmd.NewFakeClock(time.Now())
+	ctx := cdsexec.ContextWithClock(context.Background(), clock)
+	ctor := retrycmd.New(flaky(3, &calls), retrycmd.Policy{Backoff: retrycmd.ExponentialBackoff(time.Minute, time.Hour)})
+
+	done := make(chan error)
+	go func() { done <- ctor(ctx, "iscsiadm").Run() }()
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
+	clock.BlockUntil(1)
+	clock.Advance(2 * time.Minute)
+	if err := <-done; err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 calls, got %d", calls)
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	b := retrycmd.ExponentialBackoff(100*time.Millisecond, time.Second)
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second}
+	for i, w := range want {
+		if got := b(i + 1); got != w {
+			t.Errorf("Attempt %d: expected %v, got %v", i+1, w, got)
+		}
+	}
+}
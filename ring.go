@@ -0,0 +1,86 @@
+package cdsexec
+
+import "sync"
+
+// RingBuffer is an io.Writer keeping the last bytes written to it, such as the output of a
+// long-running command before it died, in bounded memory. It is safe for concurrent use,
+// so it can be read while the command runs.
+type RingBuffer struct {
+	mu      sync.Mutex
+	buf     []byte
+	written int64
+}
+
+// NewRingBuffer returns a RingBuffer keeping the last size bytes written to it.
+func NewRingBuffer(size int) *RingBuffer {
+	if size <= 0 {
+		panic("cdsexec: non-positive RingBuffer size")
+	}
+	return &RingBuffer{buf: make([]byte, size)}
+}
+
+// Write writes p to the buffer, overwriting the oldest bytes once it is full.
+func (r *RingBuffer) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := len(p)
+	size := len(r.buf)
+	if len(p) > size {
+		r.written += int64(len(p) - size)
+		p = p[len(p)-size:]
+	}
+	pos := int(r.written % int64(size))
+	c := copy(r.buf[pos:], p)
+	copy(r.buf, p[c:])
+	r.written += int64(len(p))
+	return n, nil
+}
+
+// Bytes returns a copy of the bytes kept, oldest first.
+func (r *RingBuffer) Bytes() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	size := int64(len(r.buf))
+	if r.written <= size {
+		return append([]byte(nil), r.buf[:r.written]...)
+	}
+	pos := r.written % size
+	out := make([]byte, 0, size)
+	out = append(out, r.buf[pos:]...)
+	return append(out, r.buf[:pos]...)
+}
+
+// String returns the bytes kept as a string.
+func (r *RingBuffer) String() string {
+	return string(r.Bytes())
+}
+
+// Written returns the total number of bytes written to the buffer.
+func (r *RingBuffer) Written() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.written
+}
+
+// Truncated reports whether bytes were dropped because the buffer was full.
+func (r *RingBuffer) Truncated() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.written > int64(len(r.buf))
+}
+
+// Reset empties the buffer.
+func (r *RingBuffer) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.written = 0
+}
+
+// CaptureTail sets the standard output and standard error of cmd to ring buffers keeping
+// their last size bytes each, and returns them.
+func CaptureTail(cmd Commander, size int) (stdout, stderr *RingBuffer) {
+	stdout, stderr = NewRingBuffer(size), NewRingBuffer(size)
+	cmd.SetStdout(stdout)
+	cmd.SetStderr(stderr)
+	return stdout, stderr
+}
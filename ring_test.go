@@ -0,0 +1,58 @@
+package cdsexec_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+func TestRingBuffer(t *testing.T) {
+	tests := []struct {
+		name      string
+		writes    []string
+		expected  string
+		truncated bool
+	}{
+		{name: "empty", expected: ""},
+		{name: "partial", writes: []string{"ab", "cd"}, expected: "abcd"},
+		{name: "full", writes: []string{"abcdefgh"}, expected: "abcdefgh"},
+		{name: "wrapped", writes: []string{"abcdef", "ghij"}, expected: "cdefghij", truncated: true},
+		{name: "oversized write", writes: []string{"ab", "0123456789xyz"}, expected: "56789xyz", truncated: true},
+		{name: "many writes", writes: strings.Split("the quick brown fox jumps", ""), expected: "ox jumps", truncated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := cdsexec.NewRingBuffer(8)
+			var total int64
+			for _, w := range tt.writes {
+				n, err := r.Write([]byte(w))
+				if n != len(w) || err != nil {
+					t.Fatalf("Expected a complete write, got %d, %v", n, err)
+				}
+				total += int64(n)
+			}
+			if r.String() != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, r.String())
+			}
+			if r.Truncated() != tt.truncated || r.Written() != total {
+				t.Errorf("Expected truncated=%v and %d bytes written, got %v and %d", tt.truncated, total, r.Truncated(), r.Written())
+			}
+		})
+	}
+}
+
+func TestCaptureTail(t *testing.T) {
+	cmd := cdsexec.CommandContext(context.Background(), "sh", "-c", "seq 1 10000; echo 'helper: fatal error' >&2; exit 1")
+	stdout, stderr := cdsexec.CaptureTail(cmd, 16)
+	if err := cmd.Run(); cdsexec.ExitCode(err) != 1 {
+		t.Fatalf("Expected exit code 1, got %v", err)
+	}
+	if stdout.String() != "9998\n9999\n10000\n" {
+		t.Errorf("Unexpected stdout tail %q", stdout.String())
+	}
+	if stderr.String() != "er: fatal error\n" {
+		t.Errorf("Unexpected stderr tail %q", stderr.String())
+	}
+}
@@ -0,0 +1,50 @@
+package safecmd
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// PanicError is returned when building or executing a command panicked.
+type PanicError struct {
+	// Command is the command line, redacted by the cdsexec.Redactor of its context.
+	Command string
+	// Value is the value passed to panic.
+	Value any
+	// Stack is the stack trace of the panicking goroutine.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("safecmd: panic while executing %s: %v", e.Command, e.Value)
+}
+
+// Unwrap returns Value if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// New returns a CommandConstructor that turns panics raised by base or the commands it
+// builds into a *PanicError and normalizes the errors they return with Normalize.
+func New(base cdsexec.CommandConstructor) cdsexec.CommandConstructor {
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) (err error) {
+		defer func() {
+			if v := recover(); v != nil {
+				r := cdsexec.RedactorFromContext(inv.Ctx)
+				err = &PanicError{Command: r.Spec(inv.Spec).String(), Value: v, Stack: debug.Stack()}
+			}
+		}()
+		return Normalize(next(inv))
+	})
+}
+
+// Normalize makes platform-specific errors match the cdsexec error values: a missing
+// executable matches cdsexec.ErrNotFound and a permission failure cdsexec.ErrPermission.
+// The message is kept and the original error remains reachable with errors.Is and errors.As.
+// It is cdsexec.Classify without a context.
+func Normalize(err error) error {
+	return cdsexec.Classify(nil, err)
+}
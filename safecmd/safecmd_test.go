@@ -0,0 +1,93 @@
+package safecmd_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+	"github.com/cirrusdata/cdsexec/safecmd"
+)
+
+func TestPanicIsolation(t *testing.T) {
+	panicking := func(ctx context.Context, name string, arg ...string) cdsexec.Commander {
+		panic("backend not configured")
+	}
+	panicOnRun := mockcmd.MakeMockCmdWithOutput("", func(*mockcmd.MockCmd) error {
+		panic(errors.New("nil map in mock"))
+	})
+
+	tests := []struct {
+		name  string
+		base  cdsexec.CommandConstructor
+		run   func(cdsexec.Commander) error
+		value string
+	}{
+		{"constructor", panicking, func(c cdsexec.Commander) error { return c.Run() }, "backend not configured"},
+		{"run", panicOnRun, func(c cdsexec.Commander) error { return c.Run() }, "nil map in mock"},
+		{"output", panicOnRun, func(c cdsexec.Commander) error { _, err := c.Output(); return err }, "nil map in mock"},
+		{"start", panicking, func(c cdsexec.Commander) error { return c.Start() }, "backend not configured"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.run(safecmd.New(tt.base)(context.Background(), "lsblk", "-J"))
+			var pe *safecmd.PanicError
+			if !errors.As(err, &pe) {
+				t.Fatalf("Expected *PanicError, got %v", err)
+			}
+			if pe.Command != "lsblk -J" || !strings.Contains(err.Error(), tt.value) || len(pe.Stack) == 0 {
+				t.Errorf("Unexpected panic error %q (command %q)", err, pe.Command)
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	dir := t.TempDir()
+	noexec := filepath.Join(dir, "noexec.sh")
+	if err := os.WriteFile(noexec, []byte("#!/bin/sh\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		cmd  []string
+		dir  string
+		want error
+	}{
+		{"missing binary in PATH", []string{"cdsexec-missing-tool"}, "", cdsexec.ErrNotFound},
+		{"missing absolute path", []string{filepath.Join(dir, "missing")}, "", cdsexec.ErrNotFound},
+		{"not executable", []string{noexec}, "", cdsexec.ErrPermission},
+		{"missing working directory", []string{"true"}, filepath.Join(dir, "missing"), nil},
+		{"exit status", []string{"false"}, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := safecmd.New(cdsexec.CommandContext)(context.Background(), tt.cmd[0], tt.cmd[1:]...)
+			cmd.SetDir(tt.dir)
+			err := cmd.Run()
+			if err == nil {
+				t.Fatal("Expected an error")
+			}
+			for _, target := range []error{cdsexec.ErrNotFound, cdsexec.ErrPermission} {
+				if got := errors.Is(err, target); got != (target == tt.want) {
+					t.Errorf("Expected errors.Is(%v, %v) to be %v", err, target, !got)
+				}
+			}
+		})
+	}
+
+	var ee *exec.ExitError
+	if err := safecmd.Normalize(exec.Command("false").Run()); !errors.As(err, &ee) {
+		t.Errorf("Expected exit errors to be kept, got %v", err)
+	}
+	err := safecmd.Normalize(&exec.Error{Name: "nvme", Err: exec.ErrNotFound})
+	if !errors.Is(err, cdsexec.ErrNotFound) || !errors.Is(err, exec.ErrNotFound) || err.Error() != `exec: "nvme": executable file not found in $PATH` {
+		t.Errorf("Unexpected normalized error %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package schedule_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec/schedule"
+)
+
+func TestParse(t *testing.T) {
+	// A Monday.
+	from := time.Date(2024, 3, 4, 10, 17, 30, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 3, 4, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 3, 4, 10, 30, 0, 0, time.UTC)},
+		{"0 3 * * sun", time.Date(2024, 3, 10, 3, 0, 0, 0, time.UTC)},
+		{"0 3 * * 7", time.Date(2024, 3, 10, 3, 0, 0, 0, time.UTC)},
+		{"30 9-17/4 * * mon-fri", time.Date(2024, 3, 4, 13, 30, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)},
+		// Day of month or day of week when both are restricted.
+		{"0 0 13 * fri", time.Date(2024, 3, 8, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 feb *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 3, 4, 11, 0, 0, 0, time.UTC)},
+		{"@every 90m", from.Add(90 * time.Minute)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		s, err := schedule.Parse(tt.expr)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %v", tt.expr, err)
+			continue
+		}
+		if got := s.Next(from); !got.Equal(tt.want) {
+			t.Errorf("Expected %q to be next due at %v, got %v", tt.expr, tt.want, got)
+		}
+	}
+
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "5-1 * * * *", "*/0 * * * *", "* * * foo *", "@every -1s"} {
+		if _, err := schedule.Parse(expr); err == nil {
+			t.Errorf("Expected an error parsing %q", expr)
+		}
+	}
+}
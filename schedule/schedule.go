@@ -0,0 +1,235 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// ErrDuplicate is returned by Add for a job whose name is taken.
+var ErrDuplicate = errors.New("schedule: duplicate job name")
+
+// Job is a command run on a schedule.
+type Job struct {
+	// Name identifies the job in the Scheduler.
+	Name string
+	Spec cdsexec.CommandSpec
+	// Schedule tells when the job runs, e.g. Every(time.Hour) or MustParse("0 3 * * 0").
+	Schedule Schedule
+	// Jitter delays each run by a random duration up to Jitter, so that the jobs of many
+	// hosts scheduled at the same time do not all run at once.
+	Jitter time.Duration
+}
+
+// Run is the outcome of a run of a job.
+type Run struct {
+	cdsexec.Result
+	// Scheduled is the time the run was due, before jitter.
+	Scheduled time.Time
+	Err       error
+}
+
+// Status is the state of a job in a Scheduler.
+type Status struct {
+	Name string
+	// Next is the time the job is next due, zero before the Scheduler runs or if the
+	// schedule has no further runs.
+	Next    time.Time
+	Running bool
+	// Runs is the number of runs that have finished, and Skipped the number of runs not
+	// started because the previous run was still going on.
+	Runs, Skipped int
+	// Last is the last run that finished, nil if there is none.
+	Last *Run
+}
+
+// Scheduler runs jobs on their schedules. A run is skipped when the previous run of the
+// same job has not finished, so that runs of a job never overlap.
+type Scheduler struct {
+	// Constructor constructs the commands. Nil means cdsexec.CommandContext.
+	Constructor cdsexec.CommandConstructor
+	// OnRun, if set, is called after each run of a job.
+	OnRun func(name string, run Run)
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	wake    chan struct{}
+}
+
+type entry struct {
+	job    Job
+	status Status
+}
+
+// Add adds j to s. It can be called while s runs.
+func (s *Scheduler) Add(j Job) error {
+	if j.Schedule == nil {
+		return fmt.Errorf("schedule: job %q has no schedule", j.Name)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.entries == nil {
+		s.entries = make(map[string]*entry)
+	}
+	if _, ok := s.entries[j.Name]; ok {
+		return fmt.Errorf("%w: %q", ErrDuplicate, j.Name)
+	}
+	s.entries[j.Name] = &entry{job: j, status: Status{Name: j.Name}}
+	s.notifyLocked()
+	return nil
+}
+
+// Remove removes the job name from s. A run in progress is not interrupted.
+func (s *Scheduler) Remove(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, name)
+	s.notifyLocked()
+}
+
+// Status returns the status of the job name.
+func (s *Scheduler) Status(name string) (Status, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[name]
+	if !ok {
+		return Status{}, false
+	}
+	return e.status, true
+}
+
+// Statuses returns the status of every job, sorted by name.
+func (s *Scheduler) Statuses() []Status {
+	s.mu.Lock()
+	statuses := make([]Status, 0, len(s.entries))
+	for _, e := range s.entries {
+		statuses = append(statuses, e.status)
+	}
+	s.mu.Unlock()
+	sort.Slice(statuses, func(i, k int) bool { return statuses[i].Name < statuses[k].Name })
+	return statuses
+}
+
+func (s *Scheduler) notifyLocked() {
+	if s.wake == nil {
+		return
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run runs the jobs of s on their schedules until ctx is done, measuring time on the
+// Clock of ctx, then waits for the runs in progress, whose commands are cancelled with
+// ctx, and returns the error of ctx. The first run of a job is due at the first time its
+// schedule gives after Run starts, or after the job is added, and the next ones at the
+// times it gives after the previous one was due. When runs were missed, e.g. while the
+// host was suspended, only one is started and the next is due after the current time.
+func (s *Scheduler) Run(ctx context.Context) error {
+	clock := cdsexec.ClockFromContext(ctx)
+	ctor := s.Constructor
+	if ctor == nil {
+		ctor = cdsexec.CommandContext
+	}
+	s.mu.Lock()
+	if s.wake != nil {
+		s.mu.Unlock()
+		return errors.New("schedule: scheduler already running")
+	}
+	s.wake = make(chan struct{}, 1)
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	defer func() {
+		wg.Wait()
+		s.mu.Lock()
+		s.wake = nil
+		for _, e := range s.entries {
+			e.status.Next = time.Time{}
+		}
+		s.mu.Unlock()
+	}()
+	for {
+		now := clock.Now()
+		var next time.Time
+		s.mu.Lock()
+		for _, e := range s.entries {
+			if e.status.Next.IsZero() {
+				e.status.Next = e.job.Schedule.Next(now)
+			}
+			for !e.status.Next.IsZero() && !now.Before(e.status.Next) {
+				due := e.status.Next
+				e.status.Next = e.job.Schedule.Next(due)
+				if !e.status.Next.IsZero() && !now.Before(e.status.Next) {
+					e.status.Next = e.job.Schedule.Next(now)
+				}
+				if e.status.Running {
+					e.status.Skipped++
+					continue
+				}
+				e.status.Running = true
+				wg.Add(1)
+				go func(e *entry) {
+					defer wg.Done()
+					s.run(ctx, ctor, e, due)
+				}(e)
+			}
+			if !e.status.Next.IsZero() && (next.IsZero() || e.status.Next.Before(next)) {
+				next = e.status.Next
+			}
+		}
+		wake := s.wake
+		s.mu.Unlock()
+
+		var timer cdsexec.Timer
+		var timerC <-chan time.Time
+		if !next.IsZero() {
+			timer = clock.NewTimer(next.Sub(now))
+			timerC = timer.C()
+		}
+		var err error
+		select {
+		case <-timerC:
+		case <-wake:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// run runs the job of e, due at due, after its jitter.
+func (s *Scheduler) run(ctx context.Context, ctor cdsexec.CommandConstructor, e *entry, due time.Time) {
+	r := Run{Scheduled: due}
+	if e.job.Jitter > 0 {
+		r.Err = cdsexec.Sleep(ctx, time.Duration(rand.Int63n(int64(e.job.Jitter))))
+	}
+	if r.Err == nil {
+		results, err := cdsexec.OutputAll(ctx, ctor, []cdsexec.CommandSpec{e.job.Spec}, 1)
+		var ge *cdsexec.GroupError
+		if errors.As(err, &ge) {
+			err = ge.Errs[0]
+		}
+		r.Result, r.Err = results[0], err
+	}
+	s.mu.Lock()
+	e.status.Running = false
+	e.status.Runs++
+	e.status.Last = &r
+	s.mu.Unlock()
+	if s.OnRun != nil {
+		s.OnRun(e.job.Name, r)
+	}
+}
@@ -0,0 +1,130 @@
+package schedule_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+	"github.com/cirrusdata/cdsexec/schedule"
+)
+
+func TestScheduler(t *testing.T) {
+	clock := mockcmd.NewFakeClock(time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC))
+	ctx, cancel := context.WithCancel(cdsexec.ContextWithClock(context.Background(), clock))
+	release := make(chan struct{})
+	runs := make(chan schedule.Run, 10)
+	base := mockcmd.MakeMockCmdWithOutput("", func(m *mockcmd.MockCmd) error {
+		if m.Name == "fstrim" {
+			<-release
+			return &cdsexec.ExitError{Code: 1}
+		}
+		return nil
+	})
+	s := &schedule.Scheduler{
+		Constructor: base,
+		OnRun:       func(name string, r schedule.Run) { runs <- r },
+	}
+	if err := s.Add(schedule.Job{Name: "smart", Spec: cdsexec.CommandSpec{Name: "smartctl"}, Schedule: schedule.Every(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add(schedule.Job{Name: "trim", Spec: cdsexec.CommandSpec{Name: "fstrim"}, Schedule: schedule.MustParse("*/10 * * * *")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add(schedule.Job{Name: "trim", Schedule: schedule.Every(time.Hour)}); !errors.Is(err, schedule.ErrDuplicate) {
+		t.Errorf("Expected ErrDuplicate, got %v", err)
+	}
+	done := make(chan error)
+	go func() { done <- s.Run(ctx) }()
+
+	clock.BlockUntil(1)
+	if st, _ := s.Status("trim"); !st.Next.Equal(clock.Now().Add(10 * time.Minute)) {
+		t.Errorf("Expected trim to be due in 10 minutes, got %v", st.Next)
+	}
+	clock.Advance(10 * time.Minute)
+	clock.BlockUntil(1)
+	// The first trim is still running when the next one is due.
+	clock.Advance(10 * time.Minute)
+	clock.BlockUntil(1)
+	if st, _ := s.Status("trim"); !st.Running || st.Skipped != 1 || st.Last != nil {
+		t.Errorf("Expected a running trim with a skipped run, got %+v", st)
+	}
+	close(release)
+	r := <-runs
+	if !r.Scheduled.Equal(time.Date(2024, 3, 4, 10, 10, 0, 0, time.UTC)) || r.ExitCode != 1 || r.Err == nil {
+		t.Errorf("Unexpected run %+v", r)
+	}
+
+	if st := s.Statuses(); len(st) != 2 || st[1].Name != "trim" || st[1].Runs != 1 || st[1].Running {
+		t.Errorf("Unexpected statuses %+v", st)
+	}
+
+	s.Remove("trim")
+	clock.Advance(40 * time.Minute)
+	<-runs
+	st, _ := s.Status("smart")
+	if st.Runs != 1 || st.Last == nil || st.Last.Err != nil || st.Last.Name != "smartctl" {
+		t.Errorf("Expected one successful smartctl run, got %+v", st)
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	clock := mockcmd.NewFakeClock(time.Unix(0, 0))
+	ctx, cancel := context.WithCancel(cdsexec.ContextWithClock(context.Background(), clock))
+	defer cancel()
+	runs := make(chan schedule.Run, 1)
+	s := &schedule.Scheduler{
+		Constructor: mockcmd.MakeMockCmdWithOutput("", nil),
+		OnRun:       func(name string, r schedule.Run) { runs <- r },
+	}
+	s.Add(schedule.Job{Name: "scan", Spec: cdsexec.CommandSpec{Name: "lsscsi"}, Schedule: schedule.Every(time.Hour), Jitter: time.Minute})
+	go s.Run(ctx)
+
+	clock.BlockUntil(1)
+	clock.Advance(time.Hour)
+	// The scheduler and the jitter of the run wait on the clock.
+	clock.BlockUntil(2)
+	clock.Advance(time.Minute)
+	r := <-runs
+	if r.Err != nil || r.StartTime.IsZero() {
+		t.Errorf("Unexpected run %+v", r)
+	}
+}
+
+func TestEveryFromDue(t *testing.T) {
+	start := time.Unix(0, 0)
+	clock := mockcmd.NewFakeClock(start)
+	ctx, cancel := context.WithCancel(cdsexec.ContextWithClock(context.Background(), clock))
+	defer cancel()
+	runs := make(chan schedule.Run, 3)
+	s := &schedule.Scheduler{
+		Constructor: mockcmd.MakeMockCmdWithOutput("", nil),
+		OnRun:       func(name string, r schedule.Run) { runs <- r },
+	}
+	s.Add(schedule.Job{Name: "scan", Spec: cdsexec.CommandSpec{Name: "lsscsi"}, Schedule: schedule.Every(time.Hour)})
+	go s.Run(ctx)
+
+	// The scheduler wakes up late.
+	clock.BlockUntil(1)
+	clock.Advance(70 * time.Minute)
+	<-runs
+	clock.BlockUntil(1)
+	if st, _ := s.Status("scan"); !st.Next.Equal(start.Add(2 * time.Hour)) {
+		t.Errorf("Expected the next run to be due an hour after the last was due, got %v", st.Next)
+	}
+
+	// Runs missed are not caught up.
+	clock.Advance(3 * time.Hour)
+	<-runs
+	clock.BlockUntil(1)
+	if st, _ := s.Status("scan"); !st.Next.Equal(start.Add(5*time.Hour+10*time.Minute)) || st.Runs != 2 {
+		t.Errorf("Expected one run and the next due an hour later, got %+v", st)
+	}
+}
@@ -0,0 +1,113 @@
+package cdsexec
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ScriptOptions describes a shell script run by RunScript.
+type ScriptOptions struct {
+	// Shell is the interpreter of the script. Empty means /bin/sh.
+	Shell string
+	// Content is the script itself.
+	Content string
+	// Args are the positional parameters of the script, $1 onwards.
+	Args []string
+	// Env holds KEY=VALUE pairs added to the environment the script inherits.
+	Env []string
+	// Dir is the working directory of the script.
+	Dir string
+	// Errexit stops the script at the first failing command, as with set -e.
+	Errexit bool
+	// Pipefail makes a pipeline fail if any of its commands fails, as with set -o pipefail.
+	// The shell must support it, as bash, zsh and recent versions of dash do.
+	Pipefail bool
+	// Stdin is the standard input of the script.
+	Stdin io.Reader
+	// Stdout and Stderr receive the output of the script. When nil, the output is
+	// collected in the returned Result instead.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// RunScript writes the script of opts to a temporary file only readable by the current
+// user, runs it with opts.Shell through ctor and removes the file. The file is created on
+// the local host, so ctor must run local commands. If the script exits unsuccessfully, the
+// error is a *CommandError quoting its standard error.
+func RunScript(ctx context.Context, ctor CommandConstructor, opts ScriptOptions) (Result, error) {
+	shell := opts.Shell
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+	path, err := writeScript(opts)
+	if err != nil {
+		return Result{}, fmt.Errorf("cdsexec: writing script: %w", err)
+	}
+	defer os.Remove(path)
+
+	spec := CommandSpec{
+		Name:   shell,
+		Args:   append([]string{path}, opts.Args...),
+		Dir:    opts.Dir,
+		Stdin:  opts.Stdin,
+		Stdout: opts.Stdout,
+		Stderr: opts.Stderr,
+	}
+	if opts.Env != nil {
+		spec.Env = MergeEnv(nil, opts.Env...)
+	}
+	var stdout, stderr *bytes.Buffer
+	if spec.Stdout == nil {
+		stdout = getBuffer(spec.Name)
+		spec.Stdout = stdout
+	}
+	if spec.Stderr == nil {
+		stderr = getBuffer("")
+		spec.Stderr = stderr
+	}
+
+	clock := ClockFromContext(ctx)
+	r := Result{Name: spec.Name, Args: spec.Args, Dir: spec.Dir, Env: spec.Env, StartTime: clock.Now()}
+	cmd := spec.Command(ctx, ctor)
+	err = cmd.Run()
+	r.Duration = clock.Now().Sub(r.StartTime)
+	r.ExitCode = exitCodeOf(cmd.ProcessState(), err)
+	if stdout != nil {
+		r.Stdout = detachBuffer(spec.Name, stdout)
+	}
+	if stderr != nil {
+		r.Stderr = detachBuffer("", stderr)
+	}
+	return r, commandError(spec.Name, err, r.Stderr)
+}
+
+// writeScript writes the script of opts, preceded by the set options it asks for, to a new
+// executable file.
+func writeScript(opts ScriptOptions) (string, error) {
+	f, err := os.CreateTemp("", "cdsexec-script-*.sh")
+	if err != nil {
+		return "", err
+	}
+	var prologue string
+	if opts.Errexit {
+		prologue += "set -e\n"
+	}
+	if opts.Pipefail {
+		prologue += "set -o pipefail\n"
+	}
+	_, err = io.WriteString(f, prologue+opts.Content)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0o700)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,92 @@
+package cdsexec_test
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+func TestRunScript(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     cdsexec.ScriptOptions
+		expected string
+		code     int
+	}{
+		{
+			name: "multi-line",
+			opts: cdsexec.ScriptOptions{Content: `
+for dev in "$@"; do
+	echo "disk: $dev"
+done
+`, Args: []string{"sda", "sd b"}},
+			expected: "disk: sda\ndisk: sd b\n",
+		},
+		{
+			name:     "env and dir",
+			opts:     cdsexec.ScriptOptions{Content: `echo "$POOL $(pwd)"`, Env: []string{"POOL=tank"}, Dir: "/"},
+			expected: "tank /\n",
+		},
+		{
+			name:     "without errexit",
+			opts:     cdsexec.ScriptOptions{Content: "false\necho continued\n"},
+			expected: "continued\n",
+		},
+		{
+			name: "errexit",
+			opts: cdsexec.ScriptOptions{Content: "false\necho continued\n", Errexit: true},
+			code: 1,
+		},
+		{
+			name:     "pipefail",
+			opts:     cdsexec.ScriptOptions{Shell: "bash", Content: "false | cat\necho $?\n", Pipefail: true},
+			expected: "1\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := cdsexec.RunScript(context.Background(), cdsexec.CommandContext, tt.opts)
+			if string(r.Stdout) != tt.expected {
+				t.Errorf("Expected output %q, got %q (%s)", tt.expected, r.Stdout, r.Stderr)
+			}
+			if r.ExitCode != tt.code || cdsexec.ExitCode(err) != tt.code {
+				t.Errorf("Expected exit code %d, got %d, %v", tt.code, r.ExitCode, err)
+			}
+		})
+	}
+}
+
+func TestRunScriptCleanup(t *testing.T) {
+	r, err := cdsexec.RunScript(context.Background(), cdsexec.CommandContext, cdsexec.ScriptOptions{
+		Content: `stat -c %a "$0"; echo "$0"`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Fields(string(r.Stdout))
+	if len(lines) != 2 || lines[0] != "700" {
+		t.Fatalf("Expected a script only accessible by its owner, got %q", r.Stdout)
+	}
+	if _, err := os.Stat(lines[1]); !os.IsNotExist(err) {
+		t.Errorf("Expected the script to be removed, got %v", err)
+	}
+}
+
+func TestRunScriptClock(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	clock := mockcmd.NewFakeClock(start)
+	ctx := cdsexec.ContextWithClock(context.Background(), clock)
+	base := mockcmd.MakeMockCmdWithOutput("", func(*mockcmd.MockCmd) error {
+		clock.Advance(time.Second)
+		return nil
+	})
+	r, err := cdsexec.RunScript(ctx, base, cdsexec.ScriptOptions{Content: "true"})
+	if err != nil || !r.StartTime.Equal(start) || r.Duration != time.Second {
+		t.Errorf("Expected the result to be timed on the clock of the context, got %+v, %v", r, err)
+	}
+}
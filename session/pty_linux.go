@@ -0,0 +1,100 @@
+package session
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"syscall"
+	"unsafe"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+func (s *Session) startPTY() error {
+	c, ok := s.cmd.(*cdsexec.Cmd)
+	if !ok {
+		return errors.New("a pseudo-terminal requires a local command")
+	}
+	master, slave, err := openPTY()
+	if err != nil {
+		return err
+	}
+	defer slave.Close()
+	c.Stdin, c.Stdout, c.Stderr = slave, slave, slave
+	if c.SysProcAttr == nil {
+		c.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	c.SysProcAttr.Setsid = true
+	c.SysProcAttr.Setctty = true
+	c.SysProcAttr.Ctty = 0
+	if err := c.Start(); err != nil {
+		master.Close()
+		return err
+	}
+	s.stdin = nopCloser{master}
+	go func() {
+		s.readFrom(ptyReader{master})
+		master.Close()
+	}()
+	return nil
+}
+
+// openPTY opens a new pseudo-terminal pair.
+func openPTY() (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var unlock int32
+	if err := ioctl(master, syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	var n uint32
+	if err := ioctl(master, syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	slave, err = os.OpenFile("/dev/pts/"+strconv.Itoa(int(n)), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
+
+func ioctl(f *os.File, req, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, arg)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// ptyReader reports the EIO returned once the terminal is hung up as the end of output.
+type ptyReader struct {
+	f *os.File
+}
+
+func (r ptyReader) Read(p []byte) (int, error) {
+	n, err := r.f.Read(p)
+	var pe *os.PathError
+	if errors.As(err, &pe) && pe.Err == syscall.EIO {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// nopCloser keeps Close from closing the terminal, which the process still uses.
+type nopCloser struct {
+	f *os.File
+}
+
+func (w nopCloser) Write(p []byte) (int, error) { return w.f.Write(p) }
+
+// Close sends the end-of-file character, as closing the input of a terminal means.
+func (w nopCloser) Close() error {
+	_, err := w.f.Write([]byte{4})
+	return err
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package session
+
+import "errors"
+
+func (s *Session) startPTY() error {
+	return errors.New("pseudo-terminals are only supported on Linux")
+}
@@ -0,0 +1,188 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// ErrTimeout is returned by Expect when the pattern did not appear in time.
+var ErrTimeout = errors.New("session: timed out waiting for output")
+
+// Options configures a session.
+type Options struct {
+	// PTY runs the process on a pseudo-terminal instead of pipes, for programs that only
+	// prompt or flush their output when attached to a terminal. It is supported on Linux
+	// for local commands, i.e. when the constructor returns a *cdsexec.Cmd.
+	PTY bool
+}
+
+// Session drives one long-running interactive process: lines are sent to its standard
+// input and its output, standard error included, is matched with Expect.
+type Session struct {
+	cmd   cdsexec.Commander
+	stdin io.WriteCloser
+	clock cdsexec.Clock
+
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	readErr error
+	notify  chan struct{}
+
+	waitOnce sync.Once
+	waitErr  error
+}
+
+// Start starts the process described by spec with ctor. The standard streams of spec are
+// ignored.
+func Start(ctx context.Context, ctor cdsexec.CommandConstructor, spec cdsexec.CommandSpec, opts Options) (*Session, error) {
+	spec.Stdin, spec.Stdout, spec.Stderr = nil, nil, nil
+	s := &Session{notify: make(chan struct{}, 1), clock: cdsexec.ClockFromContext(ctx)}
+	s.cmd = spec.Command(ctx, ctor)
+	if opts.PTY {
+		if err := s.startPTY(); err != nil {
+			return nil, fmt.Errorf("session: %w", err)
+		}
+		return s, nil
+	}
+
+	stdin, err := s.cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("session: %w", err)
+	}
+	s.stdin = stdin
+	w := &sessionWriter{s: s}
+	s.cmd.SetStdout(w)
+	s.cmd.SetStderr(w)
+	if err := s.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("session: %w", err)
+	}
+	go func() {
+		err := s.Wait()
+		if err == nil {
+			err = io.EOF
+		}
+		s.closeOutput(err)
+	}()
+	return s, nil
+}
+
+// sessionWriter appends the output of the process to the buffer of the session.
+type sessionWriter struct {
+	s *Session
+}
+
+func (w *sessionWriter) Write(p []byte) (int, error) {
+	w.s.mu.Lock()
+	w.s.buf.Write(p)
+	w.s.mu.Unlock()
+	w.s.wake()
+	return len(p), nil
+}
+
+// readFrom copies r into the buffer until it fails, which ends the output.
+func (s *Session) readFrom(r io.Reader) {
+	_, err := cdsexec.Copy(&sessionWriter{s: s}, r)
+	if err == nil {
+		err = io.EOF
+	}
+	s.closeOutput(err)
+}
+
+func (s *Session) closeOutput(err error) {
+	s.mu.Lock()
+	if s.readErr == nil {
+		s.readErr = err
+	}
+	s.mu.Unlock()
+	s.wake()
+}
+
+func (s *Session) wake() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Send writes line followed by a newline to the process.
+func (s *Session) Send(line string) error {
+	return s.Write(line + "\n")
+}
+
+// Write writes text to the process as is.
+func (s *Session) Write(text string) error {
+	if _, err := io.WriteString(s.stdin, text); err != nil {
+		return fmt.Errorf("session: %w", err)
+	}
+	return nil
+}
+
+// Expect waits up to timeout for re to match the output not consumed yet. It consumes the
+// output up to the end of the match and returns the match and its submatches. If the
+// output ends or timeout expires first, it returns an error wrapping io.EOF, the error of
+// the process, or ErrTimeout, and leaves the output unconsumed. The timeout is measured on
+// the cdsexec.Clock of the context the session was started with.
+func (s *Session) Expect(re *regexp.Regexp, timeout time.Duration) ([]string, error) {
+	timer := s.clock.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		s.mu.Lock()
+		b := s.buf.Bytes()
+		if loc := re.FindSubmatchIndex(b); loc != nil {
+			m := make([]string, len(loc)/2)
+			for i := range m {
+				if loc[2*i] >= 0 {
+					m[i] = string(b[loc[2*i]:loc[2*i+1]])
+				}
+			}
+			s.buf.Next(loc[1])
+			s.mu.Unlock()
+			return m, nil
+		}
+		readErr := s.readErr
+		s.mu.Unlock()
+		if readErr != nil {
+			return nil, fmt.Errorf("session: waiting for %q: %w", re, readErr)
+		}
+		select {
+		case <-s.notify:
+		case <-timer.C():
+			return nil, fmt.Errorf("session: waiting for %q: %w", re, ErrTimeout)
+		}
+	}
+}
+
+// ExpectString waits up to timeout for substr to appear in the output, like Expect.
+func (s *Session) ExpectString(substr string, timeout time.Duration) error {
+	_, err := s.Expect(regexp.MustCompile(regexp.QuoteMeta(substr)), timeout)
+	return err
+}
+
+// Pending returns the output not consumed by Expect yet.
+func (s *Session) Pending() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.String()
+}
+
+// Close closes the input of the process and waits for it to exit.
+func (s *Session) Close() error {
+	s.stdin.Close()
+	return s.Wait()
+}
+
+// Wait waits for the process to exit and returns its error.
+func (s *Session) Wait() error {
+	s.waitOnce.Do(func() {
+		s.waitErr = s.cmd.Wait()
+	})
+	return s.waitErr
+}
@@ -0,0 +1,81 @@
+package session_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"regexp"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/session"
+)
+
+// repl is a tiny interactive shell loop: it prompts, reads a line and echoes it back.
+const repl = `while printf 'repl> '; read -r line; do
+	case "$line" in
+	quit) exit 3 ;;
+	*) echo "got: $line" ;;
+	esac
+done`
+
+func TestSession(t *testing.T) {
+	for _, pty := range []bool{false, true} {
+		if pty && runtime.GOOS != "linux" {
+			continue
+		}
+		s, err := session.Start(context.Background(), cdsexec.CommandContext,
+			cdsexec.CommandSpec{Name: "sh", Args: []string{"-c", repl}}, session.Options{PTY: pty})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.ExpectString("repl> ", time.Second); err != nil {
+			t.Fatalf("pty=%v: %v", pty, err)
+		}
+		if err := s.Send("hello world"); err != nil {
+			t.Fatal(err)
+		}
+		m, err := s.Expect(regexp.MustCompile(`got: (\w+) (\w+)`), time.Second)
+		if err != nil {
+			t.Fatalf("pty=%v: %v", pty, err)
+		}
+		if m[1] != "hello" || m[2] != "world" {
+			t.Errorf("Expected submatches hello and world, got %q", m)
+		}
+
+		_, err = s.Expect(regexp.MustCompile(`never`), 50*time.Millisecond)
+		if !errors.Is(err, session.ErrTimeout) {
+			t.Errorf("pty=%v: expected ErrTimeout, got %v", pty, err)
+		}
+
+		s.Send("quit")
+		err = s.Wait()
+		if cdsexec.ExitCode(err) != 3 {
+			t.Errorf("pty=%v: expected exit code 3, got %v", pty, err)
+		}
+		_, err = s.Expect(regexp.MustCompile(`never`), time.Second)
+		if err == nil || errors.Is(err, session.ErrTimeout) {
+			t.Errorf("pty=%v: expected the end of output, got %v", pty, err)
+		}
+	}
+}
+
+func TestSessionClose(t *testing.T) {
+	s, err := session.Start(context.Background(), cdsexec.CommandContext,
+		cdsexec.CommandSpec{Name: "cat"}, session.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Send("ping")
+	if err := s.ExpectString("ping\n", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("Expected cat to exit cleanly, got %v", err)
+	}
+	if _, err := s.Expect(regexp.MustCompile(`x`), time.Second); !errors.Is(err, io.EOF) {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+}
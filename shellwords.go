@@ -0,0 +1,99 @@
+package cdsexec
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrShellSyntax matches the errors of SplitShellLine.
+var ErrShellSyntax = errors.New("cdsexec: unsupported shell syntax")
+
+// SplitShellLine splits a command line such as those rendered by CommandSpec.String and
+// CommandSpec.ShellLine into the words a POSIX shell would pass as arguments: blanks
+// separate words, single quotes and double quotes group them, backslashes escape, and a #
+// starting a word comments out the rest of the line. Anything that would make the shell do
+// more than split words, i.e. unquoted operators, expansions and substitutions, is an error
+// matching ErrShellSyntax.
+//
+// It is the inverse of the quoting of this package, which makes it suited to check that
+// no argument can break out of its argv slot, e.g. from a fuzz test.
+func SplitShellLine(line string) ([]string, error) {
+	var (
+		words  []string
+		word   strings.Builder
+		inWord bool
+		syntax = func(format string, a ...any) error {
+			return fmt.Errorf("%w: "+format, append([]any{ErrShellSyntax}, a...)...)
+		}
+	)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case c == '#' && !inWord:
+			if j := strings.IndexByte(line[i:], '\n'); j >= 0 {
+				i += j - 1
+				continue
+			}
+			i = len(line)
+		case c == '\'':
+			j := strings.IndexByte(line[i+1:], '\'')
+			if j < 0 {
+				return nil, syntax("unterminated single quote at offset %d", i)
+			}
+			word.WriteString(line[i+1 : i+1+j])
+			inWord = true
+			i += j + 1
+		case c == '"':
+			inWord = true
+			closed := false
+			for i++; i < len(line); i++ {
+				c := line[i]
+				if c == '"' {
+					closed = true
+					break
+				}
+				if c == '$' || c == '`' {
+					return nil, syntax("expansion %q in double quotes at offset %d", c, i)
+				}
+				if c == '\\' && i+1 < len(line) && strings.IndexByte("$`\"\\\n", line[i+1]) >= 0 {
+					i++
+					if line[i] == '\n' {
+						continue
+					}
+					c = line[i]
+				}
+				word.WriteByte(c)
+			}
+			if !closed {
+				return nil, syntax("unterminated double quote")
+			}
+		case c == '\\':
+			if i+1 == len(line) {
+				return nil, syntax("trailing backslash")
+			}
+			i++
+			if line[i] != '\n' {
+				word.WriteByte(line[i])
+				inWord = true
+			}
+		case strings.IndexByte("|&;<>()$`", c) >= 0:
+			return nil, syntax("unquoted %q at offset %d", c, i)
+		case (c == '*' || c == '?' || c == '[') || (c == '~' && !inWord):
+			return nil, syntax("unquoted pattern character %q at offset %d", c, i)
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,162 @@
+package snapshot
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Op is the kind of a Change.
+type Op int
+
+const (
+	// Removed lines are only in the old output.
+	Removed Op = iota
+	// Added lines are only in the new output.
+	Added
+)
+
+// Change is a line added or removed between two outputs.
+type Change struct {
+	Op Op
+	// Line is the 1-based number of the line in the old output for Removed lines, and in
+	// the new output for Added lines.
+	Line int
+	Text string
+}
+
+func (c Change) String() string {
+	if c.Op == Removed {
+		return "-" + c.Text
+	}
+	return "+" + c.Text
+}
+
+// Diff is the difference between two outputs, as the shortest list of lines removed from
+// the old output and added to get the new one.
+type Diff struct {
+	// ExitCode holds the old and new exit codes when they differ.
+	ExitCode *[2]int
+	Changes  []Change
+}
+
+// Empty reports whether the outputs are the same.
+func (d Diff) Empty() bool {
+	return d.ExitCode == nil && len(d.Changes) == 0
+}
+
+// Added returns the lines added, in order.
+func (d Diff) Added() []string { return d.lines(Added) }
+
+// Removed returns the lines removed, in order.
+func (d Diff) Removed() []string { return d.lines(Removed) }
+
+func (d Diff) lines(op Op) []string {
+	var lines []string
+	for _, c := range d.Changes {
+		if c.Op == op {
+			lines = append(lines, c.Text)
+		}
+	}
+	return lines
+}
+
+// String returns the changes one per line, with removed lines prefixed by "-" and added
+// lines by "+".
+func (d Diff) String() string {
+	var b strings.Builder
+	if d.ExitCode != nil {
+		fmt.Fprintf(&b, "exit code %d -> %d\n", d.ExitCode[0], d.ExitCode[1])
+	}
+	for _, c := range d.Changes {
+		b.WriteString(c.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// Compare returns the differences between the old and new snapshots.
+func Compare(old, new Snapshot) Diff {
+	d := DiffLines(old.Output, new.Output)
+	if old.ExitCode != new.ExitCode {
+		d.ExitCode = &[2]int{old.ExitCode, new.ExitCode}
+	}
+	return d
+}
+
+// DiffLines returns the differences between the lines of old and new.
+func DiffLines(old, new string) Diff {
+	return Diff{Changes: myers(splitLines(old), splitLines(new))}
+}
+
+func splitLines(s string) []string {
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// myers returns the shortest edit script turning a into b, with the algorithm of Eugene
+// Myers, "An O(ND) Difference Algorithm and Its Variations".
+func myers(a, b []string) []Change {
+	n, m := len(a), len(b)
+	max := n + m
+	if max == 0 {
+		return nil
+	}
+	v := make([]int, 2*max+2)
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && v[max+k-1] < v[max+k+1] {
+				x = v[max+k+1]
+			} else {
+				x = v[max+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[max+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, a, b, max)
+			}
+		}
+	}
+	return nil
+}
+
+// backtrack walks the trace of myers back from the end of a and b.
+func backtrack(trace [][]int, a, b []string, max int) []Change {
+	var changes []Change
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || k != d && v[max+k-1] < v[max+k+1] {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[max+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+		}
+		if x == prevX {
+			changes = append(changes, Change{Op: Added, Line: prevY + 1, Text: b[prevY]})
+		} else {
+			changes = append(changes, Change{Op: Removed, Line: prevX + 1, Text: a[prevX]})
+		}
+		x, y = prevX, prevY
+	}
+	for i, j := 0, len(changes)-1; i < j; i, j = i+1, j-1 {
+		changes[i], changes[j] = changes[j], changes[i]
+	}
+	return changes
+}
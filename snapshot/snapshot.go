@@ -0,0 +1,157 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// Snapshot is the canonical output of a command at a point in time.
+type Snapshot struct {
+	// Command is the command line, redacted by the Redactor of the context it ran with.
+	Command  string    `json:"command"`
+	Time     time.Time `json:"time"`
+	ExitCode int       `json:"exit_code"`
+	Output   string    `json:"output"`
+}
+
+// Options configures Take.
+type Options struct {
+	// Constructor constructs the command. Nil means cdsexec.CommandContext.
+	Constructor cdsexec.CommandConstructor
+	// Canonicalize transforms the output, in order, after Canonical, e.g. to drop the
+	// timestamps and counters that change from one run to the next.
+	Canonicalize []func([]byte) []byte
+	// AllowFailure keeps the output of a command that exits unsuccessfully, such as zpool
+	// status reporting a degraded pool, instead of failing Take.
+	AllowFailure bool
+}
+
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+
+// Canonical returns b with ANSI escape sequences removed, CRLF line endings turned into LF,
+// trailing white space removed from every line, and leading and trailing blank lines
+// removed, so that the same content always compares equal.
+func Canonical(b []byte) []byte {
+	b = ansiEscape.ReplaceAll(b, nil)
+	lines := bytes.Split(b, []byte("\n"))
+	for i, line := range lines {
+		lines[i] = bytes.TrimRight(line, " \t\r")
+	}
+	for len(lines) > 0 && len(lines[0]) == 0 {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	out := bytes.Join(lines, []byte("\n"))
+	return append(out, '\n')
+}
+
+// Take runs spec and returns the snapshot of its standard output, made canonical by
+// Canonical and opts.Canonicalize. The time of the snapshot is measured on the Clock of ctx.
+func Take(ctx context.Context, spec cdsexec.CommandSpec, opts Options) (Snapshot, error) {
+	ctor := opts.Constructor
+	if ctor == nil {
+		ctor = cdsexec.CommandContext
+	}
+	spec.Stdout = nil
+	s := Snapshot{
+		Command: cdsexec.RedactorFromContext(ctx).Spec(spec).String(),
+		Time:    cdsexec.ClockFromContext(ctx).Now(),
+	}
+	results, err := cdsexec.OutputAll(ctx, ctor, []cdsexec.CommandSpec{spec}, 1)
+	var ge *cdsexec.GroupError
+	if errors.As(err, &ge) {
+		err = ge.Errs[0]
+	}
+	if err != nil && !(opts.AllowFailure && results[0].ExitCode > 0) {
+		return Snapshot{}, err
+	}
+	out := Canonical(results[0].Stdout)
+	for _, fn := range opts.Canonicalize {
+		out = fn(out)
+	}
+	s.ExitCode, s.Output = results[0].ExitCode, string(out)
+	return s, nil
+}
+
+// Dir keeps named snapshots as JSON files in a directory, e.g. the baselines drift is
+// detected against.
+type Dir string
+
+// Load returns the snapshot name, and false if there is none.
+func (d Dir) Load(name string) (Snapshot, bool, error) {
+	data, err := os.ReadFile(d.path(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Snapshot{}, false, nil
+	}
+	if err != nil {
+		return Snapshot{}, false, err
+	}
+	var s Snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return Snapshot{}, false, fmt.Errorf("snapshot: reading %s: %w", d.path(name), err)
+	}
+	return s, true, nil
+}
+
+// Save saves s as the snapshot name, replacing the previous one atomically.
+func (d Dir) Save(name string, s Snapshot) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(string(d), 0o700); err != nil {
+		return fmt.Errorf("snapshot: saving %s: %w", name, err)
+	}
+	f, err := os.CreateTemp(string(d), name+".tmp*")
+	if err != nil {
+		return fmt.Errorf("snapshot: saving %s: %w", name, err)
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), d.path(name))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("snapshot: saving %s: %w", name, err)
+	}
+	return nil
+}
+
+// Drift compares s with the snapshot name, the baseline, and returns their differences.
+// If there is no baseline yet, s becomes it and the diff is empty. Accept a drift by
+// saving the new snapshot with Save.
+func (d Dir) Drift(name string, s Snapshot) (Diff, error) {
+	base, ok, err := d.Load(name)
+	if err != nil {
+		return Diff{}, err
+	}
+	if !ok {
+		return Diff{}, d.Save(name, s)
+	}
+	return Compare(base, s), nil
+}
+
+func (d Dir) path(name string) string {
+	return filepath.Join(string(d), name+".json")
+}
@@ -0,0 +1,161 @@
+package snapshot_test
+
+import (
+	"context"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+	"github.com/cirrusdata/cdsexec/parse"
+	"github.com/cirrusdata/cdsexec/snapshot"
+)
+
+const healthy = `  pool: tank
+ state: ONLINE
+  scan: scrub repaired 0B in 00:10:02 with 0 errors on Sun Mar  3 00:34:03 2024
+config:
+
+	NAME        STATE     READ WRITE CKSUM
+	tank        ONLINE       0     0     0
+	  mirror-0  ONLINE       0     0     0
+	    sda     ONLINE       0     0     0
+	    sdb     ONLINE       0     0     0
+`
+
+const degraded = "\x1b[1m  pool: tank\x1b[0m\r\n" + ` state: DEGRADED
+  scan: scrub repaired 0B in 00:11:40 with 0 errors on Sun Mar 10 00:35:41 2024
+config:
+
+	NAME        STATE     READ WRITE CKSUM
+	tank        DEGRADED     0     0     0
+	  mirror-0  DEGRADED     0     0     0
+	    sda     ONLINE       0     0     0
+	    sdb     FAULTED      3     0     0  too many errors   
+
+`
+
+func zpool(out string, code int) cdsexec.CommandConstructor {
+	return cdsexec.StreamConstructor(func(ctx context.Context, spec cdsexec.CommandSpec) error {
+		io.WriteString(spec.Stdout, out)
+		if code != 0 {
+			return &cdsexec.ExitError{Code: code}
+		}
+		return nil
+	})
+}
+
+func TestTakeAndDrift(t *testing.T) {
+	scan := regexp.MustCompile(`(?m)^  scan: .*$`)
+	opts := snapshot.Options{
+		Constructor:  zpool(healthy, 0),
+		Canonicalize: []func([]byte) []byte{func(b []byte) []byte { return scan.ReplaceAll(b, []byte("  scan: <redacted>")) }},
+	}
+	clock := mockcmd.NewFakeClock(time.Unix(1700000000, 0))
+	ctx := cdsexec.ContextWithClock(context.Background(), clock)
+	spec := cdsexec.CommandSpec{Name: "zpool", Args: []string{"status", "tank"}}
+	dir := snapshot.Dir(t.TempDir())
+
+	base, err := snapshot.Take(ctx, spec, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base.Command != "zpool status tank" || !base.Time.Equal(clock.Now()) || strings.Contains(base.Output, "scrub") {
+		t.Errorf("Unexpected snapshot %+v", base)
+	}
+	if d, err := dir.Drift("tank", base); err != nil || !d.Empty() {
+		t.Fatalf("Expected the first snapshot to become the baseline, got %v, %v", d, err)
+	}
+
+	opts.Constructor = zpool(degraded, 1)
+	if _, err := snapshot.Take(ctx, spec, opts); cdsexec.ExitCode(err) != 1 {
+		t.Errorf("Expected the failure of the command, got %v", err)
+	}
+	opts.AllowFailure = true
+	cur, err := snapshot.Take(ctx, spec, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := dir.Drift("tank", cur)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `exit code 0 -> 1
+- state: ONLINE
++ state: DEGRADED
+-	tank        ONLINE       0     0     0
+-	  mirror-0  ONLINE       0     0     0
++	tank        DEGRADED     0     0     0
++	  mirror-0  DEGRADED     0     0     0
+-	    sdb     ONLINE       0     0     0
++	    sdb     FAULTED      3     0     0  too many errors
+`
+	if d.String() != want {
+		t.Errorf("Expected diff\n%s\ngot\n%s", want, d)
+	}
+	if got, _, _ := dir.Load("tank"); got.Output != base.Output {
+		t.Errorf("Expected the baseline to be kept until saved")
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		old, new string
+		want     string
+	}{
+		{"", "", ""},
+		{"a\nb\n", "a\nb\n", ""},
+		{"", "a\n", "+a(1)"},
+		{"a\nb\nc\n", "a\nc\n", "-b(2)"},
+		{"a\nb\nc\na\nb\nb\na\n", "c\nb\na\nb\na\nc\n", "-a(1) -b(2) +b(2) -b(6) +c(6)"},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, c := range snapshot.DiffLines(tt.old, tt.new).Changes {
+			got = append(got, c.String()+"("+strconv.Itoa(c.Line)+")")
+		}
+		if strings.Join(got, " ") != tt.want {
+			t.Errorf("DiffLines(%q, %q): expected %q, got %q", tt.old, tt.new, tt.want, strings.Join(got, " "))
+		}
+	}
+}
+
+func TestDiffTables(t *testing.T) {
+	opts := parse.TableOptions{}
+	old, _ := parse.ParseTable([]byte("DEV SIZE MODEL\nsda 1T ST1000\nsdb 1T ST1000\nsdc 2T WD2000\n"), opts)
+	new, _ := parse.ParseTable([]byte("DEV SIZE MODEL\nsda 1T ST1000\nsdc 4T WD4000\nsdd 1T ST1000\n"), opts)
+	d := snapshot.DiffTables(old, new, "DEV")
+	if len(d.Added) != 1 || d.Added[0]["DEV"] != "sdd" || len(d.Removed) != 1 || d.Removed[0]["DEV"] != "sdb" {
+		t.Errorf("Unexpected added and removed rows %v, %v", d.Added, d.Removed)
+	}
+	want := []snapshot.FieldChange{{Section: "sdc", Field: "SIZE", Old: "2T", New: "4T"}, {Section: "sdc", Field: "MODEL", Old: "WD2000", New: "WD4000"}}
+	if len(d.Changed) != 2 || d.Changed[0] != want[0] || d.Changed[1] != want[1] {
+		t.Errorf("Expected changes %v, got %v", want, d.Changed)
+	}
+	if !snapshot.DiffTables(old, old, "DEV").Empty() {
+		t.Errorf("Expected no difference between identical tables")
+	}
+}
+
+func TestDiffKV(t *testing.T) {
+	old := parse.ParseKV([]byte("Device Model: ST1000\nFirmware Version: SN03\nSMART support is: Enabled\n"), parse.KVOptions{})
+	new := parse.ParseKV([]byte("Device Model: ST1000\nFirmware Version: SN05\nRotation Rate: 7200 rpm\n"), parse.KVOptions{})
+	got := snapshot.DiffKV(old, new)
+	want := []snapshot.FieldChange{
+		{Field: "Firmware Version", Old: "SN03", New: "SN05"},
+		{Field: "Rotation Rate", New: "7200 rpm"},
+		{Field: "SMART support is", Old: "Enabled"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Expected %v, got %v", want[i], got[i])
+		}
+	}
+}
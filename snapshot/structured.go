@@ -0,0 +1,115 @@
+package snapshot
+
+import (
+	"github.com/cirrusdata/cdsexec/parse"
+)
+
+// FieldChange is a value that changed between two outputs. Old is empty for fields that
+// were added, and New for fields that were removed.
+type FieldChange struct {
+	// Section is the section of key-value output the field belongs to, or the key of the
+	// row of tabular output.
+	Section string
+	// Field is the key or column of the value.
+	Field    string
+	Old, New string
+}
+
+// TableDiff is the difference between two tables, with rows matched by the value of a key
+// column.
+type TableDiff struct {
+	// Added and Removed are the rows only in the new and old table, as maps from column
+	// names to values.
+	Added, Removed []map[string]string
+	// Changed are the values that differ in rows found in both.
+	Changed []FieldChange
+}
+
+// Empty reports whether the tables hold the same rows.
+func (d TableDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// DiffTables compares the rows of old and new, as parsed by parse.ParseTable, matching
+// rows by their value in the column key, e.g. the device of lsscsi or the mount point of
+// df. Rows are reported in the order of their table, and changed values in the order of
+// the columns of new.
+func DiffTables(old, new *parse.Table, key string) TableDiff {
+	oldRows := rowMaps(old)
+	newRows := rowMaps(new)
+	oldByKey := make(map[string]map[string]string, len(oldRows))
+	for _, r := range oldRows {
+		oldByKey[r[key]] = r
+	}
+	newByKey := make(map[string]bool, len(newRows))
+	var d TableDiff
+	for _, r := range newRows {
+		newByKey[r[key]] = true
+		prev, ok := oldByKey[r[key]]
+		if !ok {
+			d.Added = append(d.Added, r)
+			continue
+		}
+		for _, col := range new.Columns {
+			if prev[col] != r[col] {
+				d.Changed = append(d.Changed, FieldChange{Section: r[key], Field: col, Old: prev[col], New: r[col]})
+			}
+		}
+	}
+	for _, r := range oldRows {
+		if !newByKey[r[key]] {
+			d.Removed = append(d.Removed, r)
+		}
+	}
+	return d
+}
+
+func rowMaps(t *parse.Table) []map[string]string {
+	rows := make([]map[string]string, len(t.Rows))
+	for i, row := range t.Rows {
+		m := make(map[string]string, len(t.Columns))
+		for j, col := range t.Columns {
+			if j < len(row) {
+				m[col] = row[j]
+			}
+		}
+		rows[i] = m
+	}
+	return rows
+}
+
+// DiffKV compares key-value output, as parsed by parse.ParseKV, section by section, with
+// sections matched by name and keys compared as they are. Changes are reported in the
+// order of the sections and keys of new, followed by those only in old.
+func DiffKV(old, new *parse.KV) []FieldChange {
+	oldSections := make(map[string]map[string]string, len(old.Sections))
+	for _, s := range old.Sections {
+		oldSections[s.Name] = s.Map()
+	}
+	var changes []FieldChange
+	seen := make(map[[2]string]bool)
+	for _, s := range new.Sections {
+		prev, cur := oldSections[s.Name], s.Map()
+		for _, p := range s.Pairs {
+			k := [2]string{s.Name, p.Key}
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			if v, ok := prev[p.Key]; !ok || v != cur[p.Key] {
+				changes = append(changes, FieldChange{Section: s.Name, Field: p.Key, Old: v, New: cur[p.Key]})
+			}
+		}
+	}
+	for _, s := range old.Sections {
+		for _, p := range s.Pairs {
+			k := [2]string{s.Name, p.Key}
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			changes = append(changes, FieldChange{Section: s.Name, Field: p.Key, Old: oldSections[s.Name][p.Key]})
+		}
+	}
+	return changes
+}
@@ -0,0 +1,5 @@
+//go:build !cdsexec_fastspawn
+
+package cdsexec
+
+const fastSpawn = false
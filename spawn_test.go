@@ -0,0 +1,33 @@
+package cdsexec_test
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/conformance"
+)
+
+func TestFastCommandContext(t *testing.T) {
+	conformance.Run(t, cdsexec.FastCommandContext)
+
+	// Unset streams read EOF and discard output.
+	if err := cdsexec.FastCommandContext(context.Background(), "sh", "-c", "echo out; echo err >&2; read x").Run(); err == nil {
+		t.Errorf("Expected read to fail at EOF")
+	}
+	_, err := cdsexec.FastCommandContext(context.Background(), "sh", "-c", "echo boom >&2; exit 2").Output()
+	var ee *exec.ExitError
+	if !errors.As(err, &ee) || string(ee.Stderr) != "boom\n" {
+		t.Errorf("Expected stderr in the exit error, got %v", err)
+	}
+
+	// The shared /dev/null survives the commands using it.
+	for i := 0; i < 3; i++ {
+		cmd := cdsexec.Wrap(cdsexec.FastCommandContext, passThrough)(context.Background(), "true")
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("Run %d: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package cdsexec
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+)
+
+// CommandSpec describes a command independently of the Commander that runs it.
+type CommandSpec struct {
+	Name   string
+	Args   []string
+	Dir    string
+	Env    []string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Clone returns a copy of the spec that does not share the Args and Env slices.
+func (s CommandSpec) Clone() CommandSpec {
+	c := s
+	if s.Args != nil {
+		c.Args = append([]string(nil), s.Args...)
+	}
+	if s.Env != nil {
+		c.Env = append([]string(nil), s.Env...)
+	}
+	return c
+}
+
+// String renders the spec as a shell-like command line, quoting arguments where needed.
+func (s CommandSpec) String() string {
+	var b strings.Builder
+	if strings.ContainsRune(s.Name, '=') {
+		// A first word holding '=' would be taken by the shell for an assignment.
+		b.WriteString(shellQuote(s.Name))
+	} else {
+		b.WriteString(quoteArg(s.Name))
+	}
+	for _, a := range s.Args {
+		b.WriteByte(' ')
+		b.WriteString(quoteArg(a))
+	}
+	return b.String()
+}
+
+// ShellLine renders the spec as a line that reproduces the command when pasted into a POSIX
+// shell: the variables its environment sets or changes relative to that of the current
+// process prefix the command, those it removes are unset through env, and the directory is
+// given in a trailing comment, with newlines escaped as \n.
+func (s CommandSpec) ShellLine() string {
+	var b strings.Builder
+	if s.Env != nil {
+		set, unset := envChanges(os.Environ(), s.Env)
+		// The shell only takes NAME=value prefixes for valid names, env takes any.
+		useEnv := len(unset) > 0
+		for _, kv := range set {
+			k, _, _ := strings.Cut(kv, "=")
+			useEnv = useEnv || !isShellName(k)
+		}
+		if useEnv {
+			b.WriteString("env")
+			for _, k := range unset {
+				b.WriteString(" -u ")
+				b.WriteString(quoteArg(k))
+			}
+			b.WriteByte(' ')
+		}
+		for _, kv := range set {
+			k, v, _ := strings.Cut(kv, "=")
+			if isShellName(k) {
+				b.WriteString(k + "=" + quoteArg(v))
+			} else {
+				b.WriteString(quoteArg(kv))
+			}
+			b.WriteByte(' ')
+		}
+	}
+	b.WriteString(s.String())
+	if s.Dir != "" {
+		// A newline would end the comment and run the rest of the directory as commands.
+		b.WriteString(" # cwd: ")
+		b.WriteString(strings.ReplaceAll(quoteArg(s.Dir), "\n", `\n`))
+	}
+	return b.String()
+}
+
+// envChanges returns the variables of env that are not in base or have another value
+// there, and the names of the variables of base missing from env.
+func envChanges(base, env []string) (set, unset []string) {
+	baseVars := make(map[string]string, len(base))
+	for _, kv := range base {
+		k, v, _ := strings.Cut(kv, "=")
+		baseVars[k] = v
+	}
+	seen := make(map[string]bool, len(env))
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		seen[k] = true
+		if old, ok := baseVars[k]; !ok || old != v {
+			set = append(set, kv)
+		}
+	}
+	for _, kv := range base {
+		k, _, _ := strings.Cut(kv, "=")
+		if !seen[k] {
+			unset = append(unset, k)
+		}
+	}
+	return set, unset
+}
+
+// Fingerprint returns a stable hash of the name, arguments, directory and environment of the
+// spec. Two specs with the same fingerprint run the same command in the same way.
+func (s CommandSpec) Fingerprint() string {
+	h := sha256.New()
+	write := func(field string) {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	write(s.Name)
+	for _, a := range s.Args {
+		write(a)
+	}
+	h.Write([]byte{1})
+	write(s.Dir)
+	if s.Env == nil {
+		h.Write([]byte{2})
+	}
+	for _, e := range s.Env {
+		write(e)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Command constructs the command described by the spec with ctor.
+func (s CommandSpec) Command(ctx context.Context, ctor CommandConstructor) Commander {
+	cmd := ctor(ctx, s.Name, s.Args...)
+	s.applyTo(cmd)
+	return cmd
+}
+
+// applyTo copies the explicitly set fields of the spec onto c.
+func (s CommandSpec) applyTo(c Commander) {
+	if s.Dir != "" {
+		c.SetDir(s.Dir)
+	}
+	if s.Env != nil {
+		c.SetEnv(s.Env)
+	}
+	if s.Stdin != nil {
+		c.SetStdin(s.Stdin)
+	}
+	if s.Stdout != nil {
+		c.SetStdout(s.Stdout)
+	}
+	if s.Stderr != nil {
+		c.SetStderr(s.Stderr)
+	}
+}
+
+// quoteArg quotes a for a POSIX shell if it contains anything but safe characters.
+func quoteArg(a string) string {
+	if a == "" {
+		return "''"
+	}
+	safe := true
+	for _, r := range a {
+		if !isSafeShellRune(r) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return a
+	}
+	return shellQuote(a)
+}
+
+// shellQuote quotes a for a POSIX shell.
+func shellQuote(a string) string {
+	return "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+}
+
+// isShellName reports whether name can be assigned in a POSIX shell.
+func isShellName(name string) bool {
+	for i, r := range name {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return name != ""
+}
+
+func isSafeShellRune(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	}
+	return strings.ContainsRune("-_./:=,+@%", r)
+}
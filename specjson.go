@@ -0,0 +1,72 @@
+package cdsexec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNotSerializable is returned when marshaling a CommandSpec whose standard input cannot
+// be read without consuming it.
+var ErrNotSerializable = errors.New("cdsexec: standard input cannot be serialized")
+
+// specJSON is the JSON form of a CommandSpec. Env is a pointer so that an empty
+// environment stays distinct from an inherited one.
+type specJSON struct {
+	Name  string    `json:"name"`
+	Args  []string  `json:"args,omitempty"`
+	Dir   string    `json:"dir,omitempty"`
+	Env   *[]string `json:"env,omitempty"`
+	Stdin []byte    `json:"stdin,omitempty"`
+}
+
+// stdinBytes is implemented by the readers whose content can be read without consuming
+// them: *bytes.Reader and *strings.Reader.
+type stdinBytes interface {
+	io.ReaderAt
+	Size() int64
+	Len() int
+}
+
+// MarshalJSON encodes the name, arguments, directory and environment of the spec, so that
+// it can be queued and run elsewhere, and its standard input if it is a *bytes.Reader or a
+// *strings.Reader, whose unread part is encoded without consuming it. Any other standard
+// input fails with ErrNotSerializable, as the command would read something else.
+// Standard output and error are not encoded: the process running the spec decides where
+// they go.
+func (s CommandSpec) MarshalJSON() ([]byte, error) {
+	j := specJSON{Name: s.Name, Args: s.Args, Dir: s.Dir}
+	if s.Env != nil {
+		j.Env = &s.Env
+	}
+	if s.Stdin != nil {
+		r, ok := s.Stdin.(stdinBytes)
+		if !ok {
+			return nil, fmt.Errorf("%w: %T", ErrNotSerializable, s.Stdin)
+		}
+		j.Stdin = make([]byte, r.Len())
+		if _, err := r.ReadAt(j.Stdin, r.Size()-int64(r.Len())); err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON decodes a spec encoded by MarshalJSON. An encoded standard input is
+// restored as a *bytes.Reader.
+func (s *CommandSpec) UnmarshalJSON(data []byte) error {
+	var j specJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*s = CommandSpec{Name: j.Name, Args: j.Args, Dir: j.Dir}
+	if j.Env != nil {
+		s.Env = *j.Env
+	}
+	if j.Stdin != nil {
+		s.Stdin = bytes.NewReader(j.Stdin)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package cdsexec_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+func TestSpecJSON(t *testing.T) {
+	stdin := strings.NewReader("skip:yes\n")
+	io.CopyN(io.Discard, stdin, 5)
+	spec := cdsexec.CommandSpec{
+		Name:   "sfdisk",
+		Args:   []string{"/dev/sdb"},
+		Dir:    "/tmp",
+		Env:    []string{},
+		Stdin:  stdin,
+		Stdout: os.Stdout,
+	}
+	data, err := json.Marshal(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdin.Len() != 4 {
+		t.Errorf("Expected marshaling not to consume stdin")
+	}
+
+	var got cdsexec.CommandSpec
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != spec.Name || !reflect.DeepEqual(got.Args, spec.Args) || got.Dir != spec.Dir || got.Env == nil || len(got.Env) != 0 {
+		t.Errorf("Unexpected spec %+v from %s", got, data)
+	}
+	if in, _ := io.ReadAll(got.Stdin); string(in) != "yes\n" {
+		t.Errorf("Expected the unread stdin, got %q", in)
+	}
+	if got.Stdout != nil {
+		t.Errorf("Expected stdout not to be decoded")
+	}
+
+	json.Unmarshal([]byte(`{"name":"lsblk"}`), &got)
+	if got.Env != nil || got.Stdin != nil {
+		t.Errorf("Expected an inherited environment and no stdin, got %+v", got)
+	}
+
+	spec.Stdin = os.Stdin
+	if _, err := json.Marshal(spec); !errors.Is(err, cdsexec.ErrNotSerializable) {
+		t.Errorf("Expected ErrNotSerializable, got %v", err)
+	}
+}
+
+func TestResultJSON(t *testing.T) {
+	r := cdsexec.Result{
+		Name:      "zpool",
+		Args:      []string{"list"},
+		Env:       []string{"ZFS_TOKEN=s3cr3t"},
+		ExitCode:  1,
+		Stdout:    []byte("out"),
+		Stderr:    []byte{0xff, 0xfe},
+		StartTime: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Duration:  1500 * time.Millisecond,
+		Phases:    cdsexec.Phases{Spawn: time.Millisecond},
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(`"exit_code":1`)) || bytes.Contains(data, []byte("s3cr3t")) {
+		t.Errorf("Unexpected encoding %s", data)
+	}
+	var got cdsexec.Result
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	r.Env = nil
+	if !reflect.DeepEqual(got, r) {
+		t.Errorf("Expected %+v, got %+v", r, got)
+	}
+}
@@ -0,0 +1,67 @@
+package sshcmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Dial connects to the SSH server at addr, giving up when ctx is done.
+func Dial(ctx context.Context, addr string, cfg *ssh.ClientConfig) (*ssh.Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("sshcmd: %w", err)
+	}
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, cfg)
+	if !stop() {
+		if err == nil {
+			c.Close()
+		}
+		return nil, fmt.Errorf("sshcmd: connecting to %s: %w", addr, ctx.Err())
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("sshcmd: connecting to %s: %w", addr, err)
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// KeyAuth returns an AuthMethod using a PEM-encoded private key. A nil passphrase is used
+// for unencrypted keys.
+func KeyAuth(pemBytes, passphrase []byte) (ssh.AuthMethod, error) {
+	var signer ssh.Signer
+	var err error
+	if passphrase == nil {
+		signer, err = ssh.ParsePrivateKey(pemBytes)
+	} else {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(pemBytes, passphrase)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("sshcmd: parsing private key: %w", err)
+	}
+	return ssh.PublicKeys(signer), nil
+}
+
+// AgentAuth returns an AuthMethod using the keys of the SSH agent listening on
+// SSH_AUTH_SOCK. The returned Closer closes the connection to the agent once the
+// clients using the method are connected.
+func AgentAuth() (ssh.AuthMethod, io.Closer, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, nil, errors.New("sshcmd: SSH_AUTH_SOCK is not set")
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, nil, fmt.Errorf("sshcmd: connecting to agent: %w", err)
+	}
+	return ssh.PublicKeysCallback(agent.NewClient(conn).Signers), conn, nil
+}
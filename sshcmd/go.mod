@@ -0,0 +1,12 @@
+module github.com/cirrusdata/cdsexec/sshcmd
+
+go 1.22.0
+
+require (
+	github.com/cirrusdata/cdsexec v0.0.0
+	golang.org/x/crypto v0.31.0
+)
+
+require golang.org/x/sys v0.28.0 // indirect
+
+replace github.com/cirrusdata/cdsexec => ../
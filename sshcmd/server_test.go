@@ -0,0 +1,170 @@
+package sshcmd_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// testServer is a minimal SSH server running exec requests with sh -c. Like a default
+// OpenSSH configuration, it only accepts LC_* variables as session environment.
+type testServer struct {
+	addr      string
+	hostKey   ssh.PublicKey
+	clientKey ssh.Signer
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientSigner, err := ssh.NewSignerFromKey(clientPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(clientSigner.PublicKey().Marshal()) {
+				return nil, io.EOF
+			}
+			return nil, nil
+		},
+	}
+	cfg.AddHostKey(hostSigner)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveConn(conn, cfg)
+		}
+	}()
+	return &testServer{addr: ln.Addr().String(), hostKey: hostSigner.PublicKey(), clientKey: clientSigner}
+}
+
+func (s *testServer) clientConfig() *ssh.ClientConfig {
+	return &ssh.ClientConfig{
+		User:            "test",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(s.clientKey)},
+		HostKeyCallback: ssh.FixedHostKey(s.hostKey),
+	}
+}
+
+func serveConn(conn net.Conn, cfg *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "unsupported")
+			continue
+		}
+		ch, chReqs, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		go serveSession(ch, chReqs)
+	}
+}
+
+func serveSession(ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+	var env []string
+	var mu sync.Mutex
+	var cmd *exec.Cmd
+
+	for req := range reqs {
+		switch req.Type {
+		case "env":
+			var kv struct{ Name, Value string }
+			ok := ssh.Unmarshal(req.Payload, &kv) == nil && strings.HasPrefix(kv.Name, "LC_")
+			if ok {
+				env = append(env, kv.Name+"="+kv.Value)
+			}
+			req.Reply(ok, nil)
+		case "exec":
+			var p struct{ Command string }
+			if ssh.Unmarshal(req.Payload, &p) != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			mu.Lock()
+			cmd = exec.Command("sh", "-c", p.Command)
+			cmd.Env = append(os.Environ(), env...)
+			cmd.Stdout = ch
+			cmd.Stderr = ch.Stderr()
+			stdin, _ := cmd.StdinPipe()
+			err := cmd.Start()
+			mu.Unlock()
+			req.Reply(err == nil, nil)
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(stdin, ch)
+				stdin.Close()
+			}()
+			go func() {
+				cmd.Wait()
+				ws := cmd.ProcessState.Sys().(syscall.WaitStatus)
+				if ws.Signaled() {
+					ch.SendRequest("exit-signal", false, ssh.Marshal(struct {
+						Signal     string
+						CoreDumped bool
+						Error      string
+						Lang       string
+					}{Signal: signalName(ws.Signal())}))
+				} else {
+					ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(ws.ExitStatus())}))
+				}
+				ch.Close()
+			}()
+		case "signal":
+			mu.Lock()
+			if cmd != nil && cmd.Process != nil {
+				cmd.Process.Kill()
+			}
+			mu.Unlock()
+		default:
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+func signalName(sig syscall.Signal) string {
+	if sig == syscall.SIGKILL {
+		return "KILL"
+	}
+	return "TERM"
+}
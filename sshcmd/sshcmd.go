@@ -0,0 +1,265 @@
+package sshcmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/cirrusdata/cdsexec"
+	"golang.org/x/crypto/ssh"
+)
+
+// New returns a CommandConstructor executing commands on the host client is connected to,
+// each in its own session. Non-zero exits are reported as *cdsexec.ExitError, and errors
+// are classified by cdsexec.Classify: a command the remote shell cannot find matches
+// cdsexec.ErrNotFound, and one killed by its context cdsexec.ErrKilledByContext.
+//
+// Commands are run by the remote user's login shell with their arguments quoted for a
+// POSIX shell. Variables set with SetEnv are sent as session environment and, for those
+// the server refuses, passed through env(1); they are added to the remote environment
+// rather than replacing it. Cancelling the context sends SIGKILL to the remote command
+// and closes the session.
+func New(client *ssh.Client) cdsexec.CommandConstructor {
+	return func(ctx context.Context, name string, arg ...string) cdsexec.Commander {
+		return &Cmd{ctx: ctx, client: client, spec: cdsexec.CommandSpec{Name: name, Args: arg}}
+	}
+}
+
+var _ cdsexec.Commander = (*Cmd)(nil)
+
+// Cmd is a command executed over SSH.
+type Cmd struct {
+	ctx    context.Context
+	client *ssh.Client
+	spec   cdsexec.CommandSpec
+
+	session *ssh.Session
+	started bool
+	waited  bool
+	stop    chan struct{}
+}
+
+func (c *Cmd) newSession() error {
+	if c.session != nil {
+		return nil
+	}
+	s, err := c.client.NewSession()
+	if err != nil {
+		return fmt.Errorf("sshcmd: opening session: %w", err)
+	}
+	c.session = s
+	return nil
+}
+
+// commandLine renders the remote command line, sending the environment on the way.
+func (c *Cmd) commandLine() string {
+	var inline []string
+	for _, kv := range c.spec.Env {
+		k, v, _ := strings.Cut(kv, "=")
+		if err := c.session.Setenv(k, v); err != nil {
+			inline = append(inline, kv)
+		}
+	}
+	line := cdsexec.CommandSpec{Name: c.spec.Name, Args: c.spec.Args}.String()
+	if len(inline) > 0 {
+		line = cdsexec.CommandSpec{Name: "env", Args: inline}.String() + " " + line
+	}
+	if c.spec.Dir != "" {
+		line = cdsexec.CommandSpec{Name: "cd", Args: []string{c.spec.Dir}}.String() + " && " + line
+	}
+	return line
+}
+
+// Start starts the command on the remote host.
+func (c *Cmd) Start() error {
+	if c.started {
+		return errors.New("sshcmd: already started")
+	}
+	if err := c.ctx.Err(); err != nil {
+		return cdsexec.Classify(c.ctx, err)
+	}
+	if err := c.newSession(); err != nil {
+		return cdsexec.Classify(c.ctx, err)
+	}
+	c.started = true
+
+	s := c.session
+	if c.spec.Stdin != nil {
+		s.Stdin = c.spec.Stdin
+	}
+	if c.spec.Stdout != nil {
+		s.Stdout = c.spec.Stdout
+	}
+	if c.spec.Stderr != nil {
+		s.Stderr = c.spec.Stderr
+	}
+	if err := s.Start(c.commandLine()); err != nil {
+		s.Close()
+		return cdsexec.Classify(c.ctx, fmt.Errorf("sshcmd: %w", err))
+	}
+
+	c.stop = make(chan struct{})
+	go func() {
+		select {
+		case <-c.ctx.Done():
+			s.Signal(ssh.SIGKILL)
+			s.Close()
+		case <-c.stop:
+		}
+	}()
+	return nil
+}
+
+// Wait waits for the remote command to exit.
+func (c *Cmd) Wait() error {
+	if !c.started {
+		return errors.New("sshcmd: not started")
+	}
+	if c.waited {
+		return errors.New("sshcmd: Wait was already called")
+	}
+	c.waited = true
+	err := c.session.Wait()
+	close(c.stop)
+	c.session.Close()
+	if err != nil && c.ctx.Err() != nil {
+		return cdsexec.Classify(c.ctx, fmt.Errorf("sshcmd: %w", c.ctx.Err()))
+	}
+	return cdsexec.Classify(c.ctx, mapError(err))
+}
+
+// Run starts the command and waits for it to exit.
+func (c *Cmd) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+// Output runs the command and returns its standard output. Unless standard error is
+// redirected, it is collected into the returned *cdsexec.ExitError.
+func (c *Cmd) Output() ([]byte, error) {
+	if c.spec.Stdout != nil {
+		return nil, errors.New("sshcmd: Stdout already set")
+	}
+	var stdout bytes.Buffer
+	c.spec.Stdout = &stdout
+	var stderr *bytes.Buffer
+	if c.spec.Stderr == nil {
+		stderr = new(bytes.Buffer)
+		c.spec.Stderr = stderr
+	}
+	err := c.Run()
+	var xe *cdsexec.ExitError
+	if stderr != nil && errors.As(err, &xe) {
+		xe.Stderr = stderr.Bytes()
+	}
+	return stdout.Bytes(), err
+}
+
+// CombinedOutput runs the command and returns its combined standard output and error.
+func (c *Cmd) CombinedOutput() ([]byte, error) {
+	if c.spec.Stdout != nil {
+		return nil, errors.New("sshcmd: Stdout already set")
+	}
+	if c.spec.Stderr != nil {
+		return nil, errors.New("sshcmd: Stderr already set")
+	}
+	var b lockedBuffer
+	c.spec.Stdout = &b
+	c.spec.Stderr = &b
+	err := c.Run()
+	return b.buf.Bytes(), err
+}
+
+// StdinPipe returns a pipe connected to the remote command's standard input.
+func (c *Cmd) StdinPipe() (io.WriteCloser, error) {
+	if c.spec.Stdin != nil {
+		return nil, errors.New("sshcmd: Stdin already set")
+	}
+	if err := c.newSession(); err != nil {
+		return nil, err
+	}
+	return c.session.StdinPipe()
+}
+
+// StdoutPipe returns a pipe connected to the remote command's standard output.
+func (c *Cmd) StdoutPipe() (io.ReadCloser, error) {
+	if c.spec.Stdout != nil {
+		return nil, errors.New("sshcmd: Stdout already set")
+	}
+	if err := c.newSession(); err != nil {
+		return nil, err
+	}
+	r, err := c.session.StdoutPipe()
+	return io.NopCloser(r), err
+}
+
+// StderrPipe returns a pipe connected to the remote command's standard error.
+func (c *Cmd) StderrPipe() (io.ReadCloser, error) {
+	if c.spec.Stderr != nil {
+		return nil, errors.New("sshcmd: Stderr already set")
+	}
+	if err := c.newSession(); err != nil {
+		return nil, err
+	}
+	r, err := c.session.StderrPipe()
+	return io.NopCloser(r), err
+}
+
+// SetDir sets the remote working directory.
+func (c *Cmd) SetDir(dir string) { c.spec.Dir = dir }
+
+// SetEnv sets variables added to the remote environment.
+func (c *Cmd) SetEnv(env []string) { c.spec.Env = env }
+
+// SetStdin sets the standard input for the command.
+func (c *Cmd) SetStdin(in io.Reader) { c.spec.Stdin = in }
+
+// SetStdout sets the standard output for the command.
+func (c *Cmd) SetStdout(out io.Writer) { c.spec.Stdout = out }
+
+// SetStderr sets the standard error for the command.
+func (c *Cmd) SetStderr(out io.Writer) { c.spec.Stderr = out }
+
+// Process returns nil since the process runs on the remote host.
+func (c *Cmd) Process() *os.Process { return nil }
+
+// ProcessState returns nil since the process runs on the remote host.
+func (c *Cmd) ProcessState() *os.ProcessState { return nil }
+
+// String returns the command line, redacted by the cdsexec.Redactor of the command's context.
+func (c *Cmd) String() string {
+	return cdsexec.RedactorFromContext(c.ctx).Spec(c.spec).String()
+}
+
+// mapError converts the exit status reported by the server into a *cdsexec.ExitError.
+func mapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var ee *ssh.ExitError
+	if errors.As(err, &ee) {
+		if ee.Signal() != "" {
+			return &cdsexec.ExitError{Code: -1, Signal: ee.Signal()}
+		}
+		return &cdsexec.ExitError{Code: ee.ExitStatus()}
+	}
+	return fmt.Errorf("sshcmd: %w", err)
+}
+
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
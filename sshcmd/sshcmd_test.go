@@ -0,0 +1,128 @@
+package sshcmd_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/sshcmd"
+	"golang.org/x/crypto/ssh"
+)
+
+func connect(t *testing.T) *ssh.Client {
+	t.Helper()
+	srv := newTestServer(t)
+	client, err := sshcmd.Dial(context.Background(), srv.addr, srv.clientConfig())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestOutput(t *testing.T) {
+	ctor := sshcmd.New(connect(t))
+
+	tests := []struct {
+		name     string
+		cmd      []string
+		setup    func(cdsexec.Commander)
+		want     string
+		wantCode int
+	}{
+		{"quoting", []string{"printf", "%s|", "a b", "it's", "$HOME"}, nil, "a b|it's|$HOME|", 0},
+		{"dir", []string{"pwd"}, func(c cdsexec.Commander) { c.SetDir("/tmp") }, "/tmp\n", 0},
+		{"env", []string{"sh", "-c", "echo $LC_ALL $CDS_TENANT"}, func(c cdsexec.Commander) {
+			c.SetEnv([]string{"LC_ALL=C", "CDS_TENANT=acme corp"})
+		}, "C acme corp\n", 0},
+		{"stdin", []string{"tr", "a-z", "A-Z"}, func(c cdsexec.Commander) { c.SetStdin(strings.NewReader("hello")) }, "HELLO", 0},
+		{"exit code", []string{"sh", "-c", "echo partial; exit 3"}, nil, "partial\n", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := ctor(context.Background(), tt.cmd[0], tt.cmd[1:]...)
+			if tt.setup != nil {
+				tt.setup(cmd)
+			}
+			out, err := cmd.Output()
+			if string(out) != tt.want {
+				t.Errorf("Expected output %q, got %q", tt.want, out)
+			}
+			if code := cdsexec.ExitCode(err); code != tt.wantCode {
+				t.Errorf("Expected exit code %d, got %d (%v)", tt.wantCode, code, err)
+			}
+		})
+	}
+}
+
+func TestExitError(t *testing.T) {
+	ctor := sshcmd.New(connect(t))
+
+	_, err := ctor(context.Background(), "sh", "-c", "echo 'no such pool' >&2; exit 2").Output()
+	var xe *cdsexec.ExitError
+	if !errors.As(err, &xe) {
+		t.Fatalf("Expected *cdsexec.ExitError, got %v", err)
+	}
+	if xe.Code != 2 || string(xe.Stderr) != "no such pool\n" || err.Error() != "exit status 2" {
+		t.Errorf("Unexpected exit error %q: %+v", err, xe)
+	}
+
+	// Output and error travel on separate channels, so only their content is checked.
+	out, err := ctor(context.Background(), "sh", "-c", "echo out; echo err >&2").CombinedOutput()
+	if err != nil || (string(out) != "out\nerr\n" && string(out) != "err\nout\n") {
+		t.Errorf("Unexpected combined output %q, %v", out, err)
+	}
+}
+
+func TestPipes(t *testing.T) {
+	cmd := sshcmd.New(connect(t))(context.Background(), "sed", "s/^/> /")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	io.WriteString(stdin, "line\n")
+	stdin.Close()
+	out, _ := io.ReadAll(stdout)
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if string(out) != "> line\n" {
+		t.Errorf("Unexpected output %q", out)
+	}
+}
+
+func TestContextCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := sshcmd.New(connect(t))(ctx, "sleep", "5").Run()
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, cdsexec.ErrTimedOut) || !errors.Is(err, cdsexec.ErrKilledByContext) {
+		t.Errorf("Expected deadline error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the remote command to be killed, took %s", elapsed)
+	}
+
+	// A command started with a context already done does not run.
+	if err := sshcmd.New(connect(t))(ctx, "true").Run(); !errors.Is(err, cdsexec.ErrTimedOut) {
+		t.Errorf("Expected a timed out error, got %v", err)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	err := sshcmd.New(connect(t))(context.Background(), "cdsexec-missing-tool").Run()
+	if !errors.Is(err, cdsexec.ErrNotFound) || cdsexec.ExitCode(err) != cdsexec.ExitCommandNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package cdsexec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// StreamFunc executes the command described by spec, reading its standard input from
+// spec.Stdin and writing its output to spec.Stdout and spec.Stderr, any of which may be nil.
+// It returns once the command has exited, with an *ExitError if it exited unsuccessfully.
+type StreamFunc func(ctx context.Context, spec CommandSpec) error
+
+// StreamConstructor returns a CommandConstructor whose commands are executed by fn. It
+// provides Start, Wait, pipes and the Output helpers for backends that do not run a local
+// process; Process and ProcessState always return nil.
+//
+// As with os/exec, output pipes must be read to completion before calling Wait.
+func StreamConstructor(fn StreamFunc) CommandConstructor {
+	return func(ctx context.Context, name string, arg ...string) Commander {
+		return &streamCmd{ctx: ctx, fn: fn, spec: CommandSpec{Name: name, Args: arg}}
+	}
+}
+
+var _ Commander = (*streamCmd)(nil)
+
+// streamCmd is the Commander returned by StreamConstructor.
+type streamCmd struct {
+	ctx  context.Context
+	fn   StreamFunc
+	spec CommandSpec
+
+	stdinPipe  *io.PipeReader
+	outPipes   []*io.PipeWriter
+	started    bool
+	waitCalled bool
+	done       chan error
+}
+
+func (c *streamCmd) Start() error {
+	if c.started {
+		return errAlreadyStarted
+	}
+	if err := c.ctx.Err(); err != nil {
+		return Classify(c.ctx, err)
+	}
+	c.started = true
+	c.done = make(chan error, 1)
+	spec := c.spec
+	go func() {
+		err := c.fn(c.ctx, spec)
+		for _, p := range c.outPipes {
+			p.Close()
+		}
+		if c.stdinPipe != nil {
+			c.stdinPipe.Close()
+		}
+		c.done <- Classify(c.ctx, err)
+	}()
+	return nil
+}
+
+func (c *streamCmd) Wait() error {
+	if !c.started {
+		return errNotStarted
+	}
+	if c.waitCalled {
+		return errWaitCalled
+	}
+	c.waitCalled = true
+	return <-c.done
+}
+
+func (c *streamCmd) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+func (c *streamCmd) Output() ([]byte, error) {
+	if c.spec.Stdout != nil {
+		return nil, errors.New("exec: Stdout already set")
+	}
+	stdout := getBuffer(c.spec.Name)
+	c.spec.Stdout = stdout
+	var stderr *bytes.Buffer
+	if c.spec.Stderr == nil {
+		stderr = getBuffer("")
+		c.spec.Stderr = stderr
+	}
+	err := c.Run()
+	if stderr != nil {
+		var xe *ExitError
+		if errors.As(err, &xe) && xe.Stderr == nil {
+			xe.Stderr = detachBuffer("", stderr)
+		} else {
+			putBuffer(stderr)
+		}
+	}
+	return detachBuffer(c.spec.Name, stdout), err
+}
+
+func (c *streamCmd) CombinedOutput() ([]byte, error) {
+	if c.spec.Stdout != nil {
+		return nil, errors.New("exec: Stdout already set")
+	}
+	if c.spec.Stderr != nil {
+		return nil, errors.New("exec: Stderr already set")
+	}
+	b := &lockedBuffer{buf: getBuffer(c.spec.Name)}
+	c.spec.Stdout = b
+	c.spec.Stderr = b
+	err := c.Run()
+	return detachBuffer(c.spec.Name, b.buf), err
+}
+
+func (c *streamCmd) StdinPipe() (io.WriteCloser, error) {
+	if c.started {
+		return nil, errors.New("exec: StdinPipe after process started")
+	}
+	if c.spec.Stdin != nil {
+		return nil, errors.New("exec: Stdin already set")
+	}
+	pr, pw := io.Pipe()
+	c.spec.Stdin = pr
+	c.stdinPipe = pr
+	return pw, nil
+}
+
+func (c *streamCmd) StdoutPipe() (io.ReadCloser, error) {
+	if c.started {
+		return nil, errors.New("exec: StdoutPipe after process started")
+	}
+	if c.spec.Stdout != nil {
+		return nil, errors.New("exec: Stdout already set")
+	}
+	pr, pw := io.Pipe()
+	c.spec.Stdout = pw
+	c.outPipes = append(c.outPipes, pw)
+	return pr, nil
+}
+
+func (c *streamCmd) StderrPipe() (io.ReadCloser, error) {
+	if c.started {
+		return nil, errors.New("exec: StderrPipe after process started")
+	}
+	if c.spec.Stderr != nil {
+		return nil, errors.New("exec: Stderr already set")
+	}
+	pr, pw := io.Pipe()
+	c.spec.Stderr = pw
+	c.outPipes = append(c.outPipes, pw)
+	return pr, nil
+}
+
+func (c *streamCmd) SetDir(dir string)              { c.spec.Dir = dir }
+func (c *streamCmd) SetEnv(env []string)            { c.spec.Env = env }
+func (c *streamCmd) SetStdin(in io.Reader)          { c.spec.Stdin = in }
+func (c *streamCmd) SetStdout(out io.Writer)        { c.spec.Stdout = out }
+func (c *streamCmd) SetStderr(out io.Writer)        { c.spec.Stderr = out }
+func (c *streamCmd) Process() *os.Process           { return nil }
+func (c *streamCmd) ProcessState() *os.ProcessState { return nil }
+
+// String returns the command line, redacted by the Redactor of the command's context.
+func (c *streamCmd) String() string {
+	return RedactorFromContext(c.ctx).Spec(c.spec).String()
+}
+
+// lockedBuffer is a bytes.Buffer safe for concurrent writes, collecting combined output.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf *bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
@@ -0,0 +1,78 @@
+package cdsexec_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// upper is a StreamFunc upper-casing its stdin, warning on stderr and failing when asked to.
+func upper(ctx context.Context, spec cdsexec.CommandSpec) error {
+	var in []byte
+	if spec.Stdin != nil {
+		in, _ = io.ReadAll(spec.Stdin)
+	}
+	if spec.Stdout != nil {
+		spec.Stdout.Write(bytes.ToUpper(in))
+	}
+	if spec.Stderr != nil {
+		io.WriteString(spec.Stderr, "warn\n")
+	}
+	if len(spec.Args) > 0 && spec.Args[0] == "fail" {
+		return &cdsexec.ExitError{Code: 1}
+	}
+	return nil
+}
+
+func TestStreamConstructor(t *testing.T) {
+	ctor := cdsexec.StreamConstructor(upper)
+
+	cmd := ctor(context.Background(), "upper")
+	cmd.SetStdin(strings.NewReader("abc"))
+	out, err := cmd.Output()
+	if err != nil || string(out) != "ABC" {
+		t.Errorf("Unexpected output %q, %v", out, err)
+	}
+
+	cmd = ctor(context.Background(), "upper", "fail")
+	_, err = cmd.Output()
+	var xe *cdsexec.ExitError
+	if !errors.As(err, &xe) || string(xe.Stderr) != "warn\n" {
+		t.Errorf("Expected exit error with stderr, got %v", err)
+	}
+
+	cmd = ctor(context.Background(), "upper")
+	cmd.SetStdin(strings.NewReader("x"))
+	if out, err := cmd.CombinedOutput(); err != nil || string(out) != "Xwarn\n" {
+		t.Errorf("Unexpected combined output %q, %v", out, err)
+	}
+	if err := cmd.Run(); err == nil {
+		t.Error("Expected an error when running twice")
+	}
+}
+
+func TestStreamConstructorPipes(t *testing.T) {
+	cmd := cdsexec.StreamConstructor(upper)(context.Background(), "upper")
+	stdin, _ := cmd.StdinPipe()
+	stdout, _ := cmd.StdoutPipe()
+	if err := cmd.Wait(); err == nil {
+		t.Error("Expected Wait before Start to fail")
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	io.WriteString(stdin, "piped")
+	stdin.Close()
+	out, _ := io.ReadAll(stdout)
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if string(out) != "PIPED" {
+		t.Errorf("Unexpected output %q", out)
+	}
+}
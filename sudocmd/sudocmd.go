@@ -0,0 +1,79 @@
+package sudocmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// Options configures privilege escalation.
+type Options struct {
+	// User is the user to run commands as. Empty means root.
+	User string
+	// NonInteractive makes the escalation tool fail instead of prompting for a password.
+	NonInteractive bool
+	// PreserveEnv asks sudo to keep the caller's environment. doas ignores it; use keepenv
+	// in doas.conf instead.
+	PreserveEnv bool
+	// Program is the escalation tool, "sudo" or "doas". Empty means "sudo".
+	Program string
+}
+
+func (o Options) program() string {
+	if o.Program == "" {
+		return "sudo"
+	}
+	return o.Program
+}
+
+// needed reports whether commands must be escalated, i.e. unless the process already runs as
+// root and root is the target user.
+func (o Options) needed() bool {
+	return os.Geteuid() != 0 || (o.User != "" && o.User != "root")
+}
+
+// prefix returns the escalation tool arguments placed before the command.
+func (o Options) prefix() []string {
+	var args []string
+	if o.NonInteractive {
+		args = append(args, "-n")
+	}
+	if o.User != "" {
+		args = append(args, "-u", o.User)
+	}
+	if o.PreserveEnv && o.program() == "sudo" {
+		args = append(args, "-E")
+	}
+	// "--" keeps arguments of the command from being parsed as options of the tool.
+	return append(args, "--")
+}
+
+// New returns a CommandConstructor that runs every command built by base through sudo or
+// doas, or directly when the process already has the requested privileges. Arguments are
+// passed as a separate argv, so no shell quoting is involved.
+func New(base cdsexec.CommandConstructor, opts Options) cdsexec.CommandConstructor {
+	if !opts.needed() {
+		return base
+	}
+	return func(ctx context.Context, name string, arg ...string) cdsexec.Commander {
+		args := append(opts.prefix(), name)
+		return base(ctx, opts.program(), append(args, arg...)...)
+	}
+}
+
+// Validate checks that commands can be escalated without a password prompt by running
+// "true" non-interactively through base.
+func Validate(ctx context.Context, base cdsexec.CommandConstructor, opts Options) error {
+	opts.NonInteractive = true
+	out, err := New(base, opts)(ctx, "true").CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	if msg := strings.TrimSpace(string(out)); msg != "" {
+		return fmt.Errorf("sudocmd: %s cannot run commands without a password: %w: %s", opts.program(), err, msg)
+	}
+	return fmt.Errorf("sudocmd: %s cannot run commands without a password: %w", opts.program(), err)
+}
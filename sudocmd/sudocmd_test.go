@@ -0,0 +1,76 @@
+package sudocmd_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec/mockcmd"
+	"github.com/cirrusdata/cdsexec/sudocmd"
+)
+
+func TestPrefix(t *testing.T) {
+	tests := []struct {
+		name string
+		opts sudocmd.Options
+		want string
+	}{
+		{"Sudo As User", sudocmd.Options{User: "nobody", NonInteractive: true, PreserveEnv: true}, "sudo -n -u nobody -E -- ls -l --color"},
+		{"Doas As User", sudocmd.Options{User: "nobody", Program: "doas", PreserveEnv: true}, "doas -u nobody -- ls -l --color"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			base := mockcmd.MakeMockCmdWithOutput("", func(m *mockcmd.MockCmd) error {
+				got = m.Name + " " + strings.Join(m.Args, " ")
+				return nil
+			})
+			if err := sudocmd.New(base, tt.opts)(context.Background(), "ls", "-l", "--color").Run(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRootRunsDirectly(t *testing.T) {
+	var got string
+	base := mockcmd.MakeMockCmdWithOutput("", func(m *mockcmd.MockCmd) error {
+		got = m.Name
+		return nil
+	})
+	sudocmd.New(base, sudocmd.Options{})(context.Background(), "ls").Run()
+
+	want := "sudo"
+	if os.Geteuid() == 0 {
+		want = "ls"
+	}
+	if got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	base := mockcmd.MakeMockCmdWithOutputSpecificError("sudo: a password is required", errors.New("exit status 1"), nil)
+	err := sudocmd.Validate(context.Background(), base, sudocmd.Options{User: "nobody"})
+	if err == nil || !strings.Contains(err.Error(), "a password is required") {
+		t.Errorf("Expected validation error, got %v", err)
+	}
+
+	var args []string
+	base = mockcmd.MakeMockCmdWithOutput("", func(m *mockcmd.MockCmd) error {
+		args = m.Args
+		return nil
+	})
+	if err := sudocmd.Validate(context.Background(), base, sudocmd.Options{User: "nobody"}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if len(args) == 0 || args[0] != "-n" {
+		t.Errorf("Expected validation to be non-interactive, got %v", args)
+	}
+}
@@ -0,0 +1,96 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// Probe checks that an instance is ready, i.e. usable by its clients.
+type Probe interface {
+	// Wait waits for inst to be ready. ctx is done once the ReadyTimeout of the Supervisor
+	// has passed or the instance has exited.
+	Wait(ctx context.Context, inst *Instance) error
+}
+
+// ProbeFunc adapts a function to a Probe.
+type ProbeFunc func(ctx context.Context, inst *Instance) error
+
+// Wait calls f.
+func (f ProbeFunc) Wait(ctx context.Context, inst *Instance) error {
+	return f(ctx, inst)
+}
+
+// PortOpen returns a Probe waiting for a TCP connection to addr to succeed.
+func PortOpen(addr string) Probe {
+	return ProbeFunc(func(ctx context.Context, inst *Instance) error {
+		var d net.Dialer
+		return inst.poll(ctx, "port "+addr, func() error {
+			conn, err := d.DialContext(ctx, "tcp", addr)
+			if err == nil {
+				conn.Close()
+			}
+			return err
+		})
+	})
+}
+
+// FileExists returns a Probe waiting for path to exist, e.g. a socket or PID file the
+// process creates once it is ready.
+func FileExists(path string) Probe {
+	return ProbeFunc(func(ctx context.Context, inst *Instance) error {
+		return inst.poll(ctx, "file "+path, func() error {
+			_, err := os.Stat(path)
+			return err
+		})
+	})
+}
+
+// ExecSucceeds returns a Probe running spec, with the Constructor of the Supervisor,
+// until it exits with status 0.
+func ExecSucceeds(spec cdsexec.CommandSpec) Probe {
+	return ProbeFunc(func(ctx context.Context, inst *Instance) error {
+		ctor := inst.sup.Constructor
+		if ctor == nil {
+			ctor = cdsexec.CommandContext
+		}
+		return inst.poll(ctx, spec.String(), func() error {
+			return spec.Command(ctx, ctor).Run()
+		})
+	})
+}
+
+// OutputMatches returns a Probe waiting for the instance to write a line matching re to
+// its standard output or error. Only the output written since the previous probe of the
+// instance matched is searched.
+func OutputMatches(re *regexp.Regexp) Probe {
+	return outputProbe{re}
+}
+
+type outputProbe struct {
+	re *regexp.Regexp
+}
+
+func (p outputProbe) Wait(ctx context.Context, inst *Instance) error {
+	_, err := inst.output.ExpectLine(ctx, p.re, 0)
+	return err
+}
+
+// poll calls check every ProbeInterval until it succeeds, or ctx is done and poll returns
+// the last error of check.
+func (i *Instance) poll(ctx context.Context, what string, check func() error) error {
+	interval := durationOr(i.sup.ProbeInterval, DefaultProbeInterval)
+	for {
+		err := check()
+		if err == nil {
+			return nil
+		}
+		if cdsexec.Sleep(ctx, interval) != nil {
+			return fmt.Errorf("waiting for %s: %w", what, err)
+		}
+	}
+}
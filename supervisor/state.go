@@ -0,0 +1,120 @@
+package supervisor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// record is the content of a StateFile.
+type record struct {
+	PID int `json:"pid"`
+	// StartTicks is when the process started, as the kernel reports it, so that a process
+	// reusing its PID is told apart. Zero if it is unknown.
+	StartTicks uint64              `json:"start_ticks,omitempty"`
+	Spec       cdsexec.CommandSpec `json:"spec"`
+	Started    time.Time           `json:"started"`
+}
+
+// recordedSpec returns the part of spec a record keeps.
+func recordedSpec(spec cdsexec.CommandSpec) cdsexec.CommandSpec {
+	return cdsexec.CommandSpec{Name: spec.Name, Args: spec.Args, Dir: spec.Dir, Env: spec.Env}
+}
+
+// sameSpec reports whether a and b run the same command. Empty and nil Args are the same,
+// as they do not survive JSON apart, while a nil Env, inheriting the environment, is not
+// an empty one.
+func sameSpec(a, b cdsexec.CommandSpec) bool {
+	return a.Name == b.Name && a.Dir == b.Dir && slices.Equal(a.Args, b.Args) &&
+		(a.Env == nil) == (b.Env == nil) && slices.Equal(a.Env, b.Env)
+}
+
+// adopt returns the instance recorded in the StateFile if it still runs Spec. It stops
+// the recorded instance if it runs another spec, and returns nil. The instance is known by
+// its PID and the time it started, whatever Constructor started it, e.g. through a shim
+// that replaced itself with the command.
+func (s *Supervisor) adopt() *Instance {
+	if s.StateFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.StateFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	var rec record
+	if err == nil {
+		err = json.Unmarshal(data, &rec)
+	}
+	if err != nil {
+		s.emit(Event{Kind: StateFileFailed, Err: fmt.Errorf("supervisor: reading %s: %w", s.StateFile, err)})
+		return nil
+	}
+	// The process is held before the time it started is checked, so that it cannot exit
+	// and its PID be reused in between.
+	cmd, err := cdsexec.AdoptProcess(rec.PID)
+	if err != nil {
+		return nil
+	}
+	if ticks, err := startTicks(rec.PID); err != nil || rec.StartTicks == 0 || ticks != rec.StartTicks {
+		// Another process reused the PID. The pidfd holding it is closed with cmd.
+		return nil
+	}
+	inst := &Instance{sup: s, cmd: cmd, started: rec.Started, done: make(chan struct{})}
+	go inst.wait()
+	if !sameSpec(rec.Spec, recordedSpec(s.Spec)) {
+		s.stopInstance(inst)
+		return nil
+	}
+	s.emit(Event{Kind: InstanceAdopted, PID: rec.PID})
+	return inst
+}
+
+// saveState records inst in the StateFile, atomically.
+func (s *Supervisor) saveState(inst *Instance) {
+	if s.StateFile == "" {
+		return
+	}
+	rec := record{PID: inst.PID(), Spec: recordedSpec(s.Spec), Started: inst.started}
+	rec.StartTicks, _ = startTicks(rec.PID)
+	data, err := json.Marshal(rec)
+	if err != nil {
+		s.emit(Event{Kind: StateFileFailed, Err: fmt.Errorf("supervisor: writing %s: %w", s.StateFile, err)})
+		return
+	}
+	f, err := os.CreateTemp(filepath.Dir(s.StateFile), filepath.Base(s.StateFile)+".tmp*")
+	if err == nil {
+		_, err = f.Write(data)
+		if err == nil {
+			err = f.Sync()
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(f.Name(), s.StateFile)
+		}
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}
+	if err != nil {
+		s.emit(Event{Kind: StateFileFailed, Err: fmt.Errorf("supervisor: writing %s: %w", s.StateFile, err)})
+	}
+}
+
+// removeState removes the StateFile, once no instance runs.
+func (s *Supervisor) removeState() {
+	if s.StateFile == "" {
+		return
+	}
+	if err := os.Remove(s.StateFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		s.emit(Event{Kind: StateFileFailed, Err: fmt.Errorf("supervisor: removing %s: %w", s.StateFile, err)})
+	}
+}
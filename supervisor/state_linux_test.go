@@ -0,0 +1,167 @@
+package supervisor_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/supervisor"
+)
+
+const loop = "while :; do sleep 0.05; done"
+
+// startTicks returns the start time of process pid, as /proc/<pid>/stat reports it.
+func startTicks(t *testing.T, pid int) uint64 {
+	t.Helper()
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.Fields(string(data[strings.LastIndexByte(string(data), ')')+1:]))
+	ticks, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ticks
+}
+
+// record starts run as a previous agent would have started spec, and records it in the
+// state file.
+func record(t *testing.T, stateFile string, spec cdsexec.CommandSpec, run ...string) int {
+	t.Helper()
+	if run == nil {
+		run = append([]string{spec.Name}, spec.Args...)
+	}
+	cmd := exec.Command(run[0], run[1:]...)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	pid := cmd.Process.Pid
+	t.Cleanup(func() { cmd.Process.Kill() })
+	data, err := json.Marshal(map[string]any{"pid": pid, "start_ticks": startTicks(t, pid), "spec": spec, "started": time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(stateFile, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return pid
+}
+
+func TestAdopt(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "tgtd.json")
+	pid := record(t, stateFile, sh(loop))
+	var adopted bool
+	s := &supervisor.Supervisor{
+		Spec:      sh(loop),
+		StateFile: stateFile,
+		OnEvent:   func(ev supervisor.Event) { adopted = adopted || ev.Kind == supervisor.InstanceAdopted },
+	}
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if s.PID() != pid || !adopted {
+		t.Errorf("Expected instance %d to be adopted, got %d", pid, s.PID())
+	}
+	s.Stop()
+	if alive(pid) {
+		t.Errorf("Expected the adopted instance to be stopped")
+	}
+	if _, err := os.Stat(stateFile); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected Stop to remove the state file, got %v", err)
+	}
+}
+
+func TestAdoptChangedSpec(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "tgtd.json")
+	pid := record(t, stateFile, sh(loop))
+	s := &supervisor.Supervisor{Spec: sh("echo v2; " + loop), StateFile: stateFile}
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	if s.PID() == pid {
+		t.Fatalf("Expected a new instance")
+	}
+	if alive(pid) {
+		t.Errorf("Expected the instance of the previous spec to be stopped")
+	}
+	data, err := os.ReadFile(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec struct {
+		PID        int    `json:"pid"`
+		StartTicks uint64 `json:"start_ticks"`
+	}
+	if err := json.Unmarshal(data, &rec); err != nil || rec.PID != s.PID() || rec.StartTicks != startTicks(t, rec.PID) {
+		t.Errorf("Expected the state file to record %d, got %s", s.PID(), data)
+	}
+}
+
+func TestAdoptReusedPID(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "tgtd.json")
+	other := record(t, stateFile, sh(loop))
+	// The PID of the record is now that of another process, started at another time.
+	data, _ := os.ReadFile(stateFile)
+	var rec map[string]any
+	json.Unmarshal(data, &rec)
+	rec["start_ticks"] = startTicks(t, other) - 1
+	data, _ = json.Marshal(rec)
+	os.WriteFile(stateFile, data, 0o600)
+
+	s := &supervisor.Supervisor{Spec: sh(loop), StateFile: stateFile}
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	if s.PID() == other {
+		t.Errorf("Expected a new instance")
+	}
+	if !alive(other) {
+		t.Errorf("Expected the process reusing the PID to be left alone")
+	}
+}
+
+func TestAdoptWrapped(t *testing.T) {
+	// A shim, e.g. of preexec, replaced itself with the command, so the command line of the
+	// instance is not that of the spec.
+	stateFile := filepath.Join(t.TempDir(), "tgtd.json")
+	pid := record(t, stateFile, sh(loop), "sleep", "60")
+	s := &supervisor.Supervisor{Spec: sh(loop), StateFile: stateFile}
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	if s.PID() != pid {
+		t.Errorf("Expected instance %d to be adopted, got %d", pid, s.PID())
+	}
+}
+
+func TestAdoptEmptyArgs(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "daemon")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n"+loop+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	spec := cdsexec.CommandSpec{Name: script, Args: []string{}}
+	stateFile := filepath.Join(dir, "daemon.json")
+	pid := record(t, stateFile, spec)
+	s := &supervisor.Supervisor{Spec: spec, StateFile: stateFile}
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	if s.PID() != pid {
+		t.Errorf("Expected instance %d to be adopted, got %d", pid, s.PID())
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package supervisor
+
+import "errors"
+
+// startTicks fails: the time a process started cannot be read here, so recorded instances
+// are never adopted.
+func startTicks(pid int) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,668 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/retrycmd"
+)
+
+var (
+	// ErrNotReady matches the errors of instances that did not become ready.
+	ErrNotReady = errors.New("supervisor: process not ready")
+	// ErrNotRunning is returned by Reload when no instance is ready.
+	ErrNotRunning = errors.New("supervisor: process not running")
+	// errExited is the error of an instance that exited successfully, yet too early.
+	errExited = errors.New("supervisor: instance exited")
+)
+
+// State is the state of a Supervisor.
+type State int
+
+const (
+	// Stopped is the state of a Supervisor that was not started, or was stopped.
+	Stopped State = iota
+	// Starting is the state while the first instance starts and becomes ready.
+	Starting
+	// Running is the state while a ready instance runs.
+	Running
+	// Restarting is the state from the exit of an instance until the next one is ready.
+	Restarting
+	// Quarantined is the state of a Supervisor that stopped restarting a process caught in
+	// a crash loop, until Resume is called.
+	Quarantined
+)
+
+func (s State) String() string {
+	switch s {
+	case Stopped:
+		return "stopped"
+	case Starting:
+		return "starting"
+	case Running:
+		return "running"
+	case Restarting:
+		return "restarting"
+	case Quarantined:
+		return "quarantined"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// EventKind identifies an Event.
+type EventKind int
+
+const (
+	// InstanceReady is sent when an instance became ready.
+	InstanceReady EventKind = iota
+	// InstanceNotReady is sent when an instance did not become ready, and was stopped.
+	InstanceNotReady
+	// InstanceExited is sent when the ready instance exited.
+	InstanceExited
+	// QuarantineStarted is sent when the Supervisor stops restarting the process.
+	QuarantineStarted
+	// QuarantineEnded is sent when Resume ends a quarantine.
+	QuarantineEnded
+	// InstanceAdopted is sent when Start adopts the instance recorded in the StateFile.
+	InstanceAdopted
+	// StateFileFailed is sent when the StateFile cannot be read or written.
+	StateFileFailed
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case InstanceReady:
+		return "ready"
+	case InstanceNotReady:
+		return "not ready"
+	case InstanceExited:
+		return "exited"
+	case QuarantineStarted:
+		return "quarantine started"
+	case QuarantineEnded:
+		return "quarantine ended"
+	case InstanceAdopted:
+		return "adopted"
+	case StateFileFailed:
+		return "state file failed"
+	}
+	return fmt.Sprintf("EventKind(%d)", int(k))
+}
+
+// Event describes a change in the life of the instances of a Supervisor.
+type Event struct {
+	Kind EventKind
+	Time time.Time
+	// PID is the PID of the instance, 0 for quarantine events.
+	PID int
+	// Err is why the instance exited or was not ready, or why the StateFile failed.
+	Err error
+	// Restarts is the number of restarts within the RestartWindow, for quarantine events.
+	Restarts int
+}
+
+// ReloadStrategy is how Reload makes the process reload its configuration.
+type ReloadStrategy int
+
+const (
+	// SignalReload sends ReloadSignal to the instance.
+	SignalReload ReloadStrategy = iota
+	// ReplaceReload starts a new instance, and stops the current one once the new one is
+	// ready. Both run meanwhile, so the process must allow it, e.g. by not locking a PID
+	// file or by listening with SO_REUSEPORT.
+	ReplaceReload
+)
+
+func (r ReloadStrategy) String() string {
+	switch r {
+	case SignalReload:
+		return "signal"
+	case ReplaceReload:
+		return "replace"
+	}
+	return fmt.Sprintf("ReloadStrategy(%d)", int(r))
+}
+
+// Defaults of the fields of Supervisor.
+const (
+	DefaultReadyTimeout  = 30 * time.Second
+	DefaultProbeInterval = 250 * time.Millisecond
+	DefaultStopTimeout   = 10 * time.Second
+	DefaultRestartDelay  = time.Second
+	DefaultMaxBackoff    = time.Minute
+	DefaultMaxRestarts   = 5
+	DefaultRestartWindow = 5 * time.Minute
+)
+
+// Supervisor runs a long-lived process, such as a helper daemon, and starts it again
+// whenever it exits, until it is stopped. Each run of the process is an instance.
+type Supervisor struct {
+	// Constructor constructs the commands of the process and of ExecSucceeds probes. Nil
+	// means cdsexec.CommandContext.
+	Constructor cdsexec.CommandConstructor
+	// Spec describes the process. Its standard input is ignored, and its standard output
+	// and error, if set, receive the output of every instance.
+	Spec cdsexec.CommandSpec
+	// Ready are the probes an instance must pass, in order, to be ready. Nil means an
+	// instance is ready once started.
+	Ready []Probe
+	// ReadyTimeout bounds the wait for an instance to be ready. Zero means
+	// DefaultReadyTimeout.
+	ReadyTimeout time.Duration
+	// ProbeInterval is the interval at which the probes polling for a condition check it.
+	// Zero means DefaultProbeInterval.
+	ProbeInterval time.Duration
+	// StopSignal is sent to an instance to stop it, and the instance is killed if it still
+	// runs StopTimeout later. Nil means SIGTERM, and zero DefaultStopTimeout.
+	StopSignal  os.Signal
+	StopTimeout time.Duration
+	// RestartDelay is the wait before an instance that exited, or did not become ready,
+	// is replaced. Zero means DefaultRestartDelay.
+	RestartDelay time.Duration
+	// Backoff computes the wait before a restart from the number of restarts within
+	// RestartWindow, 1 for the first one. Nil means waits doubling from RestartDelay up to
+	// DefaultMaxBackoff.
+	Backoff retrycmd.Backoff
+	// MaxRestarts and RestartWindow detect crash loops: the process is quarantined, and
+	// not restarted until Resume is called, once it needs more than MaxRestarts restarts
+	// within RestartWindow. Zero means DefaultMaxRestarts and DefaultRestartWindow.
+	MaxRestarts   int
+	RestartWindow time.Duration
+	// OnEvent, if set, is called with the events of the instances. It is called from the
+	// goroutines starting them, so it must neither block nor call Stop.
+	OnEvent func(Event)
+	// StateFile, if set, is where the ready instance is recorded, so that a Supervisor
+	// started with the same StateFile, e.g. after the agent restarted, adopts it with
+	// cdsexec.AdoptProcess rather than starting another one. If Spec changed meanwhile,
+	// the recorded instance is stopped and a new one started instead. Instances are only
+	// adopted on Linux, where the time they started is checked first, so that a process
+	// reusing the PID of an instance is left alone.
+	//
+	// Instances outlive the agent only if nothing kills them with it, as systemd does
+	// unless the unit has KillMode=process, and if they write their output to files: the
+	// pipes copying it to other writers break when the agent exits. The exit status of an
+	// adopted instance is unknown.
+	StateFile string
+	// ReloadStrategy is how Reload reloads the process, and ReloadSignal the signal
+	// SignalReload sends. Nil means SIGHUP.
+	ReloadStrategy ReloadStrategy
+	ReloadSignal   os.Signal
+
+	// opMu serializes the replacement of instances by Reload and by supervise.
+	opMu    sync.Mutex
+	mu      sync.Mutex
+	state   State
+	current *Instance
+	clock   cdsexec.Clock
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+	resume  chan struct{}
+	// restarts are the times of the restarts within RestartWindow. Only supervise uses it.
+	restarts []time.Time
+}
+
+// Instance is a run of the process of a Supervisor, as its probes see it.
+type Instance struct {
+	sup     *Supervisor
+	cmd     cdsexec.Commander
+	started time.Time
+	// output reads the output of the instance until it is ready, if a probe needs it.
+	output  *cdsexec.Expecter
+	capture *captureWriter
+
+	done chan struct{}
+	err  error
+}
+
+// PID returns the PID of the process of the instance, 0 if it has none, e.g. if it runs
+// on a remote host.
+func (i *Instance) PID() int {
+	if p := i.cmd.Process(); p != nil {
+		return p.Pid
+	}
+	return 0
+}
+
+// Started returns the time the instance started.
+func (i *Instance) Started() time.Time {
+	return i.started
+}
+
+// wait waits for the instance to exit.
+func (i *Instance) wait() {
+	i.err = i.cmd.Wait()
+	i.capture.close()
+	close(i.done)
+}
+
+// exitErr returns why the instance exited, once it has.
+func (i *Instance) exitErr() error {
+	if i.err == nil {
+		return errExited
+	}
+	return i.err
+}
+
+// State returns the state of s.
+func (s *Supervisor) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// PID returns the PID of the instance of s that is ready, 0 if there is none.
+func (s *Supervisor) PID() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state != Running || s.current == nil {
+		return 0
+	}
+	return s.current.PID()
+}
+
+// Start starts the process and waits for its first instance to be ready, then supervises
+// it in the background until Stop is called or ctx is done: an instance that exits is
+// replaced after a Backoff, unless the process is caught in a crash loop. If the first
+// instance does not become ready, it is stopped and Start returns an error matching
+// ErrNotReady. Times are measured on the Clock of ctx.
+//
+// The instances are not cancelled with ctx, but stopped as Stop does.
+func (s *Supervisor) Start(ctx context.Context) error {
+	if s.Spec.Name == "" {
+		return errors.New("supervisor: empty command name")
+	}
+	s.mu.Lock()
+	if s.done != nil {
+		s.mu.Unlock()
+		return errors.New("supervisor: already started")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	s.clock = cdsexec.ClockFromContext(ctx)
+	s.ctx, s.cancel, s.done = ctx, cancel, make(chan struct{})
+	s.resume, s.restarts = make(chan struct{}, 1), nil
+	s.state = Starting
+	s.mu.Unlock()
+
+	inst := s.adopt()
+	if inst == nil {
+		var err error
+		if inst, err = s.startInstance(ctx); err != nil {
+			cancel()
+			s.removeState()
+			s.mu.Lock()
+			s.state, s.ctx, s.cancel, s.done = Stopped, nil, nil, nil
+			s.mu.Unlock()
+			return err
+		}
+	}
+	s.setCurrent(inst)
+	go s.supervise(ctx)
+	return nil
+}
+
+// Stop stops supervising the process and stops its instance: StopSignal is sent to it,
+// and it is killed if it still runs StopTimeout later. Stop returns once the instance has
+// exited.
+func (s *Supervisor) Stop() {
+	s.mu.Lock()
+	cancel, done := s.cancel, s.done
+	s.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// supervise replaces the instances of s that exit until ctx is done, then stops the
+// current one.
+func (s *Supervisor) supervise(ctx context.Context) {
+	defer func() {
+		s.mu.Lock()
+		inst := s.current
+		s.mu.Unlock()
+		if inst != nil {
+			s.stopInstance(inst)
+		}
+		s.removeState()
+		s.mu.Lock()
+		s.state, s.current, s.ctx, s.cancel = Stopped, nil, nil, nil
+		close(s.done)
+		s.done = nil
+		s.mu.Unlock()
+	}()
+	for {
+		s.mu.Lock()
+		inst := s.current
+		s.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-inst.done:
+		}
+		if !s.restart(ctx, inst) {
+			return
+		}
+	}
+}
+
+// restart replaces inst, which exited, unless Reload already did. It returns false if
+// ctx is done first.
+func (s *Supervisor) restart(ctx context.Context, inst *Instance) bool {
+	s.opMu.Lock()
+	s.mu.Lock()
+	replaced := s.current != inst
+	if !replaced {
+		s.state, s.current = Restarting, nil
+	}
+	s.mu.Unlock()
+	s.opMu.Unlock()
+	if replaced {
+		return true
+	}
+	s.emit(Event{Kind: InstanceExited, PID: inst.PID(), Err: inst.err})
+
+	backoff := s.Backoff
+	if backoff == nil {
+		backoff = retrycmd.ExponentialBackoff(durationOr(s.RestartDelay, DefaultRestartDelay), DefaultMaxBackoff)
+	}
+	maxRestarts := s.MaxRestarts
+	if maxRestarts <= 0 {
+		maxRestarts = DefaultMaxRestarts
+	}
+	for {
+		now := s.clock.Now()
+		window := now.Add(-durationOr(s.RestartWindow, DefaultRestartWindow))
+		for len(s.restarts) > 0 && !s.restarts[0].After(window) {
+			s.restarts = s.restarts[1:]
+		}
+		s.restarts = append(s.restarts, now)
+		if len(s.restarts) > maxRestarts {
+			if !s.quarantine(ctx) {
+				return false
+			}
+		} else if cdsexec.Sleep(ctx, backoff(len(s.restarts))) != nil {
+			return false
+		}
+
+		s.opMu.Lock()
+		inst, err := s.startInstance(ctx)
+		if err == nil {
+			s.setCurrent(inst)
+		}
+		s.opMu.Unlock()
+		if err == nil {
+			return true
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+	}
+}
+
+// quarantine waits for Resume, and returns false if ctx is done first.
+func (s *Supervisor) quarantine(ctx context.Context) bool {
+	restarts := len(s.restarts)
+	s.mu.Lock()
+	s.state = Quarantined
+	s.mu.Unlock()
+	s.emit(Event{Kind: QuarantineStarted, Restarts: restarts})
+	select {
+	case <-ctx.Done():
+		return false
+	case <-s.resume:
+	}
+	s.restarts = nil
+	s.emit(Event{Kind: QuarantineEnded, Restarts: restarts})
+	return true
+}
+
+// Resume ends the quarantine of the process, which is started again at once. It does
+// nothing if the process is not quarantined.
+func (s *Supervisor) Resume() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state != Quarantined {
+		return
+	}
+	s.state = Restarting
+	select {
+	case s.resume <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Supervisor) emit(ev Event) {
+	if s.OnEvent == nil {
+		return
+	}
+	ev.Time = s.clock.Now()
+	s.OnEvent(ev)
+}
+
+// Reload makes the process reload its configuration as ReloadStrategy says, and returns
+// ErrNotRunning if no instance is ready. With ReplaceReload, it returns once the new
+// instance is ready and the previous one has exited, or returns an error matching
+// ErrNotReady, and the previous instance keeps running, if the new one does not become
+// ready within ReadyTimeout or before ctx is done.
+func (s *Supervisor) Reload(ctx context.Context) error {
+	s.opMu.Lock()
+	defer s.opMu.Unlock()
+	s.mu.Lock()
+	old, runCtx := s.current, s.ctx
+	running := s.state == Running
+	s.mu.Unlock()
+	if !running {
+		return ErrNotRunning
+	}
+	if s.ReloadStrategy == SignalReload {
+		sig := s.ReloadSignal
+		if sig == nil {
+			sig = syscall.SIGHUP
+		}
+		if err := cdsexec.Signal(old.cmd, sig); err != nil {
+			return fmt.Errorf("supervisor: reloading %s: %w", s.Spec.Name, err)
+		}
+		return nil
+	}
+
+	// Stop cancels the wait for the new instance to be ready.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(runCtx, cancel)()
+	inst, err := s.startInstance(ctx)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if runCtx.Err() != nil {
+		// Stop was called meanwhile, and stopped the previous instance.
+		s.mu.Unlock()
+		s.stopInstance(inst)
+		return ErrNotRunning
+	}
+	s.current = inst
+	s.mu.Unlock()
+	s.saveState(inst)
+	s.stopInstance(old)
+	return nil
+}
+
+func (s *Supervisor) setCurrent(inst *Instance) {
+	s.mu.Lock()
+	s.state, s.current = Running, inst
+	s.mu.Unlock()
+	s.saveState(inst)
+}
+
+// startInstance starts an instance of the process and waits for it to be ready. An
+// instance that does not become ready is stopped.
+func (s *Supervisor) startInstance(ctx context.Context) (*Instance, error) {
+	ctor := s.Constructor
+	if ctor == nil {
+		ctor = cdsexec.CommandContext
+	}
+	spec := s.Spec
+	spec.Stdin = nil
+	inst := &Instance{sup: s, done: make(chan struct{})}
+	inst.cmd = spec.Command(context.WithoutCancel(ctx), ctor)
+	if s.needsOutput() {
+		r, w := io.Pipe()
+		inst.capture = &captureWriter{w: w}
+		inst.output = cdsexec.NewExpecter(r)
+		stdout, stderr := spec.Stdout, spec.Stderr
+		if stdout != nil && sameWriter(stdout, stderr) {
+			// Once teed, the streams are copied from two goroutines.
+			shared := &lockedWriter{w: stdout}
+			stdout, stderr = shared, shared
+		}
+		inst.cmd.SetStdout(teeWriter(stdout, inst.capture))
+		inst.cmd.SetStderr(teeWriter(stderr, inst.capture))
+	}
+	inst.started = s.clock.Now()
+	if err := inst.cmd.Start(); err != nil {
+		inst.capture.close()
+		return nil, fmt.Errorf("supervisor: starting %s: %w", spec.Name, err)
+	}
+	go inst.wait()
+
+	err := s.waitReady(ctx, inst)
+	inst.capture.close()
+	if err != nil {
+		s.stopInstance(inst)
+		err = fmt.Errorf("%w: %s: %w", ErrNotReady, spec.Name, err)
+		s.emit(Event{Kind: InstanceNotReady, PID: inst.PID(), Err: err})
+		return nil, err
+	}
+	s.emit(Event{Kind: InstanceReady, PID: inst.PID()})
+	return inst, nil
+}
+
+// waitReady waits for inst to pass the probes of s.
+func (s *Supervisor) waitReady(ctx context.Context, inst *Instance) error {
+	if len(s.Ready) == 0 {
+		return nil
+	}
+	ctx, cancel := cdsexec.ContextWithTimeout(ctx, durationOr(s.ReadyTimeout, DefaultReadyTimeout))
+	defer cancel()
+	// The probes stop waiting once the instance exits.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-inst.done:
+			cancel()
+		case <-stop:
+		}
+	}()
+	for _, p := range s.Ready {
+		if err := p.Wait(ctx, inst); err != nil {
+			select {
+			case <-inst.done:
+				return fmt.Errorf("exited before it was ready: %w", inst.exitErr())
+			default:
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// stopInstance stops inst and waits for it to exit.
+func (s *Supervisor) stopInstance(inst *Instance) {
+	sig := s.StopSignal
+	if sig == nil {
+		sig = syscall.SIGTERM
+	}
+	if cdsexec.Signal(inst.cmd, sig) == nil {
+		t := s.clock.NewTimer(durationOr(s.StopTimeout, DefaultStopTimeout))
+		defer t.Stop()
+		select {
+		case <-inst.done:
+			return
+		case <-t.C():
+		}
+	}
+	cdsexec.Signal(inst.cmd, os.Kill)
+	<-inst.done
+}
+
+// needsOutput reports whether a probe of s reads the output of the instances.
+func (s *Supervisor) needsOutput() bool {
+	for _, p := range s.Ready {
+		if _, ok := p.(outputProbe); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func durationOr(d, def time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return def
+}
+
+// captureWriter passes the output of an instance to its Expecter until it is closed, once
+// the instance is ready, and drops it afterwards.
+type captureWriter struct {
+	mu sync.Mutex
+	w  *io.PipeWriter
+}
+
+func (c *captureWriter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.w != nil {
+		c.w.Write(p)
+	}
+	return len(p), nil
+}
+
+func (c *captureWriter) close() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.w != nil {
+		c.w.Close()
+		c.w = nil
+	}
+}
+
+// sameWriter reports whether a and b are the same writer. Like exec.Cmd, it treats
+// writers of incomparable types as different.
+func sameWriter(a, b io.Writer) (same bool) {
+	defer func() { recover() }()
+	return a == b
+}
+
+// lockedWriter serializes the writes of the standard output and error of an instance.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// teeWriter returns a writer writing to w, if set, and to capture.
+func teeWriter(w io.Writer, capture io.Writer) io.Writer {
+	if w == nil {
+		return capture
+	}
+	return io.MultiWriter(w, capture)
+}
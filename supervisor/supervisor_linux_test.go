@@ -0,0 +1,325 @@
+package supervisor_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/supervisor"
+)
+
+func sh(script string) cdsexec.CommandSpec {
+	return cdsexec.CommandSpec{Name: "sh", Args: []string{"-c", script}}
+}
+
+func alive(pid int) bool {
+	return syscall.Kill(pid, 0) == nil
+}
+
+func TestReady(t *testing.T) {
+	dir := t.TempDir()
+	sock := filepath.Join(dir, "ready")
+	s := &supervisor.Supervisor{
+		Spec: sh("sleep 0.2; echo 'tgtd: listening'; touch " + sock + "; exec sleep 60"),
+		Ready: []supervisor.Probe{
+			supervisor.OutputMatches(regexp.MustCompile(`listening`)),
+			supervisor.FileExists(sock),
+		},
+		ProbeInterval: 10 * time.Millisecond,
+	}
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	if _, err := os.Stat(sock); err != nil {
+		t.Errorf("Expected %s to exist once Start returns, got %v", sock, err)
+	}
+	if got := s.State(); got != supervisor.Running {
+		t.Errorf("Expected state running, got %s", got)
+	}
+	pid := s.PID()
+	if pid == 0 || !alive(pid) {
+		t.Fatalf("Expected a running instance, got PID %d", pid)
+	}
+	s.Stop()
+	if got := s.State(); got != supervisor.Stopped {
+		t.Errorf("Expected state stopped, got %s", got)
+	}
+	if alive(pid) {
+		t.Errorf("Expected instance %d to be stopped", pid)
+	}
+}
+
+func TestNotReady(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	s := &supervisor.Supervisor{
+		Spec:          sh("echo $$ > " + pidFile + "; exec sleep 60"),
+		Ready:         []supervisor.Probe{supervisor.FileExists(filepath.Join(t.TempDir(), "never"))},
+		ReadyTimeout:  200 * time.Millisecond,
+		ProbeInterval: 10 * time.Millisecond,
+	}
+	err := s.Start(context.Background())
+	if !errors.Is(err, supervisor.ErrNotReady) {
+		t.Fatalf("Expected ErrNotReady, got %v", err)
+	}
+	if got := s.State(); got != supervisor.Stopped {
+		t.Errorf("Expected state stopped, got %s", got)
+	}
+	b, _ := os.ReadFile(pidFile)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alive(pid) {
+		t.Errorf("Expected the instance that was not ready to be stopped")
+	}
+}
+
+func TestExitedBeforeReady(t *testing.T) {
+	s := &supervisor.Supervisor{
+		Spec:  sh("echo starting; exit 3"),
+		Ready: []supervisor.Probe{supervisor.OutputMatches(regexp.MustCompile(`listening`))},
+	}
+	start := time.Now()
+	err := s.Start(context.Background())
+	if !errors.Is(err, supervisor.ErrNotReady) || !strings.Contains(err.Error(), "exited before it was ready") {
+		t.Fatalf("Expected an exit before readiness, got %v", err)
+	}
+	var exitErr *cdsexec.ExitError
+	if errors.As(err, &exitErr) && exitErr.Code != 3 {
+		t.Errorf("Expected exit code 3, got %d", exitErr.Code)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Expected Start to return once the instance exited, took %v", d)
+	}
+}
+
+func TestRestart(t *testing.T) {
+	s := &supervisor.Supervisor{
+		Spec:         sh("exec sleep 60"),
+		RestartDelay: 10 * time.Millisecond,
+	}
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	pid := s.PID()
+	syscall.Kill(pid, syscall.SIGKILL)
+	deadline := time.Now().Add(5 * time.Second)
+	for s.PID() == 0 || s.PID() == pid {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected a new instance, state %s", s.State())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPortOpenAndExecSucceeds(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	marker := filepath.Join(t.TempDir(), "marker")
+	s := &supervisor.Supervisor{
+		Spec: sh("sleep 0.1; touch " + marker + "; exec sleep 60"),
+		Ready: []supervisor.Probe{
+			supervisor.PortOpen(l.Addr().String()),
+			supervisor.ExecSucceeds(sh("test -e " + marker)),
+		},
+		ProbeInterval: 10 * time.Millisecond,
+	}
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("Expected the exec probe to wait for %s, got %v", marker, err)
+	}
+}
+
+func TestStopKills(t *testing.T) {
+	s := &supervisor.Supervisor{
+		Spec:        sh("trap '' TERM; echo trapped; while :; do sleep 0.05; done"),
+		Ready:       []supervisor.Probe{supervisor.OutputMatches(regexp.MustCompile(`trapped`))},
+		StopTimeout: 100 * time.Millisecond,
+	}
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	pid := s.PID()
+	start := time.Now()
+	s.Stop()
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Errorf("Expected Stop to wait for StopTimeout, took %v", d)
+	}
+	if alive(pid) {
+		t.Errorf("Expected instance %d to be killed", pid)
+	}
+}
+
+func TestReloadSignal(t *testing.T) {
+	reloaded := filepath.Join(t.TempDir(), "reloaded")
+	s := &supervisor.Supervisor{
+		Spec:  sh("trap 'touch " + reloaded + "' HUP; echo ready; while :; do sleep 0.05; done"),
+		Ready: []supervisor.Probe{supervisor.OutputMatches(regexp.MustCompile(`ready`))},
+	}
+	if err := s.Reload(context.Background()); !errors.Is(err, supervisor.ErrNotRunning) {
+		t.Errorf("Expected ErrNotRunning before Start, got %v", err)
+	}
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	pid := s.PID()
+	if err := s.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(reloaded); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the instance to get SIGHUP")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s.PID() != pid {
+		t.Errorf("Expected instance %d to keep running, got %d", pid, s.PID())
+	}
+}
+
+func TestReloadReplace(t *testing.T) {
+	count := filepath.Join(t.TempDir(), "count")
+	// Only the first two instances become ready.
+	s := &supervisor.Supervisor{
+		Spec: sh("n=$(cat " + count + " 2>/dev/null || echo 0); echo $((n+1)) > " + count +
+			"; [ $n -lt 2 ] && echo ready; exec sleep 60"),
+		Ready:          []supervisor.Probe{supervisor.OutputMatches(regexp.MustCompile(`ready`))},
+		ReadyTimeout:   200 * time.Millisecond,
+		ReloadStrategy: supervisor.ReplaceReload,
+	}
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	first := s.PID()
+	if err := s.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	second := s.PID()
+	if second == 0 || second == first {
+		t.Errorf("Expected a new instance, got %d after %d", second, first)
+	}
+	if alive(first) {
+		t.Errorf("Expected instance %d to be stopped", first)
+	}
+	if err := s.Reload(context.Background()); !errors.Is(err, supervisor.ErrNotReady) {
+		t.Errorf("Expected ErrNotReady, got %v", err)
+	}
+	if s.PID() != second || !alive(second) {
+		t.Errorf("Expected instance %d to keep running, got %d", second, s.PID())
+	}
+	if got := s.State(); got != supervisor.Running {
+		t.Errorf("Expected state running, got %s", got)
+	}
+}
+
+func TestCrashLoop(t *testing.T) {
+	events := make(chan supervisor.Event, 100)
+	var attempts []int
+	s := &supervisor.Supervisor{
+		Spec: sh("exit 1"),
+		Backoff: func(attempt int) time.Duration {
+			attempts = append(attempts, attempt)
+			return time.Millisecond
+		},
+		MaxRestarts: 3,
+		OnEvent:     func(ev supervisor.Event) { events <- ev },
+	}
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	next := func(kind supervisor.EventKind) (ready int) {
+		t.Helper()
+		for {
+			select {
+			case ev := <-events:
+				if ev.Kind == kind {
+					return ready
+				}
+				if ev.Kind == supervisor.InstanceReady {
+					ready++
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Expected a %s event, state %s", kind, s.State())
+			}
+		}
+	}
+	if ready := next(supervisor.QuarantineStarted); ready != 4 {
+		t.Errorf("Expected 4 instances before the quarantine, got %d", ready)
+	}
+	if got := s.State(); got != supervisor.Quarantined {
+		t.Errorf("Expected state quarantined, got %s", got)
+	}
+	if want := []int{1, 2, 3}; !reflect.DeepEqual(attempts, want) {
+		t.Errorf("Expected backoff attempts %v, got %v", want, attempts)
+	}
+	select {
+	case ev := <-events:
+		t.Errorf("Expected no event during the quarantine, got %s", ev.Kind)
+	case <-time.After(100 * time.Millisecond):
+	}
+	s.Resume()
+	next(supervisor.QuarantineEnded)
+	if ready := next(supervisor.QuarantineStarted); ready != 4 {
+		t.Errorf("Expected 4 instances after Resume, got %d", ready)
+	}
+}
+
+func TestSharedOutput(t *testing.T) {
+	var out overlapWriter
+	s := &supervisor.Supervisor{
+		Spec: cdsexec.CommandSpec{
+			Name:   "sh",
+			Args:   []string{"-c", "for i in $(seq 200); do echo out; echo err >&2; done; echo ready; exec sleep 60"},
+			Stdout: &out,
+			Stderr: &out,
+		},
+		Ready: []supervisor.Probe{supervisor.OutputMatches(regexp.MustCompile(`ready`))},
+	}
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s.Stop()
+	if out.overlapped.Load() {
+		t.Errorf("Expected the writes to the shared writer to be serialized")
+	}
+}
+
+// overlapWriter records whether two writes to it ever overlapped.
+type overlapWriter struct {
+	writing, overlapped atomic.Bool
+}
+
+func (w *overlapWriter) Write(p []byte) (int, error) {
+	if w.writing.Swap(true) {
+		w.overlapped.Store(true)
+	}
+	time.Sleep(100 * time.Microsecond)
+	w.writing.Store(false)
+	return len(p), nil
+}
@@ -0,0 +1,11 @@
+module github.com/cirrusdata/cdsexec/systemdcmd
+
+go 1.22.0
+
+require (
+	github.com/cirrusdata/cdsexec v0.0.0
+	github.com/coreos/go-systemd/v22 v22.5.0
+	github.com/godbus/dbus/v5 v5.1.0
+)
+
+replace github.com/cirrusdata/cdsexec => ../
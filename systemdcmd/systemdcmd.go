@@ -0,0 +1,249 @@
+package systemdcmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/cirrusdata/cdsexec"
+	sddbus "github.com/coreos/go-systemd/v22/dbus"
+	"github.com/godbus/dbus/v5"
+)
+
+// Manager is the part of the systemd D-Bus API used to manage the transient units.
+// *dbus.Conn of github.com/coreos/go-systemd/v22/dbus implements it.
+type Manager interface {
+	StartTransientUnitContext(ctx context.Context, name, mode string, properties []sddbus.Property, ch chan<- string) (int, error)
+	StopUnitContext(ctx context.Context, name, mode string, ch chan<- string) (int, error)
+}
+
+// Options configures the transient scope of every command.
+type Options struct {
+	// Slice places the scope in the given slice, e.g. "cdsexec.slice".
+	Slice string
+	// CPUWeight sets the relative CPU weight of the scope, from 1 to 10000. Zero keeps the default.
+	CPUWeight uint64
+	// CPUQuotaPercent caps the CPU time of the scope, in percent of one CPU. Zero means no cap.
+	CPUQuotaPercent uint64
+	// MemoryMax caps the memory of the scope in bytes. Zero means no cap.
+	MemoryMax uint64
+	// TasksMax caps the number of tasks of the scope. Zero means no cap.
+	TasksMax uint64
+	// Properties are added to the properties of the scope as is.
+	Properties []sddbus.Property
+	// KeepLeftovers keeps processes the command left behind running. By default the scope is
+	// stopped once the command exits, killing them.
+	KeepLeftovers bool
+}
+
+func (o Options) properties(desc string, pid int) []sddbus.Property {
+	props := []sddbus.Property{
+		sddbus.PropDescription(desc),
+		sddbus.PropPids(uint32(pid)),
+		{Name: "CPUAccounting", Value: dbus.MakeVariant(true)},
+		{Name: "MemoryAccounting", Value: dbus.MakeVariant(true)},
+		{Name: "TasksAccounting", Value: dbus.MakeVariant(true)},
+	}
+	if o.Slice != "" {
+		props = append(props, sddbus.PropSlice(o.Slice))
+	}
+	if o.CPUWeight > 0 {
+		props = append(props, sddbus.Property{Name: "CPUWeight", Value: dbus.MakeVariant(o.CPUWeight)})
+	}
+	if o.CPUQuotaPercent > 0 {
+		props = append(props, sddbus.Property{Name: "CPUQuotaPerSecUSec", Value: dbus.MakeVariant(o.CPUQuotaPercent * 10000)})
+	}
+	if o.MemoryMax > 0 {
+		props = append(props, sddbus.Property{Name: "MemoryMax", Value: dbus.MakeVariant(o.MemoryMax)})
+	}
+	if o.TasksMax > 0 {
+		props = append(props, sddbus.Property{Name: "TasksMax", Value: dbus.MakeVariant(o.TasksMax)})
+	}
+	return append(props, o.Properties...)
+}
+
+// gate holds the command until its process has been moved into the scope, so that
+// everything it runs is accounted and limited.
+const gate = `read -r _ <&3 || exit 125; exec 3<&-; exec "$0" "$@"`
+
+// New returns a CommandConstructor running every command as a local process in its own
+// transient systemd scope created through m, with the resource accounting and limits of
+// opts. Output, exit codes, Process and ProcessState are those of the local process.
+func New(m Manager, opts Options) cdsexec.CommandConstructor {
+	return func(ctx context.Context, name string, arg ...string) cdsexec.Commander {
+		return &Cmd{
+			Cmd:  &cdsexec.Cmd{Cmd: exec.CommandContext(ctx, "/bin/sh", "-c", gate, name)},
+			ctx:  ctx,
+			m:    m,
+			opts: opts,
+			spec: cdsexec.CommandSpec{Name: name, Args: arg},
+		}
+	}
+}
+
+var _ cdsexec.Commander = (*Cmd)(nil)
+
+// Cmd is a command running in a transient systemd scope.
+type Cmd struct {
+	*cdsexec.Cmd
+
+	ctx  context.Context
+	m    Manager
+	opts Options
+	spec cdsexec.CommandSpec
+	unit string
+}
+
+// Unit returns the name of the scope of the command once it has started.
+func (c *Cmd) Unit() string {
+	return c.unit
+}
+
+// Start starts the command and moves it into a new transient scope.
+func (c *Cmd) Start() error {
+	if c.Cmd.Cmd.Process != nil {
+		return errors.New("exec: already started")
+	}
+	path := c.spec.Name
+	if !strings.ContainsRune(path, '/') {
+		p, err := exec.LookPath(path)
+		if err != nil {
+			return err
+		}
+		path = p
+	}
+	c.Cmd.Args = append([]string{"/bin/sh", "-c", gate, path}, c.spec.Args...)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	c.Cmd.ExtraFiles = []*os.File{r}
+	err = c.Cmd.Start()
+	r.Close()
+	if err != nil {
+		return err
+	}
+
+	c.unit = unitName(c.spec.Name)
+	desc := "cdsexec: " + cdsexec.RedactorFromContext(c.ctx).Spec(c.spec).String()
+	if err := c.startScope(desc); err != nil {
+		c.Cmd.Cmd.Process.Kill()
+		c.Cmd.Wait()
+		return fmt.Errorf("systemdcmd: creating scope %s: %w", c.unit, err)
+	}
+	_, err = w.Write([]byte("\n"))
+	return err
+}
+
+func (c *Cmd) startScope(desc string) error {
+	ch := make(chan string, 1)
+	if _, err := c.m.StartTransientUnitContext(c.ctx, c.unit, "fail", c.opts.properties(desc, c.Cmd.Cmd.Process.Pid), ch); err != nil {
+		return err
+	}
+	select {
+	case result := <-ch:
+		if result != "done" {
+			return fmt.Errorf("job %s", result)
+		}
+		return nil
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	}
+}
+
+// Wait waits for the command to exit and, unless Options.KeepLeftovers is set, stops its
+// scope to kill the processes it left behind.
+func (c *Cmd) Wait() error {
+	err := c.Cmd.Wait()
+	if c.unit != "" && !c.opts.KeepLeftovers {
+		// The scope is usually already gone with its last process.
+		c.m.StopUnitContext(context.Background(), c.unit, "replace", nil)
+	}
+	return err
+}
+
+// Run starts the command and waits for it to complete.
+func (c *Cmd) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+// Output runs the command and returns its standard output.
+func (c *Cmd) Output() ([]byte, error) {
+	if c.Cmd.Stdout != nil {
+		return nil, errors.New("exec: Stdout already set")
+	}
+	var stdout bytes.Buffer
+	c.Cmd.Stdout = &stdout
+	var stderr *bytes.Buffer
+	if c.Cmd.Stderr == nil {
+		stderr = new(bytes.Buffer)
+		c.Cmd.Stderr = stderr
+	}
+	err := c.Run()
+	var ee *exec.ExitError
+	if stderr != nil && errors.As(err, &ee) {
+		ee.Stderr = stderr.Bytes()
+	}
+	return stdout.Bytes(), err
+}
+
+// CombinedOutput runs the command and returns its combined standard output and error.
+func (c *Cmd) CombinedOutput() ([]byte, error) {
+	if c.Cmd.Stdout != nil {
+		return nil, errors.New("exec: Stdout already set")
+	}
+	if c.Cmd.Stderr != nil {
+		return nil, errors.New("exec: Stderr already set")
+	}
+	var b lockedBuffer
+	c.Cmd.Stdout = &b
+	c.Cmd.Stderr = &b
+	err := c.Run()
+	return b.buf.Bytes(), err
+}
+
+// String returns the command line, redacted by the cdsexec.Redactor of the command's context.
+func (c *Cmd) String() string {
+	return cdsexec.RedactorFromContext(c.ctx).Spec(c.spec).String()
+}
+
+// unitName returns a unique scope name mentioning the binary.
+func unitName(name string) string {
+	var b [4]byte
+	rand.Read(b[:])
+	return fmt.Sprintf("cdsexec-%s-%s.scope", escapeUnit(filepath.Base(name)), hex.EncodeToString(b[:]))
+}
+
+// escapeUnit replaces the characters not allowed in unit names.
+func escapeUnit(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '.' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
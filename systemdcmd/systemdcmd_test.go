@@ -0,0 +1,113 @@
+package systemdcmd_test
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec/systemdcmd"
+	sddbus "github.com/coreos/go-systemd/v22/dbus"
+)
+
+// fakeManager records the units it is asked to manage.
+type fakeManager struct {
+	mu      sync.Mutex
+	result  string
+	started map[string][]sddbus.Property
+	stopped []string
+}
+
+func (m *fakeManager) StartTransientUnitContext(_ context.Context, name, _ string, props []sddbus.Property, ch chan<- string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.started == nil {
+		m.started = make(map[string][]sddbus.Property)
+	}
+	m.started[name] = props
+	ch <- m.result
+	return 1, nil
+}
+
+func (m *fakeManager) StopUnitContext(_ context.Context, name, _ string, _ chan<- string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stopped = append(m.stopped, name)
+	return 2, nil
+}
+
+func property(props []sddbus.Property, name string) (any, bool) {
+	for _, p := range props {
+		if p.Name == name {
+			return p.Value.Value(), true
+		}
+	}
+	return nil, false
+}
+
+func TestScope(t *testing.T) {
+	m := &fakeManager{result: "done"}
+	ctor := systemdcmd.New(m, systemdcmd.Options{Slice: "cdsexec.slice", MemoryMax: 64 << 20, CPUQuotaPercent: 50})
+
+	cmd := ctor(context.Background(), "sh", "-c", "echo $0 \"$@\"; exit 3", "a", "b c")
+	out, err := cmd.Output()
+	if string(out) != "a b c\n" {
+		t.Errorf("Unexpected output %q", out)
+	}
+	var ee *exec.ExitError
+	if !errors.As(err, &ee) || ee.ExitCode() != 3 {
+		t.Errorf("Expected exit code 3, got %v", err)
+	}
+
+	unit := cmd.(*systemdcmd.Cmd).Unit()
+	if !strings.HasPrefix(unit, "cdsexec-sh-") || !strings.HasSuffix(unit, ".scope") {
+		t.Errorf("Unexpected unit name %q", unit)
+	}
+	props := m.started[unit]
+	tests := []struct {
+		name string
+		want any
+	}{
+		{"PIDs", []uint32{uint32(cmd.Process().Pid)}},
+		{"Slice", "cdsexec.slice"},
+		{"MemoryAccounting", true},
+		{"MemoryMax", uint64(64 << 20)},
+		{"CPUQuotaPerSecUSec", uint64(500000)},
+		{"Description", `cdsexec: sh -c 'echo $0 "$@"; exit 3' a 'b c'`},
+	}
+	for _, tt := range tests {
+		got, ok := property(props, tt.name)
+		if !ok || !equal(got, tt.want) {
+			t.Errorf("Expected property %s=%v, got %v", tt.name, tt.want, got)
+		}
+	}
+	if len(m.stopped) != 1 || m.stopped[0] != unit {
+		t.Errorf("Expected the scope to be stopped, got %v", m.stopped)
+	}
+}
+
+func equal(a, b any) bool {
+	if as, ok := a.([]uint32); ok {
+		bs := b.([]uint32)
+		return len(as) == len(bs) && as[0] == bs[0]
+	}
+	return a == b
+}
+
+func TestScopeFailure(t *testing.T) {
+	m := &fakeManager{result: "failed"}
+	cmd := systemdcmd.New(m, systemdcmd.Options{KeepLeftovers: true})(context.Background(), "sleep", "5")
+	err := cmd.Run()
+	if err == nil || !strings.Contains(err.Error(), "job failed") {
+		t.Errorf("Expected scope creation to fail, got %v", err)
+	}
+	if cmd.ProcessState() == nil || cmd.ProcessState().Success() {
+		t.Errorf("Expected the process to be killed, got %v", cmd.ProcessState())
+	}
+
+	if err := systemdcmd.New(m, systemdcmd.Options{})(context.Background(), "cdsexec-missing-tool").Run(); !errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("Expected exec.ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package cdsexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ErrInvalidTemplate matches the errors of CommandTemplate.Spec.
+var ErrInvalidTemplate = errors.New("cdsexec: invalid command template")
+
+// CommandTemplate is a reusable command whose name and arguments contain {param}
+// placeholders, e.g. {Name: "zfs", Args: []string{"snapshot", "{pool}/{dataset}@{snap}"}}.
+// Write {{ and }} for literal braces. Each argument stays a single argument whatever the
+// parameters, as no shell is involved.
+type CommandTemplate struct {
+	Name string
+	Args []string
+	// Raw lists the parameters exempt from validation.
+	Raw []string
+}
+
+// TemplateError reports a template that cannot be expanded.
+type TemplateError struct {
+	// Param is the parameter at fault, if any.
+	Param  string
+	Reason string
+}
+
+func (e *TemplateError) Error() string {
+	if e.Param == "" {
+		return "cdsexec: command template: " + e.Reason
+	}
+	return fmt.Sprintf("cdsexec: command template: parameter %q %s", e.Param, e.Reason)
+}
+
+func (e *TemplateError) Is(target error) bool {
+	return target == ErrInvalidTemplate
+}
+
+// Spec expands the template with params. Unless listed in Raw, parameter values must be
+// plain tokens: non-empty, made of letters, digits and _ - . / : @ , + = %, and not starting
+// with - where they start an argument, so it cannot be taken for an option.
+// Placeholders without a parameter and parameters without a placeholder are errors.
+func (t CommandTemplate) Spec(params map[string]string) (CommandSpec, error) {
+	used := make(map[string]bool)
+	expand := func(s string) (string, error) {
+		return t.expand(s, params, used)
+	}
+	name, err := expand(t.Name)
+	if err != nil {
+		return CommandSpec{}, err
+	}
+	spec := CommandSpec{Name: name, Args: make([]string, len(t.Args))}
+	for i, a := range t.Args {
+		if spec.Args[i], err = expand(a); err != nil {
+			return CommandSpec{}, err
+		}
+	}
+	for p := range params {
+		if !used[p] {
+			return CommandSpec{}, &TemplateError{Param: p, Reason: "is not used by the template"}
+		}
+	}
+	return spec, nil
+}
+
+// Command expands the template with params and constructs the command with ctor.
+func (t CommandTemplate) Command(ctx context.Context, ctor CommandConstructor, params map[string]string) (Commander, error) {
+	spec, err := t.Spec(params)
+	if err != nil {
+		return nil, err
+	}
+	return spec.Command(ctx, ctor), nil
+}
+
+func (t CommandTemplate) expand(s string, params map[string]string, used map[string]bool) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '}' {
+			if i+1 < len(s) && s[i+1] == '}' {
+				b.WriteByte('}')
+				i++
+				continue
+			}
+			return "", &TemplateError{Reason: fmt.Sprintf("unmatched } in %q", s)}
+		}
+		if c != '{' {
+			b.WriteByte(c)
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == '{' {
+			b.WriteByte('{')
+			i++
+			continue
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", &TemplateError{Reason: fmt.Sprintf("unclosed { in %q", s)}
+		}
+		p := s[i+1 : i+end]
+		if !isParamName(p) {
+			return "", &TemplateError{Reason: fmt.Sprintf("invalid placeholder {%s}", p)}
+		}
+		v, ok := params[p]
+		if !ok {
+			return "", &TemplateError{Param: p, Reason: "is missing"}
+		}
+		if !slices.Contains(t.Raw, p) {
+			if err := validateParam(p, v, b.Len() == 0); err != nil {
+				return "", err
+			}
+		}
+		used[p] = true
+		b.WriteString(v)
+		i += end
+	}
+	return b.String(), nil
+}
+
+func isParamName(p string) bool {
+	if p == "" {
+		return false
+	}
+	for i, r := range p {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func validateParam(p, v string, leading bool) error {
+	if v == "" {
+		return &TemplateError{Param: p, Reason: "is empty"}
+	}
+	if leading && v[0] == '-' {
+		return &TemplateError{Param: p, Reason: fmt.Sprintf("value %q looks like an option", v)}
+	}
+	for _, r := range v {
+		if !isPlainTokenRune(r) {
+			return &TemplateError{Param: p, Reason: fmt.Sprintf("value %q contains %q", v, r)}
+		}
+	}
+	return nil
+}
+
+func isPlainTokenRune(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	}
+	return strings.ContainsRune("_-./:@,+=%", r)
+}
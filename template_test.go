@@ -0,0 +1,128 @@
+package cdsexec_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+func TestCommandTemplate(t *testing.T) {
+	snapshot := cdsexec.CommandTemplate{Name: "zfs", Args: []string{"snapshot", "{pool}/{dataset}@{snap}"}}
+	tests := []struct {
+		name     string
+		tmpl     cdsexec.CommandTemplate
+		params   map[string]string
+		expected []string
+		err      string
+	}{
+		{
+			name:     "substitution",
+			tmpl:     snapshot,
+			params:   map[string]string{"pool": "tank", "dataset": "vol1", "snap": "daily-2024.01.01"},
+			expected: []string{"snapshot", "tank/vol1@daily-2024.01.01"},
+		},
+		{
+			name:     "literal braces",
+			tmpl:     cdsexec.CommandTemplate{Name: "jq", Args: []string{"{{name: .{field}}}"}},
+			params:   map[string]string{"field": "pool"},
+			expected: []string{"{name: .pool}"},
+		},
+		{
+			name:   "whitespace",
+			tmpl:   snapshot,
+			params: map[string]string{"pool": "tank", "dataset": "vol1 tank/other", "snap": "s"},
+			err:    `cdsexec: command template: parameter "dataset" value "vol1 tank/other" contains ' '`,
+		},
+		{
+			name:   "metacharacter",
+			tmpl:   snapshot,
+			params: map[string]string{"pool": "tank;reboot", "dataset": "vol1", "snap": "s"},
+			err:    `cdsexec: command template: parameter "pool" value "tank;reboot" contains ';'`,
+		},
+		{
+			name:   "option",
+			tmpl:   cdsexec.CommandTemplate{Name: "zfs", Args: []string{"destroy", "{dataset}"}},
+			params: map[string]string{"dataset": "-r"},
+			err:    `cdsexec: command template: parameter "dataset" value "-r" looks like an option`,
+		},
+		{
+			name:   "option prefix",
+			tmpl:   cdsexec.CommandTemplate{Name: "rm", Args: []string{"{dir}/cache"}},
+			params: map[string]string{"dir": "-rf"},
+			err:    `cdsexec: command template: parameter "dir" value "-rf" looks like an option`,
+		},
+		{
+			name:   "option adjacent placeholders",
+			tmpl:   cdsexec.CommandTemplate{Name: "rm", Args: []string{"{a}{b}"}},
+			params: map[string]string{"a": "-r", "b": "f"},
+			err:    `cdsexec: command template: parameter "a" value "-r" looks like an option`,
+		},
+		{
+			name:     "dash inside",
+			tmpl:     cdsexec.CommandTemplate{Name: "zfs", Args: []string{"{pool}/{dataset}"}},
+			params:   map[string]string{"pool": "tank", "dataset": "-vol"},
+			expected: []string{"tank/-vol"},
+		},
+		{
+			name:     "raw",
+			tmpl:     cdsexec.CommandTemplate{Name: "zfs", Args: []string{"set", "comment={comment}", "{dataset}"}, Raw: []string{"comment"}},
+			params:   map[string]string{"comment": "owned by team a; do not touch", "dataset": "tank/vol1"},
+			expected: []string{"set", "comment=owned by team a; do not touch", "tank/vol1"},
+		},
+		{
+			name:   "missing",
+			tmpl:   snapshot,
+			params: map[string]string{"pool": "tank", "dataset": "vol1"},
+			err:    `cdsexec: command template: parameter "snap" is missing`,
+		},
+		{
+			name:   "unused",
+			tmpl:   cdsexec.CommandTemplate{Name: "zpool", Args: []string{"status", "{pool}"}},
+			params: map[string]string{"pool": "tank", "verbose": "yes"},
+			err:    `cdsexec: command template: parameter "verbose" is not used by the template`,
+		},
+		{
+			name: "unclosed",
+			tmpl: cdsexec.CommandTemplate{Name: "zpool", Args: []string{"status", "{pool"}},
+			err:  `cdsexec: command template: unclosed { in "{pool"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := tt.tmpl.Spec(tt.params)
+			if tt.err != "" {
+				if !errors.Is(err, cdsexec.ErrInvalidTemplate) || err.Error() != tt.err {
+					t.Errorf("Expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(spec.Args, tt.expected) {
+				t.Errorf("Expected %q, got %q", tt.expected, spec.Args)
+			}
+		})
+	}
+}
+
+func TestCommandTemplateCommand(t *testing.T) {
+	ctor := mockcmd.MakeMockCmdWithOutput("", func(m *mockcmd.MockCmd) error {
+		if m.Name != "zpool" || !reflect.DeepEqual(m.Args, []string{"status", "tank"}) {
+			t.Errorf("Unexpected command %s %q", m.Name, m.Args)
+		}
+		return nil
+	})
+	tmpl := cdsexec.CommandTemplate{Name: "zpool", Args: []string{"status", "{pool}"}}
+	cmd, err := tmpl.Command(context.Background(), ctor, map[string]string{"pool": "tank"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,84 @@
+package timeoutcmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// Rule applies Timeout to the commands matched by Match.
+type Rule struct {
+	Match   cdsexec.Matcher
+	Timeout time.Duration
+}
+
+// Policy maps commands to default timeouts.
+type Policy struct {
+	// Rules are checked in order and the first matching rule applies.
+	Rules []Rule
+	// Default applies to commands matched by no rule. Zero leaves them without a timeout.
+	Default time.Duration
+}
+
+// Timeout returns the timeout p assigns to spec, or zero for none.
+func (p Policy) Timeout(spec cdsexec.CommandSpec) time.Duration {
+	for _, r := range p.Rules {
+		if r.Match(spec) {
+			return r.Timeout
+		}
+	}
+	return p.Default
+}
+
+type overrideKey struct{}
+
+// WithTimeout returns a context under which commands use d instead of the timeout of the
+// policy. A zero d runs them without a timeout.
+func WithTimeout(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, overrideKey{}, d)
+}
+
+// New returns a CommandConstructor that bounds every command built by base by the timeout
+// p assigns to it, on top of any deadline of its context. A command killed because of that
+// timeout returns an error matching context.DeadlineExceeded and cdsexec.ErrTimedOut.
+// Timeouts are measured on the cdsexec.Clock of the context of the command.
+func New(base cdsexec.CommandConstructor, p Policy) cdsexec.CommandConstructor {
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		d, ok := inv.Ctx.Value(overrideKey{}).(time.Duration)
+		if !ok {
+			d = p.Timeout(inv.Spec)
+		}
+		if d <= 0 {
+			return next(inv)
+		}
+
+		parent := inv.Ctx
+		ctx, cancel := cdsexec.ContextWithTimeout(parent, d)
+		defer cancel()
+		inv.Ctx = ctx
+		err := next(inv)
+		inv.Ctx = parent
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) && parent.Err() == nil {
+			return &timeoutError{binary: filepath.Base(inv.Spec.Name), timeout: d, err: err}
+		}
+		return err
+	})
+}
+
+type timeoutError struct {
+	binary  string
+	timeout time.Duration
+	err     error
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("timeoutcmd: %s exceeded its timeout of %s: %v", e.binary, e.timeout, e.err)
+}
+
+func (e *timeoutError) Unwrap() []error {
+	return []error{e.err, context.DeadlineExceeded, cdsexec.ErrTimedOut, cdsexec.ErrKilledByContext}
+}
@@ -0,0 +1,59 @@
+package timeoutcmd_test
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/timeoutcmd"
+)
+
+func TestPolicyTimeout(t *testing.T) {
+	p := timeoutcmd.Policy{
+		Rules: []timeoutcmd.Rule{
+			{Match: cdsexec.MatchCommand("smartctl", "-t"), Timeout: 10 * time.Minute},
+			{Match: cdsexec.MatchBinary("smartctl"), Timeout: 30 * time.Second},
+		},
+		Default: time.Minute,
+	}
+	tests := []struct {
+		cmd  []string
+		want time.Duration
+	}{
+		{[]string{"/usr/sbin/smartctl", "-a", "/dev/sda"}, 30 * time.Second},
+		{[]string{"smartctl", "-t", "long", "/dev/sda"}, 10 * time.Minute},
+		{[]string{"lsblk"}, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := p.Timeout(cdsexec.CommandSpec{Name: tt.cmd[0], Args: tt.cmd[1:]}); got != tt.want {
+			t.Errorf("Expected %s for %v, got %s", tt.want, tt.cmd, got)
+		}
+	}
+}
+
+func TestTimeoutApplied(t *testing.T) {
+	ctor := timeoutcmd.New(cdsexec.CommandContext, timeoutcmd.Policy{
+		Rules: []timeoutcmd.Rule{{Match: cdsexec.MatchBinary("sleep"), Timeout: 50 * time.Millisecond}},
+	})
+
+	start := time.Now()
+	err := ctor(context.Background(), "sleep", "5").Run()
+	var ee *exec.ExitError
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.As(err, &ee) {
+		t.Errorf("Expected a deadline error wrapping the exit error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the command to be killed, took %s", elapsed)
+	}
+
+	if err := ctor(context.Background(), "sh", "-c", "sleep 0.1").Run(); err != nil {
+		t.Errorf("Expected commands without a timeout to be unaffected, got %v", err)
+	}
+	ctx := timeoutcmd.WithTimeout(context.Background(), 0)
+	if err := ctor(ctx, "sleep", "0.1").Run(); err != nil {
+		t.Errorf("Expected the override to disable the timeout, got %v", err)
+	}
+}
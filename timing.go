@@ -0,0 +1,202 @@
+package cdsexec
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Phases breaks down the execution of a command. Phases that were not measured are zero.
+type Phases struct {
+	// Construct is the time taken to construct the command, which includes looking up its
+	// binary in PATH for local commands.
+	Construct time.Duration `json:"construct"`
+	// Spawn is the time taken to start the command: fork and exec for local commands.
+	Spawn time.Duration `json:"spawn"`
+	// FirstOutput is the time from the start of the command to its first write to its
+	// standard output or standard error. It is not measured for streams written directly
+	// to files.
+	FirstOutput time.Duration `json:"first_output"`
+}
+
+// runLocal executes a local command through Start and Wait, like the corresponding
+// methods of exec.Cmd, so that its phases can be measured.
+func (w *wrappedCmd) runLocal(inv *Invocation, c *Cmd) error {
+	var out *bytes.Buffer
+	var stderr *prefixSuffixSaver
+	switch inv.Kind {
+	case CallOutput:
+		if c.Cmd.Stdout != nil {
+			return errors.New("exec: Stdout already set")
+		}
+		out = getBuffer(inv.Spec.Name)
+		c.Cmd.Stdout = out
+		if c.Cmd.Stderr == nil {
+			stderr = &prefixSuffixSaver{n: 32 << 10}
+			c.Cmd.Stderr = stderr
+		}
+	case CallCombinedOutput:
+		if c.Cmd.Stdout != nil {
+			return errors.New("exec: Stdout already set")
+		}
+		if c.Cmd.Stderr != nil {
+			return errors.New("exec: Stderr already set")
+		}
+		out = getBuffer(inv.Spec.Name)
+		c.Cmd.Stdout = out
+		c.Cmd.Stderr = out
+	}
+
+	first := firstOutput{clock: ClockFromContext(inv.Ctx)}
+	if c.Cmd.Stdout != nil && sameWriter(c.Cmd.Stdout, c.Cmd.Stderr) {
+		c.Cmd.Stdout = first.wrap(c.Cmd.Stdout)
+		c.Cmd.Stderr = c.Cmd.Stdout
+	} else {
+		c.Cmd.Stdout = first.wrap(c.Cmd.Stdout)
+		c.Cmd.Stderr = first.wrap(c.Cmd.Stderr)
+	}
+
+	first.start = first.clock.Now()
+	err := c.Start()
+	inv.Phases.Spawn = first.clock.Now().Sub(first.start)
+	if inv.Kind == CallStart {
+		w.started <- err
+		if err != nil {
+			return err
+		}
+		<-w.waitReq
+	}
+	if err == nil {
+		err = c.Wait()
+	}
+	inv.Phases.FirstOutput = first.elapsed()
+
+	if out != nil {
+		inv.Output = detachBuffer(inv.Spec.Name, out)
+	}
+	var ee *exec.ExitError
+	if stderr != nil && errors.As(err, &ee) {
+		ee.Stderr = stderr.Bytes()
+	}
+	return err
+}
+
+// sameWriter reports whether a and b are the same writer, for which exec.Cmd uses a single
+// pipe. Like exec.Cmd, it treats writers of incomparable types as different.
+func sameWriter(a, b io.Writer) (same bool) {
+	defer func() { recover() }()
+	return a == b
+}
+
+// firstOutput records the time of the first write to the writers it wraps.
+type firstOutput struct {
+	clock Clock
+	start time.Time
+	once  sync.Once
+	mu    sync.Mutex
+	at    time.Duration
+}
+
+// wrap returns a writer recording the first write to wr. Files are returned unchanged so
+// that they are still passed to the process directly.
+func (f *firstOutput) wrap(wr io.Writer) io.Writer {
+	if wr == nil {
+		return nil
+	}
+	if _, ok := wr.(*os.File); ok {
+		return wr
+	}
+	return &firstOutputWriter{f: f, w: wr}
+}
+
+func (f *firstOutput) record() {
+	f.once.Do(func() {
+		f.mu.Lock()
+		f.at = f.clock.Now().Sub(f.start)
+		f.mu.Unlock()
+	})
+}
+
+func (f *firstOutput) elapsed() time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.at
+}
+
+type firstOutputWriter struct {
+	f *firstOutput
+	w io.Writer
+}
+
+func (w *firstOutputWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		w.f.record()
+	}
+	return w.w.Write(p)
+}
+
+// ReadFrom lets io.Copy, which exec.Cmd copies output with, hand r to the underlying
+// writer when it is an io.ReaderFrom such as a bytes.Buffer, instead of copying through a
+// buffer of its own.
+func (w *firstOutputWriter) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := w.w.(io.ReaderFrom); ok {
+		return rf.ReadFrom(&firstOutputReader{f: w.f, r: r})
+	}
+	return Copy(struct{ io.Writer }{w}, r)
+}
+
+// firstOutputReader records the first read of output from the process.
+type firstOutputReader struct {
+	f *firstOutput
+	r io.Reader
+}
+
+func (r *firstOutputReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.f.record()
+	}
+	return n, err
+}
+
+// prefixSuffixSaver keeps the first and last n bytes written to it, as exec.Cmd.Output does
+// for the standard error it attaches to an *exec.ExitError.
+type prefixSuffixSaver struct {
+	n      int
+	prefix []byte
+	suffix *RingBuffer
+}
+
+func (s *prefixSuffixSaver) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := s.n - len(s.prefix); room > 0 {
+		c := min(room, len(p))
+		s.prefix = append(s.prefix, p[:c]...)
+		p = p[c:]
+	}
+	if len(p) > 0 {
+		if s.suffix == nil {
+			s.suffix = NewRingBuffer(s.n)
+		}
+		s.suffix.Write(p)
+	}
+	return n, nil
+}
+
+func (s *prefixSuffixSaver) Bytes() []byte {
+	if s.suffix == nil {
+		return s.prefix
+	}
+	var b bytes.Buffer
+	b.Write(s.prefix)
+	if skipped := s.suffix.Written() - int64(s.n); skipped > 0 {
+		b.WriteString("\n... omitting " + strconv.FormatInt(skipped, 10) + " bytes ...\n")
+	}
+	b.Write(s.suffix.Bytes())
+	return b.Bytes()
+}
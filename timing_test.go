@@ -0,0 +1,91 @@
+package cdsexec_test
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+func TestPhases(t *testing.T) {
+	for _, kind := range []cdsexec.CallKind{cdsexec.CallRun, cdsexec.CallOutput, cdsexec.CallCombinedOutput, cdsexec.CallStart} {
+		t.Run(kind.String(), func(t *testing.T) { testPhases(t, kind, cdsexec.CommandContext) })
+		// An outer layer reports the phases measured by the inner one.
+		t.Run(kind.String()+"Nested", func(t *testing.T) { testPhases(t, kind, cdsexec.Wrap(cdsexec.CommandContext, passThrough)) })
+	}
+}
+
+func testPhases(t *testing.T, kind cdsexec.CallKind, base cdsexec.CommandConstructor) {
+	var res cdsexec.Result
+	ctor := cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		err := next(inv)
+		res = inv.Result(err)
+		return err
+	})
+	cmd := ctor(context.Background(), "sh", "-c", "sleep 0.1; echo ready")
+	var err error
+	switch kind {
+	case cdsexec.CallRun:
+		cmd.SetStdout(new(strings.Builder))
+		err = cmd.Run()
+	case cdsexec.CallOutput:
+		_, err = cmd.Output()
+	case cdsexec.CallCombinedOutput:
+		_, err = cmd.CombinedOutput()
+	case cdsexec.CallStart:
+		cmd.SetStdout(new(strings.Builder))
+		if err = cmd.Start(); err == nil {
+			err = cmd.Wait()
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := res.Phases
+	if p.Construct <= 0 || p.Spawn <= 0 {
+		t.Errorf("Expected construct and spawn times, got %+v", p)
+	}
+	if p.FirstOutput < 100*time.Millisecond || p.FirstOutput > res.Duration {
+		t.Errorf("Expected the first output after 100ms and within %v, got %v", res.Duration, p.FirstOutput)
+	}
+}
+
+func TestOutputStderrCapture(t *testing.T) {
+	ctor := cdsexec.Wrap(cdsexec.CommandContext, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		return next(inv)
+	})
+	out, err := ctor(context.Background(), "sh", "-c", "echo partial; echo boom >&2; exit 1").Output()
+	var ee *exec.ExitError
+	if !errors.As(err, &ee) || string(ee.Stderr) != "boom\n" || string(out) != "partial\n" {
+		t.Errorf("Expected output and stderr as exec.Cmd.Output returns them, got %q, %v", out, err)
+	}
+
+	_, err = ctor(context.Background(), "sh", "-c", "head -c 100000 /dev/zero | tr '\\0' x >&2; exit 1").Output()
+	if !errors.As(err, &ee) || len(ee.Stderr) > 70000 || !strings.Contains(string(ee.Stderr), "... omitting 34464 bytes ...") {
+		t.Errorf("Expected the prefix and suffix of stderr, got %d bytes", len(ee.Stderr))
+	}
+}
+
+type funcWriter func(p []byte)
+
+func (f funcWriter) Write(p []byte) (int, error) {
+	f(p)
+	return len(p), nil
+}
+
+func TestPhasesIncomparableWriter(t *testing.T) {
+	var n int
+	w := funcWriter(func(p []byte) { n += len(p) })
+	cmd := cdsexec.Wrap(cdsexec.CommandContext, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		return next(inv)
+	})(context.Background(), "echo", "ok")
+	cmd.SetStdout(w)
+	cmd.SetStderr(w)
+	if err := cmd.Run(); err != nil || n != 3 {
+		t.Errorf("Expected 3 bytes written, got %d, %v", n, err)
+	}
+}
@@ -0,0 +1,140 @@
+package tmpdircmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// Prefix starts the names of the directories, followed by the PID of the process that
+// created them, which Sweep relies on.
+const Prefix = "cdsexec-tmp-"
+
+// DefaultVars are the environment variables set to the directory of a command when
+// Options.Vars is nil: TMPDIR for POSIX tools, and TMP and TEMP for others.
+var DefaultVars = []string{"TMPDIR", "TMP", "TEMP"}
+
+// Options configures the temporary directories.
+type Options struct {
+	// Parent is the directory the temporary directories are created in. Empty means
+	// os.TempDir of the current process.
+	Parent string
+	// Vars are the environment variables set to the directory. Nil means DefaultVars.
+	Vars []string
+}
+
+// New returns a CommandConstructor whose commands built by base are each given a new
+// temporary directory, accessible only to the user of the current process, in the
+// environment variables of opts.Vars. The directory is removed with its contents once the
+// command has finished, including when it failed or was killed; for commands started with
+// Start, when Wait returns. A directory that cannot be removed fails a command that
+// succeeded. base must run commands on the local host.
+//
+// Directories of a process that was killed before it could remove them are left behind;
+// Sweep removes them.
+func New(base cdsexec.CommandConstructor, opts Options) cdsexec.CommandConstructor {
+	vars := opts.Vars
+	if vars == nil {
+		vars = DefaultVars
+	}
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		parent := opts.Parent
+		if parent == "" {
+			parent = os.TempDir()
+		}
+		dir, err := os.MkdirTemp(parent, Prefix+strconv.Itoa(os.Getpid())+"-")
+		if err != nil {
+			return fmt.Errorf("tmpdircmd: %w", err)
+		}
+		kv := make([]string, len(vars))
+		for i, v := range vars {
+			kv[i] = v + "=" + dir
+		}
+		inv.Spec.Env = cdsexec.MergeEnv(inv.Spec.Env, kv...)
+		err = next(inv)
+		if rmErr := removeAll(dir); rmErr != nil && err == nil {
+			err = fmt.Errorf("tmpdircmd: %w", rmErr)
+		}
+		return err
+	})
+}
+
+// removeAll removes dir and its contents, including directories the command made
+// read-only.
+func removeAll(dir string) error {
+	if os.RemoveAll(dir) == nil {
+		return nil
+	}
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			os.Chmod(path, 0o700)
+		}
+		return nil
+	})
+	return os.RemoveAll(dir)
+}
+
+// Sweep removes the directories left in parent, or os.TempDir if parent is empty, by
+// processes that are no longer running, and returns their paths. It is meant to be called
+// when a process starts, to clean up after a previous instance that was killed.
+func Sweep(parent string) ([]string, error) {
+	if parent == "" {
+		parent = os.TempDir()
+	}
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		return nil, fmt.Errorf("tmpdircmd: %w", err)
+	}
+	var removed []string
+	var errs []error
+	for _, e := range entries {
+		pid, ok := owner(e.Name())
+		if !ok || !e.IsDir() || running(pid) {
+			continue
+		}
+		path := filepath.Join(parent, e.Name())
+		if err := removeAll(path); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		removed = append(removed, path)
+	}
+	if len(errs) > 0 {
+		return removed, fmt.Errorf("tmpdircmd: %w", errors.Join(errs...))
+	}
+	return removed, nil
+}
+
+// owner returns the PID of the process that created the directory name.
+func owner(name string) (int, bool) {
+	rest, ok := strings.CutPrefix(name, Prefix)
+	if !ok {
+		return 0, false
+	}
+	pid, _, ok := strings.Cut(rest, "-")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(pid)
+	return n, err == nil && n > 0
+}
+
+// running reports whether the process pid is running.
+func running(pid int) bool {
+	if pid == os.Getpid() {
+		return true
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	defer p.Release()
+	return !errors.Is(p.Signal(syscall.Signal(0)), os.ErrProcessDone)
+}
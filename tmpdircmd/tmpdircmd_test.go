@@ -0,0 +1,86 @@
+package tmpdircmd_test
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/tmpdircmd"
+)
+
+func TestTempDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+	parent := t.TempDir()
+	ctor := tmpdircmd.New(cdsexec.CommandContext, tmpdircmd.Options{Parent: parent})
+	out, err := ctor(context.Background(), "sh", "-c", `echo "$TMPDIR $TMP $TEMP" && mkdir "$TMPDIR/ro" && touch "$TMPDIR/ro/f" && chmod 500 "$TMPDIR/ro"`).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirs := strings.Fields(string(out))
+	if len(dirs) != 3 || dirs[0] != dirs[1] || dirs[0] != dirs[2] || filepath.Dir(dirs[0]) != parent ||
+		!strings.HasPrefix(filepath.Base(dirs[0]), tmpdircmd.Prefix+strconv.Itoa(os.Getpid())+"-") {
+		t.Fatalf("Unexpected temporary directories %q", out)
+	}
+	if _, err := os.Stat(dirs[0]); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be removed, got %v", dirs[0], err)
+	}
+
+	// The directory of a killed command is removed too.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cmd := ctor(ctx, "sh", "-c", `touch "$TMPDIR/f" && echo "$TMPDIR" && sleep 30`)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	dir, _ := bufio.NewReader(stdout).ReadString('\n')
+	dir = strings.TrimSpace(dir)
+	if _, err := os.Stat(filepath.Join(dir, "f")); err != nil {
+		t.Errorf("Expected the directory while the command runs, got %v", err)
+	}
+	cancel()
+	if err := cmd.Wait(); err == nil {
+		t.Error("Expected the killed command to fail")
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be removed after Wait, got %v", dir, err)
+	}
+	if entries, _ := os.ReadDir(parent); len(entries) != 0 {
+		t.Errorf("Expected nothing left in %s, got %v", parent, entries)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	parent := t.TempDir()
+	stale := filepath.Join(parent, tmpdircmd.Prefix+"999999999-1234")
+	own := filepath.Join(parent, tmpdircmd.Prefix+strconv.Itoa(os.Getpid())+"-1234")
+	other := filepath.Join(parent, "cdsexec-tmp")
+	for _, dir := range []string{stale, own, other} {
+		if err := os.MkdirAll(filepath.Join(dir, "sub"), 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	removed, err := tmpdircmd.Sweep(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != stale {
+		t.Errorf("Expected only %s to be removed, got %v", stale, removed)
+	}
+	for _, dir := range []string{own, other} {
+		if _, err := os.Stat(dir); err != nil {
+			t.Errorf("Expected %s to be kept, got %v", dir, err)
+		}
+	}
+}
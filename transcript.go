@@ -0,0 +1,186 @@
+package cdsexec
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Stream identifies a standard output stream of a command.
+type Stream int
+
+const (
+	Stdout Stream = iota + 1
+	Stderr
+)
+
+// String returns "stdout" or "stderr".
+func (s Stream) String() string {
+	switch s {
+	case Stdout:
+		return "stdout"
+	case Stderr:
+		return "stderr"
+	}
+	return fmt.Sprintf("Stream(%d)", int(s))
+}
+
+// MarshalText encodes the stream as its name.
+func (s Stream) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes a stream name.
+func (s *Stream) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case "stdout":
+		*s = Stdout
+	case "stderr":
+		*s = Stderr
+	default:
+		return fmt.Errorf("cdsexec: unknown stream %q", b)
+	}
+	return nil
+}
+
+// OutputEvent is a chunk of output written by a command.
+type OutputEvent struct {
+	Time   time.Time
+	Stream Stream
+	Data   []byte
+}
+
+type outputEventJSON struct {
+	Time   time.Time `json:"time"`
+	Stream Stream    `json:"stream"`
+	Text   *string   `json:"text,omitempty"`
+	Data   []byte    `json:"data,omitempty"`
+}
+
+// MarshalJSON encodes the event with its data as "text" if it is valid UTF-8, and in
+// base64 as "data" otherwise.
+func (e OutputEvent) MarshalJSON() ([]byte, error) {
+	v := outputEventJSON{Time: e.Time, Stream: e.Stream}
+	if utf8.Valid(e.Data) {
+		text := string(e.Data)
+		v.Text = &text
+	} else {
+		v.Data = e.Data
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON decodes an event encoded by MarshalJSON.
+func (e *OutputEvent) UnmarshalJSON(b []byte) error {
+	var v outputEventJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*e = OutputEvent{Time: v.Time, Stream: v.Stream, Data: v.Data}
+	if v.Text != nil {
+		e.Data = []byte(*v.Text)
+	}
+	return nil
+}
+
+// Transcript records the output of a command as timestamped events, keeping the order in
+// which the writes of its standard output and standard error were received. It is safe for
+// concurrent use, and its zero value is ready to use.
+type Transcript struct {
+	// Clock timestamps the events. Nil means RealClock.
+	Clock Clock
+
+	mu     sync.Mutex
+	events []OutputEvent
+}
+
+// CaptureTranscript sets the standard output and standard error of cmd to a new
+// Transcript and returns it.
+func CaptureTranscript(cmd Commander) *Transcript {
+	t := new(Transcript)
+	cmd.SetStdout(t.Writer(Stdout))
+	cmd.SetStderr(t.Writer(Stderr))
+	return t
+}
+
+// Writer returns a writer recording what is written to it as output of stream s.
+func (t *Transcript) Writer(s Stream) io.Writer {
+	return &transcriptWriter{t: t, stream: s}
+}
+
+// Events returns a copy of the events recorded so far.
+func (t *Transcript) Events() []OutputEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]OutputEvent(nil), t.events...)
+}
+
+// Output returns the data of the events of the given streams, or of all streams if none is
+// given, concatenated in order.
+func (t *Transcript) Output(streams ...Stream) []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out []byte
+	for _, e := range t.events {
+		if len(streams) == 0 || containsStream(streams, e.Stream) {
+			out = append(out, e.Data...)
+		}
+	}
+	return out
+}
+
+func containsStream(streams []Stream, s Stream) bool {
+	for _, x := range streams {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// MarshalJSON encodes the transcript as an array of events.
+func (t *Transcript) MarshalJSON() ([]byte, error) {
+	events := t.Events()
+	if events == nil {
+		events = []OutputEvent{}
+	}
+	return json.Marshal(events)
+}
+
+// UnmarshalJSON decodes a transcript encoded by MarshalJSON.
+func (t *Transcript) UnmarshalJSON(b []byte) error {
+	var events []OutputEvent
+	if err := json.Unmarshal(b, &events); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events = events
+	return nil
+}
+
+type transcriptWriter struct {
+	t      *Transcript
+	stream Stream
+}
+
+func (w *transcriptWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	w.t.mu.Lock()
+	defer w.t.mu.Unlock()
+	clock := w.t.Clock
+	if clock == nil {
+		clock = RealClock
+	}
+	w.t.events = append(w.t.events, OutputEvent{
+		Time:   clock.Now(),
+		Stream: w.stream,
+		Data:   append([]byte(nil), p...),
+	})
+	return len(p), nil
+}
@@ -0,0 +1,76 @@
+package cdsexec_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+func TestCaptureTranscript(t *testing.T) {
+	cmd := cdsexec.CommandContext(context.Background(), "sh", "-c", "echo one; sleep 0.05; echo two >&2; sleep 0.05; echo three")
+	tr := cdsexec.CaptureTranscript(cmd)
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	events := tr.Events()
+	var streams []cdsexec.Stream
+	for i, e := range events {
+		streams = append(streams, e.Stream)
+		if i > 0 && e.Time.Before(events[i-1].Time) {
+			t.Errorf("Expected increasing timestamps, got %v after %v", e.Time, events[i-1].Time)
+		}
+	}
+	expected := []cdsexec.Stream{cdsexec.Stdout, cdsexec.Stderr, cdsexec.Stdout}
+	if !reflect.DeepEqual(streams, expected) {
+		t.Errorf("Expected streams %v, got %v", expected, streams)
+	}
+	if out := string(tr.Output()); out != "one\ntwo\nthree\n" {
+		t.Errorf("Expected interleaved output, got %q", out)
+	}
+	if out := string(tr.Output(cdsexec.Stdout)); out != "one\nthree\n" {
+		t.Errorf("Expected stdout only, got %q", out)
+	}
+}
+
+func TestTranscriptJSON(t *testing.T) {
+	var tr cdsexec.Transcript
+	tr.Writer(cdsexec.Stdout).Write([]byte("ok\n"))
+	tr.Writer(cdsexec.Stderr).Write([]byte{0xff, 0xfe})
+
+	b, err := json.Marshal(&tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw []map[string]any
+	json.Unmarshal(b, &raw)
+	if len(raw) != 2 || raw[0]["stream"] != "stdout" || raw[0]["text"] != "ok\n" || raw[1]["data"] != "//4=" {
+		t.Errorf("Unexpected JSON %s", b)
+	}
+
+	var decoded cdsexec.Transcript
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Output(cdsexec.Stderr), []byte{0xff, 0xfe}) {
+		t.Errorf("Expected the binary stderr back, got %q", decoded.Output(cdsexec.Stderr))
+	}
+	if !decoded.Events()[0].Time.Equal(tr.Events()[0].Time) {
+		t.Error("Expected the timestamps back")
+	}
+}
+
+func TestTranscriptClock(t *testing.T) {
+	clock := mockcmd.NewFakeClock(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	tr := &cdsexec.Transcript{Clock: clock}
+	io.WriteString(tr.Writer(cdsexec.Stderr), "warning\n")
+	if ev := tr.Events(); len(ev) != 1 || !ev[0].Time.Equal(clock.Now()) {
+		t.Errorf("Expected an event timed on the clock, got %+v", ev)
+	}
+}
@@ -0,0 +1,116 @@
+package transformcmd
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"regexp"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// Func transforms output. It may modify b in place and return it.
+type Func func(b []byte) []byte
+
+// Option configures the transformation of the output of the commands.
+type Option func(*config)
+
+type config struct {
+	funcs   []Func
+	cLocale bool
+}
+
+// Transform adds fn to the transforms. Transforms run in the order they were added.
+func Transform(fn Func) Option {
+	return func(c *config) { c.funcs = append(c.funcs, fn) }
+}
+
+// StripANSI removes ANSI escape sequences such as colors and cursor movements.
+func StripANSI() Option {
+	return Transform(stripANSI)
+}
+
+// NormalizeNewlines turns CRLF and lone CR line endings into LF.
+func NormalizeNewlines() Option {
+	return Transform(normalizeNewlines)
+}
+
+// TrimTrailingSpace removes spaces and tabs at the end of every line.
+func TrimTrailingSpace() Option {
+	return Transform(trimTrailingSpace)
+}
+
+// CLocale runs the commands with LC_ALL=C, so that their messages, number formats and
+// sort order are those parsers expect.
+func CLocale() Option {
+	return func(c *config) { c.cLocale = true }
+}
+
+// New returns a CommandConstructor whose commands built by base have the output returned
+// by Output and CombinedOutput transformed, as well as the standard error carried by their
+// errors. Output written to writers set by the caller is left unchanged.
+func New(base cdsexec.CommandConstructor, opts ...Option) cdsexec.CommandConstructor {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		if cfg.cLocale {
+			inv.Spec.Env = cdsexec.MergeEnv(inv.Spec.Env, "LC_ALL=C", "LANG=C")
+		}
+		err := next(inv)
+		if inv.Kind == cdsexec.CallOutput || inv.Kind == cdsexec.CallCombinedOutput {
+			inv.Output = cfg.apply(inv.Output)
+		}
+		var ee *exec.ExitError
+		var xe *cdsexec.ExitError
+		if errors.As(err, &ee) {
+			ee.Stderr = cfg.apply(ee.Stderr)
+		} else if errors.As(err, &xe) {
+			xe.Stderr = cfg.apply(xe.Stderr)
+		}
+		return err
+	})
+}
+
+func (c *config) apply(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	for _, fn := range c.funcs {
+		b = fn(b)
+	}
+	return b
+}
+
+// ansiRE matches CSI sequences, OSC sequences ended by BEL or ST, and two-byte escapes.
+var ansiRE = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+func stripANSI(b []byte) []byte {
+	if bytes.IndexByte(b, 0x1b) < 0 {
+		return b
+	}
+	return ansiRE.ReplaceAll(b, nil)
+}
+
+func normalizeNewlines(b []byte) []byte {
+	if bytes.IndexByte(b, '\r') < 0 {
+		return b
+	}
+	b = bytes.ReplaceAll(b, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(b, []byte("\r"), []byte("\n"))
+}
+
+func trimTrailingSpace(b []byte) []byte {
+	lines := bytes.SplitAfter(b, []byte("\n"))
+	out := b[:0:0]
+	for _, line := range lines {
+		body := bytes.TrimSuffix(line, []byte("\n"))
+		out = append(out, bytes.TrimRight(body, " \t")...)
+		if len(body) < len(line) {
+			out = append(out, '\n')
+		}
+	}
+	return out
+}
@@ -0,0 +1,76 @@
+package transformcmd_test
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+	"github.com/cirrusdata/cdsexec/transformcmd"
+)
+
+func TestTransforms(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []transformcmd.Option
+		output   string
+		expected string
+	}{
+		{
+			name:     "strip ANSI",
+			opts:     []transformcmd.Option{transformcmd.StripANSI()},
+			output:   "\x1b[1;32mONLINE\x1b[0m \x1b]0;title\x07pool\x1b[K\n",
+			expected: "ONLINE pool\n",
+		},
+		{
+			name:     "normalize newlines",
+			opts:     []transformcmd.Option{transformcmd.NormalizeNewlines()},
+			output:   "a\r\nb\rc\n",
+			expected: "a\nb\nc\n",
+		},
+		{
+			name:     "trim trailing space",
+			opts:     []transformcmd.Option{transformcmd.TrimTrailingSpace()},
+			output:   "NAME   \t\nsda  \nsdb",
+			expected: "NAME\nsda\nsdb",
+		},
+		{
+			name: "in order",
+			opts: []transformcmd.Option{
+				transformcmd.StripANSI(),
+				transformcmd.NormalizeNewlines(),
+				transformcmd.TrimTrailingSpace(),
+				transformcmd.Transform(func(b []byte) []byte { return []byte(strings.ToUpper(string(b))) }),
+			},
+			output:   "sda \x1b[31m \x1b[0m\r\n",
+			expected: "SDA\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctor := transformcmd.New(mockcmd.MakeMockCmdWithOutput(tt.output, nil), tt.opts...)
+			out, err := ctor(context.Background(), "multipath", "-ll").Output()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, out)
+			}
+		})
+	}
+}
+
+func TestTransformStderr(t *testing.T) {
+	ctor := transformcmd.New(cdsexec.CommandContext, transformcmd.StripANSI(), transformcmd.CLocale())
+	_, err := ctor(context.Background(), "sh", "-c", `printf '\033[31m%s\033[0m\n' "$LC_ALL" >&2; exit 1`).Output()
+	var ee *exec.ExitError
+	if !errors.As(err, &ee) {
+		t.Fatalf("Expected *exec.ExitError, got %v", err)
+	}
+	if string(ee.Stderr) != "C\n" {
+		t.Errorf("Expected stripped stderr with LC_ALL=C, got %q", ee.Stderr)
+	}
+}
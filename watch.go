@@ -0,0 +1,84 @@
+package cdsexec
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"time"
+)
+
+// WatchOptions configures WatchWith.
+type WatchOptions struct {
+	// Constructor constructs the commands. Nil means CommandContext.
+	Constructor CommandConstructor
+	// Normalize transforms the standard output of each run, in order, before it is compared
+	// with the previous one, e.g. to drop timestamps or counters that change on every run.
+	// onChange is given the normalized output.
+	Normalize []func([]byte) []byte
+	// Initial calls onChange with a nil old output for the output of the first run, which
+	// is otherwise only the baseline later runs are compared with.
+	Initial bool
+	// OnError is called when a run fails. Returning nil ignores the failure, keeping the
+	// output of the last successful run as the baseline; returning an error stops WatchWith
+	// with it. Nil stops WatchWith at the first failure, with its error.
+	OnError func(err error) error
+}
+
+// Watch runs spec every interval until ctx is done and calls onChange each time the
+// standard output of a run differs from that of the previous run. It is WatchWith with the
+// default options.
+func Watch(ctx context.Context, spec CommandSpec, interval time.Duration, onChange func(old, new []byte)) error {
+	return WatchWith(ctx, spec, interval, onChange, WatchOptions{})
+}
+
+// WatchWith runs spec every interval, measured on the Clock of ctx from the end of a run
+// to the start of the next, until ctx is done, and calls onChange each time the standard
+// output of a run, normalized by opts.Normalize, differs from that of the previous run.
+// spec.Stdout is ignored. If spec.Stdin is an io.Seeker, such as a bytes.Reader, it is
+// rewound before each run; any other Stdin is only seen by the first run.
+//
+// It returns the error of ctx once it is done, or the error a run failed with according to
+// opts.OnError. A failed run is a *CommandError quoting its standard error.
+func WatchWith(ctx context.Context, spec CommandSpec, interval time.Duration, onChange func(old, new []byte), opts WatchOptions) error {
+	ctor := opts.Constructor
+	if ctor == nil {
+		ctor = CommandContext
+	}
+	spec.Stdout = nil
+	var last []byte
+	first := true
+	for run := 0; ; run++ {
+		if s, ok := spec.Stdin.(io.Seeker); ok && run > 0 {
+			if _, err := s.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		var r Result
+		err := runSpec(ctx, ctor, spec, &r)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			if opts.OnError == nil {
+				return err
+			}
+			if err := opts.OnError(err); err != nil {
+				return err
+			}
+		} else {
+			out := r.Stdout
+			for _, fn := range opts.Normalize {
+				out = fn(out)
+			}
+			switch {
+			case first && !opts.Initial:
+			case first || !bytes.Equal(last, out):
+				onChange(last, out)
+			}
+			last, first = out, false
+		}
+		if err := Sleep(ctx, interval); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package cdsexec_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+)
+
+func TestWatch(t *testing.T) {
+	outputs := []string{
+		"mpatha (3600a0) dm-0 ts=100\n",
+		"mpatha (3600a0) dm-0 ts=101\n",
+		"", // failure
+		"mpatha (3600a0) dm-0 ts=102\nmpathb (3600a1) dm-1 ts=102\n",
+		"mpathb (3600a1) dm-1 ts=103\n",
+	}
+	run := 0
+	ctor := cdsexec.StreamConstructor(func(ctx context.Context, spec cdsexec.CommandSpec) error {
+		in, _ := io.ReadAll(spec.Stdin)
+		if string(in) != "show" {
+			t.Errorf("Expected the input to be given to run %d, got %q", run, in)
+		}
+		out := outputs[run]
+		run++
+		if out == "" {
+			io.WriteString(spec.Stderr, "multipathd not running")
+			return &cdsexec.ExitError{Code: 1}
+		}
+		io.WriteString(spec.Stdout, out)
+		return nil
+	})
+
+	clock := mockcmd.NewFakeClock(time.Unix(0, 0))
+	ctx, cancel := context.WithCancel(cdsexec.ContextWithClock(context.Background(), clock))
+	defer cancel()
+	ts := regexp.MustCompile(` ts=\d+`)
+	var changes []string
+	var failures []error
+	done := make(chan error)
+	go func() {
+		done <- cdsexec.WatchWith(ctx, cdsexec.CommandSpec{Name: "multipath", Args: []string{"-ll"}, Stdin: strings.NewReader("show")}, time.Second,
+			func(old, new []byte) { changes = append(changes, fmt.Sprintf("%q -> %q", old, new)) },
+			cdsexec.WatchOptions{
+				Constructor: ctor,
+				Normalize:   []func([]byte) []byte{func(b []byte) []byte { return ts.ReplaceAll(b, nil) }},
+				OnError: func(err error) error {
+					failures = append(failures, err)
+					return nil
+				},
+			})
+	}()
+	for i := 1; i < len(outputs); i++ {
+		clock.BlockUntil(1)
+		clock.Advance(time.Second)
+	}
+	clock.BlockUntil(1)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	want := []string{
+		`"mpatha (3600a0) dm-0\n" -> "mpatha (3600a0) dm-0\nmpathb (3600a1) dm-1\n"`,
+		`"mpatha (3600a0) dm-0\nmpathb (3600a1) dm-1\n" -> "mpathb (3600a1) dm-1\n"`,
+	}
+	if strings.Join(changes, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected changes %q, got %q", want, changes)
+	}
+	var ce *cdsexec.CommandError
+	if len(failures) != 1 || !errors.As(failures[0], &ce) || !strings.Contains(ce.Error(), "multipathd not running") {
+		t.Errorf("Expected one *CommandError, got %v", failures)
+	}
+}
+
+func TestWatchStopsOnError(t *testing.T) {
+	ctor := mockcmd.MakeMockCmdWithOutputSpecificError("", &cdsexec.ExitError{Code: 2}, nil)
+	err := cdsexec.WatchWith(context.Background(), cdsexec.CommandSpec{Name: "multipath"}, time.Second,
+		func(old, new []byte) { t.Errorf("Unexpected change") },
+		cdsexec.WatchOptions{Constructor: ctor, Initial: true})
+	if cdsexec.ExitCode(err) != 2 {
+		t.Errorf("Expected the error of the run, got %v", err)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+package wincmd
+
+import "github.com/cirrusdata/cdsexec"
+
+// setRawCmdLine does nothing on platforms that pass argv to programs as is.
+func setRawCmdLine(cdsexec.Commander, string) {}
@@ -0,0 +1,19 @@
+package wincmd
+
+import (
+	"syscall"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// setRawCmdLine makes a local command use line as its command line verbatim.
+func setRawCmdLine(cmd cdsexec.Commander, line string) {
+	c, ok := cmd.(*cdsexec.Cmd)
+	if !ok {
+		return
+	}
+	if c.SysProcAttr == nil {
+		c.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	c.SysProcAttr.CmdLine = line
+}
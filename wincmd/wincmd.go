@@ -0,0 +1,167 @@
+package wincmd
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// PowerShellOptions configures PowerShell invocations.
+type PowerShellOptions struct {
+	// Program is the PowerShell executable. Empty means "powershell.exe"; use "pwsh.exe" for
+	// PowerShell 7.
+	Program string
+}
+
+// PowerShell returns a CommandConstructor that runs every command through PowerShell. The
+// command is rendered with QuotePowerShell and passed with -EncodedCommand, so no further
+// quoting layer is involved. The exit code of a program becomes the exit code of
+// PowerShell, and a cmdlet that fails makes it exit with 1, with the error on stderr. A
+// command PowerShell cannot find makes it exit with cdsexec.ExitCommandNotFound, and the
+// command fails with an error matching cdsexec.ErrNotFound.
+func PowerShell(base cdsexec.CommandConstructor, opts PowerShellOptions) cdsexec.CommandConstructor {
+	program := opts.Program
+	if program == "" {
+		program = "powershell.exe"
+	}
+	run := func(ctx context.Context, name string, arg ...string) cdsexec.Commander {
+		script := powerShellScript(PowerShellCommand(name, arg...))
+		return base(ctx, program, "-NoLogo", "-NoProfile", "-NonInteractive", "-EncodedCommand", EncodePowerShell(script))
+	}
+	return cdsexec.Wrap(run, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		err := next(inv)
+		if cdsexec.ExitCode(err) == cdsexec.ExitCommandNotFound && !errors.Is(err, cdsexec.ErrNotFound) {
+			return fmt.Errorf("wincmd: %s: %w: %w", inv.Spec.Name, cdsexec.ErrNotFound, err)
+		}
+		return err
+	})
+}
+
+// powerShellScript wraps statement so that PowerShell exits with its status: errors of
+// cmdlets are made terminating and caught, and the status of a program, held by
+// $LASTEXITCODE, is checked before $?, which is false for both.
+func powerShellScript(statement string) string {
+	return "$ErrorActionPreference = 'Stop'; " +
+		"try { " + statement + "; $ok = $?; $code = $LASTEXITCODE } " +
+		"catch [System.Management.Automation.CommandNotFoundException] { [Console]::Error.WriteLine($_); exit " + strconv.Itoa(cdsexec.ExitCommandNotFound) + " } " +
+		"catch { [Console]::Error.WriteLine($_); exit 1 }; " +
+		"if ($code) { exit $code }; if (-not $ok) { exit 1 }; exit 0"
+}
+
+// PowerShellCommand renders a PowerShell statement invoking name with args, each passed
+// as a literal string.
+func PowerShellCommand(name string, args ...string) string {
+	var b strings.Builder
+	b.WriteString("& ")
+	b.WriteString(QuotePowerShell(name))
+	for _, a := range args {
+		b.WriteByte(' ')
+		b.WriteString(QuotePowerShell(a))
+	}
+	return b.String()
+}
+
+// QuotePowerShell quotes s as a single-quoted PowerShell string, in which nothing but the
+// quote itself is special. PowerShell also treats typographic single quotes as quotes, so
+// those are doubled as well.
+func QuotePowerShell(s string) string {
+	var b strings.Builder
+	b.WriteByte('\'')
+	for _, r := range s {
+		switch r {
+		case '\'', '‘', '’', '‚', '‛':
+			b.WriteRune(r)
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+// EncodePowerShell encodes script for -EncodedCommand: base64 of its UTF-16LE encoding.
+func EncodePowerShell(script string) string {
+	u := utf16.Encode([]rune(script))
+	b := make([]byte, 2*len(u))
+	for i, c := range u {
+		b[2*i] = byte(c)
+		b[2*i+1] = byte(c >> 8)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// CmdExe returns a CommandConstructor that runs every command through "cmd.exe /d /s /c",
+// with the command line built by CmdLine. When base is cdsexec.CommandContext the raw
+// command line is handed to Windows as is; Go's own argument quoting follows the rules of
+// the C runtime, not those of cmd.exe, and would corrupt it.
+func CmdExe(base cdsexec.CommandConstructor) cdsexec.CommandConstructor {
+	return func(ctx context.Context, name string, arg ...string) cdsexec.Commander {
+		line := CmdLine(name, arg...)
+		cmd := base(ctx, "cmd.exe", "/d", "/s", "/c", `"`+line+`"`)
+		setRawCmdLine(cmd, `cmd.exe /d /s /c "`+line+`"`)
+		return cmd
+	}
+}
+
+// CmdLine renders name and args as a cmd.exe command line. Every argument is quoted as the
+// C runtime expects and the result is escaped with ^ so cmd.exe passes it on literally,
+// including %VAR% references and characters such as & and |.
+func CmdLine(name string, args ...string) string {
+	var b strings.Builder
+	b.WriteString(QuoteCmd(name))
+	for _, a := range args {
+		b.WriteByte(' ')
+		b.WriteString(QuoteCmd(a))
+	}
+	return b.String()
+}
+
+// QuoteCmd quotes s as a single argument of a program started by cmd.exe.
+func QuoteCmd(s string) string {
+	return escapeCmdMeta(QuoteArg(s))
+}
+
+// QuoteArg quotes s as a single argument following the rules of CommandLineToArgvW and the
+// C runtime, which most Windows programs use to split their command line.
+func QuoteArg(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n\v\"") {
+		return s
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	backslashes := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\':
+			backslashes++
+		case '"':
+			b.WriteString(strings.Repeat(`\`, 2*backslashes+1))
+			b.WriteByte('"')
+			backslashes = 0
+		default:
+			b.WriteString(strings.Repeat(`\`, backslashes))
+			b.WriteByte(c)
+			backslashes = 0
+		}
+	}
+	b.WriteString(strings.Repeat(`\`, 2*backslashes))
+	b.WriteByte('"')
+	return b.String()
+}
+
+// escapeCmdMeta prefixes the characters cmd.exe interprets with ^.
+func escapeCmdMeta(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(`()[]%!^"<>&|;, *?`+"`", s[i]) >= 0 {
+			b.WriteByte('^')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
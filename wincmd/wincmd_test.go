@@ -0,0 +1,117 @@
+package wincmd_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+	"github.com/cirrusdata/cdsexec/wincmd"
+)
+
+func TestQuoteArg(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`simple`, `simple`},
+		{``, `""`},
+		{`C:\Program Files\x`, `"C:\Program Files\x"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`trailing\`, `trailing\`},
+		{`with space\`, `"with space\\"`},
+		{`a\"b`, `"a\\\"b"`},
+	}
+	for _, tt := range tests {
+		if got := wincmd.QuoteArg(tt.in); got != tt.want {
+			t.Errorf("QuoteArg(%q): expected %s, got %s", tt.in, tt.want, got)
+		}
+	}
+}
+
+func TestCmdLine(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"diskpart", []string{"/s", `C:\scripts\list.txt`}, `diskpart /s C:\scripts\list.txt`},
+		{"echo", []string{"a & b", "%PATH%"}, `echo ^"a^ ^&^ b^" ^%PATH^%`},
+		{`C:\Program Files\tool.exe`, []string{"x|y"}, `^"C:\Program^ Files\tool.exe^" x^|y`},
+	}
+	for _, tt := range tests {
+		if got := wincmd.CmdLine(tt.name, tt.args...); got != tt.want {
+			t.Errorf("CmdLine(%q, %q): expected %s, got %s", tt.name, tt.args, tt.want, got)
+		}
+	}
+}
+
+func TestQuotePowerShell(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`Get-Disk`, `'Get-Disk'`},
+		{`it's $env:PATH`, `'it''s $env:PATH'`},
+		{"it’s", "'it’’s'"},
+	}
+	for _, tt := range tests {
+		if got := wincmd.QuotePowerShell(tt.in); got != tt.want {
+			t.Errorf("QuotePowerShell(%q): expected %s, got %s", tt.in, tt.want, got)
+		}
+	}
+}
+
+func decode(t *testing.T, enc string) string {
+	t.Helper()
+	b, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = uint16(b[2*i]) | uint16(b[2*i+1])<<8
+	}
+	return string(utf16.Decode(u))
+}
+
+func TestDecorators(t *testing.T) {
+	var got *mockcmd.MockCmd
+	base := mockcmd.MakeMockCmdWithOutput("", func(m *mockcmd.MockCmd) error {
+		got = m
+		return nil
+	})
+
+	wincmd.PowerShell(base, wincmd.PowerShellOptions{})(context.Background(), "Get-Disk", "-Number", "1").Run()
+	if got.Name != "powershell.exe" || len(got.Args) != 5 || got.Args[3] != "-EncodedCommand" {
+		t.Fatalf("Unexpected PowerShell invocation %s %q", got.Name, got.Args)
+	}
+	want := "$ErrorActionPreference = 'Stop'; try { & 'Get-Disk' '-Number' '1'; $ok = $?; $code = $LASTEXITCODE } " +
+		"catch [System.Management.Automation.CommandNotFoundException] { [Console]::Error.WriteLine($_); exit 127 } " +
+		"catch { [Console]::Error.WriteLine($_); exit 1 }; if ($code) { exit $code }; if (-not $ok) { exit 1 }; exit 0"
+	if script := decode(t, got.Args[4]); script != want {
+		t.Errorf("Unexpected script %q", script)
+	}
+
+	wincmd.CmdExe(base)(context.Background(), "echo", "a & b").Run()
+	if line := got.Name + " " + strings.Join(got.Args, " "); line != `cmd.exe /d /s /c "echo ^"a^ ^&^ b^""` {
+		t.Errorf("Unexpected cmd.exe invocation %s", line)
+	}
+}
+
+func TestPowerShellNotFound(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("stands in for PowerShell with sh")
+	}
+	// PowerShell exits with 127 for a command it cannot find.
+	base := func(ctx context.Context, name string, arg ...string) cdsexec.Commander {
+		return cdsexec.CommandContext(ctx, "sh", "-c", "exit 127")
+	}
+	err := wincmd.PowerShell(base, wincmd.PowerShellOptions{})(context.Background(), "Get-Nothing").Run()
+	if !errors.Is(err, cdsexec.ErrNotFound) || cdsexec.ExitCode(err) != 127 {
+		t.Errorf("Expected an error matching ErrNotFound with exit code 127, got %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package winrmcmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/masterzen/winrm"
+)
+
+// AuthMethod selects how clients authenticate to WinRM.
+type AuthMethod int
+
+const (
+	// AuthNTLM authenticates with NTLMv2, the default of domain-joined and workgroup hosts.
+	AuthNTLM AuthMethod = iota
+	// AuthKerberos authenticates with Kerberos, with a password or a credential cache.
+	AuthKerberos
+	// AuthBasic authenticates with HTTP basic authentication, which must be enabled on
+	// the host and should only be used over HTTPS.
+	AuthBasic
+)
+
+// Config describes a WinRM endpoint and its credentials.
+type Config struct {
+	Host string
+	// Port defaults to 5985, or 5986 with HTTPS.
+	Port  int
+	HTTPS bool
+	// Insecure skips the verification of the certificate of the host.
+	Insecure bool
+	// CACert is the PEM-encoded certificate authority verifying the host.
+	CACert []byte
+
+	Auth     AuthMethod
+	User     string
+	Password string
+
+	// Realm is the Kerberos realm of User.
+	Realm string
+	// KrbConfig is the path of the Kerberos configuration. Empty means /etc/krb5.conf.
+	KrbConfig string
+	// SPN overrides the service principal name, by default HTTP/<Host>.
+	SPN string
+	// CCache is the path of a Kerberos credential cache used instead of the password.
+	CCache string
+
+	// Timeout bounds the wait for each HTTP response. Zero means 60 seconds.
+	Timeout time.Duration
+}
+
+// NewClient returns a WinRM client for cfg. It does not connect; connections are made
+// by the commands.
+func NewClient(cfg Config) (*winrm.Client, error) {
+	port := cfg.Port
+	if port == 0 {
+		port = 5985
+		if cfg.HTTPS {
+			port = 5986
+		}
+	}
+	endpoint := winrm.NewEndpoint(cfg.Host, port, cfg.HTTPS, cfg.Insecure, cfg.CACert, nil, nil, cfg.Timeout)
+
+	params := *winrm.DefaultParameters
+	switch cfg.Auth {
+	case AuthNTLM:
+		params.TransportDecorator = func() winrm.Transporter { return &winrm.ClientNTLM{} }
+	case AuthKerberos:
+		krbConfig := cfg.KrbConfig
+		if krbConfig == "" {
+			krbConfig = "/etc/krb5.conf"
+		}
+		proto := "http"
+		if cfg.HTTPS {
+			proto = "https"
+		}
+		params.TransportDecorator = func() winrm.Transporter {
+			return winrm.NewClientKerberos(&winrm.Settings{
+				WinRMUsername: cfg.User,
+				WinRMPassword: cfg.Password,
+				WinRMHost:     cfg.Host,
+				WinRMPort:     port,
+				WinRMProto:    proto,
+				WinRMInsecure: cfg.Insecure,
+				KrbRealm:      cfg.Realm,
+				KrbConfig:     krbConfig,
+				KrbSpn:        cfg.SPN,
+				KrbCCache:     cfg.CCache,
+			})
+		}
+	case AuthBasic:
+	default:
+		return nil, fmt.Errorf("winrmcmd: unknown auth method %d", cfg.Auth)
+	}
+
+	client, err := winrm.NewClientWithParameters(endpoint, cfg.User, cfg.Password, &params)
+	if err != nil {
+		return nil, fmt.Errorf("winrmcmd: %w", err)
+	}
+	return client, nil
+}
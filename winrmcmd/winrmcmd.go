@@ -0,0 +1,62 @@
+package winrmcmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/wincmd"
+	"github.com/masterzen/winrm"
+)
+
+// New returns a CommandConstructor executing commands on the Windows host client is
+// configured for. Every command runs in its own remote shell through cmd.exe, with the
+// command line quoted by wincmd.CmdLine; the directory and environment of the command are
+// applied with cd and set. Non-zero exits are reported as *cdsexec.ExitError, and errors
+// are classified by cdsexec.Classify: a command cmd.exe cannot find, which it reports with
+// exit code ExitCommandNotFound, or a POSIX port exiting with 127, matches
+// cdsexec.ErrNotFound. Cancelling the context terminates the remote command.
+func New(client *winrm.Client) cdsexec.CommandConstructor {
+	return cdsexec.StreamConstructor(func(ctx context.Context, spec cdsexec.CommandSpec) error {
+		stdout, stderr := spec.Stdout, spec.Stderr
+		if stdout == nil {
+			stdout = io.Discard
+		}
+		if stderr == nil {
+			stderr = io.Discard
+		}
+		code, err := client.RunWithContextWithInput(ctx, CommandLine(spec), stdout, stderr, spec.Stdin)
+		if ctx.Err() != nil {
+			return fmt.Errorf("winrmcmd: %w", ctx.Err())
+		}
+		if err != nil {
+			return fmt.Errorf("winrmcmd: %w", err)
+		}
+		if code == ExitCommandNotFound {
+			return fmt.Errorf("winrmcmd: %w: %w", cdsexec.ErrNotFound, &cdsexec.ExitError{Code: code})
+		}
+		if code != 0 {
+			return &cdsexec.ExitError{Code: code}
+		}
+		return nil
+	})
+}
+
+// ExitCommandNotFound is the exit code of cmd.exe for a command it cannot find, which it
+// reports as "not recognized as an internal or external command".
+const ExitCommandNotFound = 9009
+
+// CommandLine returns the cmd.exe command line executing spec.
+func CommandLine(spec cdsexec.CommandSpec) string {
+	var b strings.Builder
+	if spec.Dir != "" {
+		b.WriteString("cd /d " + wincmd.QuoteCmd(spec.Dir) + " && ")
+	}
+	for _, kv := range spec.Env {
+		b.WriteString("set " + wincmd.QuoteCmd(kv) + " && ")
+	}
+	b.WriteString(wincmd.CmdLine(spec.Name, spec.Args...))
+	return b.String()
+}
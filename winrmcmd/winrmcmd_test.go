@@ -0,0 +1,161 @@
+package winrmcmd_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/winrmcmd"
+)
+
+const envelope = `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell"><s:Header><a:Action>%s</a:Action></s:Header><s:Body>%s</s:Body></s:Envelope>`
+
+// reply is the outcome of a command on the fake host.
+type reply struct {
+	stdout, stderr string
+	code           int
+}
+
+// fakeHost is a WinRM endpoint answering commands from a table of command lines.
+type fakeHost struct {
+	replies map[string]reply
+
+	mu    sync.Mutex
+	lines []string
+	stdin string
+}
+
+var (
+	actionPattern  = regexp.MustCompile(`<a:Action[^>]*>([^<]*)</a:Action>`)
+	commandPattern = regexp.MustCompile(`<(?:\w+:)?Command>(?:<!\[CDATA\[)?(.*?)(?:\]\]>)?</`)
+	streamPattern  = regexp.MustCompile(`<(?:\w+:)?Stream[^>]*>([^<]*)</`)
+)
+
+func (h *fakeHost) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	action := actionPattern.FindSubmatch(body)
+	w.Header().Set("Content-Type", "application/soap+xml;charset=UTF-8")
+	if action == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var resp string
+	switch a := string(action[1]); {
+	case strings.HasSuffix(a, "transfer/Create"):
+		resp = fmt.Sprintf(envelope, "http://schemas.xmlsoap.org/ws/2004/09/transfer/CreateResponse",
+			`<rsp:Shell><rsp:ShellId>SHELL</rsp:ShellId></rsp:Shell>`)
+	case strings.HasSuffix(a, "shell/Command"):
+		h.lines = append(h.lines, string(commandPattern.FindSubmatch(body)[1]))
+		resp = fmt.Sprintf(envelope, "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/CommandResponse",
+			`<rsp:CommandResponse><rsp:CommandId>CMD</rsp:CommandId></rsp:CommandResponse>`)
+	case strings.HasSuffix(a, "shell/Send"):
+		if m := streamPattern.FindSubmatch(body); m != nil {
+			data, _ := base64.StdEncoding.DecodeString(string(m[1]))
+			h.stdin += string(data)
+		}
+		resp = fmt.Sprintf(envelope, "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/SendResponse", "")
+	case strings.HasSuffix(a, "shell/Receive"):
+		rep := h.replies[h.lines[len(h.lines)-1]]
+		resp = fmt.Sprintf(envelope, "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/ReceiveResponse",
+			`<rsp:ReceiveResponse>`+
+				`<rsp:Stream Name="stdout" CommandId="CMD">`+base64.StdEncoding.EncodeToString([]byte(rep.stdout))+`</rsp:Stream>`+
+				`<rsp:Stream Name="stderr" CommandId="CMD">`+base64.StdEncoding.EncodeToString([]byte(rep.stderr))+`</rsp:Stream>`+
+				`<rsp:CommandState CommandId="CMD" State="http://schemas.microsoft.com/wbem/wsman/1/windows/shell/CommandState/Done">`+
+				`<rsp:ExitCode>`+strconv.Itoa(rep.code)+`</rsp:ExitCode></rsp:CommandState></rsp:ReceiveResponse>`)
+	default:
+		resp = fmt.Sprintf(envelope, a+"Response", "")
+	}
+	io.WriteString(w, resp)
+}
+
+func newClient(t *testing.T, h *fakeHost) cdsexec.CommandConstructor {
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	host, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	client, err := winrmcmd.NewClient(winrmcmd.Config{Host: host, Port: p, Auth: winrmcmd.AuthBasic, User: "Administrator", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return winrmcmd.New(client)
+}
+
+func TestWinRM(t *testing.T) {
+	h := &fakeHost{replies: map[string]reply{
+		`hostname`:                   {stdout: "WIN-INITIATOR\r\n"},
+		`iscsicli ListTargets`:       {stderr: "The service is not running.\r\n", code: 1060},
+		`cd /d C:\data && findstr x`: {stdout: "x\r\n"},
+		`cdsexec-missing-tool`:       {stderr: "'cdsexec-missing-tool' is not recognized as an internal or external command\r\n", code: winrmcmd.ExitCommandNotFound},
+		`bash -c missing`:            {code: cdsexec.ExitCommandNotFound},
+	}}
+	ctor := newClient(t, h)
+
+	out, err := ctor(context.Background(), "hostname").Output()
+	if err != nil || string(out) != "WIN-INITIATOR\r\n" {
+		t.Errorf("Expected %q, got %q, %v", "WIN-INITIATOR\r\n", out, err)
+	}
+
+	_, err = ctor(context.Background(), "iscsicli", "ListTargets").Output()
+	if cdsexec.ExitCode(err) != 1060 {
+		t.Errorf("Expected exit code 1060, got %v", err)
+	}
+	var xe *cdsexec.ExitError
+	if !errors.As(err, &xe) || string(xe.Stderr) != "The service is not running.\r\n" {
+		t.Errorf("Expected stderr attached to the error, got %v", err)
+	}
+
+	cmd := ctor(context.Background(), "findstr", "x")
+	cmd.SetDir(`C:\data`)
+	cmd.SetStdin(strings.NewReader("x\r\ny\r\n"))
+	if out, err := cmd.Output(); err != nil || string(out) != "x\r\n" {
+		t.Errorf("Expected %q, got %q, %v", "x\r\n", out, err)
+	}
+	if h.stdin != "x\r\ny\r\n" {
+		t.Errorf("Expected stdin to be sent, got %q", h.stdin)
+	}
+
+	for _, line := range [][]string{{"cdsexec-missing-tool"}, {"bash", "-c", "missing"}} {
+		err := ctor(context.Background(), line[0], line[1:]...).Run()
+		if !errors.Is(err, cdsexec.ErrNotFound) || cdsexec.ExitCode(err) <= 0 {
+			t.Errorf("%s: Expected ErrNotFound, got %v", line[0], err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := ctor(ctx, "hostname").Run(); !errors.Is(err, cdsexec.ErrKilledByContext) || !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected ErrKilledByContext, got %v", err)
+	}
+}
+
+func TestCommandLine(t *testing.T) {
+	tests := []struct {
+		spec     cdsexec.CommandSpec
+		expected string
+	}{
+		{cdsexec.CommandSpec{Name: "ipconfig", Args: []string{"/all"}}, `ipconfig /all`},
+		{cdsexec.CommandSpec{Name: "echo", Args: []string{"a&b"}}, `echo a^&b`},
+		{cdsexec.CommandSpec{Name: "dir", Dir: `C:\Program Files`}, `cd /d ^"C:\Program^ Files^" && dir`},
+		{cdsexec.CommandSpec{Name: "app", Env: []string{"MODE=fast"}}, `set MODE=fast && app`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			if got := winrmcmd.CommandLine(tt.spec); got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package wslcmd
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/cirrusdata/cdsexec"
+)
+
+// Options configures how commands are run in WSL.
+type Options struct {
+	// Distro is the distribution to run commands in. Empty means the default distribution.
+	Distro string
+	// User is the Linux user to run commands as. Empty means the default user of the distribution.
+	User string
+	// MountRoot is where Windows drives are mounted in the distribution. Empty means "/mnt/".
+	MountRoot string
+	// Program is the WSL launcher. Empty means "wsl.exe".
+	Program string
+}
+
+func (o Options) program() string {
+	if o.Program == "" {
+		return "wsl.exe"
+	}
+	return o.Program
+}
+
+func (o Options) mountRoot() string {
+	if o.MountRoot == "" {
+		return "/mnt/"
+	}
+	return o.MountRoot
+}
+
+// New returns a CommandConstructor that runs every command built by base in WSL through
+// "wsl.exe --exec", so no Linux shell is involved. Windows absolute paths in arguments
+// and the working directory are translated to their mount points, variables of an
+// explicitly set environment are forwarded through WSLENV, and output wsl.exe writes in
+// UTF-16, such as its own error messages, is converted to UTF-8 for Output,
+// CombinedOutput and the stderr of exit errors.
+func New(base cdsexec.CommandConstructor, opts Options) cdsexec.CommandConstructor {
+	return cdsexec.Wrap(base, func(inv *cdsexec.Invocation, next cdsexec.Handler) error {
+		var args []string
+		if opts.Distro != "" {
+			args = append(args, "-d", opts.Distro)
+		}
+		if opts.User != "" {
+			args = append(args, "-u", opts.User)
+		}
+		if inv.Spec.Dir != "" {
+			dir, _ := TranslatePath(inv.Spec.Dir, opts.mountRoot())
+			args = append(args, "--cd", dir)
+			inv.Spec.Dir = ""
+		}
+		args = append(args, "--exec", inv.Spec.Name)
+		for _, a := range inv.Spec.Args {
+			a, _ = TranslatePath(a, opts.mountRoot())
+			args = append(args, a)
+		}
+		inv.Spec.Name = opts.program()
+		inv.Spec.Args = args
+		if inv.Spec.Env != nil {
+			inv.Spec.Env = forwardEnv(inv.Spec.Env)
+		}
+
+		err := next(inv)
+		inv.Output = ToUTF8(inv.Output)
+		var ee *exec.ExitError
+		if errors.As(err, &ee) {
+			ee.Stderr = ToUTF8(ee.Stderr)
+		}
+		return err
+	})
+}
+
+// TranslatePath returns the path under mountRoot of a Windows absolute path such as
+// C:\Users\admin, and reports whether p was translated. Other strings are returned unchanged.
+func TranslatePath(p, mountRoot string) (string, bool) {
+	if len(p) < 3 || p[1] != ':' || (p[2] != '\\' && p[2] != '/') {
+		return p, false
+	}
+	drive := p[0] | 0x20
+	if drive < 'a' || drive > 'z' {
+		return p, false
+	}
+	rest := strings.ReplaceAll(p[3:], `\`, "/")
+	return strings.TrimSuffix(mountRoot, "/") + "/" + string(drive) + "/" + rest, true
+}
+
+// forwardEnv adds the variables of env to WSLENV so wsl.exe passes them on. PATH is left
+// out since WSL builds the Linux PATH itself.
+func forwardEnv(env []string) []string {
+	var names []string
+	existing, _ := cdsexec.LookupEnv(env, "WSLENV")
+	if existing != "" {
+		names = append(names, existing)
+	}
+	for _, kv := range env {
+		key, _, _ := strings.Cut(kv, "=")
+		if key == "" || strings.EqualFold(key, "PATH") || key == "WSLENV" {
+			continue
+		}
+		names = append(names, key)
+	}
+	if len(names) == 0 {
+		return env
+	}
+	return cdsexec.MergeEnv(env, "WSLENV="+strings.Join(names, ":"))
+}
+
+// ToUTF8 converts b to UTF-8 if it is UTF-16LE, as written by wsl.exe itself, and returns
+// it unchanged otherwise. UTF-16LE is recognised by its byte order mark or by text whose
+// every second byte is zero.
+func ToUTF8(b []byte) []byte {
+	switch {
+	case len(b) >= 2 && b[0] == 0xff && b[1] == 0xfe:
+		b = b[2:]
+	case len(b) >= 2 && len(b)%2 == 0 && looksUTF16(b):
+	default:
+		return b
+	}
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = uint16(b[2*i]) | uint16(b[2*i+1])<<8
+	}
+	return []byte(string(utf16.Decode(u)))
+}
+
+// looksUTF16 reports whether b looks like mostly-ASCII UTF-16LE text.
+func looksUTF16(b []byte) bool {
+	for i := 0; i < len(b); i += 2 {
+		if b[i] == 0 || b[i+1] != 0 {
+			return false
+		}
+	}
+	return true
+}
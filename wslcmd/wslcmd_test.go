@@ -0,0 +1,92 @@
+package wslcmd_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/cirrusdata/cdsexec"
+	"github.com/cirrusdata/cdsexec/mockcmd"
+	"github.com/cirrusdata/cdsexec/wslcmd"
+)
+
+func utf16le(s string, bom bool) string {
+	var b []byte
+	if bom {
+		b = append(b, 0xff, 0xfe)
+	}
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(u), byte(u>>8))
+	}
+	return string(b)
+}
+
+func TestCommandLine(t *testing.T) {
+	var got *mockcmd.MockCmd
+	base := mockcmd.MakeMockCmdWithOutput("", func(m *mockcmd.MockCmd) error {
+		got = m
+		return nil
+	})
+
+	tests := []struct {
+		name    string
+		opts    wslcmd.Options
+		cmd     []string
+		dir     string
+		env     []string
+		want    string
+		wantEnv string
+	}{
+		{"default distro", wslcmd.Options{}, []string{"lsblk", "-J"}, "", nil, "wsl.exe --exec lsblk -J", ""},
+		{"distro and user", wslcmd.Options{Distro: "Ubuntu", User: "root"}, []string{"mdadm", "--detail", "/dev/md0"}, "", nil,
+			"wsl.exe -d Ubuntu -u root --exec mdadm --detail /dev/md0", ""},
+		{"paths", wslcmd.Options{}, []string{"qemu-img", "info", `D:\images\disk.qcow2`, "C:/Temp/x"}, `C:\Users\admin`, nil,
+			"wsl.exe --cd /mnt/c/Users/admin --exec qemu-img info /mnt/d/images/disk.qcow2 /mnt/c/Temp/x", ""},
+		{"mount root", wslcmd.Options{MountRoot: "/"}, []string{"ls", `E:\`}, "", nil, "wsl.exe --exec ls /e/", ""},
+		{"env", wslcmd.Options{}, []string{"env"}, "", []string{"PATH=C:\\Windows", "NODE=n1", "WSLENV=USERPROFILE/p"}, "wsl.exe --exec env", "USERPROFILE/p:NODE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := wslcmd.New(base, tt.opts)(context.Background(), tt.cmd[0], tt.cmd[1:]...)
+			cmd.SetDir(tt.dir)
+			cmd.SetEnv(tt.env)
+			if err := cmd.Run(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if line := (cdsexec.CommandSpec{Name: got.Name, Args: got.Args}).String(); line != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, line)
+			}
+			if got.Dir != "" {
+				t.Errorf("Expected the directory to be passed to wsl.exe, got %q", got.Dir)
+			}
+			if v, _ := cdsexec.LookupEnv(got.Env, "WSLENV"); tt.env != nil && v != tt.wantEnv {
+				t.Errorf("Expected WSLENV=%q, got %q", tt.wantEnv, v)
+			}
+		})
+	}
+}
+
+func TestOutputConversion(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want string
+	}{
+		{"utf-8 passes through", "sda  8:0\n", "sda  8:0\n"},
+		{"utf-16 with bom", utf16le("There is no distribution with the supplied name.\r\n", true), "There is no distribution with the supplied name.\r\n"},
+		{"utf-16 without bom", utf16le("Ubuntu\r\n", false), "Ubuntu\r\n"},
+		{"non-ascii utf-8", "größe", "größe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := wslcmd.New(mockcmd.MakeMockCmdWithOutput(tt.out, nil), wslcmd.Options{})(context.Background(), "lsblk").Output()
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if string(out) != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, strings.ToValidUTF8(string(out), "?"))
+			}
+		})
+	}
+}